@@ -0,0 +1,155 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	dsiErrors "github.com/machinable/machinable/dsi/errors"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// CodeValidationFailed is the code of errors with field level details
+const CodeValidationFailed = "VALIDATION_FAILED"
+
+// ProblemContentType is the media type of RFC 7807 problem details, errors are served as problem details if the
+// request accepts it
+const ProblemContentType = "application/problem+json"
+
+// Detail is a field level error
+type Detail struct {
+	Field   string `json:"field,omitempty"` // Field is the slash separated path of the offending field, if known
+	Message string `json:"message"`
+}
+
+// Error is the body of every error response of the API
+type Error struct {
+	Status    int       `json:"-"`
+	Code      string    `json:"code"`    // Code is the machine readable error code, i.e. `NOT_FOUND`
+	Message   string    `json:"message"` // Message is the human readable description of the error
+	Details   []*Detail `json:"details,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// New returns a pointer to a new `Error` with the code of the HTTP status
+func New(status int, message string) *Error {
+	return &Error{
+		Status:  status,
+		Code:    StatusCode(status),
+		Message: message,
+	}
+}
+
+// FromDatastore returns the `Error` of a datastore error
+func FromDatastore(err *dsiErrors.DatastoreError) *Error {
+	return New(err.Code(), err.Error())
+}
+
+// FromTranslated returns the `Error` of a translated database error, schema validation errors include the offending
+// fields as details
+func FromTranslated(err *models.TranslatedError) *Error {
+	apiErr := New(err.Code, err.Error())
+	if vErr, ok := err.Err.(*models.SchemaValidationError); ok {
+		details := make([]*Detail, 0, len(vErr.Errors))
+		for _, field := range vErr.Errors {
+			details = append(details, &Detail{Field: field.Path, Message: field.Message})
+		}
+		apiErr.WithDetails(details...)
+	}
+	return apiErr
+}
+
+// Error returns the message of the error (in order to implement the built-in error interface)
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// WithCode overrides the code derived from the HTTP status
+func (e *Error) WithCode(code string) *Error {
+	e.Code = code
+	return e
+}
+
+// WithDetails adds field level details, the code becomes `VALIDATION_FAILED`
+func (e *Error) WithDetails(details ...*Detail) *Error {
+	if len(details) == 0 {
+		return e
+	}
+	e.Details = append(e.Details, details...)
+	e.Code = CodeValidationFailed
+	return e
+}
+
+// StatusCode returns the error code of an HTTP status, i.e. `TOO_MANY_REQUESTS` for 429
+func StatusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "UNKNOWN"
+	}
+	return strings.ToUpper(strings.Replace(strings.Replace(text, "-", "_", -1), " ", "_", -1))
+}
+
+// MessageDetails returns the details of schema validation messages, i.e. "title in body is required"
+func MessageDetails(messages []string) []*Detail {
+	details := make([]*Detail, 0, len(messages))
+	for _, message := range messages {
+		message = strings.TrimSpace(message)
+		if message == "" {
+			continue
+		}
+		detail := &Detail{Message: message}
+		if i := strings.Index(message, " in body "); i > 0 {
+			detail.Field = "/" + strings.Replace(message[:i], ".", "/", -1)
+			detail.Message = message[i+len(" in body "):]
+		}
+		details = append(details, detail)
+	}
+	return details
+}
+
+// Respond writes an error response with the message and aborts the request
+func Respond(c *gin.Context, status int, message string) {
+	RespondError(c, New(status, message))
+}
+
+// RespondError writes the error response and aborts the request. The body is RFC 7807 problem details if the request
+// accepts `application/problem+json`, otherwise the `Error` with the message repeated as `error` for older clients.
+func RespondError(c *gin.Context, err *Error) {
+	err.RequestID = c.GetString("requestId")
+
+	if strings.Contains(c.GetHeader("Accept"), ProblemContentType) {
+		body, _ := json.Marshal(problem(c, err))
+		c.Data(err.Status, ProblemContentType, body)
+		c.Abort()
+		return
+	}
+
+	c.AbortWithStatusJSON(err.Status, &struct {
+		*Error
+		Legacy string `json:"error"`
+	}{
+		Error:  err,
+		Legacy: err.Message,
+	})
+}
+
+// problem returns the RFC 7807 problem details of the error, the code, details and request id are extension members
+func problem(c *gin.Context, err *Error) interface{} {
+	return &struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail"`
+		Instance string `json:"instance"`
+		*Error
+		Message string `json:"message,omitempty"` // hides the message of the error, it is the detail
+	}{
+		Type:     "about:blank",
+		Title:    http.StatusText(err.Status),
+		Status:   err.Status,
+		Detail:   err.Message,
+		Instance: c.Request.URL.Path,
+		Error:    err,
+	}
+}
@@ -0,0 +1,99 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	dsiErrors "github.com/machinable/machinable/dsi/errors"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func respond(accept string, err *Error) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/posts", func(c *gin.Context) {
+		c.Set("requestId", "abc123")
+		RespondError(c, err)
+	}, func(c *gin.Context) {
+		// the request is aborted
+		c.JSON(http.StatusOK, gin.H{})
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/posts", nil)
+	req.Header.Set("Accept", accept)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRespondError(t *testing.T) {
+	tables := []struct {
+		name        string
+		accept      string
+		err         *Error
+		contentType string
+		body        string
+	}{
+		{
+			"message",
+			"application/json",
+			New(http.StatusNotFound, "document not found"),
+			"application/json; charset=utf-8",
+			`{"code":"NOT_FOUND","message":"document not found","request_id":"abc123","error":"document not found"}`,
+		},
+		{
+			"details",
+			"*/*",
+			New(http.StatusBadRequest, "failed to save posts").WithDetails(&Detail{Field: "/title", Message: "is required"}),
+			"application/json; charset=utf-8",
+			`{"code":"VALIDATION_FAILED","message":"failed to save posts","details":[{"field":"/title","message":"is required"}],"request_id":"abc123","error":"failed to save posts"}`,
+		},
+		{
+			"problem details",
+			"application/problem+json, application/json",
+			New(http.StatusTooManyRequests, "request limit exceeded"),
+			ProblemContentType,
+			`{"type":"about:blank","title":"Too Many Requests","status":429,"detail":"request limit exceeded","instance":"/api/posts","code":"TOO_MANY_REQUESTS","request_id":"abc123"}`,
+		},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			w := respond(tt.accept, tt.err)
+			assert.Equal(t, tt.err.Status, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			assert.JSONEq(t, tt.body, w.Body.String())
+		})
+	}
+}
+
+func TestFromErrors(t *testing.T) {
+	dErr := FromDatastore(dsiErrors.New(dsiErrors.BadParameter, errors.New("invalid ttl")))
+	assert.Equal(t, &Error{Status: http.StatusBadRequest, Code: "BAD_REQUEST", Message: "invalid ttl"}, dErr)
+
+	vErr := &models.SchemaValidationError{Errors: []*models.SchemaFieldError{{Path: "/posts/0", Message: "must be of type object"}}}
+	tErr := FromTranslated(models.NewTranslatedError(http.StatusBadRequest, vErr))
+	assert.Equal(t, CodeValidationFailed, tErr.Code)
+	assert.Equal(t, []*Detail{{Field: "/posts/0", Message: "must be of type object"}}, tErr.Details)
+
+	assert.Equal(t, "INTERNAL_SERVER_ERROR", FromTranslated(models.NewTranslatedError(http.StatusInternalServerError, errors.New("internal server error"))).Code)
+}
+
+func TestMessageDetails(t *testing.T) {
+	details := MessageDetails([]string{"title in body is required", " meta.draft in body must be of type boolean: \"string\"", "tags field is not defined in the schema", ""})
+	assert.Equal(t, []*Detail{
+		{Field: "/title", Message: "is required"},
+		{Field: "/meta/draft", Message: "must be of type boolean: \"string\""},
+		{Message: "tags field is not defined in the schema"},
+	}, details)
+}
+
+func TestStatusCode(t *testing.T) {
+	assert.Equal(t, "UNAUTHORIZED", StatusCode(http.StatusUnauthorized))
+	assert.Equal(t, "NON_AUTHORITATIVE_INFORMATION", StatusCode(http.StatusNonAuthoritativeInfo))
+	assert.Equal(t, "UNKNOWN", StatusCode(599))
+}
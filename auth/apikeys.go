@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/machinable/machinable/config"
+)
+
+const (
+	// APIKeyPrefix identifies machinable API keys, e.g. in secret scanners and logs
+	APIKeyPrefix = "mch"
+	// apiKeyIDBytes is the size of the key ID, which is embedded in the key so it can be looked up before hashing
+	apiKeyIDBytes = 6
+	// apiKeySecretBytes is the size of the random part of the key
+	apiKeySecretBytes = 24
+)
+
+// GenerateAPIKey returns a new API key of the form `mch_<key id>_<secret>` and its key ID
+func GenerateAPIKey() (string, string, error) {
+	id := make([]byte, apiKeyIDBytes)
+	secret := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+
+	keyID := hex.EncodeToString(id)
+	return fmt.Sprintf("%s_%s_%s", APIKeyPrefix, keyID, hex.EncodeToString(secret)), keyID, nil
+}
+
+// ParseAPIKey returns the key ID of a prefixed API key, false is returned for keys without a valid prefix and key ID,
+// i.e. legacy UUID keys
+func ParseAPIKey(key string) (string, bool) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != APIKeyPrefix {
+		return "", false
+	}
+	if len(parts[1]) != apiKeyIDBytes*2 || len(parts[2]) != apiKeySecretBytes*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// ErrNoAPIKeySecret is returned if API keys are hashed without an API key secret
+var ErrNoAPIKeySecret = errors.New("an api key secret is required, set API_KEY_SECRET")
+
+// APIKeySecret is the secret API keys are hashed with. It is required, so the hashes of keys don't depend on which
+// secrets happened to be configured when they were created.
+func APIKeySecret(config *config.AppConfig) (string, error) {
+	if config.APIKeySecret == "" {
+		return "", ErrNoAPIKeySecret
+	}
+	return config.APIKeySecret, nil
+}
+
+// HashAPIKey returns the hex encoded HMAC-SHA256 of the API key
+func HashAPIKey(key, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CompareAPIKeyHash compares the hash to the API key in constant time
+func CompareAPIKeyHash(hash, key, secret string) bool {
+	return hmac.Equal([]byte(hash), []byte(HashAPIKey(key, secret)))
+}
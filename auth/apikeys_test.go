@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/machinable/machinable/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeys(t *testing.T) {
+	key, keyID, err := GenerateAPIKey()
+	assert.Nil(t, err)
+	assert.Regexp(t, `^mch_[0-9a-f]{12}_[0-9a-f]{48}$`, key)
+
+	parsed, ok := ParseAPIKey(key)
+	assert.True(t, ok)
+	assert.Equal(t, keyID, parsed)
+
+	tables := []struct {
+		name string
+		key  string
+	}{
+		{"legacy uuid", "1b7dfd69-bd3f-46c7-ac94-10bbdec1d96b"},
+		{"prefix", "sk_4f2a9c1b7d3e_9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e"},
+		{"key id", "mch_4f2a9c1b_9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e"},
+		{"hex", "mch_4f2a9c1b7d3z_9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e"},
+	}
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := ParseAPIKey(tt.key)
+			assert.False(t, ok)
+		})
+	}
+
+	hash := HashAPIKey(key, "secret")
+	assert.Len(t, hash, 64)
+	assert.True(t, CompareAPIKeyHash(hash, key, "secret"))
+	assert.False(t, CompareAPIKeyHash(hash, key, "other secret"))
+
+	// keys are only hashed with the dedicated secret
+	_, err = APIKeySecret(&config.AppConfig{AppSecret: "app"})
+	assert.Equal(t, ErrNoAPIKeySecret, err, "the app secret is not used for api keys")
+	secret, err := APIKeySecret(&config.AppConfig{AppSecret: "app", APIKeySecret: "keys"})
+	assert.Nil(t, err)
+	assert.Equal(t, "keys", secret)
+}
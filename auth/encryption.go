@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/machinable/machinable/config"
+)
+
+const (
+	// encryptedPrefix marks secrets encrypted with the key encryption key, the version allows the format to change
+	encryptedPrefix = "enc:v1:"
+	// keyEncryptionKeyBytes is the size of the AES-256 key encryption key
+	keyEncryptionKeyBytes = 32
+)
+
+var (
+	// ErrNoKeyEncryptionKey is returned if secrets are encrypted or decrypted without a key encryption key
+	ErrNoKeyEncryptionKey = errors.New("a key encryption key is required, set KEY_ENCRYPTION_KEY")
+	errInvalidEncrypted   = errors.New("invalid encrypted secret")
+)
+
+// KeyEncryptionKey decodes the key encryption key of the config, a base64 encoded 32 byte key
+func KeyEncryptionKey(config *config.AppConfig) ([]byte, error) {
+	if config.KeyEncryptionKey == "" {
+		return nil, ErrNoKeyEncryptionKey
+	}
+	kek, err := base64.StdEncoding.DecodeString(config.KeyEncryptionKey)
+	if err != nil || len(kek) != keyEncryptionKeyBytes {
+		return nil, errors.New("the key encryption key must be 32 base64 encoded bytes")
+	}
+	return kek, nil
+}
+
+// EncryptSecret encrypts a secret which is stored in the database with AES-256-GCM. The context, i.e. the ID of the
+// row, is authenticated so the encrypted secret can not be copied to another row.
+func EncryptSecret(kek []byte, secret, context string) (string, error) {
+	aead, err := secretCipher(kek)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(secret), []byte(context))
+	return encryptedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret decrypts a secret encrypted by `EncryptSecret` with the same context
+func DecryptSecret(kek []byte, encrypted, context string) (string, error) {
+	if !Encrypted(encrypted) {
+		return "", errInvalidEncrypted
+	}
+	aead, err := secretCipher(kek)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(encrypted, encryptedPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errInvalidEncrypted
+	}
+
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(context))
+	if err != nil {
+		return "", errInvalidEncrypted
+	}
+	return string(secret), nil
+}
+
+// Encrypted returns true if the secret was encrypted by `EncryptSecret`, secrets stored before they were encrypted are
+// plaintext
+func Encrypted(secret string) bool {
+	return strings.HasPrefix(secret, encryptedPrefix)
+}
+
+func secretCipher(kek []byte) (cipher.AEAD, error) {
+	if len(kek) == 0 {
+		return nil, ErrNoKeyEncryptionKey
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
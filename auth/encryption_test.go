@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/machinable/machinable/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyEncryptionKey(t *testing.T) {
+	_, err := KeyEncryptionKey(&config.AppConfig{})
+	assert.Equal(t, ErrNoKeyEncryptionKey, err)
+
+	_, err = KeyEncryptionKey(&config.AppConfig{KeyEncryptionKey: "c2hvcnQ="})
+	assert.NotNil(t, err, "keys must be 32 bytes")
+
+	kek, err := KeyEncryptionKey(&config.AppConfig{KeyEncryptionKey: testKeyEncryptionKey})
+	assert.Nil(t, err)
+	assert.Len(t, kek, 32)
+}
+
+func TestEncryptSecret(t *testing.T) {
+	kek := testKEK(t)
+
+	encrypted, err := EncryptSecret(kek, "secret", "row")
+	assert.Nil(t, err)
+	assert.True(t, Encrypted(encrypted))
+	assert.NotContains(t, encrypted, "secret")
+
+	// the nonce is random
+	again, _ := EncryptSecret(kek, "secret", "row")
+	assert.NotEqual(t, encrypted, again)
+
+	decrypted, err := DecryptSecret(kek, encrypted, "row")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", decrypted)
+
+	_, err = DecryptSecret(kek, encrypted, "other row")
+	assert.NotNil(t, err)
+	_, err = DecryptSecret(make([]byte, 32), encrypted, "row")
+	assert.NotNil(t, err)
+	_, err = DecryptSecret(kek, "secret", "row")
+	assert.NotNil(t, err)
+	_, err = EncryptSecret(nil, "secret", "row")
+	assert.Equal(t, ErrNoKeyEncryptionKey, err)
+}
@@ -1,66 +1,154 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/machinable/machinable/config"
+	"github.com/machinable/machinable/dsi/interfaces"
 )
 
 const (
-	// AccessTokenExpiry is how long access tokens last.
-	AccessTokenExpiry = 15 // 15 minutes
-	// RefreshTokenExpiry is the period of time that refresh tokens are valid.
-	RefreshTokenExpiry = 60 * 24 * 3 // 3 days
+	// DefaultAccessTokenLifetime is how long access tokens last, unless the project configures a lifetime.
+	DefaultAccessTokenLifetime = 15 * time.Minute
+	// DefaultRefreshTokenLifetime is how long a session can be refreshed, unless the project configures a lifetime.
+	DefaultRefreshTokenLifetime = 3 * 24 * time.Hour
+	// MaxAccessTokenLifetime is the longest access token lifetime a project can configure.
+	MaxAccessTokenLifetime = 24 * time.Hour
+	// MaxRefreshTokenLifetime is the longest refresh token lifetime a project can configure.
+	MaxRefreshTokenLifetime = 90 * 24 * time.Hour
 )
 
-// JWT wraps functions need to create and parse JWTs
+// JWTAlgorithm returns the algorithm of new signing keys, RS256 if none is configured
+func JWTAlgorithm(config *config.AppConfig) (string, error) {
+	switch config.JWTAlgorithm {
+	case "":
+		return AlgorithmRS256, nil
+	case AlgorithmRS256, AlgorithmES256:
+		return config.JWTAlgorithm, nil
+	}
+	return "", fmt.Errorf("unsupported JWT algorithm '%s', set JWT_ALGORITHM to %s or %s", config.JWTAlgorithm, AlgorithmRS256, AlgorithmES256)
+}
+
+// JWT wraps functions need to create and parse JWTs. Tokens are signed with the asymmetric keys of the project, or of
+// application users if the JWT is not scoped to a project.
 type JWT struct {
-	config *config.AppConfig
+	config    *config.AppConfig
+	keys      *KeyManager
+	projectID string
+
+	accessLifetime  time.Duration
+	refreshLifetime time.Duration
 }
 
-// NewJWT creates and returns a pointer to a new `JWT`
-func NewJWT(config *config.AppConfig) *JWT {
+// NewJWT creates and returns a pointer to a new `JWT` for application user tokens
+func NewJWT(config *config.AppConfig, store interfaces.SigningKeysDatastore) *JWT {
+	rotation := time.Duration(config.JWTKeyRotationDays) * 24 * time.Hour
+	// keys can't be created or decrypted without a valid key encryption key, the algorithm and key encryption key are
+	// checked on startup
+	algorithm, _ := JWTAlgorithm(config)
+	kek, _ := KeyEncryptionKey(config)
 	return &JWT{
-		config: config,
+		config:          config,
+		keys:            NewKeyManager(store, algorithm, rotation, kek),
+		accessLifetime:  DefaultAccessTokenLifetime,
+		refreshLifetime: DefaultRefreshTokenLifetime,
 	}
 }
 
-// TokenLookup returns an error if the JWT is invalid.
+// Project returns a `JWT` which signs and verifies the tokens of the project
+func (j *JWT) Project(projectID string) *JWT {
+	return &JWT{
+		config:          j.config,
+		keys:            j.keys,
+		projectID:       projectID,
+		accessLifetime:  j.accessLifetime,
+		refreshLifetime: j.refreshLifetime,
+	}
+}
+
+// Lifetimes returns a `JWT` which creates tokens with the lifetimes in minutes, zero keeps the default lifetime
+func (j *JWT) Lifetimes(accessMinutes, refreshMinutes int) *JWT {
+	lifetimes := *j
+	if accessMinutes > 0 {
+		lifetimes.accessLifetime = time.Duration(accessMinutes) * time.Minute
+	}
+	if refreshMinutes > 0 {
+		lifetimes.refreshLifetime = time.Duration(refreshMinutes) * time.Minute
+	}
+	return &lifetimes
+}
+
+// RefreshLifetime is how long a session can be refreshed after it is created or refreshed
+func (j *JWT) RefreshLifetime() time.Duration {
+	return j.refreshLifetime
+}
+
+// JWKS returns the public keys which verify the tokens of the project
+func (j *JWT) JWKS() (*JWKS, error) {
+	return j.keys.JWKS(j.projectID)
+}
+
+// TokenLookup returns the key which verifies the JWT, an error is returned if the JWT was not signed by a published
+// key of the project. HS256 tokens are accepted until legacy tokens are disabled.
 func (j *JWT) TokenLookup(token *jwt.Token) (interface{}, error) {
-	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+	kid, _ := token.Header["kid"].(string)
+
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if j.config.JWTLegacyDisabled || j.config.JWTLegacySecret == "" || kid != "" {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(j.config.JWTLegacySecret), nil
+	}
+
+	if kid == "" {
+		return nil, errors.New("token has no key id")
+	}
+	key, err := j.keys.verificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.ProjectID != j.projectID {
+		return nil, errors.New("token was not signed for this project")
+	}
+	if token.Method.Alg() != key.Algorithm {
 		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 	}
 
-	return []byte(j.config.AppSecret), nil
+	return key.private.Public(), nil
 }
 
-// CreateRefreshToken creates a new JWT to be used as the refresh token. The refresh token can be used to retrieve a new access token.
-func (j *JWT) CreateRefreshToken(sessionID, userID string) (string, error) {
+// CreateRefreshToken creates a new JWT to be used as the refresh token. The refresh token can be used once to retrieve
+// a new access token and refresh token, the token ID identifies it within the session.
+func (j *JWT) CreateRefreshToken(sessionID, userID, tokenID string, expires time.Time) (string, error) {
 	// create refresh jwt
 	claims := jwt.MapClaims{
 		"session_id": sessionID,
 		"user_id":    userID,
+		"jti":        tokenID,
 	}
-	expiry := time.Now().Add(time.Minute * RefreshTokenExpiry).Unix()
 
-	return j.CreateJWT(claims, expiry)
+	return j.CreateJWT(claims, expires.Unix())
 }
 
 // CreateAccessToken creates a new JWT to be used as an access token.
 func (j *JWT) CreateAccessToken(claims jwt.MapClaims) (string, error) {
-	expiry := time.Now().Add(time.Minute * AccessTokenExpiry).Unix()
+	expiry := time.Now().Add(j.accessLifetime).Unix()
 	return j.CreateJWT(claims, expiry)
 }
 
 // CreateJWT creates a new JWT. This function will add the `exp` key to the claims based on the expiry time.
 func (j *JWT) CreateJWT(claims jwt.MapClaims, expiry int64) (string, error) {
+	key, err := j.keys.signingKey(j.projectID)
+	if err != nil {
+		return "", err
+	}
+
 	claims["exp"] = expiry
-	// create access jwt
-	token := jwt.New(jwt.GetSigningMethod("HS256"))
-	token.Claims = claims
-	tokenString, err := token.SignedString([]byte(j.config.AppSecret))
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
 
-	return tokenString, err
+	return token.SignedString(key.private)
 }
@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/machinable/machinable/config"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeyEncryptionKey is a base64 encoded 32 byte key encryption key
+const testKeyEncryptionKey = "a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s="
+
+// memoryKeys is an in memory `SigningKeysDatastore`
+type memoryKeys struct {
+	mu   sync.Mutex
+	keys []*models.SigningKey
+}
+
+func (m *memoryKeys) ListSigningKeys(projectID string) ([]*models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []*models.SigningKey{}
+	for _, key := range m.keys {
+		if key.ProjectID == projectID && time.Now().Before(key.Expires) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memoryKeys) GetSigningKey(kid string) (*models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range m.keys {
+		if key.ID == kid {
+			return key, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *memoryKeys) CreateSigningKey(key *models.SigningKey) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.keys {
+		if existing.ProjectID == key.ProjectID && existing.Algorithm == key.Algorithm && existing.Signs(key.Activates) {
+			return false, nil
+		}
+	}
+	m.keys = append(m.keys, key)
+	return true, nil
+}
+
+func (m *memoryKeys) DropProjectSigningKeys(projectID string) error { return nil }
+
+func (m *memoryKeys) PurgeSigningKeys(before time.Time) (int64, error) { return 0, nil }
+
+func TestJWTSigning(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmES256} {
+		t.Run(algorithm, func(t *testing.T) {
+			store := &memoryKeys{}
+			j := NewJWT(&config.AppConfig{JWTAlgorithm: algorithm, KeyEncryptionKey: testKeyEncryptionKey}, store)
+
+			token, err := j.Project("project-a").CreateAccessToken(jwt.MapClaims{"user": "alice"})
+			assert.Nil(t, err)
+
+			parsed, err := jwt.Parse(token, j.Project("project-a").TokenLookup)
+			assert.Nil(t, err)
+			assert.Equal(t, algorithm, parsed.Method.Alg())
+			assert.Equal(t, store.keys[0].ID, parsed.Header["kid"])
+			assert.Equal(t, "alice", parsed.Claims.(jwt.MapClaims)["user"])
+
+			// tokens are only valid for the project that signed them
+			_, err = jwt.Parse(token, j.Project("project-b").TokenLookup)
+			assert.NotNil(t, err)
+			_, err = jwt.Parse(token, j.TokenLookup)
+			assert.NotNil(t, err)
+
+			// the key is reused
+			_, err = j.Project("project-a").CreateRefreshToken("session", "alice", "token", time.Now().Add(time.Hour))
+			assert.Nil(t, err)
+			assert.Len(t, store.keys, 1)
+		})
+	}
+}
+
+func TestJWTLifetimes(t *testing.T) {
+	j := NewJWT(&config.AppConfig{KeyEncryptionKey: testKeyEncryptionKey}, &memoryKeys{}).Project("project")
+	assert.Equal(t, DefaultRefreshTokenLifetime, j.RefreshLifetime())
+
+	tables := []struct {
+		name    string
+		access  int
+		refresh int
+		expires time.Duration
+		lasts   time.Duration
+	}{
+		{"defaults", 0, 0, DefaultAccessTokenLifetime, DefaultRefreshTokenLifetime},
+		{"project lifetimes", 5, 60 * 24 * 30, 5 * time.Minute, 30 * 24 * time.Hour},
+		{"default access lifetime", 0, 60, DefaultAccessTokenLifetime, time.Hour},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			lifetimes := j.Lifetimes(tt.access, tt.refresh)
+			assert.Equal(t, tt.lasts, lifetimes.RefreshLifetime())
+
+			token, err := lifetimes.CreateAccessToken(jwt.MapClaims{})
+			assert.Nil(t, err)
+			parsed, err := jwt.Parse(token, j.TokenLookup)
+			assert.Nil(t, err)
+			exp := int64(parsed.Claims.(jwt.MapClaims)["exp"].(float64))
+			assert.InDelta(t, time.Now().Add(tt.expires).Unix(), exp, 2)
+		})
+	}
+
+	// refresh tokens expire with their session and identify the token within it
+	expires := time.Now().Add(time.Hour)
+	token, err := j.CreateRefreshToken("session", "alice", "token", expires)
+	assert.Nil(t, err)
+	parsed, err := jwt.Parse(token, j.TokenLookup)
+	assert.Nil(t, err)
+	claims := parsed.Claims.(jwt.MapClaims)
+	assert.Equal(t, "token", claims["jti"])
+	assert.Equal(t, "session", claims["session_id"])
+	assert.Equal(t, float64(expires.Unix()), claims["exp"])
+}
+
+func TestJWTLegacyTokens(t *testing.T) {
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()})
+	token, err := legacy.SignedString([]byte("app secret"))
+	assert.Nil(t, err)
+
+	j := NewJWT(&config.AppConfig{AppSecret: "app secret", KeyEncryptionKey: testKeyEncryptionKey}, &memoryKeys{})
+	_, err = jwt.Parse(token, j.TokenLookup)
+	assert.NotNil(t, err, "the app secret does not sign tokens")
+
+	j = NewJWT(&config.AppConfig{JWTLegacySecret: "app secret", KeyEncryptionKey: testKeyEncryptionKey}, &memoryKeys{})
+	_, err = jwt.Parse(token, j.TokenLookup)
+	assert.Nil(t, err)
+
+	// legacy tokens are rejected after the cutover
+	j = NewJWT(&config.AppConfig{JWTLegacySecret: "app secret", JWTLegacyDisabled: true, KeyEncryptionKey: testKeyEncryptionKey}, &memoryKeys{})
+	_, err = jwt.Parse(token, j.TokenLookup)
+	assert.NotNil(t, err)
+}
+
+func TestJWTAlgorithm(t *testing.T) {
+	tables := []struct {
+		configured string
+		algorithm  string
+		valid      bool
+	}{
+		{"", AlgorithmRS256, true},
+		{AlgorithmRS256, AlgorithmRS256, true},
+		{AlgorithmES256, AlgorithmES256, true},
+		{"HS256", "", false},
+		{"es256", "", false},
+	}
+
+	for _, tt := range tables {
+		algorithm, err := JWTAlgorithm(&config.AppConfig{JWTAlgorithm: tt.configured})
+		assert.Equal(t, tt.algorithm, algorithm, tt.configured)
+		assert.Equal(t, tt.valid, err == nil, tt.configured)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	store := &memoryKeys{}
+	keys := NewKeyManager(store, AlgorithmRS256, 4*time.Hour, testKEK(t))
+	now := time.Now()
+
+	// the current key is within an hour of its rotation
+	current, err := keys.createKey("project", now.Add(-3*time.Hour-time.Minute))
+	assert.Nil(t, err)
+
+	signing, err := keys.signingKey("project")
+	assert.Nil(t, err)
+	assert.Equal(t, current.ID, signing.ID)
+
+	// the successor is published before it signs tokens
+	assert.Len(t, store.keys, 2)
+	successor := store.keys[1]
+	assert.Equal(t, current.Rotates, successor.Activates)
+	assert.False(t, successor.Signs(now))
+
+	set, err := keys.JWKS("project")
+	assert.Nil(t, err)
+	assert.Len(t, set.Keys, 2)
+
+	// after the rotation the successor signs tokens, the previous key verifies them until it expires
+	current.Rotates = now
+	keys.signing = map[string]*cachedSigningKey{}
+	successor.Activates = now.Add(-time.Second)
+
+	signing, err = keys.signingKey("project")
+	assert.Nil(t, err)
+	assert.Equal(t, successor.ID, signing.ID)
+	_, err = keys.verificationKey(current.ID)
+	assert.Nil(t, err)
+
+	current.Expires = now
+	_, err = keys.verificationKey(current.ID)
+	assert.EqualError(t, err, "signing key '"+current.ID+"' has expired")
+}
+
+func TestJWKSVerifiesTokens(t *testing.T) {
+	store := &memoryKeys{}
+	j := NewJWT(&config.AppConfig{KeyEncryptionKey: testKeyEncryptionKey}, store).Project("project")
+
+	token, err := j.CreateAccessToken(jwt.MapClaims{})
+	assert.Nil(t, err)
+
+	set, err := j.JWKS()
+	assert.Nil(t, err)
+	assert.Len(t, set.Keys, 1)
+
+	// verify the token like a third party service, with only the published key
+	jwk := set.Keys[0]
+	assert.Equal(t, "RSA", jwk.Kty)
+	assert.Equal(t, "sig", jwk.Use)
+	n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		assert.Equal(t, jwk.Kid, token.Header["kid"])
+		return public, nil
+	})
+	assert.Nil(t, err)
+}
+
+func testKEK(t *testing.T) []byte {
+	kek, err := KeyEncryptionKey(&config.AppConfig{KeyEncryptionKey: testKeyEncryptionKey})
+	assert.Nil(t, err)
+	return kek
+}
+
+func TestSigningKeyEncryption(t *testing.T) {
+	store := &memoryKeys{}
+	keys := NewKeyManager(store, AlgorithmES256, 0, testKEK(t))
+
+	signing, err := keys.signingKey("project")
+	assert.Nil(t, err)
+
+	// the private key is encrypted at rest and bound to its key ID
+	stored := store.keys[0]
+	assert.True(t, Encrypted(stored.PrivateKey))
+	assert.NotContains(t, stored.PrivateKey, "PRIVATE KEY")
+	_, err = DecryptSecret(testKEK(t), stored.PrivateKey, "other")
+	assert.NotNil(t, err)
+
+	// another instance decrypts the key with the same key encryption key
+	other := NewKeyManager(store, AlgorithmES256, 0, testKEK(t))
+	verification, err := other.verificationKey(stored.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, signing.private.Public(), verification.private.Public())
+
+	// a different key encryption key can not decrypt it
+	wrong := NewKeyManager(store, AlgorithmES256, 0, make([]byte, 32))
+	_, err = wrong.verificationKey(stored.ID)
+	assert.NotNil(t, err)
+
+	// keys can not be created without a key encryption key
+	_, err = NewKeyManager(&memoryKeys{}, AlgorithmES256, 0, nil).signingKey("project")
+	assert.Equal(t, ErrNoKeyEncryptionKey, err)
+
+	// plaintext keys are not used
+	plaintext := &models.SigningKey{ID: "plaintext", ProjectID: "project", Algorithm: AlgorithmES256, Expires: time.Now().Add(time.Hour)}
+	private, _ := generateKey(AlgorithmES256)
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	plaintext.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	store.keys = append(store.keys, plaintext)
+	_, err = other.verificationKey("plaintext")
+	assert.NotNil(t, err)
+}
+
+func TestConcurrentKeyCreation(t *testing.T) {
+	store := &memoryKeys{}
+
+	// several instances create the first key of the project at the same time
+	ids := make(chan string, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(ids); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, err := NewKeyManager(store, AlgorithmES256, 0, testKEK(t)).signingKey("project")
+			assert.Nil(t, err)
+			ids <- key.ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	// only one key is active, every instance signs with it
+	assert.Len(t, store.keys, 1)
+	for id := range ids {
+		assert.Equal(t, store.keys[0].ID, id)
+	}
+}
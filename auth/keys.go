@@ -0,0 +1,393 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const (
+	// AlgorithmRS256 signs tokens with 2048 bit RSA keys
+	AlgorithmRS256 = "RS256"
+	// AlgorithmES256 signs tokens with P-256 ECDSA keys
+	AlgorithmES256 = "ES256"
+
+	// DefaultKeyRotation is how long a signing key signs new tokens
+	DefaultKeyRotation = 30 * 24 * time.Hour
+	// maxPrepublish is how long a new key is published before it starts signing, so verifiers can cache it
+	maxPrepublish = 24 * time.Hour
+	// signingKeyCache is how long the current signing key is cached before it is looked up again
+	signingKeyCache = time.Minute
+)
+
+// JWK is a public JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// parsedKey is a signing key with its parsed private key
+type parsedKey struct {
+	*models.SigningKey
+	private crypto.Signer
+}
+
+// cachedSigningKey is the current signing key of a project
+type cachedSigningKey struct {
+	key   *parsedKey
+	until time.Time
+}
+
+// KeyManager creates, rotates and caches the signing keys of projects and application users. Private keys are stored
+// encrypted with the key encryption key.
+type KeyManager struct {
+	store     interfaces.SigningKeysDatastore
+	algorithm string
+	rotation  time.Duration
+	kek       []byte
+
+	// mu guards the caches, it is not held while keys are loaded or generated
+	mu       sync.Mutex
+	signing  map[string]*cachedSigningKey
+	keys     map[string]*parsedKey
+	projects map[string]*sync.Mutex
+}
+
+// NewKeyManager returns a key manager which creates keys of the algorithm, rotated after the rotation period and
+// encrypted with the key encryption key
+func NewKeyManager(store interfaces.SigningKeysDatastore, algorithm string, rotation time.Duration, kek []byte) *KeyManager {
+	if algorithm == "" {
+		algorithm = AlgorithmRS256
+	}
+	if rotation <= 0 {
+		rotation = DefaultKeyRotation
+	}
+	return &KeyManager{
+		store:     store,
+		algorithm: algorithm,
+		rotation:  rotation,
+		kek:       kek,
+		signing:   map[string]*cachedSigningKey{},
+		keys:      map[string]*parsedKey{},
+		projects:  map[string]*sync.Mutex{},
+	}
+}
+
+// prepublish is how long before its predecessor rotates a key is created
+func (m *KeyManager) prepublish() time.Duration {
+	if m.rotation/4 < maxPrepublish {
+		return m.rotation / 4
+	}
+	return maxPrepublish
+}
+
+// cachedSigningKey returns the cached signing key of the project, if it has not expired
+func (m *KeyManager) cachedSigningKey(projectID string, now time.Time) (*parsedKey, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cached, ok := m.signing[projectID]; ok && now.Before(cached.until) {
+		return cached.key, true
+	}
+	return nil, false
+}
+
+// projectLock returns the lock which is held while the signing key of the project is loaded or created
+func (m *KeyManager) projectLock(projectID string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.projects[projectID]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.projects[projectID] = lock
+	}
+	return lock
+}
+
+// signingKey returns the key which signs new tokens of the project. A key is created if the project has none, the
+// successor of the key is created before it rotates.
+func (m *KeyManager) signingKey(projectID string) (*parsedKey, error) {
+	if key, ok := m.cachedSigningKey(projectID, time.Now()); ok {
+		return key, nil
+	}
+
+	// callers of the same project wait for the first one to load the key, other projects are not blocked
+	lock := m.projectLock(projectID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	now := time.Now()
+	if key, ok := m.cachedSigningKey(projectID, now); ok {
+		return key, nil
+	}
+
+	keys, err := m.store.ListSigningKeys(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	active := m.activeKey(keys, now)
+	hasSuccessor := false
+	for _, key := range keys {
+		if key.Activates.After(now) && key.Algorithm == m.algorithm {
+			hasSuccessor = true
+		}
+	}
+
+	if active == nil {
+		if active, err = m.createKey(projectID, now); err != nil {
+			return nil, err
+		}
+	} else if !hasSuccessor && now.After(active.Rotates.Add(-m.prepublish())) {
+		if _, err := m.createKey(projectID, active.Rotates); err != nil {
+			return nil, err
+		}
+	}
+
+	parsed, err := m.parse(active)
+	if err != nil {
+		return nil, err
+	}
+
+	until := now.Add(signingKeyCache)
+	if active.Rotates.Before(until) {
+		until = active.Rotates
+	}
+	m.mu.Lock()
+	m.signing[projectID] = &cachedSigningKey{key: parsed, until: until}
+	m.mu.Unlock()
+
+	return parsed, nil
+}
+
+// activeKey returns the newest key of the algorithm which signs tokens at the time
+func (m *KeyManager) activeKey(keys []*models.SigningKey, now time.Time) *models.SigningKey {
+	var active *models.SigningKey
+	for _, key := range keys {
+		if key.Signs(now) && key.Algorithm == m.algorithm && (active == nil || key.Activates.After(active.Activates)) {
+			active = key
+		}
+	}
+	return active
+}
+
+// verificationKey returns the published key with the key ID
+func (m *KeyManager) verificationKey(kid string) (*parsedKey, error) {
+	m.mu.Lock()
+	key, ok := m.keys[kid]
+	m.mu.Unlock()
+
+	if !ok {
+		stored, err := m.store.GetSigningKey(kid)
+		if err != nil {
+			return nil, fmt.Errorf("unknown signing key '%s'", kid)
+		}
+		if key, err = m.parse(stored); err != nil {
+			return nil, err
+		}
+	}
+
+	if !time.Now().Before(key.Expires) {
+		m.mu.Lock()
+		delete(m.keys, kid)
+		m.mu.Unlock()
+		return nil, fmt.Errorf("signing key '%s' has expired", kid)
+	}
+
+	return key, nil
+}
+
+// JWKS returns the published keys of the project, including keys which will sign tokens after the current key rotates
+func (m *KeyManager) JWKS(projectID string) (*JWKS, error) {
+	// make sure the project has a key
+	if _, err := m.signingKey(projectID); err != nil {
+		return nil, err
+	}
+
+	keys, err := m.store.ListSigningKeys(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &JWKS{Keys: make([]*JWK, 0, len(keys))}
+	for _, key := range keys {
+		parsed, err := m.verificationKey(key.ID)
+		if err != nil {
+			continue
+		}
+		jwk, err := publicJWK(parsed)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
+
+// createKey generates and saves a new key of the project which signs tokens from the activation time. If another
+// instance created a key for the activation time first, its key is returned instead. Callers must hold the lock of
+// the project.
+func (m *KeyManager) createKey(projectID string, activates time.Time) (*models.SigningKey, error) {
+	private, err := generateKey(m.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	// the key ID is authenticated, so an encrypted key can not be copied to another key ID
+	encrypted, err := EncryptSecret(m.kek, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), kid)
+	if err != nil {
+		return nil, err
+	}
+
+	rotates := activates.Add(m.rotation)
+	key := &models.SigningKey{
+		ID:         kid,
+		ProjectID:  projectID,
+		Algorithm:  m.algorithm,
+		PrivateKey: encrypted,
+		Created:    time.Now(),
+		Activates:  activates,
+		Rotates:    rotates,
+		// tokens signed just before the rotation are valid for the longest lifetime of a refresh token
+		Expires: rotates.Add(MaxRefreshTokenLifetime),
+	}
+	created, err := m.store.CreateSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		return key, nil
+	}
+
+	keys, err := m.store.ListSigningKeys(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if existing := m.activeKey(keys, activates); existing != nil {
+		return existing, nil
+	}
+	return nil, fmt.Errorf("failed to create signing key of project '%s'", projectID)
+}
+
+// parse decrypts and decodes the private key and caches the key by its ID
+func (m *KeyManager) parse(key *models.SigningKey) (*parsedKey, error) {
+	m.mu.Lock()
+	parsed, ok := m.keys[key.ID]
+	m.mu.Unlock()
+	if ok {
+		return parsed, nil
+	}
+
+	privatePEM, err := DecryptSecret(m.kek, key.PrivateKey, key.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt signing key '%s': %v", key.ID, err)
+	}
+
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		return nil, fmt.Errorf("invalid signing key '%s'", key.ID)
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key '%s'", key.ID)
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("invalid signing key '%s'", key.ID)
+	}
+	switch signer.(type) {
+	case *rsa.PrivateKey:
+		if key.Algorithm != AlgorithmRS256 {
+			return nil, fmt.Errorf("invalid signing key '%s'", key.ID)
+		}
+	case *ecdsa.PrivateKey:
+		if key.Algorithm != AlgorithmES256 {
+			return nil, fmt.Errorf("invalid signing key '%s'", key.ID)
+		}
+	default:
+		return nil, fmt.Errorf("invalid signing key '%s'", key.ID)
+	}
+
+	parsed = &parsedKey{SigningKey: key, private: signer}
+	m.mu.Lock()
+	m.keys[key.ID] = parsed
+	m.mu.Unlock()
+	return parsed, nil
+}
+
+func generateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm '%s'", algorithm)
+}
+
+// publicJWK encodes the public key as a JWK
+func publicJWK(key *parsedKey) (*JWK, error) {
+	jwk := &JWK{Kid: key.ID, Use: "sig", Alg: key.Algorithm}
+
+	switch public := key.private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the size of the curve
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = public.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(public.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(public.Y.Bytes(), size))
+	default:
+		return nil, errors.New("unsupported public key")
+	}
+
+	return jwk, nil
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
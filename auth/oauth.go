@@ -0,0 +1,312 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// oauthClient is used for all requests to identity providers
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+// maxOAuthResponse limits the size of identity provider responses
+const maxOAuthResponse = 1 << 20
+
+// OAuthToken is the token response of an identity provider
+type OAuthToken struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// ExternalIdentity is the user of an identity provider
+type ExternalIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+// RandomToken returns a url safe random string of n bytes
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEChallenge returns the S256 code challenge of the code verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// DiscoverOIDC sets the endpoints of the provider from the OpenID Connect discovery document of its issuer. Endpoints
+// which have been set are not overwritten.
+func DiscoverOIDC(provider *models.IdentityProvider) error {
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+
+	resp, err := oauthClient.Get(strings.TrimSuffix(provider.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return fmt.Errorf("failed to discover the provider configuration: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to discover the provider configuration: unexpected status code %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOAuthResponse)).Decode(&doc); err != nil {
+		return errors.New("failed to discover the provider configuration: invalid discovery document")
+	}
+
+	// the issuer of the document must be identical to the url it was retrieved from
+	if doc.Issuer != provider.Issuer {
+		return fmt.Errorf("failed to discover the provider configuration: issuer '%s' does not match", doc.Issuer)
+	}
+
+	if provider.AuthorizationURL == "" {
+		provider.AuthorizationURL = doc.AuthorizationEndpoint
+	}
+	if provider.TokenURL == "" {
+		provider.TokenURL = doc.TokenEndpoint
+	}
+	if provider.UserInfoURL == "" {
+		provider.UserInfoURL = doc.UserInfoEndpoint
+	}
+
+	return nil
+}
+
+// OAuthAuthorizationURL returns the url of the provider's authorization endpoint for the authorization code flow with
+// PKCE. The nonce is only sent to OpenID Connect providers.
+func OAuthAuthorizationURL(provider *models.IdentityProvider, redirectURI, state, verifier, nonce string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", provider.ClientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", strings.Join(provider.Scopes, " "))
+	params.Set("state", state)
+	params.Set("code_challenge", PKCEChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+	if provider.OIDC() {
+		params.Set("nonce", nonce)
+	}
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationURL, "?") {
+		separator = "&"
+	}
+	return provider.AuthorizationURL + separator + params.Encode()
+}
+
+// OAuthExchange exchanges the authorization code for the provider's tokens
+func OAuthExchange(provider *models.IdentityProvider, code, redirectURI, verifier string) (*OAuthToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", provider.ClientID)
+	form.Set("client_secret", provider.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, provider.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// GitHub responds with a form encoded body unless JSON is requested
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange the authorization code: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	token := &OAuthToken{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOAuthResponse)).Decode(token); err != nil {
+		return nil, fmt.Errorf("failed to exchange the authorization code: unexpected status code %d", resp.StatusCode)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("failed to exchange the authorization code: %s %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return nil, fmt.Errorf("failed to exchange the authorization code: unexpected status code %d", resp.StatusCode)
+	}
+
+	return token, nil
+}
+
+// OAuthIdentity returns the identity of the provider's user. The claims of OpenID Connect ID tokens are validated
+// against the provider and nonce. The ID token is received directly from the token endpoint over TLS, so its signature
+// is not verified.
+func OAuthIdentity(provider *models.IdentityProvider, token *OAuthToken, nonce string) (*ExternalIdentity, error) {
+	if provider.Type == models.IdentityProviderGitHub {
+		return githubIdentity(provider, token)
+	}
+
+	if token.IDToken == "" {
+		return nil, errors.New("provider did not return an id token")
+	}
+	claims, err := idTokenClaims(provider, token.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &ExternalIdentity{}
+	identity.fromClaims(claims)
+	if identity.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	// the userinfo endpoint may return claims which are not in the id token
+	if provider.UserInfoURL != "" {
+		info := map[string]interface{}{}
+		if err := oauthGet(provider.UserInfoURL, token.AccessToken, &info); err != nil {
+			return nil, err
+		}
+		if sub, _ := info["sub"].(string); sub != identity.Subject {
+			return nil, errors.New("userinfo subject does not match the id token")
+		}
+		identity.fromClaims(info)
+	}
+
+	return identity, nil
+}
+
+func idTokenClaims(provider *models.IdentityProvider, idToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(idToken, claims); err != nil {
+		return nil, errors.New("invalid id token")
+	}
+
+	if !claims.VerifyIssuer(provider.Issuer, true) {
+		return nil, errors.New("id token issuer does not match the provider")
+	}
+	if !audienceContains(claims["aud"], provider.ClientID) {
+		return nil, errors.New("id token audience does not match the client")
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("id token is expired")
+	}
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return nil, errors.New("id token nonce does not match")
+	}
+
+	return claims, nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if s, _ := v.(string); s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fromClaims sets the fields of the identity from standard OpenID Connect claims
+func (i *ExternalIdentity) fromClaims(claims map[string]interface{}) {
+	if sub, ok := claims["sub"].(string); ok && sub != "" {
+		i.Subject = sub
+	}
+	if email, ok := claims["email"].(string); ok && email != "" {
+		i.Email = email
+		// some providers encode booleans as strings
+		switch verified := claims["email_verified"].(type) {
+		case bool:
+			i.EmailVerified = verified
+		case string:
+			i.EmailVerified = verified == "true"
+		default:
+			i.EmailVerified = false
+		}
+	}
+	if username, ok := claims["preferred_username"].(string); ok && username != "" {
+		i.Username = username
+	}
+}
+
+// githubIdentity loads the GitHub user and its primary verified email
+func githubIdentity(provider *models.IdentityProvider, token *OAuthToken) (*ExternalIdentity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := oauthGet(provider.UserInfoURL, token.AccessToken, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, errors.New("provider returned an invalid user")
+	}
+
+	identity := &ExternalIdentity{
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Username: user.Login,
+	}
+
+	// public profile emails are not necessarily verified
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := oauthGet(strings.TrimSuffix(provider.UserInfoURL, "/")+"/emails", token.AccessToken, &emails); err == nil {
+		for _, email := range emails {
+			if email.Primary {
+				identity.Email = email.Email
+				identity.EmailVerified = email.Verified
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+func oauthGet(endpoint, accessToken string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to load the user from the provider: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to load the user from the provider: unexpected status code %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOAuthResponse)).Decode(v); err != nil {
+		return errors.New("failed to load the user from the provider: invalid response")
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubProvider is a local OpenID Connect provider which issues a single authorization code
+type stubProvider struct {
+	server    *httptest.Server
+	challenge string
+	nonce     string
+	idClaims  jwt.MapClaims
+	userinfo  map[string]interface{}
+}
+
+func newStubProvider() *stubProvider {
+	p := &stubProvider{}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"userinfo_endpoint":      p.server.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "abc" || PKCEChallenge(r.Form.Get("code_verifier")) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := jwt.MapClaims{"iss": p.server.URL, "aud": "client", "sub": "42", "nonce": p.nonce, "exp": time.Now().Add(time.Minute).Unix()}
+		for k, v := range p.idClaims {
+			claims[k] = v
+		}
+		idToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("provider secret"))
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "token_type": "Bearer", "id_token": idToken})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(p.userinfo)
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 1001, "login": "octocat"})
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"email": "old@example.com", "primary": false, "verified": true},
+			{"email": "octocat@example.com", "primary": true, "verified": true},
+		})
+	})
+
+	p.server = httptest.NewServer(mux)
+	return p
+}
+
+// authorize simulates the redirect to the provider and returns the verifier
+func (p *stubProvider) authorize(t *testing.T, provider *models.IdentityProvider) string {
+	verifier, err := RandomToken(32)
+	assert.Nil(t, err)
+
+	u, err := url.Parse(OAuthAuthorizationURL(provider, "https://blog.machinable.io/sessions/oauth/stub/callback", "state", verifier, "nonce"))
+	assert.Nil(t, err)
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.Equal(t, "client", u.Query().Get("client_id"))
+
+	p.challenge = u.Query().Get("code_challenge")
+	p.nonce = u.Query().Get("nonce")
+	return verifier
+}
+
+func TestOIDCLogin(t *testing.T) {
+	stub := newStubProvider()
+	defer stub.server.Close()
+
+	provider := &models.IdentityProvider{
+		Name:         "stub",
+		Type:         models.IdentityProviderOIDC,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Issuer:       stub.server.URL,
+	}
+	provider.ApplyPreset()
+	assert.Nil(t, DiscoverOIDC(provider))
+	assert.Equal(t, stub.server.URL+"/token", provider.TokenURL)
+	assert.Nil(t, provider.Validate())
+
+	tables := []struct {
+		name     string
+		idClaims jwt.MapClaims
+		userinfo map[string]interface{}
+		identity *ExternalIdentity
+		err      string
+	}{
+		{
+			"userinfo claims",
+			jwt.MapClaims{},
+			map[string]interface{}{"sub": "42", "email": "alice@example.com", "email_verified": true, "preferred_username": "alice"},
+			&ExternalIdentity{Subject: "42", Email: "alice@example.com", EmailVerified: true, Username: "alice"},
+			"",
+		},
+		{
+			"unverified email",
+			jwt.MapClaims{"email": "alice@example.com"},
+			map[string]interface{}{"sub": "42"},
+			&ExternalIdentity{Subject: "42", Email: "alice@example.com"},
+			"",
+		},
+		{"audience", jwt.MapClaims{"aud": []string{"other"}}, map[string]interface{}{"sub": "42"}, nil, "id token audience does not match the client"},
+		{"issuer", jwt.MapClaims{"iss": "https://evil.example.com"}, map[string]interface{}{"sub": "42"}, nil, "id token issuer does not match the provider"},
+		{"nonce", jwt.MapClaims{"nonce": "replayed"}, map[string]interface{}{"sub": "42"}, nil, "id token nonce does not match"},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}, map[string]interface{}{"sub": "42"}, nil, "id token is expired"},
+		{"userinfo subject", jwt.MapClaims{}, map[string]interface{}{"sub": "43"}, nil, "userinfo subject does not match the id token"},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			stub.idClaims = tt.idClaims
+			stub.userinfo = tt.userinfo
+			verifier := stub.authorize(t, provider)
+
+			token, err := OAuthExchange(provider, "abc", "https://blog.machinable.io/sessions/oauth/stub/callback", verifier)
+			assert.Nil(t, err)
+
+			identity, err := OAuthIdentity(provider, token, "nonce")
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.identity, identity)
+		})
+	}
+
+	// the code verifier must match the challenge
+	stub.authorize(t, provider)
+	_, err := OAuthExchange(provider, "abc", "https://blog.machinable.io/sessions/oauth/stub/callback", "wrong verifier")
+	assert.EqualError(t, err, "failed to exchange the authorization code: invalid_grant ")
+}
+
+func TestGitHubLogin(t *testing.T) {
+	stub := newStubProvider()
+	defer stub.server.Close()
+
+	provider := &models.IdentityProvider{
+		Name:         "github",
+		Type:         models.IdentityProviderGitHub,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		TokenURL:     stub.server.URL + "/token",
+		UserInfoURL:  stub.server.URL + "/user",
+	}
+	provider.ApplyPreset()
+	assert.Equal(t, "https://github.com/login/oauth/authorize", provider.AuthorizationURL)
+	assert.Equal(t, []string{"read:user", "user:email"}, provider.Scopes)
+
+	verifier := stub.authorize(t, provider)
+	assert.Empty(t, stub.nonce)
+
+	token, err := OAuthExchange(provider, "abc", "https://blog.machinable.io/sessions/oauth/github/callback", verifier)
+	assert.Nil(t, err)
+
+	identity, err := OAuthIdentity(provider, token, "")
+	assert.Nil(t, err)
+	assert.Equal(t, &ExternalIdentity{Subject: "1001", Username: "octocat", Email: "octocat@example.com", EmailVerified: true}, identity)
+}
+
+func TestIdentityProviderValidate(t *testing.T) {
+	google := &models.IdentityProvider{Name: "google", Type: models.IdentityProviderGoogle, ClientID: "client", ClientSecret: "secret"}
+	google.ApplyPreset()
+	assert.Equal(t, models.GoogleIssuer, google.Issuer)
+
+	tables := []struct {
+		name     string
+		provider models.IdentityProvider
+		err      string
+	}{
+		{"name", models.IdentityProvider{Name: "My Provider"}, "invalid provider name, must be up to 32 lowercase letters, digits or dashes"},
+		{"type", models.IdentityProvider{Name: "saml", Type: "saml"}, "invalid provider type, must be one of 'oidc', 'google' or 'github'"},
+		{"secret", models.IdentityProvider{Name: "github", Type: "github", ClientID: "client"}, "client_id and client_secret are required"},
+		{"openid scope", models.IdentityProvider{Name: "okta", Type: "oidc", ClientID: "client", ClientSecret: "secret", Issuer: "https://okta.example.com", Scopes: []string{"email"}}, "scopes of openid connect providers must include 'openid'"},
+		{"http", models.IdentityProvider{Name: "github", Type: "github", ClientID: "client", ClientSecret: "secret", AuthorizationURL: "http://github.com/login/oauth/authorize"}, "authorization_url must be an https url"},
+		{"redirect", models.IdentityProvider{Name: "github", Type: "github", ClientID: "client", ClientSecret: "secret", RedirectURLs: []string{"/callback"}}, "invalid redirect url '/callback', must be absolute without a fragment"},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.provider.ApplyPreset()
+			assert.EqualError(t, tt.provider.Validate(), tt.err)
+		})
+	}
+}
@@ -20,7 +20,7 @@ func CompareHashAndPassword(hash, password string) bool {
 }
 
 // SHA1 hashes using sha1 algorithm
-// Used for api keys
+// Used for legacy api keys, new keys are hashed with `HashAPIKey`
 func SHA1(text, appSecret string) string {
 	algorithm := sha1.New()
 	algorithm.Write([]byte(text + appSecret)) // salt
@@ -9,5 +9,15 @@ const (
 	RoleAnon = "anonymous"
 )
 
-// ValidRoles is a list of allowed roles for project users and api keys
+// ValidRoles is a list of the built-in roles for project users and api keys, projects can define custom roles
 var ValidRoles = [2]string{RoleUser, RoleAdmin}
+
+// BuiltinRole returns true if the role is one of the built-in roles rather than a custom role of the project
+func BuiltinRole(role string) bool {
+	for _, b := range ValidRoles {
+		if b == role {
+			return true
+		}
+	}
+	return false
+}
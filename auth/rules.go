@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// Requester describes who is making a request, used to evaluate JSON access rules
+type Requester struct {
+	ID        string
+	Role      string
+	Anonymous bool
+}
+
+// ruleEnv is the environment a rule condition is evaluated in
+type ruleEnv struct {
+	requester *Requester
+	vars      map[string]string
+}
+
+// condition is a compiled rule condition
+type condition func(env *ruleEnv) interface{}
+
+// maxCachedConditions bounds the number of compiled conditions kept by `conditions`
+const maxCachedConditions = 4096
+
+// conditionCache keeps compiled rule conditions by expression, so rules are compiled once rather than on every request
+type conditionCache struct {
+	mu    sync.RWMutex
+	items map[string]condition
+}
+
+// conditions caches the conditions of the rules of all projects, invalid conditions are cached as `nil`
+var conditions = &conditionCache{items: make(map[string]condition)}
+
+// get returns the compiled condition of the expression, compiling it on the first use
+func (cc *conditionCache) get(expression string) condition {
+	cc.mu.RLock()
+	cond, ok := cc.items[expression]
+	cc.mu.RUnlock()
+	if ok {
+		return cond
+	}
+
+	cond, err := compileCondition(expression)
+	if err != nil {
+		cond = nil
+	}
+
+	cc.mu.Lock()
+	// conditions of deleted or changed rules are dropped once the cache is full
+	if len(cc.items) >= maxCachedConditions {
+		cc.items = make(map[string]condition)
+	}
+	cc.items[expression] = cond
+	cc.mu.Unlock()
+
+	return cond
+}
+
+// JSONRulesAllow returns true if a rule matching the key path, or one of its ancestors, allows the operation. Access
+// granted to a path cascades to all of its children.
+func JSONRulesAllow(rules []*models.JSONRule, op string, keys []string, requester *Requester) bool {
+	for _, rule := range rules {
+		expression := rule.Read
+		if op == models.JSONRuleWrite {
+			expression = rule.Write
+		}
+		if expression == "" {
+			continue
+		}
+
+		cond := conditions.get(expression)
+		if cond == nil {
+			continue
+		}
+
+		pattern := splitRulePath(rule.Path)
+		for i := 0; i <= len(keys); i++ {
+			vars := make(map[string]string)
+			if !matchRulePath(pattern, keys[:i], vars) {
+				continue
+			}
+
+			if cond(&ruleEnv{requester: requester, vars: vars}) == true {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ValidateJSONRules verifies the paths and conditions of the rules
+func ValidateJSONRules(rules []*models.JSONRule) error {
+	for _, rule := range rules {
+		vars := make(map[string]bool)
+		pattern := splitRulePath(rule.Path)
+		for i, segment := range pattern {
+			if segment == "**" && i != len(pattern)-1 {
+				return fmt.Errorf("rule '%s': '**' must be the last path segment", rule.Path)
+			}
+			if strings.HasPrefix(segment, "{") != strings.HasSuffix(segment, "}") || segment == "{}" {
+				return fmt.Errorf("rule '%s': invalid path variable '%s'", rule.Path, segment)
+			}
+			if strings.HasPrefix(segment, "{") {
+				vars[segment[1:len(segment)-1]] = true
+			}
+		}
+
+		for _, expression := range []string{rule.Read, rule.Write} {
+			if expression == "" {
+				continue
+			}
+			if _, err := compileCondition(expression); err != nil {
+				return fmt.Errorf("rule '%s': %s", rule.Path, err.Error())
+			}
+
+			// variables must be captured by the path, a missing variable is `null`
+			tokens, _ := tokenizeCondition(expression)
+			for _, token := range tokens {
+				if strings.HasPrefix(token, "$") && !vars[token[1:]] {
+					return fmt.Errorf("rule '%s': variable '%s' is not defined in the path", rule.Path, token)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func splitRulePath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// matchRulePath matches the keys against the rule path pattern, capturing `{var}` segments
+func matchRulePath(pattern, keys []string, vars map[string]string) bool {
+	if len(pattern) == 0 {
+		return len(keys) == 0
+	}
+
+	segment := pattern[0]
+	if segment == "**" {
+		return true
+	}
+	if len(keys) == 0 {
+		return false
+	}
+
+	switch {
+	case segment == "*":
+	case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+		vars[segment[1:len(segment)-1]] = keys[0]
+	case segment != keys[0]:
+		return false
+	}
+
+	return matchRulePath(pattern[1:], keys[1:], vars)
+}
+
+// compileCondition parses a rule condition. Conditions support `true`, `false`, `null`, quoted strings, `auth`,
+// `auth.id`, `auth.role`, path variables (`$uid` for a `{uid}` segment), `==`, `!=`, `!`, `&&`, `||` and parentheses.
+func compileCondition(expression string) (condition, error) {
+	tokens, err := tokenizeCondition(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &conditionParser{tokens: tokens}
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s' in condition", p.tokens[p.pos])
+	}
+
+	return cond, nil
+}
+
+func tokenizeCondition(expression string) ([]string, error) {
+	tokens := make([]string, 0)
+	for i := 0; i < len(expression); {
+		ch := expression[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			i++
+		case strings.HasPrefix(expression[i:], "==") || strings.HasPrefix(expression[i:], "!=") ||
+			strings.HasPrefix(expression[i:], "&&") || strings.HasPrefix(expression[i:], "||"):
+			tokens = append(tokens, expression[i:i+2])
+			i += 2
+		case ch == '!' || ch == '(' || ch == ')':
+			tokens = append(tokens, string(ch))
+			i++
+		case ch == '\'' || ch == '"':
+			end := strings.IndexByte(expression[i+1:], ch)
+			if end < 0 {
+				return nil, errors.New("unterminated string in condition")
+			}
+			tokens = append(tokens, expression[i:i+end+2])
+			i += end + 2
+		case ch == '$' || ch == '.' || ch == '_' || ch == '-' || isAlphaNumeric(ch):
+			start := i
+			for i < len(expression) && (expression[i] == '$' || expression[i] == '.' || expression[i] == '_' || expression[i] == '-' || isAlphaNumeric(expression[i])) {
+				i++
+			}
+			tokens = append(tokens, expression[start:i])
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' in condition", ch)
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("empty condition")
+	}
+
+	return tokens, nil
+}
+
+func isAlphaNumeric(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
+
+type conditionParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env *ruleEnv) interface{} {
+			return l(env) == true || right(env) == true
+		}
+	}
+
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (condition, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env *ruleEnv) interface{} {
+			return l(env) == true && right(env) == true
+		}
+	}
+
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (condition, error) {
+	if p.peek() == "!" {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *ruleEnv) interface{} {
+			return operand(env) != true
+		}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (condition, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.peek()
+	if op != "==" && op != "!=" {
+		return left, nil
+	}
+	p.pos++
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(env *ruleEnv) interface{} {
+		equal := left(env) == right(env)
+		if op == "==" {
+			return equal
+		}
+		return !equal
+	}, nil
+}
+
+func (p *conditionParser) parseOperand() (condition, error) {
+	token := p.peek()
+	if token == "" {
+		return nil, errors.New("unexpected end of condition")
+	}
+	p.pos++
+
+	switch {
+	case token == "(":
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing ')' in condition")
+		}
+		p.pos++
+		return cond, nil
+	case token == "true" || token == "false":
+		value := token == "true"
+		return func(env *ruleEnv) interface{} { return value }, nil
+	case token == "null":
+		return func(env *ruleEnv) interface{} { return nil }, nil
+	case token[0] == '\'' || token[0] == '"':
+		value := token[1 : len(token)-1]
+		return func(env *ruleEnv) interface{} { return value }, nil
+	case token == "auth":
+		// `auth` is null for anonymous requests
+		return func(env *ruleEnv) interface{} {
+			if env.requester == nil || env.requester.Anonymous {
+				return nil
+			}
+			return "authenticated"
+		}, nil
+	case token == "auth.id" || token == "auth.role":
+		return func(env *ruleEnv) interface{} {
+			if env.requester == nil || env.requester.Anonymous {
+				return nil
+			}
+			if token == "auth.id" {
+				return env.requester.ID
+			}
+			return env.requester.Role
+		}, nil
+	case strings.HasPrefix(token, "$") && len(token) > 1:
+		name := token[1:]
+		return func(env *ruleEnv) interface{} {
+			if value, ok := env.vars[name]; ok {
+				return value
+			}
+			return nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown identifier '%s' in condition", token)
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONRulesAllow(t *testing.T) {
+	rules := []*models.JSONRule{
+		{Path: "users/{uid}/**", Read: "auth != null", Write: "auth.id == $uid || auth.role == 'admin'"},
+		{Path: "public/**", Read: "true"},
+		{Path: "config", Read: "auth != null", Write: "auth.role == 'admin'"},
+	}
+
+	user := &Requester{ID: "abc", Role: RoleUser}
+	admin := &Requester{ID: "xyz", Role: RoleAdmin}
+	anon := &Requester{ID: "anonymous", Role: RoleAnon, Anonymous: true}
+
+	tables := []struct {
+		name      string
+		op        string
+		path      string
+		requester *Requester
+		allowed   bool
+	}{
+		{"owner writes own user", models.JSONRuleWrite, "users/abc/name", user, true},
+		{"owner writes own user root", models.JSONRuleWrite, "users/abc", user, true},
+		{"user writes other user", models.JSONRuleWrite, "users/def/name", user, false},
+		{"admin writes other user", models.JSONRuleWrite, "users/def/name", admin, true},
+		{"user reads other user", models.JSONRuleRead, "users/def", user, true},
+		{"anonymous reads user", models.JSONRuleRead, "users/def", anon, false},
+		{"anonymous reads public", models.JSONRuleRead, "public/posts/1", anon, true},
+		{"anonymous writes public", models.JSONRuleWrite, "public/posts/1", anon, false},
+		{"read all users not granted", models.JSONRuleRead, "users", user, false},
+		{"config cascades to children", models.JSONRuleWrite, "config/theme", admin, true},
+		{"user writes config", models.JSONRuleWrite, "config", user, false},
+		{"unmatched path", models.JSONRuleRead, "private", admin, false},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := strings.Split(tt.path, "/")
+			assert.Equal(t, tt.allowed, JSONRulesAllow(rules, tt.op, keys, tt.requester))
+		})
+	}
+}
+
+func TestValidateJSONRules(t *testing.T) {
+	tables := []struct {
+		name  string
+		rule  *models.JSONRule
+		valid bool
+	}{
+		{"valid", &models.JSONRule{Path: "users/{uid}/**", Write: "auth.id == $uid && !(auth.role == 'user')"}, true},
+		{"root", &models.JSONRule{Path: "/", Read: "auth != null"}, true},
+		{"double star not last", &models.JSONRule{Path: "users/**/name", Read: "true"}, false},
+		{"unclosed variable", &models.JSONRule{Path: "users/{uid", Read: "true"}, false},
+		{"unknown identifier", &models.JSONRule{Path: "users", Read: "request.time"}, false},
+		{"unterminated string", &models.JSONRule{Path: "users", Read: "auth.role == 'admin"}, false},
+		{"dangling operator", &models.JSONRule{Path: "users", Read: "auth.id =="}, false},
+		{"undefined variable", &models.JSONRule{Path: "users/{uid}", Read: "auth.id == $id"}, false},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateJSONRules([]*models.JSONRule{tt.rule})
+			assert.Equal(t, tt.valid, err == nil, err)
+		})
+	}
+}
+
+func TestConditionCache(t *testing.T) {
+	cache := &conditionCache{items: make(map[string]condition)}
+
+	cond := cache.get("auth.role == 'admin'")
+	assert.NotNil(t, cond)
+	assert.Equal(t, true, cond(&ruleEnv{requester: &Requester{Role: RoleAdmin}}))
+	assert.Len(t, cache.items, 1)
+
+	// compiled once
+	cache.get("auth.role == 'admin'")
+	assert.Len(t, cache.items, 1)
+
+	// invalid conditions are cached as nil
+	assert.Nil(t, cache.get("auth.role =="))
+	assert.Len(t, cache.items, 2)
+
+	for i := 0; i < maxCachedConditions; i++ {
+		cache.get("$" + strings.Repeat("a", i+1) + " == 'x'")
+	}
+	assert.True(t, len(cache.items) <= maxCachedConditions)
+}
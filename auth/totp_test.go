@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors, truncated to 6 digits
+	key := []byte("12345678901234567890")
+
+	tables := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.code, func(t *testing.T) {
+			assert.Equal(t, tt.code, totpCode(key, tt.time/totpPeriod))
+		})
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+
+	tables := []struct {
+		name  string
+		code  string
+		valid bool
+	}{
+		{"current", "081804", true},
+		{"previous", totpCode([]byte("12345678901234567890"), now.Unix()/totpPeriod-1), true},
+		{"next", totpCode([]byte("12345678901234567890"), now.Unix()/totpPeriod+1), true},
+		{"expired", totpCode([]byte("12345678901234567890"), now.Unix()/totpPeriod-2), false},
+		{"wrong", "000000", false},
+		{"length", "81804", false},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(secret, tt.code, now)
+			assert.Equal(t, tt.valid, ok)
+			if tt.valid {
+				assert.InDelta(t, now.Unix()/totpPeriod, step, 1)
+			}
+		})
+	}
+
+	_, ok := ValidateTOTP("not base32!", "081804", now)
+	assert.False(t, ok)
+}
+
+func TestTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.Nil(t, err)
+	assert.Regexp(t, `^[A-Z2-7]{32}$`, secret)
+
+	assert.Equal(t, "otpauth://totp/Machinable:dev@machinable.io?algorithm=SHA1&digits=6&issuer=Machinable&period=30&secret="+secret, TOTPURI("dev@machinable.io", secret))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	assert.Nil(t, err)
+	assert.Len(t, codes, RecoveryCodeCount)
+	for _, code := range codes {
+		assert.Regexp(t, `^[0-9a-f]{5}-[0-9a-f]{5}$`, code)
+	}
+
+	assert.Equal(t, HashRecoveryCode("abcde-12345"), HashRecoveryCode("ABCDE 12345"))
+	assert.NotEqual(t, HashRecoveryCode("abcde-12345"), HashRecoveryCode("abcde-12346"))
+}
@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/machinable/machinable/config"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	// webAuthnTimeout is the number of milliseconds the browser waits for the authenticator
+	webAuthnTimeout = 60000
+	// coseAlgES256 is the COSE algorithm of ECDSA with P-256 and SHA-256, the only algorithm accepted
+	coseAlgES256 = -7
+
+	authDataUserPresent  = 0x01
+	authDataAttestedData = 0x40
+)
+
+// WebAuthn verifies security key and platform authenticator credentials of the management app. Only ES256 credentials
+// are accepted and attestation is not requested, the authenticator of a credential is not verified.
+type WebAuthn struct {
+	RPID   string
+	RPName string
+	Origin string
+}
+
+// NewWebAuthn returns the relying party of the management app, its ID is the hostname of the app host
+func NewWebAuthn(config *config.AppConfig) *WebAuthn {
+	w := &WebAuthn{RPName: TOTPIssuer, Origin: config.AppHost}
+	if u, err := url.Parse(config.AppHost); err == nil {
+		w.RPID = u.Hostname()
+		w.Origin = u.Scheme + "://" + u.Host
+	}
+	return w
+}
+
+// WebAuthnEntity is the relying party or user of creation options
+type WebAuthnEntity struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// WebAuthnDescriptor identifies a credential of the user, IDs are base64url encoded
+type WebAuthnDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// WebAuthnParameter is a credential type and algorithm accepted by the relying party
+type WebAuthnParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// WebAuthnCreationOptions are the options of `navigator.credentials.create`, binary values are base64url encoded
+type WebAuthnCreationOptions struct {
+	Challenge              string               `json:"challenge"`
+	RP                     WebAuthnEntity       `json:"rp"`
+	User                   WebAuthnEntity       `json:"user"`
+	PubKeyCredParams       []WebAuthnParameter  `json:"pubKeyCredParams"`
+	Timeout                int                  `json:"timeout"`
+	ExcludeCredentials     []WebAuthnDescriptor `json:"excludeCredentials"`
+	Attestation            string               `json:"attestation"`
+	AuthenticatorSelection map[string]string    `json:"authenticatorSelection"`
+}
+
+// WebAuthnRequestOptions are the options of `navigator.credentials.get`, binary values are base64url encoded
+type WebAuthnRequestOptions struct {
+	Challenge        string               `json:"challenge"`
+	RPID             string               `json:"rpId"`
+	Timeout          int                  `json:"timeout"`
+	AllowCredentials []WebAuthnDescriptor `json:"allowCredentials"`
+	UserVerification string               `json:"userVerification"`
+}
+
+// WebAuthnResponse is the `PublicKeyCredential` returned by the browser, binary values are base64url encoded
+type WebAuthnResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+	} `json:"response"`
+}
+
+// clientData is the client data of a registration or assertion
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// attestationObject is the CBOR encoded attestation of a registration, the statement is not verified
+type attestationObject struct {
+	Format   string `codec:"fmt"`
+	AuthData []byte `codec:"authData"`
+}
+
+// authenticatorData is the parsed authenticator data of a registration or assertion
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// CreationOptions returns the options to register a new credential for the user, the credentials the user has
+// registered are excluded
+func (w *WebAuthn) CreationOptions(challenge, userID, username string, credentials []*models.WebAuthnCredential) *WebAuthnCreationOptions {
+	return &WebAuthnCreationOptions{
+		Challenge:              challenge,
+		RP:                     WebAuthnEntity{ID: w.RPID, Name: w.RPName},
+		User:                   WebAuthnEntity{ID: base64.RawURLEncoding.EncodeToString([]byte(userID)), Name: username, DisplayName: username},
+		PubKeyCredParams:       []WebAuthnParameter{{Type: "public-key", Alg: coseAlgES256}},
+		Timeout:                webAuthnTimeout,
+		ExcludeCredentials:     descriptors(credentials),
+		Attestation:            "none",
+		AuthenticatorSelection: map[string]string{"userVerification": "preferred"},
+	}
+}
+
+// RequestOptions returns the options to authenticate with one of the credentials of the user
+func (w *WebAuthn) RequestOptions(challenge string, credentials []*models.WebAuthnCredential) *WebAuthnRequestOptions {
+	return &WebAuthnRequestOptions{
+		Challenge:        challenge,
+		RPID:             w.RPID,
+		Timeout:          webAuthnTimeout,
+		AllowCredentials: descriptors(credentials),
+		UserVerification: "preferred",
+	}
+}
+
+// VerifyRegistration verifies the response to creation options with the challenge, returning the new credential
+func (w *WebAuthn) VerifyRegistration(challenge string, resp *WebAuthnResponse) (*models.WebAuthnCredential, error) {
+	if _, err := w.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, errors.New("invalid attestation object")
+	}
+	var attestation attestationObject
+	if err := codec.NewDecoderBytes(raw, &codec.CborHandle{}).Decode(&attestation); err != nil {
+		return nil, errors.New("invalid attestation object")
+	}
+
+	data, err := w.parseAuthenticatorData(attestation.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if data.Flags&authDataAttestedData == 0 || len(data.CredentialID) == 0 {
+		return nil, errors.New("attested credential data missing")
+	}
+
+	key, err := parseCOSEKey(data.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.WebAuthnCredential{
+		ID:        base64.RawURLEncoding.EncodeToString(data.CredentialID),
+		PublicKey: publicKey,
+		SignCount: data.SignCount,
+		Created:   time.Now(),
+	}, nil
+}
+
+// VerifyAssertion verifies the response to request options with the challenge and registered credential, returning
+// the new sign count of the credential
+func (w *WebAuthn) VerifyAssertion(challenge string, credential *models.WebAuthnCredential, resp *WebAuthnResponse) (uint32, error) {
+	clientDataJSON, err := w.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	authData, err := base64.RawURLEncoding.DecodeString(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, errors.New("invalid authenticator data")
+	}
+	data, err := w.parseAuthenticatorData(authData)
+	if err != nil {
+		return 0, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(resp.Response.Signature)
+	if err != nil {
+		return 0, errors.New("invalid signature")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return 0, errors.New("unsupported credential key")
+	}
+
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+		return 0, errors.New("invalid signature")
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	if !ecdsa.Verify(key, digest[:], sig.R, sig.S) {
+		return 0, errors.New("invalid signature")
+	}
+
+	// a sign count which does not increase indicates a cloned authenticator, authenticators without counters always
+	// return zero
+	if (data.SignCount != 0 || credential.SignCount != 0) && data.SignCount <= credential.SignCount {
+		return 0, errors.New("credential sign count did not increase")
+	}
+
+	return data.SignCount, nil
+}
+
+// verifyClientData checks the type, challenge and origin of the client data, returning its decoded JSON
+func (w *WebAuthn) verifyClientData(encoded, ceremony, challenge string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("invalid client data")
+	}
+
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, errors.New("invalid client data")
+	}
+	if data.Type != ceremony {
+		return nil, errors.New("invalid client data type")
+	}
+	if challenge == "" || data.Challenge != challenge {
+		return nil, errors.New("invalid challenge")
+	}
+	if data.Origin != w.Origin {
+		return nil, errors.New("invalid origin")
+	}
+
+	return raw, nil
+}
+
+// parseAuthenticatorData parses the authenticator data, checking the relying party and that the user was present
+func (w *WebAuthn) parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, errors.New("invalid authenticator data")
+	}
+
+	data := &authenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	rpIDHash := sha256.Sum256([]byte(w.RPID))
+	if !bytes.Equal(data.RPIDHash, rpIDHash[:]) {
+		return nil, errors.New("invalid relying party")
+	}
+	if data.Flags&authDataUserPresent == 0 {
+		return nil, errors.New("user presence required")
+	}
+
+	if data.Flags&authDataAttestedData != 0 {
+		// aaguid (16 bytes), credential ID length (2 bytes), credential ID, COSE public key
+		rest := raw[37:]
+		if len(rest) < 18 {
+			return nil, errors.New("invalid attested credential data")
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		if len(rest) < 18+idLength {
+			return nil, errors.New("invalid attested credential data")
+		}
+		data.CredentialID = rest[18 : 18+idLength]
+		data.PublicKey = rest[18+idLength:]
+	}
+
+	return data, nil
+}
+
+// parseCOSEKey parses an ES256 COSE public key, extensions following the key are ignored
+func parseCOSEKey(raw []byte) (*ecdsa.PublicKey, error) {
+	cose := map[int]interface{}{}
+	if err := codec.NewDecoderBytes(raw, &codec.CborHandle{}).Decode(&cose); err != nil {
+		return nil, errors.New("invalid credential public key")
+	}
+
+	// kty 2 (EC2), alg -7 (ES256), crv 1 (P-256)
+	if coseInt(cose[1]) != 2 || coseInt(cose[3]) != coseAlgES256 || coseInt(cose[-1]) != 1 {
+		return nil, errors.New("unsupported credential algorithm, ES256 is required")
+	}
+	x, xOK := cose[-2].([]byte)
+	y, yOK := cose[-3].([]byte)
+	if !xOK || !yOK || len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("invalid credential public key")
+	}
+
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("invalid credential public key")
+	}
+
+	return key, nil
+}
+
+// coseInt returns the integer of a decoded COSE value, CBOR integers are decoded as signed or unsigned
+func coseInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case uint64:
+		return int64(n)
+	}
+	return 0
+}
+
+// descriptors returns the descriptors of the credentials
+func descriptors(credentials []*models.WebAuthnCredential) []WebAuthnDescriptor {
+	list := []WebAuthnDescriptor{}
+	for _, credential := range credentials {
+		list = append(list, WebAuthnDescriptor{Type: "public-key", ID: credential.ID})
+	}
+	return list
+}
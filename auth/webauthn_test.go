@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/machinable/machinable/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+)
+
+// testAuthenticator is a software ES256 authenticator of the relying party
+type testAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	rpID         string
+	origin       string
+	credentialID []byte
+	signCount    uint32
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	return &testAuthenticator{key: key, rpID: "app.machinable.io", origin: "https://app.machinable.io", credentialID: []byte("credential-1")}
+}
+
+func (a *testAuthenticator) clientData(ceremony, challenge string) string {
+	b, _ := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: a.origin})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *testAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.signCount)
+	return append(data, attested...)
+}
+
+func (a *testAuthenticator) register(t *testing.T, challenge string) *WebAuthnResponse {
+	var cose []byte
+	key := map[int]interface{}{1: 2, 3: -7, -1: 1, -2: a.key.X.FillBytes(make([]byte, 32)), -3: a.key.Y.FillBytes(make([]byte, 32))}
+	assert.Nil(t, codec.NewEncoderBytes(&cose, &codec.CborHandle{}).Encode(key))
+
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(a.credentialID)))
+	attested = append(append(attested, a.credentialID...), cose...)
+
+	var object []byte
+	attestation := map[string]interface{}{"fmt": "none", "attStmt": map[string]interface{}{}, "authData": a.authData(authDataUserPresent|authDataAttestedData, attested)}
+	assert.Nil(t, codec.NewEncoderBytes(&object, &codec.CborHandle{}).Encode(attestation))
+
+	resp := &WebAuthnResponse{ID: base64.RawURLEncoding.EncodeToString(a.credentialID), Type: "public-key"}
+	resp.Response.ClientDataJSON = a.clientData("webauthn.create", challenge)
+	resp.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(object)
+	return resp
+}
+
+func (a *testAuthenticator) assert(t *testing.T, challenge string) *WebAuthnResponse {
+	a.signCount++
+	authData := a.authData(authDataUserPresent, nil)
+	clientDataJSON := a.clientData("webauthn.get", challenge)
+
+	raw, _ := base64.RawURLEncoding.DecodeString(clientDataJSON)
+	clientDataHash := sha256.Sum256(raw)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	assert.Nil(t, err)
+
+	resp := &WebAuthnResponse{ID: base64.RawURLEncoding.EncodeToString(a.credentialID), Type: "public-key"}
+	resp.Response.ClientDataJSON = clientDataJSON
+	resp.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+	resp.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	return resp
+}
+
+func TestNewWebAuthn(t *testing.T) {
+	w := NewWebAuthn(&config.AppConfig{AppHost: "https://app.machinable.io/"})
+	assert.Equal(t, "app.machinable.io", w.RPID)
+	assert.Equal(t, "https://app.machinable.io", w.Origin)
+
+	options := w.CreationOptions("challenge", "user-1", "dev", nil)
+	assert.Equal(t, "app.machinable.io", options.RP.ID)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString([]byte("user-1")), options.User.ID)
+	assert.Equal(t, []WebAuthnParameter{{Type: "public-key", Alg: -7}}, options.PubKeyCredParams)
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	w := NewWebAuthn(&config.AppConfig{AppHost: "https://app.machinable.io"})
+	a := newTestAuthenticator(t)
+
+	credential, err := w.VerifyRegistration("register-challenge", a.register(t, "register-challenge"))
+	assert.Nil(t, err)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(a.credentialID), credential.ID)
+
+	signCount, err := w.VerifyAssertion("login-challenge", credential, a.assert(t, "login-challenge"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), signCount)
+	credential.SignCount = signCount
+
+	tables := []struct {
+		name   string
+		modify func(a *testAuthenticator, resp *WebAuthnResponse)
+	}{
+		{"challenge", func(a *testAuthenticator, resp *WebAuthnResponse) {
+			resp.Response.ClientDataJSON = a.clientData("webauthn.get", "other-challenge")
+		}},
+		{"ceremony", func(a *testAuthenticator, resp *WebAuthnResponse) {
+			resp.Response.ClientDataJSON = a.clientData("webauthn.create", "login-challenge")
+		}},
+		{"origin", func(a *testAuthenticator, resp *WebAuthnResponse) {
+			a.origin = "https://evil.example.com"
+			resp.Response.ClientDataJSON = a.clientData("webauthn.get", "login-challenge")
+		}},
+		{"signature", func(a *testAuthenticator, resp *WebAuthnResponse) {
+			other := newTestAuthenticator(t)
+			resp.Response.Signature = other.assert(t, "login-challenge").Response.Signature
+		}},
+		{"sign count", func(a *testAuthenticator, resp *WebAuthnResponse) {
+			a.signCount = 0
+			*resp = *a.assert(t, "login-challenge")
+		}},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			test := *a
+			resp := test.assert(t, "login-challenge")
+			tt.modify(&test, resp)
+			_, err := w.VerifyAssertion("login-challenge", credential, resp)
+			assert.NotNil(t, err)
+		})
+	}
+
+	other := NewWebAuthn(&config.AppConfig{AppHost: "https://other.machinable.io"})
+	_, err = other.VerifyRegistration("register-challenge", a.register(t, "register-challenge"))
+	assert.NotNil(t, err)
+}
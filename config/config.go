@@ -1,6 +1,10 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+)
 
 // AppConfig contains the application configuration
 type AppConfig struct {
@@ -9,6 +13,32 @@ type AppConfig struct {
 	IPStackKey      string
 	Version         string
 	AppHost         string
+
+	// APIKeySecret keys the HMAC of project API keys, the app does not start without it. The AppSecret is only used to
+	// look up legacy SHA1 hashes, which are migrated to the HMAC when the key is next used.
+	APIKeySecret string
+
+	// JWTAlgorithm selects the algorithm of new JWT signing keys: RS256 (default) or ES256
+	JWTAlgorithm string
+	// JWTKeyRotationDays is how long a signing key signs new tokens before it is rotated, 30 days by default
+	JWTKeyRotationDays int
+	// JWTLegacySecret verifies HS256 tokens issued before asymmetric signing, the AppSecret which signed them is used if
+	// it is empty
+	JWTLegacySecret string
+	// JWTLegacyDisabled rejects HS256 tokens, set it once the tokens issued before asymmetric signing have expired
+	JWTLegacyDisabled bool
+
+	// KeyEncryptionKey encrypts the JWT signing keys and TOTP secrets stored in the database, a base64 encoded 32 byte key
+	KeyEncryptionKey string
+
+	// TrustedProxies are the addresses or CIDR ranges of the proxies whose X-Forwarded-For and X-Real-Ip headers are
+	// used for the client address and scheme, the headers of other requests are ignored
+	TrustedProxies []string
+
+	// EventBus selects the event bus backend: redis (default), redis-streams, nats or memory
+	EventBus      string
+	EventBusGroup string
+	NATSURL       string
 }
 
 // LoadSecrets loads secret config values from env vars
@@ -16,11 +46,24 @@ func (c *AppConfig) LoadSecrets() {
 	c.AppSecret = getEnv("APP_SECRET", c.AppSecret)
 	c.ReCaptchaSecret = getEnv("RECAPTCHA_SECRET", c.ReCaptchaSecret)
 	c.IPStackKey = getEnv("IPSTACK_KEY", c.IPStackKey)
+	c.JWTLegacySecret = getEnv("JWT_LEGACY_SECRET", c.JWTLegacySecret)
+	if c.JWTLegacySecret == "" {
+		c.JWTLegacySecret = c.AppSecret
+	}
+	c.APIKeySecret = getEnv("API_KEY_SECRET", c.APIKeySecret)
+	c.KeyEncryptionKey = getEnv("KEY_ENCRYPTION_KEY", c.KeyEncryptionKey)
 }
 
 // LoadEnv loads config values from env vars
 func (c *AppConfig) LoadEnv() {
 	c.Version = getEnv("VERSION", c.Version)
+	c.EventBus = getEnv("EVENT_BUS", c.EventBus)
+	c.EventBusGroup = getEnv("EVENT_BUS_GROUP", c.EventBusGroup)
+	c.NATSURL = getEnv("NATS_URL", c.NATSURL)
+	c.JWTAlgorithm = getEnv("JWT_ALGORITHM", c.JWTAlgorithm)
+	c.JWTKeyRotationDays = getEnvInt("JWT_KEY_ROTATION_DAYS", c.JWTKeyRotationDays)
+	c.JWTLegacyDisabled = getEnvBool("JWT_LEGACY_DISABLED", c.JWTLegacyDisabled)
+	c.TrustedProxies = getEnvList("TRUSTED_PROXIES", c.TrustedProxies)
 }
 
 func getEnv(key, fallback string) string {
@@ -30,3 +73,33 @@ func getEnv(key, fallback string) string {
 	}
 	return value
 }
+
+func getEnvList(key string, fallback []string) []string {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return fallback
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -19,12 +19,26 @@ type Datastore interface {
 	ProjectLogsDatastore
 	// Project sessions
 	ProjectSessionsDatastore
+	// Project email templates
+	ProjectEmailTemplatesDatastore
+	// Project identity providers
+	ProjectIdentityProvidersDatastore
+	// Project custom roles
+	ProjectRolesDatastore
+	// Project document ACLs
+	ProjectDocumentACLsDatastore
 	// Project WebHooks
 	ProjectHooksDatastore
+	// Project event outbox
+	ProjectEventsDatastore
 	// Projects
 	ProjectsDatastore
+	// JWT signing keys
+	SigningKeysDatastore
 	// Users
 	UsersDatastore
+	// Users multi-factor authentication
+	UsersMFADatastore
 	// Sessions
 	SessionsDatastore
 	// Tiers
@@ -2,6 +2,7 @@ package interfaces
 
 import (
 	"errors"
+	"time"
 
 	"github.com/machinable/machinable/dsi/models"
 )
@@ -9,8 +10,13 @@ import (
 // ProjectAPIKeysDatastore exposes functions to manage project api keys
 type ProjectAPIKeysDatastore interface {
 	GetAPIKeyByKey(projectID, hash string) (*models.ProjectAPIKey, error)
-	CreateAPIKey(projectID, hash, description string, read, write bool, role string) (*models.ProjectAPIKey, error)
-	UpdateAPIKey(projectID, keyID string, read, write bool, role string) error
+	GetAPIKeyByKeyID(projectID, keyID string) (*models.ProjectAPIKey, error)
+	GetAPIKey(projectID, id string) (*models.ProjectAPIKey, error)
+	CreateAPIKey(projectID string, key *models.ProjectAPIKey) (*models.ProjectAPIKey, error)
+	RotateAPIKey(projectID, keyID string, expires time.Time, replacement *models.ProjectAPIKey) (*models.ProjectAPIKey, error)
+	UpdateAPIKey(projectID, keyID string, key *models.ProjectAPIKey) error
+	UpdateAPIKeyLastUsed(projectID, keyID string, lastUsed time.Time) error
+	UpdateAPIKeyHash(projectID, keyID, hash string) error
 	ListAPIKeys(projectID string) ([]*models.ProjectAPIKey, error)
 	DeleteAPIKey(projectID, keyID string) error
 	DropProjectKeys(projectID string) error
@@ -18,12 +24,17 @@ type ProjectAPIKeysDatastore interface {
 
 // MockProjectAPIKeysDatastore mocks the datastore functions for ProjectAPIKeysDatastore testing
 type MockProjectAPIKeysDatastore struct {
-	GetAPIKeyByKeyFunc  func(projectID, hash string) (*models.ProjectAPIKey, error)
-	CreateAPIKeyFunc    func(projectID, hash, description string, read, write bool, role string) (*models.ProjectAPIKey, error)
-	UpdateAPIKeyFunc    func(projectID, keyID string, read, write bool, role string) error
-	ListAPIKeysFunc     func(projectID string) ([]*models.ProjectAPIKey, error)
-	DeleteAPIKeyFunc    func(projectID, keyID string) error
-	DropProjectKeysFunc func(projectID string) error
+	GetAPIKeyByKeyFunc       func(projectID, hash string) (*models.ProjectAPIKey, error)
+	GetAPIKeyByKeyIDFunc     func(projectID, keyID string) (*models.ProjectAPIKey, error)
+	GetAPIKeyFunc            func(projectID, id string) (*models.ProjectAPIKey, error)
+	CreateAPIKeyFunc         func(projectID string, key *models.ProjectAPIKey) (*models.ProjectAPIKey, error)
+	RotateAPIKeyFunc         func(projectID, keyID string, expires time.Time, replacement *models.ProjectAPIKey) (*models.ProjectAPIKey, error)
+	UpdateAPIKeyFunc         func(projectID, keyID string, key *models.ProjectAPIKey) error
+	UpdateAPIKeyLastUsedFunc func(projectID, keyID string, lastUsed time.Time) error
+	UpdateAPIKeyHashFunc     func(projectID, keyID, hash string) error
+	ListAPIKeysFunc          func(projectID string) ([]*models.ProjectAPIKey, error)
+	DeleteAPIKeyFunc         func(projectID, keyID string) error
+	DropProjectKeysFunc      func(projectID string) error
 }
 
 // GetAPIKeyByKey mock function, calls field if not nil
@@ -34,18 +45,58 @@ func (m *MockProjectAPIKeysDatastore) GetAPIKeyByKey(projectID, hash string) (*m
 	return nil, errors.New("not implemented")
 }
 
+// GetAPIKeyByKeyID mock function, calls field if not nil
+func (m *MockProjectAPIKeysDatastore) GetAPIKeyByKeyID(projectID, keyID string) (*models.ProjectAPIKey, error) {
+	if m.GetAPIKeyByKeyIDFunc != nil {
+		return m.GetAPIKeyByKeyIDFunc(projectID, keyID)
+	}
+	return nil, errors.New("not implemented")
+}
+
+// GetAPIKey mock function, calls field if not nil
+func (m *MockProjectAPIKeysDatastore) GetAPIKey(projectID, id string) (*models.ProjectAPIKey, error) {
+	if m.GetAPIKeyFunc != nil {
+		return m.GetAPIKeyFunc(projectID, id)
+	}
+	return nil, errors.New("not implemented")
+}
+
 // CreateAPIKey mock function, calls field if not nil
-func (m *MockProjectAPIKeysDatastore) CreateAPIKey(projectID, hash, description string, read, write bool, role string) (*models.ProjectAPIKey, error) {
+func (m *MockProjectAPIKeysDatastore) CreateAPIKey(projectID string, key *models.ProjectAPIKey) (*models.ProjectAPIKey, error) {
 	if m.CreateAPIKeyFunc != nil {
-		return m.CreateAPIKeyFunc(projectID, hash, description, read, write, role)
+		return m.CreateAPIKeyFunc(projectID, key)
+	}
+	return nil, errors.New("not implemented")
+}
+
+// RotateAPIKey mock function, calls field if not nil
+func (m *MockProjectAPIKeysDatastore) RotateAPIKey(projectID, keyID string, expires time.Time, replacement *models.ProjectAPIKey) (*models.ProjectAPIKey, error) {
+	if m.RotateAPIKeyFunc != nil {
+		return m.RotateAPIKeyFunc(projectID, keyID, expires, replacement)
 	}
 	return nil, errors.New("not implemented")
 }
 
 // UpdateAPIKey mock function, calls field if not nil
-func (m *MockProjectAPIKeysDatastore) UpdateAPIKey(projectID, keyID string, read, write bool, role string) error {
+func (m *MockProjectAPIKeysDatastore) UpdateAPIKey(projectID, keyID string, key *models.ProjectAPIKey) error {
 	if m.UpdateAPIKeyFunc != nil {
-		return m.UpdateAPIKeyFunc(projectID, keyID, read, write, role)
+		return m.UpdateAPIKeyFunc(projectID, keyID, key)
+	}
+	return errors.New("not implemented")
+}
+
+// UpdateAPIKeyLastUsed mock function, calls field if not nil
+func (m *MockProjectAPIKeysDatastore) UpdateAPIKeyLastUsed(projectID, keyID string, lastUsed time.Time) error {
+	if m.UpdateAPIKeyLastUsedFunc != nil {
+		return m.UpdateAPIKeyLastUsedFunc(projectID, keyID, lastUsed)
+	}
+	return errors.New("not implemented")
+}
+
+// UpdateAPIKeyHash mock function, calls field if not nil
+func (m *MockProjectAPIKeysDatastore) UpdateAPIKeyHash(projectID, keyID, hash string) error {
+	if m.UpdateAPIKeyHashFunc != nil {
+		return m.UpdateAPIKeyHashFunc(projectID, keyID, hash)
 	}
 	return errors.New("not implemented")
 }
@@ -0,0 +1,45 @@
+package interfaces
+
+import (
+	"errors"
+
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// ProjectDocumentACLsDatastore exposes functions to share the documents of project resources with users and groups
+type ProjectDocumentACLsDatastore interface {
+	ListDocumentACL(projectID, pathName, documentID string) ([]*models.DocumentACL, error)
+	GrantDocumentAccess(projectID string, acl *models.DocumentACL) error
+	RevokeDocumentAccess(projectID, pathName, documentID, principalType, principalID string) error
+}
+
+// MockProjectDocumentACLsDatastore mocks the datastore functions for ProjectDocumentACLsDatastore testing
+type MockProjectDocumentACLsDatastore struct {
+	ListDocumentACLFunc      func(projectID, pathName, documentID string) ([]*models.DocumentACL, error)
+	GrantDocumentAccessFunc  func(projectID string, acl *models.DocumentACL) error
+	RevokeDocumentAccessFunc func(projectID, pathName, documentID, principalType, principalID string) error
+}
+
+// ListDocumentACL mock function, calls field if not nil
+func (m *MockProjectDocumentACLsDatastore) ListDocumentACL(projectID, pathName, documentID string) ([]*models.DocumentACL, error) {
+	if m.ListDocumentACLFunc != nil {
+		return m.ListDocumentACLFunc(projectID, pathName, documentID)
+	}
+	return nil, errors.New("not implemented")
+}
+
+// GrantDocumentAccess mock function, calls field if not nil
+func (m *MockProjectDocumentACLsDatastore) GrantDocumentAccess(projectID string, acl *models.DocumentACL) error {
+	if m.GrantDocumentAccessFunc != nil {
+		return m.GrantDocumentAccessFunc(projectID, acl)
+	}
+	return errors.New("not implemented")
+}
+
+// RevokeDocumentAccess mock function, calls field if not nil
+func (m *MockProjectDocumentACLsDatastore) RevokeDocumentAccess(projectID, pathName, documentID, principalType, principalID string) error {
+	if m.RevokeDocumentAccessFunc != nil {
+		return m.RevokeDocumentAccessFunc(projectID, pathName, documentID, principalType, principalID)
+	}
+	return errors.New("not implemented")
+}
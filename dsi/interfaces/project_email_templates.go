@@ -0,0 +1,12 @@
+package interfaces
+
+import "github.com/machinable/machinable/dsi/models"
+
+// ProjectEmailTemplatesDatastore exposes functions to manage the email templates of project user flows
+type ProjectEmailTemplatesDatastore interface {
+	ListEmailTemplates(projectID string) ([]*models.EmailTemplate, error)
+	GetEmailTemplate(projectID, name string) (*models.EmailTemplate, error)
+	SaveEmailTemplate(projectID string, template *models.EmailTemplate) error
+	DeleteEmailTemplate(projectID, name string) error
+	DropProjectEmailTemplates(projectID string) error
+}
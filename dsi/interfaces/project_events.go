@@ -0,0 +1,17 @@
+package interfaces
+
+import (
+	"time"
+
+	"github.com/machinable/machinable/dsi/errors"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// ProjectEventsDatastore exposes functions to the transactional event outbox
+type ProjectEventsDatastore interface {
+	ClaimOutboxEvents(limit int, lease time.Duration) ([]*models.OutboxEvent, *errors.DatastoreError)
+	MarkOutboxEventPublished(eventID string) *errors.DatastoreError
+	DeadLetterOutboxEvents() (int64, *errors.DatastoreError)
+	DropProjectOutboxEvents(projectID string) *errors.DatastoreError
+	PurgePublishedOutboxEvents(before time.Time) (int64, *errors.DatastoreError)
+}
@@ -0,0 +1,17 @@
+package interfaces
+
+import "github.com/machinable/machinable/dsi/models"
+
+// ProjectIdentityProvidersDatastore exposes functions to manage the external identity providers of a project and the
+// identities linked to project users
+type ProjectIdentityProvidersDatastore interface {
+	ListIdentityProviders(projectID string) ([]*models.IdentityProvider, error)
+	GetIdentityProvider(projectID, name string) (*models.IdentityProvider, error)
+	SaveIdentityProvider(projectID string, provider *models.IdentityProvider) error
+	DeleteIdentityProvider(projectID, name string) error
+	DropProjectIdentityProviders(projectID string) error
+
+	GetUserIdentity(projectID, provider, subject string) (*models.UserIdentity, error)
+	CreateUserIdentity(projectID string, identity *models.UserIdentity) error
+	DeleteUserIdentity(projectID, identityID string) error
+}
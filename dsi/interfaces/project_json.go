@@ -11,7 +11,12 @@ type ProjectJSONDatastore interface {
 	DeleteRootKey(projectID, rootKey string) error
 
 	GetJSONKey(projectID, rootKey string, keys ...string) ([]byte, error)
-	CreateJSONKey(projectID, rootKey string, data []byte, keys ...string) error
-	UpdateJSONKey(projectID, rootKey string, data []byte, keys ...string) error
-	DeleteJSONKey(projectID, rootKey string, keys ...string) error
+	ListJSONChildren(projectID, rootKey string, query *models.JSONChildQuery, keys ...string) ([]*models.JSONChild, error)
+	CreateJSONKey(projectID, rootKey string, data []byte, metadata *models.MetaData, event *models.OutboxEvent, keys ...string) error
+	UpdateJSONKey(projectID, rootKey string, data []byte, metadata *models.MetaData, event *models.OutboxEvent, keys ...string) error
+	DeleteJSONKey(projectID, rootKey string, metadata *models.MetaData, event *models.OutboxEvent, keys ...string) error
+	ApplyJSONOperation(projectID, rootKey string, op *models.JSONOperation, validate models.JSONValueValidator, metadata *models.MetaData, event *models.OutboxEvent, keys ...string) ([]byte, error)
+
+	ListJSONVersions(projectID, rootKey string, limit, offset int64) ([]*models.JSONVersion, error)
+	RestoreJSONVersion(projectID, rootKey string, version int64, metadata *models.MetaData, event *models.OutboxEvent) ([]byte, error)
 }
@@ -1,6 +1,10 @@
 package interfaces
 
-import "github.com/machinable/machinable/dsi/models"
+import (
+	"time"
+
+	"github.com/machinable/machinable/dsi/models"
+)
 
 // ProjectLogsDatastore exposes functions to the project access logs
 type ProjectLogsDatastore interface {
@@ -8,4 +12,5 @@ type ProjectLogsDatastore interface {
 	ListProjectLogs(projectID string, limit, offset int64, filter *models.Filters, sort map[string]int) ([]*models.Log, error)
 	CountProjectLogs(projectID string, filter *models.Filters) (int64, error)
 	DropProjectLogs(projectID string) error
+	PurgeProjectLogs(before time.Time) (int64, error)
 }
@@ -0,0 +1,75 @@
+package interfaces
+
+import (
+	"errors"
+
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// ProjectRolesDatastore exposes functions to manage the custom roles of a project
+type ProjectRolesDatastore interface {
+	ListRoles(projectID string) ([]*models.Role, error)
+	GetRole(projectID, name string) (*models.Role, error)
+	CreateRole(projectID string, role *models.Role) error
+	UpdateRole(projectID, name string, role *models.Role) error
+	DeleteRole(projectID, name string) error
+	DropProjectRoles(projectID string) error
+}
+
+// MockProjectRolesDatastore mocks the datastore functions for ProjectRolesDatastore testing
+type MockProjectRolesDatastore struct {
+	ListRolesFunc        func(projectID string) ([]*models.Role, error)
+	GetRoleFunc          func(projectID, name string) (*models.Role, error)
+	CreateRoleFunc       func(projectID string, role *models.Role) error
+	UpdateRoleFunc       func(projectID, name string, role *models.Role) error
+	DeleteRoleFunc       func(projectID, name string) error
+	DropProjectRolesFunc func(projectID string) error
+}
+
+// ListRoles mock function, calls field if not nil
+func (m *MockProjectRolesDatastore) ListRoles(projectID string) ([]*models.Role, error) {
+	if m.ListRolesFunc != nil {
+		return m.ListRolesFunc(projectID)
+	}
+	return nil, errors.New("not implemented")
+}
+
+// GetRole mock function, calls field if not nil
+func (m *MockProjectRolesDatastore) GetRole(projectID, name string) (*models.Role, error) {
+	if m.GetRoleFunc != nil {
+		return m.GetRoleFunc(projectID, name)
+	}
+	return nil, errors.New("not implemented")
+}
+
+// CreateRole mock function, calls field if not nil
+func (m *MockProjectRolesDatastore) CreateRole(projectID string, role *models.Role) error {
+	if m.CreateRoleFunc != nil {
+		return m.CreateRoleFunc(projectID, role)
+	}
+	return errors.New("not implemented")
+}
+
+// UpdateRole mock function, calls field if not nil
+func (m *MockProjectRolesDatastore) UpdateRole(projectID, name string, role *models.Role) error {
+	if m.UpdateRoleFunc != nil {
+		return m.UpdateRoleFunc(projectID, name, role)
+	}
+	return errors.New("not implemented")
+}
+
+// DeleteRole mock function, calls field if not nil
+func (m *MockProjectRolesDatastore) DeleteRole(projectID, name string) error {
+	if m.DeleteRoleFunc != nil {
+		return m.DeleteRoleFunc(projectID, name)
+	}
+	return errors.New("not implemented")
+}
+
+// DropProjectRoles mock function, calls field if not nil
+func (m *MockProjectRolesDatastore) DropProjectRoles(projectID string) error {
+	if m.DropProjectRolesFunc != nil {
+		return m.DropProjectRolesFunc(projectID)
+	}
+	return errors.New("not implemented")
+}
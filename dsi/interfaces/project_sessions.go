@@ -10,8 +10,11 @@ import (
 type ProjectSessionsDatastore interface {
 	CreateSession(projectID string, session *models.Session) error
 	UpdateProjectSessionLastAccessed(projectID, sessionID string, lastAccessed time.Time) error
+	RotateRefreshToken(projectID, sessionID, currentID, nextID string, expires time.Time) (bool, error)
 	GetSession(projectID, sessionID string) (*models.Session, error)
 	ListSessions(projectID string) ([]*models.Session, error)
 	DeleteSession(projectID, sessionID string) error
+	DeleteUserSessions(projectID, userID string) error
 	DropProjectSessions(projectID string) error
+	PurgeProjectSessions(before time.Time) (int64, error)
 }
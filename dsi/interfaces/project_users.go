@@ -6,8 +6,12 @@ import "github.com/machinable/machinable/dsi/models"
 type ProjectUsersDatastore interface {
 	GetUserByUsername(projectID, userName string) (*models.ProjectUser, error)
 	GetUserByID(projectID, userID string) (*models.ProjectUser, error)
+	GetUserByEmail(projectID, email string) (*models.ProjectUser, error)
 	CreateUser(projectID string, user *models.ProjectUser) error
 	UpdateUser(projectID, userID string, user *models.ProjectUser) error
+	ActivateProjectUser(projectID, userID string, active bool) error
+	VerifyProjectUserEmail(projectID, userID string) error
+	UpdateProjectUserPassword(projectID, userID, passwordHash string) error
 	ListUsers(projectID string) ([]*models.ProjectUser, error)
 	DeleteUser(projectID, userID string) error
 	DropProjectUsers(projectID string) error
@@ -1,6 +1,8 @@
 package interfaces
 
 import (
+	"time"
+
 	"github.com/machinable/machinable/dsi/errors"
 	"github.com/machinable/machinable/dsi/models"
 )
@@ -15,4 +17,5 @@ type ProjectHooksDatastore interface {
 
 	AddResult(result *models.HookResult) *errors.DatastoreError
 	ListResults(projectID, hookID string) ([]*models.HookResult, *errors.DatastoreError)
+	PurgeResults(before time.Time) (int64, *errors.DatastoreError)
 }
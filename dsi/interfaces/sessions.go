@@ -10,7 +10,9 @@ import (
 type SessionsDatastore interface {
 	CreateAppSession(session *models.Session) error
 	UpdateAppSessionLastAccessed(sessionID string, lastAccessed time.Time) error
+	RotateAppRefreshToken(sessionID, currentID, nextID string, expires time.Time) (bool, error)
 	ListUserSessions(userID string) ([]*models.Session, error)
 	GetAppSession(sessionID string) (*models.Session, error)
 	DeleteAppSession(sessionID string) error
+	PurgeAppSessions(before time.Time) (int64, error)
 }
@@ -0,0 +1,17 @@
+package interfaces
+
+import (
+	"time"
+
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// SigningKeysDatastore exposes functions to manage the JWT signing keys of projects and application users. An empty
+// project ID refers to the keys of application users.
+type SigningKeysDatastore interface {
+	ListSigningKeys(projectID string) ([]*models.SigningKey, error)
+	GetSigningKey(kid string) (*models.SigningKey, error)
+	CreateSigningKey(key *models.SigningKey) (bool, error)
+	DropProjectSigningKeys(projectID string) error
+	PurgeSigningKeys(before time.Time) (int64, error)
+}
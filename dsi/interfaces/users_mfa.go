@@ -0,0 +1,17 @@
+package interfaces
+
+import "github.com/machinable/machinable/dsi/models"
+
+// UsersMFADatastore exposes functions to manage the multi-factor authentication of application users
+type UsersMFADatastore interface {
+	GetUserMFA(userID string) (*models.UserMFA, error)
+	SaveTOTPSecret(userID, secret string) error
+	EnableTOTP(userID string, step int64) error
+	DisableTOTP(userID string) error
+	UseTOTPStep(userID string, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID string, hashes []string) error
+	UseRecoveryCode(userID, hash string) (bool, error)
+	AddWebAuthnCredential(userID string, credential *models.WebAuthnCredential) error
+	UpdateWebAuthnSignCount(userID, credentialID string, signCount uint32) error
+	DeleteWebAuthnCredential(userID, credentialID string) error
+}
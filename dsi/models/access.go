@@ -4,16 +4,22 @@ import (
 	"time"
 )
 
-// ProjectAPIKey is a static key used to access resources and collections of the project
+// ProjectAPIKey is a static key used to access resources and collections of the project. Keys with scopes can only
+// access the scoped resources and root keys, keys with allowed IPs can only be used from those networks.
 type ProjectAPIKey struct {
-	ID          string    `json:"id"`
-	ProjectID   string    `json:"project_id"`
-	KeyHash     string    `json:"-"`
-	Created     time.Time `json:"created"`
-	Description string    `json:"description"`
-	Read        bool      `json:"read"`
-	Write       bool      `json:"write"`
-	Role        string    `json:"role"`
+	ID          string       `json:"id"`
+	ProjectID   string       `json:"project_id"`
+	KeyID       string       `json:"key_id"` // KeyID is embedded in prefixed keys, legacy keys have no key ID
+	KeyHash     string       `json:"-"`
+	Created     time.Time    `json:"created"`
+	Description string       `json:"description"`
+	Read        bool         `json:"read"`
+	Write       bool         `json:"write"`
+	Role        string       `json:"role"`
+	Scopes      APIKeyScopes `json:"scopes"`
+	AllowedIPs  []string     `json:"allowed_ips"` // AllowedIPs are CIDR ranges, the key can be used from any address if empty
+	Expires     *time.Time   `json:"expires"`
+	LastUsed    *time.Time   `json:"last_used"`
 }
 
 // ProjectUser is a user of a project. A user can access resources and collections of the project.
@@ -27,4 +33,12 @@ type ProjectUser struct {
 	Read         bool      `json:"read"`
 	Write        bool      `json:"write"`
 	Role         string    `json:"role"`
+	Active       bool      `json:"active"` // Active is false until a registered user verifies their email, if required
+	// EmailVerified is set once the user verifies their email with the project's verification code, identities of
+	// external providers are only linked to users with a verified email
+	EmailVerified bool `json:"email_verified"`
+	// Attributes are set by app users and compared with documents by the conditions of custom roles, e.g. `team_id`
+	Attributes map[string]interface{} `json:"attributes"`
+	// Groups are set by app users, documents can be shared with the users of a group
+	Groups []string `json:"groups"`
 }
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// API key scope verbs
+const (
+	VerbRead   = "read"
+	VerbCreate = "create"
+	VerbUpdate = "update"
+	VerbDelete = "delete"
+)
+
+var scopeVerbs = map[string]bool{VerbRead: true, VerbCreate: true, VerbUpdate: true, VerbDelete: true}
+
+// ErrAPIKeyExists is returned if a key is created with the key ID of another key of the project
+var ErrAPIKeyExists = errors.New("api key already exists")
+
+// APIKeyScope grants an API key the verbs on either a resource or a JSON root key
+type APIKeyScope struct {
+	Resource string   `json:"resource,omitempty"` // Resource is the path name of a resource definition
+	RootKey  string   `json:"root_key,omitempty"` // RootKey is the key of a JSON root key
+	Verbs    []string `json:"verbs"`
+}
+
+// APIKeyScopes are the scopes of an API key, an API key without scopes can access all resources and root keys
+type APIKeyScopes []APIKeyScope
+
+// ScopeVerb returns the scope verb of the HTTP method
+func ScopeVerb(method string) string {
+	switch method {
+	case "GET", "HEAD":
+		return VerbRead
+	case "POST":
+		return VerbCreate
+	case "PUT", "PATCH":
+		return VerbUpdate
+	case "DELETE":
+		return VerbDelete
+	}
+	return ""
+}
+
+// allows returns true if the scope includes the verb of the HTTP method
+func (s *APIKeyScope) allows(method string) bool {
+	verb := ScopeVerb(method)
+	for _, v := range s.Verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsResource returns true if the scopes grant the HTTP method on the resource
+func (s APIKeyScopes) AllowsResource(pathName, method string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for i := range s {
+		if s[i].Resource == pathName && s[i].allows(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsRootKey returns true if the scopes grant the HTTP method on the JSON root key
+func (s APIKeyScopes) AllowsRootKey(rootKey, method string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for i := range s {
+		if s[i].RootKey == rootKey && s[i].allows(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks each scope names exactly one resource or root key and only known verbs
+func (s APIKeyScopes) Validate() error {
+	for _, scope := range s {
+		if (scope.Resource == "") == (scope.RootKey == "") {
+			return errors.New("a scope must have either a resource or a root_key")
+		}
+		if len(scope.Verbs) == 0 {
+			return errors.New("a scope must have at least one verb")
+		}
+		for _, verb := range scope.Verbs {
+			if !scopeVerbs[verb] {
+				return fmt.Errorf("invalid scope verb '%s', must be one of 'read', 'create', 'update' or 'delete'", verb)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateAllowedIPs checks the allowed IPs are CIDR ranges or single addresses, single addresses are converted to
+// ranges of one address
+func (k *ProjectAPIKey) ValidateAllowedIPs() error {
+	for i, ip := range k.AllowedIPs {
+		if !strings.Contains(ip, "/") {
+			parsed := net.ParseIP(ip)
+			if parsed == nil {
+				return fmt.Errorf("invalid allowed ip '%s', must be an address or CIDR range", ip)
+			}
+			if parsed.To4() != nil {
+				k.AllowedIPs[i] = ip + "/32"
+			} else {
+				k.AllowedIPs[i] = ip + "/128"
+			}
+			continue
+		}
+		if _, _, err := net.ParseCIDR(ip); err != nil {
+			return fmt.Errorf("invalid allowed ip '%s', must be an address or CIDR range", ip)
+		}
+	}
+	return nil
+}
+
+// AllowsIP returns true if the key can be used from the IP address
+func (k *ProjectAPIKey) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, cidr := range k.AllowedIPs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired returns true if the key has an expiration before the time
+func (k *ProjectAPIKey) Expired(now time.Time) bool {
+	return k.Expires != nil && !now.Before(*k.Expires)
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	scopes := APIKeyScopes{
+		{Resource: "posts", Verbs: []string{VerbRead, VerbCreate}},
+		{RootKey: "settings", Verbs: []string{VerbRead}},
+	}
+
+	tables := []struct {
+		name     string
+		resource string
+		rootKey  string
+		method   string
+		allowed  bool
+	}{
+		{"read resource", "posts", "", "GET", true},
+		{"create resource", "posts", "", "POST", true},
+		{"delete resource", "posts", "", "DELETE", false},
+		{"other resource", "comments", "", "GET", false},
+		{"read root key", "", "settings", "GET", true},
+		{"update root key", "", "settings", "PUT", false},
+		{"root key is not a resource", "settings", "", "GET", false},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.resource != "" {
+				assert.Equal(t, tt.allowed, scopes.AllowsResource(tt.resource, tt.method))
+			} else {
+				assert.Equal(t, tt.allowed, scopes.AllowsRootKey(tt.rootKey, tt.method))
+			}
+		})
+	}
+
+	// keys without scopes access everything
+	assert.True(t, APIKeyScopes{}.AllowsResource("comments", "DELETE"))
+	assert.True(t, APIKeyScopes(nil).AllowsRootKey("settings", "PUT"))
+
+	assert.EqualError(t, APIKeyScopes{{Resource: "posts", RootKey: "settings", Verbs: []string{VerbRead}}}.Validate(), "a scope must have either a resource or a root_key")
+	assert.EqualError(t, APIKeyScopes{{Resource: "posts"}}.Validate(), "a scope must have at least one verb")
+	assert.Nil(t, scopes.Validate())
+}
+
+func TestAPIKeyAllowsIP(t *testing.T) {
+	key := &ProjectAPIKey{AllowedIPs: []string{"10.1.0.0/16", "192.168.1.4", "2001:db8::1"}}
+	assert.Nil(t, key.ValidateAllowedIPs())
+	assert.Equal(t, []string{"10.1.0.0/16", "192.168.1.4/32", "2001:db8::1/128"}, key.AllowedIPs)
+
+	assert.True(t, key.AllowsIP("10.1.20.3"))
+	assert.True(t, key.AllowsIP("192.168.1.4"))
+	assert.True(t, key.AllowsIP("2001:db8::1"))
+	assert.False(t, key.AllowsIP("10.2.0.1"))
+	assert.False(t, key.AllowsIP("192.168.1.5"))
+	assert.False(t, key.AllowsIP(""))
+
+	assert.True(t, (&ProjectAPIKey{}).AllowsIP("8.8.8.8"))
+
+	invalid := &ProjectAPIKey{AllowedIPs: []string{"10.0.0.300"}}
+	assert.EqualError(t, invalid.ValidateAllowedIPs(), "invalid allowed ip '10.0.0.300', must be an address or CIDR range")
+}
+
+func TestAPIKeyExpired(t *testing.T) {
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	key := &ProjectAPIKey{Expires: &expires}
+
+	assert.False(t, key.Expired(now))
+	assert.True(t, key.Expired(expires))
+	assert.False(t, (&ProjectAPIKey{}).Expired(now))
+}
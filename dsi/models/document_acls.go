@@ -0,0 +1,70 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	// PrincipalUser is the principal type of an ACL entry shared with a project user or api key, by its id
+	PrincipalUser = "user"
+	// PrincipalGroup is the principal type of an ACL entry shared with the project users of a group, by its name
+	PrincipalGroup = "group"
+
+	// FilterDocumentAccess is the authorization filter key of the documents created by or shared with the requester,
+	// its value is a `*DocumentAccess`
+	FilterDocumentAccess = "_metadata.access"
+)
+
+var groupName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// ValidGroupName returns true if the name can be used as a group of project users
+func ValidGroupName(name string) bool {
+	return groupName.MatchString(name)
+}
+
+// DocumentACL shares a document of a resource with a project user or group, in addition to its creator
+type DocumentACL struct {
+	ResourcePath  string    `json:"resource_path"`
+	DocumentID    string    `json:"document_id"`
+	PrincipalType string    `json:"principal_type"`
+	PrincipalID   string    `json:"principal_id"`
+	Read          bool      `json:"read"`
+	Write         bool      `json:"write"`
+	Created       time.Time `json:"created"`
+}
+
+// Validate checks the principal of the entry and that it grants access, write access includes read access
+func (acl *DocumentACL) Validate() error {
+	switch acl.PrincipalType {
+	case PrincipalUser:
+		if acl.PrincipalID == "" {
+			return errors.New("principal_id cannot be empty")
+		}
+	case PrincipalGroup:
+		if !ValidGroupName(acl.PrincipalID) {
+			return fmt.Errorf("invalid group '%s'", acl.PrincipalID)
+		}
+	default:
+		return fmt.Errorf("invalid principal_type '%s', must be '%s' or '%s'", acl.PrincipalType, PrincipalUser, PrincipalGroup)
+	}
+
+	if acl.Write {
+		acl.Read = true
+	}
+	if !acl.Read {
+		return errors.New("an entry must grant read or write access")
+	}
+
+	return nil
+}
+
+// DocumentAccess filters the documents of a resource to those created by the user, or shared with the user or one of
+// its groups. Write access is required for updates.
+type DocumentAccess struct {
+	UserID string
+	Groups []string
+	Write  bool
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocumentACLValidate(t *testing.T) {
+	tables := []struct {
+		name  string
+		acl   DocumentACL
+		valid bool
+		read  bool
+	}{
+		{"user read", DocumentACL{PrincipalType: PrincipalUser, PrincipalID: "u1", Read: true}, true, true},
+		{"write includes read", DocumentACL{PrincipalType: PrincipalGroup, PrincipalID: "editors", Write: true}, true, true},
+		{"no access", DocumentACL{PrincipalType: PrincipalUser, PrincipalID: "u1"}, false, false},
+		{"empty user", DocumentACL{PrincipalType: PrincipalUser, Read: true}, false, true},
+		{"invalid group", DocumentACL{PrincipalType: PrincipalGroup, PrincipalID: "my editors", Read: true}, false, true},
+		{"invalid principal type", DocumentACL{PrincipalType: "role", PrincipalID: "admin", Read: true}, false, true},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.acl.Validate()
+			if tt.valid {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+			assert.Equal(t, tt.read, tt.acl.Read)
+		})
+	}
+}
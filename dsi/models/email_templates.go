@@ -0,0 +1,133 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// EmailTemplateVerification is sent to registered project users to verify their email
+	EmailTemplateVerification = "verification"
+	// EmailTemplatePasswordReset is sent to project users who forgot their password
+	EmailTemplatePasswordReset = "password_reset"
+)
+
+// maxEmailTemplateLength limits the subject, body and url of a template
+const maxEmailTemplateLength = 8192
+
+// defaultEmailTemplates are used by projects which have not customized the template
+var defaultEmailTemplates = map[string]EmailTemplate{
+	EmailTemplateVerification: {
+		Name:    EmailTemplateVerification,
+		Subject: "Verify your {{.Project}} email address",
+		Body:    "Hi {{.Name}},\n\nVerify your {{.Project}} email address with the code {{.Code}}{{if .URL}} or by following this link: {{.URL}}{{end}}\n\nThe code expires in {{.Expires}}.",
+	},
+	EmailTemplatePasswordReset: {
+		Name:    EmailTemplatePasswordReset,
+		Subject: "Reset your {{.Project}} password",
+		Body:    "Hi {{.Name}},\n\nReset your {{.Project}} password with the token {{.Code}}{{if .URL}} or by following this link: {{.URL}}{{end}}\n\nThe token expires in {{.Expires}}. If you did not request a password reset, you can ignore this email.",
+	},
+}
+
+// EmailTemplate is the subject and plain text body of an email sent to project users. Both are Go templates with the
+// fields of `EmailTemplateData`.
+type EmailTemplate struct {
+	ID        string    `json:"id"`
+	ProjectID string    `json:"project_id"`
+	Name      string    `json:"name"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	URL       string    `json:"url"` // URL is the link of the project's application, `{{.Code}}` is replaced with the code
+	Default   bool      `json:"default"`
+	Updated   time.Time `json:"updated"`
+}
+
+// EmailTemplateData is the data available to email templates
+type EmailTemplateData struct {
+	Project string // Project is the name of the project
+	Name    string // Name is the username of the receiver
+	Code    string // Code is the verification code or password reset token
+	URL     string // URL is the rendered URL of the template, empty if there is none
+	Expires string // Expires is the lifetime of the code, i.e. "1 hour"
+}
+
+// DefaultEmailTemplate returns the default template of the name, nil if there is no template with the name
+func DefaultEmailTemplate(name string) *EmailTemplate {
+	t, ok := defaultEmailTemplates[name]
+	if !ok {
+		return nil
+	}
+	t.Default = true
+	return &t
+}
+
+// Validate checks the name of the template, and that the subject, body and url are valid templates
+func (t *EmailTemplate) Validate() error {
+	if _, ok := defaultEmailTemplates[t.Name]; !ok {
+		return fmt.Errorf("invalid template name, must be one of '%s' or '%s'", EmailTemplateVerification, EmailTemplatePasswordReset)
+	}
+	if strings.TrimSpace(t.Subject) == "" || strings.TrimSpace(t.Body) == "" {
+		return errors.New("template subject and body cannot be empty")
+	}
+	if len(t.Subject) > maxEmailTemplateLength || len(t.Body) > maxEmailTemplateLength || len(t.URL) > maxEmailTemplateLength {
+		return fmt.Errorf("template subject, body and url cannot be longer than %d characters", maxEmailTemplateLength)
+	}
+
+	// render example data to catch unknown fields
+	if _, _, _, err := t.Render(&EmailTemplateData{Code: "code"}); err != nil {
+		return err
+	}
+
+	if t.URL != "" {
+		u, err := url.Parse(strings.Replace(t.URL, "{{.Code}}", "code", -1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("template url must be an absolute http or https url")
+		}
+	}
+
+	return nil
+}
+
+// Render returns the subject, body and url of the email for the data. The url is rendered first, so the body can
+// link to it.
+func (t *EmailTemplate) Render(data *EmailTemplateData) (string, string, string, error) {
+	link := ""
+	if t.URL != "" {
+		link = strings.Replace(t.URL, "{{.Code}}", url.QueryEscape(data.Code), -1)
+	}
+
+	d := *data
+	d.URL = link
+
+	subject, err := renderEmailTemplate("subject", t.Subject, &d)
+	if err != nil {
+		return "", "", "", err
+	}
+	body, err := renderEmailTemplate("body", t.Body, &d)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	// the subject is a single header line
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(strings.TrimSpace(subject))
+
+	return subject, body, link, nil
+}
+
+func renderEmailTemplate(name, text string, data *EmailTemplateData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %s: %s", name, err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid template %s: %s", name, err.Error())
+	}
+	return buf.String(), nil
+}
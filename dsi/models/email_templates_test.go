@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailTemplateRender(t *testing.T) {
+	template := &EmailTemplate{
+		Name:    EmailTemplatePasswordReset,
+		Subject: "Reset your\n{{.Project}} password",
+		Body:    "Hi {{.Name}}, open {{.URL}} within {{.Expires}}",
+		URL:     "https://app.example.com/reset?token={{.Code}}",
+	}
+
+	subject, body, link, err := template.Render(&EmailTemplateData{Project: "Blog", Name: "alice", Code: "a b", Expires: "1 hour"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Reset your Blog password", subject)
+	assert.Equal(t, "https://app.example.com/reset?token=a+b", link)
+	assert.Equal(t, "Hi alice, open https://app.example.com/reset?token=a+b within 1 hour", body)
+
+	// the default templates render without a url
+	for _, name := range []string{EmailTemplateVerification, EmailTemplatePasswordReset} {
+		_, body, link, err := DefaultEmailTemplate(name).Render(&EmailTemplateData{Project: "Blog", Name: "alice", Code: "123", Expires: "24 hours"})
+		assert.Nil(t, err)
+		assert.Empty(t, link)
+		assert.Contains(t, body, "123")
+		assert.NotContains(t, body, "link")
+	}
+}
+
+func TestEmailTemplateValidate(t *testing.T) {
+	tables := []struct {
+		name     string
+		template EmailTemplate
+		valid    bool
+	}{
+		{"default", *DefaultEmailTemplate(EmailTemplateVerification), true},
+		{"url", EmailTemplate{Name: EmailTemplateVerification, Subject: "Verify", Body: "{{.URL}}", URL: "https://example.com/verify/{{.Code}}"}, true},
+		{"unknown name", EmailTemplate{Name: "welcome", Subject: "Hi", Body: "Hi"}, false},
+		{"empty body", EmailTemplate{Name: EmailTemplateVerification, Subject: "Verify", Body: " "}, false},
+		{"unknown field", EmailTemplate{Name: EmailTemplateVerification, Subject: "Verify", Body: "{{.Password}}"}, false},
+		{"syntax", EmailTemplate{Name: EmailTemplateVerification, Subject: "{{.Code", Body: "Verify"}, false},
+		{"relative url", EmailTemplate{Name: EmailTemplateVerification, Subject: "Verify", Body: "Verify", URL: "/verify/{{.Code}}"}, false},
+		{"javascript url", EmailTemplate{Name: EmailTemplateVerification, Subject: "Verify", Body: "Verify", URL: "javascript:alert(1)"}, false},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.template.Validate()
+			assert.Equal(t, tt.valid, err == nil, "%v", err)
+		})
+	}
+
+	assert.Nil(t, DefaultEmailTemplate("welcome"))
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+const (
+	// ActionCreate is the event action for a newly created document or key
+	ActionCreate = "create"
+	// ActionEdit is the event action for an updated document or key
+	ActionEdit = "edit"
+	// ActionDelete is the event action for a deleted document or key
+	ActionDelete = "delete"
+)
+
+// OutboxEvent is a change event that is recorded in the same transaction as the write that produced it. Outbox events
+// are relayed to the hook queue after the transaction has been committed.
+type OutboxEvent struct {
+	ID          string    `json:"id"`
+	ProjectID   string    `json:"project_id"`
+	Entity      string    `json:"entity"` // resource, json
+	EntityKey   string    `json:"entity_key"`
+	EntityID    string    `json:"entity_id"`
+	Action      string    `json:"action"` // create, edit, delete
+	Keys        []string  `json:"keys"`
+	Payload     []byte    `json:"payload"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"` // claims before the event is dead lettered
+	Created     time.Time `json:"created"`
+}
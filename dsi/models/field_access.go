@@ -0,0 +1,177 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// PropertyReadRoles is the schema keyword of a property listing the roles which can read it
+	PropertyReadRoles = "read_roles"
+	// PropertyWriteRoles is the schema keyword of a property listing the roles which can write it
+	PropertyWriteRoles = "write_roles"
+)
+
+// fieldAccessAdmin is the role which can read and write every property
+const fieldAccessAdmin = "admin"
+
+// FieldAccess holds the properties of a resource a role cannot read or write. Properties without `read_roles` or
+// `write_roles` can be read and written by every role.
+type FieldAccess struct {
+	Hidden    map[string]bool
+	Protected map[string]bool
+}
+
+// FieldAccess returns the properties of the schema the role cannot read or write, the `admin` role has access to every
+// property
+func (s *JSONSchemaObject) FieldAccess(role string) *FieldAccess {
+	access := &FieldAccess{Hidden: map[string]bool{}, Protected: map[string]bool{}}
+	if role == fieldAccessAdmin {
+		return access
+	}
+
+	for name, property := range s.Properties {
+		if roles, ok := propertyRoles(property, PropertyReadRoles); ok && !containsRole(roles, role) {
+			access.Hidden[name] = true
+		}
+		if roles, ok := propertyRoles(property, PropertyWriteRoles); ok && !containsRole(roles, role) {
+			access.Protected[name] = true
+		}
+	}
+
+	return access
+}
+
+// Restricted returns true if the role cannot read or write some of the properties
+func (a *FieldAccess) Restricted() bool {
+	return len(a.Hidden) > 0 || len(a.Protected) > 0
+}
+
+// Strip removes the hidden properties from the document
+func (a *FieldAccess) Strip(document map[string]interface{}) {
+	for name := range a.Hidden {
+		delete(document, name)
+	}
+}
+
+// Written returns the sorted protected properties set by the fields of a write
+func (a *FieldAccess) Written(fields map[string]interface{}) []string {
+	written := make([]string, 0)
+	for name := range fields {
+		if a.Protected[name] {
+			written = append(written, name)
+		}
+	}
+	sort.Strings(written)
+	return written
+}
+
+// WriteError returns an error if the fields of a write include properties protected from the role
+func (a *FieldAccess) WriteError(role string, fields map[string]interface{}) error {
+	if written := a.Written(fields); len(written) > 0 {
+		return fmt.Errorf("role '%s' cannot write '%s'", role, strings.Join(written, "', '"))
+	}
+	return nil
+}
+
+// Preserve copies the hidden and protected properties of the existing document which are not in the fields of an
+// update, so documents replaced by a role keep the values it cannot see or write
+func (a *FieldAccess) Preserve(fields, existing map[string]interface{}) {
+	for _, restricted := range []map[string]bool{a.Hidden, a.Protected} {
+		for name := range restricted {
+			if _, ok := fields[name]; ok {
+				continue
+			}
+			if value, ok := existing[name]; ok {
+				fields[name] = value
+			}
+		}
+	}
+}
+
+// RoleView returns a copy of the definition with the schema the role has access to. Hidden properties are removed and
+// protected properties are `readOnly`.
+func (def *ResourceDefinition) RoleView(role string) *ResourceDefinition {
+	view := *def
+
+	schema := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(def.Schema), &schema); err != nil {
+		return &view
+	}
+	objectSchema, err := def.GetSchema()
+	if err != nil {
+		return &view
+	}
+	access := objectSchema.FieldAccess(role)
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	for name, p := range properties {
+		property, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if access.Hidden[name] {
+			delete(properties, name)
+			continue
+		}
+		if access.Protected[name] {
+			property["readOnly"] = true
+		}
+		delete(property, PropertyReadRoles)
+		delete(property, PropertyWriteRoles)
+	}
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		visible := make([]interface{}, 0, len(required))
+		for _, name := range required {
+			if n, _ := name.(string); !access.Hidden[n] {
+				visible = append(visible, name)
+			}
+		}
+		schema["required"] = visible
+	}
+
+	b, _ := json.Marshal(schema)
+	view.Schema = string(b)
+
+	return &view
+}
+
+// validateFieldRoles checks the `read_roles` and `write_roles` of the properties are lists of role names
+func validateFieldRoles(schema *JSONSchemaObject) error {
+	for name, property := range schema.Properties {
+		for _, keyword := range []string{PropertyReadRoles, PropertyWriteRoles} {
+			value, ok := property[keyword]
+			if !ok {
+				continue
+			}
+			roles, ok := value.([]interface{})
+			if !ok {
+				return fmt.Errorf("property '%s': %s must be a list of roles", name, keyword)
+			}
+			for _, role := range roles {
+				if r, ok := role.(string); !ok || r == "" {
+					return fmt.Errorf("property '%s': %s must be a list of roles", name, keyword)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// propertyRoles returns the roles of the keyword, false if the property does not restrict access
+func propertyRoles(property map[string]interface{}, keyword string) ([]interface{}, bool) {
+	roles, ok := property[keyword].([]interface{})
+	return roles, ok
+}
+
+func containsRole(roles []interface{}, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fieldAccessSchema = `{"type":"object","required":["item","cost"],"properties":{"item":{"type":"string"},"cost":{"type":"number","read_roles":["billing"],"write_roles":["billing"]},"status":{"type":"string","write_roles":["billing","support"]}}}`
+
+func TestFieldAccess(t *testing.T) {
+	def := &ResourceDefinition{Schema: fieldAccessSchema}
+	schema, err := def.GetSchema()
+	assert.Nil(t, err)
+
+	tables := []struct {
+		name      string
+		role      string
+		hidden    map[string]bool
+		protected map[string]bool
+	}{
+		{"admin", "admin", map[string]bool{}, map[string]bool{}},
+		{"listed role", "billing", map[string]bool{}, map[string]bool{}},
+		{"write only role", "support", map[string]bool{"cost": true}, map[string]bool{"cost": true}},
+		{"unlisted role", "user", map[string]bool{"cost": true}, map[string]bool{"cost": true, "status": true}},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			access := schema.FieldAccess(tt.role)
+			assert.Equal(t, tt.hidden, access.Hidden)
+			assert.Equal(t, tt.protected, access.Protected)
+			assert.Equal(t, len(tt.hidden)+len(tt.protected) > 0, access.Restricted())
+		})
+	}
+}
+
+func TestFieldAccessDocuments(t *testing.T) {
+	access := &FieldAccess{Hidden: map[string]bool{"cost": true}, Protected: map[string]bool{"cost": true, "status": true}}
+
+	document := map[string]interface{}{"item": "book", "cost": 12.5, "status": "paid"}
+	access.Strip(document)
+	assert.Equal(t, map[string]interface{}{"item": "book", "status": "paid"}, document)
+
+	assert.Equal(t, []string{}, access.Written(map[string]interface{}{"item": "book"}))
+	assert.Equal(t, []string{"cost", "status"}, access.Written(map[string]interface{}{"status": "paid", "cost": 1, "item": "book"}))
+
+	fields := map[string]interface{}{"item": "pen"}
+	access.Preserve(fields, map[string]interface{}{"item": "book", "cost": 12.5, "status": "paid"})
+	assert.Equal(t, map[string]interface{}{"item": "pen", "cost": 12.5, "status": "paid"}, fields)
+}
+
+func TestRoleView(t *testing.T) {
+	def := &ResourceDefinition{Title: "Orders", PathName: "orders", Schema: fieldAccessSchema}
+
+	view := def.RoleView("user")
+	assert.Equal(t, "orders", view.PathName)
+	assert.Equal(t, fieldAccessSchema, def.Schema)
+
+	schema := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(view.Schema), &schema))
+	assert.Equal(t, []interface{}{"item"}, schema["required"])
+	assert.Equal(t, map[string]interface{}{
+		"item":   map[string]interface{}{"type": "string"},
+		"status": map[string]interface{}{"type": "string", "readOnly": true},
+	}, schema["properties"])
+}
+
+func TestValidateFieldRoles(t *testing.T) {
+	tables := []struct {
+		name   string
+		schema string
+		valid  bool
+	}{
+		{"roles", fieldAccessSchema, true},
+		{"not a list", `{"type":"object","properties":{"cost":{"type":"number","read_roles":"billing"}}}`, false},
+		{"not a string", `{"type":"object","properties":{"cost":{"type":"number","write_roles":[1]}}}`, false},
+		{"empty role", `{"type":"object","properties":{"cost":{"type":"number","read_roles":[""]}}}`, false},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			def := &ResourceDefinition{Title: "Orders", PathName: "orders", Schema: tt.schema}
+			err := def.Validate()
+			if tt.valid {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
@@ -3,10 +3,23 @@ package models
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/url"
 	"time"
 )
 
+const (
+	// HookPhaseAfter hooks are called asynchronously after the write has been committed
+	HookPhaseAfter = "after"
+	// HookPhaseBefore hooks are called synchronously before the write, and can reject or modify it
+	HookPhaseBefore = "before"
+
+	// DefaultHookTimeoutMS is the default timeout of a "before" hook, in milliseconds
+	DefaultHookTimeoutMS = 2000
+	// MaxHookTimeoutMS is the maximum timeout of a "before" hook, in milliseconds
+	MaxHookTimeoutMS = 10000
+)
+
 // HookResult contains relevant information regarding the http response of a web hook
 type HookResult struct {
 	WebHookID    string    `json:"webhook_id"`
@@ -28,6 +41,14 @@ type WebHook struct {
 	HookEvent string `json:"event"`
 	Headers   []byte `json:"headers"`
 	HookURL   string `json:"hook_url"`
+	Phase     string `json:"phase"`      // before, after
+	TimeoutMS int    `json:"timeout_ms"` // before hooks only
+	FailOpen  bool   `json:"fail_open"`  // before hooks only, allow the write if the hook can not be reached
+}
+
+// IsBefore returns true if the hook is called before the write
+func (w *WebHook) IsBefore() bool {
+	return w.Phase == HookPhaseBefore
 }
 
 // validURL parses the string as a url and verifies it is valid
@@ -52,6 +73,10 @@ func (w *WebHook) Validate() error {
 		return errors.New("hook URL can not be empty")
 	} else if !validURL(w.HookURL) {
 		return errors.New("invalid hook URL")
+	} else if w.Phase != HookPhaseBefore && w.Phase != HookPhaseAfter {
+		return errors.New("hook phase must be 'before' or 'after'")
+	} else if w.TimeoutMS < 0 || w.TimeoutMS > MaxHookTimeoutMS {
+		return fmt.Errorf("hook timeout must be between 0 and %d milliseconds", MaxHookTimeoutMS)
 	}
 
 	return nil
@@ -75,6 +100,9 @@ func (w *WebHook) MarshalJSON() ([]byte, error) {
 		HookEvent string              `json:"event"`
 		Headers   []map[string]string `json:"headers"`
 		HookURL   string              `json:"hook_url"`
+		Phase     string              `json:"phase"`
+		TimeoutMS int                 `json:"timeout_ms"`
+		FailOpen  bool                `json:"fail_open"`
 	}{
 		ID:        w.ID,
 		ProjectID: w.ProjectID,
@@ -85,6 +113,9 @@ func (w *WebHook) MarshalJSON() ([]byte, error) {
 		HookEvent: w.HookEvent,
 		Headers:   headers,
 		HookURL:   w.HookURL,
+		Phase:     w.Phase,
+		TimeoutMS: w.TimeoutMS,
+		FailOpen:  w.FailOpen,
 	})
 }
 
@@ -100,6 +131,9 @@ func (w *WebHook) UnmarshalJSON(b []byte) error {
 		HookEvent string          `json:"event"`
 		Headers   json.RawMessage `json:"headers"`
 		HookURL   string          `json:"hook_url"`
+		Phase     string          `json:"phase"`
+		TimeoutMS int             `json:"timeout_ms"`
+		FailOpen  bool            `json:"fail_open"`
 	}{}
 
 	err := json.Unmarshal(b, &payload)
@@ -117,6 +151,17 @@ func (w *WebHook) UnmarshalJSON(b []byte) error {
 	w.HookEvent = payload.HookEvent
 	w.Headers = payload.Headers
 	w.HookURL = payload.HookURL
+	w.Phase = payload.Phase
+	w.TimeoutMS = payload.TimeoutMS
+	w.FailOpen = payload.FailOpen
+
+	// hooks created before phases existed are "after" hooks
+	if w.Phase == "" {
+		w.Phase = HookPhaseAfter
+	}
+	if w.Phase == HookPhaseBefore && w.TimeoutMS == 0 {
+		w.TimeoutMS = DefaultHookTimeoutMS
+	}
 
 	return nil
 }
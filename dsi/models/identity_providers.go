@@ -0,0 +1,161 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const (
+	// IdentityProviderOIDC is a generic OpenID Connect provider, the endpoints are discovered from the issuer
+	IdentityProviderOIDC = "oidc"
+	// IdentityProviderGoogle is the Google OpenID Connect provider
+	IdentityProviderGoogle = "google"
+	// IdentityProviderGitHub is the GitHub OAuth2 provider, which does not support OpenID Connect
+	IdentityProviderGitHub = "github"
+)
+
+// GoogleIssuer is the issuer of Google ID tokens
+const GoogleIssuer = "https://accounts.google.com"
+
+var providerName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// IdentityProvider is an external OAuth2 or OpenID Connect provider project users can log in with
+type IdentityProvider struct {
+	ID               string    `json:"id"`
+	ProjectID        string    `json:"project_id"`
+	Name             string    `json:"name"` // Name is the path segment of the login routes, i.e. `/sessions/oauth/{name}/authorize`
+	Type             string    `json:"type"`
+	ClientID         string    `json:"client_id"`
+	ClientSecret     string    `json:"client_secret,omitempty"`
+	Issuer           string    `json:"issuer"`
+	AuthorizationURL string    `json:"authorization_url"`
+	TokenURL         string    `json:"token_url"`
+	UserInfoURL      string    `json:"userinfo_url"`
+	Scopes           []string  `json:"scopes"`
+	RedirectURLs     []string  `json:"redirect_urls"` // RedirectURLs are the application urls allowed to receive the login code
+	Provision        bool      `json:"provision"`     // Provision creates project users for identities which are not linked to a user
+	Read             bool      `json:"read"`          // Read is the read access of provisioned users
+	Write            bool      `json:"write"`         // Write is the write access of provisioned users
+	Created          time.Time `json:"created"`
+}
+
+// OIDC returns true if the provider issues ID tokens
+func (p *IdentityProvider) OIDC() bool {
+	return p.Type == IdentityProviderOIDC || p.Type == IdentityProviderGoogle
+}
+
+// ApplyPreset fills in the well known issuer, endpoints and scopes of the provider type. Fields which have been set are
+// not overwritten.
+func (p *IdentityProvider) ApplyPreset() {
+	switch p.Type {
+	case IdentityProviderGoogle:
+		if p.Issuer == "" {
+			p.Issuer = GoogleIssuer
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+	case IdentityProviderGitHub:
+		if p.AuthorizationURL == "" {
+			p.AuthorizationURL = "https://github.com/login/oauth/authorize"
+		}
+		if p.TokenURL == "" {
+			p.TokenURL = "https://github.com/login/oauth/access_token"
+		}
+		if p.UserInfoURL == "" {
+			p.UserInfoURL = "https://api.github.com/user"
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"read:user", "user:email"}
+		}
+	case IdentityProviderOIDC:
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+	}
+}
+
+// Validate checks the name, type, client credentials and urls of the provider. The endpoints of OpenID Connect
+// providers must have been discovered before validating.
+func (p *IdentityProvider) Validate() error {
+	if !providerName.MatchString(p.Name) {
+		return errors.New("invalid provider name, must be up to 32 lowercase letters, digits or dashes")
+	}
+	if p.Type != IdentityProviderOIDC && p.Type != IdentityProviderGoogle && p.Type != IdentityProviderGitHub {
+		return fmt.Errorf("invalid provider type, must be one of '%s', '%s' or '%s'", IdentityProviderOIDC, IdentityProviderGoogle, IdentityProviderGitHub)
+	}
+	if p.ClientID == "" || p.ClientSecret == "" {
+		return errors.New("client_id and client_secret are required")
+	}
+
+	if p.OIDC() {
+		if err := validateProviderURL("issuer", p.Issuer); err != nil {
+			return err
+		}
+		hasOpenID := false
+		for _, scope := range p.Scopes {
+			hasOpenID = hasOpenID || scope == "openid"
+		}
+		if !hasOpenID {
+			return errors.New("scopes of openid connect providers must include 'openid'")
+		}
+	}
+	if err := validateProviderURL("authorization_url", p.AuthorizationURL); err != nil {
+		return err
+	}
+	if err := validateProviderURL("token_url", p.TokenURL); err != nil {
+		return err
+	}
+	if p.UserInfoURL != "" || !p.OIDC() {
+		if err := validateProviderURL("userinfo_url", p.UserInfoURL); err != nil {
+			return err
+		}
+	}
+
+	for _, redirect := range p.RedirectURLs {
+		u, err := url.Parse(redirect)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+			return fmt.Errorf("invalid redirect url '%s', must be absolute without a fragment", redirect)
+		}
+	}
+
+	return nil
+}
+
+// AllowsRedirect returns true if the application url is one of the provider's redirect urls
+func (p *IdentityProvider) AllowsRedirect(redirect string) bool {
+	for _, allowed := range p.RedirectURLs {
+		if allowed == redirect {
+			return true
+		}
+	}
+	return false
+}
+
+// validateProviderURL requires https, plain http is only allowed for local development providers
+func validateProviderURL(field, value string) error {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute url", field)
+	}
+	host := u.Hostname()
+	local := host == "localhost" || host == "127.0.0.1" || host == "::1"
+	if u.Scheme != "https" && !(u.Scheme == "http" && local) {
+		return fmt.Errorf("%s must be an https url", field)
+	}
+	return nil
+}
+
+// UserIdentity links the subject of an identity provider to a project user
+type UserIdentity struct {
+	ID        string    `json:"id"`
+	ProjectID string    `json:"project_id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	Created   time.Time `json:"created"`
+}
@@ -1,12 +1,421 @@
 package models
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	oaerrors "github.com/go-openapi/errors"
+	"github.com/go-openapi/jsonpointer"
+	"github.com/go-openapi/spec"
+	"github.com/go-openapi/strfmt"
+	"github.com/go-openapi/validate"
+)
+
 // RootKey defines the metadata of a project root JSON key
 type RootKey struct {
-	ID        string `json:"id"`
-	Key       string `json:"key"`
-	ProjectID string `json:"project_id"`
-	Create    bool   `json:"create"`
-	Read      bool   `json:"read"`
-	Update    bool   `json:"update"`
-	Delete    bool   `json:"delete"`
+	ID        string          `json:"id"`
+	Key       string          `json:"key"`
+	ProjectID string          `json:"project_id"`
+	Create    bool            `json:"create"`
+	Read      bool            `json:"read"`
+	Update    bool            `json:"update"`
+	Delete    bool            `json:"delete"`
+	Schema    json.RawMessage `json:"schema,omitempty"`  // optional JSON Schema of the entire tree
+	Rules     []*JSONRule     `json:"rules,omitempty"`   // optional path scoped access rules, replace the access flags
+	History   *JSONHistory    `json:"history,omitempty"` // optional change history of the tree
+}
+
+// HistoryEnabled returns true if changes of the tree are recorded as versions
+func (r *RootKey) HistoryEnabled() bool {
+	return r.History != nil && r.History.Enabled
+}
+
+// HasSchema returns true if a JSON Schema is attached to the root key
+func (r *RootKey) HasSchema() bool {
+	trimmed := strings.TrimSpace(string(r.Schema))
+	return trimmed != "" && trimmed != "null"
+}
+
+// ValidateSchema verifies the root key schema is a valid JSON Schema (draft 4), it is called before the schema is stored
+func (r *RootKey) ValidateSchema() error {
+	if !r.HasSchema() {
+		return nil
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(r.Schema, &document); err != nil {
+		return fmt.Errorf("invalid schema: %s", err.Error())
+	}
+	if _, ok := document.(map[string]interface{}); !ok {
+		return errors.New("invalid schema: must be an object")
+	}
+
+	// the validator of the meta schema requires a root name
+	res := validate.NewSchemaValidator(spec.MustLoadJSONSchemaDraft04(), nil, "schema", strfmt.Default).Validate(document)
+	if res.HasErrors() {
+		return fmt.Errorf("invalid schema: %s", res.AsError().Error())
+	}
+
+	schema := new(spec.Schema)
+	if err := json.Unmarshal(r.Schema, schema); err != nil {
+		return fmt.Errorf("invalid schema: %s", err.Error())
+	}
+
+	return nil
+}
+
+// ValidateData validates the entire JSON tree of the root key against its schema. A `*SchemaValidationError` is returned
+// if the tree does not match the schema.
+func (r *RootKey) ValidateData(data []byte) error {
+	if !r.HasSchema() {
+		return nil
+	}
+
+	schema := new(spec.Schema)
+	if err := json.Unmarshal(r.Schema, schema); err != nil {
+		return fmt.Errorf("invalid schema: %s", err.Error())
+	}
+
+	var tree interface{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &tree); err != nil {
+			return err
+		}
+	}
+
+	res := validate.NewSchemaValidator(schema, nil, "", strfmt.Default).Validate(tree)
+	if !res.HasErrors() {
+		return nil
+	}
+
+	vErr := &SchemaValidationError{Errors: make([]*SchemaFieldError, 0)}
+	for _, e := range res.Errors {
+		field := &SchemaFieldError{Path: "/", Message: e.Error()}
+		if v, ok := e.(*oaerrors.Validation); ok && v.Name != "" {
+			field.Path = schemaErrorPointer(tree, v.Name)
+			field.Message = strings.TrimPrefix(field.Message, v.Name+" in body ")
+		}
+		vErr.Errors = append(vErr.Errors, field)
+	}
+
+	return vErr
+}
+
+// schemaErrorPointer returns the JSON pointer of the dotted name of a validation error. Keys can contain dots, so the
+// segments are found by matching the name against the keys of the tree, the longest key first. The remainder of the
+// name, i.e. a missing required key, is the last segment.
+func schemaErrorPointer(tree interface{}, name string) string {
+	// names below `additionalProperties` of the root start with a dot
+	name = strings.TrimPrefix(name, ".")
+
+	segments := make([]string, 0)
+	for name != "" {
+		segment := ""
+		switch node := tree.(type) {
+		case map[string]interface{}:
+			for key := range node {
+				if (name == key || strings.HasPrefix(name, key+".")) && len(key) > len(segment) {
+					segment = key
+				}
+			}
+			if segment != "" {
+				tree = node[segment]
+			}
+		case []interface{}:
+			index := strings.SplitN(name, ".", 2)[0]
+			if i, err := strconv.Atoi(index); err == nil && i >= 0 && i < len(node) {
+				segment = index
+				tree = node[i]
+			}
+		}
+
+		if segment == "" {
+			segments = append(segments, name)
+			break
+		}
+		segments = append(segments, segment)
+		name = strings.TrimPrefix(strings.TrimPrefix(name, segment), ".")
+	}
+
+	for i, segment := range segments {
+		segments[i] = jsonpointer.Escape(segment)
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// SchemaFieldError is a single schema violation, `Path` points at the offending key i.e. `/users/abc/age`
+type SchemaFieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// SchemaValidationError is returned when a JSON tree does not match the schema of its root key
+type SchemaValidationError struct {
+	Errors []*SchemaFieldError `json:"errors"`
+}
+
+func (e *SchemaValidationError) Error() string {
+	messages := make([]string, 0)
+	for _, field := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", field.Path, field.Message))
+	}
+	return "schema validation failed: " + strings.Join(messages, ", ")
+}
+
+// JSONValueValidator is called with the value at the key path before it is written and returns the value to be written,
+// i.e. to call the "before" hooks with the result of a `JSONOperation`
+type JSONValueValidator func(value []byte) ([]byte, error)
+
+const (
+	// JSONOpIncrement adds `value` to the number at the key path, a missing key starts at 0
+	JSONOpIncrement = "increment"
+	// JSONOpDecrement subtracts `value` from the number at the key path, a missing key starts at 0
+	JSONOpDecrement = "decrement"
+	// JSONOpAppend appends `value` to the array at the key path, a missing key starts as an empty array
+	JSONOpAppend = "append"
+	// JSONOpRemove removes all elements equal to `value` from the array at the key path
+	JSONOpRemove = "remove"
+	// JSONOpCompareAndSet sets the key path to `value` only if the current value equals `expected`
+	JSONOpCompareAndSet = "compare_and_set"
+	// JSONOpSetIfAbsent sets the key path to `value` only if the key does not exist
+	JSONOpSetIfAbsent = "set_if_absent"
+)
+
+var (
+	// ErrKeyNotFound is returned when the key path, or one of its intermediate nodes, does not exist
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyExists is returned when creating a key which already exists
+	ErrKeyExists = errors.New("key already exists")
+	// ErrInvalidArrayIndex is returned when a key path segment of an array node is not a non-negative integer index
+	ErrInvalidArrayIndex = errors.New("array index must be a non-negative integer")
+	// ErrKeyTypeConflict is returned when the parent of the key path is a value which can not have children
+	ErrKeyTypeConflict = errors.New("parent of the key is not an object or array")
+)
+
+// ErrOperationConflict is returned when the current value at the key path does not allow the operation, i.e. the
+// expected value of a compare-and-set does not match or the value is not a number/array
+var ErrOperationConflict = errors.New("operation conflicts with the current value")
+
+// JSONOperation is an atomic operation on the value at a JSON tree key path
+type JSONOperation struct {
+	Op       string          `json:"op"`
+	Value    json.RawMessage `json:"value"`
+	Expected json.RawMessage `json:"expected,omitempty"` // compare_and_set only
+}
+
+// Validate verifies the operation and its values
+func (o *JSONOperation) Validate() error {
+	if len(o.Value) == 0 {
+		return errors.New("operation value cannot be empty")
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(o.Value, &value); err != nil {
+		return fmt.Errorf("invalid operation value: %s", err.Error())
+	}
+
+	switch o.Op {
+	case JSONOpIncrement, JSONOpDecrement:
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("'%s' value must be a number", o.Op)
+		}
+	case JSONOpCompareAndSet:
+		var expected interface{}
+		if len(o.Expected) == 0 {
+			return errors.New("compare_and_set requires an expected value")
+		}
+		if err := json.Unmarshal(o.Expected, &expected); err != nil {
+			return fmt.Errorf("invalid expected value: %s", err.Error())
+		}
+	case JSONOpAppend, JSONOpRemove, JSONOpSetIfAbsent:
+	default:
+		return fmt.Errorf("unknown operation '%s'", o.Op)
+	}
+
+	return nil
+}
+
+const (
+	// JSONRuleRead is the rule operation for reading a key path
+	JSONRuleRead = "read"
+	// JSONRuleWrite is the rule operation for creating, updating or deleting a key path
+	JSONRuleWrite = "write"
+)
+
+// JSONRule is a path scoped access rule of a JSON tree. `Path` is relative to the root key and may contain `{var}`
+// segments which capture a single key, `*` which matches a single key and a trailing `**` which matches any number of
+// keys. `Read` and `Write` are conditions such as `auth != null` or `auth.id == $uid`.
+type JSONRule struct {
+	Path  string `json:"path"`
+	Read  string `json:"read,omitempty"`
+	Write string `json:"write,omitempty"`
+}
+
+const (
+	// JSONOrderByKey orders the children of a node by their key, array children by their index
+	JSONOrderByKey = "$key"
+	// JSONOrderByValue orders the children of a node by their value
+	JSONOrderByValue = "$value"
+)
+
+// jsonChildQueryParams are the query parameters which turn a read of a key path into a child query
+var jsonChildQueryParams = []string{"orderBy", "startAt", "endAt", "limitToFirst", "limitToLast", "shallow"}
+
+// JSONChildQuery lists the children of an object or array node. `OrderBy` is `$key`, `$value` or a child key path
+// such as `profile/age`. `StartAt` and `EndAt` are inclusive bounds compared with the ordered value.
+type JSONChildQuery struct {
+	OrderBy      string
+	OrderPath    []string
+	StartAt      json.RawMessage
+	EndAt        json.RawMessage
+	LimitToFirst int
+	LimitToLast  int
+	Shallow      bool
+}
+
+// JSONChild is a single child of a JSON tree node, `Value` is omitted for shallow queries
+type JSONChild struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// IsJSONChildQuery returns true if the query parameters request the children of a node rather than its subtree
+func IsJSONChildQuery(values url.Values) bool {
+	for _, param := range jsonChildQueryParams {
+		if _, ok := values[param]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseJSONChildQuery parses and validates the child query parameters. Range values which are not valid JSON are
+// treated as strings, i.e. `startAt=b` is equivalent to `startAt="b"`.
+func ParseJSONChildQuery(values url.Values) (*JSONChildQuery, error) {
+	query := &JSONChildQuery{OrderBy: values.Get("orderBy")}
+
+	switch strings.Trim(query.OrderBy, "/") {
+	case "", JSONOrderByKey:
+		query.OrderBy = JSONOrderByKey
+	case JSONOrderByValue:
+	default:
+		query.OrderPath = strings.Split(strings.Trim(query.OrderBy, "/"), "/")
+		for _, key := range query.OrderPath {
+			if key == "" {
+				return nil, fmt.Errorf("invalid orderBy '%s'", query.OrderBy)
+			}
+		}
+	}
+
+	query.StartAt = jsonQueryValue(values, "startAt")
+	query.EndAt = jsonQueryValue(values, "endAt")
+
+	var err error
+	if query.LimitToFirst, err = jsonQueryLimit(values, "limitToFirst"); err != nil {
+		return nil, err
+	}
+	if query.LimitToLast, err = jsonQueryLimit(values, "limitToLast"); err != nil {
+		return nil, err
+	}
+	if query.LimitToFirst > 0 && query.LimitToLast > 0 {
+		return nil, errors.New("limitToFirst and limitToLast cannot be combined")
+	}
+
+	if shallow := values.Get("shallow"); shallow != "" {
+		if query.Shallow, err = strconv.ParseBool(shallow); err != nil {
+			return nil, errors.New("shallow must be a boolean")
+		}
+	} else if _, ok := values["shallow"]; ok {
+		query.Shallow = true
+	}
+
+	return query, nil
+}
+
+// jsonQueryValue returns the query parameter as a JSON value, nil if it is not set
+func jsonQueryValue(values url.Values, param string) json.RawMessage {
+	if _, ok := values[param]; !ok {
+		return nil
+	}
+
+	value := values.Get(param)
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+
+	b, _ := json.Marshal(value)
+	return json.RawMessage(b)
+}
+
+// jsonQueryLimit parses a positive limit query parameter, 0 if it is not set
+func jsonQueryLimit(values url.Values, param string) (int, error) {
+	value := values.Get(param)
+	if value == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", param)
+	}
+
+	return limit, nil
+}
+
+const (
+	// DefaultJSONHistoryRetention is the number of versions kept if the root key does not set a retention
+	DefaultJSONHistoryRetention = 100
+	// MaxJSONHistoryRetention is the maximum number of versions kept per root key
+	MaxJSONHistoryRetention = 10000
+	// JSONActionRestore is the version action of a tree restored to a previous version
+	JSONActionRestore = "restore"
+)
+
+var (
+	// ErrVersionNotFound is returned when restoring a version which does not exist or is beyond the retention
+	ErrVersionNotFound = errors.New("version not found")
+	// ErrHistoryDisabled is returned when restoring a version of a root key without history
+	ErrHistoryDisabled = errors.New("history is not enabled for this key")
+)
+
+// JSONHistory configures the change history of a root key. Each write is recorded as a version which can be restored,
+// only the most recent `Retention` versions are kept.
+type JSONHistory struct {
+	Enabled   bool `json:"enabled"`
+	Retention int  `json:"retention,omitempty"`
+}
+
+// Validate verifies the retention of the history, 0 keeps the default number of versions
+func (h *JSONHistory) Validate() error {
+	if h.Retention < 0 || h.Retention > MaxJSONHistoryRetention {
+		return fmt.Errorf("history retention must be between 1 and %d, or 0 for the default of %d", MaxJSONHistoryRetention, DefaultJSONHistoryRetention)
+	}
+	return nil
+}
+
+// Limit returns the number of versions to keep
+func (h *JSONHistory) Limit() int {
+	if h.Retention == 0 {
+		return DefaultJSONHistoryRetention
+	}
+	return h.Retention
+}
+
+// JSONVersion is a single recorded change of a JSON tree. The change is a patch of the value at `Keys`, `Previous` is
+// the value before the change (null if the key did not exist) and `Value` the value after. Changes to array elements are
+// recorded as a patch of the entire array since they can shift the following elements.
+type JSONVersion struct {
+	Version     int64           `json:"version"`
+	Action      string          `json:"action"` // create, edit, delete, restore
+	Keys        []string        `json:"keys"`
+	Previous    json.RawMessage `json:"previous"`
+	Value       json.RawMessage `json:"value"`
+	Creator     string          `json:"creator"`
+	CreatorType string          `json:"creator_type"`
+	Created     time.Time       `json:"created"`
 }
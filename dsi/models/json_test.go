@@ -0,0 +1,200 @@
+package models
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRootKeyValidateData(t *testing.T) {
+	rootKey := &RootKey{
+		Schema: []byte(`{
+			"type": "object",
+			"properties": {
+				"users": {
+					"type": "object",
+					"additionalProperties": {
+						"type": "object",
+						"required": ["name"],
+						"properties": {
+							"name": {"type": "string"},
+							"age": {"type": "integer"}
+						}
+					}
+				}
+			}
+		}`),
+	}
+
+	assert.Nil(t, rootKey.ValidateSchema())
+	assert.Nil(t, rootKey.ValidateData([]byte(`{"users": {"abc": {"name": "Nick", "age": 30}}}`)))
+
+	err := rootKey.ValidateData([]byte(`{"users": {"abc": {"age": "thirty"}}}`))
+	vErr, ok := err.(*SchemaValidationError)
+	if !assert.True(t, ok) {
+		return
+	}
+
+	paths := []string{}
+	for _, field := range vErr.Errors {
+		paths = append(paths, field.Path)
+	}
+	assert.ElementsMatch(t, []string{"/users/abc/age", "/users/abc/name"}, paths)
+
+	// no schema accepts anything
+	assert.Nil(t, (&RootKey{}).ValidateData([]byte(`[1, 2, 3]`)))
+	assert.Nil(t, (&RootKey{Schema: []byte("null")}).ValidateData([]byte(`"anything"`)))
+}
+
+func TestRootKeyValidateSchema(t *testing.T) {
+	tables := []struct {
+		name   string
+		schema string
+		valid  bool
+	}{
+		{"object", `{"type": "object", "properties": {"name": {"type": "string"}}}`, true},
+		{"none", ``, true},
+		{"unknown type", `{"type": "nope"}`, false},
+		{"invalid required", `{"type": "object", "required": "name"}`, false},
+		{"invalid minimum", `{"type": "integer", "minimum": "1"}`, false},
+		{"not an object", `[1, 2]`, false},
+		{"invalid json", `{"type":`, false},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&RootKey{Schema: []byte(tt.schema)}).ValidateSchema()
+			assert.Equal(t, tt.valid, err == nil, "%v", err)
+		})
+	}
+}
+
+func TestRootKeyValidateDataPointers(t *testing.T) {
+	rootKey := &RootKey{
+		Schema: []byte(`{
+			"type": "object",
+			"additionalProperties": {
+				"type": "object",
+				"required": ["name"],
+				"properties": {
+					"name": {"type": "string"},
+					"tags": {"type": "array", "items": {"type": "string"}}
+				}
+			}
+		}`),
+	}
+
+	// keys are escaped, items of arrays are reported at the array
+	err := rootKey.ValidateData([]byte(`{"a.b/c~d": {"name": 1}, "e": {"tags": ["x", 2]}}`))
+	vErr, ok := err.(*SchemaValidationError)
+	if !assert.True(t, ok) {
+		return
+	}
+
+	paths := []string{}
+	for _, field := range vErr.Errors {
+		paths = append(paths, field.Path)
+	}
+	assert.ElementsMatch(t, []string{"/a.b~1c~0d/name", "/e/name", "/e/tags"}, paths)
+}
+
+func TestJSONOperationValidate(t *testing.T) {
+	tables := []struct {
+		name  string
+		op    *JSONOperation
+		valid bool
+	}{
+		{"increment", &JSONOperation{Op: JSONOpIncrement, Value: []byte("1")}, true},
+		{"decrement float", &JSONOperation{Op: JSONOpDecrement, Value: []byte("0.5")}, true},
+		{"increment string", &JSONOperation{Op: JSONOpIncrement, Value: []byte(`"1"`)}, false},
+		{"append object", &JSONOperation{Op: JSONOpAppend, Value: []byte(`{"a": 1}`)}, true},
+		{"remove", &JSONOperation{Op: JSONOpRemove, Value: []byte(`"tag"`)}, true},
+		{"compare and set", &JSONOperation{Op: JSONOpCompareAndSet, Value: []byte("2"), Expected: []byte("1")}, true},
+		{"compare and set without expected", &JSONOperation{Op: JSONOpCompareAndSet, Value: []byte("2")}, false},
+		{"set if absent", &JSONOperation{Op: JSONOpSetIfAbsent, Value: []byte("null")}, true},
+		{"missing value", &JSONOperation{Op: JSONOpSetIfAbsent}, false},
+		{"unknown", &JSONOperation{Op: "multiply", Value: []byte("2")}, false},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.op.Validate()
+			assert.Equal(t, tt.valid, err == nil)
+		})
+	}
+}
+
+func TestParseJSONChildQuery(t *testing.T) {
+	tables := []struct {
+		name  string
+		query string
+		valid bool
+		check func(t *testing.T, q *JSONChildQuery)
+	}{
+		{"default order", "shallow", true, func(t *testing.T, q *JSONChildQuery) {
+			assert.Equal(t, JSONOrderByKey, q.OrderBy)
+			assert.True(t, q.Shallow)
+		}},
+		{"child path", "orderBy=profile/age&startAt=18&endAt=30", true, func(t *testing.T, q *JSONChildQuery) {
+			assert.Equal(t, []string{"profile", "age"}, q.OrderPath)
+			assert.Equal(t, "18", string(q.StartAt))
+			assert.Equal(t, "30", string(q.EndAt))
+		}},
+		{"string range", "orderBy=$value&startAt=b", true, func(t *testing.T, q *JSONChildQuery) {
+			assert.Nil(t, q.OrderPath)
+			assert.Equal(t, `"b"`, string(q.StartAt))
+			assert.Nil(t, q.EndAt)
+		}},
+		{"limit to last", "limitToLast=5", true, func(t *testing.T, q *JSONChildQuery) {
+			assert.Equal(t, 5, q.LimitToLast)
+		}},
+		{"combined limits", "limitToFirst=1&limitToLast=1", false, nil},
+		{"negative limit", "limitToFirst=-1", false, nil},
+		{"invalid shallow", "shallow=maybe", false, nil},
+		{"empty child key", "orderBy=a//b", false, nil},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			assert.True(t, IsJSONChildQuery(values))
+
+			q, err := ParseJSONChildQuery(values)
+			assert.Equal(t, tt.valid, err == nil)
+			if tt.check != nil && q != nil {
+				tt.check(t, q)
+			}
+		})
+	}
+
+	assert.False(t, IsJSONChildQuery(url.Values{"_limit": []string{"1"}}))
+}
+
+func TestJSONHistory(t *testing.T) {
+	tables := []struct {
+		name    string
+		history *JSONHistory
+		valid   bool
+		limit   int
+	}{
+		{"default retention", &JSONHistory{Enabled: true}, true, DefaultJSONHistoryRetention},
+		{"custom retention", &JSONHistory{Enabled: true, Retention: 5}, true, 5},
+		{"minimum retention", &JSONHistory{Enabled: true, Retention: 1}, true, 1},
+		{"maximum retention", &JSONHistory{Enabled: true, Retention: MaxJSONHistoryRetention}, true, MaxJSONHistoryRetention},
+		{"negative retention", &JSONHistory{Enabled: true, Retention: -1}, false, -1},
+		{"retention too large", &JSONHistory{Enabled: true, Retention: MaxJSONHistoryRetention + 1}, false, MaxJSONHistoryRetention + 1},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.history.Validate()
+			assert.Equal(t, tt.valid, err == nil)
+			assert.Equal(t, tt.limit, tt.history.Limit())
+		})
+	}
+
+	assert.False(t, (&RootKey{}).HistoryEnabled())
+	assert.False(t, (&RootKey{History: &JSONHistory{}}).HistoryEnabled())
+	assert.True(t, (&RootKey{History: &JSONHistory{Enabled: true}}).HistoryEnabled())
+}
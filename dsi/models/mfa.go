@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	// MFAMethodTOTP is the multi-factor method of authenticator app codes
+	MFAMethodTOTP = "totp"
+	// MFAMethodRecoveryCode is the multi-factor method of single use recovery codes
+	MFAMethodRecoveryCode = "recovery_code"
+	// MFAMethodWebAuthn is the multi-factor method of security keys and platform authenticators
+	MFAMethodWebAuthn = "webauthn"
+)
+
+// UserMFA is the multi-factor authentication of an app user
+type UserMFA struct {
+	// TOTPSecret is set when enrollment starts, it is only used to log in once TOTPEnabled is set
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	// TOTPLastStep is the time step of the last code used, codes of the step or earlier steps are rejected
+	TOTPLastStep int64 `json:"-"`
+	// RecoveryCodes are the hashes of the recovery codes which have not been used
+	RecoveryCodes []string              `json:"-"`
+	WebAuthn      []*WebAuthnCredential `json:"webauthn"`
+}
+
+// Enabled returns true if the user has a second factor, and must use it to log in
+func (m *UserMFA) Enabled() bool {
+	return m.TOTPEnabled || len(m.WebAuthn) > 0
+}
+
+// Methods returns the multi-factor methods the user can log in with
+func (m *UserMFA) Methods() []string {
+	methods := []string{}
+	if m.TOTPEnabled {
+		methods = append(methods, MFAMethodTOTP)
+	}
+	if len(m.WebAuthn) > 0 {
+		methods = append(methods, MFAMethodWebAuthn)
+	}
+	if m.Enabled() && len(m.RecoveryCodes) > 0 {
+		methods = append(methods, MFAMethodRecoveryCode)
+	}
+	return methods
+}
+
+// Credential returns the WebAuthn credential with the ID, nil if the user has not registered it
+func (m *UserMFA) Credential(id string) *WebAuthnCredential {
+	for _, credential := range m.WebAuthn {
+		if credential.ID == id {
+			return credential
+		}
+	}
+	return nil
+}
+
+// WebAuthnCredential is a security key or platform authenticator registered by an app user
+type WebAuthnCredential struct {
+	// ID is the base64url encoded credential ID
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// PublicKey is the PKIX, ASN.1 DER encoded public key of the credential
+	PublicKey []byte    `json:"-"`
+	SignCount uint32    `json:"sign_count"`
+	Created   time.Time `json:"created"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserMFAMethods(t *testing.T) {
+	key := &WebAuthnCredential{ID: "key-1"}
+
+	tables := []struct {
+		name    string
+		mfa     *UserMFA
+		enabled bool
+		methods []string
+	}{
+		{"not enrolled", &UserMFA{}, false, []string{}},
+		{"pending totp", &UserMFA{TOTPSecret: "secret", RecoveryCodes: []string{"hash"}}, false, []string{}},
+		{"totp", &UserMFA{TOTPEnabled: true, RecoveryCodes: []string{"hash"}}, true, []string{MFAMethodTOTP, MFAMethodRecoveryCode}},
+		{"webauthn", &UserMFA{WebAuthn: []*WebAuthnCredential{key}}, true, []string{MFAMethodWebAuthn}},
+		{"all", &UserMFA{TOTPEnabled: true, WebAuthn: []*WebAuthnCredential{key}, RecoveryCodes: []string{"hash"}}, true, []string{MFAMethodTOTP, MFAMethodWebAuthn, MFAMethodRecoveryCode}},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.enabled, tt.mfa.Enabled())
+			assert.Equal(t, tt.methods, tt.mfa.Methods())
+		})
+	}
+
+	mfa := &UserMFA{WebAuthn: []*WebAuthnCredential{key}}
+	assert.Equal(t, key, mfa.Credential("key-1"))
+	assert.Nil(t, mfa.Credential("key-2"))
+}
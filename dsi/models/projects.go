@@ -25,20 +25,29 @@ type Project struct {
 	Created          time.Time `json:"created"`
 	Authn            bool      `json:"authn"`
 	UserRegistration bool      `json:"user_registration"`
+	UserVerification bool      `json:"user_verification"` // UserVerification requires registered users to verify their email
+	// AccessTokenLifetime and RefreshTokenLifetime are the session token lifetimes in minutes, 0 uses the defaults
+	AccessTokenLifetime  int `json:"access_token_lifetime"`
+	RefreshTokenLifetime int `json:"refresh_token_lifetime"`
+	// MFARequired requires app users to log in with a second factor to manage the project
+	MFARequired bool `json:"mfa_required"`
 }
 
 // ProjectDetail is read from the app_project_limits view and contains app tier values
 // based on the currently active account tier
 type ProjectDetail struct {
-	ID               string     `json:"id"`
-	UserID           string     `json:"user_id"`
-	Slug             string     `json:"slug"`
-	Name             string     `json:"name"`
-	Description      string     `json:"description"`
-	Icon             string     `json:"icon"`
-	Created          time.Time  `json:"created"`
-	Authn            bool       `json:"authn"`
-	UserRegistration bool       `json:"user_registration"`
-	Requests         int        `json:"requests"`
-	Hooks            []*WebHook `json:"hooks"`
+	ID                   string     `json:"id"`
+	UserID               string     `json:"user_id"`
+	Slug                 string     `json:"slug"`
+	Name                 string     `json:"name"`
+	Description          string     `json:"description"`
+	Icon                 string     `json:"icon"`
+	Created              time.Time  `json:"created"`
+	Authn                bool       `json:"authn"`
+	UserRegistration     bool       `json:"user_registration"`
+	UserVerification     bool       `json:"user_verification"`
+	AccessTokenLifetime  int        `json:"access_token_lifetime"`
+	RefreshTokenLifetime int        `json:"refresh_token_lifetime"`
+	Requests             int        `json:"requests"`
+	Hooks                []*WebHook `json:"hooks"`
 }
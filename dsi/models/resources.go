@@ -52,6 +52,10 @@ func (obj *ResourceObject) Validate(definition *ResourceDefinition) error {
 		}
 		return errors.New(strings.Join(errs, ","))
 	}
+
+	if definition.TTL != nil {
+		return definition.TTL.ValidateDocument(*obj)
+	}
 	return nil
 }
 
@@ -68,20 +72,70 @@ type JSONSchemaObject struct {
 // 	Type string `json:"type"`
 // }
 
+// ResourceTTL defines when the documents of a resource expire. Without a `Property`, documents expire `Seconds` after
+// they were created. With a `Property`, documents expire `Seconds` after the date-time value of that property.
+type ResourceTTL struct {
+	Seconds  int64  `json:"seconds"`
+	Property string `json:"property,omitempty"`
+}
+
+// Validate validates the TTL against the resource schema
+func (ttl *ResourceTTL) Validate(schema *JSONSchemaObject) error {
+	if ttl.Seconds < 0 {
+		return errors.New("ttl seconds cannot be negative")
+	}
+
+	if ttl.Property == "" {
+		if ttl.Seconds == 0 {
+			return errors.New("ttl seconds must be greater than 0")
+		}
+		return nil
+	}
+
+	prop, ok := schema.Properties[ttl.Property]
+	if !ok {
+		return fmt.Errorf("ttl property '%s' is not defined in the schema", ttl.Property)
+	}
+	if format, _ := prop["format"].(string); format != "date-time" {
+		return fmt.Errorf("ttl property '%s' must be a date-time string", ttl.Property)
+	}
+
+	return nil
+}
+
+// ValidateDocument validates that the TTL property of the document, if it is set, is a date-time the expiry can compare
+func (ttl *ResourceTTL) ValidateDocument(obj ResourceObject) error {
+	if ttl.Property == "" || obj[ttl.Property] == nil {
+		return nil
+	}
+
+	value, ok := obj[ttl.Property].(string)
+	if ok {
+		_, err := strfmt.ParseDateTime(value)
+		ok = err == nil
+	}
+	if !ok {
+		return fmt.Errorf("%s must be a date-time string", ttl.Property)
+	}
+
+	return nil
+}
+
 // ResourceDefinition defines an API resource
 type ResourceDefinition struct {
-	ID            string    `json:"id"` // ID is the unique identifier for this resource definition
-	ProjectID     string    `json:"project_id"`
-	Title         string    `json:"title"`     // Title of this resource
-	PathName      string    `json:"path_name"` // PathName is the name that will appear in the URL path
-	ParallelRead  bool      `json:"parallel_read"`
-	ParallelWrite bool      `json:"parallel_write"`
-	Create        bool      `json:"create"`
-	Read          bool      `json:"read"`
-	Update        bool      `json:"update"`
-	Delete        bool      `json:"delete"`
-	Created       time.Time `json:"created"` // Created is the timestamp the resource was created
-	Schema        string    `json:"schema"`  // Properties is the string representation of the JSON schema properties
+	ID            string       `json:"id"` // ID is the unique identifier for this resource definition
+	ProjectID     string       `json:"project_id"`
+	Title         string       `json:"title"`     // Title of this resource
+	PathName      string       `json:"path_name"` // PathName is the name that will appear in the URL path
+	ParallelRead  bool         `json:"parallel_read"`
+	ParallelWrite bool         `json:"parallel_write"`
+	Create        bool         `json:"create"`
+	Read          bool         `json:"read"`
+	Update        bool         `json:"update"`
+	Delete        bool         `json:"delete"`
+	Created       time.Time    `json:"created"` // Created is the timestamp the resource was created
+	Schema        string       `json:"schema"`  // Properties is the string representation of the JSON schema properties
+	TTL           *ResourceTTL `json:"ttl"`     // TTL is the optional expiry of documents
 }
 
 // GetSchema returns the schema as a `Schema` object
@@ -121,6 +175,7 @@ func (def *ResourceDefinition) MarshalJSON() ([]byte, error) {
 		Delete        bool             `json:"delete"`
 		Created       time.Time        `json:"created"` // Created is the timestamp the resource was created
 		Schema        JSONSchemaObject `json:"schema"`  // Properties is the string representation of the JSON schema properties
+		TTL           *ResourceTTL     `json:"ttl"`
 	}{
 		ID:            def.ID,
 		ProjectID:     def.ProjectID,
@@ -134,6 +189,7 @@ func (def *ResourceDefinition) MarshalJSON() ([]byte, error) {
 		Delete:        def.Delete,
 		Created:       def.Created,
 		Schema:        schema,
+		TTL:           def.TTL,
 	})
 }
 
@@ -150,6 +206,7 @@ func (def *ResourceDefinition) UnmarshalJSON(b []byte) error {
 		Read          bool            `json:"read"`
 		Update        bool            `json:"update"`
 		Delete        bool            `json:"delete"`
+		TTL           *ResourceTTL    `json:"ttl"`
 	}{}
 
 	err := json.Unmarshal(b, &payload)
@@ -168,6 +225,7 @@ func (def *ResourceDefinition) UnmarshalJSON(b []byte) error {
 	def.Read = payload.Read
 	def.Update = payload.Update
 	def.Delete = payload.Delete
+	def.TTL = payload.TTL
 
 	return nil
 }
@@ -199,6 +257,16 @@ func (def *ResourceDefinition) Validate() error {
 		return err
 	}
 
+	if def.TTL != nil {
+		if err := def.TTL.Validate(&objectSchema); err != nil {
+			return err
+		}
+	}
+
+	if err := validateFieldRoles(&objectSchema); err != nil {
+		return err
+	}
+
 	schema := new(spec.Schema)
 
 	err = json.Unmarshal([]byte(def.Schema), schema)
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceTTLValidateDocument(t *testing.T) {
+	ttl := &ResourceTTL{Seconds: 60, Property: "expires"}
+
+	tables := []struct {
+		name  string
+		value interface{}
+		valid bool
+	}{
+		{"date-time", "2024-01-02T03:04:05Z", true},
+		{"missing", nil, true},
+		{"invalid date", "2024-13-45T99:99", false},
+		{"invalid date-time", "2024-13-45T99:99:00Z", false},
+		{"number", 1704164645, false},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ttl.ValidateDocument(ResourceObject{"expires": tt.value})
+			assert.Equal(t, tt.valid, err == nil)
+		})
+	}
+
+	// documents are rejected on write
+	definition := &ResourceDefinition{
+		Schema: `{"type": "object", "properties": {"expires": {"type": "string", "format": "date-time"}}}`,
+		TTL:    ttl,
+	}
+	obj := ResourceObject{"expires": "2024-13-45T99:99"}
+	assert.NotNil(t, obj.Validate(definition))
+}
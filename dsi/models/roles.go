@@ -0,0 +1,177 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RoleWildcard is the resource or root key of a permission which applies to all of them
+const RoleWildcard = "*"
+
+var (
+	roleName      = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+	conditionName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// reservedRoles are the built-in roles of every project, which cannot be redefined
+var reservedRoles = map[string]bool{"user": true, "admin": true, "anonymous": true}
+
+// Role is a custom role of a project, assigned to project users and API keys in place of the built-in `user` and
+// `admin` roles. A role only has the permissions it lists.
+type Role struct {
+	ID          string            `json:"id"`
+	ProjectID   string            `json:"project_id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Permissions []*RolePermission `json:"permissions"`
+	Created     time.Time         `json:"created"`
+}
+
+// RolePermission grants the verbs on a resource or JSON root key. Conditions restrict the documents of a resource to
+// those matching every condition, such as `team_id == user.team_id`.
+type RolePermission struct {
+	Resource   string   `json:"resource,omitempty"` // Resource is the path name of a resource definition or `*`
+	RootKey    string   `json:"root_key,omitempty"` // RootKey is the key of a JSON root key or `*`
+	Verbs      []string `json:"verbs"`
+	Conditions []string `json:"conditions,omitempty"`
+}
+
+// RowCondition is a parsed permission condition, the document field must equal either the literal `Value` or the
+// requester attribute `Attribute`
+type RowCondition struct {
+	Field     string
+	Value     string
+	Attribute string
+}
+
+// Validate checks the name of the role and each of its permissions
+func (r *Role) Validate() error {
+	if !roleName.MatchString(r.Name) {
+		return errors.New("invalid role name, must be up to 32 lowercase letters, digits, dashes or underscores")
+	}
+	if reservedRoles[r.Name] {
+		return fmt.Errorf("'%s' is a built-in role", r.Name)
+	}
+
+	for _, permission := range r.Permissions {
+		if permission == nil {
+			return errors.New("invalid permission")
+		}
+		if (permission.Resource == "") == (permission.RootKey == "") {
+			return errors.New("a permission must have either a resource or a root_key")
+		}
+		if len(permission.Verbs) == 0 {
+			return errors.New("a permission must have at least one verb")
+		}
+		for _, verb := range permission.Verbs {
+			if !scopeVerbs[verb] {
+				return fmt.Errorf("invalid permission verb '%s', must be one of 'read', 'create', 'update' or 'delete'", verb)
+			}
+		}
+		if len(permission.Conditions) > 0 && permission.Resource == "" {
+			return errors.New("conditions can only be applied to resources")
+		}
+		if _, err := permission.RowConditions(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ResourcePermission returns the permission of the role which grants the HTTP method on the resource, nil if the
+// method is not granted. A permission of the resource takes precedence over a `*` permission.
+func (r *Role) ResourcePermission(pathName, method string) *RolePermission {
+	return r.permission(func(p *RolePermission) string { return p.Resource }, pathName, method)
+}
+
+// RootKeyPermission returns the permission of the role which grants the HTTP method on the JSON root key, nil if the
+// method is not granted
+func (r *Role) RootKeyPermission(rootKey, method string) *RolePermission {
+	return r.permission(func(p *RolePermission) string { return p.RootKey }, rootKey, method)
+}
+
+func (r *Role) permission(target func(p *RolePermission) string, name, method string) *RolePermission {
+	verb := ScopeVerb(method)
+
+	var wildcard *RolePermission
+	for _, permission := range r.Permissions {
+		if !permission.allows(verb) {
+			continue
+		}
+		switch target(permission) {
+		case name:
+			return permission
+		case RoleWildcard:
+			if wildcard == nil {
+				wildcard = permission
+			}
+		}
+	}
+
+	return wildcard
+}
+
+func (p *RolePermission) allows(verb string) bool {
+	for _, v := range p.Verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// RowConditions parses the conditions of the permission. A condition compares a document field with `==` to a quoted
+// string, a number, `true`, `false` or a requester attribute (`user.id`, `user.name`, `user.role` or any attribute of
+// the project user). Comparisons can be joined with `&&`.
+func (p *RolePermission) RowConditions() ([]*RowCondition, error) {
+	conditions := make([]*RowCondition, 0)
+	for _, expression := range p.Conditions {
+		for _, comparison := range strings.Split(expression, "&&") {
+			condition, err := parseRowCondition(comparison)
+			if err != nil {
+				return nil, fmt.Errorf("condition '%s': %s", strings.TrimSpace(expression), err.Error())
+			}
+			conditions = append(conditions, condition)
+		}
+	}
+	return conditions, nil
+}
+
+func parseRowCondition(comparison string) (*RowCondition, error) {
+	parts := strings.Split(comparison, "==")
+	if len(parts) != 2 {
+		return nil, errors.New("must compare a field to a value with '=='")
+	}
+	field := strings.TrimSpace(parts[0])
+	operand := strings.TrimSpace(parts[1])
+
+	// the field is a top level property of the document or the creator of the document
+	if field != "_metadata.creator" && !conditionName.MatchString(field) {
+		return nil, fmt.Errorf("invalid field '%s'", field)
+	}
+
+	condition := &RowCondition{Field: field}
+	switch {
+	case strings.HasPrefix(operand, "user."):
+		condition.Attribute = strings.TrimPrefix(operand, "user.")
+		if !conditionName.MatchString(condition.Attribute) {
+			return nil, fmt.Errorf("invalid attribute '%s'", operand)
+		}
+	case len(operand) >= 2 && (operand[0] == '\'' || operand[0] == '"') && operand[len(operand)-1] == operand[0]:
+		condition.Value = operand[1 : len(operand)-1]
+	case operand == "true" || operand == "false":
+		condition.Value = operand
+	default:
+		if _, err := strconv.ParseFloat(operand, 64); err != nil {
+			return nil, fmt.Errorf("invalid value '%s'", operand)
+		}
+		condition.Value = operand
+	}
+
+	return condition, nil
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolePermission(t *testing.T) {
+	role := &Role{
+		Name: "editor",
+		Permissions: []*RolePermission{
+			{Resource: "posts", Verbs: []string{VerbRead, VerbUpdate}, Conditions: []string{"team_id == user.team_id"}},
+			{Resource: "*", Verbs: []string{VerbRead}},
+			{RootKey: "settings", Verbs: []string{VerbRead}},
+		},
+	}
+
+	tables := []struct {
+		name       string
+		resource   string
+		rootKey    string
+		method     string
+		permission *RolePermission
+	}{
+		{"resource permission", "posts", "", "PUT", role.Permissions[0]},
+		{"resource before wildcard", "posts", "", "GET", role.Permissions[0]},
+		{"wildcard resource", "comments", "", "GET", role.Permissions[1]},
+		{"verb not granted", "posts", "", "DELETE", nil},
+		{"wildcard verb not granted", "comments", "", "POST", nil},
+		{"root key permission", "", "settings", "GET", role.Permissions[2]},
+		{"root key verb not granted", "", "settings", "PUT", nil},
+		{"root key not granted", "", "flags", "GET", nil},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.resource != "" {
+				assert.Equal(t, tt.permission, role.ResourcePermission(tt.resource, tt.method))
+			} else {
+				assert.Equal(t, tt.permission, role.RootKeyPermission(tt.rootKey, tt.method))
+			}
+		})
+	}
+}
+
+func TestRowConditions(t *testing.T) {
+	permission := &RolePermission{
+		Resource:   "posts",
+		Verbs:      []string{VerbRead},
+		Conditions: []string{"team_id == user.team_id && published == true", "category == 'news'", "_metadata.creator == user.id", "rank == 3"},
+	}
+
+	conditions, err := permission.RowConditions()
+	assert.Nil(t, err)
+	assert.Equal(t, []*RowCondition{
+		{Field: "team_id", Attribute: "team_id"},
+		{Field: "published", Value: "true"},
+		{Field: "category", Value: "news"},
+		{Field: "_metadata.creator", Attribute: "id"},
+		{Field: "rank", Value: "3"},
+	}, conditions)
+}
+
+func TestRoleValidate(t *testing.T) {
+	tables := []struct {
+		name  string
+		role  *Role
+		valid bool
+	}{
+		{"valid", &Role{Name: "team-member", Permissions: []*RolePermission{{Resource: "posts", Verbs: []string{VerbRead}, Conditions: []string{"team_id == user.team_id"}}}}, true},
+		{"no permissions", &Role{Name: "viewer"}, true},
+		{"built-in name", &Role{Name: "admin"}, false},
+		{"invalid name", &Role{Name: "Team Member"}, false},
+		{"resource and root key", &Role{Name: "r", Permissions: []*RolePermission{{Resource: "posts", RootKey: "settings", Verbs: []string{VerbRead}}}}, false},
+		{"no verbs", &Role{Name: "r", Permissions: []*RolePermission{{Resource: "posts"}}}, false},
+		{"invalid verb", &Role{Name: "r", Permissions: []*RolePermission{{Resource: "posts", Verbs: []string{"write"}}}}, false},
+		{"root key conditions", &Role{Name: "r", Permissions: []*RolePermission{{RootKey: "settings", Verbs: []string{VerbRead}, Conditions: []string{"a == 'b'"}}}}, false},
+		{"not an equality", &Role{Name: "r", Permissions: []*RolePermission{{Resource: "posts", Verbs: []string{VerbRead}, Conditions: []string{"team_id != user.team_id"}}}}, false},
+		{"invalid field", &Role{Name: "r", Permissions: []*RolePermission{{Resource: "posts", Verbs: []string{VerbRead}, Conditions: []string{"team'id == 'a'"}}}}, false},
+		{"invalid value", &Role{Name: "r", Permissions: []*RolePermission{{Resource: "posts", Verbs: []string{VerbRead}, Conditions: []string{"team_id == team"}}}}, false},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.role.Validate()
+			assert.Equal(t, tt.valid, err == nil, "%v", err)
+		})
+	}
+}
@@ -15,4 +15,9 @@ type Session struct {
 	LastAccessed time.Time `json:"last_accessed"`
 	Browser      string    `json:"browser"`
 	OS           string    `json:"os"`
+	// RefreshTokenID is the ID of the only refresh token of the session which has not been used
+	RefreshTokenID string `json:"-"`
+	// MFA is set for app sessions which were logged in with a second factor
+	MFA     bool      `json:"mfa"`
+	Expires time.Time `json:"expires"`
 }
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// SigningKey is an asymmetric key which signs the JWTs of a project, or of application users if the project is empty.
+// A key signs tokens from `Activates` until `Rotates`, it is published until `Expires` so the tokens it signed can be
+// verified until they expire.
+type SigningKey struct {
+	ID         string    `json:"kid"`
+	ProjectID  string    `json:"project_id"`
+	Algorithm  string    `json:"alg"`
+	PrivateKey string    `json:"-"` // PrivateKey is PEM encoded and encrypted with the key encryption key
+	Created    time.Time `json:"created"`
+	Activates  time.Time `json:"activates"`
+	Rotates    time.Time `json:"rotates"`
+	Expires    time.Time `json:"expires"`
+}
+
+// Signs returns true if the key signs new tokens at the time
+func (k *SigningKey) Signs(now time.Time) bool {
+	return !now.Before(k.Activates) && now.Before(k.Rotates)
+}
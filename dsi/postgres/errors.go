@@ -12,6 +12,9 @@ import (
 	"github.com/machinable/machinable/dsi/models"
 )
 
+// uniqueViolation is the postgres error code of unique constraint violations
+const uniqueViolation = "23505"
+
 // TranslateError attempts to translate the database specific error to a simple `error` to return to the user.
 func (p *Database) TranslateError(err error) *models.TranslatedError {
 	originalError := err.Error()
@@ -19,10 +22,23 @@ func (p *Database) TranslateError(err error) *models.TranslatedError {
 	// log original error
 	log.Println(err)
 
+	switch err {
+	case models.ErrOperationConflict, models.ErrKeyExists, models.ErrKeyTypeConflict, models.ErrAPIKeyExists:
+		return models.NewTranslatedError(http.StatusConflict, err)
+	case models.ErrKeyNotFound, models.ErrVersionNotFound:
+		return models.NewTranslatedError(http.StatusNotFound, err)
+	case models.ErrInvalidArrayIndex, models.ErrHistoryDisabled:
+		return models.NewTranslatedError(http.StatusBadRequest, err)
+	}
+
+	if vErr, ok := err.(*models.SchemaValidationError); ok {
+		return models.NewTranslatedError(http.StatusBadRequest, vErr)
+	}
+
 	if err, ok := err.(*pq.Error); ok {
 		// postgres specific errors
 		switch err.Code {
-		case "23505":
+		case uniqueViolation:
 			return models.NewTranslatedError(http.StatusBadRequest, errors.New("key already exists"))
 		case "22023":
 			return models.NewTranslatedError(http.StatusBadRequest, errors.New("key already exists"))
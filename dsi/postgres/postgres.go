@@ -30,6 +30,29 @@ func New(user, password, host, database string) (*Database, error) {
 	}, nil
 }
 
+// transact runs `fn` within a transaction. The transaction is committed if `fn` returns `nil`, otherwise it is rolled back.
+func (d *Database) transact(fn func(tx *sql.Tx) error) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// jsonbValue returns the JSON as a query parameter, empty JSON is passed as `NULL` rather than an empty string
+func jsonbValue(value []byte) interface{} {
+	if len(value) == 0 {
+		return nil
+	}
+	return value
+}
+
 func (d *Database) mapToQuery(filter map[string]interface{}, validFields map[string]bool, filterString *[]string, args *[]interface{}, index *int, tableAlias string) error {
 	for key, value := range filter {
 		if _, acceptsAny := validFields["*"]; !acceptsAny {
@@ -1,67 +1,183 @@
 package postgres
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/machinable/machinable/dsi/models"
 )
 
 const tableProjectAPIKeys = "project_apikeys"
 
+const apiKeyColumns = "id, project_id, key_id, key_hash, description, read, write, role, scopes, allowed_ips, expires, last_used, created"
+
 // GetAPIKeyByKey retrieves a single api key by key hash
 func (d *Database) GetAPIKeyByKey(projectID, hash string) (*models.ProjectAPIKey, error) {
-	key := models.ProjectAPIKey{}
-	err := d.db.QueryRow(
+	return scanAPIKey(d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, project_id, key_hash, description, read, write, role, created FROM %s WHERE project_id=$1 and key_hash=$2",
+			"SELECT %s FROM %s WHERE project_id=$1 and key_hash=$2",
+			apiKeyColumns,
 			tableProjectAPIKeys,
 		),
 		projectID,
 		hash,
-	).Scan(
-		&key.ID,
-		&key.ProjectID,
-		&key.KeyHash,
-		&key.Description,
-		&key.Read,
-		&key.Write,
-		&key.Role,
-		&key.Created,
+	))
+}
+
+// GetAPIKeyByKeyID retrieves a single api key by the key ID embedded in prefixed keys
+func (d *Database) GetAPIKeyByKeyID(projectID, keyID string) (*models.ProjectAPIKey, error) {
+	return scanAPIKey(d.db.QueryRow(
+		fmt.Sprintf(
+			"SELECT %s FROM %s WHERE project_id=$1 and key_id=$2",
+			apiKeyColumns,
+			tableProjectAPIKeys,
+		),
+		projectID,
+		keyID,
+	))
+}
+
+// GetAPIKey retrieves a single api key by ID
+func (d *Database) GetAPIKey(projectID, id string) (*models.ProjectAPIKey, error) {
+	return scanAPIKey(d.db.QueryRow(
+		fmt.Sprintf(
+			"SELECT %s FROM %s WHERE project_id=$1 and id=$2",
+			apiKeyColumns,
+			tableProjectAPIKeys,
+		),
+		projectID,
+		id,
+	))
+}
+
+// rowQuerier is implemented by `*sql.DB` and `*sql.Tx`
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// CreateAPIKey creates a new api key for the project, `ErrAPIKeyExists` is returned if the project has a key with the
+// same key ID
+func (d *Database) CreateAPIKey(projectID string, key *models.ProjectAPIKey) (*models.ProjectAPIKey, error) {
+	if err := insertAPIKey(d.db, projectID, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RotateAPIKey creates the replacement of an api key and sets the expiration of the rotated key in one transaction
+func (d *Database) RotateAPIKey(projectID, keyID string, expires time.Time, replacement *models.ProjectAPIKey) (*models.ProjectAPIKey, error) {
+	err := d.transact(func(tx *sql.Tx) error {
+		if err := insertAPIKey(tx, projectID, replacement); err != nil {
+			return err
+		}
+
+		res, err := tx.Exec(
+			fmt.Sprintf(
+				"UPDATE %s SET expires=$1 WHERE id=$2 and project_id=$3",
+				tableProjectAPIKeys,
+			),
+			expires,
+			keyID,
+			projectID,
+		)
+		if err != nil {
+			return err
+		}
+		if updated, err := res.RowsAffected(); err != nil || updated == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return replacement, nil
+}
+
+func insertAPIKey(db rowQuerier, projectID string, key *models.ProjectAPIKey) error {
+	scopes, err := marshalScopes(key.Scopes)
+	if err != nil {
+		return err
+	}
+
+	key.ProjectID = projectID
+	key.Created = time.Now()
+	err = db.QueryRow(
+		fmt.Sprintf(
+			"INSERT INTO %s (project_id, key_id, key_hash, description, read, write, role, scopes, allowed_ips, expires, created) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id",
+			tableProjectAPIKeys,
+		),
+		projectID,
+		nullString(key.KeyID),
+		key.KeyHash,
+		key.Description,
+		key.Read,
+		key.Write,
+		key.Role,
+		scopes,
+		pq.Array(key.AllowedIPs),
+		key.Expires,
+		key.Created,
+	).Scan(&key.ID)
+	if err, ok := err.(*pq.Error); ok && err.Code == uniqueViolation {
+		return models.ErrAPIKeyExists
+	}
+
+	return err
+}
+
+// UpdateAPIKey updates the role, access, scopes, allowed IPs and expiration of an API key
+func (d *Database) UpdateAPIKey(projectID, keyID string, key *models.ProjectAPIKey) error {
+	scopes, err := marshalScopes(key.Scopes)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET read=$1, write=$2, role=$3, scopes=$4, allowed_ips=$5, expires=$6 WHERE id=$7 and project_id=$8",
+			tableProjectAPIKeys,
+		),
+		key.Read,
+		key.Write,
+		key.Role,
+		scopes,
+		pq.Array(key.AllowedIPs),
+		key.Expires,
+		keyID,
+		projectID,
 	)
 
-	return &key, err
+	return err
 }
 
-// CreateAPIKey creates a new api key for the project
-func (d *Database) CreateAPIKey(projectID, hash, description string, read, write bool, role string) (*models.ProjectAPIKey, error) {
+// UpdateAPIKeyLastUsed sets the time the API key was last used
+func (d *Database) UpdateAPIKeyLastUsed(projectID, keyID string, lastUsed time.Time) error {
 	_, err := d.db.Exec(
 		fmt.Sprintf(
-			"INSERT INTO %s (project_id, key_hash, description, read, write, role, created) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			"UPDATE %s SET last_used=$1 WHERE id=$2 and project_id=$3",
 			tableProjectAPIKeys,
 		),
+		lastUsed,
+		keyID,
 		projectID,
-		hash,
-		description,
-		read,
-		write,
-		role,
-		time.Now(),
 	)
 
-	return nil, err
+	return err
 }
 
-// UpdateAPIKey updates the role and access of an API key
-func (d *Database) UpdateAPIKey(projectID, keyID string, read, write bool, role string) error {
+// UpdateAPIKeyHash replaces the hash of an API key, i.e. to migrate a legacy hash
+func (d *Database) UpdateAPIKeyHash(projectID, keyID, hash string) error {
 	_, err := d.db.Exec(
 		fmt.Sprintf(
-			"UPDATE %s SET read=$1, write=$2, role=$3 WHERE id=$4 and project_id=$5",
+			"UPDATE %s SET key_hash=$1 WHERE id=$2 and project_id=$3",
 			tableProjectAPIKeys,
 		),
-		read,
-		write,
-		role,
+		hash,
 		keyID,
 		projectID,
 	)
@@ -73,7 +189,8 @@ func (d *Database) UpdateAPIKey(projectID, keyID string, read, write bool, role
 func (d *Database) ListAPIKeys(projectID string) ([]*models.ProjectAPIKey, error) {
 	rows, err := d.db.Query(
 		fmt.Sprintf(
-			"SELECT id, project_id, key_hash, description, read, write, role, created FROM %s WHERE project_id=$1",
+			"SELECT %s FROM %s WHERE project_id=$1",
+			apiKeyColumns,
 			tableProjectAPIKeys,
 		),
 		projectID,
@@ -85,22 +202,12 @@ func (d *Database) ListAPIKeys(projectID string) ([]*models.ProjectAPIKey, error
 
 	keys := make([]*models.ProjectAPIKey, 0)
 	for rows.Next() {
-		key := models.ProjectAPIKey{}
-		err = rows.Scan(
-			&key.ID,
-			&key.ProjectID,
-			&key.KeyHash,
-			&key.Description,
-			&key.Read,
-			&key.Write,
-			&key.Role,
-			&key.Created,
-		)
+		key, err := scanAPIKey(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		keys = append(keys, &key)
+		keys = append(keys, key)
 	}
 
 	return keys, rows.Err()
@@ -130,3 +237,43 @@ func (d *Database) DropProjectKeys(projectID string) error {
 	)
 	return err
 }
+
+func scanAPIKey(row scanner) (*models.ProjectAPIKey, error) {
+	key := models.ProjectAPIKey{}
+	var keyID sql.NullString
+	var scopes []byte
+	err := row.Scan(
+		&key.ID,
+		&key.ProjectID,
+		&keyID,
+		&key.KeyHash,
+		&key.Description,
+		&key.Read,
+		&key.Write,
+		&key.Role,
+		&scopes,
+		pq.Array(&key.AllowedIPs),
+		&key.Expires,
+		&key.LastUsed,
+		&key.Created,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.KeyID = keyID.String
+	key.Scopes = models.APIKeyScopes{}
+	if err := json.Unmarshal(scopes, &key.Scopes); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// marshalScopes encodes the scopes of an API key for the JSONB scopes column
+func marshalScopes(scopes models.APIKeyScopes) ([]byte, error) {
+	if scopes == nil {
+		scopes = models.APIKeyScopes{}
+	}
+	return json.Marshal(scopes)
+}
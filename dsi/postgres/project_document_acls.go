@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const documentACLColumns = "resource_path, document_id, principal_type, principal_id, read, write, created"
+
+// ListDocumentACL returns the ACL entries of a document
+func (d *Database) ListDocumentACL(projectID, pathName, documentID string) ([]*models.DocumentACL, error) {
+	rows, err := d.db.Query(
+		fmt.Sprintf(
+			"SELECT %s FROM %s WHERE project_id=$1 and resource_path=$2 and document_id=$3 ORDER BY principal_type, principal_id",
+			documentACLColumns,
+			tableProjectDocumentACLs,
+		),
+		projectID,
+		pathName,
+		documentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*models.DocumentACL, 0)
+	for rows.Next() {
+		acl := &models.DocumentACL{}
+		err := rows.Scan(
+			&acl.ResourcePath,
+			&acl.DocumentID,
+			&acl.PrincipalType,
+			&acl.PrincipalID,
+			&acl.Read,
+			&acl.Write,
+			&acl.Created,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, acl)
+	}
+
+	return entries, rows.Err()
+}
+
+// GrantDocumentAccess creates the ACL entry of the principal on the document, or replaces its access if it exists
+func (d *Database) GrantDocumentAccess(projectID string, acl *models.DocumentACL) error {
+	acl.Created = time.Now()
+
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"INSERT INTO %s (project_id, %s) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (project_id, document_id, principal_type, principal_id) DO UPDATE SET read=EXCLUDED.read, write=EXCLUDED.write",
+			tableProjectDocumentACLs,
+			documentACLColumns,
+		),
+		projectID,
+		acl.ResourcePath,
+		acl.DocumentID,
+		acl.PrincipalType,
+		acl.PrincipalID,
+		acl.Read,
+		acl.Write,
+		acl.Created,
+	)
+
+	return err
+}
+
+// RevokeDocumentAccess deletes the ACL entry of the principal on the document
+func (d *Database) RevokeDocumentAccess(projectID, pathName, documentID, principalType, principalID string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE project_id=$1 and resource_path=$2 and document_id=$3 and principal_type=$4 and principal_id=$5",
+			tableProjectDocumentACLs,
+		),
+		projectID,
+		pathName,
+		documentID,
+		principalType,
+		principalID,
+	)
+
+	return err
+}
@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const tableProjectEmailTemplates = "project_email_templates"
+
+// ListEmailTemplates returns the customized email templates of a project
+func (d *Database) ListEmailTemplates(projectID string) ([]*models.EmailTemplate, error) {
+	rows, err := d.db.Query(
+		fmt.Sprintf(
+			"SELECT id, project_id, name, subject, body, url, updated FROM %s WHERE project_id=$1 ORDER BY name",
+			tableProjectEmailTemplates,
+		),
+		projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := make([]*models.EmailTemplate, 0)
+	for rows.Next() {
+		template, err := scanEmailTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+
+	return templates, rows.Err()
+}
+
+// GetEmailTemplate retrieves the email template of a project by name, the default template is returned if the project
+// has not customized it
+func (d *Database) GetEmailTemplate(projectID, name string) (*models.EmailTemplate, error) {
+	template, err := scanEmailTemplate(d.db.QueryRow(
+		fmt.Sprintf(
+			"SELECT id, project_id, name, subject, body, url, updated FROM %s WHERE project_id=$1 and name=$2",
+			tableProjectEmailTemplates,
+		),
+		projectID,
+		name,
+	))
+	if err == sql.ErrNoRows {
+		if def := models.DefaultEmailTemplate(name); def != nil {
+			def.ProjectID = projectID
+			return def, nil
+		}
+	}
+	return template, err
+}
+
+// SaveEmailTemplate creates or replaces the email template of a project
+func (d *Database) SaveEmailTemplate(projectID string, template *models.EmailTemplate) error {
+	template.Updated = time.Now()
+
+	return d.db.QueryRow(
+		fmt.Sprintf(
+			"INSERT INTO %s (project_id, name, subject, body, url, updated) VALUES ($1, $2, $3, $4, $5, $6) "+
+				"ON CONFLICT (project_id, name) DO UPDATE SET subject=EXCLUDED.subject, body=EXCLUDED.body, url=EXCLUDED.url, updated=EXCLUDED.updated "+
+				"RETURNING id",
+			tableProjectEmailTemplates,
+		),
+		projectID,
+		template.Name,
+		template.Subject,
+		template.Body,
+		template.URL,
+		template.Updated,
+	).Scan(&template.ID)
+}
+
+// DeleteEmailTemplate deletes the email template of a project, the project uses the default template again
+func (d *Database) DeleteEmailTemplate(projectID, name string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE project_id=$1 and name=$2",
+			tableProjectEmailTemplates,
+		),
+		projectID,
+		name,
+	)
+	return err
+}
+
+// DropProjectEmailTemplates deletes all email templates of a project
+func (d *Database) DropProjectEmailTemplates(projectID string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE project_id=$1",
+			tableProjectEmailTemplates,
+		),
+		projectID,
+	)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEmailTemplate(row scanner) (*models.EmailTemplate, error) {
+	template := &models.EmailTemplate{}
+	var url sql.NullString
+
+	err := row.Scan(
+		&template.ID,
+		&template.ProjectID,
+		&template.Name,
+		&template.Subject,
+		&template.Body,
+		&url,
+		&template.Updated,
+	)
+	if err != nil {
+		return nil, err
+	}
+	template.URL = url.String
+
+	return template, nil
+}
@@ -0,0 +1,154 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/machinable/machinable/dsi/errors"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const tableProjectEventOutbox = "project_event_outbox"
+
+// insertOutboxEvent records the event as part of the transaction `tx`. A `nil` event is ignored, this is the case when
+// hooks have been disabled for the request.
+func insertOutboxEvent(tx *sql.Tx, projectID string, event *models.OutboxEvent) error {
+	if event == nil {
+		return nil
+	}
+
+	event.ProjectID = projectID
+	event.Created = time.Now()
+
+	return tx.QueryRow(
+		fmt.Sprintf(
+			"INSERT INTO %s (project_id, entity, entity_key, entity_id, action, keys, payload, created) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+			tableProjectEventOutbox,
+		),
+		projectID,
+		event.Entity,
+		event.EntityKey,
+		event.EntityID,
+		event.Action,
+		pq.Array(event.Keys),
+		jsonbValue(event.Payload),
+		event.Created,
+	).Scan(&event.ID)
+}
+
+// ClaimOutboxEvents leases up to `limit` unpublished events for the duration of `lease`. Events that are not marked as
+// published before the lease expires will be claimed again, so events are delivered at least once, until they have been
+// claimed `max_attempts` times.
+func (d *Database) ClaimOutboxEvents(limit int, lease time.Duration) ([]*models.OutboxEvent, *errors.DatastoreError) {
+	now := time.Now()
+	rows, err := d.db.Query(
+		fmt.Sprintf(
+			"UPDATE %s SET attempts=attempts+1, locked_until=$1 WHERE id IN (SELECT id FROM %s WHERE published IS NULL AND dead_lettered IS NULL AND attempts < max_attempts AND (locked_until IS NULL OR locked_until < $2) ORDER BY created LIMIT $3 FOR UPDATE SKIP LOCKED) RETURNING id, project_id, entity, entity_key, entity_id, action, keys, payload, attempts, max_attempts, created",
+			tableProjectEventOutbox,
+			tableProjectEventOutbox,
+		),
+		now.Add(lease),
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, errors.New(errors.UnknownError, err)
+	}
+	defer rows.Close()
+
+	events := make([]*models.OutboxEvent, 0)
+	for rows.Next() {
+		event := models.OutboxEvent{}
+		err = rows.Scan(
+			&event.ID,
+			&event.ProjectID,
+			&event.Entity,
+			&event.EntityKey,
+			&event.EntityID,
+			&event.Action,
+			pq.Array(&event.Keys),
+			&event.Payload,
+			&event.Attempts,
+			&event.MaxAttempts,
+			&event.Created,
+		)
+		if err != nil {
+			return nil, errors.New(errors.UnknownError, err)
+		}
+
+		events = append(events, &event)
+	}
+
+	// `RETURNING` does not preserve the sub query order
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Created.Before(events[j].Created)
+	})
+
+	return events, errors.New(errors.UnknownError, rows.Err())
+}
+
+// MarkOutboxEventPublished marks the event as published so it is no longer claimed by the relay
+func (d *Database) MarkOutboxEventPublished(eventID string) *errors.DatastoreError {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET published=$1, locked_until=NULL WHERE id=$2",
+			tableProjectEventOutbox,
+		),
+		time.Now(),
+		eventID,
+	)
+
+	return errors.New(errors.UnknownError, err)
+}
+
+// DeadLetterOutboxEvents marks the unpublished events which have been claimed `max_attempts` times, and whose last lease
+// has expired, as dead lettered so they are no longer claimed. Returns the number of dead lettered events.
+func (d *Database) DeadLetterOutboxEvents() (int64, *errors.DatastoreError) {
+	now := time.Now()
+	res, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET dead_lettered=$1, locked_until=NULL WHERE published IS NULL AND dead_lettered IS NULL AND attempts >= max_attempts AND (locked_until IS NULL OR locked_until < $1)",
+			tableProjectEventOutbox,
+		),
+		now,
+	)
+	if err != nil {
+		return 0, errors.New(errors.UnknownError, err)
+	}
+
+	deadLettered, err := res.RowsAffected()
+	return deadLettered, errors.New(errors.UnknownError, err)
+}
+
+// DropProjectOutboxEvents removes all outbox events for a project
+func (d *Database) DropProjectOutboxEvents(projectID string) *errors.DatastoreError {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE project_id=$1",
+			tableProjectEventOutbox,
+		),
+		projectID,
+	)
+
+	return errors.New(errors.UnknownError, err)
+}
+
+// PurgePublishedOutboxEvents deletes all events that were published before `before`
+func (d *Database) PurgePublishedOutboxEvents(before time.Time) (int64, *errors.DatastoreError) {
+	res, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE published < $1",
+			tableProjectEventOutbox,
+		),
+		before,
+	)
+	if err != nil {
+		return 0, errors.New(errors.UnknownError, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	return deleted, errors.New(errors.UnknownError, err)
+}
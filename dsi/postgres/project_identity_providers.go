@@ -0,0 +1,222 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const (
+	tableProjectIdentityProviders = "project_identity_providers"
+	tableProjectUserIdentities    = "project_user_identities"
+)
+
+const identityProviderColumns = "id, project_id, name, type, client_id, client_secret, issuer, authorization_url, token_url, userinfo_url, scopes, redirect_urls, provision, read, write, created"
+
+// ListIdentityProviders returns the identity providers of a project
+func (d *Database) ListIdentityProviders(projectID string) ([]*models.IdentityProvider, error) {
+	rows, err := d.db.Query(
+		fmt.Sprintf(
+			"SELECT %s FROM %s WHERE project_id=$1 ORDER BY name",
+			identityProviderColumns,
+			tableProjectIdentityProviders,
+		),
+		projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	providers := make([]*models.IdentityProvider, 0)
+	for rows.Next() {
+		provider, err := scanIdentityProvider(rows)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, rows.Err()
+}
+
+// GetIdentityProvider retrieves an identity provider of a project by name
+func (d *Database) GetIdentityProvider(projectID, name string) (*models.IdentityProvider, error) {
+	return scanIdentityProvider(d.db.QueryRow(
+		fmt.Sprintf(
+			"SELECT %s FROM %s WHERE project_id=$1 and name=$2",
+			identityProviderColumns,
+			tableProjectIdentityProviders,
+		),
+		projectID,
+		name,
+	))
+}
+
+// SaveIdentityProvider creates or replaces the identity provider of a project
+func (d *Database) SaveIdentityProvider(projectID string, provider *models.IdentityProvider) error {
+	provider.ProjectID = projectID
+	provider.Created = time.Now()
+
+	return d.db.QueryRow(
+		fmt.Sprintf(
+			"INSERT INTO %s (project_id, name, type, client_id, client_secret, issuer, authorization_url, token_url, userinfo_url, scopes, redirect_urls, provision, read, write, created) "+
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) "+
+				"ON CONFLICT (project_id, name) DO UPDATE SET type=EXCLUDED.type, client_id=EXCLUDED.client_id, client_secret=EXCLUDED.client_secret, "+
+				"issuer=EXCLUDED.issuer, authorization_url=EXCLUDED.authorization_url, token_url=EXCLUDED.token_url, userinfo_url=EXCLUDED.userinfo_url, "+
+				"scopes=EXCLUDED.scopes, redirect_urls=EXCLUDED.redirect_urls, provision=EXCLUDED.provision, read=EXCLUDED.read, write=EXCLUDED.write "+
+				"RETURNING id, created",
+			tableProjectIdentityProviders,
+		),
+		projectID,
+		provider.Name,
+		provider.Type,
+		provider.ClientID,
+		provider.ClientSecret,
+		provider.Issuer,
+		provider.AuthorizationURL,
+		provider.TokenURL,
+		provider.UserInfoURL,
+		pq.Array(provider.Scopes),
+		pq.Array(provider.RedirectURLs),
+		provider.Provision,
+		provider.Read,
+		provider.Write,
+		provider.Created,
+	).Scan(&provider.ID, &provider.Created)
+}
+
+// DeleteIdentityProvider deletes the identity provider of a project and unlinks its identities from project users
+func (d *Database) DeleteIdentityProvider(projectID, name string) error {
+	return d.transact(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(
+			fmt.Sprintf("DELETE FROM %s WHERE project_id=$1 and provider=$2", tableProjectUserIdentities),
+			projectID,
+			name,
+		); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			fmt.Sprintf("DELETE FROM %s WHERE project_id=$1 and name=$2", tableProjectIdentityProviders),
+			projectID,
+			name,
+		)
+		return err
+	})
+}
+
+// DropProjectIdentityProviders deletes all identity providers and user identities of a project
+func (d *Database) DropProjectIdentityProviders(projectID string) error {
+	return d.transact(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(
+			fmt.Sprintf("DELETE FROM %s WHERE project_id=$1", tableProjectUserIdentities),
+			projectID,
+		); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			fmt.Sprintf("DELETE FROM %s WHERE project_id=$1", tableProjectIdentityProviders),
+			projectID,
+		)
+		return err
+	})
+}
+
+// GetUserIdentity retrieves the identity of a provider's subject, nil is returned if the subject is not linked to a user
+func (d *Database) GetUserIdentity(projectID, provider, subject string) (*models.UserIdentity, error) {
+	identity := &models.UserIdentity{}
+	var email sql.NullString
+
+	err := d.db.QueryRow(
+		fmt.Sprintf(
+			"SELECT id, project_id, provider, subject, user_id, email, created FROM %s WHERE project_id=$1 and provider=$2 and subject=$3",
+			tableProjectUserIdentities,
+		),
+		projectID,
+		provider,
+		subject,
+	).Scan(
+		&identity.ID,
+		&identity.ProjectID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.UserID,
+		&email,
+		&identity.Created,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	identity.Email = email.String
+
+	return identity, nil
+}
+
+// CreateUserIdentity links the identity to a project user
+func (d *Database) CreateUserIdentity(projectID string, identity *models.UserIdentity) error {
+	identity.ProjectID = projectID
+	identity.Created = time.Now()
+
+	return d.db.QueryRow(
+		fmt.Sprintf(
+			"INSERT INTO %s (project_id, provider, subject, user_id, email, created) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+			tableProjectUserIdentities,
+		),
+		projectID,
+		identity.Provider,
+		identity.Subject,
+		identity.UserID,
+		identity.Email,
+		identity.Created,
+	).Scan(&identity.ID)
+}
+
+// DeleteUserIdentity unlinks an identity from its project user
+func (d *Database) DeleteUserIdentity(projectID, identityID string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE project_id=$1 and id=$2",
+			tableProjectUserIdentities,
+		),
+		projectID,
+		identityID,
+	)
+	return err
+}
+
+func scanIdentityProvider(row scanner) (*models.IdentityProvider, error) {
+	provider := &models.IdentityProvider{}
+	var issuer, userInfoURL sql.NullString
+
+	err := row.Scan(
+		&provider.ID,
+		&provider.ProjectID,
+		&provider.Name,
+		&provider.Type,
+		&provider.ClientID,
+		&provider.ClientSecret,
+		&issuer,
+		&provider.AuthorizationURL,
+		&provider.TokenURL,
+		&userInfoURL,
+		pq.Array(&provider.Scopes),
+		pq.Array(&provider.RedirectURLs),
+		&provider.Provision,
+		&provider.Read,
+		&provider.Write,
+		&provider.Created,
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider.Issuer = issuer.String
+	provider.UserInfoURL = userInfoURL.String
+
+	return provider, nil
+}
@@ -1,6 +1,8 @@
 package postgres
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -14,9 +16,10 @@ const tableProjectJSON = "project_json"
 // GetRootKey retrieves a single root key by the key name
 func (d *Database) GetRootKey(projectID, rootKey string) (*models.RootKey, error) {
 	newKey := models.RootKey{}
+	var rules, history []byte
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, project_id, root_key, \"create\", \"read\", \"update\", \"delete\" FROM %s WHERE project_id=$1 and root_key=$2",
+			"SELECT id, project_id, root_key, \"create\", \"read\", \"update\", \"delete\", schema, rules, history FROM %s WHERE project_id=$1 and root_key=$2",
 			tableProjectJSON,
 		),
 		projectID,
@@ -29,8 +32,19 @@ func (d *Database) GetRootKey(projectID, rootKey string) (*models.RootKey, error
 		&newKey.Read,
 		&newKey.Update,
 		&newKey.Delete,
+		(*[]byte)(&newKey.Schema),
+		&rules,
+		&history,
 	)
+	if err != nil {
+		return &newKey, err
+	}
+
+	if newKey.Rules, err = scanRules(rules); err != nil {
+		return &newKey, err
+	}
 
+	newKey.History, err = scanHistory(history)
 	return &newKey, err
 }
 
@@ -38,7 +52,7 @@ func (d *Database) GetRootKey(projectID, rootKey string) (*models.RootKey, error
 func (d *Database) ListRootKeys(projectID string) ([]*models.RootKey, error) {
 	rows, err := d.db.Query(
 		fmt.Sprintf(
-			"SELECT id, project_id, root_key, \"create\", \"read\", \"update\", \"delete\" FROM %s WHERE project_id=$1",
+			"SELECT id, project_id, root_key, \"create\", \"read\", \"update\", \"delete\", schema, rules, history FROM %s WHERE project_id=$1",
 			tableProjectJSON,
 		),
 		projectID,
@@ -51,6 +65,7 @@ func (d *Database) ListRootKeys(projectID string) ([]*models.RootKey, error) {
 	rootKeys := make([]*models.RootKey, 0)
 	for rows.Next() {
 		rootKey := models.RootKey{}
+		var rules, history []byte
 		err = rows.Scan(
 			&rootKey.ID,
 			&rootKey.ProjectID,
@@ -59,10 +74,19 @@ func (d *Database) ListRootKeys(projectID string) ([]*models.RootKey, error) {
 			&rootKey.Read,
 			&rootKey.Update,
 			&rootKey.Delete,
+			(*[]byte)(&rootKey.Schema),
+			&rules,
+			&history,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if rootKey.Rules, err = scanRules(rules); err != nil {
+			return nil, err
+		}
+		if rootKey.History, err = scanHistory(history); err != nil {
+			return nil, err
+		}
 
 		rootKeys = append(rootKeys, &rootKey)
 	}
@@ -93,112 +117,646 @@ func (d *Database) CreateRootKey(projectID, rootKey string, data []byte) error {
 	return err
 }
 
-// UpdateRootKey updates the data at the key path. Creates a new key if it does not already exist.
+// UpdateRootKey updates the access policies, schema, rules and history of the root key. The schema, rules and history
+// are kept if they are nil, a `null` schema and empty rules remove them. The tree is locked and must match the new
+// schema. The recorded versions are only removed if history is disabled.
 func (d *Database) UpdateRootKey(projectID string, rootKey *models.RootKey) error {
-	_, err := d.db.Exec(
-		fmt.Sprintf(
-			"UPDATE %s set \"create\"=$1, \"read\"=$2, \"update\"=$3, \"delete\"=$4 WHERE project_id=$5 and root_key=$6",
-			tableProjectJSON,
-		),
-		rootKey.Create,
-		rootKey.Read,
-		rootKey.Update,
-		rootKey.Delete,
-		projectID,
-		rootKey.Key,
-	)
-	return err
+	return d.transact(func(tx *sql.Tx) error {
+		var tree, schema, rules, history []byte
+		err := tx.QueryRow(
+			fmt.Sprintf(
+				"SELECT data, schema, rules, history FROM %s WHERE project_id=$1 and root_key=$2 FOR UPDATE",
+				tableProjectJSON,
+			),
+			projectID,
+			rootKey.Key,
+		).Scan(&tree, &schema, &rules, &history)
+		if err == sql.ErrNoRows {
+			return models.ErrKeyNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		// keep the settings which are not updated
+		if rootKey.Schema == nil {
+			rootKey.Schema = schema
+		}
+		if rootKey.Rules == nil {
+			if rootKey.Rules, err = scanRules(rules); err != nil {
+				return err
+			}
+		}
+		if rootKey.History == nil {
+			if rootKey.History, err = scanHistory(history); err != nil {
+				return err
+			}
+		}
+
+		if err := rootKey.ValidateData(tree); err != nil {
+			return err
+		}
+
+		schema = nil
+		if rootKey.HasSchema() {
+			schema = []byte(rootKey.Schema)
+		}
+
+		rules = nil
+		if len(rootKey.Rules) > 0 {
+			if rules, err = json.Marshal(rootKey.Rules); err != nil {
+				return err
+			}
+		}
+
+		history = nil
+		if rootKey.History != nil {
+			if history, err = json.Marshal(rootKey.History); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(
+			fmt.Sprintf(
+				"UPDATE %s set \"create\"=$1, \"read\"=$2, \"update\"=$3, \"delete\"=$4, schema=$5, rules=$6, history=$7 WHERE project_id=$8 and root_key=$9",
+				tableProjectJSON,
+			),
+			rootKey.Create,
+			rootKey.Read,
+			rootKey.Update,
+			rootKey.Delete,
+			schema,
+			rules,
+			history,
+			projectID,
+			rootKey.Key,
+		)
+		if err != nil || rootKey.History == nil || rootKey.History.Enabled {
+			return err
+		}
+
+		// versions can not be restored once changes are no longer recorded
+		return dropJSONVersions(tx, projectID, rootKey.Key)
+	})
 }
 
-//DeleteRootKey permanently deletes an entire rootkey's tree
+// DeleteRootKey permanently deletes an entire rootkey's tree and its versions
 func (d *Database) DeleteRootKey(projectID, rootKey string) error {
-	_, err := d.db.Exec(
-		fmt.Sprintf(
-			"DELETE FROM %s where project_id=$1 and root_key=$2",
-			tableProjectJSON,
-		),
-		projectID,
-		rootKey,
-	)
-	return err
+	return d.transact(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			fmt.Sprintf(
+				"DELETE FROM %s where project_id=$1 and root_key=$2",
+				tableProjectJSON,
+			),
+			projectID,
+			rootKey,
+		)
+		if err != nil {
+			return err
+		}
+
+		return dropJSONVersions(tx, projectID, rootKey)
+	})
 }
 
-// GetJSONKey retrieves the object at the key path
+// GetJSONKey retrieves the object at the key path. `models.ErrKeyNotFound` is returned if the key path does not exist.
 func (d *Database) GetJSONKey(projectID, rootKey string, keys ...string) ([]byte, error) {
-	byt := []byte{}
-
-	// escape?
-	keysFormat := strings.Join(keys, ",")
+	var byt []byte
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT data#>'{%s}' as data FROM %s WHERE project_id=$1 and root_key=$2",
-			keysFormat,
+			"SELECT data#>$1 as data FROM %s WHERE project_id=$2 and root_key=$3",
 			tableProjectJSON,
 		),
+		pq.Array(jsonKeyPath(keys)),
 		projectID,
 		rootKey,
 	).Scan(&byt)
+	if err == sql.ErrNoRows || (err == nil && byt == nil) {
+		return nil, models.ErrKeyNotFound
+	}
 
 	return byt, err
 }
 
-// CreateJSONKey saves the data at the provided key path. Fails if the key already exists.
-func (d *Database) CreateJSONKey(projectID, rootKey string, data []byte, keys ...string) error {
-	// escape?
-	keysFormat := strings.Join(keys, ",")
-	_, err := d.db.Exec(
-		fmt.Sprintf(
-			"UPDATE %s set data=jsonb_insert(data, '{%s}', $1) WHERE project_id=$2 and root_key=$3",
-			tableProjectJSON,
-			keysFormat,
-		),
-		data,
-		projectID,
-		rootKey,
-	)
-	return err
-}
-
-// UpdateJSONKey updates the data at the key path. Creates a new key if it does not already exist.
-func (d *Database) UpdateJSONKey(projectID, rootKey string, data []byte, keys ...string) error {
-	// escape?
-	keysFormat := strings.Join(keys, ",")
-	qstr := "UPDATE %s set data=jsonb_set(data, '{%s}', $1) WHERE project_id=$2 and root_key=$3"
+// CreateJSONKey saves the data at the provided key path. Fails with `models.ErrKeyExists` if the key already exists.
+func (d *Database) CreateJSONKey(projectID, rootKey string, data []byte, metadata *models.MetaData, event *models.OutboxEvent, keys ...string) error {
+	keys = jsonKeyPath(keys)
 	query := fmt.Sprintf(
-		qstr,
+		"UPDATE %s set data=jsonb_insert(data, $1, $2) WHERE project_id=$3 and root_key=$4 RETURNING data, schema",
 		tableProjectJSON,
-		keysFormat,
 	)
 
-	if keysFormat == "" {
-		qstr = "UPDATE %s set data=$1 WHERE project_id=$2 and root_key=$3"
-		query = fmt.Sprintf(
-			qstr,
+	return d.execJSONWrite(
+		query,
+		[]interface{}{pq.Array(keys), data, projectID, rootKey},
+		&jsonWrite{
+			projectID: projectID,
+			rootKey:   rootKey,
+			action:    models.ActionCreate,
+			keys:      keys,
+			data:      data,
+			metadata:  metadata,
+			event:     event,
+			check: func(path *jsonPathState) error {
+				if err := path.requireParent(keys); err != nil {
+					return err
+				}
+				if path.exists {
+					return models.ErrKeyExists
+				}
+				return nil
+			},
+		},
+	)
+}
+
+// UpdateJSONKey updates the data at the key path. Creates a new key if it does not already exist, the parent of the
+// key must exist.
+func (d *Database) UpdateJSONKey(projectID, rootKey string, data []byte, metadata *models.MetaData, event *models.OutboxEvent, keys ...string) error {
+	keys = jsonKeyPath(keys)
+	write := &jsonWrite{
+		projectID: projectID,
+		rootKey:   rootKey,
+		action:    models.ActionEdit,
+		keys:      keys,
+		data:      data,
+		metadata:  metadata,
+		event:     event,
+		check: func(path *jsonPathState) error {
+			return path.requireParent(keys)
+		},
+	}
+
+	if len(keys) == 0 {
+		query := fmt.Sprintf(
+			"UPDATE %s set data=$1 WHERE project_id=$2 and root_key=$3 RETURNING data, schema",
 			tableProjectJSON,
 		)
+		return d.execJSONWrite(query, []interface{}{data, projectID, rootKey}, write)
 	}
 
-	_, err := d.db.Exec(
+	query := fmt.Sprintf(
+		"UPDATE %s set data=jsonb_set(data, $1, $2) WHERE project_id=$3 and root_key=$4 RETURNING data, schema",
+		tableProjectJSON,
+	)
+
+	return d.execJSONWrite(query, []interface{}{pq.Array(keys), data, projectID, rootKey}, write)
+}
+
+// DeleteJSONKey permanently removes the data at the key path. Fails with `models.ErrKeyNotFound` if the key does not exist.
+func (d *Database) DeleteJSONKey(projectID, rootKey string, metadata *models.MetaData, event *models.OutboxEvent, keys ...string) error {
+	keys = jsonKeyPath(keys)
+	query := fmt.Sprintf(
+		"UPDATE %s SET data=data #- $1 WHERE project_id=$2 and root_key=$3 RETURNING data, schema",
+		tableProjectJSON,
+	)
+
+	return d.execJSONWrite(
 		query,
-		data,
-		projectID,
-		rootKey,
+		[]interface{}{pq.Array(keys), projectID, rootKey},
+		&jsonWrite{
+			projectID: projectID,
+			rootKey:   rootKey,
+			action:    models.ActionDelete,
+			keys:      keys,
+			metadata:  metadata,
+			event:     event,
+			check: func(path *jsonPathState) error {
+				return path.requireKey()
+			},
+		},
 	)
-	return err
 }
 
-// DeleteJSONKey permanently removes the data at the key path.
-func (d *Database) DeleteJSONKey(projectID, rootKey string, keys ...string) error {
-	// escape?
-	keysFormat := strings.Join(keys, ",")
-	_, err := d.db.Exec(
+// jsonKeyPath returns the key path as a non-nil slice, a single empty key is the root of the tree
+func jsonKeyPath(keys []string) []string {
+	if len(keys) == 0 || (len(keys) == 1 && keys[0] == "") {
+		return []string{}
+	}
+	return keys
+}
+
+// jsonWrite is a single write to the key path of a JSON tree. `check` verifies the key path before the write.
+type jsonWrite struct {
+	projectID string
+	rootKey   string
+	action    string
+	keys      []string
+	data      []byte
+	metadata  *models.MetaData
+	event     *models.OutboxEvent
+	check     func(*jsonPathState) error
+}
+
+// jsonPathState describes the key path of a locked JSON tree before it is written
+type jsonPathState struct {
+	parentType   string // jsonb type of the parent node, empty if the parent does not exist
+	exists       bool
+	invalidIndex bool // a key of an array node of the key path is not an index, i.e. a negative offset from the end
+	history      *models.JSONHistory
+}
+
+// requireParent verifies the parent of the key path is an object, or an array and the last key is an index
+func (p *jsonPathState) requireParent(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if p.invalidIndex {
+		return models.ErrInvalidArrayIndex
+	}
+
+	switch p.parentType {
+	case "object":
+		return nil
+	case "array":
+		if !jsonArrayIndex(keys[len(keys)-1]) {
+			return models.ErrInvalidArrayIndex
+		}
+		return nil
+	case "":
+		return models.ErrKeyNotFound
+	default:
+		return models.ErrKeyTypeConflict
+	}
+}
+
+// requireKey verifies the key path exists and its array indexes are valid
+func (p *jsonPathState) requireKey() error {
+	if p.invalidIndex {
+		return models.ErrInvalidArrayIndex
+	}
+	if !p.exists {
+		return models.ErrKeyNotFound
+	}
+	return nil
+}
+
+// jsonArrayIndex returns true if the key is a non-negative integer. Postgres treats negative indexes as offsets from the
+// end of the array.
+func jsonArrayIndex(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// versionPath returns the key path recorded in the history. Changes to array elements record the entire array since
+// inserts and deletes shift the following elements.
+func (p *jsonPathState) versionPath(keys []string) []string {
+	if len(keys) > 0 && p.parentType == "array" {
+		return keys[:len(keys)-1]
+	}
+	return keys
+}
+
+// lockJSONPath locks the root key row for the rest of the transaction and returns the state of the key path.
+// `models.ErrKeyNotFound` is returned if the root key does not exist.
+func lockJSONPath(tx *sql.Tx, projectID, rootKey string, keys []string) (*jsonPathState, error) {
+	parent := []string{}
+	if len(keys) > 0 {
+		parent = keys[:len(keys)-1]
+	}
+
+	path := &jsonPathState{}
+	var history []byte
+	err := tx.QueryRow(
 		fmt.Sprintf(
-			"UPDATE %s SET data=data #- '{%s}' WHERE project_id=$1 and root_key=$2",
+			`SELECT COALESCE(jsonb_typeof(data#>$1), ''), data#>$2 IS NOT NULL,
+				EXISTS (SELECT 1 FROM generate_subscripts($2::text[], 1) i WHERE jsonb_typeof(data#>($2::text[])[1:i-1])='array' AND ($2::text[])[i] !~ '^[0-9]+$'),
+				history FROM %s WHERE project_id=$3 and root_key=$4 FOR UPDATE`,
 			tableProjectJSON,
-			keysFormat,
 		),
+		pq.Array(parent),
+		pq.Array(keys),
 		projectID,
 		rootKey,
+	).Scan(&path.parentType, &path.exists, &path.invalidIndex, &history)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	path.history, err = scanHistory(history)
+	return path, err
+}
+
+// execJSONWrite executes the JSON tree write and records the version and outbox event within the same transaction. The
+// root key is locked and the key path verified before the write. The resulting tree is validated against the root key
+// schema, the write is rolled back if it is invalid.
+func (d *Database) execJSONWrite(query string, args []interface{}, write *jsonWrite) error {
+	return d.transact(func(tx *sql.Tx) error {
+		path, err := lockJSONPath(tx, write.projectID, write.rootKey, write.keys)
+		if err != nil {
+			return err
+		}
+		if err := write.check(path); err != nil {
+			return err
+		}
+
+		version, err := beginJSONVersion(tx, path, write)
+		if err != nil {
+			return err
+		}
+
+		rootKeyModel := &models.RootKey{}
+		var tree []byte
+		err = tx.QueryRow(query, args...).Scan(&tree, (*[]byte)(&rootKeyModel.Schema))
+		if err == sql.ErrNoRows {
+			return models.ErrKeyNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := rootKeyModel.ValidateData(tree); err != nil {
+			return err
+		}
+
+		if err := saveJSONVersion(tx, write.projectID, write.rootKey, path.history, version); err != nil {
+			return err
+		}
+
+		if write.event == nil {
+			return nil
+		}
+
+		write.event.Keys = write.keys
+		write.event.Payload = write.data
+
+		return insertOutboxEvent(tx, write.projectID, write.event)
+	})
+}
+
+// jsonOperationUpdates are the expression of the resulting tree and the condition of each atomic operation. `$1` is the
+// key path, `$2` the operation value and `$5` the expected value.
+var jsonOperationUpdates = map[string][2]string{
+	models.JSONOpIncrement: {
+		"jsonb_set(data, $1, to_jsonb(COALESCE((data#>>$1)::numeric, 0) + $2::numeric))",
+		"(data#>$1 IS NULL OR jsonb_typeof(data#>$1)='number')",
+	},
+	models.JSONOpDecrement: {
+		"jsonb_set(data, $1, to_jsonb(COALESCE((data#>>$1)::numeric, 0) - $2::numeric))",
+		"(data#>$1 IS NULL OR jsonb_typeof(data#>$1)='number')",
+	},
+	models.JSONOpAppend: {
+		"jsonb_set(data, $1, COALESCE(data#>$1, '[]'::jsonb) || jsonb_build_array($2::jsonb))",
+		"(data#>$1 IS NULL OR jsonb_typeof(data#>$1)='array')",
+	},
+	models.JSONOpRemove: {
+		"jsonb_set(data, $1, COALESCE((SELECT jsonb_agg(e) FROM jsonb_array_elements(data#>$1) e WHERE e <> $2::jsonb), '[]'::jsonb))",
+		"jsonb_typeof(data#>$1)='array'",
+	},
+	models.JSONOpCompareAndSet: {
+		"jsonb_set(data, $1, $2::jsonb)",
+		"data#>$1 = $5::jsonb",
+	},
+	models.JSONOpSetIfAbsent: {
+		"jsonb_set(data, $1, $2::jsonb)",
+		"data#>$1 IS NULL",
+	},
+}
+
+// ApplyJSONOperation applies the operation to the value at the key path, returning the resulting value. The result is
+// computed from the current value and passed to `validate` outside of a transaction, so slow "before" hooks do not
+// keep the root key locked, the value it returns is written if the current value has not changed in the meantime.
+// `models.ErrOperationConflict` is returned if the current value does not allow the operation or was changed
+// concurrently, and `models.ErrKeyNotFound` if the parent of the key path does not exist.
+func (d *Database) ApplyJSONOperation(projectID, rootKey string, op *models.JSONOperation, validate models.JSONValueValidator, metadata *models.MetaData, event *models.OutboxEvent, keys ...string) ([]byte, error) {
+	update, ok := jsonOperationUpdates[op.Op]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation '%s'", op.Op)
+	}
+
+	args := []interface{}{pq.Array(keys), string(op.Value), projectID, rootKey}
+	if op.Op == models.JSONOpCompareAndSet {
+		args = append(args, string(op.Expected))
+	}
+
+	// the current value and the result of the operation
+	var current, value []byte
+	err := d.transact(func(tx *sql.Tx) error {
+		path, err := lockJSONPath(tx, projectID, rootKey, keys)
+		if err != nil {
+			return err
+		}
+		if err := path.requireParent(keys); err != nil {
+			return err
+		}
+
+		var applies bool
+		err = tx.QueryRow(
+			fmt.Sprintf(
+				"SELECT data#>$1, (%s)#>$1, COALESCE(%s, false) FROM %s WHERE project_id=$3 and root_key=$4",
+				update[0],
+				update[1],
+				tableProjectJSON,
+			),
+			args...,
+		).Scan(&current, &value, &applies)
+		if err != nil {
+			return err
+		}
+		if !applies {
+			return models.ErrOperationConflict
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if validate != nil {
+		value, err = validate(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = d.transact(func(tx *sql.Tx) error {
+		path, err := lockJSONPath(tx, projectID, rootKey, keys)
+		if err != nil {
+			return err
+		}
+		if err := path.requireParent(keys); err != nil {
+			return err
+		}
+
+		version, err := beginJSONVersion(tx, path, &jsonWrite{
+			projectID: projectID,
+			rootKey:   rootKey,
+			action:    models.ActionEdit,
+			keys:      keys,
+			metadata:  metadata,
+		})
+		if err != nil {
+			return err
+		}
+
+		// the value was changed while the hooks were called
+		rootKeyModel := &models.RootKey{}
+		var tree []byte
+		err = tx.QueryRow(
+			fmt.Sprintf(
+				"UPDATE %s SET data=jsonb_set(data, $1, $2::jsonb) WHERE project_id=$3 and root_key=$4 AND data#>$1 IS NOT DISTINCT FROM $5::jsonb RETURNING data#>$1, data, schema",
+				tableProjectJSON,
+			),
+			pq.Array(keys),
+			string(value),
+			projectID,
+			rootKey,
+			sql.NullString{String: string(current), Valid: current != nil},
+		).Scan(&value, &tree, (*[]byte)(&rootKeyModel.Schema))
+		if err == sql.ErrNoRows {
+			return models.ErrOperationConflict
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := rootKeyModel.ValidateData(tree); err != nil {
+			return err
+		}
+
+		if err := saveJSONVersion(tx, projectID, rootKey, path.history, version); err != nil {
+			return err
+		}
+
+		if event == nil {
+			return nil
+		}
+
+		event.Keys = keys
+		event.Payload = value
+
+		return insertOutboxEvent(tx, projectID, event)
+	})
+
+	return value, err
+}
+
+// jsonChildrenOrder returns the `ORDER BY` expressions and `LIMIT` of the child query. Children without a value to
+// sort by are ordered last. `limitToLast` reads the children in reverse, so they are ordered first, and `reversed` is
+// set so the order is restored afterwards.
+func jsonChildrenOrder(sortExpr string, query *models.JSONChildQuery) (string, string, bool) {
+	if query.LimitToLast > 0 && query.LimitToFirst <= 0 {
+		return fmt.Sprintf("%s DESC NULLS FIRST, c.idx DESC, c.key DESC", sortExpr), fmt.Sprintf("LIMIT %d", query.LimitToLast), true
+	}
+
+	limit := ""
+	if query.LimitToFirst > 0 {
+		limit = fmt.Sprintf("LIMIT %d", query.LimitToFirst)
+	}
+	return fmt.Sprintf("%s ASC NULLS LAST, c.idx ASC, c.key ASC", sortExpr), limit, false
+}
+
+// scanRules parses the JSONB rules column
+func scanRules(value []byte) ([]*models.JSONRule, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	rules := make([]*models.JSONRule, 0)
+	err := json.Unmarshal(value, &rules)
+
+	return rules, err
+}
+
+// ListJSONChildren lists the children of the object or array at the key path, ordered and limited by the query. Array
+// children are keyed by their index. A key path which is not an object or array has no children,
+// `models.ErrKeyNotFound` is returned if the key path does not exist.
+func (d *Database) ListJSONChildren(projectID, rootKey string, query *models.JSONChildQuery, keys ...string) ([]*models.JSONChild, error) {
+	keys = jsonKeyPath(keys)
+	args := []interface{}{pq.Array(keys), projectID, rootKey}
+
+	// array children order by their numeric index, object children by their key
+	sortExpr := "COALESCE(to_jsonb(c.idx), to_jsonb(c.key))"
+	switch query.OrderBy {
+	case models.JSONOrderByKey:
+	case models.JSONOrderByValue:
+		sortExpr = "c.value"
+	default:
+		args = append(args, pq.Array(query.OrderPath))
+		sortExpr = fmt.Sprintf("c.value#>$%d", len(args))
+	}
+
+	conditions := []string{}
+	if query.StartAt != nil {
+		args = append(args, string(query.StartAt))
+		conditions = append(conditions, fmt.Sprintf("%s >= $%d::jsonb", sortExpr, len(args)))
+	}
+	if query.EndAt != nil {
+		args = append(args, string(query.EndAt))
+		conditions = append(conditions, fmt.Sprintf("%s <= $%d::jsonb", sortExpr, len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	orderBy, limit, reversed := jsonChildrenOrder(sortExpr, query)
+
+	value := "c.value"
+	if query.Shallow {
+		value = "NULL::jsonb"
+	}
+
+	rows, err := d.db.Query(
+		fmt.Sprintf(
+			`SELECT c.key, %s FROM (
+				SELECT o.key, o.value, NULL::bigint AS idx FROM %s j,
+					jsonb_each(CASE WHEN jsonb_typeof(j.data#>$1)='object' THEN j.data#>$1 ELSE '{}'::jsonb END) o
+				WHERE j.project_id=$2 and j.root_key=$3
+				UNION ALL
+				SELECT (a.idx-1)::text, a.value, a.idx-1 FROM %s j,
+					jsonb_array_elements(CASE WHEN jsonb_typeof(j.data#>$1)='array' THEN j.data#>$1 ELSE '[]'::jsonb END) WITH ORDINALITY a(value, idx)
+				WHERE j.project_id=$2 and j.root_key=$3
+			) c %s ORDER BY %s %s`,
+			value,
+			tableProjectJSON,
+			tableProjectJSON,
+			where,
+			orderBy,
+			limit,
+		),
+		args...,
 	)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	children := make([]*models.JSONChild, 0)
+	for rows.Next() {
+		child := &models.JSONChild{}
+		if err := rows.Scan(&child.Key, (*[]byte)(&child.Value)); err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// no children, distinguish an empty node from a missing key path
+	if len(children) == 0 {
+		if _, err := d.GetJSONKey(projectID, rootKey, keys...); err != nil {
+			return nil, err
+		}
+	}
+
+	if reversed {
+		for i, j := 0, len(children)-1; i < j; i, j = i+1, j-1 {
+			children[i], children[j] = children[j], children[i]
+		}
+	}
+
+	return children, nil
 }
@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONChildrenOrder(t *testing.T) {
+	tables := []struct {
+		name     string
+		query    *models.JSONChildQuery
+		orderBy  string
+		limit    string
+		reversed bool
+	}{
+		{"unlimited", &models.JSONChildQuery{}, "c.value ASC NULLS LAST, c.idx ASC, c.key ASC", "", false},
+		{"limit to first", &models.JSONChildQuery{LimitToFirst: 3}, "c.value ASC NULLS LAST, c.idx ASC, c.key ASC", "LIMIT 3", false},
+		// children without a value are last in ascending order, so they are first when read in reverse
+		{"limit to last", &models.JSONChildQuery{LimitToLast: 2}, "c.value DESC NULLS FIRST, c.idx DESC, c.key DESC", "LIMIT 2", true},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			orderBy, limit, reversed := jsonChildrenOrder("c.value", tt.query)
+			assert.Equal(t, tt.orderBy, orderBy)
+			assert.Equal(t, tt.limit, limit)
+			assert.Equal(t, tt.reversed, reversed)
+		})
+	}
+}
+
+func TestJSONPathState(t *testing.T) {
+	d := &Database{}
+	tables := []struct {
+		name   string
+		path   *jsonPathState
+		keys   []string
+		err    error
+		status int
+	}{
+		{"root", &jsonPathState{}, []string{}, nil, 0},
+		{"object parent", &jsonPathState{parentType: "object"}, []string{"a", "b"}, nil, 0},
+		{"array index", &jsonPathState{parentType: "array"}, []string{"a", "0"}, nil, 0},
+		{"negative array index", &jsonPathState{parentType: "array"}, []string{"a", "-1"}, models.ErrInvalidArrayIndex, 400},
+		{"signed array index", &jsonPathState{parentType: "array"}, []string{"a", "+1"}, models.ErrInvalidArrayIndex, 400},
+		{"array key", &jsonPathState{parentType: "array"}, []string{"a", "b"}, models.ErrInvalidArrayIndex, 400},
+		{"negative index of an intermediate array", &jsonPathState{parentType: "object", invalidIndex: true}, []string{"a", "-1", "b"}, models.ErrInvalidArrayIndex, 400},
+		{"missing parent", &jsonPathState{}, []string{"a", "b"}, models.ErrKeyNotFound, 404},
+		{"value parent", &jsonPathState{parentType: "string"}, []string{"a", "b"}, models.ErrKeyTypeConflict, 409},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.path.requireParent(tt.keys)
+			assert.Equal(t, tt.err, err)
+			if err != nil {
+				assert.Equal(t, tt.status, d.TranslateError(err).Code)
+			}
+		})
+	}
+
+	// deletes require the key and valid indexes
+	assert.Nil(t, (&jsonPathState{exists: true}).requireKey())
+	assert.Equal(t, models.ErrKeyNotFound, (&jsonPathState{}).requireKey())
+	assert.Equal(t, models.ErrInvalidArrayIndex, (&jsonPathState{exists: true, invalidIndex: true}).requireKey())
+}
@@ -0,0 +1,296 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const tableProjectJSONVersions = "project_json_versions"
+
+// ListJSONVersions lists the recorded versions of the root key, most recent first
+func (d *Database) ListJSONVersions(projectID, rootKey string, limit, offset int64) ([]*models.JSONVersion, error) {
+	rows, err := d.db.Query(
+		fmt.Sprintf(
+			"SELECT version, action, keys, previous, value, creator, creator_type, created FROM %s WHERE project_id=$1 and root_key=$2 ORDER BY version DESC LIMIT $3 OFFSET $4",
+			tableProjectJSONVersions,
+		),
+		projectID,
+		rootKey,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]*models.JSONVersion, 0)
+	for rows.Next() {
+		version := &models.JSONVersion{}
+		var creator, creatorType sql.NullString
+		err = rows.Scan(
+			&version.Version,
+			&version.Action,
+			pq.Array(&version.Keys),
+			(*[]byte)(&version.Previous),
+			(*[]byte)(&version.Value),
+			&creator,
+			&creatorType,
+			&version.Created,
+		)
+		if err != nil {
+			return nil, err
+		}
+		version.Creator = creator.String
+		version.CreatorType = creatorType.String
+
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+// RestoreJSONVersion restores the tree of the root key to the state after `version` was recorded by undoing every
+// newer version, most recent first. The restore is recorded as a new version and the restored tree is returned.
+func (d *Database) RestoreJSONVersion(projectID, rootKey string, version int64, metadata *models.MetaData, event *models.OutboxEvent) ([]byte, error) {
+	var tree []byte
+	err := d.transact(func(tx *sql.Tx) error {
+		path, err := lockJSONPath(tx, projectID, rootKey, []string{})
+		if err != nil {
+			return err
+		}
+		if path.history == nil || !path.history.Enabled {
+			return models.ErrHistoryDisabled
+		}
+
+		var exists bool
+		err = tx.QueryRow(
+			fmt.Sprintf(
+				"SELECT true FROM %s WHERE project_id=$1 and root_key=$2 and version=$3",
+				tableProjectJSONVersions,
+			),
+			projectID,
+			rootKey,
+			version,
+		).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return models.ErrVersionNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		undo, err := newerJSONVersions(tx, projectID, rootKey, version)
+		if err != nil {
+			return err
+		}
+
+		restore, err := beginJSONVersion(tx, path, &jsonWrite{
+			projectID: projectID,
+			rootKey:   rootKey,
+			action:    models.JSONActionRestore,
+			keys:      []string{},
+			metadata:  metadata,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, v := range undo {
+			if err := undoJSONVersion(tx, projectID, rootKey, v); err != nil {
+				return err
+			}
+		}
+
+		rootKeyModel := &models.RootKey{}
+		err = tx.QueryRow(
+			fmt.Sprintf(
+				"SELECT data, schema FROM %s WHERE project_id=$1 and root_key=$2",
+				tableProjectJSON,
+			),
+			projectID,
+			rootKey,
+		).Scan(&tree, (*[]byte)(&rootKeyModel.Schema))
+		if err != nil {
+			return err
+		}
+
+		// the schema may have changed since the version was recorded
+		if err := rootKeyModel.ValidateData(tree); err != nil {
+			return err
+		}
+
+		if err := saveJSONVersion(tx, projectID, rootKey, path.history, restore); err != nil {
+			return err
+		}
+
+		if event == nil {
+			return nil
+		}
+
+		event.Keys = []string{}
+		event.Payload = tree
+
+		return insertOutboxEvent(tx, projectID, event)
+	})
+
+	return tree, err
+}
+
+// newerJSONVersions returns the key path and previous value of the versions after `version`, most recent first
+func newerJSONVersions(tx *sql.Tx, projectID, rootKey string, version int64) ([]*models.JSONVersion, error) {
+	rows, err := tx.Query(
+		fmt.Sprintf(
+			"SELECT version, keys, previous FROM %s WHERE project_id=$1 and root_key=$2 and version>$3 ORDER BY version DESC",
+			tableProjectJSONVersions,
+		),
+		projectID,
+		rootKey,
+		version,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]*models.JSONVersion, 0)
+	for rows.Next() {
+		v := &models.JSONVersion{}
+		if err := rows.Scan(&v.Version, pq.Array(&v.Keys), (*[]byte)(&v.Previous)); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	return versions, rows.Err()
+}
+
+// undoJSONVersion sets the key path of the version back to its previous value, the key is removed if it did not exist
+func undoJSONVersion(tx *sql.Tx, projectID, rootKey string, version *models.JSONVersion) error {
+	var err error
+	switch {
+	case len(version.Keys) == 0:
+		_, err = tx.Exec(
+			fmt.Sprintf("UPDATE %s SET data=$1 WHERE project_id=$2 and root_key=$3", tableProjectJSON),
+			jsonbValue(version.Previous),
+			projectID,
+			rootKey,
+		)
+	case version.Previous == nil:
+		_, err = tx.Exec(
+			fmt.Sprintf("UPDATE %s SET data=data #- $1 WHERE project_id=$2 and root_key=$3", tableProjectJSON),
+			pq.Array(version.Keys),
+			projectID,
+			rootKey,
+		)
+	default:
+		_, err = tx.Exec(
+			fmt.Sprintf("UPDATE %s SET data=jsonb_set(data, $1, $2) WHERE project_id=$3 and root_key=$4", tableProjectJSON),
+			pq.Array(version.Keys),
+			[]byte(version.Previous),
+			projectID,
+			rootKey,
+		)
+	}
+
+	return err
+}
+
+// beginJSONVersion captures the value at the version path before the write, returns `nil` if the root key does not
+// record history
+func beginJSONVersion(tx *sql.Tx, path *jsonPathState, write *jsonWrite) (*models.JSONVersion, error) {
+	if path.history == nil || !path.history.Enabled {
+		return nil, nil
+	}
+
+	version := &models.JSONVersion{
+		Action: write.action,
+		Keys:   path.versionPath(write.keys),
+	}
+	if write.metadata != nil {
+		version.Creator = write.metadata.Creator
+		version.CreatorType = write.metadata.CreatorType
+	}
+
+	err := tx.QueryRow(
+		fmt.Sprintf(
+			"SELECT data#>$1 FROM %s WHERE project_id=$2 and root_key=$3",
+			tableProjectJSON,
+		),
+		pq.Array(version.Keys),
+		write.projectID,
+		write.rootKey,
+	).Scan((*[]byte)(&version.Previous))
+
+	return version, err
+}
+
+// saveJSONVersion records the version with the value at the version path after the write and removes the versions
+// beyond the retention of the root key. A `nil` version is ignored.
+func saveJSONVersion(tx *sql.Tx, projectID, rootKey string, history *models.JSONHistory, version *models.JSONVersion) error {
+	if version == nil {
+		return nil
+	}
+
+	err := tx.QueryRow(
+		fmt.Sprintf(
+			`INSERT INTO %s (project_id, root_key, version, action, keys, previous, value, creator, creator_type)
+			SELECT $1, $2, COALESCE((SELECT MAX(version) FROM %s WHERE project_id=$1 and root_key=$2), 0) + 1, $3, $4, $5, data#>$4, $6, $7
+			FROM %s WHERE project_id=$1 and root_key=$2 RETURNING version, value, created`,
+			tableProjectJSONVersions,
+			tableProjectJSONVersions,
+			tableProjectJSON,
+		),
+		projectID,
+		rootKey,
+		version.Action,
+		pq.Array(version.Keys),
+		jsonbValue(version.Previous),
+		version.Creator,
+		version.CreatorType,
+	).Scan(&version.Version, (*[]byte)(&version.Value), &version.Created)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE project_id=$1 and root_key=$2 and version<=$3",
+			tableProjectJSONVersions,
+		),
+		projectID,
+		rootKey,
+		version.Version-int64(history.Limit()),
+	)
+
+	return err
+}
+
+// dropJSONVersions removes all versions of the root key
+func dropJSONVersions(tx *sql.Tx, projectID, rootKey string) error {
+	_, err := tx.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE project_id=$1 and root_key=$2",
+			tableProjectJSONVersions,
+		),
+		projectID,
+		rootKey,
+	)
+	return err
+}
+
+// scanHistory parses the JSONB history column
+func scanHistory(value []byte) (*models.JSONHistory, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	history := &models.JSONHistory{}
+	err := json.Unmarshal(value, history)
+
+	return history, err
+}
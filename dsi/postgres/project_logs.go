@@ -189,3 +189,18 @@ func (d *Database) DropProjectLogs(projectID string) error {
 	)
 	return err
 }
+
+// PurgeProjectLogs deletes the logs of all projects created before `before`
+func (d *Database) PurgeProjectLogs(before time.Time) (int64, error) {
+	res, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE created < $1",
+			tableProjectLogs,
+		),
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	dsiErrors "github.com/machinable/machinable/dsi/errors"
 	"github.com/machinable/machinable/dsi/models"
 )
@@ -15,6 +16,7 @@ import (
 const (
 	tableProjectResourceDefinitions = "project_resource_definitions"
 	tableProjectResourceObjects     = "project_resource_objects"
+	tableProjectDocumentACLs        = "project_document_acls"
 )
 
 // objectFilterTranslation translates metadata keys to their respective field name in the database
@@ -25,13 +27,95 @@ var objectFilterTranslation = map[string]string{
 	"_metadata.created":      "created",
 }
 
+// translateObjectFilters translates metadata keys to their field name and data keys to JSONB filters. Data keys are
+// assumed to have been validated by the caller, i.e. against the schema or by the conditions of a role. The document
+// access filter is not a field, it is added to the query by `documentAccessQuery`.
+func translateObjectFilters(filter map[string]interface{}) map[string]interface{} {
+	translatedFilters := make(map[string]interface{})
+	for key, value := range filter {
+		if key == models.FilterDocumentAccess {
+			continue
+		}
+		if translated, ok := objectFilterTranslation[key]; ok {
+			if _, ok := filter[translated]; !ok {
+				translatedFilters[translated] = value
+			}
+		} else {
+			translatedFilters[fmt.Sprintf("data->>'%s'", key)] = value
+		}
+	}
+	return translatedFilters
+}
+
+// documentAccessQuery adds the condition of the document access filter, if any, to the query of the table. Documents
+// created by the user or with an ACL entry of the user or one of its groups match the filter, the entries are looked up
+// by their primary key.
+func documentAccessQuery(filter map[string]interface{}, table string, filterString *[]string, args *[]interface{}, index *int) {
+	access, ok := filter[models.FilterDocumentAccess].(*models.DocumentAccess)
+	if !ok {
+		return
+	}
+
+	permission := "acl.read"
+	if access.Write {
+		permission = "acl.write"
+	}
+	groups := access.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+
+	*args = append(*args, access.UserID, access.UserID, pq.Array(groups))
+	*filterString = append(*filterString, fmt.Sprintf(
+		"(%s.creator=$%d OR EXISTS (SELECT 1 FROM %s acl WHERE acl.project_id=%s.project_id AND acl.document_id=%s.id AND %s AND ((acl.principal_type='%s' AND acl.principal_id=$%d) OR (acl.principal_type='%s' AND acl.principal_id=ANY($%d)))))",
+		table, *index, tableProjectDocumentACLs, table, table, permission, models.PrincipalUser, *index+1, models.PrincipalGroup, *index+2,
+	))
+	*index += 3
+}
+
 // AddDefinition creates a new definition
 func (d *Database) AddDefinition(projectID string, definition *models.ResourceDefinition) (string, *dsiErrors.DatastoreError) {
-	err := d.db.QueryRow(
-		fmt.Sprintf(
-			"INSERT INTO %s (project_id, name, path_name, parallel_read, parallel_write, \"create\", \"read\", \"update\", \"delete\", schema, created) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id",
-			tableProjectResourceDefinitions,
-		),
+	args, err := definitionArgs(projectID, definition)
+	if err != nil {
+		return "", dsiErrors.New(dsiErrors.BadParameter, err)
+	}
+
+	err = d.db.QueryRow(fmt.Sprintf(insertDefinitionQuery, tableProjectResourceDefinitions), args...).Scan(&definition.ID)
+
+	return definition.ID, dsiErrors.New(dsiErrors.UnknownError, err)
+}
+
+// AddDefinitions creates all of the definitions in a single transaction, none are created if one fails
+func (d *Database) AddDefinitions(projectID string, definitions []*models.ResourceDefinition) *dsiErrors.DatastoreError {
+	for _, definition := range definitions {
+		if _, err := ttlValue(definition.TTL); err != nil {
+			return dsiErrors.New(dsiErrors.BadParameter, err)
+		}
+	}
+
+	err := d.transact(func(tx *sql.Tx) error {
+		for _, definition := range definitions {
+			args, _ := definitionArgs(projectID, definition)
+			if err := tx.QueryRow(fmt.Sprintf(insertDefinitionQuery, tableProjectResourceDefinitions), args...).Scan(&definition.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return dsiErrors.New(dsiErrors.UnknownError, err)
+}
+
+const insertDefinitionQuery = "INSERT INTO %s (project_id, name, path_name, parallel_read, parallel_write, \"create\", \"read\", \"update\", \"delete\", schema, created, ttl) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id"
+
+// definitionArgs returns the parameters of `insertDefinitionQuery`
+func definitionArgs(projectID string, definition *models.ResourceDefinition) ([]interface{}, error) {
+	ttl, err := ttlValue(definition.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{
 		projectID,
 		definition.Title,
 		definition.PathName,
@@ -43,16 +127,20 @@ func (d *Database) AddDefinition(projectID string, definition *models.ResourceDe
 		definition.Delete,
 		definition.Schema,
 		time.Now(),
-	).Scan(&definition.ID)
-
-	return definition.ID, dsiErrors.New(dsiErrors.UnknownError, err)
+		ttl,
+	}, nil
 }
 
-// UpdateDefinition updates the access fields of a definition
+// UpdateDefinition updates the access fields and TTL of a definition
 func (d *Database) UpdateDefinition(projectID, definitionID string, definition *models.ResourceDefinition) *dsiErrors.DatastoreError {
-	_, err := d.db.Exec(
+	ttl, err := ttlValue(definition.TTL)
+	if err != nil {
+		return dsiErrors.New(dsiErrors.BadParameter, err)
+	}
+
+	_, err = d.db.Exec(
 		fmt.Sprintf(
-			"UPDATE %s SET parallel_read=$1, parallel_write=$2, \"create\"=$3, \"read\"=$4, \"update\"=$5, \"delete\"=$6 WHERE id=$7",
+			"UPDATE %s SET parallel_read=$1, parallel_write=$2, \"create\"=$3, \"read\"=$4, \"update\"=$5, \"delete\"=$6, ttl=$7 WHERE id=$8",
 			tableProjectResourceDefinitions,
 		),
 		definition.ParallelRead,
@@ -61,6 +149,7 @@ func (d *Database) UpdateDefinition(projectID, definitionID string, definition *
 		definition.Read,
 		definition.Update,
 		definition.Delete,
+		ttl,
 		definitionID,
 	)
 
@@ -71,7 +160,7 @@ func (d *Database) UpdateDefinition(projectID, definitionID string, definition *
 func (d *Database) ListDefinitions(projectID string) ([]*models.ResourceDefinition, *dsiErrors.DatastoreError) {
 	rows, err := d.db.Query(
 		fmt.Sprintf(
-			"SELECT id, project_id, name, path_name, parallel_read, parallel_write, \"create\", \"read\", \"update\", \"delete\", schema, created FROM %s WHERE project_id=$1",
+			"SELECT id, project_id, name, path_name, parallel_read, parallel_write, \"create\", \"read\", \"update\", \"delete\", schema, created, ttl FROM %s WHERE project_id=$1",
 			tableProjectResourceDefinitions,
 		),
 		projectID,
@@ -84,6 +173,7 @@ func (d *Database) ListDefinitions(projectID string) ([]*models.ResourceDefiniti
 	definitions := make([]*models.ResourceDefinition, 0)
 	for rows.Next() {
 		def := models.ResourceDefinition{}
+		var ttl []byte
 		err = rows.Scan(
 			&def.ID,
 			&def.ProjectID,
@@ -97,10 +187,14 @@ func (d *Database) ListDefinitions(projectID string) ([]*models.ResourceDefiniti
 			&def.Delete,
 			&def.Schema,
 			&def.Created,
+			&ttl,
 		)
 		if err != nil {
 			return nil, dsiErrors.New(dsiErrors.UnknownError, err)
 		}
+		if def.TTL, err = scanTTL(ttl); err != nil {
+			return nil, dsiErrors.New(dsiErrors.UnknownError, err)
+		}
 
 		definitions = append(definitions, &def)
 	}
@@ -111,9 +205,10 @@ func (d *Database) ListDefinitions(projectID string) ([]*models.ResourceDefiniti
 // GetDefinition returns a single definition by ID.
 func (d *Database) GetDefinition(projectID, definitionID string) (*models.ResourceDefinition, *dsiErrors.DatastoreError) {
 	def := models.ResourceDefinition{}
+	var ttl []byte
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, project_id, name, path_name, parallel_read, parallel_write, \"create\", \"read\", \"update\", \"delete\", schema, created FROM %s WHERE id=$1",
+			"SELECT id, project_id, name, path_name, parallel_read, parallel_write, \"create\", \"read\", \"update\", \"delete\", schema, created, ttl FROM %s WHERE id=$1",
 			tableProjectResourceDefinitions,
 		),
 		definitionID,
@@ -130,10 +225,14 @@ func (d *Database) GetDefinition(projectID, definitionID string) (*models.Resour
 		&def.Delete,
 		&def.Schema,
 		&def.Created,
+		&ttl,
 	)
 	if err != nil {
 		return nil, dsiErrors.New(dsiErrors.UnknownError, err)
 	}
+	if def.TTL, err = scanTTL(ttl); err != nil {
+		return nil, dsiErrors.New(dsiErrors.UnknownError, err)
+	}
 
 	return &def, nil
 }
@@ -163,9 +262,10 @@ func (d *Database) GetResourceStats(projectID, pathName string) (*models.Stats,
 // GetDefinitionByPathName returns a definition based on `PathName` and `ProjectID`
 func (d *Database) GetDefinitionByPathName(projectID, pathName string) (*models.ResourceDefinition, *dsiErrors.DatastoreError) {
 	def := models.ResourceDefinition{}
+	var ttl []byte
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, project_id, name, path_name, parallel_read, parallel_write, \"create\", \"read\", \"update\", \"delete\", schema, created FROM %s WHERE project_id=$1 AND path_name=$2",
+			"SELECT id, project_id, name, path_name, parallel_read, parallel_write, \"create\", \"read\", \"update\", \"delete\", schema, created, ttl FROM %s WHERE project_id=$1 AND path_name=$2",
 			tableProjectResourceDefinitions,
 		),
 		projectID,
@@ -183,10 +283,14 @@ func (d *Database) GetDefinitionByPathName(projectID, pathName string) (*models.
 		&def.Delete,
 		&def.Schema,
 		&def.Created,
+		&ttl,
 	)
 	if err != nil {
 		return nil, dsiErrors.New(dsiErrors.UnknownError, err)
 	}
+	if def.TTL, err = scanTTL(ttl); err != nil {
+		return nil, dsiErrors.New(dsiErrors.UnknownError, err)
+	}
 
 	return &def, nil
 }
@@ -310,7 +414,7 @@ func (d *Database) CheckIfRelationsExistBeforeInsert(projectID string, fields mo
 /******************************/
 
 // AddDefDocument creates a new document for the existing resource, specified by the path.
-func (d *Database) AddDefDocument(projectID, pathName string, fields models.ResourceObject, metadata *models.MetaData) (string, *dsiErrors.DatastoreError) {
+func (d *Database) AddDefDocument(projectID, pathName string, fields models.ResourceObject, metadata *models.MetaData, event *models.OutboxEvent) (string, *dsiErrors.DatastoreError) {
 	var id string
 	var creatorID interface{}
 
@@ -341,24 +445,38 @@ func (d *Database) AddDefDocument(projectID, pathName string, fields models.Reso
 		return "", checkErr
 	}
 
-	err := d.db.QueryRow(
-		fmt.Sprintf(
-			"INSERT INTO %s (project_id, resource_path, creator_type, creator, created, data) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
-			tableProjectResourceObjects,
-		),
-		projectID,
-		pathName,
-		metadata.CreatorType,
-		creatorID,
-		time.Now(),
-		data,
-	).Scan(&id)
+	err := d.transact(func(tx *sql.Tx) error {
+		err := tx.QueryRow(
+			fmt.Sprintf(
+				"INSERT INTO %s (project_id, resource_path, creator_type, creator, created, data) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+				tableProjectResourceObjects,
+			),
+			projectID,
+			pathName,
+			metadata.CreatorType,
+			creatorID,
+			time.Now(),
+			data,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		if event != nil {
+			event.Payload, err = documentEventPayload(fields, id, metadata)
+			if err != nil {
+				return err
+			}
+		}
+
+		return insertOutboxEvent(tx, projectID, event)
+	})
 
 	return id, dsiErrors.New(dsiErrors.UnknownError, err)
 }
 
 // UpdateDefDocument updates an existing document if it exists
-func (d *Database) UpdateDefDocument(projectID, pathName, documentID string, updatedFields models.ResourceObject, filter map[string]interface{}) (*models.ResourceObject, *dsiErrors.DatastoreError) {
+func (d *Database) UpdateDefDocument(projectID, pathName, documentID string, updatedFields models.ResourceObject, filter map[string]interface{}, event *models.OutboxEvent) (*models.ResourceObject, *dsiErrors.DatastoreError) {
 	// Get field definitions for this resource
 	resourceDefinition, defErr := d.GetDefinitionByPathName(projectID, pathName)
 	if defErr != nil {
@@ -382,15 +500,7 @@ func (d *Database) UpdateDefDocument(projectID, pathName, documentID string, upd
 	}
 
 	// translate filters
-	translatedFilters := make(map[string]interface{})
-	for key, value := range filter {
-		// auth filters only
-		if translated, ok := objectFilterTranslation[key]; ok {
-			if _, ok := filter[translated]; !ok {
-				translatedFilters[translated] = value
-			}
-		}
-	}
+	translatedFilters := translateObjectFilters(filter)
 
 	args := make([]interface{}, 0)
 	index := 2
@@ -419,6 +529,7 @@ func (d *Database) UpdateDefDocument(projectID, pathName, documentID string, upd
 	if filterErr != nil {
 		return nil, dsiErrors.New(dsiErrors.UnknownError, filterErr)
 	}
+	documentAccessQuery(filter, tableProjectResourceObjects, &filterString, &args, &index)
 
 	query := fmt.Sprintf(
 		"UPDATE %s SET data=$1 WHERE %s RETURNING creator_type, creator, created",
@@ -430,16 +541,30 @@ func (d *Database) UpdateDefDocument(projectID, pathName, documentID string, upd
 	var created time.Time
 
 	meta := &models.MetaData{}
-	err := d.db.QueryRow(
-		query,
-		args...,
-	).Scan(
-		&meta.CreatorType,
-		&creatorID,
-		&created,
-	)
-	meta.Creator = creatorID.String
-	meta.Created = created.Unix()
+	err := d.transact(func(tx *sql.Tx) error {
+		err := tx.QueryRow(
+			query,
+			args...,
+		).Scan(
+			&meta.CreatorType,
+			&creatorID,
+			&created,
+		)
+		if err != nil {
+			return err
+		}
+		meta.Creator = creatorID.String
+		meta.Created = created.Unix()
+
+		if event != nil {
+			event.Payload, err = documentEventPayload(updatedFields, documentID, meta)
+			if err != nil {
+				return err
+			}
+		}
+
+		return insertOutboxEvent(tx, projectID, event)
+	})
 
 	updatedFields["id"] = documentID
 	updatedFields["_meta"] = meta
@@ -450,19 +575,7 @@ func (d *Database) UpdateDefDocument(projectID, pathName, documentID string, upd
 // ListDefDocuments retrieves all definition documents for the give project and path
 func (d *Database) ListDefDocuments(projectID, pathName string, limit, offset int64, filter map[string]interface{}, sort map[string]int, relations map[string]string) ([]map[string]interface{}, *dsiErrors.DatastoreError) {
 	// translate filters
-	translatedFilters := make(map[string]interface{})
-	for key, value := range filter {
-		if translated, ok := objectFilterTranslation[key]; ok {
-			if _, ok := filter[translated]; !ok {
-				translatedFilters[translated] = value
-			}
-		} else {
-			// this is a data key, translate key to JSONB filter
-			// this assumes the caller has validated this field
-			dataField := fmt.Sprintf("data->>'%s'", key)
-			translatedFilters[dataField] = value
-		}
-	}
+	translatedFilters := translateObjectFilters(filter)
 
 	args := make([]interface{}, 0)
 	index := 1
@@ -490,6 +603,7 @@ func (d *Database) ListDefDocuments(projectID, pathName string, limit, offset in
 	if filterErr != nil {
 		return nil, dsiErrors.New(dsiErrors.UnknownError, filterErr)
 	}
+	documentAccessQuery(filter, "o", &filterString, &args, &index)
 
 	// sort
 	for key, val := range sort {
@@ -616,19 +730,7 @@ func (d *Database) ListDefDocuments(projectID, pathName string, limit, offset in
 // GetDefDocument retrieves a single document
 func (d *Database) GetDefDocument(projectID, path, documentID string, filter map[string]interface{}, relations map[string]string) (map[string]interface{}, *dsiErrors.DatastoreError) {
 	// translate filters
-	translatedFilters := make(map[string]interface{})
-	for key, value := range filter {
-		if translated, ok := objectFilterTranslation[key]; ok {
-			if _, ok := filter[translated]; !ok {
-				translatedFilters[translated] = value
-			}
-		} else {
-			// this is a data key, translate key to JSONB filter
-			// this assumes the caller has validated this field
-			dataField := fmt.Sprintf("data->>'%s'", key)
-			translatedFilters[dataField] = value
-		}
-	}
+	translatedFilters := translateObjectFilters(filter)
 
 	//GetDefinitionByPathName
 	args := make([]interface{}, 0)
@@ -660,6 +762,7 @@ func (d *Database) GetDefDocument(projectID, path, documentID string, filter map
 	if filterErr != nil {
 		return nil, dsiErrors.New(dsiErrors.UnknownError, filterErr)
 	}
+	documentAccessQuery(filter, "o", &filterString, &args, &index)
 
 	queryFields := "o.id, o.creator, o.creator_type, o.created, o.data"
 	joins := ""
@@ -893,19 +996,7 @@ func (d *Database) getDocumentsInIDs(projectID string, documentIDs []string) (ma
 // CountDefDocuments returns the count of all documents for a project resource
 func (d *Database) CountDefDocuments(projectID, pathName string, filter map[string]interface{}) (int64, *dsiErrors.DatastoreError) {
 	// translate filters
-	translatedFilters := make(map[string]interface{})
-	for key, value := range filter {
-		if translated, ok := objectFilterTranslation[key]; ok {
-			if _, ok := filter[translated]; !ok {
-				translatedFilters[translated] = value
-			}
-		} else {
-			// this is a data key, translate key to JSONB filter
-			// this assumes the caller has validated this field
-			dataField := fmt.Sprintf("data->>'%s'", key)
-			translatedFilters[dataField] = value
-		}
-	}
+	translatedFilters := translateObjectFilters(filter)
 
 	args := make([]interface{}, 0)
 	index := 1
@@ -931,6 +1022,7 @@ func (d *Database) CountDefDocuments(projectID, pathName string, filter map[stri
 	if filterErr != nil {
 		return 0, dsiErrors.New(dsiErrors.UnknownError, filterErr)
 	}
+	documentAccessQuery(filter, tableProjectResourceObjects, &filterString, &args, &index)
 
 	queryFields := "count(id)"
 
@@ -957,17 +1049,9 @@ func (d *Database) CountDefDocuments(projectID, pathName string, filter map[stri
 }
 
 // DeleteDefDocument deletes a single document
-func (d *Database) DeleteDefDocument(projectID, path, documentID string, filter map[string]interface{}) *dsiErrors.DatastoreError {
+func (d *Database) DeleteDefDocument(projectID, path, documentID string, filter map[string]interface{}, event *models.OutboxEvent) *dsiErrors.DatastoreError {
 	// translate filters
-	translatedFilters := make(map[string]interface{})
-	for key, value := range filter {
-		// auth filters only
-		if translated, ok := objectFilterTranslation[key]; ok {
-			if _, ok := filter[translated]; !ok {
-				translatedFilters[translated] = value
-			}
-		}
-	}
+	translatedFilters := translateObjectFilters(filter)
 
 	args := make([]interface{}, 0)
 	index := 1
@@ -993,6 +1077,7 @@ func (d *Database) DeleteDefDocument(projectID, path, documentID string, filter
 	if filterErr != nil {
 		return dsiErrors.New(dsiErrors.UnknownError, filterErr)
 	}
+	documentAccessQuery(filter, tableProjectResourceObjects, &filterString, &args, &index)
 
 	query := fmt.Sprintf(
 		"DELETE FROM %s WHERE %s",
@@ -1000,37 +1085,230 @@ func (d *Database) DeleteDefDocument(projectID, path, documentID string, filter
 		strings.Join(filterString, " AND "),
 	)
 
-	_, err := d.db.Exec(
-		query,
-		args...,
-	)
+	err := d.transact(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			query,
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+
+		// only record an event if a document was actually removed
+		if deleted, _ := res.RowsAffected(); deleted == 0 {
+			return nil
+		}
+
+		// the document is no longer shared
+		if _, err := tx.Exec(
+			fmt.Sprintf("DELETE FROM %s WHERE project_id=$1 AND document_id=$2", tableProjectDocumentACLs),
+			projectID,
+			documentID,
+		); err != nil {
+			return err
+		}
+		if event == nil {
+			return nil
+		}
+
+		event.Payload, err = json.Marshal(map[string]interface{}{"id": documentID})
+		if err != nil {
+			return err
+		}
+
+		return insertOutboxEvent(tx, projectID, event)
+	})
 
 	return dsiErrors.New(dsiErrors.UnknownError, err)
 }
 
-// DropDefDocuments drops documents for a resource
+// documentEventPayload returns the JSON payload of a document event, including the document id and metadata
+func documentEventPayload(fields models.ResourceObject, documentID string, metadata *models.MetaData) ([]byte, error) {
+	payload := make(map[string]interface{})
+	for key, value := range fields {
+		payload[key] = value
+	}
+	payload["id"] = documentID
+	payload["_metadata"] = metadata
+
+	return json.Marshal(payload)
+}
+
+// DropDefDocuments drops documents for a resource, along with their ACL entries
 func (d *Database) DropDefDocuments(projectID, path string) *dsiErrors.DatastoreError {
-	_, err := d.db.Exec(
-		fmt.Sprintf(
-			"DELETE FROM %s WHERE resource_path=$1 AND project_id=$2",
-			tableProjectResourceObjects,
-		),
-		path,
-		projectID,
-	)
+	err := d.transact(func(tx *sql.Tx) error {
+		for _, table := range []string{tableProjectDocumentACLs, tableProjectResourceObjects} {
+			if _, err := tx.Exec(
+				fmt.Sprintf(
+					"DELETE FROM %s WHERE resource_path=$1 AND project_id=$2",
+					table,
+				),
+				path,
+				projectID,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 
 	return dsiErrors.New(dsiErrors.UnknownError, err)
 }
 
-// DropProjectDefDocuments drops the entire collection of documents for a project
+// DropProjectDefDocuments drops the entire collection of documents for a project, along with their ACL entries
 func (d *Database) DropProjectDefDocuments(projectID string) *dsiErrors.DatastoreError {
-	_, err := d.db.Exec(
+	err := d.transact(func(tx *sql.Tx) error {
+		for _, table := range []string{tableProjectDocumentACLs, tableProjectResourceObjects} {
+			if _, err := tx.Exec(
+				fmt.Sprintf(
+					"DELETE FROM %s WHERE project_id=$1",
+					table,
+				),
+				projectID,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return dsiErrors.New(dsiErrors.UnknownError, err)
+}
+
+// ListExpiringDefinitions lists the definitions of all projects that have a TTL
+func (d *Database) ListExpiringDefinitions() ([]*models.ResourceDefinition, *dsiErrors.DatastoreError) {
+	rows, err := d.db.Query(
 		fmt.Sprintf(
-			"DELETE FROM %s WHERE project_id=$1",
-			tableProjectResourceObjects,
+			"SELECT id, project_id, path_name, ttl FROM %s WHERE ttl IS NOT NULL",
+			tableProjectResourceDefinitions,
 		),
-		projectID,
 	)
+	if err != nil {
+		return nil, dsiErrors.New(dsiErrors.UnknownError, err)
+	}
+	defer rows.Close()
 
-	return dsiErrors.New(dsiErrors.UnknownError, err)
+	definitions := make([]*models.ResourceDefinition, 0)
+	for rows.Next() {
+		def := models.ResourceDefinition{}
+		var ttl []byte
+		err = rows.Scan(
+			&def.ID,
+			&def.ProjectID,
+			&def.PathName,
+			&ttl,
+		)
+		if err != nil {
+			return nil, dsiErrors.New(dsiErrors.UnknownError, err)
+		}
+		if def.TTL, err = scanTTL(ttl); err != nil {
+			return nil, dsiErrors.New(dsiErrors.UnknownError, err)
+		}
+
+		definitions = append(definitions, &def)
+	}
+
+	return definitions, dsiErrors.New(dsiErrors.UnknownError, rows.Err())
+}
+
+// DeleteExpiredDefDocuments deletes up to `limit` expired documents of the definition, recording a `delete` event for
+// each document. Returns the number of deleted documents.
+func (d *Database) DeleteExpiredDefDocuments(definition *models.ResourceDefinition, limit int) (int64, *dsiErrors.DatastoreError) {
+	if definition.TTL == nil {
+		return 0, nil
+	}
+
+	cutoff := time.Now().Add(-time.Duration(definition.TTL.Seconds) * time.Second)
+	args := []interface{}{definition.ProjectID, definition.PathName, cutoff, limit}
+
+	// documents without a valid date-time value for the property never expire, `safe_timestamptz` is NULL for them
+	expired := "created < $3"
+	if definition.TTL.Property != "" {
+		expired = "safe_timestamptz(data->>$5) < $3"
+		args = append(args, definition.TTL.Property)
+	}
+
+	var deleted int64
+	err := d.transact(func(tx *sql.Tx) error {
+		rows, err := tx.Query(
+			fmt.Sprintf(
+				"DELETE FROM %s WHERE id IN (SELECT id FROM %s WHERE project_id=$1 AND resource_path=$2 AND %s LIMIT $4 FOR UPDATE SKIP LOCKED) RETURNING id",
+				tableProjectResourceObjects,
+				tableProjectResourceObjects,
+				expired,
+			),
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]string, 0)
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if len(ids) > 0 {
+			if _, err := tx.Exec(
+				fmt.Sprintf("DELETE FROM %s WHERE project_id=$1 AND document_id=ANY($2::uuid[])", tableProjectDocumentACLs),
+				definition.ProjectID,
+				pq.Array(ids),
+			); err != nil {
+				return err
+			}
+		}
+
+		for _, id := range ids {
+			payload, err := json.Marshal(map[string]interface{}{"id": id})
+			if err != nil {
+				return err
+			}
+
+			event := &models.OutboxEvent{
+				Entity:    models.EndpointResource,
+				EntityKey: definition.PathName,
+				EntityID:  definition.ID,
+				Action:    models.ActionDelete,
+				Payload:   payload,
+			}
+			if err := insertOutboxEvent(tx, definition.ProjectID, event); err != nil {
+				return err
+			}
+		}
+
+		deleted = int64(len(ids))
+		return nil
+	})
+
+	return deleted, dsiErrors.New(dsiErrors.UnknownError, err)
+}
+
+// ttlValue returns the TTL as a JSONB parameter, `nil` if the definition does not expire
+func ttlValue(ttl *models.ResourceTTL) (interface{}, error) {
+	if ttl == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(ttl)
+}
+
+// scanTTL parses the JSONB TTL column
+func scanTTL(value []byte) (*models.ResourceTTL, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	ttl := &models.ResourceTTL{}
+	err := json.Unmarshal(value, ttl)
+
+	return ttl, err
 }
@@ -0,0 +1,152 @@
+package postgres
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const tableProjectRoles = "project_roles"
+
+const roleColumns = "id, project_id, name, description, permissions, created"
+
+// ListRoles returns the custom roles of a project
+func (d *Database) ListRoles(projectID string) ([]*models.Role, error) {
+	rows, err := d.db.Query(
+		fmt.Sprintf(
+			"SELECT %s FROM %s WHERE project_id=$1 ORDER BY name",
+			roleColumns,
+			tableProjectRoles,
+		),
+		projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]*models.Role, 0)
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// GetRole retrieves a custom role of a project by name
+func (d *Database) GetRole(projectID, name string) (*models.Role, error) {
+	return scanRole(d.db.QueryRow(
+		fmt.Sprintf(
+			"SELECT %s FROM %s WHERE project_id=$1 and name=$2",
+			roleColumns,
+			tableProjectRoles,
+		),
+		projectID,
+		name,
+	))
+}
+
+// CreateRole creates a custom role of a project, the ID of the role is set to the inserted ID
+func (d *Database) CreateRole(projectID string, role *models.Role) error {
+	permissions, err := marshalPermissions(role.Permissions)
+	if err != nil {
+		return err
+	}
+	role.ProjectID = projectID
+	role.Created = time.Now()
+
+	return d.db.QueryRow(
+		fmt.Sprintf(
+			"INSERT INTO %s (project_id, name, description, permissions, created) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+			tableProjectRoles,
+		),
+		projectID,
+		role.Name,
+		role.Description,
+		permissions,
+		role.Created,
+	).Scan(&role.ID)
+}
+
+// UpdateRole updates the description and permissions of a custom role
+func (d *Database) UpdateRole(projectID, name string, role *models.Role) error {
+	permissions, err := marshalPermissions(role.Permissions)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET description=$1, permissions=$2 WHERE project_id=$3 and name=$4",
+			tableProjectRoles,
+		),
+		role.Description,
+		permissions,
+		projectID,
+		name,
+	)
+	return err
+}
+
+// DeleteRole deletes a custom role of a project
+func (d *Database) DeleteRole(projectID, name string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE project_id=$1 and name=$2",
+			tableProjectRoles,
+		),
+		projectID,
+		name,
+	)
+	return err
+}
+
+// DropProjectRoles deletes all custom roles of a project
+func (d *Database) DropProjectRoles(projectID string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE project_id=$1",
+			tableProjectRoles,
+		),
+		projectID,
+	)
+	return err
+}
+
+func scanRole(row scanner) (*models.Role, error) {
+	role := &models.Role{}
+	var permissions []byte
+
+	err := row.Scan(
+		&role.ID,
+		&role.ProjectID,
+		&role.Name,
+		&role.Description,
+		&permissions,
+		&role.Created,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	role.Permissions = make([]*models.RolePermission, 0)
+	if err := json.Unmarshal(permissions, &role.Permissions); err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// marshalPermissions encodes the permissions of a role, nil permissions are an empty array
+func marshalPermissions(permissions []*models.RolePermission) ([]byte, error) {
+	if permissions == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(permissions)
+}
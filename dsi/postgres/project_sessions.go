@@ -13,7 +13,7 @@ const tableProjectSessions = "project_sessions"
 func (d *Database) CreateSession(projectID string, session *models.Session) error {
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"INSERT INTO %s (project_id, user_id, location, mobile, ip, last_accessed, browser, os) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+			"INSERT INTO %s (project_id, user_id, location, mobile, ip, last_accessed, browser, os, refresh_token_id, expires) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id",
 			tableProjectSessions,
 		),
 		projectID,
@@ -24,6 +24,8 @@ func (d *Database) CreateSession(projectID string, session *models.Session) erro
 		session.LastAccessed,
 		session.Browser,
 		session.OS,
+		session.RefreshTokenID,
+		session.Expires,
 	).Scan(&session.ID)
 
 	return err
@@ -44,13 +46,36 @@ func (d *Database) UpdateProjectSessionLastAccessed(projectID, sessionID string,
 	return err
 }
 
+// RotateRefreshToken replaces the refresh token ID of the session and extends its expiry. False is returned if the
+// current refresh token ID no longer matches, because the token has already been used.
+func (d *Database) RotateRefreshToken(projectID, sessionID, currentID, nextID string, expires time.Time) (bool, error) {
+	res, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET refresh_token_id=$1, expires=$2, last_accessed=$3 WHERE id=$4 and project_id=$5 and refresh_token_id=$6",
+			tableProjectSessions,
+		),
+		nextID,
+		expires,
+		time.Now(),
+		sessionID,
+		projectID,
+		currentID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
 // GetSession retrieves a single project session by ID
 func (d *Database) GetSession(projectID, sessionID string) (*models.Session, error) {
 	session := models.Session{}
 
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, project_id, user_id, location, mobile, ip, last_accessed, browser, os FROM %s WHERE id=$1 and project_id=$2",
+			"SELECT id, project_id, user_id, location, mobile, ip, last_accessed, browser, os, refresh_token_id, expires FROM %s WHERE id=$1 and project_id=$2",
 			tableProjectSessions,
 		),
 		sessionID,
@@ -65,6 +90,8 @@ func (d *Database) GetSession(projectID, sessionID string) (*models.Session, err
 		&session.LastAccessed,
 		&session.Browser,
 		&session.OS,
+		&session.RefreshTokenID,
+		&session.Expires,
 	)
 	if err != nil {
 		return nil, err
@@ -77,7 +104,7 @@ func (d *Database) GetSession(projectID, sessionID string) (*models.Session, err
 func (d *Database) ListSessions(projectID string) ([]*models.Session, error) {
 	rows, err := d.db.Query(
 		fmt.Sprintf(
-			"SELECT id, user_id, project_id, location, mobile, ip, last_accessed, browser, os FROM %s WHERE project_id=$1",
+			"SELECT id, user_id, project_id, location, mobile, ip, last_accessed, browser, os, refresh_token_id, expires FROM %s WHERE project_id=$1",
 			tableProjectSessions,
 		),
 		projectID,
@@ -100,6 +127,8 @@ func (d *Database) ListSessions(projectID string) ([]*models.Session, error) {
 			&session.LastAccessed,
 			&session.Browser,
 			&session.OS,
+			&session.RefreshTokenID,
+			&session.Expires,
 		)
 		if err != nil {
 			return nil, err
@@ -124,6 +153,19 @@ func (d *Database) DeleteSession(projectID, sessionID string) error {
 	return err
 }
 
+// DeleteUserSessions deletes all sessions of a project user
+func (d *Database) DeleteUserSessions(projectID, userID string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE user_id=$1 and project_id=$2",
+			tableProjectSessions,
+		),
+		userID,
+		projectID,
+	)
+	return err
+}
+
 // DropProjectSessions drops the collection of this project's user sessions
 func (d *Database) DropProjectSessions(projectID string) error {
 	_, err := d.db.Exec(
@@ -135,3 +177,18 @@ func (d *Database) DropProjectSessions(projectID string) error {
 	)
 	return err
 }
+
+// PurgeProjectSessions deletes the expired sessions of all projects last accessed before `before`
+func (d *Database) PurgeProjectSessions(before time.Time) (int64, error) {
+	res, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE last_accessed < $1 and expires < NOW()",
+			tableProjectSessions,
+		),
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -1,6 +1,8 @@
 package postgres
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -9,63 +11,61 @@ import (
 
 const tableProjectUsers = "project_users"
 
+const projectUserColumns = "id, project_id, email, username, password_hash, read, write, role, active, attributes, groups, created, email_verified"
+
 // GetUserByUsername retrieves a project user by the user's username
 func (d *Database) GetUserByUsername(projectID, userName string) (*models.ProjectUser, error) {
-	user := &models.ProjectUser{}
-
-	err := d.db.QueryRow(
+	return scanProjectUser(d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, project_id, email, username, password_hash, read, write, role, created from %s WHERE username=$1 and project_id=$2",
+			"SELECT %s FROM %s WHERE username=$1 and project_id=$2",
+			projectUserColumns,
 			tableProjectUsers,
 		),
 		userName,
 		projectID,
-	).Scan(
-		&user.ID,
-		&user.ProjectID,
-		&user.Email,
-		&user.Username,
-		&user.PasswordHash,
-		&user.Read,
-		&user.Write,
-		&user.Role,
-		&user.Created,
-	)
-
-	return user, err
+	))
 }
 
 // GetUserByID retrieves a project user by user _id
 func (d *Database) GetUserByID(projectID, userID string) (*models.ProjectUser, error) {
-	user := &models.ProjectUser{}
-
-	err := d.db.QueryRow(
+	return scanProjectUser(d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, project_id, email, username, password_hash, read, write, role, created from %s WHERE id=$1 and project_id=$2",
+			"SELECT %s FROM %s WHERE id=$1 and project_id=$2",
+			projectUserColumns,
 			tableProjectUsers,
 		),
 		userID,
 		projectID,
-	).Scan(
-		&user.ID,
-		&user.ProjectID,
-		&user.Email,
-		&user.Username,
-		&user.PasswordHash,
-		&user.Read,
-		&user.Write,
-		&user.Role,
-		&user.Created,
-	)
+	))
+}
 
-	return user, err
+// GetUserByEmail retrieves a project user by the user's email
+func (d *Database) GetUserByEmail(projectID, email string) (*models.ProjectUser, error) {
+	return scanProjectUser(d.db.QueryRow(
+		fmt.Sprintf(
+			"SELECT %s FROM %s WHERE lower(email)=lower($1) and project_id=$2",
+			projectUserColumns,
+			tableProjectUsers,
+		),
+		email,
+		projectID,
+	))
 }
 
-// CreateUser creates a new project user for the project
+// CreateUser creates a new project user for the project, the ID of the user is set to the inserted ID
 func (d *Database) CreateUser(projectID string, user *models.ProjectUser) error {
-	_, err := d.db.Exec(
+	attributes, err := marshalAttributes(user.Attributes)
+	if err != nil {
+		return err
+	}
+	groups, err := marshalGroups(user.Groups)
+	if err != nil {
+		return err
+	}
+
+	return d.db.QueryRow(
 		fmt.Sprintf(
-			"INSERT INTO %s (project_id, email, username, password_hash, read, write, role, created) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+			"INSERT INTO %s (project_id, email, username, password_hash, read, write, role, active, attributes, groups, created) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id",
 			tableProjectUsers,
 		),
 		projectID,
@@ -75,22 +75,83 @@ func (d *Database) CreateUser(projectID string, user *models.ProjectUser) error
 		user.Read,
 		user.Write,
 		user.Role,
+		user.Active,
+		attributes,
+		groups,
 		time.Now(),
-	)
-
-	return err
+	).Scan(&user.ID)
 }
 
-// UpdateUser updates the project user's access and role
+// UpdateUser updates the project user's access, role, attributes and groups. The attributes and groups are not changed
+// if they are nil.
 func (d *Database) UpdateUser(projectID, userID string, user *models.ProjectUser) error {
-	_, err := d.db.Exec(
+	var attributes, groups []byte
+	var err error
+	if user.Attributes != nil {
+		if attributes, err = marshalAttributes(user.Attributes); err != nil {
+			return err
+		}
+	}
+	if user.Groups != nil {
+		if groups, err = marshalGroups(user.Groups); err != nil {
+			return err
+		}
+	}
+
+	_, err = d.db.Exec(
 		fmt.Sprintf(
-			"UPDATE %s SET read=$1, write=$2, role=$3 WHERE id=$4 and project_id=$5",
+			"UPDATE %s SET read=$1, write=$2, role=$3, attributes=COALESCE($4, attributes), groups=COALESCE($5, groups) WHERE id=$6 and project_id=$7",
 			tableProjectUsers,
 		),
 		user.Read,
 		user.Write,
 		user.Role,
+		attributes,
+		groups,
+		userID,
+		projectID,
+	)
+
+	return err
+}
+
+// ActivateProjectUser updates the project user's active flag
+func (d *Database) ActivateProjectUser(projectID, userID string, active bool) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET active=$1 WHERE id=$2 and project_id=$3",
+			tableProjectUsers,
+		),
+		active,
+		userID,
+		projectID,
+	)
+
+	return err
+}
+
+// VerifyProjectUserEmail marks the project user's email as verified and activates the user
+func (d *Database) VerifyProjectUserEmail(projectID, userID string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET email_verified=true, active=true WHERE id=$1 and project_id=$2",
+			tableProjectUsers,
+		),
+		userID,
+		projectID,
+	)
+
+	return err
+}
+
+// UpdateProjectUserPassword updates the project user's password hash
+func (d *Database) UpdateProjectUserPassword(projectID, userID, passwordHash string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET password_hash=$1 WHERE id=$2 and project_id=$3",
+			tableProjectUsers,
+		),
+		passwordHash,
 		userID,
 		projectID,
 	)
@@ -102,7 +163,8 @@ func (d *Database) UpdateUser(projectID, userID string, user *models.ProjectUser
 func (d *Database) ListUsers(projectID string) ([]*models.ProjectUser, error) {
 	rows, err := d.db.Query(
 		fmt.Sprintf(
-			"SELECT id, project_id, email, username, password_hash, read, write, role, created FROM %s WHERE project_id=$1",
+			"SELECT %s FROM %s WHERE project_id=$1",
+			projectUserColumns,
 			tableProjectUsers,
 		),
 		projectID,
@@ -114,23 +176,12 @@ func (d *Database) ListUsers(projectID string) ([]*models.ProjectUser, error) {
 
 	users := make([]*models.ProjectUser, 0)
 	for rows.Next() {
-		user := models.ProjectUser{}
-		err = rows.Scan(
-			&user.ID,
-			&user.ProjectID,
-			&user.Email,
-			&user.Username,
-			&user.PasswordHash,
-			&user.Read,
-			&user.Write,
-			&user.Role,
-			&user.Created,
-		)
+		user, err := scanProjectUser(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		users = append(users, &user)
+		users = append(users, user)
 	}
 
 	return users, rows.Err()
@@ -138,15 +189,25 @@ func (d *Database) ListUsers(projectID string) ([]*models.ProjectUser, error) {
 
 // DeleteUser deletes a project user for a project based on userID
 func (d *Database) DeleteUser(projectID, userID string) error {
-	_, err := d.db.Exec(
-		fmt.Sprintf(
-			"DELETE FROM %s WHERE id=$1 and project_id=$2",
-			tableProjectUsers,
-		),
-		userID,
-		projectID,
-	)
-	return err
+	return d.transact(func(tx *sql.Tx) error {
+		// unlink the identities of the user
+		if _, err := tx.Exec(
+			fmt.Sprintf("DELETE FROM %s WHERE user_id=$1 and project_id=$2", tableProjectUserIdentities),
+			userID,
+			projectID,
+		); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			fmt.Sprintf(
+				"DELETE FROM %s WHERE id=$1 and project_id=$2",
+				tableProjectUsers,
+			),
+			userID,
+			projectID,
+		)
+		return err
+	})
 }
 
 // DropProjectUsers drops the mongo collection of this project's users
@@ -160,3 +221,54 @@ func (d *Database) DropProjectUsers(projectID string) error {
 	)
 	return err
 }
+
+func scanProjectUser(row scanner) (*models.ProjectUser, error) {
+	user := &models.ProjectUser{}
+	var attributes, groups []byte
+
+	err := row.Scan(
+		&user.ID,
+		&user.ProjectID,
+		&user.Email,
+		&user.Username,
+		&user.PasswordHash,
+		&user.Read,
+		&user.Write,
+		&user.Role,
+		&user.Active,
+		&attributes,
+		&groups,
+		&user.Created,
+		&user.EmailVerified,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Attributes = map[string]interface{}{}
+	if err := json.Unmarshal(attributes, &user.Attributes); err != nil {
+		return nil, err
+	}
+	user.Groups = []string{}
+	if err := json.Unmarshal(groups, &user.Groups); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// marshalAttributes encodes the attributes of a project user, nil attributes are an empty object
+func marshalAttributes(attributes map[string]interface{}) ([]byte, error) {
+	if attributes == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(attributes)
+}
+
+// marshalGroups encodes the groups of a project user, nil groups are an empty array
+func marshalGroups(groups []string) ([]byte, error) {
+	if groups == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(groups)
+}
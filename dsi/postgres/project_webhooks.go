@@ -69,7 +69,7 @@ func (d *Database) ListResults(projectID, hookID string) ([]*models.HookResult,
 func (d *Database) AddHook(projectID string, hook *models.WebHook) *errors.DatastoreError {
 	_, err := d.db.Exec(
 		fmt.Sprintf(
-			"INSERT INTO %s (project_id, label, isenabled, entity, entity_id, hook_event, headers, hook_url) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+			"INSERT INTO %s (project_id, label, isenabled, entity, entity_id, hook_event, headers, hook_url, phase, timeout_ms, fail_open) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)",
 			tableProjectWebHooks,
 		),
 		projectID,
@@ -80,6 +80,9 @@ func (d *Database) AddHook(projectID string, hook *models.WebHook) *errors.Datas
 		hook.HookEvent,
 		hook.Headers,
 		hook.HookURL,
+		hook.Phase,
+		hook.TimeoutMS,
+		hook.FailOpen,
 	)
 
 	return errors.New(errors.UnknownError, err)
@@ -89,7 +92,7 @@ func (d *Database) AddHook(projectID string, hook *models.WebHook) *errors.Datas
 func (d *Database) ListHooks(projectID string) ([]*models.WebHook, *errors.DatastoreError) {
 	rows, err := d.db.Query(
 		fmt.Sprintf(
-			"SELECT id, project_id, label, isenabled, entity, entity_id, hook_event, headers, hook_url FROM %s WHERE project_id=$1",
+			"SELECT id, project_id, label, isenabled, entity, entity_id, hook_event, headers, hook_url, phase, timeout_ms, fail_open FROM %s WHERE project_id=$1",
 			tableProjectWebHooks,
 		),
 		projectID,
@@ -112,6 +115,9 @@ func (d *Database) ListHooks(projectID string) ([]*models.WebHook, *errors.Datas
 			&hook.HookEvent,
 			&hook.Headers,
 			&hook.HookURL,
+			&hook.Phase,
+			&hook.TimeoutMS,
+			&hook.FailOpen,
 		)
 		if err != nil {
 			return nil, errors.New(errors.UnknownError, err)
@@ -128,7 +134,7 @@ func (d *Database) GetHook(projectID, hookID string) (*models.WebHook, *errors.D
 	hook := models.WebHook{}
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, project_id, label, isenabled, entity, entity_id, hook_event, headers, hook_url FROM %s WHERE project_id=$1 AND id=$2",
+			"SELECT id, project_id, label, isenabled, entity, entity_id, hook_event, headers, hook_url, phase, timeout_ms, fail_open FROM %s WHERE project_id=$1 AND id=$2",
 			tableProjectWebHooks,
 		),
 		projectID,
@@ -143,6 +149,9 @@ func (d *Database) GetHook(projectID, hookID string) (*models.WebHook, *errors.D
 		&hook.HookEvent,
 		&hook.Headers,
 		&hook.HookURL,
+		&hook.Phase,
+		&hook.TimeoutMS,
+		&hook.FailOpen,
 	)
 
 	return &hook, errors.New(errors.UnknownError, err)
@@ -152,7 +161,7 @@ func (d *Database) GetHook(projectID, hookID string) (*models.WebHook, *errors.D
 func (d *Database) UpdateHook(projectID, hookID string, hook *models.WebHook) *errors.DatastoreError {
 	_, err := d.db.Exec(
 		fmt.Sprintf(
-			"UPDATE %s SET label=$1, isenabled=$2, entity=$3, entity_id=$4, hook_event=$5, headers=$6, hook_url=$7 WHERE id=$8 and project_id=$9",
+			"UPDATE %s SET label=$1, isenabled=$2, entity=$3, entity_id=$4, hook_event=$5, headers=$6, hook_url=$7, phase=$8, timeout_ms=$9, fail_open=$10 WHERE id=$11 and project_id=$12",
 			tableProjectWebHooks,
 		),
 		hook.Label,
@@ -162,6 +171,9 @@ func (d *Database) UpdateHook(projectID, hookID string, hook *models.WebHook) *e
 		hook.HookEvent,
 		hook.Headers,
 		hook.HookURL,
+		hook.Phase,
+		hook.TimeoutMS,
+		hook.FailOpen,
 		hook.ID,
 		projectID,
 	)
@@ -194,3 +206,20 @@ func (d *Database) DeleteHook(projectID, hookID string) *errors.DatastoreError {
 	)
 	return errors.New(errors.UnknownError, err)
 }
+
+// PurgeResults deletes the webhook results of all projects created before `before`
+func (d *Database) PurgeResults(before time.Time) (int64, *errors.DatastoreError) {
+	res, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE created < $1",
+			tableProjectWebhookResults,
+		),
+		before,
+	)
+	if err != nil {
+		return 0, errors.New(errors.UnknownError, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	return deleted, errors.New(errors.UnknownError, err)
+}
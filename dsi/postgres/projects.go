@@ -9,17 +9,22 @@ import (
 const tableAppProjects = "app_projects"
 const tableAppProjectLimits = "app_project_limits"
 
-// UpdateProject updates the project's name, description, icon, and user_registration
+// UpdateProject updates the project's name, description, icon, user registration, user verification, token lifetimes
+// and MFA requirement
 func (d *Database) UpdateProject(slug, userID string, project *models.Project) (*models.Project, error) {
 	_, err := d.db.Exec(
 		fmt.Sprintf(
-			"UPDATE %s SET name=$1, description=$2, icon=$3, user_registration=$4  WHERE slug=$5 and user_id=$6",
+			"UPDATE %s SET name=$1, description=$2, icon=$3, user_registration=$4, user_verification=$5, access_token_lifetime=$6, refresh_token_lifetime=$7, mfa_required=$8 WHERE slug=$9 and user_id=$10",
 			tableAppProjects,
 		),
 		project.Name,
 		project.Description,
 		project.Icon,
 		project.UserRegistration,
+		project.UserVerification,
+		project.AccessTokenLifetime,
+		project.RefreshTokenLifetime,
+		project.MFARequired,
 		slug,
 		userID,
 	)
@@ -72,7 +77,7 @@ func (d *Database) CreateProject(userID, slug, name, description, icon string, a
 func (d *Database) ListUserProjects(userID string) ([]*models.Project, error) {
 	rows, err := d.db.Query(
 		fmt.Sprintf(
-			"SELECT id, user_id, slug, name, description, icon, user_registration, created FROM %s WHERE user_id=$1",
+			"SELECT id, user_id, slug, name, description, icon, user_registration, user_verification, access_token_lifetime, refresh_token_lifetime, mfa_required, created FROM %s WHERE user_id=$1",
 			tableAppProjects,
 		),
 		userID,
@@ -93,6 +98,10 @@ func (d *Database) ListUserProjects(userID string) ([]*models.Project, error) {
 			&project.Description,
 			&project.Icon,
 			&project.UserRegistration,
+			&project.UserVerification,
+			&project.AccessTokenLifetime,
+			&project.RefreshTokenLifetime,
+			&project.MFARequired,
 			&project.Created,
 		)
 		if err != nil {
@@ -111,7 +120,7 @@ func (d *Database) GetProjectBySlug(slug string) (*models.Project, error) {
 
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, user_id, slug, name, description, icon, user_registration, created FROM %s WHERE slug=$1",
+			"SELECT id, user_id, slug, name, description, icon, user_registration, user_verification, access_token_lifetime, refresh_token_lifetime, mfa_required, created FROM %s WHERE slug=$1",
 			tableAppProjects,
 		),
 		slug,
@@ -123,6 +132,10 @@ func (d *Database) GetProjectBySlug(slug string) (*models.Project, error) {
 		&project.Description,
 		&project.Icon,
 		&project.UserRegistration,
+		&project.UserVerification,
+		&project.AccessTokenLifetime,
+		&project.RefreshTokenLifetime,
+		&project.MFARequired,
 		&project.Created,
 	)
 	if err != nil {
@@ -138,7 +151,7 @@ func (d *Database) GetProjectDetailBySlug(slug string) (*models.ProjectDetail, e
 
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, user_id, slug, name, description, icon, user_registration, created, requests FROM %s WHERE slug=$1",
+			"SELECT id, user_id, slug, name, description, icon, user_registration, user_verification, access_token_lifetime, refresh_token_lifetime, created, requests FROM %s WHERE slug=$1",
 			tableAppProjectLimits,
 		),
 		slug,
@@ -150,6 +163,9 @@ func (d *Database) GetProjectDetailBySlug(slug string) (*models.ProjectDetail, e
 		&project.Description,
 		&project.Icon,
 		&project.UserRegistration,
+		&project.UserVerification,
+		&project.AccessTokenLifetime,
+		&project.RefreshTokenLifetime,
 		&project.Created,
 		&project.Requests,
 	)
@@ -166,7 +182,7 @@ func (d *Database) GetProjectBySlugAndUserID(slug, userID string) (*models.Proje
 
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, user_id, slug, name, description, icon, user_registration, created FROM %s WHERE slug=$1 and user_id=$2",
+			"SELECT id, user_id, slug, name, description, icon, user_registration, user_verification, access_token_lifetime, refresh_token_lifetime, mfa_required, created FROM %s WHERE slug=$1 and user_id=$2",
 			tableAppProjects,
 		),
 		slug,
@@ -179,6 +195,10 @@ func (d *Database) GetProjectBySlugAndUserID(slug, userID string) (*models.Proje
 		&project.Description,
 		&project.Icon,
 		&project.UserRegistration,
+		&project.UserVerification,
+		&project.AccessTokenLifetime,
+		&project.RefreshTokenLifetime,
+		&project.MFARequired,
 		&project.Created,
 	)
 	if err != nil {
@@ -13,7 +13,7 @@ const tableAppSessions = "app_sessions"
 func (d *Database) CreateAppSession(session *models.Session) error {
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"INSERT INTO %s (user_id, location, mobile, ip, last_accessed, browser, os) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+			"INSERT INTO %s (user_id, location, mobile, ip, last_accessed, browser, os, refresh_token_id, mfa, expires) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id",
 			tableAppSessions,
 		),
 		session.UserID,
@@ -23,6 +23,9 @@ func (d *Database) CreateAppSession(session *models.Session) error {
 		session.LastAccessed,
 		session.Browser,
 		session.OS,
+		session.RefreshTokenID,
+		session.MFA,
+		session.Expires,
 	).Scan(&session.ID)
 
 	return err
@@ -42,11 +45,33 @@ func (d *Database) UpdateAppSessionLastAccessed(sessionID string, lastAccessed t
 	return err
 }
 
+// RotateAppRefreshToken replaces the refresh token ID of the session and extends its expiry. False is returned if the
+// current refresh token ID no longer matches, because the token has already been used.
+func (d *Database) RotateAppRefreshToken(sessionID, currentID, nextID string, expires time.Time) (bool, error) {
+	res, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET refresh_token_id=$1, expires=$2, last_accessed=$3 WHERE id=$4 and refresh_token_id=$5",
+			tableAppSessions,
+		),
+		nextID,
+		expires,
+		time.Now(),
+		sessionID,
+		currentID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
 // ListUserSessions lists all sessions for a user
 func (d *Database) ListUserSessions(userID string) ([]*models.Session, error) {
 	rows, err := d.db.Query(
 		fmt.Sprintf(
-			"SELECT id, user_id, location, mobile, ip, last_accessed, browser, os FROM %s WHERE user_id=$1",
+			"SELECT id, user_id, location, mobile, ip, last_accessed, browser, os, refresh_token_id, mfa, expires FROM %s WHERE user_id=$1",
 			tableAppSessions,
 		),
 		userID,
@@ -68,6 +93,9 @@ func (d *Database) ListUserSessions(userID string) ([]*models.Session, error) {
 			&session.LastAccessed,
 			&session.Browser,
 			&session.OS,
+			&session.RefreshTokenID,
+			&session.MFA,
+			&session.Expires,
 		)
 		if err != nil {
 			return nil, err
@@ -85,7 +113,7 @@ func (d *Database) GetAppSession(sessionID string) (*models.Session, error) {
 
 	err := d.db.QueryRow(
 		fmt.Sprintf(
-			"SELECT id, user_id, location, mobile, ip, last_accessed, browser, os FROM %s WHERE id=$1",
+			"SELECT id, user_id, location, mobile, ip, last_accessed, browser, os, refresh_token_id, mfa, expires FROM %s WHERE id=$1",
 			tableAppSessions,
 		),
 		sessionID,
@@ -98,6 +126,9 @@ func (d *Database) GetAppSession(sessionID string) (*models.Session, error) {
 		&session.LastAccessed,
 		&session.Browser,
 		&session.OS,
+		&session.RefreshTokenID,
+		&session.MFA,
+		&session.Expires,
 	)
 	if err != nil {
 		return nil, err
@@ -117,3 +148,18 @@ func (d *Database) DeleteAppSession(sessionID string) error {
 	)
 	return err
 }
+
+// PurgeAppSessions deletes the expired application user sessions last accessed before `before`
+func (d *Database) PurgeAppSessions(before time.Time) (int64, error) {
+	res, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE last_accessed < $1 and expires < NOW()",
+			tableAppSessions,
+		),
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,158 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const tableSigningKeys = "signing_keys"
+
+const signingKeyColumns = "id, project_id, algorithm, private_key, created, activates, rotates, expires"
+
+// ListSigningKeys returns the published signing keys of a project, newest first. An empty project ID returns the keys
+// of application users.
+func (d *Database) ListSigningKeys(projectID string) ([]*models.SigningKey, error) {
+	rows, err := d.db.Query(
+		fmt.Sprintf(
+			"SELECT %s FROM %s WHERE project_id IS NOT DISTINCT FROM $1 and expires > $2 ORDER BY activates DESC",
+			signingKeyColumns,
+			tableSigningKeys,
+		),
+		nullString(projectID),
+		time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*models.SigningKey, 0)
+	for rows.Next() {
+		key, err := scanSigningKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetSigningKey retrieves a signing key by its key ID
+func (d *Database) GetSigningKey(kid string) (*models.SigningKey, error) {
+	return scanSigningKey(d.db.QueryRow(
+		fmt.Sprintf(
+			"SELECT %s FROM %s WHERE id=$1",
+			signingKeyColumns,
+			tableSigningKeys,
+		),
+		kid,
+	))
+}
+
+// CreateSigningKey saves a new signing key. False is returned if a key of the project and algorithm already signs
+// tokens at the activation time, i.e. another instance created it concurrently.
+func (d *Database) CreateSigningKey(key *models.SigningKey) (bool, error) {
+	created := false
+	err := d.transact(func(tx *sql.Tx) error {
+		// keys of a project are created one at a time, so the check below sees keys of concurrent transactions
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", tableSigningKeys+":"+key.ProjectID); err != nil {
+			return err
+		}
+
+		var exists bool
+		if err := tx.QueryRow(
+			fmt.Sprintf(
+				"SELECT EXISTS (SELECT 1 FROM %s WHERE project_id IS NOT DISTINCT FROM $1 and algorithm=$2 and activates <= $3 and rotates > $3)",
+				tableSigningKeys,
+			),
+			nullString(key.ProjectID),
+			key.Algorithm,
+			key.Activates,
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+
+		_, err := tx.Exec(
+			fmt.Sprintf(
+				"INSERT INTO %s (%s) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+				tableSigningKeys,
+				signingKeyColumns,
+			),
+			key.ID,
+			nullString(key.ProjectID),
+			key.Algorithm,
+			key.PrivateKey,
+			key.Created,
+			key.Activates,
+			key.Rotates,
+			key.Expires,
+		)
+		created = err == nil
+		return err
+	})
+	return created, err
+}
+
+// DropProjectSigningKeys deletes all signing keys of a project
+func (d *Database) DropProjectSigningKeys(projectID string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE project_id=$1",
+			tableSigningKeys,
+		),
+		projectID,
+	)
+	return err
+}
+
+// PurgeSigningKeys deletes signing keys which expired before the time
+func (d *Database) PurgeSigningKeys(before time.Time) (int64, error) {
+	res, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE expires < $1",
+			tableSigningKeys,
+		),
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// nullString passes empty strings as `NULL`
+func nullString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func scanSigningKey(row scanner) (*models.SigningKey, error) {
+	key := &models.SigningKey{}
+	var projectID sql.NullString
+
+	err := row.Scan(
+		&key.ID,
+		&projectID,
+		&key.Algorithm,
+		&key.PrivateKey,
+		&key.Created,
+		&key.Activates,
+		&key.Rotates,
+		&key.Expires,
+	)
+	if err != nil {
+		return nil, err
+	}
+	key.ProjectID = projectID.String
+
+	return key, nil
+}
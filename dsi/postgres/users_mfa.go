@@ -0,0 +1,227 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const (
+	tableAppUserMFA                 = "app_user_mfa"
+	tableAppUserWebAuthnCredentials = "app_user_webauthn_credentials"
+)
+
+// GetUserMFA returns the multi-factor authentication of the user, which is empty if the user has not enrolled
+func (d *Database) GetUserMFA(userID string) (*models.UserMFA, error) {
+	mfa := &models.UserMFA{RecoveryCodes: []string{}, WebAuthn: []*models.WebAuthnCredential{}}
+
+	var recoveryCodes []byte
+	err := d.db.QueryRow(
+		fmt.Sprintf(
+			"SELECT totp_secret, totp_enabled, totp_last_step, recovery_codes FROM %s WHERE user_id=$1",
+			tableAppUserMFA,
+		),
+		userID,
+	).Scan(
+		&mfa.TOTPSecret,
+		&mfa.TOTPEnabled,
+		&mfa.TOTPLastStep,
+		&recoveryCodes,
+	)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if len(recoveryCodes) > 0 {
+		if err := json.Unmarshal(recoveryCodes, &mfa.RecoveryCodes); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := d.db.Query(
+		fmt.Sprintf(
+			"SELECT id, name, public_key, sign_count, created FROM %s WHERE user_id=$1 ORDER BY created",
+			tableAppUserWebAuthnCredentials,
+		),
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		credential := &models.WebAuthnCredential{}
+		var signCount int64
+		err := rows.Scan(
+			&credential.ID,
+			&credential.Name,
+			&credential.PublicKey,
+			&signCount,
+			&credential.Created,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credential.SignCount = uint32(signCount)
+		mfa.WebAuthn = append(mfa.WebAuthn, credential)
+	}
+
+	return mfa, rows.Err()
+}
+
+// SaveTOTPSecret sets the pending TOTP secret of the user, the secret of enabled TOTP is not replaced
+func (d *Database) SaveTOTPSecret(userID, secret string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"INSERT INTO %s (user_id, totp_secret, updated) VALUES ($1, $2, $3) ON CONFLICT (user_id) DO UPDATE SET totp_secret=EXCLUDED.totp_secret, updated=EXCLUDED.updated WHERE %s.totp_enabled=false",
+			tableAppUserMFA,
+			tableAppUserMFA,
+		),
+		userID,
+		secret,
+		time.Now(),
+	)
+
+	return err
+}
+
+// EnableTOTP enables the pending TOTP secret of the user, the step of the code which verified the secret is stored
+func (d *Database) EnableTOTP(userID string, step int64) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET totp_enabled=true, totp_last_step=$1, updated=$2 WHERE user_id=$3 and totp_secret<>''",
+			tableAppUserMFA,
+		),
+		step,
+		time.Now(),
+		userID,
+	)
+
+	return err
+}
+
+// DisableTOTP disables TOTP and removes the secret of the user
+func (d *Database) DisableTOTP(userID string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET totp_enabled=false, totp_secret='', totp_last_step=0, updated=$1 WHERE user_id=$2",
+			tableAppUserMFA,
+		),
+		time.Now(),
+		userID,
+	)
+
+	return err
+}
+
+// UseTOTPStep stores the time step of a TOTP code used by the user. False is returned if a code of the step, or a later
+// step, has already been used.
+func (d *Database) UseTOTPStep(userID string, step int64) (bool, error) {
+	res, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET totp_last_step=$1 WHERE user_id=$2 and totp_enabled=true and totp_last_step<$1",
+			tableAppUserMFA,
+		),
+		step,
+		userID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+// ReplaceRecoveryCodes replaces the recovery code hashes of the user
+func (d *Database) ReplaceRecoveryCodes(userID string, hashes []string) error {
+	if hashes == nil {
+		hashes = []string{}
+	}
+	codes, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(
+		fmt.Sprintf(
+			"INSERT INTO %s (user_id, recovery_codes, updated) VALUES ($1, $2, $3) ON CONFLICT (user_id) DO UPDATE SET recovery_codes=EXCLUDED.recovery_codes, updated=EXCLUDED.updated",
+			tableAppUserMFA,
+		),
+		userID,
+		codes,
+		time.Now(),
+	)
+
+	return err
+}
+
+// UseRecoveryCode removes the recovery code hash of the user. False is returned if the user does not have the code,
+// because it does not exist or has already been used.
+func (d *Database) UseRecoveryCode(userID, hash string) (bool, error) {
+	res, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET recovery_codes=recovery_codes-$1::text, updated=$2 WHERE user_id=$3 and recovery_codes ? $1::text",
+			tableAppUserMFA,
+		),
+		hash,
+		time.Now(),
+		userID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+// AddWebAuthnCredential saves a new WebAuthn credential of the user
+func (d *Database) AddWebAuthnCredential(userID string, credential *models.WebAuthnCredential) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"INSERT INTO %s (id, user_id, name, public_key, sign_count, created) VALUES ($1, $2, $3, $4, $5, $6)",
+			tableAppUserWebAuthnCredentials,
+		),
+		credential.ID,
+		userID,
+		credential.Name,
+		credential.PublicKey,
+		int64(credential.SignCount),
+		credential.Created,
+	)
+
+	return err
+}
+
+// UpdateWebAuthnSignCount updates the sign count of a WebAuthn credential of the user after it has been used
+func (d *Database) UpdateWebAuthnSignCount(userID, credentialID string, signCount uint32) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET sign_count=$1 WHERE id=$2 and user_id=$3",
+			tableAppUserWebAuthnCredentials,
+		),
+		int64(signCount),
+		credentialID,
+		userID,
+	)
+
+	return err
+}
+
+// DeleteWebAuthnCredential deletes a WebAuthn credential of the user
+func (d *Database) DeleteWebAuthnCredential(userID, credentialID string) error {
+	_, err := d.db.Exec(
+		fmt.Sprintf(
+			"DELETE FROM %s WHERE id=$1 and user_id=$2",
+			tableAppUserWebAuthnCredentials,
+		),
+		credentialID,
+		userID,
+	)
+
+	return err
+}
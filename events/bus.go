@@ -0,0 +1,69 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/config"
+)
+
+const (
+	// BusMemory is the in-process event bus, used for tests and single node deployments
+	BusMemory = "memory"
+	// BusRedis is the Redis list event bus, this is the default
+	BusRedis = "redis"
+	// BusRedisStreams is the Redis Streams event bus with consumer groups and acknowledgements
+	BusRedisStreams = "redis-streams"
+	// BusNATS is the NATS event bus
+	BusNATS = "nats"
+
+	// defaultBusGroup is the consumer group used when none is configured
+	defaultBusGroup = "machinable"
+)
+
+// ErrBusClosed is returned when consuming from an event bus that has been closed
+var ErrBusClosed = errors.New("event bus closed")
+
+// Message is a single message consumed from a queue of the event bus
+type Message struct {
+	ID    string
+	Queue string
+	Body  []byte
+}
+
+// EventBus publishes and consumes messages on named queues
+type EventBus interface {
+	// Publish appends the message body to the queue
+	Publish(queue string, body []byte) error
+	// Consume blocks until a message is available on the queue
+	Consume(queue string) (*Message, error)
+	// Ack acknowledges that the message has been processed. Unacknowledged messages are redelivered by backends that
+	// support acknowledgements.
+	Ack(msg *Message) error
+	// Close releases any resources held by the bus
+	Close() error
+}
+
+// NewBus creates the event bus configured by `config.EventBus`
+func NewBus(config *config.AppConfig, cache redis.UniversalClient) (EventBus, error) {
+	group := config.EventBusGroup
+	if group == "" {
+		group = defaultBusGroup
+	}
+
+	switch config.EventBus {
+	case "", BusRedis:
+		return NewRedisListBus(cache), nil
+	case BusRedisStreams:
+		consumer, _ := os.Hostname()
+		return NewRedisStreamBus(cache, group, consumer), nil
+	case BusNATS:
+		return NewNATSBus(config.NATSURL, group)
+	case BusMemory:
+		return NewMemoryBus(), nil
+	}
+
+	return nil, fmt.Errorf("unknown event bus '%s'", config.EventBus)
+}
@@ -0,0 +1,79 @@
+package events
+
+import (
+	"strconv"
+	"sync"
+)
+
+// MemoryBus is an in-process `EventBus` for tests and single node deployments. Messages are lost when the process
+// exits, and only consumers in the same process, such as the hook result processor, receive them.
+type MemoryBus struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues map[string][]*Message
+	seq    int64
+	closed bool
+}
+
+// NewMemoryBus creates and returns a new instance of `MemoryBus`
+func NewMemoryBus() *MemoryBus {
+	bus := &MemoryBus{
+		queues: make(map[string][]*Message),
+	}
+	bus.cond = sync.NewCond(&bus.mu)
+	return bus
+}
+
+// Publish appends the message body to the queue
+func (b *MemoryBus) Publish(queue string, body []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrBusClosed
+	}
+
+	b.seq++
+	b.queues[queue] = append(b.queues[queue], &Message{
+		ID:    strconv.FormatInt(b.seq, 10),
+		Queue: queue,
+		Body:  body,
+	})
+	b.cond.Broadcast()
+
+	return nil
+}
+
+// Consume blocks until a message is available on the queue
+func (b *MemoryBus) Consume(queue string) (*Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.queues[queue]) == 0 {
+		if b.closed {
+			return nil, ErrBusClosed
+		}
+		b.cond.Wait()
+	}
+
+	msg := b.queues[queue][0]
+	b.queues[queue] = b.queues[queue][1:]
+
+	return msg, nil
+}
+
+// Ack is a no-op, messages are removed from the queue when consumed
+func (b *MemoryBus) Ack(msg *Message) error {
+	return nil
+}
+
+// Close wakes up any blocked consumers, which will return `ErrBusClosed`
+func (b *MemoryBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.cond.Broadcast()
+
+	return nil
+}
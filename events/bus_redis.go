@@ -0,0 +1,261 @@
+package events
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	// redisBlock is how long a single blocking read waits before it is retried
+	redisBlock = 5 * time.Second
+	// redisStreamMaxLen caps the length of each stream, acknowledged messages are trimmed first
+	redisStreamMaxLen = 100000
+	// redisStreamBodyField is the stream entry field containing the message body
+	redisStreamBodyField = "body"
+	// redisClaimIdle is how long a message stays pending before it is redelivered, which also delays retries of
+	// messages that failed to be processed
+	redisClaimIdle = time.Minute
+	// redisClaimInterval is how often a consumer checks the pending messages of the group
+	redisClaimInterval = 10 * time.Second
+	// redisClaimCount is the number of pending messages checked at once
+	redisClaimCount = 100
+	// redisMaxDeliveries is the number of deliveries after which a message is moved to the dead letter stream
+	redisMaxDeliveries = 5
+)
+
+// RedisListBus is an `EventBus` backed by Redis lists. Messages are removed from the list when they are consumed, so
+// they are lost if the consumer fails before processing them.
+type RedisListBus struct {
+	cache redis.UniversalClient
+}
+
+// NewRedisListBus creates and returns a new instance of `RedisListBus`
+func NewRedisListBus(cache redis.UniversalClient) *RedisListBus {
+	return &RedisListBus{cache: cache}
+}
+
+// Publish appends the message body to the list
+func (b *RedisListBus) Publish(queue string, body []byte) error {
+	return b.cache.RPush(queue, body).Err()
+}
+
+// Consume blocks until a message is available on the list
+func (b *RedisListBus) Consume(queue string) (*Message, error) {
+	for {
+		result, err := b.cache.BLPop(redisBlock, queue).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &Message{Queue: queue, Body: []byte(result[1])}, nil
+	}
+}
+
+// Ack is a no-op, list items can not be acknowledged
+func (b *RedisListBus) Ack(msg *Message) error {
+	return nil
+}
+
+// Close is a no-op, the redis client is owned by the caller
+func (b *RedisListBus) Close() error {
+	return nil
+}
+
+// RedisStreamBus is an `EventBus` backed by Redis Streams. Each queue is a stream read by a consumer group, messages
+// stay pending until they are acknowledged. Messages left pending for `redisClaimIdle`, because processing failed or the
+// consumer crashed, are claimed and redelivered to a consumer of the group. After `redisMaxDeliveries` deliveries a
+// message is moved to the `{queue}:dead` stream.
+type RedisStreamBus struct {
+	cache    redis.UniversalClient
+	group    string
+	consumer string
+
+	mu      sync.Mutex
+	groups  map[string]bool
+	claimed map[string]time.Time
+}
+
+// NewRedisStreamBus creates and returns a new instance of `RedisStreamBus`. `consumer` must be unique within the group.
+func NewRedisStreamBus(cache redis.UniversalClient, group, consumer string) *RedisStreamBus {
+	return &RedisStreamBus{
+		cache:    cache,
+		group:    group,
+		consumer: consumer,
+		groups:   make(map[string]bool),
+		claimed:  make(map[string]time.Time),
+	}
+}
+
+// DeadLetterQueue returns the stream of the messages of `queue` that exceeded the maximum number of deliveries
+func DeadLetterQueue(queue string) string {
+	return queue + ":dead"
+}
+
+// Publish adds the message body to the stream
+func (b *RedisStreamBus) Publish(queue string, body []byte) error {
+	return b.cache.XAdd(&redis.XAddArgs{
+		Stream:       queue,
+		MaxLenApprox: redisStreamMaxLen,
+		Values:       map[string]interface{}{redisStreamBodyField: body},
+	}).Err()
+}
+
+// Consume blocks until a message is available on the stream. Pending messages of the group that have been idle for
+// `redisClaimIdle` are claimed and returned before new messages, the pending messages are checked at most every
+// `redisClaimInterval`.
+func (b *RedisStreamBus) Consume(queue string) (*Message, error) {
+	if err := b.ensureGroup(queue); err != nil {
+		return nil, err
+	}
+
+	for {
+		b.mu.Lock()
+		claim := time.Since(b.claimed[queue]) >= redisClaimInterval
+		b.mu.Unlock()
+
+		if claim {
+			msg, err := b.claim(queue)
+			if err != nil {
+				return nil, err
+			}
+			if msg != nil {
+				return msg, nil
+			}
+
+			b.mu.Lock()
+			b.claimed[queue] = time.Now()
+			b.mu.Unlock()
+		}
+
+		streams, err := b.cache.XReadGroup(&redis.XReadGroupArgs{
+			Group:    b.group,
+			Consumer: b.consumer,
+			Streams:  []string{queue, ">"},
+			Count:    1,
+			Block:    redisBlock,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				return streamMessage(queue, msg), nil
+			}
+		}
+	}
+}
+
+// claim returns the first pending message of the group which has been idle for `redisClaimIdle`, after claiming it for
+// this consumer. Messages that have been delivered `redisMaxDeliveries` times are moved to the dead letter stream.
+func (b *RedisStreamBus) claim(queue string) (*Message, error) {
+	pending, err := b.cache.XPendingExt(&redis.XPendingExtArgs{
+		Stream: queue,
+		Group:  b.group,
+		Start:  "-",
+		End:    "+",
+		Count:  redisClaimCount,
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for _, entry := range pending {
+		if entry.Idle < redisClaimIdle {
+			continue
+		}
+
+		if entry.RetryCount >= redisMaxDeliveries {
+			if err := b.deadLetter(queue, entry); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// another consumer may claim the message first, it is then no longer idle and is not returned
+		messages, err := b.cache.XClaim(&redis.XClaimArgs{
+			Stream:   queue,
+			Group:    b.group,
+			Consumer: b.consumer,
+			MinIdle:  redisClaimIdle,
+			Messages: []string{entry.Id},
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+
+		for _, msg := range messages {
+			return streamMessage(queue, msg), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// deadLetter moves the pending message to the dead letter stream of the queue and acknowledges it
+func (b *RedisStreamBus) deadLetter(queue string, entry redis.XPendingExt) error {
+	messages, err := b.cache.XRangeN(queue, entry.Id, entry.Id, 1).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	// the message may have been trimmed from the stream
+	for _, msg := range messages {
+		err := b.cache.XAdd(&redis.XAddArgs{
+			Stream:       DeadLetterQueue(queue),
+			MaxLenApprox: redisStreamMaxLen,
+			Values: map[string]interface{}{
+				redisStreamBodyField: msg.Values[redisStreamBodyField],
+				"id":                 entry.Id,
+				"deliveries":         entry.RetryCount,
+			},
+		}).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return b.cache.XAck(queue, b.group, entry.Id).Err()
+}
+
+func streamMessage(queue string, msg redis.XMessage) *Message {
+	body, _ := msg.Values[redisStreamBodyField].(string)
+	return &Message{ID: msg.ID, Queue: queue, Body: []byte(body)}
+}
+
+// Ack acknowledges the message so it is no longer pending for the consumer group
+func (b *RedisStreamBus) Ack(msg *Message) error {
+	return b.cache.XAck(msg.Queue, b.group, msg.ID).Err()
+}
+
+// Close is a no-op, the redis client is owned by the caller
+func (b *RedisStreamBus) Close() error {
+	return nil
+}
+
+func (b *RedisStreamBus) ensureGroup(queue string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.groups[queue] {
+		return nil
+	}
+
+	err := b.cache.XGroupCreateMkStream(queue, b.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group for '%s': %s", queue, err)
+	}
+
+	b.groups[queue] = true
+	return nil
+}
@@ -0,0 +1,257 @@
+package events
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBus(t *testing.T) {
+	bus := NewMemoryBus()
+
+	assert.Nil(t, bus.Publish(QueueHooks, []byte("one")))
+	assert.Nil(t, bus.Publish(QueueHooks, []byte("two")))
+	assert.Nil(t, bus.Publish(QueueHookResults, []byte("result")))
+
+	msg, err := bus.Consume(QueueHooks)
+	assert.Nil(t, err)
+	assert.Equal(t, "one", string(msg.Body))
+	assert.Equal(t, QueueHooks, msg.Queue)
+
+	msg, err = bus.Consume(QueueHooks)
+	assert.Nil(t, err)
+	assert.Equal(t, "two", string(msg.Body))
+
+	msg, err = bus.Consume(QueueHookResults)
+	assert.Nil(t, err)
+	assert.Equal(t, "result", string(msg.Body))
+
+	// blocked consumers are released on close
+	done := make(chan error)
+	go func() {
+		_, err := bus.Consume(QueueHooks)
+		done <- err
+	}()
+
+	bus.Close()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, ErrBusClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("consumer was not released")
+	}
+
+	assert.Equal(t, ErrBusClosed, bus.Publish(QueueHooks, []byte("three")))
+}
+
+// dialPipes returns a dialer which returns the connections in order, further dials fail
+func dialPipes(conns ...net.Conn) func() (net.Conn, error) {
+	var mu sync.Mutex
+	return func() (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(conns) == 0 {
+			return nil, errors.New("connection refused")
+		}
+		conn := conns[0]
+		conns = conns[1:]
+		return conn, nil
+	}
+}
+
+func TestNATSBus(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	// minimal NATS server, echoes published messages back to the subscription
+	go func() {
+		fmt.Fprint(server, "INFO {\"server_id\":\"test\"}\r\n")
+
+		reader := bufio.NewReader(server)
+		subs := make(map[string]string)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+
+			switch fields[0] {
+			case "SUB":
+				// SUB <subject> <queue group> <sid>
+				subs[fields[1]] = fields[3]
+				fmt.Fprint(server, "PING\r\n")
+			case "PUB":
+				payload, _ := reader.ReadString('\n')
+				fmt.Fprintf(server, "MSG %s %s %s\r\n%s", fields[1], subs[fields[1]], fields[2], payload)
+			}
+		}
+	}()
+
+	bus, err := newNATSBus(dialPipes(client), "machinable", nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	done := make(chan *Message)
+	go func() {
+		msg, err := bus.Consume(QueueHookResults)
+		assert.Nil(t, err)
+		done <- msg
+	}()
+
+	// wait for the subscription before publishing
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, bus.Publish(QueueHookResults, []byte("{\"status_code\":200}")))
+
+	select {
+	case msg := <-done:
+		assert.Equal(t, QueueHookResults, msg.Queue)
+		assert.Equal(t, "{\"status_code\":200}", string(msg.Body))
+	case <-time.After(time.Second):
+		t.Fatal("message was not received")
+	}
+
+	bus.Close()
+	_, err = bus.Consume(QueueHookResults)
+	assert.Equal(t, ErrBusClosed, err)
+}
+
+func TestNATSBusSlowConsumer(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	pong := make(chan bool)
+	go func() {
+		fmt.Fprint(server, "INFO {\"server_id\":\"test\"}\r\n")
+
+		reader := bufio.NewReader(server)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+
+			switch fields[0] {
+			case "SUB":
+				// more messages than the subscription buffers, followed by a ping
+				go func(sid string) {
+					for i := 0; i < natsBuffer*2; i++ {
+						fmt.Fprintf(server, "MSG %s %s 2\r\n{}\r\n", fields[1], sid)
+					}
+					fmt.Fprint(server, "PING\r\n")
+				}(fields[3])
+			case "PONG":
+				pong <- true
+			}
+		}
+	}()
+
+	bus, err := newNATSBus(dialPipes(client), "machinable", nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer bus.Close()
+
+	// a single message is consumed, the others are not
+	msg, err := bus.Consume(QueueHookResults)
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(msg.Body))
+
+	select {
+	case <-pong:
+	case <-time.After(time.Second):
+		t.Fatal("ping was not answered")
+	}
+}
+
+func TestNATSBusReconnect(t *testing.T) {
+	first, firstServer := net.Pipe()
+	second, secondServer := net.Pipe()
+	defer secondServer.Close()
+
+	// the first server drops the connection once the consumer has subscribed
+	go func() {
+		fmt.Fprint(firstServer, "INFO {\"server_id\":\"test\"}\r\n")
+
+		reader := bufio.NewReader(firstServer)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "SUB") {
+				firstServer.Close()
+				return
+			}
+		}
+	}()
+
+	// the second server delivers a message to the subscription sent after the reconnect
+	subscribed := make(chan string, 1)
+	go func() {
+		fmt.Fprint(secondServer, "INFO {\"server_id\":\"test\"}\r\n")
+
+		reader := bufio.NewReader(secondServer)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			if fields[0] == "SUB" {
+				subscribed <- line
+				fmt.Fprintf(secondServer, "MSG %s %s 2\r\n{}\r\n", fields[1], fields[3])
+			}
+		}
+	}()
+
+	bus, err := newNATSBus(dialPipes(first, second), "machinable", nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer bus.Close()
+
+	done := make(chan *Message)
+	go func() {
+		msg, err := bus.Consume(QueueHookResults)
+		assert.Nil(t, err)
+		done <- msg
+	}()
+
+	select {
+	case msg := <-done:
+		assert.Equal(t, "{}", string(msg.Body))
+	case <-time.After(2 * time.Second):
+		t.Fatal("message was not received after reconnecting")
+	}
+	assert.Equal(t, "SUB "+QueueHookResults+" machinable 1\r\n", <-subscribed)
+}
+
+func TestNATSSubscriptionMaxPending(t *testing.T) {
+	sub := newNATSSubscription(QueueHookResults, 2)
+
+	// the worker is not running, so messages are only queued
+	for i := 0; i < 5; i++ {
+		sub.push(&Message{Queue: QueueHookResults, Body: []byte(fmt.Sprint(i))})
+	}
+	assert.Len(t, sub.pending, 2)
+	assert.Equal(t, 3, sub.dropped)
+
+	go sub.run()
+	assert.Equal(t, "0", string((<-sub.ch).Body))
+	assert.Equal(t, "1", string((<-sub.ch).Body))
+
+	sub.close()
+	_, ok := <-sub.ch
+	assert.False(t, ok)
+}
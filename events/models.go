@@ -3,9 +3,9 @@ package events
 import "github.com/machinable/machinable/dsi/models"
 
 const (
-	// QueueHooks is the redis queue for web hooks
+	// QueueHooks is the event bus queue for web hooks
 	QueueHooks = "hook_queue"
-	// QueueHookResults is the redis queue for web hook result messages
+	// QueueHookResults is the event bus queue for web hook result messages
 	QueueHookResults = "hook_result_queue"
 	// QueueEmailNotifications process email send
 	QueueEmailNotifications = "email_notifications_queue"
@@ -13,6 +13,7 @@ const (
 
 // Event defines the event(s) to be processed
 type Event struct {
+	ID        string                `json:"id"`
 	Project   *models.ProjectDetail `json:"project"`
 	Entity    string                `json:"entity"` // resource, json
 	EntityKey string                `json:"entity_key"`
@@ -24,6 +25,7 @@ type Event struct {
 
 // HookEvent describes a single web hook event
 type HookEvent struct {
+	ID        string          `json:"id"` // unique per event and hook, hooks are delivered at least once
 	Hook      *models.WebHook `json:"hook"`
 	EntityKey string          `json:"entity_key"`
 	Payload   interface{}     `json:"payload"`
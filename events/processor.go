@@ -3,59 +3,76 @@ package events
 import (
 	"encoding/json"
 	"log"
+	"time"
 
-	"github.com/go-redis/redis"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/dsi/models"
 )
 
+// consumeRetry is how long to wait before consuming again after a read error
+const consumeRetry = time.Second
+
 // Processor process and emits events for web hooks and websockets
 type Processor struct {
-	cache redis.UniversalClient
+	bus   EventBus
 	store interfaces.ProjectHooksDatastore
 }
 
-// NewProcessor creates and returns a new instance of `Processor` with the given redis client
-func NewProcessor(cache redis.UniversalClient, store interfaces.ProjectHooksDatastore) *Processor {
+// NewProcessor creates and returns a new instance of `Processor` with the given event bus
+func NewProcessor(bus EventBus, store interfaces.ProjectHooksDatastore) *Processor {
 	return &Processor{
-		cache: cache,
+		bus:   bus,
 		store: store,
 	}
 }
 
-// ProcessResults listens for web hook results on the redis queue. This function should be run as a goroutine.
-func (p *Processor) ProcessResults() error {
+// ProcessResults listens for web hook results on the event bus. This function should be run as a goroutine, it returns
+// once the event bus has been closed.
+func (p *Processor) ProcessResults() {
 	for {
-		// endlessly read from queue
-		result, err := p.cache.BLPop(0, QueueHookResults).Result()
-
-		// exit on a read error
+		msg, err := p.bus.Consume(QueueHookResults)
+		if err == ErrBusClosed {
+			return
+		}
+		// retry on a read error
 		if err != nil {
 			log.Println(err)
-			return err
+			time.Sleep(consumeRetry)
+			continue
 		}
 
-		// unmarshal event
+		// unmarshal event, malformed results are acknowledged so they are not redelivered
 		hookResult := &models.HookResult{}
-		if err := json.Unmarshal([]byte(result[1]), hookResult); err != nil {
+		if err := json.Unmarshal(msg.Body, hookResult); err != nil {
 			log.Println(err)
+			p.ack(msg)
 			continue
 		}
 
-		// save hook result
+		// save hook result, leave unacknowledged on failure so it is redelivered
 		if err := p.store.AddResult(hookResult); err != nil {
 			log.Println(err)
 			continue
 		}
+
+		p.ack(msg)
+	}
+}
+
+func (p *Processor) ack(msg *Message) {
+	if err := p.bus.Ack(msg); err != nil {
+		log.Println(err)
 	}
 }
 
-// PushEvent processes and emits an event
+// PushEvent processes and emits an event, returns the last error encountered while publishing to the hook queue
 func (p *Processor) PushEvent(e *Event) error {
+	var pushErr error
 	hooks := e.Project.Hooks
 	for _, hook := range hooks {
-		// emit event to redis for the event action
-		if hook.HookEvent == e.Action &&
+		// emit event to the bus for the event action
+		if !hook.IsBefore() &&
+			hook.HookEvent == e.Action &&
 			hook.Entity == e.Entity &&
 			hook.EntityID == e.EntityID &&
 			hook.IsEnabled == true {
@@ -78,6 +95,7 @@ func (p *Processor) PushEvent(e *Event) error {
 				hookEvent.Hook = hook
 				hookEvent.Payload = payload
 			}
+			hookEvent.ID = e.ID + ":" + hook.ID
 			hookEvent.EntityKey = e.EntityKey
 
 			b, merr := json.Marshal(hookEvent)
@@ -85,10 +103,11 @@ func (p *Processor) PushEvent(e *Event) error {
 				log.Println(merr)
 				continue
 			}
-			if err := p.cache.RPush(QueueHooks, b).Err(); err != nil {
+			if err := p.bus.Publish(QueueHooks, b); err != nil {
 				log.Println(err)
+				pushErr = err
 			}
 		}
 	}
-	return nil
+	return pushErr
 }
@@ -0,0 +1,114 @@
+package events
+
+import (
+	"log"
+	"time"
+
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const (
+	// relayBatchSize is the maximum number of outbox events claimed at once
+	relayBatchSize = 100
+	// relayLease is how long claimed events are locked before they can be claimed again
+	relayLease = 30 * time.Second
+)
+
+// Relay publishes committed outbox events to the hook queue
+type Relay struct {
+	store     interfaces.Datastore
+	processor *Processor
+}
+
+// NewRelay creates and returns a new instance of `Relay`
+func NewRelay(store interfaces.Datastore, processor *Processor) *Relay {
+	return &Relay{
+		store:     store,
+		processor: processor,
+	}
+}
+
+// Run relays pending outbox events every `interval`. This function should be run as a goroutine.
+func (r *Relay) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// drain the outbox before waiting for the next tick
+		for {
+			relayed, err := r.RelayPending()
+			if err != nil {
+				log.Println(err)
+				break
+			}
+			if relayed < relayBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// RelayPending claims a batch of pending outbox events and pushes them to the hook queue. Events that fail to be
+// pushed are left unpublished and will be retried once their lease expires, events that have exhausted their attempts
+// are dead lettered instead. Returns the number of claimed events.
+func (r *Relay) RelayPending() (int, error) {
+	deadLettered, dErr := r.store.DeadLetterOutboxEvents()
+	if dErr != nil {
+		return 0, dErr
+	}
+	if deadLettered > 0 {
+		log.Printf("dead lettered %d outbox events", deadLettered)
+	}
+
+	outbox, err := r.store.ClaimOutboxEvents(relayBatchSize, relayLease)
+	if err != nil {
+		return 0, err
+	}
+
+	// cache hooks per project for this batch
+	projectHooks := make(map[string][]*models.WebHook)
+	for _, event := range outbox {
+		hooks, ok := projectHooks[event.ProjectID]
+		if !ok {
+			var hErr error
+			hooks, hErr = r.listHooks(event.ProjectID)
+			if hErr != nil {
+				log.Println(hErr)
+				continue
+			}
+			projectHooks[event.ProjectID] = hooks
+		}
+
+		pushErr := r.processor.PushEvent(
+			&Event{
+				ID:        event.ID,
+				Project:   &models.ProjectDetail{ID: event.ProjectID, Hooks: hooks},
+				Entity:    event.Entity,
+				EntityKey: event.EntityKey,
+				EntityID:  event.EntityID,
+				Action:    event.Action,
+				Keys:      event.Keys,
+				Payload:   event.Payload,
+			},
+		)
+		if pushErr != nil {
+			log.Printf("failed to relay outbox event %s, attempt %d of %d: %v", event.ID, event.Attempts, event.MaxAttempts, pushErr)
+			continue
+		}
+
+		if mErr := r.store.MarkOutboxEventPublished(event.ID); mErr != nil {
+			log.Println(mErr)
+		}
+	}
+
+	return len(outbox), nil
+}
+
+func (r *Relay) listHooks(projectID string) ([]*models.WebHook, error) {
+	hooks, err := r.store.ListHooks(projectID)
+	if err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
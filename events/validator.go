@@ -0,0 +1,255 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// maxHookResponse is the maximum size of a "before" hook response body
+const maxHookResponse = 1 << 20
+
+// ErrInvalidDocument is returned when a "before" hook of a document write returns a payload which is not a document
+var ErrInvalidDocument = errors.New("validation hook returned an invalid document")
+
+// HookError is returned when a "before" hook rejects a write, or fails and is not configured to fail open
+type HookError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HookError) Error() string {
+	return e.Message
+}
+
+// HookResponse is the response body of a "before" hook. An empty response allows the write unchanged.
+type HookResponse struct {
+	Reject bool            `json:"reject"`
+	Error  string          `json:"error"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Validator calls "before" hooks synchronously, allowing the receiver to reject or modify a write
+type Validator struct {
+	client *http.Client
+	store  interfaces.ProjectHooksDatastore
+}
+
+// NewValidator creates and returns a new instance of `Validator`. Hook results are saved to the store.
+func NewValidator(store interfaces.ProjectHooksDatastore) *Validator {
+	return &Validator{
+		client: &http.Client{},
+		store:  store,
+	}
+}
+
+// Validate calls each enabled "before" hook of the project matching the event, in order. The payload returned by a hook
+// is passed on to the next hook, the final payload is returned to be written. A `*HookError` is returned if the write
+// is rejected.
+func (v *Validator) Validate(e *Event) ([]byte, error) {
+	payload := e.Payload
+	for _, hook := range e.Project.Hooks {
+		if !hook.IsBefore() ||
+			!hook.IsEnabled ||
+			hook.HookEvent != e.Action ||
+			hook.Entity != e.Entity ||
+			hook.EntityID != e.EntityID {
+			continue
+		}
+
+		modified, err := v.call(hook, e, payload)
+		if err != nil {
+			return nil, err
+		}
+		if modified != nil {
+			payload = modified
+		}
+	}
+
+	return payload, nil
+}
+
+// ValidateDocument calls the "before" hooks of the event with the document as the payload, returning the document to be
+// written. `ErrInvalidDocument` is returned if a hook returned a payload which is not a document, the document is
+// returned unchanged by a `nil` validator.
+func (v *Validator) ValidateDocument(e *Event, document models.ResourceObject) (models.ResourceObject, error) {
+	if v == nil {
+		return document, nil
+	}
+
+	payload, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	e.Payload = payload
+
+	validated, err := v.Validate(e)
+	if err != nil {
+		return nil, err
+	}
+
+	result := models.ResourceObject{}
+	if err := json.Unmarshal(validated, &result); err != nil {
+		return nil, ErrInvalidDocument
+	}
+
+	return result, nil
+}
+
+// call sends the event to a single hook, returning the modified payload if the hook returned one
+func (v *Validator) call(hook *models.WebHook, e *Event, payload []byte) ([]byte, error) {
+	var data interface{}
+	json.Unmarshal(payload, &data)
+
+	hookEvent := &HookEvent{
+		Hook:      hook,
+		EntityKey: e.EntityKey,
+		Payload:   data,
+	}
+	if hook.Entity == models.EndpointJSON {
+		hookEvent.Payload = map[string]interface{}{
+			"data": data,
+			"keys": e.Keys,
+		}
+	}
+
+	body, err := json.Marshal(hookEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(hook.TimeoutMS) * time.Millisecond
+	if timeout <= 0 {
+		timeout = models.DefaultHookTimeoutMS * time.Millisecond
+	}
+
+	start := time.Now()
+	statusCode, response, err := v.post(hook, body, timeout)
+	v.saveResult(hook, statusCode, time.Since(start), err)
+
+	// the hook could not be reached or failed
+	if err != nil || statusCode >= http.StatusInternalServerError {
+		if hook.FailOpen {
+			return nil, nil
+		}
+		return nil, &HookError{
+			StatusCode: http.StatusBadGateway,
+			Message:    fmt.Sprintf("validation hook '%s' failed", hook.Label),
+		}
+	}
+
+	hookResponse := &HookResponse{}
+	if len(bytes.TrimSpace(response)) > 0 {
+		if err := json.Unmarshal(response, hookResponse); err != nil && statusCode < http.StatusBadRequest {
+			if hook.FailOpen {
+				return nil, nil
+			}
+			return nil, &HookError{
+				StatusCode: http.StatusBadGateway,
+				Message:    fmt.Sprintf("validation hook '%s' returned an invalid response", hook.Label),
+			}
+		}
+	}
+
+	// the hook rejected the write
+	if hookResponse.Reject || statusCode >= http.StatusBadRequest {
+		message := hookResponse.Error
+		if message == "" {
+			message = fmt.Sprintf("rejected by validation hook '%s'", hook.Label)
+		}
+		return nil, &HookError{
+			StatusCode: http.StatusBadRequest,
+			Message:    message,
+		}
+	}
+
+	if len(hookResponse.Data) > 0 && e.Action != models.ActionDelete {
+		return hookResponse.Data, nil
+	}
+
+	return nil, nil
+}
+
+func (v *Validator) post(hook *models.WebHook, body []byte, timeout time.Duration) (int, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, hook.HookURL, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range hookHeaders(hook) {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	response, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHookResponse))
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+
+	return resp.StatusCode, response, nil
+}
+
+func (v *Validator) saveResult(hook *models.WebHook, statusCode int, responseTime time.Duration, err error) {
+	if v.store == nil {
+		return
+	}
+
+	result := &models.HookResult{
+		WebHookID:    hook.ID,
+		ProjectID:    hook.ProjectID,
+		StatusCode:   statusCode,
+		ResponseTime: int64(responseTime / time.Millisecond),
+	}
+	if err != nil {
+		result.StatusCode = -1
+		result.ErrorMessage = err.Error()
+	}
+
+	// save in go routine, do not block the write
+	go func() {
+		if err := v.store.AddResult(result); err != nil {
+			log.Println(err)
+		}
+	}()
+}
+
+// hookHeaders returns the configured request headers of the hook, stored as a list of `{"key": "", "value": ""}` pairs
+func hookHeaders(hook *models.WebHook) map[string]string {
+	headers := make(map[string]string)
+
+	pairs := []map[string]string{}
+	if err := json.Unmarshal(hook.Headers, &pairs); err != nil {
+		return headers
+	}
+
+	for _, pair := range pairs {
+		if key, ok := pair["key"]; ok {
+			headers[key] = pair["value"]
+			continue
+		}
+		for key, value := range pair {
+			headers[key] = value
+		}
+	}
+
+	return headers
+}
@@ -0,0 +1,106 @@
+package events
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/allow":
+		case "/reject":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "name is reserved"}`))
+		case "/modify":
+			w.Write([]byte(`{"data": {"name": "modified"}}`))
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	tables := []struct {
+		name       string
+		path       string
+		failOpen   bool
+		payload    string
+		statusCode int
+	}{
+		{"allow", "/allow", false, `{"name": "original"}`, 0},
+		{"reject", "/reject", false, "", http.StatusBadRequest},
+		{"modify", "/modify", false, `{"name": "modified"}`, 0},
+		{"fail closed", "/fail", false, "", http.StatusBadGateway},
+		{"fail open", "/fail", true, `{"name": "original"}`, 0},
+		{"timeout closed", "/slow", false, "", http.StatusBadGateway},
+		{"timeout open", "/slow", true, `{"name": "original"}`, 0},
+	}
+
+	validator := NewValidator(nil)
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &models.WebHook{
+				Label:     tt.name,
+				IsEnabled: true,
+				Entity:    models.EndpointResource,
+				EntityID:  "definition",
+				HookEvent: models.ActionCreate,
+				HookURL:   server.URL + tt.path,
+				Headers:   []byte(`[{"key": "X-Secret", "value": "secret"}]`),
+				Phase:     models.HookPhaseBefore,
+				TimeoutMS: 50,
+				FailOpen:  tt.failOpen,
+			}
+			event := &Event{
+				Project:  &models.ProjectDetail{Hooks: []*models.WebHook{hook}},
+				Entity:   models.EndpointResource,
+				EntityID: "definition",
+				Action:   models.ActionCreate,
+				Payload:  []byte(`{"name": "original"}`),
+			}
+
+			payload, err := validator.Validate(event)
+			if tt.statusCode != 0 {
+				hErr, ok := err.(*HookError)
+				if assert.True(t, ok) {
+					assert.Equal(t, tt.statusCode, hErr.StatusCode)
+				}
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.JSONEq(t, tt.payload, string(payload))
+		})
+	}
+}
+
+func TestValidateIgnoresAfterHooks(t *testing.T) {
+	event := &Event{
+		Project: &models.ProjectDetail{Hooks: []*models.WebHook{
+			{
+				IsEnabled: true,
+				Entity:    models.EndpointJSON,
+				EntityID:  "root",
+				HookEvent: models.ActionEdit,
+				HookURL:   "http://localhost:0/unreachable",
+				Phase:     models.HookPhaseAfter,
+			},
+		}},
+		Entity:   models.EndpointJSON,
+		EntityID: "root",
+		Action:   models.ActionEdit,
+		Payload:  []byte(`{"a": 1}`),
+	}
+
+	payload, err := NewValidator(nil).Validate(event)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a": 1}`, string(payload))
+}
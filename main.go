@@ -7,13 +7,16 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/auth"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/postgres"
 	"github.com/machinable/machinable/events"
 	"github.com/machinable/machinable/management"
 	"github.com/machinable/machinable/projects"
+	"github.com/machinable/machinable/scheduler"
 )
 
 // HostSwitch is used to switch routers based on sub domain
@@ -56,6 +59,17 @@ func main() {
 	// some values from environment
 	config.LoadEnv()
 
+	// signing keys are encrypted at rest and api keys are hashed with their own secret
+	if _, err := auth.KeyEncryptionKey(config); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := auth.JWTAlgorithm(config); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := auth.APIKeySecret(config); err != nil {
+		log.Fatal(err)
+	}
+
 	// use postgres client
 	datastore, err := postgres.New(
 		os.Getenv("POSTGRES_USER"),
@@ -83,23 +97,37 @@ func main() {
 		log.Fatal(pong, err)
 	}
 
+	// create event bus
+	bus, err := events.NewBus(config, cache)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bus.Close()
+
 	// create event processor
-	processor := events.NewProcessor(cache, datastore)
+	processor := events.NewProcessor(bus, datastore)
 
 	// process web hook results
-	go func() {
-		err := processor.ProcessResults()
-		// fail out
-		log.Fatal(err)
-	}()
+	go processor.ProcessResults()
+
+	// relay committed outbox events to the web hook queue
+	relay := events.NewRelay(datastore, processor)
+	go relay.Run(time.Second)
+
+	// expire documents and purge old logs, sessions and hook results
+	jobs := scheduler.New()
+	jobs.Every(time.Minute, "expire documents", scheduler.ExpireDocuments(datastore))
+	jobs.Every(10*time.Minute, "purge retention", scheduler.PurgeRetention(datastore))
+	jobs.Start()
+	defer jobs.Stop()
 
 	// switch routers based on subdomain
 	hostSwitch := make(HostSwitch)
 
 	// manage is for the management application api, i.e. project/team management
-	hostSwitch["manage"] = management.CreateRoutes(datastore, cache, config)
+	hostSwitch["manage"] = management.CreateRoutes(datastore, cache, bus, config)
 	// all other subdomains will be treated as project names, and use the project routes
-	hostSwitch["*"] = projects.CreateRoutes(datastore, cache, processor, config)
+	hostSwitch["*"] = projects.CreateRoutes(datastore, cache, bus, config)
 
 	log.Fatal(http.ListenAndServe(":5001", hostSwitch))
 }
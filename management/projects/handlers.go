@@ -4,8 +4,10 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/middleware"
 )
 
 // New returns a pointer to a new `Projects`
@@ -20,7 +22,8 @@ type Projects struct {
 	store interfaces.Datastore
 }
 
-// UpdateProject updates the project settings, specifically the authn value
+// UpdateProject updates the project settings, including user registration, the session token lifetimes and the MFA
+// requirement of app users
 func (p *Projects) UpdateProject(c *gin.Context) {
 	projectSlug := c.Param("projectSlug")
 	userID := c.MustGet("user_id").(string)
@@ -28,19 +31,45 @@ func (p *Projects) UpdateProject(c *gin.Context) {
 	var updatedProject ProjectBody
 	c.BindJSON(&updatedProject)
 
+	if err := updatedProject.ValidateLifetimes(); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// the MFA requirement can only be changed by app users logged in with a second factor, so it cannot be removed
+	// with a stolen password and users cannot lock themselves out. Removing it requires the factor was used recently,
+	// so it cannot be removed with a stolen session either.
+	current, err := p.store.GetProjectBySlugAndUserID(projectSlug, userID)
+	if err != nil {
+		apierror.Respond(c, http.StatusNotFound, "project does not exist")
+		return
+	}
+	if (current.MFARequired || updatedProject.MFARequired) && !c.GetBool("mfa") {
+		apierror.Respond(c, http.StatusForbidden, "project requires multi-factor authentication")
+		return
+	}
+	if current.MFARequired && !updatedProject.MFARequired && !middleware.RecentMFA(c) {
+		apierror.Respond(c, http.StatusForbidden, "recent multi-factor authentication required")
+		return
+	}
+
 	project, err := p.store.UpdateProject(
 		projectSlug,
 		userID,
 		&models.Project{
-			Name:             updatedProject.Name,
-			Description:      updatedProject.Description,
-			Icon:             updatedProject.Icon,
-			UserRegistration: updatedProject.UserRegistration,
+			Name:                 updatedProject.Name,
+			Description:          updatedProject.Description,
+			Icon:                 updatedProject.Icon,
+			UserRegistration:     updatedProject.UserRegistration,
+			UserVerification:     updatedProject.UserVerification,
+			AccessTokenLifetime:  updatedProject.AccessTokenLifetime,
+			RefreshTokenLifetime: updatedProject.RefreshTokenLifetime,
+			MFARequired:          updatedProject.MFARequired,
 		},
 	)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -61,19 +90,19 @@ func (p *Projects) CreateProject(c *gin.Context) {
 
 	// validate project
 	if err := newProject.Validate(); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// check for reserved slug
 	if newProject.ReservedSlug() {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "project slug is already in use"})
+		apierror.Respond(c, http.StatusBadRequest, "project slug is already in use")
 		return
 	}
 
 	// check for duplicate slug
 	if _, err := p.store.GetProjectBySlug(newProject.Slug); err == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "project slug is already in use"})
+		apierror.Respond(c, http.StatusBadRequest, "project slug is already in use")
 		return
 	}
 
@@ -88,7 +117,7 @@ func (p *Projects) CreateProject(c *gin.Context) {
 	)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -104,7 +133,7 @@ func (p *Projects) ListUserProjects(c *gin.Context) {
 	projects, err := p.store.ListUserProjects(userID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -120,7 +149,11 @@ func (p *Projects) DeleteUserProject(c *gin.Context) {
 	// be sure this user owns the project
 	project, err := p.store.GetProjectBySlugAndUserID(projectSlug, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "project does not exist"})
+		apierror.Respond(c, http.StatusNotFound, "project does not exist")
+		return
+	}
+	if project.MFARequired && !c.GetBool("mfa") {
+		apierror.Respond(c, http.StatusForbidden, "project requires multi-factor authentication")
 		return
 	}
 
@@ -128,32 +161,57 @@ func (p *Projects) DeleteUserProject(c *gin.Context) {
 
 	logErr := p.store.DropProjectLogs(projectID)
 	if logErr != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error deleting project logs"})
+		apierror.Respond(c, http.StatusInternalServerError, "error deleting project logs")
 		return
 	}
 	keyErr := p.store.DropProjectKeys(projectID)
 	if keyErr != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error deleting project api keys"})
+		apierror.Respond(c, http.StatusInternalServerError, "error deleting project api keys")
 		return
 	}
 	usersErr := p.store.DropProjectUsers(projectID)
 	if usersErr != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error deleting project users"})
+		apierror.Respond(c, http.StatusInternalServerError, "error deleting project users")
+		return
+	}
+	templatesErr := p.store.DropProjectEmailTemplates(projectID)
+	if templatesErr != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "error deleting project email templates")
+		return
+	}
+	providersErr := p.store.DropProjectIdentityProviders(projectID)
+	if providersErr != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "error deleting project identity providers")
+		return
+	}
+	rolesErr := p.store.DropProjectRoles(projectID)
+	if rolesErr != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "error deleting project roles")
+		return
+	}
+	keysErr := p.store.DropProjectSigningKeys(projectID)
+	if keysErr != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "error deleting project signing keys")
 		return
 	}
 	sessionsErr := p.store.DropProjectSessions(projectID)
 	if sessionsErr != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error deleting project sessions"})
+		apierror.Respond(c, http.StatusInternalServerError, "error deleting project sessions")
 		return
 	}
 	resourceErr := p.store.DropProjectResources(projectID)
 	if resourceErr != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error deleting project resources"})
+		apierror.Respond(c, http.StatusInternalServerError, "error deleting project resources")
+		return
+	}
+	eventsErr := p.store.DropProjectOutboxEvents(projectID)
+	if eventsErr != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "error deleting project events")
 		return
 	}
 	projectErr := p.store.DeleteProject(projectID)
 	if projectErr != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error deleting project"})
+		apierror.Respond(c, http.StatusInternalServerError, "error deleting project")
 		return
 	}
 
@@ -2,7 +2,10 @@ package projects
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
+	"github.com/machinable/machinable/auth"
 	"github.com/machinable/machinable/dsi"
 )
 
@@ -43,6 +46,12 @@ type ProjectBody struct {
 	Icon             string `json:"icon"`
 	Authn            bool   `json:"authn"`
 	UserRegistration bool   `json:"user_registration"`
+	UserVerification bool   `json:"user_verification"`
+	// token lifetimes in minutes, 0 uses the default lifetimes
+	AccessTokenLifetime  int `json:"access_token_lifetime"`
+	RefreshTokenLifetime int `json:"refresh_token_lifetime"`
+	// MFARequired requires app users to log in with a second factor to manage the project
+	MFARequired bool `json:"mfa_required"`
 }
 
 // Validate checks the project body for invalid fields
@@ -66,6 +75,31 @@ func (pb *ProjectBody) Validate() error {
 	return nil
 }
 
+// ValidateLifetimes checks the session token lifetimes of the project
+func (pb *ProjectBody) ValidateLifetimes() error {
+	access := time.Duration(pb.AccessTokenLifetime) * time.Minute
+	refresh := time.Duration(pb.RefreshTokenLifetime) * time.Minute
+
+	if access < 0 || access > auth.MaxAccessTokenLifetime {
+		return fmt.Errorf("access_token_lifetime must be between 1 and %.0f minutes, or 0 for the default of %.0f", auth.MaxAccessTokenLifetime.Minutes(), auth.DefaultAccessTokenLifetime.Minutes())
+	}
+	if refresh < 0 || refresh > auth.MaxRefreshTokenLifetime {
+		return fmt.Errorf("refresh_token_lifetime must be between 1 and %.0f minutes, or 0 for the default of %.0f", auth.MaxRefreshTokenLifetime.Minutes(), auth.DefaultRefreshTokenLifetime.Minutes())
+	}
+
+	if access == 0 {
+		access = auth.DefaultAccessTokenLifetime
+	}
+	if refresh == 0 {
+		refresh = auth.DefaultRefreshTokenLifetime
+	}
+	if refresh < access {
+		return errors.New("refresh_token_lifetime can not be shorter than access_token_lifetime")
+	}
+
+	return nil
+}
+
 // ReservedSlug verifies the slug is not reserved. Returns true if reserved, false otherwise
 func (pb *ProjectBody) ReservedSlug() bool {
 	// check if the slug is in the `reservedProjectNames`
@@ -13,11 +13,11 @@ func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, config *confi
 
 	// project endpoints
 	projects := engine.Group("/projects")
-	projects.Use(middleware.AppUserJwtAuthzMiddleware(config))
+	projects.Use(middleware.AppUserJwtAuthzMiddleware(datastore, config))
 	projects.GET("/", handler.ListUserProjects)
 	projects.POST("/", handler.CreateProject)
 	projects.PUT("/:projectSlug", handler.UpdateProject)
-	projects.DELETE("/:projectSlug", handler.DeleteUserProject)
+	projects.DELETE("/:projectSlug", middleware.AppUserRecentMFAMiddleware(datastore), handler.DeleteUserProject)
 
 	return nil
 }
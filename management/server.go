@@ -6,8 +6,10 @@ import (
 	"github.com/go-redis/redis"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/events"
 	"github.com/machinable/machinable/management/projects"
 	"github.com/machinable/machinable/management/users"
 	"github.com/machinable/machinable/middleware"
@@ -29,11 +31,15 @@ func (m *Meta) Version(c *gin.Context) {
 }
 
 // CreateRoutes creates a gin.Engine with routes to the application management resources
-func CreateRoutes(datastore interfaces.Datastore, cache redis.UniversalClient, config *config.AppConfig) *gin.Engine {
+func CreateRoutes(datastore interfaces.Datastore, cache redis.UniversalClient, bus events.EventBus, config *config.AppConfig) *gin.Engine {
 	metaHandler := &Meta{config: config}
 	router := gin.Default()
 
+	router.Use(middleware.RequestIDMiddleware())
 	router.Use(middleware.OpenCORSMiddleware())
+	router.NoRoute(func(c *gin.Context) {
+		apierror.Respond(c, http.StatusNotFound, "route not found")
+	})
 
 	// meta endpoint for health and version
 	meta := router.Group("/meta")
@@ -41,7 +47,7 @@ func CreateRoutes(datastore interfaces.Datastore, cache redis.UniversalClient, c
 	meta.GET("/version", metaHandler.Version)
 
 	// user endpoints
-	users.SetRoutes(router, datastore, cache, config)
+	users.SetRoutes(router, datastore, cache, bus, config)
 	projects.SetRoutes(router, datastore, config)
 
 	return router
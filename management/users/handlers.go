@@ -15,6 +15,7 @@ import (
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/auth"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
@@ -24,25 +25,35 @@ import (
 )
 
 // New returns a pointer to a new `Users`
-func New(db interfaces.Datastore, cache redis.UniversalClient, config *config.AppConfig) *Users {
-	jwt := auth.NewJWT(config)
+func New(db interfaces.Datastore, cache redis.UniversalClient, bus events.EventBus, config *config.AppConfig) *Users {
+	jwt := auth.NewJWT(config, db)
+	// TOTP secrets can't be encrypted or decrypted without a valid key encryption key, which is checked on startup
+	kek, _ := auth.KeyEncryptionKey(config)
 	return &Users{
-		store:  db,
-		cache:  cache,
-		config: config,
-		jwt:    jwt,
+		store:    db,
+		cache:    cache,
+		bus:      bus,
+		config:   config,
+		jwt:      jwt,
+		webauthn: auth.NewWebAuthn(config),
+		kek:      kek,
 	}
 }
 
 // Users contains the datastore and any HTTP handlers needed for application users
 type Users struct {
-	store  interfaces.Datastore
-	cache  redis.UniversalClient
-	config *config.AppConfig
-	jwt    *auth.JWT
+	store    interfaces.Datastore
+	cache    redis.UniversalClient
+	bus      events.EventBus
+	config   *config.AppConfig
+	jwt      *auth.JWT
+	webauthn *auth.WebAuthn
+	kek      []byte // kek encrypts the TOTP secrets of users
 }
 
-func (u *Users) createAccessToken(user *models.User) (string, error) {
+// createAccessToken returns an access token of the user. `mfa` is set for sessions logged in with a second factor,
+// `mfaTime` is the time the factor was used and is only set when the token is issued with it.
+func (u *Users) createAccessToken(user *models.User, mfa bool, mfaTime time.Time) (string, error) {
 	// TODO: add user project map to jwt
 	claims := jwt.MapClaims{
 		"projects": make(map[string]interface{}),
@@ -52,6 +63,10 @@ func (u *Users) createAccessToken(user *models.User) (string, error) {
 			"type":   "app",
 			"active": true,
 		},
+		"mfa": mfa,
+	}
+	if !mfaTime.IsZero() {
+		claims["mfa_time"] = mfaTime.Unix()
 	}
 
 	accessToken, err := u.jwt.CreateAccessToken(claims)
@@ -63,21 +78,34 @@ func (u *Users) createAccessToken(user *models.User) (string, error) {
 }
 
 // createTokensAndSession returns an accessToken, refreshToken, error
-func (u *Users) createTokensAndSession(user *models.User, c *gin.Context) (string, string, *models.Session, error) {
+func (u *Users) createTokensAndSession(user *models.User, mfa bool, c *gin.Context) (string, string, *models.Session, error) {
+	mfaTime := time.Time{}
+	if mfa {
+		mfaTime = time.Now()
+	}
+
 	// create access token
-	accessToken, err := u.createAccessToken(user)
+	accessToken, err := u.createAccessToken(user, mfa, mfaTime)
 	if err != nil {
 		return "", "", nil, err
 	}
 
+	tokenID, err := auth.RandomToken(16)
+	if err != nil {
+		return "", "", nil, errors.New("failed to create session")
+	}
+
 	// create session in database (refresh token)
 	session := as.CreateSession(user.ID, c.ClientIP(), c.Request.UserAgent(), u.config)
+	session.RefreshTokenID = tokenID
+	session.MFA = mfa
+	session.Expires = time.Now().Add(u.jwt.RefreshLifetime())
 	err = u.store.CreateAppSession(session)
 	if err != nil {
 		return "", "", nil, errors.New("failed to create session")
 	}
 
-	refreshToken, err := u.jwt.CreateRefreshToken(session.ID, user.ID)
+	refreshToken, err := u.jwt.CreateRefreshToken(session.ID, user.ID, tokenID, session.Expires)
 	if err != nil {
 		return "", "", nil, errors.New("failed to create refresh token")
 	}
@@ -122,7 +150,7 @@ func (u *Users) verifyEmail(user *models.User) error {
 		log.Println(err)
 		return errors.New("failed creating verification code")
 	}
-	if err := u.cache.RPush(events.QueueEmailNotifications, b).Err(); err != nil {
+	if err := u.bus.Publish(events.QueueEmailNotifications, b); err != nil {
 		log.Println(err)
 		return errors.New("failed creating verification code")
 	}
@@ -140,21 +168,21 @@ func (u *Users) RegisterUser(c *gin.Context) {
 	// validate user
 	err := newUser.Validate(u.config.ReCaptchaSecret)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// generate hashed password
 	passwordHash, err := auth.HashPassword(newUser.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 	newUser.Password = ""
 
 	// check for duplicate email
 	if _, err := u.store.GetAppUserByUsername(newUser.Email); err == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "email is already in use"})
+		apierror.Respond(c, http.StatusBadRequest, "email is already in use")
 		return
 	}
 
@@ -169,13 +197,13 @@ func (u *Users) RegisterUser(c *gin.Context) {
 	// save the user
 	err = u.store.CreateAppUser(user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// return success
 	if err := u.verifyEmail(user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -196,10 +224,10 @@ func (u *Users) EmailVerification(c *gin.Context) {
 	userID, rerr := u.cache.Get(vKey).Result()
 	if rerr == redis.Nil {
 		// key doesn't exist
-		c.JSON(http.StatusNotFound, gin.H{"error": "failed to verify email for user"})
+		apierror.Respond(c, http.StatusNotFound, "failed to verify email for user")
 		return
 	} else if rerr != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "failed to verify email for user"})
+		apierror.Respond(c, http.StatusNotFound, "failed to verify email for user")
 		return
 	}
 
@@ -207,22 +235,34 @@ func (u *Users) EmailVerification(c *gin.Context) {
 	user, err := u.store.GetAppUserByID(userID)
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "user does not exist"})
+		apierror.Respond(c, http.StatusNotFound, "user does not exist")
 		return
 	}
 
 	// update user in db, set active
 	if err := u.store.ActivateUser(userID, true); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "failed to activate user"})
+		apierror.Respond(c, http.StatusNotFound, "failed to activate user")
+		return
+	}
+
+	// users with a second factor must log in with it
+	mfa, err := u.store.GetUserMFA(userID)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to load multi-factor authentication")
+		return
+	}
+	if mfa.Enabled() {
+		u.cache.Del(vKey)
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully activated user"})
 		return
 	}
 
 	// create session and return api tokens
 	// TODO: refactor sessions
-	accessToken, refreshToken, session, err := u.createTokensAndSession(user, c)
+	accessToken, refreshToken, session, err := u.createTokensAndSession(user, false, c)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -243,13 +283,13 @@ func (u *Users) LoginUser(c *gin.Context) {
 	// basic auth for login
 	authorizationHeader, _ := c.Request.Header["Authorization"]
 	if len(authorizationHeader) <= 0 {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "no authorization header"})
+		apierror.Respond(c, http.StatusUnauthorized, "no authorization header")
 		return
 	}
 	authzHeader := strings.SplitN(authorizationHeader[0], " ", 2)
 
 	if len(authzHeader) != 2 || authzHeader[0] != "Basic" {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "malformed authorization header"})
+		apierror.Respond(c, http.StatusUnauthorized, "malformed authorization header")
 		return
 	}
 
@@ -257,7 +297,7 @@ func (u *Users) LoginUser(c *gin.Context) {
 	pair := strings.SplitN(string(payload), ":", 2)
 
 	if len(pair) != 2 {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "no authorization header payload"})
+		apierror.Respond(c, http.StatusUnauthorized, "no authorization header payload")
 		return
 	}
 
@@ -272,26 +312,37 @@ func (u *Users) LoginUser(c *gin.Context) {
 	// look up the user
 	user, err := u.store.GetAppUserByUsername(userName)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "email not found"})
+		apierror.Respond(c, http.StatusNotFound, "email not found")
 		return
 	}
 
 	// compare passwords
 	if !auth.CompareHashAndPassword(user.PasswordHash, userPassword) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "invalid password"})
+		apierror.Respond(c, http.StatusNotFound, "invalid password")
 		return
 	}
 
 	if !user.Active {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user account is not active, check email for verification"})
+		apierror.Respond(c, http.StatusNotFound, "user account is not active, check email for verification")
 		u.verifyEmail(user)
 		return
 	}
 
-	accessToken, refreshToken, session, err := u.createTokensAndSession(user, c)
+	// users with a second factor complete the login at `/users/sessions/mfa`
+	mfa, err := u.store.GetUserMFA(user.ID)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to load multi-factor authentication")
+		return
+	}
+	if mfa.Enabled() {
+		u.respondMFAChallenge(c, http.StatusAccepted, user, mfa, mfaPurposeLogin)
+		return
+	}
+
+	accessToken, refreshToken, session, err := u.createTokensAndSession(user, false, c)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -302,30 +353,34 @@ func (u *Users) LoginUser(c *gin.Context) {
 	})
 }
 
-// RefreshToken exchanges a valid refresh token for a new access token.
+// RefreshToken exchanges a valid refresh token for a new access token and refresh token. The presented refresh token
+// can not be used again.
 func (u *Users) RefreshToken(c *gin.Context) {
-	// get session and user id from context, should have been injected by ValidateRefreshToken
+	// get session, user and token id from context, should have been injected by ValidateAppRefreshToken
 	sessionID, ok := c.MustGet("session_id").(string)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no session"})
+		apierror.Respond(c, http.StatusBadRequest, "no session")
 		return
 	}
 
 	userID, ok := c.MustGet("user_id").(string)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no user"})
+		apierror.Respond(c, http.StatusBadRequest, "no user")
 		return
 	}
-
-	// load session to update last accessed
+	tokenID := c.GetString("refresh_token_id")
 
 	// verify session exists
-	_, err := u.store.GetAppSession(sessionID)
+	session, err := u.store.GetAppSession(sessionID)
 
 	if err != nil {
 		log.Println(err)
-		// no documents in result, user does not exist
-		c.JSON(http.StatusNotFound, gin.H{"message": "error creating access token."})
+		// no documents in result, session has been revoked
+		apierror.Respond(c, http.StatusNotFound, "error creating access token.")
+		return
+	}
+	if session.UserID != userID || !time.Now().Before(session.Expires) {
+		apierror.Respond(c, http.StatusUnauthorized, "session has expired")
 		return
 	}
 
@@ -334,28 +389,53 @@ func (u *Users) RefreshToken(c *gin.Context) {
 	if err != nil {
 		log.Println(err)
 		// no documents in result, user does not exist
-		c.JSON(http.StatusNotFound, gin.H{"message": "error creating access token."})
+		apierror.Respond(c, http.StatusNotFound, "error creating access token.")
 		return
 	}
 
-	// create new access jwt
-	accessToken, err := u.createAccessToken(user)
+	nextID, err := auth.RandomToken(16)
 	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusNotFound, gin.H{"message": "error creating access token."})
+		apierror.Respond(c, http.StatusInternalServerError, "failed to create refresh token")
 		return
 	}
+	expires := time.Now().Add(u.jwt.RefreshLifetime())
 
-	// update session `last_accessed` time
-	err = u.store.UpdateAppSessionLastAccessed(sessionID, time.Now())
-
+	// the tokens are created before the rotation is saved, so a failure can't leave the session without a usable token.
+	// The second factor of the session is not used again.
+	accessToken, err := u.createAccessToken(user, session.MFA, time.Time{})
+	if err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusNotFound, "error creating access token.")
+		return
+	}
+	refreshToken, err := u.jwt.CreateRefreshToken(sessionID, userID, nextID, expires)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to create refresh token")
+		return
+	}
+
+	// refresh tokens are single use, presenting a used token revokes the session
+	rotated := false
+	if tokenID == session.RefreshTokenID {
+		rotated, err = u.store.RotateAppRefreshToken(sessionID, tokenID, nextID, expires)
+		if err != nil {
+			log.Println(err)
+			apierror.Respond(c, http.StatusInternalServerError, "failed to refresh session")
+			return
+		}
+	}
+	if !rotated {
+		if err := u.store.DeleteAppSession(sessionID); err != nil {
+			log.Println(err)
+		}
+		apierror.Respond(c, http.StatusUnauthorized, "refresh token reuse detected, session revoked")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"access_token": accessToken,
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
 	})
 }
 
@@ -366,7 +446,7 @@ func (u *Users) RevokeSession(c *gin.Context) {
 	err := u.store.DeleteAppSession(sessionID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -377,7 +457,7 @@ func (u *Users) RevokeSession(c *gin.Context) {
 func (u *Users) ResetPassword(c *gin.Context) {
 	userID, ok := c.MustGet("user_id").(string)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no user"})
+		apierror.Respond(c, http.StatusBadRequest, "no user")
 		return
 	}
 
@@ -388,27 +468,27 @@ func (u *Users) ResetPassword(c *gin.Context) {
 	// validate user
 	err := passwordUpdate.Validate()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// look up the user
 	user, err := u.store.GetAppUserByID(userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		apierror.Respond(c, http.StatusNotFound, "user not found")
 		return
 	}
 
 	// compare passwords
 	if !auth.CompareHashAndPassword(user.PasswordHash, passwordUpdate.OldPW) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "invalid password"})
+		apierror.Respond(c, http.StatusNotFound, "invalid password")
 		return
 	}
 
 	// generate hashed NEW password
 	newPasswordHash, err := auth.HashPassword(passwordUpdate.NewPW)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 	passwordUpdate.OldPW = ""
@@ -416,7 +496,7 @@ func (u *Users) ResetPassword(c *gin.Context) {
 
 	// update password
 	if err := u.store.UpdateUserPassword(userID, newPasswordHash); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -427,14 +507,14 @@ func (u *Users) ResetPassword(c *gin.Context) {
 func (u *Users) GetUser(c *gin.Context) {
 	userID, ok := c.MustGet("user_id").(string)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no user"})
+		apierror.Respond(c, http.StatusBadRequest, "no user")
 		return
 	}
 
 	user, err := u.store.GetAppUserByID(userID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -446,7 +526,7 @@ func (u *Users) ListTiers(c *gin.Context) {
 	tiers, err := u.store.ListTiers()
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -457,7 +537,7 @@ func (u *Users) ListTiers(c *gin.Context) {
 func (u *Users) GetUsage(c *gin.Context) {
 	userID, ok := c.MustGet("user_id").(string)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no user"})
+		apierror.Respond(c, http.StatusBadRequest, "no user")
 		return
 	}
 
@@ -470,7 +550,7 @@ func (u *Users) GetUsage(c *gin.Context) {
 	if err == redis.Nil {
 		// {currentKey} does not exist
 	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error retrieving usage"})
+		apierror.Respond(c, http.StatusInternalServerError, "unexpected error retrieving usage")
 		return
 	}
 
@@ -484,7 +564,7 @@ func (u *Users) GetSession(c *gin.Context) {
 	session, err := u.store.GetAppSession(sessionID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -495,14 +575,14 @@ func (u *Users) GetSession(c *gin.Context) {
 func (u *Users) ListUserSessions(c *gin.Context) {
 	userID, ok := c.MustGet("user_id").(string)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no user"})
+		apierror.Respond(c, http.StatusBadRequest, "no user")
 		return
 	}
 
 	sessions, err := u.store.ListUserSessions(userID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
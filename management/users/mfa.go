@@ -0,0 +1,578 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/apierror"
+	"github.com/machinable/machinable/auth"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const (
+	// mfaChallengeTTL is how long a user has to complete a multi-factor challenge
+	mfaChallengeTTL = time.Minute * 5
+	// maxMFAAttempts is the number of invalid factors accepted for a user within mfaAttemptsWindow, new challenges do
+	// not reset it
+	maxMFAAttempts    = 10
+	mfaAttemptsWindow = time.Minute * 15
+
+	mfaPurposeLogin    = "login"
+	mfaPurposeVerify   = "verify"
+	mfaPurposeRegister = "register"
+)
+
+// mfaChallenge is a pending multi-factor authentication or WebAuthn registration, stored in the cache
+type mfaChallenge struct {
+	UserID  string `json:"user_id"`
+	Purpose string `json:"purpose"`
+	// Challenge is the WebAuthn challenge of the ceremony
+	Challenge string `json:"challenge"`
+}
+
+func mfaChallengeKey(token string) string {
+	return fmt.Sprintf("mfaChallenge:%s", token)
+}
+
+func mfaAttemptsKey(userID string) string {
+	return fmt.Sprintf("mfaAttempts:%s", userID)
+}
+
+// createMFAChallenge saves a new challenge of the user, returning its token
+func (u *Users) createMFAChallenge(userID, purpose string) (string, *mfaChallenge, error) {
+	token, tErr := auth.RandomToken(32)
+	challenge, cErr := auth.RandomToken(32)
+	if tErr != nil || cErr != nil {
+		return "", nil, fmt.Errorf("failed to create challenge")
+	}
+
+	pending := &mfaChallenge{UserID: userID, Purpose: purpose, Challenge: challenge}
+	b, _ := json.Marshal(pending)
+	if err := u.cache.Set(mfaChallengeKey(token), b, mfaChallengeTTL).Err(); err != nil {
+		log.Println(err)
+		return "", nil, fmt.Errorf("failed to create challenge")
+	}
+
+	return token, pending, nil
+}
+
+// loadMFAChallenge responds with an error unless the token is a pending challenge of the purpose
+func (u *Users) loadMFAChallenge(c *gin.Context, token, purpose string) (*mfaChallenge, bool) {
+	if token == "" {
+		apierror.Respond(c, http.StatusBadRequest, "mfa_token is required")
+		return nil, false
+	}
+
+	b, err := u.cache.Get(mfaChallengeKey(token)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Println(err)
+		}
+		apierror.Respond(c, http.StatusUnauthorized, "invalid or expired mfa_token")
+		return nil, false
+	}
+
+	pending := &mfaChallenge{}
+	if err := json.Unmarshal(b, pending); err != nil || pending.Purpose != purpose {
+		apierror.Respond(c, http.StatusUnauthorized, "invalid or expired mfa_token")
+		return nil, false
+	}
+
+	return pending, true
+}
+
+// deleteMFAChallenge revokes a challenge once it has been used
+func (u *Users) deleteMFAChallenge(token string) {
+	u.cache.Del(mfaChallengeKey(token))
+}
+
+// respondMFAChallenge responds with a new challenge of the user and the methods it can be completed with
+func (u *Users) respondMFAChallenge(c *gin.Context, status int, user *models.User, mfa *models.UserMFA, purpose string) {
+	token, pending, err := u.createMFAChallenge(user.ID, purpose)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := gin.H{
+		"mfa_required": true,
+		"mfa_token":    token,
+		"methods":      mfa.Methods(),
+	}
+	if len(mfa.WebAuthn) > 0 {
+		response["webauthn"] = u.webauthn.RequestOptions(pending.Challenge, mfa.WebAuthn)
+	}
+
+	c.JSON(status, response)
+}
+
+// verifySecondFactor verifies the TOTP code, recovery code or WebAuthn assertion of the body. False is returned if the
+// factor is invalid or has already been used, the error is only set if the factor could not be checked.
+func (u *Users) verifySecondFactor(userID string, mfa *models.UserMFA, pending *mfaChallenge, body *mfaBody) (bool, error) {
+	switch {
+	case body.Code != "":
+		if !mfa.TOTPEnabled {
+			return false, nil
+		}
+		secret, err := u.totpSecret(userID, mfa)
+		if err != nil {
+			return false, err
+		}
+		step, ok := auth.ValidateTOTP(secret, body.Code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		// a code cannot be used twice
+		return u.store.UseTOTPStep(userID, step)
+	case body.RecoveryCode != "":
+		return u.store.UseRecoveryCode(userID, auth.HashRecoveryCode(body.RecoveryCode))
+	case body.WebAuthn != nil:
+		credential := mfa.Credential(body.WebAuthn.ID)
+		if credential == nil {
+			return false, nil
+		}
+		signCount, err := u.webauthn.VerifyAssertion(pending.Challenge, credential, body.WebAuthn)
+		if err != nil {
+			return false, nil
+		}
+		return true, u.store.UpdateWebAuthnSignCount(userID, credential.ID, signCount)
+	}
+
+	return false, nil
+}
+
+// completeMFAChallenge loads the challenge of the body and verifies its second factor, the user of the challenge is
+// returned once it has been completed
+func (u *Users) completeMFAChallenge(c *gin.Context, body *mfaBody, purpose string) (*models.User, bool) {
+	if err := body.Validate(); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	pending, ok := u.loadMFAChallenge(c, body.MFAToken, purpose)
+	if !ok {
+		return nil, false
+	}
+	if purpose == mfaPurposeVerify && pending.UserID != c.GetString("user_id") {
+		apierror.Respond(c, http.StatusUnauthorized, "invalid or expired mfa_token")
+		return nil, false
+	}
+
+	// invalid factors are limited per user, rather than per challenge, so codes cannot be guessed with new logins
+	attemptsKey := mfaAttemptsKey(pending.UserID)
+	if attempts, _ := u.cache.Get(attemptsKey).Int(); attempts >= maxMFAAttempts {
+		apierror.Respond(c, http.StatusTooManyRequests, "too many invalid attempts, try again later")
+		return nil, false
+	}
+
+	user, err := u.store.GetAppUserByID(pending.UserID)
+	if err != nil {
+		apierror.Respond(c, http.StatusNotFound, "user not found")
+		return nil, false
+	}
+	mfa, err := u.store.GetUserMFA(user.ID)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to load multi-factor authentication")
+		return nil, false
+	}
+
+	verified, err := u.verifySecondFactor(user.ID, mfa, pending, body)
+	if err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to verify multi-factor authentication")
+		return nil, false
+	}
+	if !verified {
+		if u.cache.Incr(attemptsKey).Val() == 1 {
+			u.cache.Expire(attemptsKey, mfaAttemptsWindow)
+		}
+		apierror.Respond(c, http.StatusUnauthorized, "invalid multi-factor authentication")
+		return nil, false
+	}
+
+	u.cache.Del(attemptsKey)
+	u.deleteMFAChallenge(body.MFAToken)
+	return user, true
+}
+
+// LoginUserMFA completes the login of a user with a second factor, the tokens of the session are returned
+func (u *Users) LoginUserMFA(c *gin.Context) {
+	var body mfaBody
+	if err := c.BindJSON(&body); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, ok := u.completeMFAChallenge(c, &body, mfaPurposeLogin)
+	if !ok {
+		return
+	}
+
+	accessToken, refreshToken, session, err := u.createTokensAndSession(user, true, c)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"session_id":    session.ID,
+	})
+}
+
+// CreateMFAChallenge starts the verification of the second factor of a logged in user, before sensitive actions
+func (u *Users) CreateMFAChallenge(c *gin.Context) {
+	user, mfa, ok := u.loadUserMFA(c)
+	if !ok {
+		return
+	}
+	if !mfa.Enabled() {
+		apierror.Respond(c, http.StatusBadRequest, "multi-factor authentication is not enabled")
+		return
+	}
+
+	u.respondMFAChallenge(c, http.StatusCreated, user, mfa, mfaPurposeVerify)
+}
+
+// VerifyMFA completes the verification of the second factor of a logged in user, returning a new access token which
+// allows sensitive actions for `middleware.RecentMFAWindow`
+func (u *Users) VerifyMFA(c *gin.Context) {
+	var body mfaBody
+	if err := c.BindJSON(&body); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, ok := u.completeMFAChallenge(c, &body, mfaPurposeVerify)
+	if !ok {
+		return
+	}
+
+	accessToken, err := u.createAccessToken(user, true, time.Now())
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"access_token": accessToken})
+}
+
+// GetMFA returns the second factors of the user
+func (u *Users) GetMFA(c *gin.Context) {
+	_, mfa, ok := u.loadUserMFA(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  mfa.Enabled(),
+		"methods":                  mfa.Methods(),
+		"totp_enabled":             mfa.TOTPEnabled,
+		"webauthn":                 mfa.WebAuthn,
+		"recovery_codes_remaining": len(mfa.RecoveryCodes),
+	})
+}
+
+// totpSecret decrypts the TOTP secret of the user
+func (u *Users) totpSecret(userID string, mfa *models.UserMFA) (string, error) {
+	return auth.DecryptSecret(u.kek, mfa.TOTPSecret, userID)
+}
+
+// EnrollTOTP creates a new TOTP secret for the user, TOTP is enabled once a code of the secret is verified
+func (u *Users) EnrollTOTP(c *gin.Context) {
+	user, mfa, ok := u.loadUserMFA(c)
+	if !ok {
+		return
+	}
+	if mfa.TOTPEnabled {
+		apierror.Respond(c, http.StatusBadRequest, "totp is already enabled")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to create totp secret")
+		return
+	}
+	// the secret is encrypted with the user ID, so it can not be copied to another user
+	encrypted, err := auth.EncryptSecret(u.kek, secret, user.ID)
+	if err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to create totp secret")
+		return
+	}
+	if err := u.store.SaveTOTPSecret(user.ID, encrypted); err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to create totp secret")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"secret": secret,
+		"uri":    auth.TOTPURI(user.Email, secret),
+	})
+}
+
+// ConfirmTOTP enables TOTP with a code of the pending secret. Recovery codes are returned if it is the first factor
+// of the user, they are not shown again.
+func (u *Users) ConfirmTOTP(c *gin.Context) {
+	var body mfaBody
+	if err := c.BindJSON(&body); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, mfa, ok := u.loadUserMFA(c)
+	if !ok {
+		return
+	}
+	if mfa.TOTPEnabled {
+		apierror.Respond(c, http.StatusBadRequest, "totp is already enabled")
+		return
+	}
+	if mfa.TOTPSecret == "" {
+		apierror.Respond(c, http.StatusBadRequest, "totp enrollment has not been started")
+		return
+	}
+
+	secret, err := u.totpSecret(user.ID, mfa)
+	if err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to load totp secret")
+		return
+	}
+	step, valid := auth.ValidateTOTP(secret, body.Code, time.Now())
+	if !valid {
+		apierror.Respond(c, http.StatusBadRequest, "invalid code")
+		return
+	}
+	if err := u.store.EnableTOTP(user.ID, step); err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to enable totp")
+		return
+	}
+
+	response := gin.H{"totp_enabled": true}
+	if len(mfa.RecoveryCodes) == 0 {
+		codes, ok := u.replaceRecoveryCodes(c, user.ID)
+		if !ok {
+			return
+		}
+		response["recovery_codes"] = codes
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DisableTOTP disables TOTP for the user, the recovery codes are removed with the last factor of the user
+func (u *Users) DisableTOTP(c *gin.Context) {
+	user, mfa, ok := u.loadUserMFA(c)
+	if !ok {
+		return
+	}
+	if !mfa.TOTPEnabled {
+		apierror.Respond(c, http.StatusNotFound, "totp is not enabled")
+		return
+	}
+
+	if err := u.store.DisableTOTP(user.ID); err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to disable totp")
+		return
+	}
+
+	mfa.TOTPEnabled = false
+	if !u.removeLastFactor(c, user.ID, mfa) {
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user
+func (u *Users) RegenerateRecoveryCodes(c *gin.Context) {
+	user, mfa, ok := u.loadUserMFA(c)
+	if !ok {
+		return
+	}
+	if !mfa.Enabled() {
+		apierror.Respond(c, http.StatusBadRequest, "multi-factor authentication is not enabled")
+		return
+	}
+
+	codes, ok := u.replaceRecoveryCodes(c, user.ID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"recovery_codes": codes})
+}
+
+// BeginWebAuthnRegistration returns the options to register a new security key or platform authenticator
+func (u *Users) BeginWebAuthnRegistration(c *gin.Context) {
+	user, mfa, ok := u.loadUserMFA(c)
+	if !ok {
+		return
+	}
+
+	token, pending, err := u.createMFAChallenge(user.ID, mfaPurposeRegister)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"mfa_token": token,
+		"webauthn":  u.webauthn.CreationOptions(pending.Challenge, user.ID, user.Email, mfa.WebAuthn),
+	})
+}
+
+// FinishWebAuthnRegistration saves the credential created by the browser. Recovery codes are returned if it is the
+// first factor of the user, they are not shown again.
+func (u *Users) FinishWebAuthnRegistration(c *gin.Context) {
+	var body mfaBody
+	if err := c.BindJSON(&body); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.WebAuthn == nil {
+		apierror.Respond(c, http.StatusBadRequest, "webauthn is required")
+		return
+	}
+
+	user, mfa, ok := u.loadUserMFA(c)
+	if !ok {
+		return
+	}
+	pending, ok := u.loadMFAChallenge(c, body.MFAToken, mfaPurposeRegister)
+	if !ok {
+		return
+	}
+	if pending.UserID != user.ID {
+		apierror.Respond(c, http.StatusUnauthorized, "invalid or expired mfa_token")
+		return
+	}
+
+	credential, err := u.webauthn.VerifyRegistration(pending.Challenge, body.WebAuthn)
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	credential.Name = body.Name
+	if credential.Name == "" {
+		credential.Name = fmt.Sprintf("Security key %d", len(mfa.WebAuthn)+1)
+	}
+
+	if err := u.store.AddWebAuthnCredential(user.ID, credential); err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to save credential")
+		return
+	}
+	u.deleteMFAChallenge(body.MFAToken)
+
+	response := gin.H{"credential": credential}
+	if len(mfa.RecoveryCodes) == 0 {
+		codes, ok := u.replaceRecoveryCodes(c, user.ID)
+		if !ok {
+			return
+		}
+		response["recovery_codes"] = codes
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// DeleteWebAuthnCredential removes a security key or platform authenticator of the user, the recovery codes are
+// removed with the last factor of the user
+func (u *Users) DeleteWebAuthnCredential(c *gin.Context) {
+	credentialID := c.Param("credentialID")
+
+	user, mfa, ok := u.loadUserMFA(c)
+	if !ok {
+		return
+	}
+	if mfa.Credential(credentialID) == nil {
+		apierror.Respond(c, http.StatusNotFound, "credential not found")
+		return
+	}
+
+	if err := u.store.DeleteWebAuthnCredential(user.ID, credentialID); err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to delete credential")
+		return
+	}
+
+	remaining := []*models.WebAuthnCredential{}
+	for _, credential := range mfa.WebAuthn {
+		if credential.ID != credentialID {
+			remaining = append(remaining, credential)
+		}
+	}
+	mfa.WebAuthn = remaining
+	if !u.removeLastFactor(c, user.ID, mfa) {
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// loadUserMFA responds with an error unless the user of the request and their multi-factor authentication are found
+func (u *Users) loadUserMFA(c *gin.Context) (*models.User, *models.UserMFA, bool) {
+	user, err := u.store.GetAppUserByID(c.GetString("user_id"))
+	if err != nil {
+		apierror.Respond(c, http.StatusNotFound, "user not found")
+		return nil, nil, false
+	}
+
+	mfa, err := u.store.GetUserMFA(user.ID)
+	if err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to load multi-factor authentication")
+		return nil, nil, false
+	}
+
+	return user, mfa, true
+}
+
+// replaceRecoveryCodes generates and saves new recovery codes of the user, returning them in plain text
+func (u *Users) replaceRecoveryCodes(c *gin.Context, userID string) ([]string, bool) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to create recovery codes")
+		return nil, false
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	if err := u.store.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to save recovery codes")
+		return nil, false
+	}
+
+	return codes, true
+}
+
+// removeLastFactor removes the recovery codes of the user once they no longer have a second factor, recovery codes
+// cannot be used to log in without one
+func (u *Users) removeLastFactor(c *gin.Context, userID string, mfa *models.UserMFA) bool {
+	if mfa.Enabled() {
+		return true
+	}
+
+	if err := u.store.ReplaceRecoveryCodes(userID, nil); err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to remove recovery codes")
+		return false
+	}
+
+	return true
+}
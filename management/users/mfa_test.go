@@ -0,0 +1,406 @@
+package users
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/auth"
+	"github.com/machinable/machinable/config"
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/events"
+	"github.com/machinable/machinable/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+const testKeyEncryptionKey = "a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s="
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.ReleaseMode)
+	code := m.Run()
+	os.Exit(code)
+}
+
+// testCache is an in-memory cache, unused functions panic
+type testCache struct {
+	redis.UniversalClient
+	values map[string]string
+}
+
+func (c *testCache) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	switch v := value.(type) {
+	case []byte:
+		c.values[key] = string(v)
+	default:
+		c.values[key] = fmt.Sprint(v)
+	}
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (c *testCache) Get(key string) *redis.StringCmd {
+	value, ok := c.values[key]
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(value, nil)
+}
+
+func (c *testCache) Del(keys ...string) *redis.IntCmd {
+	for _, key := range keys {
+		delete(c.values, key)
+	}
+	return redis.NewIntResult(int64(len(keys)), nil)
+}
+
+func (c *testCache) Incr(key string) *redis.IntCmd {
+	count, _ := strconv.ParseInt(c.values[key], 10, 64)
+	count++
+	c.values[key] = strconv.FormatInt(count, 10)
+	return redis.NewIntResult(count, nil)
+}
+
+func (c *testCache) Expire(key string, expiration time.Duration) *redis.BoolCmd {
+	return redis.NewBoolResult(true, nil)
+}
+
+// testStore keeps app users, their second factors and signing keys in memory, unused functions panic
+type testStore struct {
+	interfaces.Datastore
+	mu       sync.Mutex
+	users    map[string]*models.User
+	mfa      map[string]*models.UserMFA
+	keys     []*models.SigningKey
+	sessions []*models.Session
+}
+
+func newTestStore(users ...*models.User) *testStore {
+	store := &testStore{users: map[string]*models.User{}, mfa: map[string]*models.UserMFA{}}
+	for _, user := range users {
+		store.users[user.ID] = user
+		store.mfa[user.ID] = &models.UserMFA{}
+	}
+	return store
+}
+
+func (s *testStore) GetAppUserByUsername(userName string) (*models.User, error) {
+	for _, user := range s.users {
+		if user.Username == userName {
+			return user, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (s *testStore) GetAppUserByID(id string) (*models.User, error) {
+	if user, ok := s.users[id]; ok {
+		return user, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+func (s *testStore) CreateAppSession(session *models.Session) error {
+	session.ID = "session-" + strconv.Itoa(len(s.sessions)+1)
+	s.sessions = append(s.sessions, session)
+	return nil
+}
+
+// GetUserMFA returns a copy, as the datastore does
+func (s *testStore) GetUserMFA(userID string) (*models.UserMFA, error) {
+	mfa := *s.mfa[userID]
+	return &mfa, nil
+}
+
+func (s *testStore) SaveTOTPSecret(userID, secret string) error {
+	s.mfa[userID].TOTPSecret = secret
+	return nil
+}
+
+func (s *testStore) EnableTOTP(userID string, step int64) error {
+	s.mfa[userID].TOTPEnabled = true
+	s.mfa[userID].TOTPLastStep = step
+	return nil
+}
+
+func (s *testStore) DisableTOTP(userID string) error {
+	s.mfa[userID].TOTPEnabled = false
+	s.mfa[userID].TOTPSecret = ""
+	return nil
+}
+
+func (s *testStore) UseTOTPStep(userID string, step int64) (bool, error) {
+	if step <= s.mfa[userID].TOTPLastStep {
+		return false, nil
+	}
+	s.mfa[userID].TOTPLastStep = step
+	return true, nil
+}
+
+func (s *testStore) ReplaceRecoveryCodes(userID string, hashes []string) error {
+	s.mfa[userID].RecoveryCodes = hashes
+	return nil
+}
+
+func (s *testStore) UseRecoveryCode(userID, hash string) (bool, error) {
+	codes := s.mfa[userID].RecoveryCodes
+	for i, code := range codes {
+		if code == hash {
+			s.mfa[userID].RecoveryCodes = append(codes[:i:i], codes[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *testStore) ListSigningKeys(projectID string) ([]*models.SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []*models.SigningKey{}
+	for _, key := range s.keys {
+		if key.ProjectID == projectID {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *testStore) GetSigningKey(kid string) (*models.SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.keys {
+		if key.ID == kid {
+			return key, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (s *testStore) CreateSigningKey(key *models.SigningKey) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+	return true, nil
+}
+
+// enableTOTP enrolls a TOTP secret and recovery code of the user, returning the secret
+func (s *testStore) enableTOTP(t *testing.T, userID, recoveryCode string) string {
+	secret, _ := auth.GenerateTOTPSecret()
+	kek, _ := auth.KeyEncryptionKey(testConfig())
+	encrypted, err := auth.EncryptSecret(kek, secret, userID)
+	assert.Nil(t, err)
+
+	s.mfa[userID] = &models.UserMFA{
+		TOTPSecret:    encrypted,
+		TOTPEnabled:   true,
+		RecoveryCodes: []string{auth.HashRecoveryCode(recoveryCode)},
+	}
+	return secret
+}
+
+func testConfig() *config.AppConfig {
+	return &config.AppConfig{KeyEncryptionKey: testKeyEncryptionKey, AppHost: "https://app.machinable.io"}
+}
+
+func testUser(id, username string) *models.User {
+	hash, _ := auth.HashPassword("secret")
+	return &models.User{ID: id, Username: username, Email: username + "@example.com", PasswordHash: hash, Active: true}
+}
+
+// testRouter serves the user routes, tokens are issued with the returned handler
+func testRouter(store *testStore) (*gin.Engine, *Users) {
+	cache := &testCache{values: map[string]string{}}
+	router := gin.New()
+	SetRoutes(router, store, cache, events.NewMemoryBus(), testConfig())
+
+	return router, New(store, cache, events.NewMemoryBus(), testConfig())
+}
+
+// totpCode returns the code of the secret `offset` periods from now (RFC 6238)
+func totpCode(secret string, offset int64) string {
+	key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(time.Now().Unix()/30+offset))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	start := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[start:start+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// wrongCode returns a code which is not valid for the secret in any of the accepted periods
+func wrongCode(secret string) string {
+	for i := 0; ; i++ {
+		code := fmt.Sprintf("%06d", i)
+		if code != totpCode(secret, -1) && code != totpCode(secret, 0) && code != totpCode(secret, 1) {
+			return code
+		}
+	}
+}
+
+func request(router *gin.Engine, method, path, authorization string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	b, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewReader(b))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	router.ServeHTTP(w, req)
+
+	response := map[string]interface{}{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w, response
+}
+
+func basicAuth(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+func TestLoginUser(t *testing.T) {
+	store := newTestStore(testUser("user-1", "ada"), testUser("user-2", "grace"))
+	secret := store.enableTOTP(t, "user-2", "aaaaa-bbbbb")
+	router, _ := testRouter(store)
+
+	// users without a second factor log in with their password
+	w, response := request(router, http.MethodPost, "/users/sessions", basicAuth("ada", "secret"), nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotEmpty(t, response["access_token"])
+	assert.Equal(t, false, store.sessions[0].MFA)
+
+	w, _ = request(router, http.MethodPost, "/users/sessions", basicAuth("ada", "wrong"), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// users with a second factor get a challenge instead of tokens
+	w, response = request(router, http.MethodPost, "/users/sessions", basicAuth("grace", "secret"), nil)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, true, response["mfa_required"])
+	assert.Nil(t, response["access_token"])
+	assert.Len(t, store.sessions, 1)
+	token := response["mfa_token"].(string)
+
+	w, _ = request(router, http.MethodPost, "/users/sessions/mfa", "", gin.H{"mfa_token": token, "code": wrongCode(secret)})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	code := totpCode(secret, 0)
+	w, response = request(router, http.MethodPost, "/users/sessions/mfa", "", gin.H{"mfa_token": token, "code": code})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotEmpty(t, response["access_token"])
+	assert.Equal(t, true, store.sessions[1].MFA)
+
+	// the challenge and the code are single use
+	w, _ = request(router, http.MethodPost, "/users/sessions/mfa", "", gin.H{"mfa_token": token, "code": code})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	_, response = request(router, http.MethodPost, "/users/sessions", basicAuth("grace", "secret"), nil)
+	w, _ = request(router, http.MethodPost, "/users/sessions/mfa", "", gin.H{"mfa_token": response["mfa_token"], "code": code})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// recovery codes complete the challenge once
+	w, _ = request(router, http.MethodPost, "/users/sessions/mfa", "", gin.H{"mfa_token": response["mfa_token"], "recovery_code": "aaaaa-bbbbb"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, store.mfa["user-2"].RecoveryCodes)
+}
+
+func TestRecentMFA(t *testing.T) {
+	store := newTestStore(testUser("user-1", "ada"))
+	secret := store.enableTOTP(t, "user-1", "aaaaa-bbbbb")
+	router, handler := testRouter(store)
+	user := store.users["user-1"]
+
+	// the session was logged in with a second factor, but not recently
+	stale, err := handler.createAccessToken(user, true, time.Now().Add(-middleware.RecentMFAWindow-time.Minute))
+	assert.Nil(t, err)
+	loggedIn, err := handler.createAccessToken(user, true, time.Time{})
+	assert.Nil(t, err)
+
+	for _, token := range []string{stale, loggedIn} {
+		w, _ := request(router, http.MethodDelete, "/users/mfa/totp", "Bearer "+token, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w, _ = request(router, http.MethodPost, "/users/mfa/recovery-codes", "Bearer "+token, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+	assert.True(t, store.mfa["user-1"].TOTPEnabled)
+
+	// verifying the factor again issues a token which allows sensitive actions
+	w, response := request(router, http.MethodPost, "/users/mfa/challenge", "Bearer "+stale, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w, response = request(router, http.MethodPost, "/users/mfa/verify", "Bearer "+stale, gin.H{"mfa_token": response["mfa_token"], "code": totpCode(secret, 0)})
+	assert.Equal(t, http.StatusOK, w.Code)
+	recent := response["access_token"].(string)
+
+	// challenges of other users can not be completed
+	other, _ := handler.createAccessToken(testUser("user-2", "grace"), false, time.Time{})
+	store.users["user-2"] = testUser("user-2", "grace")
+	store.enableTOTP(t, "user-2", "ccccc-ddddd")
+	_, response = request(router, http.MethodPost, "/users/mfa/challenge", "Bearer "+stale, nil)
+	w, _ = request(router, http.MethodPost, "/users/mfa/verify", "Bearer "+other, gin.H{"mfa_token": response["mfa_token"], "recovery_code": "aaaaa-bbbbb"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// removing the last factor removes the recovery codes
+	w, _ = request(router, http.MethodDelete, "/users/mfa/totp", "Bearer "+recent, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, store.mfa["user-1"].TOTPEnabled)
+	assert.Empty(t, store.mfa["user-1"].RecoveryCodes)
+
+	// users without a second factor don't need one
+	w, _ = request(router, http.MethodPost, "/users/mfa/totp", "Bearer "+stale, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	store := newTestStore(testUser("user-1", "ada"))
+	router, handler := testRouter(store)
+	token, err := handler.createAccessToken(store.users["user-1"], false, time.Time{})
+	assert.Nil(t, err)
+
+	w, _ := request(router, http.MethodPost, "/users/mfa/totp/verify", "Bearer "+token, gin.H{"code": "123456"})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "enrollment has not been started")
+
+	w, response := request(router, http.MethodPost, "/users/mfa/totp", "Bearer "+token, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	secret := response["secret"].(string)
+	assert.Contains(t, response["uri"], "secret="+secret)
+
+	// the secret is encrypted at rest and is not enabled until a code is verified
+	stored := store.mfa["user-1"]
+	assert.True(t, auth.Encrypted(stored.TOTPSecret))
+	assert.NotContains(t, stored.TOTPSecret, secret)
+	assert.False(t, stored.TOTPEnabled)
+
+	w, _ = request(router, http.MethodPost, "/users/mfa/totp/verify", "Bearer "+token, gin.H{"code": "12345"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.False(t, store.mfa["user-1"].TOTPEnabled)
+
+	w, response = request(router, http.MethodPost, "/users/mfa/totp/verify", "Bearer "+token, gin.H{"code": totpCode(secret, 0)})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, response["totp_enabled"])
+	assert.Len(t, response["recovery_codes"], auth.RecoveryCodeCount)
+	assert.True(t, store.mfa["user-1"].TOTPEnabled)
+	assert.Len(t, store.mfa["user-1"].RecoveryCodes, auth.RecoveryCodeCount)
+
+	// the token was issued without a second factor, changes now require one
+	w, _ = request(router, http.MethodPost, "/users/mfa/totp", "Bearer "+token, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// plaintext secrets are not used
+	store.mfa["user-1"].TOTPSecret = secret
+	_, response = request(router, http.MethodPost, "/users/sessions", basicAuth("ada", "secret"), nil)
+	w, _ = request(router, http.MethodPost, "/users/sessions/mfa", "", gin.H{"mfa_token": response["mfa_token"], "code": totpCode(secret, 1)})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
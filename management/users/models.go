@@ -45,3 +45,28 @@ func (u *newUserBody) Validate(reCaptchaSecret string) error {
 
 	return nil
 }
+
+// mfaBody is the second factor of a multi-factor challenge, or of a TOTP or WebAuthn enrollment
+type mfaBody struct {
+	MFAToken     string                 `json:"mfa_token"`
+	Code         string                 `json:"code"`
+	RecoveryCode string                 `json:"recovery_code"`
+	WebAuthn     *auth.WebAuthnResponse `json:"webauthn"`
+	// Name is the name of a registered WebAuthn credential
+	Name string `json:"name"`
+}
+
+// Validate checks that exactly one second factor is used to complete a challenge.
+func (m *mfaBody) Validate() error {
+	factors := 0
+	for _, set := range []bool{m.Code != "", m.RecoveryCode != "", m.WebAuthn != nil} {
+		if set {
+			factors++
+		}
+	}
+	if factors != 1 {
+		return errors.New("one of code, recovery_code or webauthn is required")
+	}
+
+	return nil
+}
@@ -5,14 +5,15 @@ import (
 	"github.com/go-redis/redis"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/events"
 	"github.com/machinable/machinable/middleware"
 )
 
 // SetRoutes sets all of the appropriate routes to handlers for users
-func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, cache redis.UniversalClient, config *config.AppConfig) error {
-	handler := New(datastore, cache, config)
+func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, cache redis.UniversalClient, bus events.EventBus, config *config.AppConfig) error {
+	handler := New(datastore, cache, bus, config)
 
-	appUserMiddleware := middleware.AppUserJwtAuthzMiddleware(config)
+	appUserMiddleware := middleware.AppUserJwtAuthzMiddleware(datastore, config)
 	// user endpoints
 	users := engine.Group("/users")
 	users.GET("/", appUserMiddleware, handler.GetUser)
@@ -24,8 +25,22 @@ func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, cache redis.U
 	users.GET("/sessions", appUserMiddleware, handler.ListUserSessions)
 	users.GET("/sessions/:sessionID", appUserMiddleware, handler.GetSession)
 	users.DELETE("/sessions/:sessionID", appUserMiddleware, handler.RevokeSession)
-	users.POST("/refresh", middleware.ValidateRefreshToken(datastore, config), handler.RefreshToken)
+	users.POST("/refresh", middleware.ValidateAppRefreshToken(datastore, config), handler.RefreshToken)
 	users.POST("/password", appUserMiddleware, handler.ResetPassword)
 
+	// multi-factor authentication, changes to the factors of enrolled users require a recently verified factor
+	recentMFA := middleware.AppUserRecentMFAMiddleware(datastore)
+	users.POST("/sessions/mfa", handler.LoginUserMFA)
+	users.GET("/mfa", appUserMiddleware, handler.GetMFA)
+	users.POST("/mfa/challenge", appUserMiddleware, handler.CreateMFAChallenge)
+	users.POST("/mfa/verify", appUserMiddleware, handler.VerifyMFA)
+	users.POST("/mfa/totp", appUserMiddleware, recentMFA, handler.EnrollTOTP)
+	users.POST("/mfa/totp/verify", appUserMiddleware, recentMFA, handler.ConfirmTOTP)
+	users.DELETE("/mfa/totp", appUserMiddleware, recentMFA, handler.DisableTOTP)
+	users.POST("/mfa/recovery-codes", appUserMiddleware, recentMFA, handler.RegenerateRecoveryCodes)
+	users.POST("/mfa/webauthn", appUserMiddleware, recentMFA, handler.BeginWebAuthnRegistration)
+	users.POST("/mfa/webauthn/register", appUserMiddleware, recentMFA, handler.FinishWebAuthnRegistration)
+	users.DELETE("/mfa/webauthn/:credentialID", appUserMiddleware, recentMFA, handler.DeleteWebAuthnCredential)
+
 	return nil
 }
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/events"
+)
+
+// OutboxEvent returns the event for a write to the requested document or json key. The event is stored in the same
+// transaction as the write, returns `nil` if hooks have been disabled with the `X-Trigger-Hooks: false` request header.
+func OutboxEvent(c *gin.Context, action string) *models.OutboxEvent {
+	if c.Request.Header.Get("X-Trigger-Hooks") == "false" {
+		return nil
+	}
+
+	return &models.OutboxEvent{
+		Entity:    c.GetString("endpointType"),
+		EntityKey: c.GetString("entityKey"),
+		EntityID:  c.GetString("entityID"),
+		Action:    action,
+	}
+}
+
+// ValidateWrite calls the "before" hooks of the project for the write, returning the payload to be written. The hooks can
+// not be disabled by the client. If the write is rejected the error is written to the response and `false` is returned.
+func ValidateWrite(c *gin.Context, validator *events.Validator, action string, keys []string, payload []byte) ([]byte, bool) {
+	validated, err := BeforeHooks(c, validator, action, keys, payload)
+	if hErr, ok := err.(*events.HookError); ok {
+		respondWithError(hErr.StatusCode, hErr.Message, c)
+		return nil, false
+	}
+	if err != nil {
+		respondWithError(http.StatusInternalServerError, err.Error(), c)
+		return nil, false
+	}
+
+	return validated, true
+}
+
+// BeforeHooks calls the "before" hooks of the project for the write and returns the payload to be written, without
+// writing the response. A `*events.HookError` is returned if the write is rejected. The payload of a write is the value
+// written at the key path, or the document.
+func BeforeHooks(c *gin.Context, validator *events.Validator, action string, keys []string, payload []byte) ([]byte, error) {
+	if validator == nil {
+		return payload, nil
+	}
+
+	event, err := hookEvent(c, action, keys)
+	if err != nil {
+		return nil, err
+	}
+	event.Payload = payload
+
+	return validator.Validate(event)
+}
+
+// ValidateDocument calls the "before" hooks of the project for the write of the document, returning the document to be
+// written. If the write is rejected the error is written to the response and `false` is returned.
+func ValidateDocument(c *gin.Context, validator *events.Validator, action string, document models.ResourceObject) (models.ResourceObject, bool) {
+	if validator == nil {
+		return document, true
+	}
+
+	event, err := hookEvent(c, action, nil)
+	if err == nil {
+		document, err = validator.ValidateDocument(event, document)
+	}
+	if hErr, ok := err.(*events.HookError); ok {
+		respondWithError(hErr.StatusCode, hErr.Message, c)
+		return nil, false
+	}
+	if err == events.ErrInvalidDocument {
+		respondWithError(http.StatusBadGateway, err.Error(), c)
+		return nil, false
+	}
+	if err != nil {
+		respondWithError(http.StatusInternalServerError, err.Error(), c)
+		return nil, false
+	}
+
+	return document, true
+}
+
+// hookEvent returns the event of the "before" hooks for a write to the requested document or json key
+func hookEvent(c *gin.Context, action string, keys []string) (*events.Event, error) {
+	projecti, exists := c.Get("projectObject")
+	if !exists {
+		return nil, &events.HookError{StatusCode: http.StatusBadRequest, Message: "malformed request - invalid project"}
+	}
+
+	return &events.Event{
+		Project:   projecti.(*models.ProjectDetail),
+		Entity:    c.GetString("endpointType"),
+		EntityKey: c.GetString("entityKey"),
+		EntityID:  c.GetString("entityID"),
+		Action:    action,
+		Keys:      keys,
+	}, nil
+}
@@ -17,7 +17,7 @@ func SubDomainMiddleware() gin.HandlerFunc {
 
 		hostParts := strings.Split(c.Request.Host, ".")
 		if len(hostParts) < 3 {
-			c.AbortWithStatus(404)
+			respondWithError(http.StatusNotFound, "project not found", c)
 			return
 		}
 
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/config"
+)
+
+// ClientIP returns the address of the client. The forwarding headers can be set by anybody, so they are only used if
+// the request comes from a trusted proxy. The `X-Forwarded-For` chain is read from the right, the first address which
+// is not a trusted proxy is the client.
+func ClientIP(c *gin.Context, config *config.AppConfig) string {
+	remote := remoteAddr(c)
+	if !trustedProxy(remote, config.TrustedProxies) {
+		return remote
+	}
+
+	if forwarded := c.GetHeader("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if !trustedProxy(hop, config.TrustedProxies) {
+				return hop
+			}
+		}
+		// every hop is a proxy, the leftmost is the closest to the client
+		return strings.TrimSpace(hops[0])
+	}
+	if realIP := strings.TrimSpace(c.GetHeader("X-Real-Ip")); realIP != "" {
+		return realIP
+	}
+
+	return remote
+}
+
+// RequestScheme returns the scheme the client used for the request, `X-Forwarded-Proto` is only used if the request
+// comes from a trusted proxy
+func RequestScheme(c *gin.Context, config *config.AppConfig) string {
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	if trustedProxy(remoteAddr(c), config.TrustedProxies) && strings.TrimSpace(c.GetHeader("X-Forwarded-Proto")) == "https" {
+		return "https"
+	}
+	return "http"
+}
+
+// remoteAddr returns the address of the peer of the connection, the client or a proxy
+func remoteAddr(c *gin.Context) string {
+	remote, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		return strings.TrimSpace(c.Request.RemoteAddr)
+	}
+	return remote
+}
+
+// trustedProxy returns true if the address is one of the proxies, which are addresses or CIDR ranges
+func trustedProxy(ip string, proxies []string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if proxyAddr := net.ParseIP(proxy); proxyAddr != nil && proxyAddr.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
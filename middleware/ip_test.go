@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/auth"
+	"github.com/machinable/machinable/config"
+	"github.com/machinable/machinable/dsi/errors"
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// apiKeyStore serves a project with one API key, unused functions panic
+type apiKeyStore struct {
+	interfaces.Datastore
+	key *models.ProjectAPIKey
+}
+
+func (s *apiKeyStore) GetProjectDetailBySlug(slug string) (*models.ProjectDetail, error) {
+	return &models.ProjectDetail{ID: "project", Slug: slug}, nil
+}
+
+func (s *apiKeyStore) ListHooks(projectID string) ([]*models.WebHook, *errors.DatastoreError) {
+	return nil, nil
+}
+
+func (s *apiKeyStore) GetAPIKeyByKeyID(projectID, keyID string) (*models.ProjectAPIKey, error) {
+	return s.key, nil
+}
+
+func (s *apiKeyStore) UpdateAPIKeyLastUsed(projectID, keyID string, lastUsed time.Time) error {
+	return nil
+}
+
+func TestAPIKeyAllowedIPs(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	apiKey, _, _ := auth.GenerateAPIKey()
+	store := &apiKeyStore{key: &models.ProjectAPIKey{
+		ID:         "key",
+		KeyHash:    auth.HashAPIKey(apiKey, "secret"),
+		Role:       auth.RoleUser,
+		Read:       true,
+		AllowedIPs: []string{"10.1.0.0/16"},
+	}}
+	config := &config.AppConfig{APIKeySecret: "secret", TrustedProxies: []string{"192.168.0.0/24"}}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("project", "blog") })
+	router.GET("/", ProjectRequesterMiddleware(store, config), func(c *gin.Context) {
+		c.String(http.StatusOK, ClientIP(c, config))
+	})
+
+	tables := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		statusCode int
+	}{
+		{"allowed address", "10.1.2.3:4000", "", "", http.StatusOK},
+		{"other address", "203.0.113.5:4000", "", "", http.StatusForbidden},
+		{"forged x-forwarded-for", "203.0.113.5:4000", "10.1.2.3", "", http.StatusForbidden},
+		{"forged x-real-ip", "203.0.113.5:4000", "", "10.1.2.3", http.StatusForbidden},
+		{"trusted proxy", "192.168.0.10:4000", "10.1.2.3", "", http.StatusOK},
+		{"trusted proxies", "192.168.0.10:4000", "10.1.2.3, 192.168.0.11", "", http.StatusOK},
+		{"forged through trusted proxy", "192.168.0.10:4000", "10.1.2.3, 203.0.113.5", "", http.StatusForbidden},
+		{"trusted proxy real ip", "192.168.0.10:4000", "", "10.1.2.3", http.StatusOK},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("Authorization", "apikey "+apiKey)
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-Ip", tt.realIP)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.statusCode == http.StatusOK {
+				assert.Equal(t, "10.1.2.3", w.Body.String())
+			}
+		})
+	}
+}
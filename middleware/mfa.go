@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/dsi/interfaces"
+)
+
+// RecentMFAWindow is how long after using their second factor app users can perform sensitive actions
+const RecentMFAWindow = 10 * time.Minute
+
+// setMFAClaims injects the multi-factor authentication of an app user access token into the context. `mfa` is set for
+// sessions logged in with a second factor, `mfa_time` is only set on access tokens issued when the factor was used.
+func setMFAClaims(c *gin.Context, claims jwt.MapClaims) {
+	mfa, _ := claims["mfa"].(bool)
+	c.Set("mfa", mfa)
+	if mfaTime, ok := claims["mfa_time"].(float64); ok {
+		c.Set("mfa_time", time.Unix(int64(mfaTime), 0))
+	}
+}
+
+// RecentMFA returns true if the app user of the request used their second factor within the `RecentMFAWindow`
+func RecentMFA(c *gin.Context) bool {
+	value, _ := c.Get("mfa_time")
+	mfaTime, ok := value.(time.Time)
+	return ok && time.Since(mfaTime) < RecentMFAWindow
+}
+
+// AppUserRecentMFAMiddleware protects sensitive actions, such as deleting projects and creating API keys. App users who
+// have enrolled a second factor must have used it within the `RecentMFAWindow`, users can verify it again with
+// `/users/mfa/verify`. It must follow `AppUserJwtAuthzMiddleware`.
+func AppUserRecentMFAMiddleware(store interfaces.UsersMFADatastore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		if userID == "" {
+			respondWithError(http.StatusUnauthorized, "access token required", c)
+			return
+		}
+
+		mfa, err := store.GetUserMFA(userID)
+		if err != nil {
+			respondWithError(http.StatusInternalServerError, "failed to load multi-factor authentication", c)
+			return
+		}
+
+		if mfa.Enabled() && !RecentMFA(c) {
+			respondWithError(http.StatusForbidden, "recent multi-factor authentication required", c)
+			return
+		}
+
+		c.Next()
+	}
+}
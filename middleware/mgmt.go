@@ -6,16 +6,14 @@ import (
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/auth"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
 )
 
 func respondWithError(code int, message string, c *gin.Context) {
-	resp := map[string]string{"error": message}
-
-	c.JSON(code, resp)
-	c.Abort()
+	apierror.Respond(c, code, message)
 }
 
 // ProjectIDAuthzMiddleware retrieves the project ID
@@ -40,6 +38,8 @@ func ProjectIDAuthzMiddleware(store interfaces.Datastore) gin.HandlerFunc {
 		c.Set("projectPath", project.Slug)
 		c.Set("projectIcon", project.Icon)
 		c.Set("projectDescription", project.Description)
+		c.Set("projectUserRegistration", project.UserRegistration)
+		c.Set("projectUserVerification", project.UserVerification)
 		c.Next()
 		return
 	}
@@ -47,7 +47,7 @@ func ProjectIDAuthzMiddleware(store interfaces.Datastore) gin.HandlerFunc {
 
 // AppUserProjectAuthzMiddleware validates this app user has access to the project
 func AppUserProjectAuthzMiddleware(store interfaces.Datastore, config *config.AppConfig) gin.HandlerFunc {
-	jwtAuth := auth.NewJWT(config)
+	jwtAuth := auth.NewJWT(config, store)
 	return func(c *gin.Context) {
 		if values, _ := c.Request.Header["Authorization"]; len(values) > 0 {
 
@@ -88,6 +88,13 @@ func AppUserProjectAuthzMiddleware(store interfaces.Datastore, config *config.Ap
 					return
 				}
 
+				// the project requires app users to log in with a second factor
+				setMFAClaims(c, claims)
+				if project.MFARequired && !c.GetBool("mfa") {
+					respondWithError(http.StatusForbidden, "project requires multi-factor authentication", c)
+					return
+				}
+
 				_, ok = projects[projectSlug]
 				if !ok {
 					// the project is not in the claims, look in the database in case it was created with the last 5 minutes
@@ -116,8 +123,8 @@ func AppUserProjectAuthzMiddleware(store interfaces.Datastore, config *config.Ap
 }
 
 // AppUserJwtAuthzMiddleware authorizes the JWT in the Authorization header for application users
-func AppUserJwtAuthzMiddleware(config *config.AppConfig) gin.HandlerFunc {
-	jwtAuth := auth.NewJWT(config)
+func AppUserJwtAuthzMiddleware(store interfaces.Datastore, config *config.AppConfig) gin.HandlerFunc {
+	jwtAuth := auth.NewJWT(config, store)
 	return func(c *gin.Context) {
 
 		if values, _ := c.Request.Header["Authorization"]; len(values) > 0 {
@@ -157,6 +164,7 @@ func AppUserJwtAuthzMiddleware(config *config.AppConfig) gin.HandlerFunc {
 				c.Set("projects", projects)
 				c.Set("user_id", user["id"])
 				c.Set("username", user["name"])
+				setMFAClaims(c, claims)
 
 				c.Set("authType", "admin")
 				c.Set("authString", user["name"])
@@ -176,58 +184,102 @@ func AppUserJwtAuthzMiddleware(config *config.AppConfig) gin.HandlerFunc {
 	}
 }
 
-// ValidateRefreshToken validates the refresh token
+// ValidateRefreshToken validates the refresh token of a project user, which is signed with the keys of the project
 func ValidateRefreshToken(store interfaces.Datastore, config *config.AppConfig) gin.HandlerFunc {
-	jwtAuth := auth.NewJWT(config)
+	jwtAuth := auth.NewJWT(config, store)
 	return func(c *gin.Context) {
 
 		if values, _ := c.Request.Header["Authorization"]; len(values) > 0 {
 
-			tokenString := strings.Split(values[0], " ")[1]
-			token, err := jwt.Parse(tokenString, jwtAuth.TokenLookup)
+			projectSlug := c.GetString("project")
+			if projectSlug == "" {
+				respondWithError(http.StatusUnauthorized, "invalid project", c)
+				return
+			}
 
-			if err == nil {
-				// token is valid, validate it's a refresh token
-				claims := token.Claims.(jwt.MapClaims)
+			// load the project, refresh tokens are signed with the keys of the project
+			project, err := store.GetProjectBySlug(projectSlug)
+			if err != nil {
+				respondWithError(http.StatusNotFound, "project not found", c)
+				return
+			}
 
-				sessionID, ok := claims["session_id"].(string)
-				if !ok {
-					respondWithError(http.StatusUnauthorized, "invalid refresh token", c)
-					return
-				}
+			claims, ok := refreshClaims(values[0], jwtAuth.Project(project.ID))
+			if !ok {
+				respondWithError(http.StatusUnauthorized, "invalid refresh token", c)
+				return
+			}
 
-				userID, ok := claims["user_id"].(string)
-				if !ok {
-					respondWithError(http.StatusUnauthorized, "invalid refresh token", c)
-					return
-				}
-				projectSlug := c.GetString("project")
-				if projectSlug == "" {
-					respondWithError(http.StatusUnauthorized, "invalid project", c)
-					return
-				}
+			// inject claims into context
+			c.Set("projectId", project.ID)
+			c.Set("project", project.Slug)
+			c.Set("session_id", claims.sessionID)
+			c.Set("user_id", claims.userID)
+			c.Set("refresh_token_id", claims.tokenID)
 
-				// // load the project and check the authn policy
-				project, err := store.GetProjectBySlug(projectSlug)
-				if err != nil {
-					respondWithError(http.StatusNotFound, "project not found", c)
-					return
-				}
-				// inject claims into context
-				c.Set("projectId", project.ID)
-				c.Set("project", project.Name)
-				c.Set("session_id", sessionID)
-				c.Set("user_id", userID)
+			c.Next()
+			return
+		}
 
-				c.Next()
+		respondWithError(http.StatusUnauthorized, "refresh token required", c)
+		return
+	}
+}
+
+// ValidateAppRefreshToken validates the refresh token of an application user, which is signed with the application keys
+func ValidateAppRefreshToken(store interfaces.Datastore, config *config.AppConfig) gin.HandlerFunc {
+	jwtAuth := auth.NewJWT(config, store)
+	return func(c *gin.Context) {
+		if values, _ := c.Request.Header["Authorization"]; len(values) > 0 {
+			claims, ok := refreshClaims(values[0], jwtAuth)
+			if !ok {
+				respondWithError(http.StatusUnauthorized, "invalid refresh token", c)
 				return
 			}
 
-			respondWithError(http.StatusUnauthorized, "invalid refresh token", c)
+			// inject claims into context
+			c.Set("session_id", claims.sessionID)
+			c.Set("user_id", claims.userID)
+			c.Set("refresh_token_id", claims.tokenID)
+
+			c.Next()
 			return
 		}
 
 		respondWithError(http.StatusUnauthorized, "refresh token required", c)
-		return
 	}
 }
+
+// refreshTokenClaims are the claims of a valid refresh token
+type refreshTokenClaims struct {
+	sessionID string
+	userID    string
+	tokenID   string // tokenID is empty for refresh tokens created before tokens were rotated
+}
+
+// refreshClaims parses the bearer refresh token of the authorization header
+func refreshClaims(header string, jwtAuth *auth.JWT) (*refreshTokenClaims, bool) {
+	parts := strings.Split(header, " ")
+	if len(parts) != 2 {
+		return nil, false
+	}
+
+	token, err := jwt.Parse(parts[1], jwtAuth.TokenLookup)
+	if err != nil {
+		return nil, false
+	}
+
+	// token is valid, validate it's a refresh token
+	claims := token.Claims.(jwt.MapClaims)
+	sessionID, ok := claims["session_id"].(string)
+	if !ok {
+		return nil, false
+	}
+	userID, ok := claims["user_id"].(string)
+	if !ok {
+		return nil, false
+	}
+	tokenID, _ := claims["jti"].(string)
+
+	return &refreshTokenClaims{sessionID: sessionID, userID: userID, tokenID: tokenID}, true
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/machinable/machinable/auth"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
 )
 
 // Resources is the constant value for the URL parameter
@@ -28,6 +30,9 @@ var BEARER = "bearer"
 // APIKEY is the key for the apikey authorization token
 var APIKEY = "apikey"
 
+// apiKeyLastUsedInterval is how often the last used time of an api key is updated
+const apiKeyLastUsedInterval = time.Minute
+
 // StoreConfig holds the middleware-relevant config for a collection/resource
 type StoreConfig struct {
 	Create        bool
@@ -46,7 +51,7 @@ func (s *StoreConfig) VerbRequiresAuthn(verb string) (bool, error) {
 		return s.Create, nil
 	case "GET":
 		return s.Read, nil
-	case "PUT":
+	case "PUT", "PATCH":
 		return s.Update, nil
 	case "DELETE":
 		return s.Delete, nil
@@ -76,14 +81,16 @@ func ProjectUserRegistrationMiddleware(store interfaces.Datastore) gin.HandlerFu
 
 		// inject projectId
 		c.Set("projectId", prj.ID)
+		c.Set("projectName", prj.Name)
+		c.Set("projectUserVerification", prj.UserVerification)
 
 		c.Next()
 	}
 }
 
 // ProjectAuthzBuildFiltersMiddleware builds the necessary filters based on the requester's role, permissions, as well as
-// the collection/resource's access policies. This middleware requires that the requester `role` has been injected into
-// the context.
+// the collection/resource's access policies. This middleware requires that the `requester` has been injected into the
+// context.
 func ProjectAuthzBuildFiltersMiddleware(store interfaces.Datastore) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// get project from context, inserted into context from subdomain
@@ -104,76 +111,223 @@ func ProjectAuthzBuildFiltersMiddleware(store interfaces.Datastore) gin.HandlerF
 			respondWithError(http.StatusNotImplemented, "unexpected HTTP verb when checking for authentication", c)
 			return
 		}
-		// if this verb does not require authn, or the user is creating an object (no need for creator filter), let it on by!
-		if !requiresAuthn || verb == "POST" {
+		// if this verb does not require authn, let it on by!
+		if !requiresAuthn {
 			c.Set("filters", filters)
 			c.Next()
 			return
 		}
 
-		rRole := c.GetString("authRole")
-		rID := c.GetString("authID")
+		requester, ok := c.Value("requester").(*Requester)
+		if !ok {
+			respondWithError(http.StatusBadRequest, "malformed request - invalid requester", c)
+			return
+		}
+
+		filters, err = authzFilters(storeConfig, verb, c.GetString("entityKey"), requester)
+		if err != nil {
+			// unknown role or unsatisfiable role conditions, cancel request
+			respondWithError(http.StatusForbidden, err.Error(), c)
+			return
+		}
+
+		c.Set("filters", filters)
+		c.Next()
+	}
+}
+
+// authzFilters builds the creator filters based on the requester's role and the access policies of the store. The
+// conditions of custom roles replace the creator filter, they are also returned for POST to verify created documents.
+func authzFilters(storeConfig StoreConfig, verb, pathName string, requester *Requester) (map[string]interface{}, error) {
+	if requester.CustomRole != nil {
+		return requester.roleFilters(pathName, verb)
+	}
 
-		// based on the requester's role and resource access policies, build filters
-		if rRole == auth.RoleUser {
-			if verb == "GET" && storeConfig.ParallelRead == false {
-				filters["_metadata.creator"] = rID
-			} else if (verb == "PUT" || verb == "DELETE") && storeConfig.ParallelWrite == false {
-				filters["_metadata.creator"] = rID
+	filters := map[string]interface{}{}
+	role, id := requester.Role, requester.ID
+
+	// based on the requester's role and resource access policies, build filters. Documents shared with the user are
+	// read and updated along with the documents it created, only the creator can delete a document.
+	if role == auth.RoleUser {
+		if verb == "GET" && storeConfig.ParallelRead == false {
+			filters[models.FilterDocumentAccess] = requester.documentAccess(false)
+		} else if verb == "PUT" && storeConfig.ParallelWrite == false {
+			filters[models.FilterDocumentAccess] = requester.documentAccess(true)
+		} else if verb == "DELETE" && storeConfig.ParallelWrite == false {
+			filters["_metadata.creator"] = id
+		}
+
+		return filters, nil
+	} else if role == auth.RoleAdmin {
+		// `admin` role:
+		//    no filter needed
+		return filters, nil
+	}
+
+	return nil, errors.New("unknown role")
+}
+
+// documentAccess returns the filter of the documents created by or shared with the requester
+func (r *Requester) documentAccess(write bool) *models.DocumentAccess {
+	return &models.DocumentAccess{UserID: r.ID, Groups: r.Groups, Write: write}
+}
+
+// roleFilters compiles the conditions of the custom role's permission for the verb on the resource to filters
+func (r *Requester) roleFilters(pathName, verb string) (map[string]interface{}, error) {
+	permission := r.CustomRole.ResourcePermission(pathName, verb)
+	if permission == nil {
+		return nil, fmt.Errorf("role '%s' does not have permission to '%s' '%s'", r.Role, verb, pathName)
+	}
+
+	conditions, err := permission.RowConditions()
+	if err != nil {
+		return nil, err
+	}
+
+	filters := map[string]interface{}{}
+	for _, condition := range conditions {
+		value := condition.Value
+		if condition.Attribute != "" {
+			// a requester without the attribute does not match any document
+			attribute, ok := r.attribute(condition.Attribute)
+			if !ok {
+				return nil, fmt.Errorf("role '%s' requires the '%s' attribute", r.Role, condition.Attribute)
 			}
+			value = attribute
+		}
 
-			c.Set("filters", filters)
-			c.Next()
-			return
-		} else if rRole == auth.RoleAdmin {
-			// `admin` role:
-			//    no filter needed
-			c.Set("filters", filters)
-			c.Next()
-			return
+		if existing, ok := filters[condition.Field]; ok && existing != value {
+			return nil, fmt.Errorf("role '%s' conditions on '%s' cannot be satisfied", r.Role, condition.Field)
 		}
+		filters[condition.Field] = value
+	}
 
-		// unknown role, cancel request
-		respondWithError(http.StatusForbidden, "unknown role", c)
-		return
+	return filters, nil
+}
+
+// attribute returns the requester attribute of a role condition as it is compared with document fields
+func (r *Requester) attribute(name string) (string, bool) {
+	switch name {
+	case "id":
+		return r.ID, true
+	case "name":
+		return r.Name, true
+	case "role":
+		return r.Role, true
 	}
+	return filterValue(r.Attributes[name])
+}
+
+// filterValue formats a JSON value the way it is compared by the datastore filters, objects and arrays cannot be
+// compared
+func filterValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// DocumentMatchesFilters returns true if the fields of a created or updated document have the values of the data
+// filters, so role conditions cannot be bypassed by writing documents outside of them. Metadata filters are set by the
+// datastore and are not compared.
+func DocumentMatchesFilters(fields models.ResourceObject, filters map[string]interface{}) bool {
+	for key, expected := range filters {
+		if key == "_id" || strings.HasPrefix(key, "_metadata.") {
+			continue
+		}
+		value, ok := filterValue(fields[key])
+		if !ok || value != expected {
+			return false
+		}
+	}
+	return true
+}
+
+// Requester is the authenticated project user or api key of a request, along with its read/write permissions
+type Requester struct {
+	Type       string // user, apikey, anonymous
+	Name       string
+	ID         string
+	Role       string
+	Read       bool
+	Write      bool
+	Scopes     models.APIKeyScopes    // Scopes limit the resources and root keys of scoped api keys
+	Attributes map[string]interface{} // Attributes of project users are compared with documents by role conditions
+	Groups     []string               // Groups of project users are granted access to shared documents
+	CustomRole *models.Role           // CustomRole is the project role of requesters without a built-in role
+}
+
+// anonymousRequester is the requester of requests without an Authorization header
+func anonymousRequester() *Requester {
+	return &Requester{Type: "anonymous", Name: "anonymous", ID: "anonymous", Role: "anonymous"}
+}
+
+// Anonymous returns true if the request did not include credentials
+func (r *Requester) Anonymous() bool {
+	return r.Type == "anonymous"
+}
+
+// Allows returns true if the requester has permission to the HTTP verb
+func (r *Requester) Allows(verb string) bool {
+	switch verb {
+	case "GET":
+		return r.Read
+	case "POST", "PUT", "PATCH", "DELETE":
+		return r.Write
+	default:
+		return false
+	}
+}
+
+// AllowsResource returns true if the requester's scopes include the HTTP verb on the resource
+func (r *Requester) AllowsResource(pathName, verb string) bool {
+	return r.Scopes.AllowsResource(pathName, verb)
+}
+
+// AllowsRootKey returns true if the requester's scopes include the HTTP verb on the JSON root key
+func (r *Requester) AllowsRootKey(rootKey, verb string) bool {
+	return r.Scopes.AllowsRootKey(rootKey, verb)
+}
+
+// RoleAllowsResource returns true if the requester's custom role, if any, grants the HTTP verb on the resource
+func (r *Requester) RoleAllowsResource(pathName, verb string) bool {
+	return r.CustomRole == nil || r.CustomRole.ResourcePermission(pathName, verb) != nil
+}
+
+// RoleAllowsRootKey returns true if the requester's custom role, if any, grants the HTTP verb on the JSON root key
+func (r *Requester) RoleAllowsRootKey(rootKey, verb string) bool {
+	return r.CustomRole == nil || r.CustomRole.RootKeyPermission(rootKey, verb) != nil
+}
+
+// setContext injects the requester claims into the context
+func (r *Requester) setContext(c *gin.Context) {
+	c.Set("authType", r.Type)
+	c.Set("authString", r.Name)
+	c.Set("authID", r.ID)
+	c.Set("authRole", r.Role)
+	c.Set("requester", r)
 }
 
 // ProjectUserAuthzMiddleware authenticates the JWT and verifies the requesting user has access to this project. This middleware
 // requires that the `project` has been injected into the context.
 func ProjectUserAuthzMiddleware(store interfaces.Datastore, config *config.AppConfig) gin.HandlerFunc {
-	jwtAuth := auth.NewJWT(config)
+	jwtAuth := auth.NewJWT(config, store)
 	return func(c *gin.Context) {
 		// get request method
 		verb := c.Request.Method
-		// get project slug
-		// get project from context, inserted into context from subdomain
-		projectSlug := c.GetString("project")
-		if projectSlug == "" {
-			respondWithError(http.StatusUnauthorized, "invalid project", c)
-			return
-		}
 
-		// load the project and check the authn policy
-		project, err := store.GetProjectDetailBySlug(projectSlug)
-		if err != nil {
-			respondWithError(http.StatusNotFound, "project not found", c)
+		project, ok := loadProjectDetail(c, store)
+		if !ok {
 			return
 		}
 
-		// TODO: query hooks as part of project detail view
-		hooks, lErr := store.ListHooks(project.ID)
-		if lErr != nil {
-			respondWithError(http.StatusNotFound, fmt.Sprintf("error loading project details: %s", lErr.Error()), c)
-			return
-		}
-		project.Hooks = hooks
-
-		c.Set("projectObject", project)
-		c.Set("projectId", project.ID)
-		c.Set("accountRequestLimit", project.Requests)
-		c.Set("accountId", project.UserID)
-
 		// load resource access policies
 		params := strings.Split(c.Request.URL.Path, "/")
 
@@ -189,7 +343,6 @@ func ProjectUserAuthzMiddleware(store interfaces.Datastore, config *config.AppCo
 		if storeType == Resources {
 			resourceName := params[2]
 			// TODO: Perhaps move this to a view with the project so we only make one DB query?
-			// TODO: put this into the context to be used to validate object later (for PUT and POST)
 			def, err := store.GetDefinitionByPathName(project.ID, resourceName)
 			if err != nil {
 				respondWithError(http.StatusNotFound, "error retrieving resource - does not exist", c)
@@ -197,12 +350,8 @@ func ProjectUserAuthzMiddleware(store interfaces.Datastore, config *config.AppCo
 			}
 			c.Set("entityID", def.ID)
 			c.Set("entityKey", resourceName)
-			storeConfig.Create = def.Create
-			storeConfig.Read = def.Read
-			storeConfig.Update = def.Update
-			storeConfig.Delete = def.Delete
-			storeConfig.ParallelRead = def.ParallelRead
-			storeConfig.ParallelWrite = def.ParallelWrite
+			c.Set("resourceDefinition", def)
+			storeConfig = resourceStoreConfig(def)
 		} else if storeType == JSONKey {
 			rootKeyStr := params[2]
 			rootKey, err := store.GetRootKey(project.ID, rootKeyStr)
@@ -216,6 +365,12 @@ func ProjectUserAuthzMiddleware(store interfaces.Datastore, config *config.AppCo
 			storeConfig.Read = rootKey.Read
 			storeConfig.Update = rootKey.Update
 			storeConfig.Delete = rootKey.Delete
+
+			// path rules replace the access flags, anonymous requests are authorized by `JSONRulesMiddleware`
+			if len(rootKey.Rules) > 0 {
+				c.Set("jsonRules", rootKey.Rules)
+				storeConfig = StoreConfig{}
+			}
 		} else {
 			respondWithError(http.StatusNotFound, "not found", c)
 			return
@@ -225,120 +380,36 @@ func ProjectUserAuthzMiddleware(store interfaces.Datastore, config *config.AppCo
 		c.Set("storeConfig", storeConfig)
 
 		// validate Authorization header
-		if values, _ := c.Request.Header["Authorization"]; len(values) > 0 {
-
-			vals := strings.Split(values[0], " ")
-
-			authType := strings.ToLower(vals[0])
-
-			if authType == BEARER {
-				tokenString := vals[1]
-				token, err := jwt.Parse(tokenString, jwtAuth.TokenLookup)
-
-				if err == nil {
-
-					// token is valid, get claims and perform authorization
-					claims := token.Claims.(jwt.MapClaims)
-
-					projects, ok := claims["projects"].(map[string]interface{})
-					if !ok {
-						respondWithError(http.StatusUnauthorized, "improperly formatted access token", c)
-						return
-					}
-
-					_, ok = projects[projectSlug]
-					if !ok {
-						// project user does not have access to this project
-						respondWithError(http.StatusNotFound, "project not found", c)
-						return
-					}
-
-					user, ok := claims["user"].(map[string]interface{})
-					if !ok {
-						respondWithError(http.StatusUnauthorized, "improperly formatted access token", c)
-						return
-					}
-
-					userType, ok := user["type"].(string)
-					if !ok || userType != "project" {
-						respondWithError(http.StatusUnauthorized, "invalid access token", c)
-						return
-					}
-
-					userIsActive, ok := user["active"].(bool)
-					if !ok || !userIsActive {
-						respondWithError(http.StatusUnauthorized, "user is not active, please confirm your account", c)
-						return
-					}
-
-					// check user permissions
-					perms := map[string]bool{}
-					if user["read"].(bool) {
-						perms["GET"] = true
-					}
-					if user["write"].(bool) {
-						perms["POST"] = true
-						perms["DELETE"] = true
-						perms["PUT"] = true
-						// perms["PATCH"] = true
-					}
-
-					if _, ok := perms[verb]; !ok {
-						respondWithError(http.StatusUnauthorized, fmt.Sprintf("user does not have permission to '%s'", verb), c)
-						return
-					}
-
-					// inject claims into context
-					c.Set("authType", "user")
-					c.Set("authString", user["name"])
-					c.Set("authID", user["id"])
-					c.Set("authRole", user["role"])
-
-					c.Next()
-					return
-				}
-			} else if authType == APIKEY {
-				// authenticate api key
-				if len(vals) < 2 {
-					respondWithError(http.StatusNotFound, "invalid key", c)
-					return
-				}
-				apiKey := vals[1]
-				hashedKey := auth.SHA1(apiKey, config.AppSecret)
-
-				key, err := store.GetAPIKeyByKey(project.ID, hashedKey)
-				if err != nil {
-					respondWithError(http.StatusNotFound, "invalid key", c)
-					return
-				}
-				// check user permissions
-				perms := map[string]bool{}
-				if key.Read {
-					perms["GET"] = true
-				}
-				if key.Write {
-					perms["POST"] = true
-					perms["DELETE"] = true
-					perms["PUT"] = true
-					// perms["PATCH"] = true
-				}
-
-				if _, ok := perms[verb]; !ok {
-					respondWithError(http.StatusUnauthorized, fmt.Sprintf("user does not have permission to '%s'", verb), c)
-					return
-				}
-
-				// inject claims into context
-				c.Set("authType", "apikey")
-				c.Set("authString", key.Description)
-				c.Set("authID", key.ID)
-				c.Set("authRole", key.Role)
-
-				c.Next()
+		requester, status, msg := authenticateRequester(c, store, jwtAuth, config, project)
+		if status != 0 {
+			respondWithError(status, msg, c)
+			return
+		}
+		if requester != nil {
+			if !requester.Allows(verb) {
+				respondWithError(http.StatusUnauthorized, fmt.Sprintf("user does not have permission to '%s'", verb), c)
+				return
+			}
+
+			// scoped api keys only access the resources and root keys of their scopes
+			entityKey := c.GetString("entityKey")
+			if (storeType == Resources && !requester.AllowsResource(entityKey, verb)) ||
+				(storeType == JSONKey && !requester.AllowsRootKey(entityKey, verb)) {
+				respondWithError(http.StatusForbidden, fmt.Sprintf("api key is not scoped to '%s' '%s'", verb, entityKey), c)
+				return
+			}
+
+			// custom roles only access the resources and root keys of their permissions
+			if (storeType == Resources && !requester.RoleAllowsResource(entityKey, verb)) ||
+				(storeType == JSONKey && !requester.RoleAllowsRootKey(entityKey, verb)) {
+				respondWithError(http.StatusForbidden, fmt.Sprintf("role '%s' does not have permission to '%s' '%s'", requester.Role, verb, entityKey), c)
 				return
 			}
 
-			respondWithError(http.StatusUnauthorized, "invalid access token", c)
+			// inject claims into context
+			requester.setContext(c)
+
+			c.Next()
 			return
 		}
 
@@ -349,10 +420,7 @@ func ProjectUserAuthzMiddleware(store interfaces.Datastore, config *config.AppCo
 			return
 		}
 		if !requiresAuthn {
-			c.Set("authType", "anonymous")
-			c.Set("authString", "anonymous")
-			c.Set("authID", "anonymous")
-			c.Set("authRole", "anonymous")
+			anonymousRequester().setContext(c)
 
 			// project does not require authentication, carry on
 			c.Next()
@@ -364,6 +432,297 @@ func ProjectUserAuthzMiddleware(store interfaces.Datastore, config *config.AppCo
 	}
 }
 
+// ProjectRequesterMiddleware authenticates the requester of endpoints which access several resources, i.e. GraphQL.
+// Requests without an Authorization header continue as anonymous, the `requester` is injected into the context and
+// each resource is authorized with `AuthorizeResource`. This middleware requires that the `project` has been injected
+// into the context.
+func ProjectRequesterMiddleware(store interfaces.Datastore, config *config.AppConfig) gin.HandlerFunc {
+	jwtAuth := auth.NewJWT(config, store)
+	return func(c *gin.Context) {
+		project, ok := loadProjectDetail(c, store)
+		if !ok {
+			return
+		}
+
+		requester, status, msg := authenticateRequester(c, store, jwtAuth, config, project)
+		if status != 0 {
+			respondWithError(status, msg, c)
+			return
+		}
+		if requester == nil {
+			requester = anonymousRequester()
+		}
+
+		requester.setContext(c)
+
+		c.Next()
+	}
+}
+
+// AuthorizeResource authorizes the requester for the HTTP verb on the resource definition with the same access policies
+// as `ProjectUserAuthzMiddleware` and `ProjectAuthzBuildFiltersMiddleware`, returning the creator filters. The HTTP
+// status code is returned along with the error if the requester is not authorized.
+func AuthorizeResource(def *models.ResourceDefinition, verb string, requester *Requester) (map[string]interface{}, int, error) {
+	storeConfig := resourceStoreConfig(def)
+
+	requiresAuthn, err := storeConfig.VerbRequiresAuthn(verb)
+	if err != nil {
+		return nil, http.StatusNotImplemented, err
+	}
+
+	if requester.Anonymous() {
+		if requiresAuthn {
+			return nil, http.StatusUnauthorized, errors.New("access token required")
+		}
+		return map[string]interface{}{}, 0, nil
+	}
+
+	if !requester.Allows(verb) {
+		return nil, http.StatusUnauthorized, fmt.Errorf("user does not have permission to '%s'", verb)
+	}
+	if !requester.AllowsResource(def.PathName, verb) {
+		return nil, http.StatusForbidden, fmt.Errorf("api key is not scoped to '%s' '%s'", verb, def.PathName)
+	}
+	if !requester.RoleAllowsResource(def.PathName, verb) {
+		return nil, http.StatusForbidden, fmt.Errorf("role '%s' does not have permission to '%s' '%s'", requester.Role, verb, def.PathName)
+	}
+
+	if !requiresAuthn {
+		return map[string]interface{}{}, 0, nil
+	}
+
+	filters, err := authzFilters(storeConfig, verb, def.PathName, requester)
+	if err != nil {
+		return nil, http.StatusForbidden, err
+	}
+
+	return filters, 0, nil
+}
+
+// FieldAccess returns the properties of the request's resource the requester cannot read or write. Requests without a
+// project requester, i.e. of app users, have access to every property.
+func FieldAccess(c *gin.Context) *models.FieldAccess {
+	requester, ok := c.Value("requester").(*Requester)
+	if !ok {
+		return &models.FieldAccess{}
+	}
+	def, ok := c.Value("resourceDefinition").(*models.ResourceDefinition)
+	if !ok {
+		return &models.FieldAccess{}
+	}
+	return requester.FieldAccess(def)
+}
+
+// FieldAccess returns the properties of the resource definition the requester cannot read or write
+func (r *Requester) FieldAccess(def *models.ResourceDefinition) *models.FieldAccess {
+	schema, err := def.GetSchema()
+	if err != nil {
+		return &models.FieldAccess{}
+	}
+	return schema.FieldAccess(r.Role)
+}
+
+// resourceStoreConfig returns the access policies of the resource definition
+func resourceStoreConfig(def *models.ResourceDefinition) StoreConfig {
+	return StoreConfig{
+		Create:        def.Create,
+		Read:          def.Read,
+		Update:        def.Update,
+		Delete:        def.Delete,
+		ParallelRead:  def.ParallelRead,
+		ParallelWrite: def.ParallelWrite,
+	}
+}
+
+// loadProjectDetail loads the project of the request, with its hooks, and injects it into the context. The error is
+// written to the response if the project could not be loaded.
+func loadProjectDetail(c *gin.Context, store interfaces.Datastore) (*models.ProjectDetail, bool) {
+	// get project slug
+	// get project from context, inserted into context from subdomain
+	projectSlug := c.GetString("project")
+	if projectSlug == "" {
+		respondWithError(http.StatusUnauthorized, "invalid project", c)
+		return nil, false
+	}
+
+	// load the project and check the authn policy
+	project, err := store.GetProjectDetailBySlug(projectSlug)
+	if err != nil {
+		respondWithError(http.StatusNotFound, "project not found", c)
+		return nil, false
+	}
+
+	// TODO: query hooks as part of project detail view
+	hooks, lErr := store.ListHooks(project.ID)
+	if lErr != nil {
+		respondWithError(http.StatusNotFound, fmt.Sprintf("error loading project details: %s", lErr.Error()), c)
+		return nil, false
+	}
+	project.Hooks = hooks
+
+	c.Set("projectObject", project)
+	c.Set("projectId", project.ID)
+	c.Set("accountRequestLimit", project.Requests)
+	c.Set("accountId", project.UserID)
+
+	return project, true
+}
+
+// authenticateRequester validates the Authorization header of the request. A `nil` requester is returned if the header
+// is not present, otherwise the HTTP status code and message are returned if the credentials are invalid.
+func authenticateRequester(c *gin.Context, store interfaces.Datastore, jwtAuth *auth.JWT, config *config.AppConfig, project *models.ProjectDetail) (*Requester, int, string) {
+	values, _ := c.Request.Header["Authorization"]
+	if len(values) == 0 {
+		return nil, 0, ""
+	}
+
+	vals := strings.Split(values[0], " ")
+
+	authType := strings.ToLower(vals[0])
+
+	if authType == BEARER {
+		if len(vals) < 2 {
+			return nil, http.StatusUnauthorized, "invalid access token"
+		}
+		tokenString := vals[1]
+		token, err := jwt.Parse(tokenString, jwtAuth.Project(project.ID).TokenLookup)
+		if err != nil {
+			return nil, http.StatusUnauthorized, "invalid access token"
+		}
+
+		// token is valid, get claims and perform authorization
+		claims := token.Claims.(jwt.MapClaims)
+
+		projects, ok := claims["projects"].(map[string]interface{})
+		if !ok {
+			return nil, http.StatusUnauthorized, "improperly formatted access token"
+		}
+
+		_, ok = projects[project.Slug]
+		if !ok {
+			// project user does not have access to this project
+			return nil, http.StatusNotFound, "project not found"
+		}
+
+		user, ok := claims["user"].(map[string]interface{})
+		if !ok {
+			return nil, http.StatusUnauthorized, "improperly formatted access token"
+		}
+
+		userType, ok := user["type"].(string)
+		if !ok || userType != "project" {
+			return nil, http.StatusUnauthorized, "invalid access token"
+		}
+
+		userIsActive, ok := user["active"].(bool)
+		if !ok || !userIsActive {
+			return nil, http.StatusUnauthorized, "user is not active, please confirm your account"
+		}
+
+		requester := &Requester{Type: "user"}
+		requester.Name, _ = user["name"].(string)
+		requester.ID, _ = user["id"].(string)
+		requester.Role, _ = user["role"].(string)
+		requester.Read, _ = user["read"].(bool)
+		requester.Write, _ = user["write"].(bool)
+		requester.Attributes, _ = user["attributes"].(map[string]interface{})
+		groups, _ := user["groups"].([]interface{})
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				requester.Groups = append(requester.Groups, name)
+			}
+		}
+
+		return loadCustomRole(store, project.ID, requester)
+	} else if authType == APIKEY {
+		// authenticate api key
+		if len(vals) < 2 {
+			return nil, http.StatusNotFound, "invalid key"
+		}
+		key, err := lookupAPIKey(store, config, project.ID, vals[1])
+		if err != nil {
+			return nil, http.StatusNotFound, "invalid key"
+		}
+
+		now := time.Now()
+		if key.Expired(now) {
+			return nil, http.StatusUnauthorized, "api key has expired"
+		}
+		if !key.AllowsIP(ClientIP(c, config)) {
+			return nil, http.StatusForbidden, "api key is not allowed from this address"
+		}
+
+		// the last used time is only written once per interval, rather than on every request
+		if key.LastUsed == nil || now.Sub(*key.LastUsed) > apiKeyLastUsedInterval {
+			if err := store.UpdateAPIKeyLastUsed(project.ID, key.ID, now); err != nil {
+				log.Println(err)
+			}
+		}
+
+		return loadCustomRole(store, project.ID, &Requester{
+			Type:   "apikey",
+			Name:   key.Description,
+			ID:     key.ID,
+			Role:   key.Role,
+			Read:   key.Read,
+			Write:  key.Write,
+			Scopes: key.Scopes,
+		})
+	}
+
+	return nil, http.StatusUnauthorized, "invalid access token"
+}
+
+// loadCustomRole loads the project role of a requester without a built-in role, requesters of deleted roles are denied
+func loadCustomRole(store interfaces.ProjectRolesDatastore, projectID string, requester *Requester) (*Requester, int, string) {
+	if auth.BuiltinRole(requester.Role) {
+		return requester, 0, ""
+	}
+
+	role, err := store.GetRole(projectID, requester.Role)
+	if err != nil {
+		return nil, http.StatusForbidden, fmt.Sprintf("role '%s' does not exist", requester.Role)
+	}
+	requester.CustomRole = role
+
+	return requester, 0, ""
+}
+
+// lookupAPIKey returns the project API key. Prefixed keys are looked up by their key ID and compared with their
+// HMAC, legacy keys by their hash. Legacy keys hashed with SHA1 are rehashed with HMAC-SHA256 when they are used.
+func lookupAPIKey(store interfaces.ProjectAPIKeysDatastore, config *config.AppConfig, projectID, apiKey string) (*models.ProjectAPIKey, error) {
+	secret, err := auth.APIKeySecret(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyID, ok := auth.ParseAPIKey(apiKey); ok {
+		key, err := store.GetAPIKeyByKeyID(projectID, keyID)
+		if err != nil {
+			return nil, err
+		}
+		if !auth.CompareAPIKeyHash(key.KeyHash, apiKey, secret) {
+			return nil, errors.New("invalid key")
+		}
+		return key, nil
+	}
+
+	hash := auth.HashAPIKey(apiKey, secret)
+	if key, err := store.GetAPIKeyByKey(projectID, hash); err == nil {
+		return key, nil
+	}
+
+	key, err := store.GetAPIKeyByKey(projectID, auth.SHA1(apiKey, config.AppSecret))
+	if err != nil {
+		return nil, err
+	}
+	if err := store.UpdateAPIKeyHash(projectID, key.ID, hash); err != nil {
+		log.Println(err)
+	}
+
+	return key, nil
+}
+
 // RequestRateLimit checks the account rate limit and returns 429 if over app tier limit
 func RequestRateLimit(store interfaces.Datastore, cache redis.UniversalClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -402,3 +761,39 @@ func RequestRateLimit(store interfaces.Datastore, cache redis.UniversalClient) g
 		c.Next()
 	}
 }
+
+// JSONRulesMiddleware evaluates the path rules of the root key with the requester's `authID` and `authRole`. Requests
+// to root keys without rules are not affected. This middleware requires that `ProjectUserAuthzMiddleware` has run.
+func JSONRulesMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rulesi, exists := c.Get("jsonRules")
+		if !exists {
+			c.Next()
+			return
+		}
+		rules := rulesi.([]*models.JSONRule)
+
+		op := models.JSONRuleWrite
+		if c.Request.Method == "GET" {
+			op = models.JSONRuleRead
+		}
+
+		keys := []string{}
+		if trimmed := strings.Trim(c.Param("keys"), "/"); trimmed != "" {
+			keys = strings.Split(trimmed, "/")
+		}
+
+		requester := &auth.Requester{
+			ID:        c.GetString("authID"),
+			Role:      c.GetString("authRole"),
+			Anonymous: c.GetString("authType") == "anonymous",
+		}
+
+		if !auth.JSONRulesAllow(rules, op, keys, requester) {
+			respondWithError(http.StatusForbidden, fmt.Sprintf("access to '%s' denied by rules", strings.Join(keys, "/")), c)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/config"
+)
+
+// RateLimited counts a request against the key and returns true if more than `limit` requests were counted within the
+// window. Requests are allowed if the cache is unavailable.
+func RateLimited(cache redis.UniversalClient, key string, limit int64, window time.Duration) bool {
+	count, err := cache.Incr(key).Result()
+	if err != nil {
+		log.Println("could not write to cache ", err.Error())
+		return false
+	}
+	if count == 1 {
+		cache.Expire(key, window)
+	}
+
+	return count > limit
+}
+
+// IPRateLimit returns 429 if the client made more than `limit` requests to the named flow of the project within the
+// window. This middleware requires that `ProjectIDAuthzMiddleware` has run.
+func IPRateLimit(cache redis.UniversalClient, config *config.AppConfig, flow string, limit int64, window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := fmt.Sprintf("rateLimit:%s:%s:%s", flow, c.GetString("projectId"), ClientIP(c, config))
+		if RateLimited(cache, key, limit, window) {
+			respondWithError(http.StatusTooManyRequests, "too many requests, try again later", c)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -3,13 +3,11 @@ package middleware
 import (
 	"bytes"
 	"log"
-	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/dsi/models"
-	"github.com/machinable/machinable/events"
 )
 
 type logWriter struct {
@@ -23,16 +21,16 @@ func (w logWriter) Write(b []byte) (int, error) {
 }
 
 // JSONStatsMiddleware logs json stats for reporting
-func JSONStatsMiddleware(store interfaces.Datastore, emitter *events.Processor) gin.HandlerFunc {
-	return loggingMiddleware(store, emitter, models.EndpointJSON)
+func JSONStatsMiddleware(store interfaces.Datastore) gin.HandlerFunc {
+	return loggingMiddleware(store, models.EndpointJSON)
 }
 
 // ResourceStatsMiddleware logs resource stats and logging for reporting
-func ResourceStatsMiddleware(store interfaces.Datastore, emitter *events.Processor) gin.HandlerFunc {
-	return loggingMiddleware(store, emitter, models.EndpointResource)
+func ResourceStatsMiddleware(store interfaces.Datastore) gin.HandlerFunc {
+	return loggingMiddleware(store, models.EndpointResource)
 }
 
-func loggingMiddleware(store interfaces.Datastore, emitter *events.Processor, endpointType string) gin.HandlerFunc {
+func loggingMiddleware(store interfaces.Datastore, endpointType string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// inject custom writer
 		lw := &logWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
@@ -43,6 +41,9 @@ func loggingMiddleware(store interfaces.Datastore, emitter *events.Processor, en
 		// get aligned time by 5 minute interval
 		alignedStart := AlignTime(requestStart, 5)
 
+		// used by handlers to record events for hooks
+		c.Set("endpointType", endpointType)
+
 		// continue handler chain
 		c.Next()
 
@@ -79,38 +80,6 @@ func loggingMiddleware(store interfaces.Datastore, emitter *events.Processor, en
 			InitiatorID:    authID,
 		}
 
-		// hooks are disabled with this request header set to false
-		xTriggerHooks := c.Request.Header.Get("X-Trigger-Hooks")
-
-		if verb != "GET" && (statusCode == 200 || statusCode == 201) && xTriggerHooks != "false" {
-			projecti, exists := c.Get("projectObject")
-			if !exists {
-				respondWithError(http.StatusBadRequest, "malformed request - invalid project", c)
-				return
-			}
-			projectObj := projecti.(*models.ProjectDetail)
-
-			action := "create"
-			if verb == "PUT" {
-				action = "edit"
-			} else if verb == "DELETE" {
-				action = "delete"
-			}
-
-			// push event for webhook/websocket processing (async)
-			go emitter.PushEvent(
-				&events.Event{
-					Project:   projectObj,
-					Entity:    endpointType,
-					EntityKey: c.GetString("entityKey"),
-					EntityID:  c.GetString("entityID"),
-					Action:    action,
-					Keys:      c.GetStringSlice("jsonKeys"), // if exists
-					Payload:   lw.body.Bytes(),
-				},
-			)
-		}
-
 		// save in go routine, do not block request
 		go func(projectID string, plog *models.Log) {
 			// save the log
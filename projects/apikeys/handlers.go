@@ -2,29 +2,37 @@ package apikeys
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/auth"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
-	uuid "github.com/satori/go.uuid"
+	"github.com/machinable/machinable/dsi/models"
 )
 
+// maxKeyAttempts is how many keys are generated when rotating a key, before giving up on finding an unused key id
+const maxKeyAttempts = 3
+
 // New returns a pointer to a new `APIKeys` struct
-func New(db interfaces.ProjectAPIKeysDatastore, config *config.AppConfig) *APIKeys {
+func New(db interfaces.ProjectAPIKeysDatastore, roles interfaces.ProjectRolesDatastore, config *config.AppConfig) *APIKeys {
 	return &APIKeys{
 		store:  db,
+		roles:  roles,
 		config: config,
 	}
 }
 
-// APIKeys wraps the datastore and any HTTP handlers for project api keys
+// APIKeys wraps the datastore and any HTTP handlers for project api keys, custom roles of keys are verified with the
+// roles datastore
 type APIKeys struct {
 	store  interfaces.ProjectAPIKeysDatastore
+	roles  interfaces.ProjectRolesDatastore
 	config *config.AppConfig
 }
 
-// UpdateKey updates api key role and access
+// UpdateKey updates api key role, access, scopes, allowed IPs and expiration
 func (k *APIKeys) UpdateKey(c *gin.Context) {
 	var newKey NewProjectKey
 	keyID := c.Param("keyID")
@@ -34,20 +42,18 @@ func (k *APIKeys) UpdateKey(c *gin.Context) {
 
 	err := newKey.ValidateRoleAccess()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !k.supportedRole(projectID, newKey.Role) {
+		apierror.Respond(c, http.StatusBadRequest, "invalid role")
 		return
 	}
 
-	err = k.store.UpdateAPIKey(
-		projectID,
-		keyID,
-		newKey.Read,
-		newKey.Write,
-		newKey.Role,
-	)
+	err = k.store.UpdateAPIKey(projectID, keyID, newKey.apiKey())
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -63,31 +69,48 @@ func (k *APIKeys) AddKey(c *gin.Context) {
 
 	err := newKey.Validate()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !k.supportedRole(projectID, newKey.Role) {
+		apierror.Respond(c, http.StatusBadRequest, "invalid role")
 		return
 	}
 
-	// generate sha1 key
-	keyHash := auth.SHA1(newKey.Key, k.config.AppSecret)
+	secret, err := auth.APIKeySecret(k.config)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// only the HMAC of the key is stored
+	apiKey := newKey.apiKey()
+	apiKey.KeyHash = auth.HashAPIKey(newKey.Key, secret)
 	newKey.Key = ""
 
-	key, err := k.store.CreateAPIKey(
-		projectID,
-		keyHash,
-		newKey.Description,
-		newKey.Read,
-		newKey.Write,
-		newKey.Role,
-	)
+	key, err := k.store.CreateAPIKey(projectID, apiKey)
 
+	if err == models.ErrAPIKeyExists {
+		apierror.Respond(c, http.StatusConflict, "the project has a key with the same key id, generate a new key")
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	c.JSON(http.StatusCreated, key)
 }
 
+// supportedRole returns true if the role is a built-in role or a custom role of the project
+func (k *APIKeys) supportedRole(projectID, role string) bool {
+	if auth.BuiltinRole(role) {
+		return true
+	}
+	_, err := k.roles.GetRole(projectID, role)
+	return err == nil
+}
+
 // ListKeys lists all api tokens of this project
 func (k *APIKeys) ListKeys(c *gin.Context) {
 	projectID := c.MustGet("projectId").(string)
@@ -95,17 +118,99 @@ func (k *APIKeys) ListKeys(c *gin.Context) {
 	keys, err := k.store.ListAPIKeys(projectID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"items": keys})
 }
 
-// GenerateKey retrieves a single api token of this project by ID
+// GenerateKey generates a new prefixed api key, the key is saved with `AddKey`
 func (k *APIKeys) GenerateKey(c *gin.Context) {
-	UUID := uuid.NewV4()
-	c.JSON(http.StatusOK, gin.H{"key": UUID.String()})
+	key, _, err := auth.GenerateAPIKey()
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to generate key")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key": key})
+}
+
+// RotateKey replaces an api key with a new key with the same access. The rotated key stays valid for the grace
+// period, so clients can be updated without downtime.
+func (k *APIKeys) RotateKey(c *gin.Context) {
+	var body RotateProjectKey
+	keyID := c.Param("keyID")
+	projectID := c.MustGet("projectId").(string)
+
+	c.BindJSON(&body)
+	grace, err := body.Duration()
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	old, err := k.store.GetAPIKey(projectID, keyID)
+	if err != nil {
+		apierror.Respond(c, http.StatusNotFound, "api key not found")
+		return
+	}
+	if old.Expired(time.Now()) {
+		apierror.Respond(c, http.StatusBadRequest, "api key has expired")
+		return
+	}
+
+	secret, err := auth.APIKeySecret(k.config)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the rotated key expires after the grace period, unless it expires sooner
+	expires := time.Now().Add(grace)
+	if old.Expires != nil && old.Expires.Before(expires) {
+		expires = *old.Expires
+	}
+
+	// the replacement is created and the rotated key expires in one transaction, a new key is generated if its key id
+	// is taken
+	var key string
+	var replacement *models.ProjectAPIKey
+	for attempt := 0; attempt < maxKeyAttempts; attempt++ {
+		var newKeyID string
+		key, newKeyID, err = auth.GenerateAPIKey()
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "failed to generate key")
+			return
+		}
+
+		replacement, err = k.store.RotateAPIKey(projectID, old.ID, expires, &models.ProjectAPIKey{
+			KeyID:       newKeyID,
+			KeyHash:     auth.HashAPIKey(key, secret),
+			Description: old.Description,
+			Read:        old.Read,
+			Write:       old.Write,
+			Role:        old.Role,
+			Scopes:      old.Scopes,
+			AllowedIPs:  old.AllowedIPs,
+			Expires:     old.Expires,
+		})
+		if err != models.ErrAPIKeyExists {
+			break
+		}
+	}
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	old.Expires = &expires
+
+	// the new key is only returned once
+	c.JSON(http.StatusCreated, gin.H{
+		"key":     key,
+		"api_key": replacement,
+		"rotated": old.ID,
+		"expires": old.Expires,
+	})
 }
 
 // DeleteKey removes an api token by ID
@@ -116,7 +221,7 @@ func (k *APIKeys) DeleteKey(c *gin.Context) {
 	err := k.store.DeleteAPIKey(projectID, keyID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -2,28 +2,53 @@ package apikeys
 
 import (
 	"errors"
+	"time"
 
 	"github.com/machinable/machinable/auth"
-	uuid "github.com/satori/go.uuid"
+	"github.com/machinable/machinable/dsi/models"
 )
 
 // NewProjectKey is the JSON structure of a new api key request
 type NewProjectKey struct {
-	Key         string `json:"key"`
-	Description string `json:"description"`
-	Read        bool   `json:"read"`
-	Write       bool   `json:"write"`
-	Role        string `json:"role"`
+	Key         string              `json:"key"`
+	Description string              `json:"description"`
+	Read        bool                `json:"read"`
+	Write       bool                `json:"write"`
+	Role        string              `json:"role"`
+	Scopes      models.APIKeyScopes `json:"scopes"`
+	AllowedIPs  []string            `json:"allowed_ips"`
+	Expires     *time.Time          `json:"expires"`
+
+	keyID string // keyID is embedded in the key, set by `Validate`
 }
 
-// SupportedRole verifies that the users role is valid and supported
-func (u *NewProjectKey) SupportedRole() bool {
-	for _, b := range auth.ValidRoles {
-		if b == u.Role {
-			return true
-		}
+// RotateProjectKey is the JSON structure of an api key rotation request
+type RotateProjectKey struct {
+	GracePeriod int `json:"grace_period"` // GracePeriod is how long the rotated key stays valid in minutes
+}
+
+const (
+	// DefaultGracePeriod is how long a rotated key stays valid if the request does not set a grace period
+	DefaultGracePeriod = 24 * time.Hour
+	// MaxGracePeriod is the longest a rotated key can stay valid
+	MaxGracePeriod = 30 * 24 * time.Hour
+)
+
+// Duration validates and returns the grace period
+func (r *RotateProjectKey) Duration() (time.Duration, error) {
+	grace := time.Duration(r.GracePeriod) * time.Minute
+	if grace < 0 || grace > MaxGracePeriod {
+		return 0, errors.New("grace_period must be between 0 and 43200 minutes")
+	}
+	if grace == 0 {
+		grace = DefaultGracePeriod
 	}
-	return false
+	return grace, nil
+}
+
+// SupportedRole verifies that the role is one of the built-in roles, other roles must be custom roles of the project
+func (u *NewProjectKey) SupportedRole() bool {
+	return auth.BuiltinRole(u.Role)
 }
 
 // Validate checks that the new key is not empty
@@ -32,23 +57,46 @@ func (u *NewProjectKey) Validate() error {
 		return errors.New("invalid key")
 	}
 
-	if _, err := uuid.FromString(u.Key); err != nil {
-		return errors.New("invalid key")
+	keyID, ok := auth.ParseAPIKey(u.Key)
+	if !ok {
+		return errors.New("invalid key, keys are created with /keys/generate")
 	}
+	u.keyID = keyID
 
 	return u.ValidateRoleAccess()
 }
 
-// ValidateRoleAccess validates the role and access fields
+// ValidateRoleAccess validates the role, access, scope, allowed IP and expiration fields
 func (u *NewProjectKey) ValidateRoleAccess() error {
 	// Set default role
 	if u.Role == "" {
 		u.Role = auth.RoleUser
 	}
 
-	if ok := u.SupportedRole(); !ok {
-		return errors.New("invalid role")
+	if err := u.Scopes.Validate(); err != nil {
+		return err
+	}
+
+	if u.Expires != nil && !u.Expires.After(time.Now()) {
+		return errors.New("expires must be in the future")
 	}
 
-	return nil
+	return u.apiKey().ValidateAllowedIPs()
+}
+
+// apiKey returns the access fields of the request as an API key, allowed IPs are normalized by validation
+func (u *NewProjectKey) apiKey() *models.ProjectAPIKey {
+	if u.AllowedIPs == nil {
+		u.AllowedIPs = []string{}
+	}
+	return &models.ProjectAPIKey{
+		KeyID:       u.keyID,
+		Description: u.Description,
+		Read:        u.Read,
+		Write:       u.Write,
+		Role:        u.Role,
+		Scopes:      u.Scopes,
+		AllowedIPs:  u.AllowedIPs,
+		Expires:     u.Expires,
+	}
 }
@@ -14,33 +14,38 @@ type Handler interface {
 	ListKeys(c *gin.Context)
 	GenerateKey(c *gin.Context)
 	DeleteKey(c *gin.Context)
+	RotateKey(c *gin.Context)
 }
 
 // SetRoutes sets all of the appropriate routes to handlers for project users
 func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, config *config.AppConfig) error {
 	// create new Resources handler with datastore
-	handler := New(datastore, config)
+	handler := New(datastore, datastore, config)
 
 	// di for testing
 	return setRoutes(
 		engine,
 		handler,
 		datastore,
-		middleware.AppUserJwtAuthzMiddleware(config),
+		middleware.AppUserRecentMFAMiddleware(datastore),
+		middleware.AppUserJwtAuthzMiddleware(datastore, config),
 		middleware.AppUserProjectAuthzMiddleware(datastore, config),
 	)
 }
 
-func setRoutes(engine *gin.Engine, handler Handler, datastore interfaces.ProjectAPIKeysDatastore, mw ...gin.HandlerFunc) error {
+// setRoutes sets the api key routes, `recentMFA` protects the creation and changes of keys and follows the `mw` middleware
+func setRoutes(engine *gin.Engine, handler Handler, datastore interfaces.ProjectAPIKeysDatastore, recentMFA gin.HandlerFunc, mw ...gin.HandlerFunc) error {
 	// Only app users have access to api key management
 	keys := engine.Group("/keys")
 	keys.Use(mw...)
 
-	keys.GET("/generate", handler.GenerateKey) // generate a valid uuid for a potential api key
-	keys.GET("/", handler.ListKeys)            // get list of api keys for this project
-	keys.POST("/", handler.AddKey)             // create a new api key for this project
-	keys.DELETE("/:keyID", handler.DeleteKey)  // delete an api key
-	keys.PUT("/:keyID", handler.UpdateKey)     // update an api key
+	keys.GET("/generate", handler.GenerateKey)           // generate a valid uuid for a potential api key
+	keys.GET("/", handler.ListKeys)                      // get list of api keys for this project
+	keys.POST("/", recentMFA, handler.AddKey)            // create a new api key for this project
+	keys.DELETE("/:keyID", recentMFA, handler.DeleteKey) // delete an api key
+	keys.PUT("/:keyID", recentMFA, handler.UpdateKey)    // update an api key
+
+	keys.POST("/:keyID/rotate", recentMFA, handler.RotateKey) // replace an api key, the old key is valid for a grace period
 
 	return nil
 }
@@ -8,12 +8,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/auth"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/dsi/models"
-	uuid "github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,9 +24,25 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
+// noMFA is the recent multi-factor authentication middleware of app users without a second factor
+func noMFA(c *gin.Context) {}
+
+// testRoles returns a roles datastore with the custom role 'editor'
+func testRoles() *interfaces.MockProjectRolesDatastore {
+	return &interfaces.MockProjectRolesDatastore{
+		GetRoleFunc: func(projectID, name string) (*models.Role, error) {
+			if name == "editor" {
+				return &models.Role{Name: name}, nil
+			}
+			return nil, errors.New("role not found")
+		},
+	}
+}
+
 func TestUpdateKey(t *testing.T) {
 	ds := &interfaces.MockProjectAPIKeysDatastore{}
-	handler := New(ds, &config.AppConfig{})
+	handler := New(ds, testRoles(), &config.AppConfig{})
+	expired := time.Now().Add(-time.Hour)
 
 	tables := []struct {
 		name       string
@@ -51,6 +68,42 @@ func TestUpdateKey(t *testing.T) {
 			&NewProjectKey{Role: "Batman"},
 			400,
 		},
+		{
+			"custom role",
+			nil,
+			&NewProjectKey{Role: "editor"},
+			200,
+		},
+		{
+			"scoped key",
+			nil,
+			&NewProjectKey{Scopes: models.APIKeyScopes{{Resource: "posts", Verbs: []string{"read"}}}, AllowedIPs: []string{"10.0.0.0/8", "192.168.1.4"}},
+			200,
+		},
+		{
+			"validation error - scope without resource",
+			nil,
+			&NewProjectKey{Scopes: models.APIKeyScopes{{Verbs: []string{"read"}}}},
+			400,
+		},
+		{
+			"validation error - invalid scope verb",
+			nil,
+			&NewProjectKey{Scopes: models.APIKeyScopes{{RootKey: "settings", Verbs: []string{"write"}}}},
+			400,
+		},
+		{
+			"validation error - invalid cidr",
+			nil,
+			&NewProjectKey{AllowedIPs: []string{"10.0.0.0/33"}},
+			400,
+		},
+		{
+			"validation error - expired",
+			nil,
+			&NewProjectKey{Expires: &expired},
+			400,
+		},
 		{
 			"update error",
 			errors.New("unexpected error"),
@@ -62,11 +115,11 @@ func TestUpdateKey(t *testing.T) {
 	for _, tt := range tables {
 		t.Run(tt.name, func(t *testing.T) {
 			router := gin.Default()
-			ds.UpdateAPIKeyFunc = func(projectID, keyId string, read, write bool, role string) error {
+			ds.UpdateAPIKeyFunc = func(projectID, keyId string, key *models.ProjectAPIKey) error {
 				return tt.updateErr
 			}
 
-			setRoutes(router, handler, ds, func(c *gin.Context) { c.Set("projectId", "testing") })
+			setRoutes(router, handler, ds, noMFA, func(c *gin.Context) { c.Set("projectId", "testing") })
 			w := httptest.NewRecorder()
 
 			jsonStr, _ := json.Marshal(tt.newKey)
@@ -80,7 +133,7 @@ func TestUpdateKey(t *testing.T) {
 
 func TestAddKey(t *testing.T) {
 	ds := &interfaces.MockProjectAPIKeysDatastore{}
-	handler := New(ds, &config.AppConfig{})
+	handler := New(ds, testRoles(), &config.AppConfig{APIKeySecret: "secret"})
 
 	tables := []struct {
 		name       string
@@ -91,9 +144,15 @@ func TestAddKey(t *testing.T) {
 		{
 			"success",
 			nil,
-			&NewProjectKey{Key: "1b7dfd69-bd3f-46c7-ac94-10bbdec1d96b"},
+			&NewProjectKey{Key: "mch_4f2a9c1b7d3e_9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e"},
 			201,
 		},
+		{
+			"validation error - unprefixed key",
+			nil,
+			&NewProjectKey{Key: "1b7dfd69-bd3f-46c7-ac94-10bbdec1d96b"},
+			400,
+		},
 		{
 			"validation error",
 			nil,
@@ -109,19 +168,25 @@ func TestAddKey(t *testing.T) {
 		{
 			"create error",
 			errors.New("unexpected error"),
-			&NewProjectKey{Key: "1b7dfd69-bd3f-46c7-ac94-10bbdec1d96b"},
+			&NewProjectKey{Key: "mch_4f2a9c1b7d3e_9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e"},
 			500,
 		},
+		{
+			"key id exists",
+			models.ErrAPIKeyExists,
+			&NewProjectKey{Key: "mch_4f2a9c1b7d3e_9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e"},
+			409,
+		},
 	}
 
 	for _, tt := range tables {
 		t.Run(tt.name, func(t *testing.T) {
 			router := gin.Default()
-			ds.CreateAPIKeyFunc = func(projectID, hash, description string, read, write bool, role string) (*models.ProjectAPIKey, error) {
+			ds.CreateAPIKeyFunc = func(projectID string, key *models.ProjectAPIKey) (*models.ProjectAPIKey, error) {
 				return nil, tt.addErr
 			}
 
-			setRoutes(router, handler, ds, func(c *gin.Context) { c.Set("projectId", "testing") })
+			setRoutes(router, handler, ds, noMFA, func(c *gin.Context) { c.Set("projectId", "testing") })
 			w := httptest.NewRecorder()
 
 			jsonStr, _ := json.Marshal(tt.newKey)
@@ -135,7 +200,7 @@ func TestAddKey(t *testing.T) {
 
 func TestListKeys(t *testing.T) {
 	ds := &interfaces.MockProjectAPIKeysDatastore{}
-	handler := New(ds, &config.AppConfig{})
+	handler := New(ds, testRoles(), &config.AppConfig{})
 
 	tables := []struct {
 		name       string
@@ -166,7 +231,7 @@ func TestListKeys(t *testing.T) {
 				return tt.apiKeys, tt.listErr
 			}
 
-			setRoutes(router, handler, ds, func(c *gin.Context) { c.Set("projectId", "testing") })
+			setRoutes(router, handler, ds, noMFA, func(c *gin.Context) { c.Set("projectId", "testing") })
 			w := httptest.NewRecorder()
 			req, _ := http.NewRequest("GET", "/keys/", nil)
 			router.ServeHTTP(w, req)
@@ -189,9 +254,9 @@ func TestListKeys(t *testing.T) {
 func TestGenerate(t *testing.T) {
 	router := gin.Default()
 	ds := &interfaces.MockProjectAPIKeysDatastore{}
-	handler := New(ds, &config.AppConfig{})
+	handler := New(ds, testRoles(), &config.AppConfig{})
 
-	setRoutes(router, handler, ds)
+	setRoutes(router, handler, ds, noMFA)
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/keys/generate", nil)
 	router.ServeHTTP(w, req)
@@ -209,15 +274,83 @@ func TestGenerate(t *testing.T) {
 	}
 
 	// verify generated key
-	_, err := uuid.FromString(response.Key)
-	if err != nil {
+	if _, ok := auth.ParseAPIKey(response.Key); !ok {
 		t.Error("invalid key")
 	}
 }
 
+func TestRotateKey(t *testing.T) {
+	ds := &interfaces.MockProjectAPIKeysDatastore{}
+	handler := New(ds, testRoles(), &config.AppConfig{APIKeySecret: "secret"})
+	soon := time.Now().Add(time.Hour)
+
+	tables := []struct {
+		name        string
+		body        *RotateProjectKey
+		oldExpires  *time.Time
+		statusCode  int
+		gracePeriod time.Duration
+	}{
+		{"default grace period", &RotateProjectKey{}, nil, 201, DefaultGracePeriod},
+		{"grace period", &RotateProjectKey{GracePeriod: 90}, nil, 201, 90 * time.Minute},
+		{"expires sooner", &RotateProjectKey{GracePeriod: 600}, &soon, 201, time.Hour},
+		{"invalid grace period", &RotateProjectKey{GracePeriod: -1}, nil, 400, 0},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.Default()
+			old := &models.ProjectAPIKey{ID: "old", Role: "user", Read: true, Scopes: models.APIKeyScopes{{Resource: "posts", Verbs: []string{"read"}}}, Expires: tt.oldExpires}
+			var created *models.ProjectAPIKey
+			ds.GetAPIKeyFunc = func(projectID, id string) (*models.ProjectAPIKey, error) {
+				return old, nil
+			}
+			var expires time.Time
+			attempts := 0
+			ds.RotateAPIKeyFunc = func(projectID, keyID string, exp time.Time, key *models.ProjectAPIKey) (*models.ProjectAPIKey, error) {
+				// the key id of the first key is taken
+				if attempts++; attempts == 1 {
+					return nil, models.ErrAPIKeyExists
+				}
+				assert.Equal(t, "old", keyID)
+				created, expires = key, exp
+				return key, nil
+			}
+
+			setRoutes(router, handler, ds, noMFA, func(c *gin.Context) { c.Set("projectId", "testing") })
+			w := httptest.NewRecorder()
+			jsonStr, _ := json.Marshal(tt.body)
+			req, _ := http.NewRequest("POST", "/keys/old/rotate", bytes.NewBuffer(jsonStr))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			if w.Code != 201 {
+				return
+			}
+
+			response := struct {
+				Key string `json:"key"`
+			}{}
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+			// the replacement has the same access and is hashed with the key secret
+			keyID, ok := auth.ParseAPIKey(response.Key)
+			assert.True(t, ok)
+			assert.Equal(t, keyID, created.KeyID)
+			assert.True(t, auth.CompareAPIKeyHash(created.KeyHash, response.Key, "secret"))
+			assert.Equal(t, old.Scopes, created.Scopes)
+			assert.True(t, created.Read)
+
+			// the old key stays valid for the grace period
+			assert.Equal(t, 2, attempts)
+			assert.WithinDuration(t, time.Now().Add(tt.gracePeriod), expires, 5*time.Second)
+		})
+	}
+}
+
 func TestDeleteKey(t *testing.T) {
 	ds := &interfaces.MockProjectAPIKeysDatastore{}
-	handler := New(ds, &config.AppConfig{})
+	handler := New(ds, testRoles(), &config.AppConfig{})
 
 	tables := []struct {
 		name       string
@@ -246,7 +379,7 @@ func TestDeleteKey(t *testing.T) {
 				return tt.deleteErr
 			}
 
-			setRoutes(router, handler, ds, func(c *gin.Context) { c.Set("projectId", "testing") })
+			setRoutes(router, handler, ds, noMFA, func(c *gin.Context) { c.Set("projectId", "testing") })
 			w := httptest.NewRecorder()
 			req, _ := http.NewRequest("DELETE", "/keys/first-api-key", nil)
 			router.ServeHTTP(w, req)
@@ -257,3 +390,39 @@ func TestDeleteKey(t *testing.T) {
 		})
 	}
 }
+
+func TestRecentMFA(t *testing.T) {
+	ds := &interfaces.MockProjectAPIKeysDatastore{}
+	ds.ListAPIKeysFunc = func(projectID string) ([]*models.ProjectAPIKey, error) {
+		return []*models.ProjectAPIKey{}, nil
+	}
+	handler := New(ds, testRoles(), &config.AppConfig{})
+
+	router := gin.Default()
+	requireMFA := func(c *gin.Context) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "recent multi-factor authentication required"})
+	}
+	setRoutes(router, handler, ds, requireMFA, func(c *gin.Context) { c.Set("projectId", "testing") })
+
+	tables := []struct {
+		method     string
+		path       string
+		statusCode int
+	}{
+		{"GET", "/keys/", 200},
+		{"POST", "/keys/", 403},
+		{"POST", "/keys/a-key-id/rotate", 403},
+		{"PUT", "/keys/a-key-id", 403},
+		{"DELETE", "/keys/a-key-id", 403},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString("{}"))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
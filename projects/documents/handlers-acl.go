@@ -0,0 +1,120 @@
+package documents
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
+	"github.com/machinable/machinable/auth"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// aclBody is the JSON structure of a grant of access to a document
+type aclBody struct {
+	Read  bool `json:"read"`
+	Write bool `json:"write"`
+}
+
+// ListDocumentACL lists the users and groups a document is shared with
+func (h *Documents) ListDocumentACL(c *gin.Context) {
+	resourcePathName := c.Param("resourcePathName")
+	resourceID := c.Param("resourceID")
+	projectID := c.MustGet("projectId").(string)
+
+	if !h.ownedDocument(c) {
+		return
+	}
+
+	entries, err := h.store.ListDocumentACL(projectID, resourcePathName, resourceID)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to list document access")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": entries})
+}
+
+// GrantDocumentAccess shares a document with a user or group, replacing its access if it was already shared
+func (h *Documents) GrantDocumentAccess(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+
+	var body aclBody
+	if err := c.BindJSON(&body); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	acl := &models.DocumentACL{
+		ResourcePath:  c.Param("resourcePathName"),
+		DocumentID:    c.Param("resourceID"),
+		PrincipalType: c.Param("principalType"),
+		PrincipalID:   c.Param("principalID"),
+		Read:          body.Read,
+		Write:         body.Write,
+	}
+	if err := acl.Validate(); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.ownedDocument(c) {
+		return
+	}
+
+	if err := h.store.GrantDocumentAccess(projectID, acl); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to grant document access")
+		return
+	}
+
+	c.JSON(http.StatusOK, acl)
+}
+
+// RevokeDocumentAccess stops sharing a document with a user or group
+func (h *Documents) RevokeDocumentAccess(c *gin.Context) {
+	resourcePathName := c.Param("resourcePathName")
+	resourceID := c.Param("resourceID")
+	projectID := c.MustGet("projectId").(string)
+
+	if !h.ownedDocument(c) {
+		return
+	}
+
+	err := h.store.RevokeDocumentAccess(projectID, resourcePathName, resourceID, c.Param("principalType"), c.Param("principalID"))
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to revoke document access")
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// ownedDocument responds with an error unless the document exists and the requester can share it. Only the creator of
+// a document, within the conditions of its role, or an admin can manage who the document is shared with.
+func (h *Documents) ownedDocument(c *gin.Context) bool {
+	resourcePathName := c.Param("resourcePathName")
+	resourceID := c.Param("resourceID")
+	projectID := c.MustGet("projectId").(string)
+	authFilters := c.MustGet("filters").(map[string]interface{})
+
+	if c.GetString("authType") == "anonymous" {
+		apierror.Respond(c, http.StatusUnauthorized, "access token required")
+		return false
+	}
+
+	filter := map[string]interface{}{}
+	for key, value := range authFilters {
+		if key != models.FilterDocumentAccess {
+			filter[key] = value
+		}
+	}
+	if c.GetString("authRole") != auth.RoleAdmin {
+		filter["_metadata.creator"] = c.GetString("authID")
+	}
+
+	if _, err := h.store.GetDefDocument(projectID, resourcePathName, resourceID, filter, map[string]string{}); err != nil {
+		apierror.RespondError(c, apierror.FromDatastore(err))
+		return false
+	}
+
+	return true
+}
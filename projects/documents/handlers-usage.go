@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/dsi/models"
 )
 
@@ -35,7 +36,7 @@ func (d *Documents) ListCollectionUsage(c *gin.Context) {
 	logs, err := d.store.ListProjectLogs(projectID, iLimit, 0, filter, nil)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -76,7 +77,7 @@ func (d *Documents) GetStats(c *gin.Context) {
 	collections, err := d.store.ListDefinitions(projectID)
 
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
@@ -85,7 +86,7 @@ func (d *Documents) GetStats(c *gin.Context) {
 	for _, col := range collections {
 		stats, err := d.store.GetResourceStats(projectID, col.PathName)
 		if err != nil {
-			c.JSON(err.Code(), gin.H{"error": err.Error()})
+			apierror.RespondError(c, apierror.FromDatastore(err))
 			return
 		}
 
@@ -6,22 +6,27 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/dsi"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/events"
+	"github.com/machinable/machinable/middleware"
 	"github.com/machinable/machinable/query"
 )
 
 // New returns a pointer to a new `Documents` struct
-func New(db interfaces.Datastore) *Documents {
+func New(db interfaces.Datastore, validator *events.Validator) *Documents {
 	return &Documents{
-		store: db,
+		store:     db,
+		validator: validator,
 	}
 }
 
 // Documents contains the datastore and any HTTP handlers for project resource documents
 type Documents struct {
-	store interfaces.Datastore
+	store     interfaces.Datastore
+	validator *events.Validator
 }
 
 // AddObject creates a new document of the resource definition
@@ -30,12 +35,19 @@ func (h *Documents) AddObject(c *gin.Context) {
 	projectID := c.MustGet("projectId").(string)
 	creator := c.MustGet("authID").(string)
 	creatorType := c.MustGet("authType").(string)
+	authFilters := c.MustGet("filters").(map[string]interface{})
 
 	fieldValues := models.ResourceObject{}
 
 	err := c.BindJSON(&fieldValues)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// properties protected from the requester's role cannot be written
+	access := middleware.FieldAccess(c)
+	if !writableFields(c, access, fieldValues) {
 		return
 	}
 
@@ -43,13 +55,24 @@ func (h *Documents) AddObject(c *gin.Context) {
 
 	// TODO: Validate against schema here
 
-	newID, dsiErr := h.store.AddDefDocument(projectID, resourcePathName, fieldValues, meta)
+	// "before" hooks can reject or modify the document
+	fieldValues, ok := middleware.ValidateDocument(c, h.validator, models.ActionCreate, fieldValues)
+	if !ok {
+		return
+	}
+	if !middleware.DocumentMatchesFilters(fieldValues, authFilters) {
+		apierror.Respond(c, http.StatusForbidden, "document does not match the conditions of the requester's role")
+		return
+	}
+
+	newID, dsiErr := h.store.AddDefDocument(projectID, resourcePathName, fieldValues, meta, middleware.OutboxEvent(c, models.ActionCreate))
 	if dsiErr != nil {
-		c.JSON(dsiErr.Code(), gin.H{"error": "failed to save " + resourcePathName, "errors": strings.Split(dsiErr.Error(), ",")})
+		apierror.RespondError(c, apierror.New(dsiErr.Code(), "failed to save "+resourcePathName).WithDetails(apierror.MessageDetails(strings.Split(dsiErr.Error(), ","))...))
 		return
 	}
 
 	// Set the inserted ID for the response
+	access.Strip(fieldValues)
 	fieldValues["id"] = newID
 	fieldValues["_metadata"] = meta
 
@@ -67,17 +90,43 @@ func (h *Documents) PutObject(c *gin.Context) {
 
 	err := c.BindJSON(&fieldValues)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// properties protected from the requester's role cannot be written, the document keeps the values of the
+	// properties the role cannot see or write
+	access := middleware.FieldAccess(c)
+	if !writableFields(c, access, fieldValues) {
 		return
 	}
+	if access.Restricted() {
+		existing, dsiErr := h.store.GetDefDocument(projectID, resourcePathName, resourceID, authFilters, map[string]string{})
+		if dsiErr != nil {
+			apierror.RespondError(c, apierror.FromDatastore(dsiErr))
+			return
+		}
+		access.Preserve(fieldValues, existing)
+	}
 
 	// TODO: Validate against schema here
 
-	object, dsiErr := h.store.UpdateDefDocument(projectID, resourcePathName, resourceID, fieldValues, authFilters)
+	// "before" hooks can reject or modify the document
+	fieldValues, ok := middleware.ValidateDocument(c, h.validator, models.ActionEdit, fieldValues)
+	if !ok {
+		return
+	}
+	if !middleware.DocumentMatchesFilters(fieldValues, authFilters) {
+		apierror.Respond(c, http.StatusForbidden, "document does not match the conditions of the requester's role")
+		return
+	}
+
+	object, dsiErr := h.store.UpdateDefDocument(projectID, resourcePathName, resourceID, fieldValues, authFilters, middleware.OutboxEvent(c, models.ActionEdit))
 	if dsiErr != nil {
-		c.JSON(dsiErr.Code(), gin.H{"error": "failed to save " + resourcePathName, "errors": strings.Split(dsiErr.Error(), ",")})
+		apierror.RespondError(c, apierror.New(dsiErr.Code(), "failed to save "+resourcePathName).WithDetails(apierror.MessageDetails(strings.Split(dsiErr.Error(), ","))...))
 		return
 	}
+	access.Strip(*object)
 
 	c.JSON(http.StatusOK, object)
 }
@@ -89,7 +138,7 @@ func (h *Documents) ListObjects(c *gin.Context) {
 	authFilters := c.MustGet("filters").(map[string]interface{})
 
 	if resourcePathName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "resource cannot be empty"})
+		apierror.Respond(c, http.StatusBadRequest, "resource cannot be empty")
 		return
 	}
 
@@ -98,12 +147,12 @@ func (h *Documents) ListObjects(c *gin.Context) {
 
 	iLimit, err := query.GetLimit(&values)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 	iOffset, err := query.GetOffset(&values)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -111,6 +160,7 @@ func (h *Documents) ListObjects(c *gin.Context) {
 	filter := make(map[string]interface{})
 	sort := make(map[string]int)
 	relations := make(map[string]string)
+	access := middleware.FieldAccess(c)
 
 	var resourceDefinition *models.ResourceDefinition
 	validSchema := &models.JSONSchemaObject{}
@@ -127,6 +177,11 @@ func (h *Documents) ListObjects(c *gin.Context) {
 				order = -1
 				sortField = sortField[1:]
 			}
+			// hidden properties cannot be used to infer their values
+			if access.Hidden[sortField] {
+				apierror.Respond(c, http.StatusBadRequest, fmt.Sprintf("unable to sort on '%s'", sortField))
+				return
+			}
 			sort[sortField] = order
 			continue
 		}
@@ -135,7 +190,7 @@ func (h *Documents) ListObjects(c *gin.Context) {
 			// get resource definition if we do not already have it
 			resourceDefinition, err := h.store.GetDefinitionByPathName(projectID, resourcePathName)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "could not retrieve resource definition to validate query parameters"})
+				apierror.Respond(c, http.StatusInternalServerError, "could not retrieve resource definition to validate query parameters")
 				return
 			}
 
@@ -143,14 +198,14 @@ func (h *Documents) ListObjects(c *gin.Context) {
 			var pErr error
 			validSchema, pErr = resourceDefinition.GetSchema()
 			if pErr != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "error getting schema property types"})
+				apierror.Respond(c, http.StatusInternalServerError, "error getting schema property types")
 				return
 			}
 		}
 
 		if k == dsi.RelationKey {
 			for key, value := range validSchema.Properties {
-				if val, ok := value["relation"]; ok && stringInSlice(key, v) {
+				if val, ok := value["relation"]; ok && stringInSlice(key, v) && !access.Hidden[key] {
 					relations[key] = val.(string)
 				}
 			}
@@ -158,8 +213,8 @@ func (h *Documents) ListObjects(c *gin.Context) {
 		}
 
 		_, ok := validSchema.Properties[k]
-		if !ok {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unable to filter on '%s'", k)})
+		if !ok || access.Hidden[k] {
+			apierror.Respond(c, http.StatusBadRequest, fmt.Sprintf("unable to filter on '%s'", k))
 			return
 		}
 
@@ -176,23 +231,31 @@ func (h *Documents) ListObjects(c *gin.Context) {
 	docCount, countErr := h.store.CountDefDocuments(projectID, resourcePathName, filter)
 
 	if countErr != nil {
-		c.JSON(countErr.Code(), gin.H{"error": countErr.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(countErr))
 		return
 	}
 
 	pageMax := (docCount % iLimit) + docCount
 	if (iLimit+iOffset) > pageMax && iOffset >= docCount && docCount != 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		apierror.Respond(c, http.StatusBadRequest, "invalid page")
 		return
 	}
 
-	documents, dsiErr := h.store.ListDefDocuments(projectID, resourcePathName, iLimit, iOffset, filter, sort, relations)
+	documents, dsiErr := h.store.ListDefDocuments(projectID, resourcePathName, iLimit, iOffset, filter, sort, map[string]string{})
 
 	if dsiErr != nil {
-		c.JSON(dsiErr.Code(), gin.H{"error": dsiErr.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(dsiErr))
 		return
 	}
 
+	if !h.expandRelations(c, projectID, relations, documents...) {
+		return
+	}
+
+	for _, document := range documents {
+		access.Strip(document)
+	}
+
 	links := query.NewLinks(c.Request, iLimit, iOffset, docCount)
 
 	c.PureJSON(http.StatusOK, gin.H{"items": documents, "links": links, "count": docCount})
@@ -205,6 +268,7 @@ func (h *Documents) GetObject(c *gin.Context) {
 	resourceID := c.Param("resourceID")
 	projectID := c.MustGet("projectId").(string)
 	authFilters := c.MustGet("filters").(map[string]interface{})
+	access := middleware.FieldAccess(c)
 
 	relations := make(map[string]string)
 	var resourceDefinition *models.ResourceDefinition
@@ -214,7 +278,7 @@ func (h *Documents) GetObject(c *gin.Context) {
 			// get resource definition if we do not already have it
 			resourceDefinition, err := h.store.GetDefinitionByPathName(projectID, resourcePathName)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "could not retrieve resource definition to validate query parameters"})
+				apierror.Respond(c, http.StatusInternalServerError, "could not retrieve resource definition to validate query parameters")
 				return
 			}
 
@@ -222,24 +286,28 @@ func (h *Documents) GetObject(c *gin.Context) {
 			var pErr error
 			validSchema, pErr = resourceDefinition.GetSchema()
 			if pErr != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "error getting schema property types"})
+				apierror.Respond(c, http.StatusInternalServerError, "error getting schema property types")
 				return
 			}
 		}
 
 		for key, value := range validSchema.Properties {
-			if val, ok := value["relation"]; ok && key == v {
+			if val, ok := value["relation"]; ok && key == v && !access.Hidden[key] {
 				relations[key] = val.(string)
 			}
 		}
 	}
 
-	document, err := h.store.GetDefDocument(projectID, resourcePathName, resourceID, authFilters, relations)
+	document, err := h.store.GetDefDocument(projectID, resourcePathName, resourceID, authFilters, map[string]string{})
 
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
+	if !h.expandRelations(c, projectID, relations, document) {
+		return
+	}
+	access.Strip(document)
 
 	c.IndentedJSON(http.StatusOK, document)
 }
@@ -251,16 +319,115 @@ func (h *Documents) DeleteObject(c *gin.Context) {
 	projectID := c.MustGet("projectId").(string)
 	authFilters := c.MustGet("filters").(map[string]interface{})
 
-	err := h.store.DeleteDefDocument(projectID, resourcePathName, resourceID, authFilters)
+	// "before" hooks can reject the delete
+	if _, ok := middleware.ValidateDocument(c, h.validator, models.ActionDelete, models.ResourceObject{"id": resourceID}); !ok {
+		return
+	}
+
+	err := h.store.DeleteDefDocument(projectID, resourcePathName, resourceID, authFilters, middleware.OutboxEvent(c, models.ActionDelete))
 
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
 	c.JSON(http.StatusNoContent, gin.H{})
 }
 
+// writableFields responds with an error if the fields of a write include properties protected from the requester's role
+func writableFields(c *gin.Context, access *models.FieldAccess, fields models.ResourceObject) bool {
+	if err := access.WriteError(c.GetString("authRole"), fields); err != nil {
+		apierror.Respond(c, http.StatusForbidden, err.Error())
+		return false
+	}
+	return true
+}
+
+// expandRelations replaces the relation properties of the documents with the related documents. Related documents are
+// retrieved with the access policies of the related resource and stripped of the properties hidden from the requester's
+// role, documents the requester cannot read are not expanded. The error is written to the response if the requester is
+// not authorized for the related resource.
+func (h *Documents) expandRelations(c *gin.Context, projectID string, relations map[string]string, documents ...map[string]interface{}) bool {
+	requester, _ := c.Value("requester").(*middleware.Requester)
+
+	for key, pathName := range relations {
+		def, dsiErr := h.store.GetDefinitionByPathName(projectID, pathName)
+		if dsiErr != nil {
+			apierror.Respond(c, http.StatusInternalServerError, fmt.Sprintf("could not retrieve the resource definition of '%s'", key))
+			return false
+		}
+
+		// requests without a project requester, i.e. of app users, have access to every document
+		filters := map[string]interface{}{}
+		access := &models.FieldAccess{}
+		if requester != nil {
+			var status int
+			var err error
+			filters, status, err = middleware.AuthorizeResource(def, http.MethodGet, requester)
+			if err != nil {
+				apierror.Respond(c, status, fmt.Sprintf("unable to expand '%s': %s", key, err.Error()))
+				return false
+			}
+			access = requester.FieldAccess(def)
+		}
+
+		// documents can relate to the same document, which is retrieved once
+		related := map[string]map[string]interface{}{}
+		for _, document := range documents {
+			for _, id := range relationIDs(document[key]) {
+				if _, ok := related[id]; ok {
+					continue
+				}
+				relatedDoc, dsiErr := h.store.GetDefDocument(projectID, def.PathName, id, filters, map[string]string{})
+				if dsiErr != nil && dsiErr.Code() != http.StatusNotFound {
+					apierror.RespondError(c, apierror.FromDatastore(dsiErr))
+					return false
+				}
+				if relatedDoc != nil {
+					access.Strip(relatedDoc)
+				}
+				related[id] = relatedDoc
+			}
+		}
+
+		for _, document := range documents {
+			switch value := document[key].(type) {
+			case string:
+				if relatedDoc := related[value]; relatedDoc != nil {
+					document[key] = relatedDoc
+				}
+			case []interface{}:
+				values := make([]interface{}, 0, len(value))
+				for _, id := range relationIDs(value) {
+					if relatedDoc := related[id]; relatedDoc != nil {
+						values = append(values, relatedDoc)
+					}
+				}
+				document[key] = values
+			}
+		}
+	}
+
+	return true
+}
+
+// relationIDs returns the document IDs of a relation property, a single ID or an array of IDs
+func relationIDs(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		ids := make([]string, 0, len(value))
+		for _, v := range value {
+			if id, ok := v.(string); ok {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	return nil
+}
+
 // probably need to move to a better place
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
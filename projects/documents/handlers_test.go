@@ -0,0 +1,152 @@
+package documents
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	dsiErrors "github.com/machinable/machinable/dsi/errors"
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+// testStore serves documents from memory, unused datastore functions panic
+type testStore struct {
+	interfaces.Datastore
+	definitions map[string]*models.ResourceDefinition
+	documents   map[string][]map[string]interface{}
+	creators    map[string]string
+}
+
+func (s *testStore) GetDefinitionByPathName(projectID, pathName string) (*models.ResourceDefinition, *dsiErrors.DatastoreError) {
+	if def, ok := s.definitions[pathName]; ok {
+		return def, nil
+	}
+	return nil, dsiErrors.New(dsiErrors.NotFound, errors.New("not found"))
+}
+
+func (s *testStore) CountDefDocuments(projectID, path string, filter map[string]interface{}) (int64, *dsiErrors.DatastoreError) {
+	return int64(len(s.documents[path])), nil
+}
+
+func (s *testStore) ListDefDocuments(projectID, path string, limit, offset int64, filter map[string]interface{}, sort map[string]int, relations map[string]string) ([]map[string]interface{}, *dsiErrors.DatastoreError) {
+	documents := make([]map[string]interface{}, 0)
+	for _, doc := range s.documents[path] {
+		documents = append(documents, copyDocument(doc))
+	}
+	return documents, nil
+}
+
+// GetDefDocument only returns the documents created by the requester if the filters include document access
+func (s *testStore) GetDefDocument(projectID, path, documentID string, filter map[string]interface{}, relations map[string]string) (map[string]interface{}, *dsiErrors.DatastoreError) {
+	for _, doc := range s.documents[path] {
+		if doc["id"] != documentID {
+			continue
+		}
+		if access, ok := filter[models.FilterDocumentAccess].(*models.DocumentAccess); ok && s.creators[documentID] != access.UserID {
+			break
+		}
+		return copyDocument(doc), nil
+	}
+	return nil, dsiErrors.New(dsiErrors.NotFound, errors.New("not found"))
+}
+
+func copyDocument(doc map[string]interface{}) map[string]interface{} {
+	copied := map[string]interface{}{}
+	for k, v := range doc {
+		copied[k] = v
+	}
+	return copied
+}
+
+func testRouter(store *testStore, requester *middleware.Requester) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	handler := New(store, nil)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("projectId", "project")
+		c.Set("filters", map[string]interface{}{})
+		c.Set("requester", requester)
+		c.Set("resourceDefinition", store.definitions[c.Param("resourcePathName")])
+	})
+	router.GET("/api/:resourcePathName", handler.ListObjects)
+	router.GET("/api/:resourcePathName/:resourceID", handler.GetObject)
+	return router
+}
+
+func TestRelations(t *testing.T) {
+	store := &testStore{
+		definitions: map[string]*models.ResourceDefinition{
+			"orders": {
+				PathName:     "orders",
+				ParallelRead: true,
+				Schema:       `{"type":"object","properties":{"item":{"type":"string"},"customer":{"type":"string","relation":"customers"},"notes":{"type":"array","items":{"type":"string"},"relation":"notes"}}}`,
+			},
+			"customers": {
+				PathName:     "customers",
+				Read:         true,
+				ParallelRead: true,
+				Schema:       `{"type":"object","properties":{"name":{"type":"string"},"email":{"type":"string","read_roles":["admin"]}}}`,
+			},
+			"notes": {
+				PathName: "notes",
+				Read:     true,
+				Schema:   `{"type":"object","properties":{"text":{"type":"string"}}}`,
+			},
+		},
+		documents: map[string][]map[string]interface{}{
+			"orders":    {{"id": "o1", "item": "book", "customer": "c1", "notes": []interface{}{"n1", "n2"}}},
+			"customers": {{"id": "c1", "name": "Ada", "email": "ada@example.com"}},
+			"notes":     {{"id": "n1", "text": "mine"}, {"id": "n2", "text": "theirs"}},
+		},
+		creators: map[string]string{"n1": "u1", "n2": "u2"},
+	}
+	user := &middleware.Requester{Type: "user", ID: "u1", Role: "user", Read: true}
+	admin := &middleware.Requester{Type: "user", ID: "u2", Role: "admin", Read: true}
+	anonymous := &middleware.Requester{Type: "anonymous", ID: "anonymous", Role: "anonymous"}
+
+	tables := []struct {
+		name      string
+		requester *middleware.Requester
+		url       string
+		status    int
+		body      string
+	}{
+		{"hidden field", user, "/api/orders/o1?_relation=customer", http.StatusOK, `{"customer":{"id":"c1","name":"Ada"},"id":"o1","item":"book","notes":["n1","n2"]}`},
+		{"admin", admin, "/api/orders/o1?_relation=customer", http.StatusOK, `{"customer":{"email":"ada@example.com","id":"c1","name":"Ada"},"id":"o1","item":"book","notes":["n1","n2"]}`},
+		{"creator filters", user, "/api/orders/o1?_relation=notes", http.StatusOK, `{"customer":"c1","id":"o1","item":"book","notes":[{"id":"n1","text":"mine"}]}`},
+		{"list", user, "/api/orders?_relation=customer", http.StatusOK, `[{"customer":{"id":"c1","name":"Ada"},"id":"o1","item":"book","notes":["n1","n2"]}]`},
+		{"unauthorized", anonymous, "/api/orders/o1?_relation=customer", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+			testRouter(store, tt.requester).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			if tt.body == "" {
+				return
+			}
+
+			body := w.Body.Bytes()
+			var list struct {
+				Items json.RawMessage `json:"items"`
+			}
+			if json.Unmarshal(body, &list) == nil && list.Items != nil {
+				body = list.Items
+			}
+			var document interface{}
+			assert.Nil(t, json.Unmarshal(body, &document))
+			actual, _ := json.Marshal(document)
+			assert.Equal(t, tt.body, string(actual))
+		})
+	}
+}
@@ -10,13 +10,13 @@ import (
 )
 
 // SetRoutes sets all of the appropriate routes to handlers for project collections
-func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, cache redis.UniversalClient, processor *events.Processor, config *config.AppConfig) error {
+func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, cache redis.UniversalClient, validator *events.Validator, config *config.AppConfig) error {
 	// create new Resources handler with datastore
-	handler := New(datastore)
+	handler := New(datastore, validator)
 
 	// project/user routes
 	api := engine.Group("/api")
-	api.Use(middleware.ResourceStatsMiddleware(datastore, processor))
+	api.Use(middleware.ResourceStatsMiddleware(datastore))
 	api.Use(middleware.ProjectUserAuthzMiddleware(datastore, config))
 	api.Use(middleware.RequestRateLimit(datastore, cache))
 	api.Use(middleware.ProjectAuthzBuildFiltersMiddleware(datastore))
@@ -27,9 +27,14 @@ func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, cache redis.U
 	api.PUT("/:resourcePathName/:resourceID", handler.PutObject)
 	api.DELETE("/:resourcePathName/:resourceID", handler.DeleteObject)
 
+	// share documents with users and groups
+	api.GET("/:resourcePathName/:resourceID/acl", handler.ListDocumentACL)
+	api.PUT("/:resourcePathName/:resourceID/acl/:principalType/:principalID", handler.GrantDocumentAccess)
+	api.DELETE("/:resourcePathName/:resourceID/acl/:principalType/:principalID", handler.RevokeDocumentAccess)
+
 	// App mgmt routes with different authz policy
 	mgmt := engine.Group("/mgmt")
-	mgmt.Use(middleware.AppUserJwtAuthzMiddleware(config))
+	mgmt.Use(middleware.AppUserJwtAuthzMiddleware(datastore, config))
 	mgmt.Use(middleware.AppUserProjectAuthzMiddleware(datastore, config))
 
 	// mgmt resource usage
@@ -0,0 +1,686 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/events"
+	"github.com/machinable/machinable/middleware"
+)
+
+// Request is a GraphQL request, sent as the JSON body of a POST or as query parameters of a GET
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Response is the result of a GraphQL request. `Data` is omitted if the request could not be executed.
+type Response struct {
+	Data   interface{}      `json:"data,omitempty"`
+	Errors []*ResponseError `json:"errors,omitempty"`
+}
+
+// ResponseError is a request or field error, `Path` points at the field which could not be resolved
+type ResponseError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// errNullPropagated is returned by a non-null field which resolved to null, its parent becomes null instead
+var errNullPropagated = errors.New("null propagated")
+
+const (
+	// maxDepth is the maximum nesting of the selected fields, root fields are at depth 1
+	maxDepth = 10
+	// maxFields is the maximum number of selected fields, including aliases and the fields of each fragment spread
+	maxFields = 500
+)
+
+// executor executes a single request against the project resources, on behalf of the requester
+type executor struct {
+	schema       *Schema
+	store        interfaces.ResourcesDatastore
+	validator    *events.Validator
+	project      *models.ProjectDetail
+	requester    *middleware.Requester
+	triggerHooks bool
+
+	doc       *document
+	variables map[string]interface{}
+	errors    []*ResponseError
+
+	// documents memoizes `getDocument` by resource and id for the request, writes reset it
+	documents map[string]*documentResult
+}
+
+// documentResult is a memoized result of `getDocument`
+type documentResult struct {
+	document interface{}
+	err      error
+}
+
+// execute runs the request, GET requests can not execute mutations
+func (ex *executor) execute(req *Request, allowMutation bool) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return requestError(err)
+	}
+	ex.doc = doc
+
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return requestError(err)
+	}
+
+	root, ok := ex.schema.objects[typeQuery]
+	if op.kind == "mutation" {
+		if !allowMutation {
+			return requestError(errors.New("mutations must be sent with POST"))
+		}
+		if root, ok = ex.schema.objects[typeMutation]; !ok {
+			return requestError(errors.New("schema does not support mutations"))
+		}
+	}
+
+	if ex.variables, err = ex.coerceVariables(op, req.Variables); err != nil {
+		return requestError(err)
+	}
+
+	fields := 0
+	if err := ex.validateSelections(root, op.selections, map[string]bool{}, 1, &fields); err != nil {
+		return requestError(err)
+	}
+
+	data, err := ex.executeSelections(root, nil, op.selections, []interface{}{})
+	if err == errNullPropagated {
+		data = nil
+	}
+
+	return &Response{Data: data, Errors: ex.errors}
+}
+
+func requestError(err error) *Response {
+	return &Response{Errors: []*ResponseError{{Message: err.Error()}}}
+}
+
+// operation returns the operation to execute, the name is required if the document contains several operations
+func (doc *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, errors.New("operationName is required for documents with multiple operations")
+		}
+		return doc.operations[0], nil
+	}
+
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown operation '%s'", name)
+}
+
+// coerceVariables validates the provided variables against the variable definitions of the operation
+func (ex *executor) coerceVariables(op *operation, provided map[string]interface{}) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+	for _, def := range op.variables {
+		value, ok := provided[def.name]
+		if !ok && def.defaultValue != nil {
+			defaultValue, err := ex.literal(def.defaultValue)
+			if err != nil {
+				return nil, err
+			}
+			value, ok = defaultValue, true
+		}
+
+		if !ok {
+			if def.typ.nonNull {
+				return nil, fmt.Errorf("variable '$%s' of type '%s' is required", def.name, def.typ)
+			}
+			continue
+		}
+
+		coerced, err := ex.coerceInput(def.typ.String(), value)
+		if err != nil {
+			return nil, fmt.Errorf("variable '$%s': %s", def.name, err.Error())
+		}
+		variables[def.name] = coerced
+	}
+
+	return variables, nil
+}
+
+// literal converts the argument value to a Go value, resolving variables
+func (ex *executor) literal(v value) (interface{}, error) {
+	switch val := v.(type) {
+	case variableValue:
+		return ex.variables[string(val)], nil
+	case enumValue:
+		return string(val), nil
+	case listValue:
+		list := make([]interface{}, 0, len(val))
+		for _, item := range val {
+			lit, err := ex.literal(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, lit)
+		}
+		return list, nil
+	case objectValue:
+		obj := map[string]interface{}{}
+		for _, f := range val {
+			lit, err := ex.literal(f.value)
+			if err != nil {
+				return nil, err
+			}
+			obj[f.name] = lit
+		}
+		return obj, nil
+	}
+
+	return v, nil
+}
+
+// coerceInput validates and converts the input value to the SDL type
+func (ex *executor) coerceInput(typ string, v interface{}) (interface{}, error) {
+	if strings.HasSuffix(typ, "!") {
+		if v == nil {
+			return nil, fmt.Errorf("expected non-null value of type '%s'", typ)
+		}
+		return ex.coerceInput(strings.TrimSuffix(typ, "!"), v)
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(typ, "[") {
+		elem := typ[1 : len(typ)-1]
+		items, ok := v.([]interface{})
+		if !ok {
+			// a single value is coerced to a list of one
+			items = []interface{}{v}
+		}
+		list := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			coerced, err := ex.coerceInput(elem, item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, coerced)
+		}
+		return list, nil
+	}
+
+	switch typ {
+	case "String":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case "ID":
+		switch val := v.(type) {
+		case string:
+			return val, nil
+		case int64:
+			return fmt.Sprint(val), nil
+		case float64:
+			if val == math.Trunc(val) {
+				return fmt.Sprint(int64(val)), nil
+			}
+		}
+	case "Int":
+		switch val := v.(type) {
+		case int64:
+			return val, nil
+		case float64:
+			if val == math.Trunc(val) {
+				return int64(val), nil
+			}
+		}
+	case "Float":
+		switch val := v.(type) {
+		case int64:
+			return float64(val), nil
+		case float64:
+			return val, nil
+		}
+	case "Boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case typeJSON:
+		return v, nil
+	default:
+		input, ok := ex.schema.inputs[typ]
+		if !ok {
+			return nil, fmt.Errorf("unknown input type '%s'", typ)
+		}
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object of type '%s'", typ)
+		}
+
+		coerced := map[string]interface{}{}
+		for key, val := range obj {
+			f := input.field(key)
+			if f == nil {
+				return nil, fmt.Errorf("unknown field '%s' of input type '%s'", key, typ)
+			}
+			c, err := ex.coerceInput(f.typ, val)
+			if err != nil {
+				return nil, fmt.Errorf("field '%s': %s", key, err.Error())
+			}
+			coerced[key] = c
+		}
+		for _, f := range input.fields {
+			if _, ok := obj[f.name]; !ok && strings.HasSuffix(f.typ, "!") {
+				return nil, fmt.Errorf("field '%s' of input type '%s' is required", f.name, typ)
+			}
+		}
+		return coerced, nil
+	}
+
+	return nil, fmt.Errorf("expected value of type '%s'", typ)
+}
+
+// validateSelections verifies every selected field exists on the type and leaf fields do not have selections. The
+// selections must not be nested deeper than `maxDepth` or select more than `maxFields` fields, `fields` counts the
+// fields selected so far.
+func (ex *executor) validateSelections(obj *objectType, selections []selection, visited map[string]bool, depth int, fields *int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("query exceeds the maximum depth of %d", maxDepth)
+	}
+
+	for _, sel := range selections {
+		switch s := sel.(type) {
+		case *field:
+			*fields++
+			if *fields > maxFields {
+				return fmt.Errorf("query exceeds the maximum of %d fields", maxFields)
+			}
+			if s.name == "__typename" {
+				continue
+			}
+			f, ok := obj.lookup[s.name]
+			if !ok {
+				return fmt.Errorf("cannot query field '%s' on type '%s'", s.name, obj.name)
+			}
+			for _, arg := range s.arguments {
+				if argumentDefinition(f, arg.name) == nil {
+					return fmt.Errorf("unknown argument '%s' on field '%s.%s'", arg.name, obj.name, s.name)
+				}
+			}
+
+			child, isObject := ex.schema.objects[namedType(f.typ)]
+			if !isObject {
+				if len(s.selections) > 0 {
+					return fmt.Errorf("field '%s' of type '%s' must not have a selection", s.name, f.typ)
+				}
+				continue
+			}
+			if len(s.selections) == 0 {
+				return fmt.Errorf("field '%s' of type '%s' must have a selection of subfields", s.name, f.typ)
+			}
+			if err := ex.validateSelections(child, s.selections, visited, depth+1, fields); err != nil {
+				return err
+			}
+		case *fragmentSpread:
+			frag, ok := ex.doc.fragments[s.name]
+			if !ok {
+				return fmt.Errorf("unknown fragment '%s'", s.name)
+			}
+			if visited[s.name] {
+				return fmt.Errorf("fragment '%s' spreads itself", s.name)
+			}
+			if frag.typeCondition != obj.name {
+				continue
+			}
+			visited[s.name] = true
+			err := ex.validateSelections(obj, frag.selections, visited, depth, fields)
+			delete(visited, s.name)
+			if err != nil {
+				return err
+			}
+		case *inlineFragment:
+			if s.typeCondition != "" && s.typeCondition != obj.name {
+				continue
+			}
+			if err := ex.validateSelections(obj, s.selections, visited, depth, fields); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func argumentDefinition(f *objectField, name string) *inputValue {
+	for _, arg := range f.args {
+		if arg.name == name {
+			return arg
+		}
+	}
+	return nil
+}
+
+// collectFields groups the selected fields by their response key, in selection order
+func (ex *executor) collectFields(obj *objectType, selections []selection, keys *[]string, fields map[string][]*field) error {
+	for _, sel := range selections {
+		switch s := sel.(type) {
+		case *field:
+			include, err := ex.included(s.directives)
+			if err != nil {
+				return err
+			}
+			if !include {
+				continue
+			}
+			key := s.responseKey()
+			if _, ok := fields[key]; !ok {
+				*keys = append(*keys, key)
+			}
+			fields[key] = append(fields[key], s)
+		case *fragmentSpread:
+			include, err := ex.included(s.directives)
+			if err != nil {
+				return err
+			}
+			frag := ex.doc.fragments[s.name]
+			if !include || frag.typeCondition != obj.name {
+				continue
+			}
+			if err := ex.collectFields(obj, frag.selections, keys, fields); err != nil {
+				return err
+			}
+		case *inlineFragment:
+			include, err := ex.included(s.directives)
+			if err != nil {
+				return err
+			}
+			if !include || (s.typeCondition != "" && s.typeCondition != obj.name) {
+				continue
+			}
+			if err := ex.collectFields(obj, s.selections, keys, fields); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// included evaluates the `@include` and `@skip` directives
+func (ex *executor) included(directives []*directive) (bool, error) {
+	for _, d := range directives {
+		if d.name != "include" && d.name != "skip" {
+			continue
+		}
+		var condition interface{}
+		for _, arg := range d.arguments {
+			if arg.name == "if" {
+				lit, err := ex.literal(arg.value)
+				if err != nil {
+					return false, err
+				}
+				condition = lit
+			}
+		}
+		b, ok := condition.(bool)
+		if !ok {
+			return false, fmt.Errorf("directive '@%s' requires a boolean 'if' argument", d.name)
+		}
+		if (d.name == "include" && !b) || (d.name == "skip" && b) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// executeSelections resolves the selected fields of the object type from the source value
+func (ex *executor) executeSelections(obj *objectType, source interface{}, selections []selection, path []interface{}) (*orderedMap, error) {
+	keys := []string{}
+	fields := map[string][]*field{}
+	if err := ex.collectFields(obj, selections, &keys, fields); err != nil {
+		ex.addError(err, path)
+		return nil, errNullPropagated
+	}
+
+	result := newOrderedMap()
+	for _, key := range keys {
+		f := fields[key][0]
+		fieldPath := appendPath(path, key)
+
+		if f.name == "__typename" {
+			result.set(key, obj.name)
+			continue
+		}
+
+		def := obj.lookup[f.name]
+		value, err := ex.resolveField(def, source, f, fieldPath)
+		if err == nil {
+			value, err = ex.completeValue(def.typ, value, mergeSelections(fields[key]), fieldPath)
+		}
+		if err != nil {
+			if err != errNullPropagated {
+				ex.addError(err, fieldPath)
+			}
+			if strings.HasSuffix(def.typ, "!") {
+				return nil, errNullPropagated
+			}
+			value = nil
+		}
+
+		result.set(key, value)
+	}
+
+	return result, nil
+}
+
+// resolveField coerces the arguments of the field and calls its resolver
+func (ex *executor) resolveField(def *objectField, source interface{}, f *field, path []interface{}) (interface{}, error) {
+	args := map[string]interface{}{}
+	provided := map[string]value{}
+	for _, arg := range f.arguments {
+		provided[arg.name] = arg.value
+	}
+
+	for _, argDef := range def.args {
+		v, ok := provided[argDef.name]
+		if variable, isVariable := v.(variableValue); isVariable {
+			_, ok = ex.variables[string(variable)]
+		}
+
+		var raw interface{}
+		if ok {
+			lit, err := ex.literal(v)
+			if err != nil {
+				return nil, err
+			}
+			raw = lit
+		} else if argDef.defaultValue != "" {
+			doc, err := parseValue(argDef.defaultValue)
+			if err != nil {
+				return nil, err
+			}
+			raw = doc
+			ok = true
+		}
+
+		if !ok {
+			if strings.HasSuffix(argDef.typ, "!") {
+				return nil, fmt.Errorf("argument '%s' of type '%s' is required", argDef.name, argDef.typ)
+			}
+			continue
+		}
+
+		coerced, err := ex.coerceInput(argDef.typ, raw)
+		if err != nil {
+			return nil, fmt.Errorf("argument '%s': %s", argDef.name, err.Error())
+		}
+		args[argDef.name] = coerced
+	}
+
+	return def.resolve(ex, source, args)
+}
+
+// parseValue parses a constant SDL literal such as an argument default value
+func parseValue(literal string) (interface{}, error) {
+	p := &parser{lex: &lexer{src: literal}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	v, err := p.value(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return (&executor{}).literal(v)
+}
+
+// completeValue shapes the resolved value according to the SDL type, executing the selections of object types
+func (ex *executor) completeValue(typ string, value interface{}, selections []selection, path []interface{}) (interface{}, error) {
+	if strings.HasSuffix(typ, "!") {
+		completed, err := ex.completeValue(strings.TrimSuffix(typ, "!"), value, selections, path)
+		if err != nil {
+			return nil, err
+		}
+		if completed == nil {
+			return nil, fmt.Errorf("non-null field resolved to null")
+		}
+		return completed, nil
+	}
+	if isNil(value) {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(typ, "[") {
+		elem := typ[1 : len(typ)-1]
+		items, ok := toList(value)
+		if !ok {
+			return nil, fmt.Errorf("expected a list of '%s'", elem)
+		}
+		list := make([]interface{}, 0, len(items))
+		for i, item := range items {
+			itemPath := appendPath(path, i)
+			completed, err := ex.completeValue(elem, item, selections, itemPath)
+			if err != nil {
+				if err != errNullPropagated {
+					ex.addError(err, itemPath)
+				}
+				if strings.HasSuffix(elem, "!") {
+					return nil, errNullPropagated
+				}
+				completed = nil
+			}
+			list = append(list, completed)
+		}
+		return list, nil
+	}
+
+	obj, ok := ex.schema.objects[typ]
+	if !ok {
+		return value, nil
+	}
+
+	return ex.executeSelections(obj, value, selections, path)
+}
+
+// mergeSelections combines the selections of fields with the same response key
+func mergeSelections(fields []*field) []selection {
+	if len(fields) == 1 {
+		return fields[0].selections
+	}
+
+	merged := []selection{}
+	for _, f := range fields {
+		merged = append(merged, f.selections...)
+	}
+	return merged
+}
+
+func (ex *executor) addError(err error, path []interface{}) {
+	ex.errors = append(ex.errors, &ResponseError{Message: err.Error(), Path: path})
+}
+
+func appendPath(path []interface{}, key interface{}) []interface{} {
+	p := make([]interface{}, len(path), len(path)+1)
+	copy(p, path)
+	return append(p, key)
+}
+
+func isNil(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return v == nil
+	case *orderedMap:
+		return v == nil
+	}
+	return false
+}
+
+func toList(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case []map[string]interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			list = append(list, item)
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+// orderedMap is a JSON object which keeps the order of its keys, response fields are ordered as selected
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: map[string]interface{}{}}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// MarshalJSON writes the keys in insertion order
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,305 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/machinable/machinable/dsi/errors"
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+// testStore serves documents from memory, unused datastore functions panic
+type testStore struct {
+	interfaces.ResourcesDatastore
+	documents map[string][]map[string]interface{}
+	filters   []map[string]interface{}
+	added     models.ResourceObject
+	gets      int
+}
+
+func (s *testStore) CountDefDocuments(projectID, path string, filter map[string]interface{}) (int64, *errors.DatastoreError) {
+	return int64(len(s.documents[path])), nil
+}
+
+func (s *testStore) ListDefDocuments(projectID, path string, limit, offset int64, filter map[string]interface{}, sort map[string]int, relations map[string]string) ([]map[string]interface{}, *errors.DatastoreError) {
+	s.filters = append(s.filters, filter)
+	return s.documents[path], nil
+}
+
+func (s *testStore) GetDefDocument(projectID, path, documentID string, filter map[string]interface{}, relations map[string]string) (map[string]interface{}, *errors.DatastoreError) {
+	s.gets++
+	for _, doc := range s.documents[path] {
+		if doc["id"] == documentID {
+			return doc, nil
+		}
+	}
+	return nil, errors.New(errors.NotFound, errNullPropagated)
+}
+
+func (s *testStore) AddDefDocument(projectID, path string, fields models.ResourceObject, metadata *models.MetaData, event *models.OutboxEvent) (string, *errors.DatastoreError) {
+	s.added = fields
+	return "new-id", nil
+}
+
+var testDefinitions = []*models.ResourceDefinition{
+	{
+		ID:       "def-posts",
+		PathName: "blog-posts",
+		Read:     true,
+		Create:   false,
+		Schema:   `{"type":"object","required":["title"],"properties":{"title":{"type":"string"},"views":{"type":"integer"},"tags":{"type":"array","items":{"type":"string"}},"author":{"type":"string","relation":"people"},"bad-name":{"type":"string"}}}`,
+	},
+	{
+		ID:           "def-people",
+		PathName:     "people",
+		Read:         true,
+		ParallelRead: true,
+		Schema:       `{"type":"object","properties":{"name":{"type":"string"}}}`,
+	},
+}
+
+func newTestExecutor(store *testStore, requester *middleware.Requester) *executor {
+	return &executor{
+		schema:    NewSchema(testDefinitions),
+		store:     store,
+		project:   &models.ProjectDetail{ID: "project"},
+		requester: requester,
+	}
+}
+
+func marshal(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	assert.Nil(t, err)
+	return string(b)
+}
+
+func TestParse(t *testing.T) {
+	tables := []struct {
+		name  string
+		query string
+		valid bool
+	}{
+		{"shorthand", `{ blogPosts { items { id } } }`, true},
+		{"named with variables", `query Posts($limit: Int = 5, $ids: [ID!]!) { blogPosts(limit: $limit) { count } }`, true},
+		{"fragments", `{ ...F } fragment F on Query { blogPosts { count } }`, true},
+		{"inline fragment and directives", `{ ... on Query @include(if: true) { a: blogPosts { count } } }`, true},
+		{"block string", "{ a(b: \"\"\"\n  text\n\"\"\") }", true},
+		{"object and list values", `{ a(b: {c: [1, 2.5, "x", ENUM, null]}) }`, true},
+		{"unterminated", `{ blogPosts { items }`, false},
+		{"empty selection", `{ }`, false},
+		{"subscription", `subscription { a }`, false},
+		{"no operation", `fragment F on Query { a }`, false},
+		{"variable in default", `query ($a: Int = $b) { a }`, false},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(tt.query)
+			assert.Equal(t, tt.valid, err == nil, "%v", err)
+		})
+	}
+}
+
+func TestSchemaSDL(t *testing.T) {
+	sdl := NewSchema(testDefinitions).SDL()
+
+	assert.Contains(t, sdl, "type BlogPosts {\n  id: ID!\n  _metadata: Metadata\n  author: People\n  tags: [String]\n  title: String\n  views: Int\n}")
+	assert.Contains(t, sdl, "input BlogPostsInput {\n  author: ID\n  tags: [String]\n  title: String!\n  views: Int\n}")
+	assert.Contains(t, sdl, "blogPosts(filter: BlogPostsFilter, sort: String, limit: Int = 10, offset: Int = 0): BlogPostsPage")
+	assert.Contains(t, sdl, "createBlogPosts(input: BlogPostsInput!): BlogPosts")
+	assert.NotContains(t, sdl, "bad-name")
+
+	empty := NewSchema(nil).SDL()
+	assert.Contains(t, empty, "type Query {\n  _empty: Boolean\n}")
+	assert.NotContains(t, empty, "type Mutation")
+}
+
+func TestExecute(t *testing.T) {
+	store := &testStore{documents: map[string][]map[string]interface{}{
+		"blog-posts": {{"id": "p1", "title": "Hello", "views": float64(3), "author": "u1"}},
+		"people":     {{"id": "u1", "name": "Ada"}},
+	}}
+	user := &middleware.Requester{Type: "user", ID: "u1", Role: "user", Read: true, Write: true}
+
+	res := newTestExecutor(store, user).execute(&Request{
+		Query: `query ($min: Int) {
+			posts: blogPosts(filter: {views: $min}, sort: "-views") { count items { ...Post } }
+			missing: peopleById(id: "nope") { name }
+		}
+		fragment Post on BlogPosts { __typename title views author { name } }`,
+		Variables: map[string]interface{}{"min": float64(3)},
+	}, false)
+
+	assert.Nil(t, res.Errors)
+	assert.Equal(
+		t,
+		`{"posts":{"count":1,"items":[{"__typename":"BlogPosts","title":"Hello","views":3,"author":{"name":"Ada"}}]},"missing":null}`,
+		marshal(t, res.Data),
+	)
+
+	// the filter argument is compared as text, the documents of the private resource are limited to those created by or
+	// shared with the user
+	assert.Equal(t, map[string]interface{}{"views": "3", models.FilterDocumentAccess: &models.DocumentAccess{UserID: "u1"}}, store.filters[0])
+}
+
+func TestExecuteCustomRole(t *testing.T) {
+	store := &testStore{documents: map[string][]map[string]interface{}{
+		"blog-posts": {{"id": "p1", "title": "Hello", "views": float64(3)}},
+	}}
+	editor := &middleware.Requester{
+		Type:       "user",
+		ID:         "u1",
+		Role:       "editor",
+		Read:       true,
+		Attributes: map[string]interface{}{"level": float64(3)},
+		CustomRole: &models.Role{Name: "editor", Permissions: []*models.RolePermission{
+			{Resource: "blog-posts", Verbs: []string{models.VerbRead}, Conditions: []string{"views == user.level && title == 'Hello'"}},
+		}},
+	}
+
+	res := newTestExecutor(store, editor).execute(&Request{Query: `{ blogPosts { count } people { count } }`}, false)
+
+	assert.Equal(t, `{"data":{"blogPosts":{"count":1},"people":null},"errors":[{"message":"role 'editor' does not have permission to 'GET' 'people'","path":["people"]}]}`, marshal(t, res))
+
+	// the conditions of the role replace the creator filter
+	assert.Equal(t, map[string]interface{}{"views": "3", "title": "Hello"}, store.filters[0])
+}
+
+func TestExecuteErrors(t *testing.T) {
+	store := &testStore{documents: map[string][]map[string]interface{}{}}
+	anonymous := &middleware.Requester{Type: "anonymous", ID: "anonymous", Role: "anonymous"}
+
+	tables := []struct {
+		name     string
+		query    string
+		post     bool
+		response string
+	}{
+		{"unknown field", `{ blogPosts { items { nope } } }`, true, `{"errors":[{"message":"cannot query field 'nope' on type 'BlogPosts'"}]}`},
+		{"leaf selection", `{ blogPosts { count { a } } }`, true, `{"errors":[{"message":"field 'count' of type 'Int!' must not have a selection"}]}`},
+		{"mutation with GET", `mutation { deleteBlogPosts(id: "1") }`, false, `{"errors":[{"message":"mutations must be sent with POST"}]}`},
+		{"required input field", `mutation { createBlogPosts(input: {views: 1}) { id } }`, true, `{"data":{"createBlogPosts":null},"errors":[{"message":"argument 'input': field 'title' of input type 'BlogPostsInput' is required","path":["createBlogPosts"]}]}`},
+		{"anonymous read of protected resource", `{ people { count } }`, false, `{"data":{"people":null},"errors":[{"message":"access token required","path":["people"]}]}`},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newTestExecutor(store, anonymous).execute(&Request{Query: tt.query}, tt.post)
+			assert.Equal(t, tt.response, marshal(t, res))
+		})
+	}
+}
+
+func TestExecuteMutation(t *testing.T) {
+	store := &testStore{}
+	anonymous := &middleware.Requester{Type: "anonymous", ID: "anonymous", Role: "anonymous"}
+
+	res := newTestExecutor(store, anonymous).execute(&Request{
+		Query:     `mutation ($input: BlogPostsInput!) { createBlogPosts(input: $input) { id title _metadata { creator_type } } }`,
+		Variables: map[string]interface{}{"input": map[string]interface{}{"title": "New", "views": float64(1)}},
+	}, true)
+
+	assert.Nil(t, res.Errors)
+	assert.Equal(t, `{"createBlogPosts":{"id":"new-id","title":"New","_metadata":{"creator_type":"anonymous"}}}`, marshal(t, res.Data))
+	assert.Equal(t, models.ResourceObject{"title": "New", "views": int64(1)}, store.added)
+}
+
+func TestExecuteFieldAccess(t *testing.T) {
+	store := &testStore{documents: map[string][]map[string]interface{}{
+		"orders": {{"id": "o1", "item": "book", "cost": 12.5}},
+	}}
+	user := &middleware.Requester{Type: "user", ID: "u1", Role: "user", Read: true, Write: true}
+	orders := NewSchema([]*models.ResourceDefinition{{
+		ID:           "def-orders",
+		PathName:     "orders",
+		Read:         true,
+		Create:       true,
+		ParallelRead: true,
+		Schema:       `{"type":"object","properties":{"item":{"type":"string"},"cost":{"type":"number","read_roles":["billing"],"write_roles":["billing"]}}}`,
+	}})
+
+	tables := []struct {
+		name     string
+		query    string
+		response string
+	}{
+		{"hidden field", `{ orders { items { item cost } } }`, `{"data":{"orders":{"items":[{"item":"book","cost":null}]}}}`},
+		{"filter on hidden field", `{ orders(filter: {cost: 12.5}) { count } }`, `{"data":{"orders":null},"errors":[{"message":"unable to filter on 'cost'","path":["orders"]}]}`},
+		{"write protected field", `mutation { createOrders(input: {item: "pen", cost: 1}) { id } }`, `{"data":{"createOrders":null},"errors":[{"message":"role 'user' cannot write 'cost'","path":["createOrders"]}]}`},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newTestExecutor(store, user)
+			ex.schema = orders
+			res := ex.execute(&Request{Query: tt.query}, true)
+			assert.Equal(t, tt.response, marshal(t, res))
+		})
+	}
+}
+
+func TestExecuteLimits(t *testing.T) {
+	store := &testStore{documents: map[string][]map[string]interface{}{}}
+	user := &middleware.Requester{Type: "user", ID: "u1", Role: "user", Read: true}
+
+	// each level of nested fragments doubles the number of fields
+	fragments := "fragment F0 on BlogPostsPage { count }"
+	for i := 1; i <= 10; i++ {
+		fragments += fmt.Sprintf(" fragment F%d on BlogPostsPage { ...F%d ...F%d }", i, i-1, i-1)
+	}
+
+	tables := []struct {
+		name     string
+		query    string
+		response string
+	}{
+		{"aliases", "{ " + strings.Repeat("a: blogPosts { count } ", maxFields/2+1) + "}", `{"errors":[{"message":"query exceeds the maximum of 500 fields"}]}`},
+		{"fragments", "{ blogPosts { ...F10 } } " + fragments, `{"errors":[{"message":"query exceeds the maximum of 500 fields"}]}`},
+		{"fields", "{ " + strings.Repeat("a: blogPosts { count } ", maxFields/2) + "}", `{"data":{"a":{"count":0}}}`},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newTestExecutor(store, user).execute(&Request{Query: tt.query}, false)
+			assert.Equal(t, tt.response, marshal(t, res))
+		})
+	}
+}
+
+func TestExecuteRelationDepth(t *testing.T) {
+	// the node is its own parent
+	store := &testStore{documents: map[string][]map[string]interface{}{
+		"nodes": {{"id": "n1", "name": "root", "parent": "n1"}},
+	}}
+	user := &middleware.Requester{Type: "user", ID: "u1", Role: "user", Read: true}
+	ex := func() *executor {
+		return &executor{
+			schema: NewSchema([]*models.ResourceDefinition{{
+				ID:           "def-nodes",
+				PathName:     "nodes",
+				Read:         true,
+				ParallelRead: true,
+				Schema:       `{"type":"object","properties":{"name":{"type":"string"},"parent":{"type":"string","relation":"nodes"}}}`,
+			}}),
+			store:     store,
+			project:   &models.ProjectDetail{ID: "project"},
+			requester: user,
+		}
+	}
+	nested := func(parents int) string {
+		return `{ nodesById(id: "n1") { ` + strings.Repeat("parent { ", parents) + "name" + strings.Repeat(" }", parents) + " } }"
+	}
+
+	// the name is at the maximum depth, the related document is retrieved once
+	res := ex().execute(&Request{Query: nested(maxDepth - 2)}, false)
+	assert.Nil(t, res.Errors)
+	assert.Equal(t, 1, store.gets)
+
+	res = ex().execute(&Request{Query: nested(maxDepth - 1)}, false)
+	assert.Equal(t, `{"errors":[{"message":"query exceeds the maximum depth of 10"}]}`, marshal(t, res))
+}
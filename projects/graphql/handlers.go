@@ -0,0 +1,93 @@
+package graphql
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/events"
+	"github.com/machinable/machinable/middleware"
+)
+
+// New returns a pointer to a new `GraphQL`
+func New(db interfaces.ResourcesDatastore, validator *events.Validator) *GraphQL {
+	return &GraphQL{
+		store:     db,
+		validator: validator,
+	}
+}
+
+// GraphQL contains the datastore and any HTTP handlers for the project GraphQL endpoint
+type GraphQL struct {
+	store     interfaces.ResourcesDatastore
+	validator *events.Validator
+}
+
+// Query executes a GraphQL request. POST requests send the request as JSON, GET requests as query parameters and can
+// only execute queries.
+func (g *GraphQL) Query(c *gin.Context) {
+	req := &Request{}
+	if c.Request.Method == http.MethodPost {
+		if err := c.BindJSON(req); err != nil {
+			c.JSON(http.StatusBadRequest, requestError(err))
+			return
+		}
+	} else {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				c.JSON(http.StatusBadRequest, requestError(err))
+				return
+			}
+		}
+	}
+
+	schema, ok := g.schema(c)
+	if !ok {
+		return
+	}
+
+	ex := &executor{
+		schema:       schema,
+		store:        g.store,
+		validator:    g.validator,
+		project:      c.MustGet("projectObject").(*models.ProjectDetail),
+		requester:    c.MustGet("requester").(*middleware.Requester),
+		triggerHooks: c.Request.Header.Get("X-Trigger-Hooks") != "false",
+	}
+
+	res := ex.execute(req, c.Request.Method == http.MethodPost)
+	if res.Data == nil && len(res.Errors) > 0 {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GetSchema returns the GraphQL schema of the project in the schema definition language
+func (g *GraphQL) GetSchema(c *gin.Context) {
+	schema, ok := g.schema(c)
+	if !ok {
+		return
+	}
+
+	c.String(http.StatusOK, schema.SDL())
+}
+
+// schema generates the schema from the current resource definitions of the project
+func (g *GraphQL) schema(c *gin.Context) (*Schema, bool) {
+	projectID := c.MustGet("projectId").(string)
+
+	definitions, err := g.store.ListDefinitions(projectID)
+	if err != nil {
+		apierror.RespondError(c, apierror.FromDatastore(err))
+		return nil, false
+	}
+
+	return NewSchema(definitions), true
+}
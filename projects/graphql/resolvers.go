@@ -0,0 +1,322 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/events"
+	"github.com/machinable/machinable/middleware"
+	"github.com/machinable/machinable/query"
+)
+
+// errDocumentConditions is returned for created or updated documents outside of the conditions of the requester's role
+var errDocumentConditions = errors.New("document does not match the conditions of the requester's role")
+
+// mapResolver resolves the key of a document or metadata map
+func mapResolver(key string) resolveFunc {
+	return func(ex *executor, source interface{}, args map[string]interface{}) (interface{}, error) {
+		if m, ok := source.(map[string]interface{}); ok {
+			return m[key], nil
+		}
+		return nil, nil
+	}
+}
+
+// metadataResolver resolves the `_metadata` of a document, newly created documents hold a `*models.MetaData`
+func metadataResolver(ex *executor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	doc, ok := source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	switch meta := doc["_metadata"].(type) {
+	case *models.MetaData:
+		return meta.Map(), nil
+	case map[string]interface{}:
+		return meta, nil
+	}
+
+	return nil, nil
+}
+
+// relationResolver resolves the related document of a relation property, with the access policies of the related
+// resource
+func relationResolver(key string, related *resourceType) resolveFunc {
+	return func(ex *executor, source interface{}, args map[string]interface{}) (interface{}, error) {
+		doc, ok := source.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		id, ok := doc[key].(string)
+		if !ok || id == "" {
+			return nil, nil
+		}
+
+		return ex.getDocument(related, id)
+	}
+}
+
+// listResolver lists the documents of the resource with the `filter`, `sort`, `limit` and `offset` arguments
+func listResolver(res *resourceType) resolveFunc {
+	return func(ex *executor, source interface{}, args map[string]interface{}) (interface{}, error) {
+		authFilters, err := ex.authorize(res, http.MethodGet)
+		if err != nil {
+			return nil, err
+		}
+
+		limit, _ := args["limit"].(int64)
+		offset, _ := args["offset"].(int64)
+		if limit < 1 || limit > query.MaxLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", query.MaxLimit)
+		}
+		if offset < 0 {
+			return nil, errors.New("offset cannot be negative")
+		}
+
+		// values are compared as text, the same as REST query parameters. Hidden properties cannot be used to infer
+		// their values.
+		access := ex.requester.FieldAccess(res.def)
+		filter := map[string]interface{}{}
+		if fields, ok := args["filter"].(map[string]interface{}); ok {
+			for key, value := range fields {
+				if value != nil {
+					if access.Hidden[key] {
+						return nil, fmt.Errorf("unable to filter on '%s'", key)
+					}
+					filter[key] = fmt.Sprint(value)
+				}
+			}
+		}
+		for key, value := range authFilters {
+			filter[key] = value
+		}
+
+		sort := map[string]int{}
+		if field, ok := args["sort"].(string); ok && field != "" {
+			order := 1
+			if strings.HasPrefix(field, "-") {
+				order = -1
+				field = field[1:]
+			}
+			if (!res.properties[field] || access.Hidden[field]) && field != "_metadata.created" {
+				return nil, fmt.Errorf("unable to sort on '%s'", field)
+			}
+			sort[field] = order
+		}
+
+		count, dsiErr := ex.store.CountDefDocuments(ex.project.ID, res.def.PathName, filter)
+		if dsiErr != nil {
+			return nil, dsiErr
+		}
+
+		documents, dsiErr := ex.store.ListDefDocuments(ex.project.ID, res.def.PathName, limit, offset, filter, sort, map[string]string{})
+		if dsiErr != nil {
+			return nil, dsiErr
+		}
+
+		for _, document := range documents {
+			access.Strip(document)
+		}
+
+		return map[string]interface{}{"items": documents, "count": count}, nil
+	}
+}
+
+// getResolver retrieves a single document of the resource, null if it does not exist
+func getResolver(res *resourceType) resolveFunc {
+	return func(ex *executor, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return ex.getDocument(res, args["id"].(string))
+	}
+}
+
+// createResolver creates a document of the resource from the `input` argument
+func createResolver(res *resourceType) resolveFunc {
+	return func(ex *executor, source interface{}, args map[string]interface{}) (interface{}, error) {
+		authFilters, err := ex.authorize(res, http.MethodPost)
+		if err != nil {
+			return nil, err
+		}
+
+		ex.resetDocuments()
+
+		access := ex.requester.FieldAccess(res.def)
+		input := models.ResourceObject(args["input"].(map[string]interface{}))
+		if err := access.WriteError(ex.requester.Role, input); err != nil {
+			return nil, err
+		}
+
+		fields, err := ex.validateDocument(res, models.ActionCreate, input)
+		if err != nil {
+			return nil, err
+		}
+		if !middleware.DocumentMatchesFilters(fields, authFilters) {
+			return nil, errDocumentConditions
+		}
+
+		meta := models.NewMetaData(ex.requester.ID, ex.requester.Type)
+		id, dsiErr := ex.store.AddDefDocument(ex.project.ID, res.def.PathName, fields, meta, ex.outboxEvent(res, models.ActionCreate))
+		if dsiErr != nil {
+			return nil, dsiErr
+		}
+
+		document := map[string]interface{}{}
+		for key, value := range fields {
+			document[key] = value
+		}
+		access.Strip(document)
+		document["id"] = id
+		document["_metadata"] = meta
+
+		return document, nil
+	}
+}
+
+// updateResolver replaces the fields of a document of the resource with the `input` argument
+func updateResolver(res *resourceType) resolveFunc {
+	return func(ex *executor, source interface{}, args map[string]interface{}) (interface{}, error) {
+		authFilters, err := ex.authorize(res, http.MethodPut)
+		if err != nil {
+			return nil, err
+		}
+
+		ex.resetDocuments()
+
+		// the document keeps the values of the properties the role cannot see or write
+		access := ex.requester.FieldAccess(res.def)
+		input := models.ResourceObject(args["input"].(map[string]interface{}))
+		if err := access.WriteError(ex.requester.Role, input); err != nil {
+			return nil, err
+		}
+		if access.Restricted() {
+			existing, dsiErr := ex.store.GetDefDocument(ex.project.ID, res.def.PathName, args["id"].(string), authFilters, map[string]string{})
+			if dsiErr != nil {
+				return nil, dsiErr
+			}
+			access.Preserve(input, existing)
+		}
+
+		fields, err := ex.validateDocument(res, models.ActionEdit, input)
+		if err != nil {
+			return nil, err
+		}
+		if !middleware.DocumentMatchesFilters(fields, authFilters) {
+			return nil, errDocumentConditions
+		}
+
+		document, dsiErr := ex.store.UpdateDefDocument(ex.project.ID, res.def.PathName, args["id"].(string), fields, authFilters, ex.outboxEvent(res, models.ActionEdit))
+		if dsiErr != nil {
+			return nil, dsiErr
+		}
+		if document == nil {
+			return nil, nil
+		}
+		access.Strip(*document)
+
+		return map[string]interface{}(*document), nil
+	}
+}
+
+// deleteResolver deletes a document of the resource
+func deleteResolver(res *resourceType) resolveFunc {
+	return func(ex *executor, source interface{}, args map[string]interface{}) (interface{}, error) {
+		authFilters, err := ex.authorize(res, http.MethodDelete)
+		if err != nil {
+			return nil, err
+		}
+
+		ex.resetDocuments()
+
+		id := args["id"].(string)
+		if _, err := ex.validateDocument(res, models.ActionDelete, models.ResourceObject{"id": id}); err != nil {
+			return nil, err
+		}
+
+		if dsiErr := ex.store.DeleteDefDocument(ex.project.ID, res.def.PathName, id, authFilters, ex.outboxEvent(res, models.ActionDelete)); dsiErr != nil {
+			return nil, dsiErr
+		}
+
+		return true, nil
+	}
+}
+
+// authorize applies the access policies of the resource to the requester, returning the creator filters
+func (ex *executor) authorize(res *resourceType, verb string) (map[string]interface{}, error) {
+	filters, _, err := middleware.AuthorizeResource(res.def, verb, ex.requester)
+	return filters, err
+}
+
+// getDocument retrieves the document with the creator filters of the requester, null if it does not exist. Results are
+// memoized for the request, so relations to the same document are retrieved once.
+func (ex *executor) getDocument(res *resourceType, id string) (interface{}, error) {
+	key := res.def.PathName + "/" + id
+	if result, ok := ex.documents[key]; ok {
+		return result.document, result.err
+	}
+
+	document, err := ex.loadDocument(res, id)
+	if ex.documents == nil {
+		ex.documents = make(map[string]*documentResult)
+	}
+	ex.documents[key] = &documentResult{document: document, err: err}
+
+	return document, err
+}
+
+func (ex *executor) loadDocument(res *resourceType, id string) (interface{}, error) {
+	authFilters, err := ex.authorize(res, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+
+	document, dsiErr := ex.store.GetDefDocument(ex.project.ID, res.def.PathName, id, authFilters, map[string]string{})
+	if dsiErr != nil {
+		if dsiErr.Code() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, dsiErr
+	}
+	ex.requester.FieldAccess(res.def).Strip(document)
+
+	return document, nil
+}
+
+// resetDocuments clears the documents memoized by `getDocument`, the write can change documents retrieved earlier in the request
+func (ex *executor) resetDocuments() {
+	ex.documents = nil
+}
+
+// validateDocument calls the "before" hooks of the project for the write, returning the document to be written
+func (ex *executor) validateDocument(res *resourceType, action string, fields models.ResourceObject) (models.ResourceObject, error) {
+	document, err := ex.validator.ValidateDocument(
+		&events.Event{
+			Project:   ex.project,
+			Entity:    models.EndpointResource,
+			EntityKey: res.def.PathName,
+			EntityID:  res.def.ID,
+			Action:    action,
+		},
+		fields,
+	)
+	if hErr, ok := err.(*events.HookError); ok {
+		return nil, errors.New(hErr.Message)
+	}
+
+	return document, err
+}
+
+// outboxEvent returns the event for a write to the resource, `nil` if hooks have been disabled for the request
+func (ex *executor) outboxEvent(res *resourceType, action string) *models.OutboxEvent {
+	if !ex.triggerHooks {
+		return nil
+	}
+
+	return &models.OutboxEvent{
+		Entity:    models.EndpointResource,
+		EntityKey: res.def.PathName,
+		EntityID:  res.def.ID,
+		Action:    action,
+	}
+}
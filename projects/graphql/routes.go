@@ -0,0 +1,43 @@
+package graphql
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/config"
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/events"
+	"github.com/machinable/machinable/middleware"
+)
+
+// Handler is an interface to the GraphQL HTTP handler functions.
+type Handler interface {
+	Query(c *gin.Context)
+	GetSchema(c *gin.Context)
+}
+
+// SetRoutes sets all of the appropriate routes to handlers for the project GraphQL endpoint
+func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, cache redis.UniversalClient, validator *events.Validator, config *config.AppConfig) error {
+	// create new GraphQL handler with datastore
+	handler := New(datastore, validator)
+
+	// di for testing
+	return setRoutes(
+		engine,
+		handler,
+		middleware.ResourceStatsMiddleware(datastore),
+		middleware.ProjectRequesterMiddleware(datastore, config),
+		middleware.RequestRateLimit(datastore, cache),
+	)
+}
+
+func setRoutes(engine *gin.Engine, handler Handler, mw ...gin.HandlerFunc) error {
+	// resources are authorized per field, with the same policies as the REST routes
+	gql := engine.Group("/graphql")
+	gql.Use(mw...)
+
+	gql.GET("", handler.Query)
+	gql.POST("", handler.Query)
+	gql.GET("/schema", handler.GetSchema) // schema definition language
+
+	return nil
+}
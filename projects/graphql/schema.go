@@ -0,0 +1,370 @@
+package graphql
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const (
+	typeQuery    = "Query"
+	typeMutation = "Mutation"
+	typeMetadata = "Metadata"
+	typeJSON     = "JSON"
+)
+
+// scalarTypes are the leaf types of the schema, `JSON` is any JSON value
+var scalarTypes = map[string]bool{
+	"String":  true,
+	"Int":     true,
+	"Float":   true,
+	"Boolean": true,
+	"ID":      true,
+	typeJSON:  true,
+}
+
+// reservedTypes can not be used as resource type names
+var reservedTypes = map[string]bool{
+	typeQuery:    true,
+	typeMutation: true,
+	typeMetadata: true,
+}
+
+var validName = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// resolveFunc resolves the value of a field from the source value of its parent object
+type resolveFunc func(ex *executor, source interface{}, args map[string]interface{}) (interface{}, error)
+
+// objectType is an output type of the schema
+type objectType struct {
+	name   string
+	fields []*objectField
+	lookup map[string]*objectField
+}
+
+func newObjectType(name string) *objectType {
+	return &objectType{name: name, lookup: map[string]*objectField{}}
+}
+
+func (o *objectType) addField(f *objectField) {
+	o.fields = append(o.fields, f)
+	o.lookup[f.name] = f
+}
+
+// objectField is a field of an output type, `typ` is the SDL type such as `[Post!]!`
+type objectField struct {
+	name    string
+	typ     string
+	args    []*inputValue
+	resolve resolveFunc
+}
+
+// inputType is an input object type of the schema
+type inputType struct {
+	name   string
+	fields []*inputValue
+}
+
+func (i *inputType) field(name string) *inputValue {
+	for _, f := range i.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// inputValue is an argument or input object field, `defaultValue` is the SDL literal
+type inputValue struct {
+	name         string
+	typ          string
+	defaultValue string
+}
+
+// resourceType maps a resource definition to its GraphQL types
+type resourceType struct {
+	def        *models.ResourceDefinition
+	name       string // object type name, i.e. `BlogPosts`
+	field      string // query field name, i.e. `blogPosts`
+	properties map[string]bool
+	relations  map[string]string // property -> related resource path name
+}
+
+// Schema is the GraphQL schema of a project generated from its resource definitions. Each resource has an object type,
+// list and get queries as well as create, update and delete mutations. Relation properties resolve to the related
+// document.
+type Schema struct {
+	objects   map[string]*objectType
+	inputs    map[string]*inputType
+	order     []string // object and input type names in SDL order
+	resources map[string]*resourceType
+}
+
+// NewSchema generates the schema for the resource definitions. Resources and properties which can not be represented
+// as GraphQL names are left out.
+func NewSchema(definitions []*models.ResourceDefinition) *Schema {
+	s := &Schema{
+		objects:   map[string]*objectType{},
+		inputs:    map[string]*inputType{},
+		resources: map[string]*resourceType{},
+	}
+
+	defs := make([]*models.ResourceDefinition, len(definitions))
+	copy(defs, definitions)
+	sort.Slice(defs, func(i, j int) bool { return defs[i].PathName < defs[j].PathName })
+
+	// register the type names first so relations can refer to any resource
+	byPath := map[string]*resourceType{}
+	resources := make([]*resourceType, 0)
+	for _, def := range defs {
+		name := typeName(def.PathName)
+		if name == "" || reservedTypes[name] || scalarTypes[name] || s.resources[name] != nil {
+			continue
+		}
+		res := &resourceType{
+			def:        def,
+			name:       name,
+			field:      strings.ToLower(name[:1]) + name[1:],
+			properties: map[string]bool{},
+			relations:  map[string]string{},
+		}
+		s.resources[name] = res
+		byPath[def.PathName] = res
+		resources = append(resources, res)
+	}
+
+	query := newObjectType(typeQuery)
+	mutation := newObjectType(typeMutation)
+
+	metadata := newObjectType(typeMetadata)
+	metadata.addField(&objectField{name: "creator", typ: "String", resolve: mapResolver("creator")})
+	metadata.addField(&objectField{name: "creator_type", typ: "String", resolve: mapResolver("creator_type")})
+	metadata.addField(&objectField{name: "created", typ: "Int", resolve: mapResolver("created")})
+
+	for _, res := range resources {
+		s.addResource(res, byPath, query, mutation)
+	}
+
+	if len(query.fields) == 0 {
+		// a schema requires at least one query field
+		query.addField(&objectField{name: "_empty", typ: "Boolean", resolve: func(*executor, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, nil
+		}})
+	}
+
+	s.addObject(query)
+	if len(mutation.fields) > 0 {
+		s.addObject(mutation)
+	}
+	s.addObject(metadata)
+
+	return s
+}
+
+func (s *Schema) addObject(o *objectType) {
+	s.objects[o.name] = o
+	s.order = append(s.order, o.name)
+}
+
+func (s *Schema) addInput(i *inputType) {
+	s.inputs[i.name] = i
+	s.order = append(s.order, i.name)
+}
+
+// addResource generates the types, queries and mutations of the resource
+func (s *Schema) addResource(res *resourceType, byPath map[string]*resourceType, query, mutation *objectType) {
+	schema, err := res.def.GetSchema()
+	if err != nil {
+		schema = &models.JSONSchemaObject{}
+	}
+	required := map[string]bool{}
+	for _, key := range schema.Required {
+		required[key] = true
+	}
+
+	obj := newObjectType(res.name)
+	obj.addField(&objectField{name: "id", typ: "ID!", resolve: mapResolver("id")})
+	obj.addField(&objectField{name: "_metadata", typ: typeMetadata, resolve: metadataResolver})
+
+	input := &inputType{name: res.name + "Input"}
+	filter := &inputType{name: res.name + "Filter"}
+
+	keys := make([]string, 0, len(schema.Properties))
+	for key := range schema.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !validName.MatchString(key) || strings.HasPrefix(key, "__") || key == "id" {
+			continue
+		}
+		property := schema.Properties[key]
+		res.properties[key] = true
+
+		if relation, ok := property["relation"].(string); ok && byPath[relation] != nil {
+			related := byPath[relation]
+			res.relations[key] = relation
+			obj.addField(&objectField{name: key, typ: related.name, resolve: relationResolver(key, related)})
+			input.fields = append(input.fields, &inputValue{name: key, typ: nonNullIf("ID", required[key])})
+			filter.fields = append(filter.fields, &inputValue{name: key, typ: "ID"})
+			continue
+		}
+
+		typ := propertyType(property)
+		obj.addField(&objectField{name: key, typ: typ, resolve: mapResolver(key)})
+		input.fields = append(input.fields, &inputValue{name: key, typ: nonNullIf(typ, required[key])})
+		if scalarTypes[typ] && typ != typeJSON {
+			filter.fields = append(filter.fields, &inputValue{name: key, typ: typ})
+		}
+	}
+
+	page := newObjectType(res.name + "Page")
+	page.addField(&objectField{name: "items", typ: fmt.Sprintf("[%s!]!", res.name), resolve: mapResolver("items")})
+	page.addField(&objectField{name: "count", typ: "Int!", resolve: mapResolver("count")})
+
+	s.addObject(obj)
+	s.addObject(page)
+	s.addInput(input)
+
+	listArgs := []*inputValue{
+		{name: "sort", typ: "String"},
+		{name: "limit", typ: "Int", defaultValue: "10"},
+		{name: "offset", typ: "Int", defaultValue: "0"},
+	}
+	if len(filter.fields) > 0 {
+		s.addInput(filter)
+		listArgs = append([]*inputValue{{name: "filter", typ: filter.name}}, listArgs...)
+	}
+
+	query.addField(&objectField{
+		name:    res.field,
+		typ:     page.name,
+		args:    listArgs,
+		resolve: listResolver(res),
+	})
+	query.addField(&objectField{
+		name:    res.field + "ById",
+		typ:     res.name,
+		args:    []*inputValue{{name: "id", typ: "ID!"}},
+		resolve: getResolver(res),
+	})
+
+	mutation.addField(&objectField{
+		name:    "create" + res.name,
+		typ:     res.name,
+		args:    []*inputValue{{name: "input", typ: input.name + "!"}},
+		resolve: createResolver(res),
+	})
+	mutation.addField(&objectField{
+		name:    "update" + res.name,
+		typ:     res.name,
+		args:    []*inputValue{{name: "id", typ: "ID!"}, {name: "input", typ: input.name + "!"}},
+		resolve: updateResolver(res),
+	})
+	mutation.addField(&objectField{
+		name:    "delete" + res.name,
+		typ:     "Boolean",
+		args:    []*inputValue{{name: "id", typ: "ID!"}},
+		resolve: deleteResolver(res),
+	})
+}
+
+// SDL returns the schema in the GraphQL schema definition language
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	b.WriteString("scalar JSON\n")
+
+	for _, name := range s.order {
+		b.WriteString("\n")
+		if obj, ok := s.objects[name]; ok {
+			fmt.Fprintf(&b, "type %s {\n", obj.name)
+			for _, f := range obj.fields {
+				fmt.Fprintf(&b, "  %s%s: %s\n", f.name, sdlArguments(f.args), f.typ)
+			}
+			b.WriteString("}\n")
+			continue
+		}
+
+		input := s.inputs[name]
+		fmt.Fprintf(&b, "input %s {\n", input.name)
+		for _, f := range input.fields {
+			fmt.Fprintf(&b, "  %s: %s\n", f.name, f.typ)
+		}
+		b.WriteString("}\n")
+	}
+
+	return b.String()
+}
+
+func sdlArguments(args []*inputValue) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		part := fmt.Sprintf("%s: %s", arg.name, arg.typ)
+		if arg.defaultValue != "" {
+			part += " = " + arg.defaultValue
+		}
+		parts = append(parts, part)
+	}
+
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// typeName converts the resource path name to a GraphQL type name, i.e. `blog-posts` to `BlogPosts`
+func typeName(pathName string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(pathName, func(r rune) bool { return r == '-' || r == '_' }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+
+	name := b.String()
+	if name != "" && isDigit(name[0]) {
+		name = "R" + name
+	}
+	if !validName.MatchString(name) {
+		return ""
+	}
+
+	return name
+}
+
+// propertyType returns the GraphQL type of the JSON schema property
+func propertyType(property map[string]interface{}) string {
+	switch property["type"] {
+	case "string":
+		return "String"
+	case "integer":
+		return "Int"
+	case "number":
+		return "Float"
+	case "boolean":
+		return "Boolean"
+	case "array":
+		if items, ok := property["items"].(map[string]interface{}); ok {
+			if elem := propertyType(items); elem != typeJSON {
+				return "[" + elem + "]"
+			}
+		}
+	}
+
+	return typeJSON
+}
+
+func nonNullIf(typ string, nonNull bool) string {
+	if nonNull {
+		return typ + "!"
+	}
+	return typ
+}
+
+// namedType strips the list and non-null wrappers of the SDL type
+func namedType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
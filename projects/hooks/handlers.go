@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/dsi/models"
 )
@@ -31,14 +32,14 @@ func (w *WebHooks) UpdateHook(c *gin.Context) {
 
 	// validate hook before storing
 	if err := hook.Validate(); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// update hook in database for project
 	err := w.store.UpdateHook(projectID, hookID, &hook)
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
@@ -55,14 +56,14 @@ func (w *WebHooks) AddHook(c *gin.Context) {
 
 	// validate hook before storing
 	if err := hook.Validate(); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// save hook to database for project
 	err := w.store.AddHook(projectID, &hook)
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
@@ -76,7 +77,7 @@ func (w *WebHooks) ListHooks(c *gin.Context) {
 	// retrieve all hooks for the project
 	hooks, err := w.store.ListHooks(projectID)
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
@@ -90,7 +91,7 @@ func (w *WebHooks) ListResults(c *gin.Context) {
 
 	results, err := w.store.ListResults(projectID, hookID)
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
@@ -104,7 +105,7 @@ func (w *WebHooks) GetHook(c *gin.Context) {
 
 	hook, err := w.store.GetHook(projectID, hookID)
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
@@ -118,7 +119,7 @@ func (w *WebHooks) DeleteHook(c *gin.Context) {
 
 	err := w.store.DeleteHook(projectID, hookID)
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
@@ -27,7 +27,7 @@ func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, config *confi
 		engine,
 		handler,
 		datastore,
-		middleware.AppUserJwtAuthzMiddleware(config),
+		middleware.AppUserJwtAuthzMiddleware(datastore, config),
 		middleware.AppUserProjectAuthzMiddleware(datastore, config),
 	)
 }
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/dsi/models"
 )
 
@@ -36,7 +37,7 @@ func (d *Handlers) ListUsage(c *gin.Context) {
 	logs, err := d.db.ListProjectLogs(projectID, iLimit, 0, filter, nil)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -3,22 +3,31 @@ package jsontree
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
+	"github.com/machinable/machinable/auth"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/events"
+	"github.com/machinable/machinable/middleware"
+	"github.com/machinable/machinable/query"
 )
 
 // Handlers contains all handler functions
 type Handlers struct {
-	db interfaces.Datastore
+	db        interfaces.Datastore
+	validator *events.Validator
 }
 
 // NewHandlers creates and returns a new instance of `Handlers` with the datastore
-func NewHandlers(datastore interfaces.Datastore) *Handlers {
+func NewHandlers(datastore interfaces.Datastore, validator *events.Validator) *Handlers {
 	return &Handlers{
-		db: datastore,
+		db:        datastore,
+		validator: validator,
 	}
 }
 
@@ -28,15 +37,15 @@ func (h *Handlers) ListRootKeys(c *gin.Context) {
 
 	rootKeys, err := h.db.ListRootKeys(projectID)
 	if err != nil {
-		tErr := h.db.TranslateError(err)
-		c.JSON(tErr.Code, gin.H{"error": tErr.Error()})
+		h.respondWithError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"items": rootKeys})
 }
 
-// UpdateRootKey updates the access policies
+// UpdateRootKey updates the access policies. The schema, rules and history of the root key are kept if they are absent,
+// a `null` schema or empty rules remove them and history is only removed if it is disabled.
 func (h *Handlers) UpdateRootKey(c *gin.Context) {
 	rootKey := c.Param("rootKey")
 	projectID := c.MustGet("projectId").(string)
@@ -45,13 +54,31 @@ func (h *Handlers) UpdateRootKey(c *gin.Context) {
 	c.BindJSON(keyData)
 
 	if keyData.Key != rootKey {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key"})
+		apierror.Respond(c, http.StatusBadRequest, "invalid key")
+		return
+	}
+
+	if err := keyData.ValidateSchema(); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := auth.ValidateJSONRules(keyData.Rules); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
 	}
 
+	if keyData.History != nil {
+		if err := keyData.History.Validate(); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// the existing tree must match the new schema, it is validated by the datastore
 	err := h.db.UpdateRootKey(projectID, keyData)
 	if err != nil {
-		tErr := h.db.TranslateError(err)
-		c.JSON(tErr.Code, gin.H{"error": tErr.Error()})
+		h.respondWithError(c, err)
 		return
 	}
 
@@ -65,14 +92,13 @@ func (h *Handlers) CreateRootKey(c *gin.Context) {
 
 	b, rErr := c.GetRawData()
 	if rErr != nil {
-		c.JSON(http.StatusInternalServerError, "error parsing data")
+		apierror.Respond(c, http.StatusInternalServerError, "error parsing data")
 		return
 	}
 
 	err := h.db.CreateRootKey(projectID, rootKey, b)
 	if err != nil {
-		tErr := h.db.TranslateError(err)
-		c.JSON(tErr.Code, gin.H{"error": tErr.Error()})
+		h.respondWithError(c, err)
 		return
 	}
 
@@ -86,8 +112,7 @@ func (h *Handlers) ReadRootKey(c *gin.Context) {
 
 	byt, err := h.db.GetJSONKey(projectID, rootKey)
 	if err != nil {
-		tErr := h.db.TranslateError(err)
-		c.JSON(tErr.Code, gin.H{"error": tErr.Error()})
+		h.respondWithError(c, err)
 		return
 	}
 
@@ -103,8 +128,7 @@ func (h *Handlers) DeleteRootKey(c *gin.Context) {
 
 	err := h.db.DeleteRootKey(projectID, rootKey)
 	if err != nil {
-		tErr := h.db.TranslateError(err)
-		c.JSON(tErr.Code, gin.H{"error": tErr.Error()})
+		h.respondWithError(c, err)
 		return
 	}
 
@@ -118,10 +142,16 @@ func (h *Handlers) ReadJSONKey(c *gin.Context) {
 	keys := c.Param("keys")
 
 	keys = strings.TrimRight(strings.TrimLeft(keys, "/"), "/")
+
+	values := c.Request.URL.Query()
+	if models.IsJSONChildQuery(values) {
+		h.listJSONChildren(c, projectID, rootKey, keys, values)
+		return
+	}
+
 	byt, err := h.db.GetJSONKey(projectID, rootKey, strings.Split(keys, "/")...)
 	if err != nil {
-		tErr := h.db.TranslateError(err)
-		c.JSON(tErr.Code, gin.H{"error": tErr.Error()})
+		h.respondWithError(c, err)
 		return
 	}
 
@@ -130,6 +160,28 @@ func (h *Handlers) ReadJSONKey(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, obj)
 }
 
+// listJSONChildren responds with the ordered children of the node at the key path
+func (h *Handlers) listJSONChildren(c *gin.Context, projectID, rootKey, keys string, values url.Values) {
+	query, err := models.ParseJSONChildQuery(values)
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var parseKeys []string
+	if keys != "" {
+		parseKeys = strings.Split(keys, "/")
+	}
+
+	children, err := h.db.ListJSONChildren(projectID, rootKey, query, parseKeys...)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": children})
+}
+
 // CreateJSONKey updates a key at the key path. An error is returned if the key already exists.
 func (h *Handlers) CreateJSONKey(c *gin.Context) {
 	rootKey := c.Param("rootKey")
@@ -138,20 +190,26 @@ func (h *Handlers) CreateJSONKey(c *gin.Context) {
 
 	b, rErr := c.GetRawData()
 	if rErr != nil {
-		c.JSON(http.StatusInternalServerError, "error parsing data")
+		apierror.Respond(c, http.StatusInternalServerError, "error parsing data")
 		return
 	}
 
 	keys = strings.TrimRight(strings.TrimLeft(keys, "/"), "/")
 	if keys == "" {
-		c.JSON(http.StatusBadRequest, "no keys provided")
+		apierror.Respond(c, http.StatusBadRequest, "no keys provided")
 		return
 	}
+	parseKeys := strings.Split(strings.Trim(keys, "/"), "/")
 
-	err := h.db.CreateJSONKey(projectID, rootKey, b, strings.Split(strings.Trim(keys, "/"), "/")...)
+	// "before" hooks can reject or modify the data
+	b, ok := middleware.ValidateWrite(c, h.validator, models.ActionCreate, parseKeys, b)
+	if !ok {
+		return
+	}
+
+	err := h.db.CreateJSONKey(projectID, rootKey, b, jsonMetaData(c), middleware.OutboxEvent(c, models.ActionCreate), parseKeys...)
 	if err != nil {
-		tErr := h.db.TranslateError(err)
-		c.JSON(tErr.Code, gin.H{"error": tErr.Error()})
+		h.respondWithError(c, err)
 		return
 	}
 
@@ -168,17 +226,21 @@ func (h *Handlers) UpdateJSONKey(c *gin.Context) {
 
 	b, rErr := c.GetRawData()
 	if rErr != nil {
-		c.JSON(http.StatusInternalServerError, "error parsing data")
+		apierror.Respond(c, http.StatusInternalServerError, "error parsing data")
 		return
 	}
 
 	parseKeys := strings.Split(strings.Trim(keys, "/"), "/")
-	c.Set("jsonKeys", parseKeys)
 
-	err := h.db.UpdateJSONKey(projectID, rootKey, b, parseKeys...)
+	// "before" hooks can reject or modify the data
+	b, ok := middleware.ValidateWrite(c, h.validator, models.ActionEdit, parseKeys, b)
+	if !ok {
+		return
+	}
+
+	err := h.db.UpdateJSONKey(projectID, rootKey, b, jsonMetaData(c), middleware.OutboxEvent(c, models.ActionEdit), parseKeys...)
 	if err != nil {
-		tErr := h.db.TranslateError(err)
-		c.JSON(tErr.Code, gin.H{"error": tErr.Error()})
+		h.respondWithError(c, err)
 		return
 	}
 
@@ -187,6 +249,49 @@ func (h *Handlers) UpdateJSONKey(c *gin.Context) {
 	c.JSON(http.StatusCreated, bod)
 }
 
+// ApplyJSONOperation atomically applies an operation, i.e. increment or append, to the value at the key path. The
+// "before" hooks of "edit" receive the value at the key path after the operation, as they do for `UpdateJSONKey`, and
+// can reject or replace it before the operation is committed.
+func (h *Handlers) ApplyJSONOperation(c *gin.Context) {
+	rootKey := c.Param("rootKey")
+	projectID := c.MustGet("projectId").(string)
+	keys := c.Param("keys")
+	keys = strings.TrimRight(strings.TrimLeft(keys, "/"), "/")
+	if keys == "" {
+		apierror.Respond(c, http.StatusBadRequest, "no keys provided")
+		return
+	}
+	parseKeys := strings.Split(keys, "/")
+
+	op := &models.JSONOperation{}
+	if err := c.BindJSON(op); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := op.Validate(); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	validate := func(value []byte) ([]byte, error) {
+		return middleware.BeforeHooks(c, h.validator, models.ActionEdit, parseKeys, value)
+	}
+
+	value, err := h.db.ApplyJSONOperation(projectID, rootKey, op, validate, jsonMetaData(c), middleware.OutboxEvent(c, models.ActionEdit), parseKeys...)
+	if hErr, ok := err.(*events.HookError); ok {
+		apierror.Respond(c, hErr.StatusCode, hErr.Message)
+		return
+	}
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+
+	var bod interface{}
+	json.Unmarshal(value, &bod)
+	c.JSON(http.StatusOK, bod)
+}
+
 // DeleteJSONKey deletes a project key at the key path
 func (h *Handlers) DeleteJSONKey(c *gin.Context) {
 	rootKey := c.Param("rootKey")
@@ -194,16 +299,96 @@ func (h *Handlers) DeleteJSONKey(c *gin.Context) {
 	keys := c.Param("keys")
 	keys = strings.TrimRight(strings.TrimLeft(keys, "/"), "/")
 	if keys == "" {
-		c.JSON(http.StatusBadRequest, "no keys provided")
+		apierror.Respond(c, http.StatusBadRequest, "no keys provided")
 		return
 	}
+	parseKeys := strings.Split(keys, "/")
 
-	err := h.db.DeleteJSONKey(projectID, rootKey, strings.Split(keys, "/")...)
+	// "before" hooks can reject the delete
+	if _, ok := middleware.ValidateWrite(c, h.validator, models.ActionDelete, parseKeys, nil); !ok {
+		return
+	}
+
+	err := h.db.DeleteJSONKey(projectID, rootKey, jsonMetaData(c), middleware.OutboxEvent(c, models.ActionDelete), parseKeys...)
 	if err != nil {
-		tErr := h.db.TranslateError(err)
-		c.JSON(tErr.Code, gin.H{"error": tErr.Error()})
+		h.respondWithError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{})
 }
+
+// ListVersions lists the recorded versions of the root key, most recent first
+func (h *Handlers) ListVersions(c *gin.Context) {
+	rootKey := c.Param("rootKey")
+	projectID := c.MustGet("projectId").(string)
+
+	values := c.Request.URL.Query()
+	limit, err := query.GetLimit(&values)
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := query.GetOffset(&values)
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	versions, err := h.db.ListJSONVersions(projectID, rootKey, limit, offset)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": versions})
+}
+
+// RestoreVersion restores the tree of the root key to a previous version
+func (h *Handlers) RestoreVersion(c *gin.Context) {
+	rootKey := c.Param("rootKey")
+	projectID := c.MustGet("projectId").(string)
+
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, "invalid version")
+		return
+	}
+
+	keyData, err := h.db.GetRootKey(projectID, rootKey)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+
+	// management requests do not pass through the json stats middleware, build the event from the root key
+	var event *models.OutboxEvent
+	if c.Request.Header.Get("X-Trigger-Hooks") != "false" {
+		event = &models.OutboxEvent{
+			Entity:    models.EndpointJSON,
+			EntityKey: rootKey,
+			EntityID:  keyData.ID,
+			Action:    models.ActionEdit,
+		}
+	}
+
+	byt, err := h.db.RestoreJSONVersion(projectID, rootKey, version, models.NewMetaData(c.GetString("user_id"), c.GetString("authType")), event)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+
+	var obj interface{}
+	json.Unmarshal(byt, &obj)
+	c.IndentedJSON(http.StatusOK, obj)
+}
+
+// jsonMetaData returns the requester of a write, recorded in the root key history
+func jsonMetaData(c *gin.Context) *models.MetaData {
+	return models.NewMetaData(c.GetString("authID"), c.GetString("authType"))
+}
+
+// respondWithError writes the translated datastore error, schema validation errors include the offending paths
+func (h *Handlers) respondWithError(c *gin.Context, err error) {
+	apierror.RespondError(c, apierror.FromTranslated(h.db.TranslateError(err)))
+}
@@ -19,36 +19,41 @@ type Handler interface {
 	ReadJSONKey(c *gin.Context)
 	CreateJSONKey(c *gin.Context)
 	UpdateJSONKey(c *gin.Context)
+	ApplyJSONOperation(c *gin.Context)
 	DeleteJSONKey(c *gin.Context)
+	ListVersions(c *gin.Context)
+	RestoreVersion(c *gin.Context)
 
 	ListUsage(c *gin.Context)
 }
 
 // SetRoutes sets all of the appropriate routes to handlers for the application
-func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, cache redis.UniversalClient, processor *events.Processor, config *config.AppConfig) error {
-	handler := NewHandlers(datastore)
+func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, cache redis.UniversalClient, validator *events.Validator, config *config.AppConfig) error {
+	handler := NewHandlers(datastore, validator)
 
-	return setRoutes(engine, handler, datastore, cache, processor, config)
+	return setRoutes(engine, handler, datastore, cache, config)
 }
 
 // abstraction for dependency injection
-func setRoutes(engine *gin.Engine, h Handler, datastore interfaces.Datastore, cache redis.UniversalClient, processor *events.Processor, config *config.AppConfig) error {
+func setRoutes(engine *gin.Engine, h Handler, datastore interfaces.Datastore, cache redis.UniversalClient, config *config.AppConfig) error {
 	jsonKeys := engine.Group("/json")
 
 	// set middleware on json key resources
-	jsonKeys.Use(middleware.JSONStatsMiddleware(datastore, processor))
+	jsonKeys.Use(middleware.JSONStatsMiddleware(datastore))
 	jsonKeys.Use(middleware.ProjectUserAuthzMiddleware(datastore, config))
 	jsonKeys.Use(middleware.RequestRateLimit(datastore, cache))
+	jsonKeys.Use(middleware.JSONRulesMiddleware())
 
 	// initialize routes
 	jsonKeys.GET("/:rootKey/*keys", h.ReadJSONKey)
 	jsonKeys.POST("/:rootKey/*keys", h.CreateJSONKey)
 	jsonKeys.PUT("/:rootKey/*keys", h.UpdateJSONKey)
+	jsonKeys.PATCH("/:rootKey/*keys", h.ApplyJSONOperation)
 	jsonKeys.DELETE("/:rootKey/*keys", h.DeleteJSONKey)
 
 	// App mgmt routes with different authz policy
 	mgmt := engine.Group("/mgmt")
-	mgmt.Use(middleware.AppUserJwtAuthzMiddleware(config))
+	mgmt.Use(middleware.AppUserJwtAuthzMiddleware(datastore, config))
 	mgmt.Use(middleware.AppUserProjectAuthzMiddleware(datastore, config))
 
 	// stats
@@ -62,5 +67,9 @@ func setRoutes(engine *gin.Engine, h Handler, datastore interfaces.Datastore, ca
 	mgmtAPI.PUT("/:rootKey", h.UpdateRootKey)    // create a new tree at `rootKey`
 	mgmtAPI.DELETE("/:rootKey", h.DeleteRootKey) // root tree must be empty to delete
 
+	// history
+	mgmtAPI.GET("/:rootKey/versions", h.ListVersions)
+	mgmtAPI.POST("/:rootKey/versions/:version/restore", h.RestoreVersion)
+
 	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/dsi"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/dsi/models"
@@ -33,12 +34,12 @@ func (l *Logs) ListProjectLogs(c *gin.Context) {
 
 	iLimit, err := query.GetLimit(&values)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 	iOffset, err := query.GetOffset(&values)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -72,14 +73,14 @@ func (l *Logs) ListProjectLogs(c *gin.Context) {
 		if models.IsValidLogField(k) {
 			value, err := models.FieldAsTypedInterface(k, v[0])
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid value for field '%s'", k)})
+				apierror.Respond(c, http.StatusBadRequest, fmt.Sprintf("invalid value for field '%s'", k))
 				return
 			}
 			filter.AddFilter(k, models.Value{
 				models.EQ: value,
 			})
 		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("'%s' is not a valid field", k)})
+			apierror.Respond(c, http.StatusBadRequest, fmt.Sprintf("'%s' is not a valid field", k))
 			return
 		}
 	}
@@ -89,14 +90,14 @@ func (l *Logs) ListProjectLogs(c *gin.Context) {
 
 	pageMax := (logCount % iLimit) + logCount
 	if (iLimit+iOffset) > pageMax && iOffset >= logCount && logCount != 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		apierror.Respond(c, http.StatusBadRequest, "invalid page")
 		return
 	}
 
 	logs, err := l.store.ListProjectLogs(projectID, iLimit, iOffset, filter, sort)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
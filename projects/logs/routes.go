@@ -13,7 +13,7 @@ func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, config *confi
 	handler := New(datastore)
 
 	logs := engine.Group("/logs")
-	logs.Use(middleware.AppUserJwtAuthzMiddleware(config))
+	logs.Use(middleware.AppUserJwtAuthzMiddleware(datastore, config))
 	logs.Use(middleware.AppUserProjectAuthzMiddleware(datastore, config))
 	logs.GET("/", handler.ListProjectLogs)
 
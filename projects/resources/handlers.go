@@ -1,13 +1,19 @@
 package resources
 
 import (
+	"errors"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/dsi/models"
 )
 
+// maxImportSize is the maximum size in bytes of an imported OpenAPI document
+const maxImportSize = 10 << 20
+
 // New returns a pointer to a new `Resources` struct
 func New(db interfaces.ResourcesDatastore) *Resources {
 	return &Resources{
@@ -36,21 +42,21 @@ func (h *Resources) AddResourceDefinition(c *gin.Context) {
 
 	// validate the definition
 	if err := resourceDefinition.Validate(); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// verify uniqueness
 	if _, dne := h.store.GetDefinitionByPathName(projectID, resourceDefinition.PathName); dne == nil {
 		// path name already exists for project and path name
-		c.JSON(http.StatusBadRequest, gin.H{"error": "path name already in use for project"})
+		apierror.Respond(c, http.StatusBadRequest, "path name already in use for project")
 		return
 	}
 
 	id, err := h.store.AddDefinition(projectID, &resourceDefinition)
 
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
@@ -66,7 +72,7 @@ func (h *Resources) ListResourceDefinitions(c *gin.Context) {
 	definitions, err := h.store.ListDefinitions(projectID)
 
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
@@ -81,23 +87,43 @@ func (h *Resources) GetResourceDefinition(c *gin.Context) {
 	def, err := h.store.GetDefinition(projectID, resourceID)
 
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 	c.JSON(http.StatusOK, def)
 }
 
-// UpdateResourceDefinition updates the parallel_read and parallel_write operations of the definition
+// UpdateResourceDefinition updates the parallel_read and parallel_write operations, and the TTL of the definition
 func (h *Resources) UpdateResourceDefinition(c *gin.Context) {
 	projectID := c.MustGet("projectId").(string)
 	resourceDefinitionID := c.Param("resourceDefinitionID") // actually uses ID
 	var updatedDefinition models.ResourceDefinition
 	c.BindJSON(&updatedDefinition)
 
+	// validate the TTL against the existing schema
+	if updatedDefinition.TTL != nil {
+		def, err := h.store.GetDefinition(projectID, resourceDefinitionID)
+		if err != nil {
+			apierror.RespondError(c, apierror.FromDatastore(err))
+			return
+		}
+
+		schema, sErr := def.GetSchema()
+		if sErr != nil {
+			apierror.Respond(c, http.StatusInternalServerError, sErr.Error())
+			return
+		}
+
+		if vErr := updatedDefinition.TTL.Validate(schema); vErr != nil {
+			apierror.Respond(c, http.StatusBadRequest, vErr.Error())
+			return
+		}
+	}
+
 	// add collection and return error if anything goes wrong
 	err := h.store.UpdateDefinition(projectID, resourceDefinitionID, &updatedDefinition)
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
@@ -112,9 +138,91 @@ func (h *Resources) DeleteResourceDefinition(c *gin.Context) {
 
 	err := h.store.DeleteDefinition(projectID, resourceID)
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
+		apierror.RespondError(c, apierror.FromDatastore(err))
 		return
 	}
 
 	c.JSON(http.StatusNoContent, gin.H{})
 }
+
+// PreviewImport maps the schemas and CRUD paths of an OpenAPI 3 document to resource definitions without creating them
+func (h *Resources) PreviewImport(c *gin.Context) {
+	report, ok := h.importReport(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ImportResourceDefinitions creates all resource definitions of an OpenAPI 3 document, nothing is created if the
+// import report has errors, which are returned as the details of the error
+func (h *Resources) ImportResourceDefinitions(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+
+	report, ok := h.importReport(c)
+	if !ok {
+		return
+	}
+
+	if len(report.Errors) > 0 {
+		details := make([]*apierror.Detail, 0, len(report.Errors))
+		for _, issue := range report.Errors {
+			details = append(details, &apierror.Detail{Field: issue.Pointer, Message: issue.Message})
+		}
+		apierror.RespondError(c, apierror.New(http.StatusBadRequest, "the document cannot be imported").WithDetails(details...))
+		return
+	}
+	if len(report.Definitions) == 0 {
+		apierror.Respond(c, http.StatusBadRequest, "the document does not contain any resources")
+		return
+	}
+
+	if err := h.store.AddDefinitions(projectID, report.Definitions); err != nil {
+		apierror.RespondError(c, apierror.FromDatastore(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, report)
+}
+
+// importReport parses the OpenAPI document of the request body and reports path names already in use by the project
+func (h *Resources) importReport(c *gin.Context) (*ImportReport, bool) {
+	projectID := c.MustGet("projectId").(string)
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		apierror.Respond(c, http.StatusRequestEntityTooLarge, "the document exceeds the maximum size of 10MB")
+		return nil, false
+	}
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	doc, err := parseOpenAPIDocument(body)
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	existing, dErr := h.store.ListDefinitions(projectID)
+	if dErr != nil {
+		apierror.RespondError(c, apierror.FromDatastore(dErr))
+		return nil, false
+	}
+
+	report := importOpenAPI(doc)
+	inUse := map[string]bool{}
+	for _, def := range existing {
+		inUse[def.PathName] = true
+	}
+	for _, def := range report.Definitions {
+		if inUse[def.PathName] {
+			report.fail("/paths", "path name '%s' already in use for project", def.PathName)
+		}
+	}
+
+	return report, true
+}
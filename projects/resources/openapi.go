@@ -0,0 +1,583 @@
+package resources
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/machinable/machinable/dsi"
+	"github.com/machinable/machinable/dsi/models"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const componentSchemaPrefix = "#/components/schemas/"
+
+// componentPointer returns the JSON pointer of a component schema
+func componentPointer(component string) string {
+	return "/components/schemas/" + escapePointer(component)
+}
+
+// supportedKeywords are the JSON schema keywords kept when converting an OpenAPI schema
+var supportedKeywords = map[string]bool{
+	"title":            true,
+	"description":      true,
+	"type":             true,
+	"format":           true,
+	"enum":             true,
+	"default":          true,
+	"minimum":          true,
+	"maximum":          true,
+	"exclusiveMinimum": true,
+	"exclusiveMaximum": true,
+	"multipleOf":       true,
+	"minLength":        true,
+	"maxLength":        true,
+	"pattern":          true,
+	"minItems":         true,
+	"maxItems":         true,
+	"uniqueItems":      true,
+	"minProperties":    true,
+	"maxProperties":    true,
+	"required":         true,
+}
+
+// ignoredKeywords are OpenAPI annotations which are dropped without a warning
+var ignoredKeywords = map[string]bool{
+	"example":      true,
+	"examples":     true,
+	"externalDocs": true,
+	"xml":          true,
+	"deprecated":   true,
+	"readOnly":     true,
+	"writeOnly":    true,
+}
+
+var invalidPathChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// ImportIssue describes a construct of an OpenAPI document that could not be imported as is
+type ImportIssue struct {
+	Pointer string `json:"pointer"` // Pointer is the JSON pointer of the construct in the document
+	Message string `json:"message"`
+}
+
+// ImportReport is the result of mapping an OpenAPI document to resource definitions, the definitions are only created
+// if there are no errors
+type ImportReport struct {
+	Definitions []*models.ResourceDefinition `json:"definitions"`
+	Warnings    []*ImportIssue               `json:"warnings"`
+	Errors      []*ImportIssue               `json:"errors"`
+}
+
+func (r *ImportReport) warn(pointer, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, &ImportIssue{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *ImportReport) fail(pointer, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, &ImportIssue{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+}
+
+// openAPIResource is a component schema which is imported as a resource definition
+type openAPIResource struct {
+	component  string
+	pointer    string
+	definition *models.ResourceDefinition
+}
+
+type openAPIImporter struct {
+	doc       map[string]interface{}
+	schemas   map[string]interface{}
+	resources map[string]*openAPIResource // resources by component name
+	report    *ImportReport
+}
+
+// parseOpenAPIDocument parses a JSON or YAML document
+func parseOpenAPIDocument(body []byte) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, fmt.Errorf("invalid JSON document: %s", err.Error())
+		}
+		return doc, nil
+	}
+
+	var value interface{}
+	if err := yaml.Unmarshal(body, &value); err != nil {
+		return nil, fmt.Errorf("invalid YAML document: %s", err.Error())
+	}
+	doc, ok := yamlToJSON(value).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the document must be an object")
+	}
+	return doc, nil
+}
+
+// yamlToJSON converts the `map[interface{}]interface{}` values decoded by yaml to JSON compatible maps
+func yamlToJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			m[fmt.Sprint(key)] = yamlToJSON(child)
+		}
+		return m
+	case []interface{}:
+		for i, child := range v {
+			v[i] = yamlToJSON(child)
+		}
+	}
+	return value
+}
+
+// importOpenAPI maps the component schemas and CRUD paths of an OpenAPI 3 document to resource definitions
+func importOpenAPI(doc map[string]interface{}) *ImportReport {
+	report := &ImportReport{
+		Definitions: []*models.ResourceDefinition{},
+		Warnings:    []*ImportIssue{},
+		Errors:      []*ImportIssue{},
+	}
+
+	version, _ := doc["openapi"].(string)
+	if !strings.HasPrefix(version, "3.") {
+		report.fail("/openapi", "only OpenAPI 3 documents are supported")
+		return report
+	}
+
+	components, _ := doc["components"].(map[string]interface{})
+	schemas, _ := components["schemas"].(map[string]interface{})
+
+	importer := &openAPIImporter{
+		doc:       doc,
+		schemas:   schemas,
+		resources: map[string]*openAPIResource{},
+		report:    report,
+	}
+
+	importer.collectPaths()
+	if len(importer.resources) == 0 {
+		importer.collectComponents()
+	}
+
+	resources := make([]*openAPIResource, 0, len(importer.resources))
+	for _, resource := range importer.resources {
+		resources = append(resources, resource)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].definition.PathName == resources[j].definition.PathName {
+			return resources[i].component < resources[j].component
+		}
+		return resources[i].definition.PathName < resources[j].definition.PathName
+	})
+
+	pathNames := map[string]string{}
+	for _, resource := range resources {
+		def := resource.definition
+		if other, ok := pathNames[def.PathName]; ok {
+			report.fail(resource.pointer, "path name '%s' is also used by '%s'", def.PathName, other)
+			continue
+		}
+		pathNames[def.PathName] = resource.component
+
+		schema := importer.convertObject(schemas[resource.component], componentPointer(resource.component))
+		b, _ := json.Marshal(schema)
+		def.Schema = string(b)
+
+		if err := def.Validate(); err != nil {
+			report.fail(resource.pointer, err.Error())
+			continue
+		}
+		report.Definitions = append(report.Definitions, def)
+	}
+
+	return report
+}
+
+// collectPaths adds a resource for each collection path (`/things`) and item path (`/things/{id}`) which refer to a
+// component schema
+func (i *openAPIImporter) collectPaths() {
+	paths, _ := i.doc["paths"].(map[string]interface{})
+
+	keys := make([]string, 0, len(paths))
+	for key := range paths {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	type crudPath struct {
+		name       string
+		pointer    string
+		collection map[string]interface{}
+		item       map[string]interface{}
+	}
+	found := map[string]*crudPath{}
+	order := []string{}
+
+	for _, key := range keys {
+		segments := strings.Split(strings.Trim(key, "/"), "/")
+		last := segments[len(segments)-1]
+		operations, _ := paths[key].(map[string]interface{})
+
+		base, isItem := "/"+strings.Join(segments, "/"), false
+		if strings.HasPrefix(last, "{") && strings.HasSuffix(last, "}") {
+			if len(segments) < 2 {
+				continue
+			}
+			base, isItem = "/"+strings.Join(segments[:len(segments)-1], "/"), true
+			last = segments[len(segments)-2]
+		}
+		if last == "" || strings.Contains(last, "{") {
+			continue
+		}
+
+		path, ok := found[base]
+		if !ok {
+			path = &crudPath{name: last, pointer: "/paths/" + escapePointer(key)}
+			found[base] = path
+			order = append(order, base)
+		}
+		if isItem {
+			path.item = operations
+		} else {
+			path.collection = operations
+		}
+	}
+
+	for _, base := range order {
+		path := found[base]
+		component := crudComponent(path.collection, path.item)
+		if component == "" {
+			i.report.warn(path.pointer, "no component schema is referenced by the operations of the path, it is skipped")
+			continue
+		}
+		if _, ok := i.schemas[component]; !ok {
+			i.report.fail(path.pointer, "component schema '%s' does not exist", component)
+			continue
+		}
+		if existing, ok := i.resources[component]; ok {
+			i.report.warn(path.pointer, "component schema '%s' is already imported by %s, the path is skipped", component, existing.pointer)
+			continue
+		}
+
+		def := i.newDefinition(component, path.name, path.pointer)
+		def.Create = i.requiresAuthn(path.collection, "post")
+		def.Read = i.requiresAuthn(path.collection, "get") && i.requiresAuthn(path.item, "get")
+		def.Update = i.requiresAuthn(path.item, "put") && i.requiresAuthn(path.item, "patch")
+		def.Delete = i.requiresAuthn(path.item, "delete")
+
+		i.resources[component] = &openAPIResource{component: component, pointer: path.pointer, definition: def}
+	}
+}
+
+// collectComponents adds a resource for each object component schema, used when the document has no CRUD paths
+func (i *openAPIImporter) collectComponents() {
+	keys := make([]string, 0, len(i.schemas))
+	for key := range i.schemas {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, component := range keys {
+		pointer := componentPointer(component)
+		schema, _ := i.schemas[component].(map[string]interface{})
+		if typ, _ := schema["type"].(string); typ != "object" && schema["properties"] == nil && schema["allOf"] == nil {
+			i.report.warn(pointer, "component schema is not an object, it is skipped")
+			continue
+		}
+
+		def := i.newDefinition(component, strings.ToLower(component), pointer)
+		def.Create, def.Read, def.Update, def.Delete = true, true, true, true
+		i.resources[component] = &openAPIResource{component: component, pointer: pointer, definition: def}
+	}
+
+	if len(i.resources) > 0 {
+		i.report.warn("/paths", "no CRUD paths refer to component schemas, every object component schema is imported")
+	}
+}
+
+// newDefinition returns a definition titled after the component, the title and path name are truncated to fit
+func (i *openAPIImporter) newDefinition(component, pathName, pointer string) *models.ResourceDefinition {
+	title := component
+	if len(title) > dsi.MaxLengthOfCollectionInfo {
+		title = title[:dsi.MaxLengthOfCollectionInfo]
+		i.report.warn(pointer, "title '%s' is truncated to '%s'", component, title)
+	}
+
+	name := strings.Trim(invalidPathChars.ReplaceAllString(pathName, "-"), "-")
+	if name != pathName {
+		i.report.warn(pointer, "path name '%s' is changed to '%s'", pathName, name)
+	}
+	if len(name) > dsi.MaxLengthOfCollectionInfo {
+		truncated := name[:dsi.MaxLengthOfCollectionInfo]
+		i.report.warn(pointer, "path name '%s' is truncated to '%s'", name, truncated)
+		name = truncated
+	}
+
+	return &models.ResourceDefinition{Title: title, PathName: name}
+}
+
+// requiresAuthn returns true if the operation requires authentication, missing operations do
+func (i *openAPIImporter) requiresAuthn(operations map[string]interface{}, method string) bool {
+	operation, ok := operations[method].(map[string]interface{})
+	if !ok {
+		return true
+	}
+
+	security, ok := operation["security"].([]interface{})
+	if !ok {
+		if security, ok = i.doc["security"].([]interface{}); !ok {
+			return true
+		}
+	}
+	if len(security) == 0 {
+		return false
+	}
+	for _, requirement := range security {
+		if r, _ := requirement.(map[string]interface{}); len(r) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// crudComponent returns the name of the component schema created by or returned from the operations of the paths
+func crudComponent(collection, item map[string]interface{}) string {
+	candidates := []interface{}{
+		requestSchema(collection["post"]),
+		responseSchema(collection["get"]),
+		responseSchema(item["get"]),
+		requestSchema(item["put"]),
+	}
+
+	for n, schema := range candidates {
+		if component := componentRef(schema); component != "" {
+			return component
+		}
+		// lists return an array of the component, or wrap it in an `items` or `data` property
+		if n != 1 {
+			continue
+		}
+		list, _ := schema.(map[string]interface{})
+		if component := componentRef(list["items"]); component != "" {
+			return component
+		}
+		properties, _ := list["properties"].(map[string]interface{})
+		for _, key := range []string{"items", "data"} {
+			wrapped, _ := properties[key].(map[string]interface{})
+			if component := componentRef(wrapped["items"]); component != "" {
+				return component
+			}
+		}
+	}
+
+	return ""
+}
+
+func requestSchema(operation interface{}) interface{} {
+	op, _ := operation.(map[string]interface{})
+	body, _ := op["requestBody"].(map[string]interface{})
+	return jsonContentSchema(body)
+}
+
+func responseSchema(operation interface{}) interface{} {
+	op, _ := operation.(map[string]interface{})
+	responses, _ := op["responses"].(map[string]interface{})
+	response, _ := responses["200"].(map[string]interface{})
+	return jsonContentSchema(response)
+}
+
+func jsonContentSchema(body map[string]interface{}) interface{} {
+	content, _ := body["content"].(map[string]interface{})
+	media, _ := content["application/json"].(map[string]interface{})
+	return media["schema"]
+}
+
+// componentRef returns the component name of a `$ref` to `#/components/schemas/`
+func componentRef(schema interface{}) string {
+	m, _ := schema.(map[string]interface{})
+	ref, _ := m["$ref"].(string)
+	if !strings.HasPrefix(ref, componentSchemaPrefix) {
+		return ""
+	}
+	return unescapePointer(strings.TrimPrefix(ref, componentSchemaPrefix))
+}
+
+// convertObject converts the top level schema of a resource, only its properties are kept
+func (i *openAPIImporter) convertObject(schema interface{}, pointer string) map[string]interface{} {
+	converted := i.convert(schema, pointer, 0, map[string]bool{})
+
+	properties, _ := converted["properties"].(map[string]interface{})
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	object := map[string]interface{}{"type": "object", "properties": properties}
+
+	for key, property := range properties {
+		if dsi.ReservedField(key) {
+			i.report.warn(pointer+"/properties/"+escapePointer(key), "'%s' is a reserved field, the property is dropped", key)
+			delete(properties, key)
+			continue
+		}
+		prop, _ := property.(map[string]interface{})
+		if relation, ok := prop["x-relation"].(string); ok {
+			properties[key] = map[string]interface{}{"type": "string", "relation": relation}
+			continue
+		}
+		if items, _ := prop["items"].(map[string]interface{}); items["x-relation"] != nil {
+			prop["items"] = map[string]interface{}{"type": "string"}
+			prop["relation"] = items["x-relation"]
+		}
+		if i.stripRelations(prop) {
+			i.report.warn(pointer+"/properties/"+escapePointer(key), "relations are only supported on top level properties, nested references to resources are stored as ids")
+		}
+	}
+
+	if required, ok := converted["required"].([]interface{}); ok {
+		keep := []interface{}{}
+		for _, key := range required {
+			if _, ok := properties[fmt.Sprint(key)]; ok {
+				keep = append(keep, key)
+			}
+		}
+		if len(keep) > 0 {
+			object["required"] = keep
+		}
+	}
+
+	return object
+}
+
+// convert converts an OpenAPI schema object to a JSON schema, references to imported resources are marked with
+// `x-relation` and become relation properties at the top level of the resource
+func (i *openAPIImporter) convert(schema interface{}, pointer string, depth int, stack map[string]bool) map[string]interface{} {
+	m, ok := schema.(map[string]interface{})
+	if !ok {
+		i.report.warn(pointer, "schema is not an object, it is unconstrained")
+		return map[string]interface{}{}
+	}
+	if depth > dsi.MaxRecursion {
+		i.report.warn(pointer, "schema is nested deeper than %d levels, it is unconstrained", dsi.MaxRecursion)
+		return map[string]interface{}{}
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		component := componentRef(m)
+		if component == "" {
+			i.report.warn(pointer, "reference '%s' is not a component schema, it is unconstrained", ref)
+			return map[string]interface{}{}
+		}
+		if resource, ok := i.resources[component]; ok && depth > 0 {
+			return map[string]interface{}{"type": "string", "x-relation": resource.definition.PathName}
+		}
+		if stack[component] {
+			i.report.warn(pointer, "reference to '%s' is recursive, it is unconstrained", component)
+			return map[string]interface{}{}
+		}
+		stack[component] = true
+		defer delete(stack, component)
+		return i.convert(i.schemas[component], componentPointer(component), depth, stack)
+	}
+
+	converted := map[string]interface{}{}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := m[key]
+		child := pointer + "/" + escapePointer(key)
+
+		switch {
+		case supportedKeywords[key]:
+			converted[key] = value
+		case ignoredKeywords[key], strings.HasPrefix(key, "x-"):
+		case key == "nullable":
+			i.report.warn(child, "nullable is not supported, null values are rejected")
+		case key == "properties":
+			properties, _ := value.(map[string]interface{})
+			out := map[string]interface{}{}
+			for name, property := range properties {
+				out[name] = i.convert(property, child+"/"+escapePointer(name), depth+1, stack)
+			}
+			converted[key] = out
+		case key == "items":
+			converted[key] = i.convert(value, child, depth+1, stack)
+		case key == "additionalProperties":
+			if b, ok := value.(bool); ok {
+				converted[key] = b
+			} else {
+				converted[key] = i.convert(value, child, depth+1, stack)
+			}
+		case key == "allOf":
+			i.mergeAllOf(converted, value, child, depth, stack)
+		case key == "oneOf", key == "anyOf", key == "not", key == "discriminator":
+			i.report.warn(child, "%s is not supported, the schema is unconstrained", key)
+			return map[string]interface{}{}
+		default:
+			i.report.warn(child, "keyword '%s' is not supported, it is dropped", key)
+		}
+	}
+
+	return converted
+}
+
+// mergeAllOf merges the properties and required keys of the `allOf` schemas into the schema
+func (i *openAPIImporter) mergeAllOf(converted map[string]interface{}, allOf interface{}, pointer string, depth int, stack map[string]bool) {
+	schemas, _ := allOf.([]interface{})
+	for n, schema := range schemas {
+		part := i.convert(schema, fmt.Sprintf("%s/%d", pointer, n), depth, stack)
+		for key, value := range part {
+			switch key {
+			case "properties":
+				properties, _ := converted["properties"].(map[string]interface{})
+				if properties == nil {
+					properties = map[string]interface{}{}
+				}
+				for name, property := range value.(map[string]interface{}) {
+					properties[name] = property
+				}
+				converted["properties"] = properties
+			case "required":
+				required, _ := converted["required"].([]interface{})
+				converted["required"] = append(required, value.([]interface{})...)
+			default:
+				if _, ok := converted[key]; !ok {
+					converted[key] = value
+				}
+			}
+		}
+	}
+}
+
+// stripRelations removes the relation markers of nested references, returns true if there were any
+func (i *openAPIImporter) stripRelations(schema map[string]interface{}) bool {
+	found := false
+	if _, ok := schema["x-relation"]; ok {
+		delete(schema, "x-relation")
+		found = true
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		found = i.stripRelations(items) || found
+	}
+	if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+		found = i.stripRelations(additional) || found
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	for _, property := range properties {
+		if p, ok := property.(map[string]interface{}); ok {
+			found = i.stripRelations(p) || found
+		}
+	}
+	return found
+}
+
+func escapePointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+func unescapePointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~1", "/", -1), "~0", "~", -1)
+}
@@ -0,0 +1,249 @@
+package resources
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+const petStore = `
+openapi: 3.0.1
+info:
+  title: Pet Store
+  version: 1.0.0
+security:
+  - bearer: []
+paths:
+  /owners:
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Owner'
+  /pets:
+    get:
+      security: []
+      responses:
+        '200':
+          description: pets
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  items:
+                    type: array
+                    items:
+                      $ref: '#/components/schemas/Pet'
+  /pets/{petId}:
+    get:
+      security:
+        - {}
+      responses:
+        '200':
+          description: pet
+    delete:
+      responses:
+        '204':
+          description: deleted
+  /health:
+    get:
+      responses:
+        '200':
+          description: ok
+components:
+  schemas:
+    Base:
+      type: object
+      required: [name]
+      properties:
+        id:
+          type: string
+        name:
+          type: string
+          example: Rex
+    Pet:
+      allOf:
+        - $ref: '#/components/schemas/Base'
+        - type: object
+          properties:
+            age:
+              type: integer
+              minimum: 0
+              nullable: true
+            owner:
+              $ref: '#/components/schemas/Owner'
+            friends:
+              type: array
+              items:
+                $ref: '#/components/schemas/Pet'
+            tag:
+              oneOf:
+                - type: string
+                - type: integer
+    Owner:
+      type: object
+      properties:
+        name:
+          type: string
+        address:
+          type: object
+          properties:
+            home:
+              $ref: '#/components/schemas/Owner'
+`
+
+func testImport(t *testing.T, document string) *ImportReport {
+	doc, err := parseOpenAPIDocument([]byte(document))
+	assert.Nil(t, err)
+	return importOpenAPI(doc)
+}
+
+func schemaOf(t *testing.T, def *models.ResourceDefinition) map[string]interface{} {
+	schema, err := def.GetSchemaMap()
+	assert.Nil(t, err)
+	return schema
+}
+
+func TestImportOpenAPI(t *testing.T) {
+	report := testImport(t, petStore)
+	assert.Empty(t, report.Errors)
+	assert.Len(t, report.Definitions, 2)
+
+	owners, pets := report.Definitions[0], report.Definitions[1]
+	assert.Equal(t, "Owner", owners.Title)
+	assert.Equal(t, "owners", owners.PathName)
+	assert.Equal(t, "Pet", pets.Title)
+	assert.Equal(t, "pets", pets.PathName)
+
+	// reads of pets are anonymous, everything else inherits the global security
+	assert.True(t, pets.Create)
+	assert.False(t, pets.Read)
+	assert.True(t, pets.Update)
+	assert.True(t, pets.Delete)
+
+	assert.Equal(t, map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"name"},
+		"properties": map[string]interface{}{
+			"name":    map[string]interface{}{"type": "string"},
+			"age":     map[string]interface{}{"type": "integer", "minimum": float64(0)},
+			"owner":   map[string]interface{}{"type": "string", "relation": "owners"},
+			"friends": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "relation": "pets"},
+			"tag":     map[string]interface{}{},
+		},
+	}, schemaOf(t, pets))
+
+	home := schemaOf(t, owners)["properties"].(map[string]interface{})["address"].(map[string]interface{})["properties"].(map[string]interface{})["home"]
+	assert.Equal(t, map[string]interface{}{"type": "string"}, home)
+
+	warnings := map[string]string{}
+	for _, warning := range report.Warnings {
+		warnings[warning.Pointer] = warning.Message
+	}
+	assert.Equal(t, map[string]string{
+		"/paths/~1health":                                         "no component schema is referenced by the operations of the path, it is skipped",
+		"/components/schemas/Pet/properties/id":                   "'id' is a reserved field, the property is dropped",
+		"/components/schemas/Pet/allOf/1/properties/age/nullable": "nullable is not supported, null values are rejected",
+		"/components/schemas/Pet/allOf/1/properties/tag/oneOf":    "oneOf is not supported, the schema is unconstrained",
+		"/components/schemas/Owner/properties/address":            "relations are only supported on top level properties, nested references to resources are stored as ids",
+	}, warnings)
+}
+
+func TestImportOpenAPIComponents(t *testing.T) {
+	report := testImport(t, `{
+		"openapi": "3.0.0",
+		"components": {"schemas": {
+			"LongComponentName": {"type": "object", "properties": {"title": {"type": "string"}}},
+			"Tree": {"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#/components/schemas/Tree"}}}},
+			"Names": {"type": "array", "items": {"type": "string"}}
+		}}
+	}`)
+	assert.Empty(t, report.Errors)
+	assert.Len(t, report.Definitions, 2)
+
+	long := report.Definitions[0]
+	assert.Equal(t, "LongComponen", long.Title)
+	assert.Equal(t, "longcomponen", long.PathName)
+	assert.True(t, long.Read)
+
+	// references between imported resources are relations
+	assert.Equal(t, map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "relation": "tree"},
+		schemaOf(t, report.Definitions[1])["properties"].(map[string]interface{})["children"])
+
+	messages := []string{}
+	for _, warning := range report.Warnings {
+		messages = append(messages, warning.Message)
+	}
+	assert.Contains(t, messages, "component schema is not an object, it is skipped")
+	assert.Contains(t, messages, "path name 'longcomponentname' is truncated to 'longcomponen'")
+	assert.Contains(t, messages, "no CRUD paths refer to component schemas, every object component schema is imported")
+}
+
+func TestImportOpenAPIErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		document string
+		error    string
+	}{
+		{
+			name:     "swagger 2",
+			document: `{"swagger": "2.0"}`,
+			error:    "only OpenAPI 3 documents are supported",
+		},
+		{
+			name:     "missing component",
+			document: `{"openapi": "3.0.0", "paths": {"/posts": {"post": {"requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Post"}}}}}}}}`,
+			error:    "component schema 'Post' does not exist",
+		},
+		{
+			name:     "duplicate path names",
+			document: `{"openapi": "3.0.0", "components": {"schemas": {"posts": {"type": "object"}, "Posts": {"type": "object"}}}}`,
+			error:    "path name 'posts' is also used by 'Posts'",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			report := testImport(t, tc.document)
+			assert.Len(t, report.Errors, 1)
+			assert.Equal(t, tc.error, report.Errors[0].Message)
+		})
+	}
+}
+
+func TestParseOpenAPIDocument(t *testing.T) {
+	_, err := parseOpenAPIDocument([]byte(`{"openapi":`))
+	assert.NotNil(t, err)
+
+	_, err = parseOpenAPIDocument([]byte(`- openapi`))
+	assert.EqualError(t, err, "the document must be an object")
+
+	doc, err := parseOpenAPIDocument([]byte("openapi: 3.0.0\npaths:\n  /a:\n    '200': {}\n"))
+	assert.Nil(t, err)
+	b, err := json.Marshal(doc)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"openapi":"3.0.0","paths":{"/a":{"200":{}}}}`, string(b))
+}
+
+func TestImportSizeLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("projectId", "project")
+	})
+	router.POST("/import/preview", New(nil).PreviewImport)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/import/preview", bytes.NewReader(make([]byte, maxImportSize+1)))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+}
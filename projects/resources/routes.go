@@ -15,11 +15,13 @@ func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, config *confi
 	// admin/mgmt routes
 	// Only application users have access to resource definitions
 	resources := engine.Group("/resources")
-	resources.Use(middleware.AppUserJwtAuthzMiddleware(config))
+	resources.Use(middleware.AppUserJwtAuthzMiddleware(datastore, config))
 	resources.Use(middleware.AppUserProjectAuthzMiddleware(datastore, config))
 
 	resources.POST("/", handler.AddResourceDefinition)
 	resources.GET("/", handler.ListResourceDefinitions)
+	resources.POST("/import", handler.ImportResourceDefinitions)
+	resources.POST("/import/preview", handler.PreviewImport)
 	resources.GET("/:resourceDefinitionID", handler.GetResourceDefinition)
 	resources.PUT("/:resourceDefinitionID", handler.UpdateResourceDefinition)
 	resources.DELETE("/:resourceDefinitionID", handler.DeleteResourceDefinition)
@@ -0,0 +1,115 @@
+package roles
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// New returns a pointer to a new `Roles` struct
+func New(db interfaces.ProjectRolesDatastore) *Roles {
+	return &Roles{
+		store: db,
+	}
+}
+
+// Roles wraps the datastore and any HTTP handlers for the custom roles of a project
+type Roles struct {
+	store interfaces.ProjectRolesDatastore
+}
+
+// ListRoles lists the custom roles of the project
+func (r *Roles) ListRoles(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+
+	roles, err := r.store.ListRoles(projectID)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": roles})
+}
+
+// GetRole returns a single custom role of the project
+func (r *Roles) GetRole(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+
+	role, err := r.store.GetRole(projectID, c.Param("roleName"))
+	if err != nil {
+		apierror.Respond(c, http.StatusNotFound, "role not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// CreateRole creates a custom role of the project, which can then be assigned to project users and api keys
+func (r *Roles) CreateRole(c *gin.Context) {
+	var role models.Role
+	projectID := c.MustGet("projectId").(string)
+
+	c.BindJSON(&role)
+
+	if err := role.Validate(); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := r.store.GetRole(projectID, role.Name); err == nil {
+		apierror.Respond(c, http.StatusBadRequest, "role already exists")
+		return
+	}
+
+	if err := r.store.CreateRole(projectID, &role); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole replaces the description and permissions of a custom role, the role's users and api keys are affected
+// with their next request
+func (r *Roles) UpdateRole(c *gin.Context) {
+	var role models.Role
+	projectID := c.MustGet("projectId").(string)
+
+	c.BindJSON(&role)
+	role.Name = c.Param("roleName")
+
+	if err := role.Validate(); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existing, err := r.store.GetRole(projectID, role.Name)
+	if err != nil {
+		apierror.Respond(c, http.StatusNotFound, "role not found")
+		return
+	}
+
+	if err := r.store.UpdateRole(projectID, role.Name, &role); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	role.ID = existing.ID
+	role.ProjectID = existing.ProjectID
+	role.Created = existing.Created
+
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole deletes a custom role of the project, requests of users and api keys which still have the role are denied
+func (r *Roles) DeleteRole(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+
+	if err := r.store.DeleteRole(projectID, c.Param("roleName")); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
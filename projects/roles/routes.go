@@ -0,0 +1,45 @@
+package roles
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/config"
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/middleware"
+)
+
+// Handler is an interface to the custom role HTTP handler functions.
+type Handler interface {
+	ListRoles(c *gin.Context)
+	GetRole(c *gin.Context)
+	CreateRole(c *gin.Context)
+	UpdateRole(c *gin.Context)
+	DeleteRole(c *gin.Context)
+}
+
+// SetRoutes sets all of the appropriate routes to handlers for project roles
+func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, config *config.AppConfig) error {
+	// create new Roles handler with datastore
+	handler := New(datastore)
+
+	// di for testing
+	return setRoutes(
+		engine,
+		handler,
+		middleware.AppUserJwtAuthzMiddleware(datastore, config),
+		middleware.AppUserProjectAuthzMiddleware(datastore, config),
+	)
+}
+
+func setRoutes(engine *gin.Engine, handler Handler, mw ...gin.HandlerFunc) error {
+	// Only app users have access to role management
+	roles := engine.Group("/mgmt/roles")
+	roles.Use(mw...)
+
+	roles.GET("/", handler.ListRoles)              // list the custom roles of this project
+	roles.POST("/", handler.CreateRole)            // create a custom role
+	roles.GET("/:roleName", handler.GetRole)       // get a single custom role
+	roles.PUT("/:roleName", handler.UpdateRole)    // replace the permissions of a custom role
+	roles.DELETE("/:roleName", handler.DeleteRole) // delete a custom role
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package roles
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.ReleaseMode)
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestCreateRole(t *testing.T) {
+	ds := &interfaces.MockProjectRolesDatastore{}
+	handler := New(ds)
+
+	tables := []struct {
+		name       string
+		exists     bool
+		createErr  error
+		role       *models.Role
+		statusCode int
+	}{
+		{
+			"success",
+			false,
+			nil,
+			&models.Role{Name: "team-member", Permissions: []*models.RolePermission{{Resource: "posts", Verbs: []string{"read", "update"}, Conditions: []string{"team_id == user.team_id"}}}},
+			201,
+		},
+		{
+			"role already exists",
+			true,
+			nil,
+			&models.Role{Name: "team-member"},
+			400,
+		},
+		{
+			"validation error - built-in role",
+			false,
+			nil,
+			&models.Role{Name: "admin"},
+			400,
+		},
+		{
+			"validation error - invalid condition",
+			false,
+			nil,
+			&models.Role{Name: "team-member", Permissions: []*models.RolePermission{{Resource: "posts", Verbs: []string{"read"}, Conditions: []string{"team_id > 3"}}}},
+			400,
+		},
+		{
+			"datastore error",
+			false,
+			errors.New("unexpected error"),
+			&models.Role{Name: "team-member"},
+			500,
+		},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.Default()
+			ds.GetRoleFunc = func(projectID, name string) (*models.Role, error) {
+				if tt.exists {
+					return &models.Role{Name: name}, nil
+				}
+				return nil, errors.New("not found")
+			}
+			ds.CreateRoleFunc = func(projectID string, role *models.Role) error {
+				return tt.createErr
+			}
+
+			setRoutes(router, handler, func(c *gin.Context) { c.Set("projectId", "testing") })
+			w := httptest.NewRecorder()
+
+			jsonStr, _ := json.Marshal(tt.role)
+			req, _ := http.NewRequest("POST", "/mgmt/roles/", bytes.NewBuffer(jsonStr))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestUpdateRole(t *testing.T) {
+	ds := &interfaces.MockProjectRolesDatastore{}
+	handler := New(ds)
+
+	tables := []struct {
+		name       string
+		roleName   string
+		exists     bool
+		role       *models.Role
+		statusCode int
+	}{
+		{"success", "viewer", true, &models.Role{Permissions: []*models.RolePermission{{Resource: "*", Verbs: []string{"read"}}}}, 200},
+		{"not found", "viewer", false, &models.Role{}, 404},
+		{"validation error - no verbs", "viewer", true, &models.Role{Permissions: []*models.RolePermission{{RootKey: "settings"}}}, 400},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.Default()
+			updated := ""
+			ds.GetRoleFunc = func(projectID, name string) (*models.Role, error) {
+				if tt.exists {
+					return &models.Role{ID: "role-id", Name: name}, nil
+				}
+				return nil, errors.New("not found")
+			}
+			ds.UpdateRoleFunc = func(projectID, name string, role *models.Role) error {
+				updated = name
+				return nil
+			}
+
+			setRoutes(router, handler, func(c *gin.Context) { c.Set("projectId", "testing") })
+			w := httptest.NewRecorder()
+
+			jsonStr, _ := json.Marshal(tt.role)
+			req, _ := http.NewRequest("PUT", "/mgmt/roles/"+tt.roleName, bytes.NewBuffer(jsonStr))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			if w.Code == 200 {
+				response := &models.Role{}
+				json.Unmarshal(w.Body.Bytes(), response)
+				assert.Equal(t, tt.roleName, updated)
+				assert.Equal(t, "role-id", response.ID)
+				assert.Equal(t, tt.roleName, response.Name)
+			}
+		})
+	}
+}
+
+func TestListRoles(t *testing.T) {
+	ds := &interfaces.MockProjectRolesDatastore{}
+	handler := New(ds)
+
+	tables := []struct {
+		name       string
+		listErr    error
+		roles      []*models.Role
+		statusCode int
+	}{
+		{"success", nil, []*models.Role{{Name: "viewer", Permissions: []*models.RolePermission{}}}, 200},
+		{"error", errors.New("unexpected error"), nil, 500},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.Default()
+			ds.ListRolesFunc = func(projectID string) ([]*models.Role, error) {
+				return tt.roles, tt.listErr
+			}
+
+			setRoutes(router, handler, func(c *gin.Context) { c.Set("projectId", "testing") })
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/mgmt/roles/", nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			if w.Code == 200 {
+				respBody := struct {
+					Items []*models.Role `json:"items"`
+				}{}
+				json.Unmarshal(w.Body.Bytes(), &respBody)
+				assert.Equal(t, tt.roles, respBody.Items)
+			}
+		})
+	}
+}
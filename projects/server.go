@@ -1,7 +1,10 @@
 package projects
 
 import (
+	"net/http"
+
 	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/events"
@@ -10,31 +13,42 @@ import (
 	"github.com/machinable/machinable/middleware"
 	"github.com/machinable/machinable/projects/apikeys"
 	"github.com/machinable/machinable/projects/documents"
+	"github.com/machinable/machinable/projects/graphql"
 	"github.com/machinable/machinable/projects/hooks"
 	"github.com/machinable/machinable/projects/jsontree"
 	"github.com/machinable/machinable/projects/logs"
 	"github.com/machinable/machinable/projects/resources"
+	"github.com/machinable/machinable/projects/roles"
 	"github.com/machinable/machinable/projects/sessions"
 	"github.com/machinable/machinable/projects/spec"
 	"github.com/machinable/machinable/projects/users"
 )
 
 // CreateRoutes creates a gin.Engine for the project routes
-func CreateRoutes(datastore interfaces.Datastore, cache redis.UniversalClient, processor *events.Processor, config *config.AppConfig) *gin.Engine {
+func CreateRoutes(datastore interfaces.Datastore, cache redis.UniversalClient, bus events.EventBus, config *config.AppConfig) *gin.Engine {
 
 	router := gin.Default()
+	router.Use(middleware.RequestIDMiddleware())
 	router.Use(middleware.OpenCORSMiddleware())
 	router.Use(middleware.SubDomainMiddleware())
+	router.NoRoute(func(c *gin.Context) {
+		apierror.Respond(c, http.StatusNotFound, "route not found")
+	})
+
+	// "before" hooks are called synchronously by the document and json handlers
+	validator := events.NewValidator(datastore)
 
 	// set routes -> handlers for each package
 	resources.SetRoutes(router, datastore, config)
-	documents.SetRoutes(router, datastore, cache, processor, config)
+	documents.SetRoutes(router, datastore, cache, validator, config)
 	logs.SetRoutes(router, datastore, config)
-	users.SetRoutes(router, datastore, config)
-	sessions.SetRoutes(router, datastore, config)
+	users.SetRoutes(router, datastore, cache, bus, config)
+	sessions.SetRoutes(router, datastore, cache, config)
 	apikeys.SetRoutes(router, datastore, config)
-	jsontree.SetRoutes(router, datastore, cache, processor, config)
-	spec.SetRoutes(router, datastore)
+	roles.SetRoutes(router, datastore, config)
+	jsontree.SetRoutes(router, datastore, cache, validator, config)
+	spec.SetRoutes(router, datastore, config)
+	graphql.SetRoutes(router, datastore, cache, validator, config)
 	hooks.SetRoutes(router, datastore, config)
 
 	return router
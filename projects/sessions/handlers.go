@@ -11,6 +11,8 @@ import (
 	"github.com/mssola/user_agent"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/auth"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
@@ -19,17 +21,20 @@ import (
 )
 
 // New returns a pointer to a new `Users` struct
-func New(db interfaces.Datastore, config *config.AppConfig) *Sessions {
+func New(db interfaces.Datastore, cache redis.UniversalClient, config *config.AppConfig) *Sessions {
 	return &Sessions{
 		store:  db,
+		cache:  cache,
 		config: config,
-		jwt:    auth.NewJWT(config),
+		jwt:    auth.NewJWT(config, db),
 	}
 }
 
-// Sessions wraps the datastore and any HTTP handlers for project user sessions
+// Sessions wraps the datastore and any HTTP handlers for project user sessions. The state of external identity
+// provider logins is kept in the cache.
 type Sessions struct {
 	store  interfaces.Datastore
+	cache  redis.UniversalClient
 	config *config.AppConfig
 	jwt    *auth.JWT
 }
@@ -60,13 +65,13 @@ func (s *Sessions) CreateSession(c *gin.Context) {
 	// basic auth for login
 	authorizationHeader, _ := c.Request.Header["Authorization"]
 	if len(authorizationHeader) <= 0 {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "no authorization header"})
+		apierror.Respond(c, http.StatusUnauthorized, "no authorization header")
 		return
 	}
 	authzHeader := strings.SplitN(authorizationHeader[0], " ", 2)
 
 	if len(authzHeader) != 2 || strings.ToLower(authzHeader[0]) != "basic" {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "malformed authorization header"})
+		apierror.Respond(c, http.StatusUnauthorized, "malformed authorization header")
 		return
 	}
 
@@ -74,7 +79,7 @@ func (s *Sessions) CreateSession(c *gin.Context) {
 	pair := strings.SplitN(string(payload), ":", 2)
 
 	if len(pair) != 2 {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "no authorization header payload"})
+		apierror.Respond(c, http.StatusUnauthorized, "no authorization header payload")
 		return
 	}
 
@@ -89,7 +94,7 @@ func (s *Sessions) CreateSession(c *gin.Context) {
 	// find project
 	project, err := s.store.GetProjectBySlug(projectSlug)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "project does not exist"})
+		apierror.Respond(c, http.StatusNotFound, "project does not exist")
 		return
 	}
 
@@ -99,58 +104,92 @@ func (s *Sessions) CreateSession(c *gin.Context) {
 	log.Println(user)
 	log.Println(err)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "username not found"})
+		apierror.Respond(c, http.StatusNotFound, "username not found")
 		return
 	}
 
 	// compare passwords
 	if !auth.CompareHashAndPassword(user.PasswordHash, userPassword) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "invalid password"})
+		apierror.Respond(c, http.StatusNotFound, "invalid password")
 		return
 	}
 
+	// registered users verify their email before logging in, if required by the project
+	if !user.Active {
+		apierror.Respond(c, http.StatusUnauthorized, "user is not active, please confirm your account")
+		return
+	}
+
+	tokens, aErr := s.createTokensAndSession(project, user, c)
+	if aErr != nil {
+		apierror.RespondError(c, aErr)
+		return
+	}
+
+	c.JSON(http.StatusCreated, tokens)
+}
+
+// projectJWT returns the `JWT` which creates tokens with the lifetimes of the project
+func (s *Sessions) projectJWT(project *models.Project) *auth.JWT {
+	return s.jwt.Project(project.ID).Lifetimes(project.AccessTokenLifetime, project.RefreshTokenLifetime)
+}
+
+// createTokensAndSession creates the session (refresh token) and access token of a project user
+func (s *Sessions) createTokensAndSession(project *models.Project, user *models.ProjectUser, c *gin.Context) (gin.H, *apierror.Error) {
+	jwtAuth := s.projectJWT(project)
+
 	// create access token
-	claims := jwt.MapClaims{
-		"projects": map[string]interface{}{
-			projectSlug: true,
-		},
-		"user": map[string]interface{}{
-			"id":     user.ID,
-			"name":   user.Username,
-			"active": true,
-			"read":   user.Read,
-			"write":  user.Write,
-			"role":   user.Role,
-			"type":   "project",
-		},
+	accessToken, err := jwtAuth.CreateAccessToken(userClaims(project.Slug, user))
+	if err != nil {
+		return nil, apierror.New(http.StatusInternalServerError, "failed to create the access token")
 	}
 
-	accessToken, err := s.jwt.CreateAccessToken(claims)
+	tokenID, err := auth.RandomToken(16)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create the access token"})
-		return
+		return nil, apierror.New(http.StatusInternalServerError, "failed to create session")
 	}
 
 	// create session in database (refresh token)
 	session := s.generateSession(user.ID, c.ClientIP(), c.Request.UserAgent())
+	session.RefreshTokenID = tokenID
+	session.Expires = time.Now().Add(jwtAuth.RefreshLifetime())
 	err = s.store.CreateSession(project.ID, session)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
-		return
+		return nil, apierror.New(http.StatusInternalServerError, "failed to create session")
 	}
 
-	refreshToken, err := s.jwt.CreateRefreshToken(session.ID, user.ID)
+	refreshToken, err := jwtAuth.CreateRefreshToken(session.ID, user.ID, tokenID, session.Expires)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create refresh token"})
-		return
+		return nil, apierror.New(http.StatusInternalServerError, "failed to create refresh token")
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
+	return gin.H{
 		"message":       "Successfully logged in",
 		"access_token":  accessToken,
 		"refresh_token": refreshToken,
 		"session_id":    session.ID,
-	})
+	}, nil
+}
+
+// userClaims are the claims of a project user's access token, the attributes are evaluated by the conditions of
+// custom roles and the groups by the ACLs of shared documents
+func userClaims(projectSlug string, user *models.ProjectUser) jwt.MapClaims {
+	return jwt.MapClaims{
+		"projects": map[string]interface{}{
+			projectSlug: true,
+		},
+		"user": map[string]interface{}{
+			"id":         user.ID,
+			"name":       user.Username,
+			"active":     user.Active,
+			"read":       user.Read,
+			"write":      user.Write,
+			"role":       user.Role,
+			"attributes": user.Attributes,
+			"groups":     user.Groups,
+			"type":       "project",
+		},
+	}
 }
 
 // ListSessions lists all active user sessions for a project
@@ -160,7 +199,7 @@ func (s *Sessions) ListSessions(c *gin.Context) {
 	sessions, err := s.store.ListSessions(projectID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -175,38 +214,48 @@ func (s *Sessions) RevokeSession(c *gin.Context) {
 	err := s.store.DeleteSession(projectID, sessionID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	c.JSON(http.StatusNoContent, gin.H{})
 }
 
-// RefreshSession uses the refresh token to generate a new access token
+// RefreshSession uses the refresh token to generate a new access token and refresh token. Refresh tokens are single
+// use, if a used refresh token is presented again the session is revoked, since either the user or an attacker holds
+// a stolen token.
 func (s *Sessions) RefreshSession(c *gin.Context) {
 	projectID := c.MustGet("projectId").(string)
 	projectSlug := c.MustGet("project").(string)
-	// get session and user id from context, should have been injected by ValidateRefreshToken
+	// get session, user and token id from context, should have been injected by ValidateRefreshToken
 	sessionID, ok := c.MustGet("session_id").(string)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no session"})
+		apierror.Respond(c, http.StatusBadRequest, "no session")
 		return
 	}
 
 	userID, ok := c.MustGet("user_id").(string)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no user"})
+		apierror.Respond(c, http.StatusBadRequest, "no user")
 		return
 	}
+	tokenID := c.GetString("refresh_token_id")
 
-	// load session to update last accessed
+	project, err := s.store.GetProjectBySlug(projectSlug)
+	if err != nil {
+		apierror.Respond(c, http.StatusNotFound, "project does not exist")
+		return
+	}
 
 	// verify session exists
-	_, err := s.store.GetSession(projectID, sessionID)
-
+	session, err := s.store.GetSession(projectID, sessionID)
 	if err != nil {
-		// no documents in result, user does not exist
-		c.JSON(http.StatusNotFound, gin.H{"message": "error creating access token."})
+		// no documents in result, session has been revoked
+		apierror.Respond(c, http.StatusNotFound, "error creating access token.")
+		return
+	}
+	if session.UserID != userID || !time.Now().Before(session.Expires) {
+		apierror.Respond(c, http.StatusUnauthorized, "session has expired")
 		return
 	}
 
@@ -214,36 +263,66 @@ func (s *Sessions) RefreshSession(c *gin.Context) {
 	user, err := s.store.GetUserByID(projectID, userID)
 	if err != nil {
 		// no documents in result, user does not exist
-		c.JSON(http.StatusNotFound, gin.H{"message": "error creating access token."})
+		apierror.Respond(c, http.StatusNotFound, "error creating access token.")
 		return
 	}
 
-	// create access token
-	claims := jwt.MapClaims{
-		"projects": map[string]interface{}{
-			projectSlug: true,
-		},
-		"user": map[string]interface{}{
-			"id":     user.ID,
-			"name":   user.Username,
-			"active": true,
-			"read":   user.Read,
-			"write":  user.Write,
-			"role":   user.Role,
-			"type":   "project",
-		},
+	jwtAuth := s.projectJWT(project)
+	nextID, err := auth.RandomToken(16)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to create refresh token")
+		return
 	}
+	expires := time.Now().Add(jwtAuth.RefreshLifetime())
 
-	accessToken, err := s.jwt.CreateAccessToken(claims)
+	// the tokens are created before the rotation is saved, so a failure can't leave the session without a usable token
+	accessToken, err := jwtAuth.CreateAccessToken(userClaims(projectSlug, user))
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to create the access token")
+		return
+	}
+	refreshToken, err := jwtAuth.CreateRefreshToken(sessionID, userID, nextID, expires)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create the access token"})
+		apierror.Respond(c, http.StatusInternalServerError, "failed to create refresh token")
 		return
 	}
 
-	// update session `last_accessed` time
-	err = s.store.UpdateProjectSessionLastAccessed(projectID, sessionID, time.Now())
+	// the session only accepts its latest refresh token, the rotation fails if the token has been used concurrently
+	rotated := false
+	if tokenID == session.RefreshTokenID {
+		rotated, err = s.store.RotateRefreshToken(projectID, sessionID, tokenID, nextID, expires)
+		if err != nil {
+			log.Println(err)
+			apierror.Respond(c, http.StatusInternalServerError, "failed to refresh session")
+			return
+		}
+	}
+	if !rotated {
+		if err := s.store.DeleteSession(projectID, sessionID); err != nil {
+			log.Println(err)
+		}
+		apierror.Respond(c, http.StatusUnauthorized, "refresh token reuse detected, session revoked")
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"access_token": accessToken,
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
 	})
 }
+
+// JWKS serves the public keys which verify the project's tokens, so other services can verify project users
+func (s *Sessions) JWKS(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+
+	keys, err := s.jwt.Project(projectID).JWKS()
+	if err != nil {
+		log.Println(err)
+		apierror.Respond(c, http.StatusInternalServerError, "failed to load signing keys")
+		return
+	}
+
+	// verifiers refetch the keys if a token has an unknown key id, new keys are published before they sign tokens
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keys)
+}
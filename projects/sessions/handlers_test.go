@@ -0,0 +1,256 @@
+package sessions
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/auth"
+	"github.com/machinable/machinable/config"
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.ReleaseMode)
+	code := m.Run()
+	os.Exit(code)
+}
+
+// testStore keeps a project, its users, sessions and signing keys in memory, unused functions panic
+type testStore struct {
+	interfaces.Datastore
+	project    *models.Project
+	users      map[string]*models.ProjectUser
+	sessions   map[string]*models.Session
+	keys       []*models.SigningKey
+	identities []*models.UserIdentity
+}
+
+func newTestStore(users ...*models.ProjectUser) *testStore {
+	store := &testStore{
+		project:  &models.Project{ID: "project", Slug: "blog"},
+		users:    map[string]*models.ProjectUser{},
+		sessions: map[string]*models.Session{},
+	}
+	for _, user := range users {
+		store.users[user.ID] = user
+	}
+	return store
+}
+
+func (s *testStore) GetProjectBySlug(slug string) (*models.Project, error) {
+	if slug != s.project.Slug {
+		return nil, errors.New("project not found")
+	}
+	return s.project, nil
+}
+
+func (s *testStore) GetUserByUsername(projectID, userName string) (*models.ProjectUser, error) {
+	for _, user := range s.users {
+		if user.Username == userName {
+			return user, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (s *testStore) GetUserByID(projectID, userID string) (*models.ProjectUser, error) {
+	if user, ok := s.users[userID]; ok {
+		return user, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+func (s *testStore) GetUserByEmail(projectID, email string) (*models.ProjectUser, error) {
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (s *testStore) GetUserIdentity(projectID, provider, subject string) (*models.UserIdentity, error) {
+	for _, identity := range s.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *testStore) CreateUserIdentity(projectID string, identity *models.UserIdentity) error {
+	s.identities = append(s.identities, identity)
+	return nil
+}
+
+func (s *testStore) CreateSession(projectID string, session *models.Session) error {
+	session.ID = "session"
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *testStore) GetSession(projectID, sessionID string) (*models.Session, error) {
+	if session, ok := s.sessions[sessionID]; ok {
+		return session, nil
+	}
+	return nil, errors.New("session not found")
+}
+
+func (s *testStore) RotateRefreshToken(projectID, sessionID, currentID, nextID string, expires time.Time) (bool, error) {
+	session, ok := s.sessions[sessionID]
+	if !ok || session.RefreshTokenID != currentID {
+		return false, nil
+	}
+	session.RefreshTokenID = nextID
+	session.Expires = expires
+	return true, nil
+}
+
+func (s *testStore) DeleteSession(projectID, sessionID string) error {
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+func (s *testStore) ListSigningKeys(projectID string) ([]*models.SigningKey, error) {
+	return s.keys, nil
+}
+
+func (s *testStore) GetSigningKey(kid string) (*models.SigningKey, error) {
+	for _, key := range s.keys {
+		if key.ID == kid {
+			return key, nil
+		}
+	}
+	return nil, errors.New("signing key not found")
+}
+
+func (s *testStore) CreateSigningKey(key *models.SigningKey) (bool, error) {
+	s.keys = append(s.keys, key)
+	return true, nil
+}
+
+func testRouter(store *testStore) *gin.Engine {
+	config := &config.AppConfig{JWTAlgorithm: auth.AlgorithmES256, KeyEncryptionKey: "a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s="}
+	handler := New(store, nil, config)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("project", store.project.Slug)
+		c.Set("projectId", store.project.ID)
+	})
+	router.POST("/sessions", handler.CreateSession)
+	router.POST("/sessions/refresh", middleware.ValidateRefreshToken(store, config), handler.RefreshSession)
+
+	return router
+}
+
+func TestCreateSession(t *testing.T) {
+	hash, _ := auth.HashPassword("secret")
+
+	tables := []struct {
+		name       string
+		active     bool
+		password   string
+		statusCode int
+	}{
+		{"active", true, "secret", http.StatusCreated},
+		{"inactive", false, "secret", http.StatusUnauthorized},
+		{"wrong password", true, "wrong", http.StatusNotFound},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(&models.ProjectUser{ID: "user-1", Username: "ada", PasswordHash: hash, Active: tt.active})
+			router := testRouter(store)
+
+			w := login(router, "ada", tt.password)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.statusCode == http.StatusCreated {
+				assert.Contains(t, w.Body.String(), "refresh_token")
+				assert.True(t, store.sessions["session"].Expires.After(time.Now()))
+			} else {
+				// no session is created for users who can not log in
+				assert.Empty(t, store.sessions)
+			}
+		})
+	}
+}
+
+func login(router *gin.Engine, username, password string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/sessions", nil)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func refresh(router *gin.Engine, refreshToken string) (*httptest.ResponseRecorder, map[string]string) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/sessions/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+refreshToken)
+	router.ServeHTTP(w, req)
+
+	tokens := map[string]string{}
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	return w, tokens
+}
+
+func TestRefreshSession(t *testing.T) {
+	hash, _ := auth.HashPassword("secret")
+
+	tables := []struct {
+		name       string
+		reuse      bool
+		expired    bool
+		statusCode int
+	}{
+		{"refresh", false, false, http.StatusOK},
+		{"reused token", true, false, http.StatusUnauthorized},
+		{"expired session", false, true, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(&models.ProjectUser{ID: "user-1", Username: "ada", PasswordHash: hash, Active: true})
+			router := testRouter(store)
+
+			tokens := map[string]string{}
+			json.Unmarshal(login(router, "ada", "secret").Body.Bytes(), &tokens)
+			if tt.expired {
+				store.sessions["session"].Expires = time.Now().Add(-time.Minute)
+			}
+
+			w, next := refresh(router, tokens["refresh_token"])
+			if tt.reuse {
+				assert.Equal(t, http.StatusOK, w.Code)
+				w, _ = refresh(router, tokens["refresh_token"])
+			}
+			assert.Equal(t, tt.statusCode, w.Code)
+
+			if tt.statusCode == http.StatusOK {
+				// the new refresh token replaces the presented one
+				assert.NotEmpty(t, next["access_token"])
+				assert.NotEqual(t, tokens["refresh_token"], next["refresh_token"])
+				w, _ = refresh(router, next["refresh_token"])
+				assert.Equal(t, http.StatusOK, w.Code)
+			}
+			if tt.reuse {
+				// the session is revoked, so the token issued to whoever used it first is rejected as well
+				assert.Empty(t, store.sessions)
+				w, _ = refresh(router, next["refresh_token"])
+				assert.Equal(t, http.StatusNotFound, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,400 @@
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/apierror"
+	"github.com/machinable/machinable/auth"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/middleware"
+)
+
+const (
+	// oauthStateTTL is how long a user has to log in with the identity provider
+	oauthStateTTL = time.Minute * 10
+	// oauthLoginTTL is how long the application has to exchange the login code for the session tokens
+	oauthLoginTTL = time.Minute
+)
+
+// usernameCharacters are removed from usernames of provisioned users
+var usernameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// oauthState is saved between the redirect to the identity provider and the callback
+type oauthState struct {
+	Provider    string `json:"provider"`
+	Verifier    string `json:"verifier"`
+	Nonce       string `json:"nonce"`
+	RedirectURL string `json:"redirect_url"` // RedirectURL is the application url which receives the login code
+	AppState    string `json:"app_state"`    // AppState is passed through to the application
+	LinkUserID  string `json:"link_user_id"` // LinkUserID is the logged in user which links the identity
+}
+
+// oauthTokenBody is the JSON structure of a login code exchange
+type oauthTokenBody struct {
+	Code string `json:"code"`
+}
+
+func oauthStateKey(projectID, state string) string {
+	return fmt.Sprintf("projectOAuthState:%s:%s", projectID, state)
+}
+
+func oauthLoginKey(projectID, code string) string {
+	return fmt.Sprintf("projectOAuthLogin:%s:%s", projectID, code)
+}
+
+// oauthCallbackURL is the redirect uri registered with the identity provider. The host is the project host the request
+// was routed by, the scheme is only taken from `X-Forwarded-Proto` for trusted proxies.
+func (s *Sessions) oauthCallbackURL(c *gin.Context, provider string) string {
+	scheme := middleware.RequestScheme(c, s.config)
+	return fmt.Sprintf("%s://%s/sessions/oauth/%s/callback", scheme, c.Request.Host, url.PathEscape(provider))
+}
+
+// withQuery appends the query parameters to the url
+func withQuery(rawURL string, params url.Values) string {
+	separator := "?"
+	if strings.Contains(rawURL, "?") {
+		separator = "&"
+	}
+	return rawURL + separator + params.Encode()
+}
+
+// OAuthAuthorize redirects the user to the identity provider. If `redirect_url` is set, the application receives a
+// login code after the callback, otherwise the callback responds with the session tokens.
+func (s *Sessions) OAuthAuthorize(c *gin.Context) {
+	authorizationURL, aErr := s.authorizationURL(c, "")
+	if aErr != nil {
+		apierror.RespondError(c, aErr)
+		return
+	}
+
+	c.Redirect(http.StatusFound, authorizationURL)
+}
+
+// OAuthLink returns the authorization url which links the identity of the provider to the logged in user. Identities
+// are only linked automatically to users who verified their email, other users link them explicitly. The callback
+// completes the login as the logged in user.
+func (s *Sessions) OAuthLink(c *gin.Context) {
+	requester := c.MustGet("requester").(*middleware.Requester)
+	if requester.Type != "user" {
+		apierror.Respond(c, http.StatusUnauthorized, "a project user access token is required to link an identity")
+		return
+	}
+
+	authorizationURL, aErr := s.authorizationURL(c, requester.ID)
+	if aErr != nil {
+		apierror.RespondError(c, aErr)
+		return
+	}
+
+	// the application sends the access token, so it navigates to the url rather than following a redirect
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authorizationURL})
+}
+
+// authorizationURL saves the login state and returns the authorization url of the identity provider
+func (s *Sessions) authorizationURL(c *gin.Context, linkUserID string) (string, *apierror.Error) {
+	projectID := c.MustGet("projectId").(string)
+	name := c.Param("provider")
+
+	provider, err := s.store.GetIdentityProvider(projectID, name)
+	if err != nil {
+		return "", apierror.New(http.StatusNotFound, "identity provider not found")
+	}
+
+	redirectURL := c.Query("redirect_url")
+	if redirectURL != "" && !provider.AllowsRedirect(redirectURL) {
+		return "", apierror.New(http.StatusBadRequest, "redirect_url is not allowed for this provider")
+	}
+
+	state, sErr := auth.RandomToken(32)
+	verifier, vErr := auth.RandomToken(32)
+	nonce, nErr := auth.RandomToken(16)
+	if sErr != nil || vErr != nil || nErr != nil {
+		return "", apierror.New(http.StatusInternalServerError, "failed to create login state")
+	}
+
+	b, _ := json.Marshal(&oauthState{
+		Provider:    provider.Name,
+		Verifier:    verifier,
+		Nonce:       nonce,
+		RedirectURL: redirectURL,
+		AppState:    c.Query("state"),
+		LinkUserID:  linkUserID,
+	})
+	if err := s.cache.Set(oauthStateKey(projectID, state), b, oauthStateTTL).Err(); err != nil {
+		log.Println(err)
+		return "", apierror.New(http.StatusInternalServerError, "failed to create login state")
+	}
+
+	return auth.OAuthAuthorizationURL(provider, s.oauthCallbackURL(c, provider.Name), state, verifier, nonce), nil
+}
+
+// OAuthCallback completes the login with the identity provider. The identity is linked to the user who started the
+// login with `OAuthLink`, an existing user with the same verified email, or a new user if the provider allows it.
+func (s *Sessions) OAuthCallback(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+	projectSlug := c.MustGet("project").(string)
+	name := c.Param("provider")
+
+	// the state is single use
+	sKey := oauthStateKey(projectID, c.Query("state"))
+	b, err := s.cache.Get(sKey).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Println(err)
+		}
+		apierror.Respond(c, http.StatusBadRequest, "invalid or expired login state")
+		return
+	}
+	s.cache.Del(sKey)
+
+	state := &oauthState{}
+	if err := json.Unmarshal(b, state); err != nil || state.Provider != name {
+		apierror.Respond(c, http.StatusBadRequest, "invalid or expired login state")
+		return
+	}
+
+	tokens, aErr := s.oauthLogin(c, projectID, projectSlug, state)
+	if state.RedirectURL == "" {
+		if aErr != nil {
+			apierror.RespondError(c, aErr)
+			return
+		}
+		c.JSON(http.StatusCreated, tokens)
+		return
+	}
+
+	// the application receives a short lived code rather than the tokens, so they don't end up in its url
+	params := url.Values{}
+	if state.AppState != "" {
+		params.Set("state", state.AppState)
+	}
+	if aErr != nil {
+		params.Set("error", aErr.Code)
+		params.Set("error_description", aErr.Message)
+		c.Redirect(http.StatusFound, withQuery(state.RedirectURL, params))
+		return
+	}
+
+	code, err := auth.RandomToken(32)
+	if err == nil {
+		b, _ = json.Marshal(tokens)
+		err = s.cache.Set(oauthLoginKey(projectID, code), b, oauthLoginTTL).Err()
+	}
+	if err != nil {
+		log.Println(err)
+		params.Set("error", apierror.StatusCode(http.StatusInternalServerError))
+		params.Set("error_description", "failed to create login code")
+		c.Redirect(http.StatusFound, withQuery(state.RedirectURL, params))
+		return
+	}
+
+	params.Set("code", code)
+	c.Redirect(http.StatusFound, withQuery(state.RedirectURL, params))
+}
+
+// OAuthToken exchanges the login code of the application for the session tokens
+func (s *Sessions) OAuthToken(c *gin.Context) {
+	var body oauthTokenBody
+	projectID := c.MustGet("projectId").(string)
+
+	c.BindJSON(&body)
+	if body.Code == "" {
+		apierror.Respond(c, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	lKey := oauthLoginKey(projectID, body.Code)
+	b, err := s.cache.Get(lKey).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Println(err)
+		}
+		apierror.Respond(c, http.StatusBadRequest, "invalid or expired login code")
+		return
+	}
+	// codes are single use
+	s.cache.Del(lKey)
+
+	tokens := gin.H{}
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "invalid login code")
+		return
+	}
+
+	c.JSON(http.StatusCreated, tokens)
+}
+
+// oauthLogin exchanges the authorization code of the callback, resolves the project user and creates the session
+func (s *Sessions) oauthLogin(c *gin.Context, projectID, projectSlug string, state *oauthState) (gin.H, *apierror.Error) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		return nil, apierror.New(http.StatusUnauthorized, fmt.Sprintf("identity provider denied the login: %s", providerErr))
+	}
+	code := c.Query("code")
+	if code == "" {
+		return nil, apierror.New(http.StatusBadRequest, "authorization code is required")
+	}
+
+	provider, err := s.store.GetIdentityProvider(projectID, state.Provider)
+	if err != nil {
+		return nil, apierror.New(http.StatusNotFound, "identity provider not found")
+	}
+
+	token, err := auth.OAuthExchange(provider, code, s.oauthCallbackURL(c, provider.Name), state.Verifier)
+	if err != nil {
+		log.Println(err)
+		return nil, apierror.New(http.StatusUnauthorized, err.Error())
+	}
+
+	identity, err := auth.OAuthIdentity(provider, token, state.Nonce)
+	if err != nil {
+		log.Println(err)
+		return nil, apierror.New(http.StatusUnauthorized, err.Error())
+	}
+
+	user, aErr := s.identityUser(projectID, provider, identity, state.LinkUserID)
+	if aErr != nil {
+		return nil, aErr
+	}
+	if !user.Active {
+		return nil, apierror.New(http.StatusUnauthorized, "user is not active, please confirm your account")
+	}
+
+	project, err := s.store.GetProjectBySlug(projectSlug)
+	if err != nil {
+		return nil, apierror.New(http.StatusNotFound, "project does not exist")
+	}
+
+	return s.createTokensAndSession(project, user, c)
+}
+
+// identityUser returns the project user linked to the identity. Unlinked identities are linked to the logged in user
+// of `OAuthLink`, to the active user with the same email if both the provider and the project verified it, or to a
+// newly provisioned user.
+func (s *Sessions) identityUser(projectID string, provider *models.IdentityProvider, identity *auth.ExternalIdentity, linkUserID string) (*models.ProjectUser, *apierror.Error) {
+	linked, err := s.store.GetUserIdentity(projectID, provider.Name, identity.Subject)
+	if err != nil {
+		log.Println(err)
+		return nil, apierror.New(http.StatusInternalServerError, "failed to load identity")
+	}
+	if linked != nil {
+		user, err := s.store.GetUserByID(projectID, linked.UserID)
+		if err == nil {
+			if linkUserID != "" && user.ID != linkUserID {
+				return nil, apierror.New(http.StatusConflict, "identity is linked to another user")
+			}
+			return user, nil
+		}
+		// the user has been deleted, link the identity again
+		s.store.DeleteUserIdentity(projectID, linked.ID)
+	}
+
+	link := &models.UserIdentity{
+		Provider: provider.Name,
+		Subject:  identity.Subject,
+	}
+	if identity.EmailVerified {
+		link.Email = identity.Email
+	}
+
+	if linkUserID != "" {
+		user, err := s.store.GetUserByID(projectID, linkUserID)
+		if err != nil {
+			return nil, apierror.New(http.StatusNotFound, "user does not exist")
+		}
+		return s.linkIdentity(projectID, link, user)
+	}
+
+	// only emails verified by both the provider and the project are trusted, otherwise anybody could take over a user
+	// by registering their email with the provider or the project
+	if link.Email != "" {
+		if user, err := s.store.GetUserByEmail(projectID, link.Email); err == nil {
+			if !user.EmailVerified || !user.Active {
+				return nil, apierror.New(http.StatusConflict, "a user with this email exists, log in and link the identity to the user")
+			}
+			return s.linkIdentity(projectID, link, user)
+		}
+	}
+
+	if !provider.Provision {
+		return nil, apierror.New(http.StatusForbidden, "no user is linked to this identity")
+	}
+
+	user, aErr := s.provisionUser(projectID, provider, identity, link.Email)
+	if aErr != nil {
+		return nil, aErr
+	}
+
+	return s.linkIdentity(projectID, link, user)
+}
+
+// linkIdentity links the identity to the user
+func (s *Sessions) linkIdentity(projectID string, link *models.UserIdentity, user *models.ProjectUser) (*models.ProjectUser, *apierror.Error) {
+	link.UserID = user.ID
+	if err := s.store.CreateUserIdentity(projectID, link); err != nil {
+		log.Println(err)
+		return nil, apierror.New(http.StatusInternalServerError, "failed to link identity")
+	}
+
+	return user, nil
+}
+
+// provisionUser creates a project user for the identity. The password is random, the user can set one with a password
+// reset if they have an email.
+func (s *Sessions) provisionUser(projectID string, provider *models.IdentityProvider, identity *auth.ExternalIdentity, email string) (*models.ProjectUser, *apierror.Error) {
+	base := identity.Username
+	if base == "" && email != "" {
+		base = strings.SplitN(email, "@", 2)[0]
+	}
+	base = usernameCharacters.ReplaceAllString(base, "")
+	if base == "" {
+		base = provider.Name + "-" + identity.Subject
+	}
+
+	// find an unused username
+	username := base
+	for i := 0; ; i++ {
+		if _, err := s.store.GetUserByUsername(projectID, username); err != nil {
+			break
+		}
+		if i == 5 {
+			return nil, apierror.New(http.StatusConflict, "failed to find an unused username")
+		}
+		suffix, _ := auth.RandomToken(3)
+		username = base + "-" + strings.ToLower(usernameCharacters.ReplaceAllString(suffix, ""))
+	}
+
+	password, err := auth.RandomToken(32)
+	if err != nil {
+		return nil, apierror.New(http.StatusInternalServerError, "failed to create user")
+	}
+	passwordHash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, apierror.New(http.StatusInternalServerError, "failed to create user")
+	}
+
+	user := &models.ProjectUser{
+		Created:      time.Now(),
+		PasswordHash: passwordHash,
+		Username:     username,
+		Email:        email,
+		Read:         provider.Read,
+		Write:        provider.Write,
+		Role:         auth.RoleUser,
+		Active:       true,
+	}
+	if err := s.store.CreateUser(projectID, user); err != nil {
+		log.Println(err)
+		return nil, apierror.New(http.StatusInternalServerError, "failed to create user")
+	}
+
+	return user, nil
+}
@@ -0,0 +1,114 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/auth"
+	"github.com/machinable/machinable/config"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityUser(t *testing.T) {
+	provider := &models.IdentityProvider{Name: "google"}
+	identity := &auth.ExternalIdentity{Subject: "subject", Email: "ada@example.com", EmailVerified: true}
+
+	tables := []struct {
+		name       string
+		user       *models.ProjectUser
+		identity   *models.UserIdentity
+		linkUserID string
+		statusCode int
+		userID     string
+	}{
+		{
+			name:   "verified email",
+			user:   &models.ProjectUser{ID: "user-1", Email: "ada@example.com", EmailVerified: true, Active: true},
+			userID: "user-1",
+		},
+		{
+			name:       "unverified email",
+			user:       &models.ProjectUser{ID: "user-1", Email: "ada@example.com", Active: true},
+			statusCode: http.StatusConflict,
+		},
+		{
+			name:       "inactive user",
+			user:       &models.ProjectUser{ID: "user-1", Email: "ada@example.com", EmailVerified: true},
+			statusCode: http.StatusConflict,
+		},
+		{
+			name:       "explicit link",
+			user:       &models.ProjectUser{ID: "user-1", Email: "ada@example.com", Active: true},
+			linkUserID: "user-1",
+			userID:     "user-1",
+		},
+		{
+			name:       "linked to another user",
+			user:       &models.ProjectUser{ID: "user-1", Email: "ada@example.com", Active: true},
+			identity:   &models.UserIdentity{Provider: "google", Subject: "subject", UserID: "user-1"},
+			linkUserID: "user-2",
+			statusCode: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(tt.user, &models.ProjectUser{ID: "user-2", Email: "grace@example.com", Active: true})
+			if tt.identity != nil {
+				store.identities = append(store.identities, tt.identity)
+			}
+			handler := New(store, nil, &config.AppConfig{})
+
+			user, aErr := handler.identityUser("project", provider, identity, tt.linkUserID)
+			if tt.statusCode != 0 {
+				assert.NotNil(t, aErr)
+				assert.Equal(t, tt.statusCode, aErr.Status)
+				// the identity is not linked to another user
+				for _, linked := range store.identities {
+					assert.Equal(t, tt.user.ID, linked.UserID)
+				}
+				if tt.identity == nil {
+					assert.Empty(t, store.identities)
+				}
+				return
+			}
+
+			assert.Nil(t, aErr)
+			assert.Equal(t, tt.userID, user.ID)
+			assert.Len(t, store.identities, 1)
+			assert.Equal(t, tt.userID, store.identities[0].UserID)
+			assert.Equal(t, "ada@example.com", store.identities[0].Email)
+		})
+	}
+}
+
+func TestOAuthCallbackURL(t *testing.T) {
+	handler := New(newTestStore(), nil, &config.AppConfig{TrustedProxies: []string{"192.168.0.0/24"}})
+
+	tables := []struct {
+		name       string
+		remoteAddr string
+		proto      string
+		url        string
+	}{
+		{"direct", "203.0.113.5:4000", "", "http://blog.machinable.io/sessions/oauth/google/callback"},
+		{"forged proto", "203.0.113.5:4000", "https", "http://blog.machinable.io/sessions/oauth/google/callback"},
+		{"trusted proxy", "192.168.0.10:4000", "https", "https://blog.machinable.io/sessions/oauth/google/callback"},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request, _ = http.NewRequest(http.MethodGet, "http://blog.machinable.io/sessions/oauth/google", nil)
+			c.Request.RemoteAddr = tt.remoteAddr
+			if tt.proto != "" {
+				c.Request.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+
+			assert.Equal(t, tt.url, handler.oauthCallbackURL(c, "google"))
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package sessions
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
+	"github.com/machinable/machinable/auth"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// ListIdentityProviders lists the identity providers of the project, client secrets are not returned
+func (s *Sessions) ListIdentityProviders(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+
+	providers, err := s.store.ListIdentityProviders(projectID)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, provider := range providers {
+		provider.ClientSecret = ""
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": providers})
+}
+
+// GetIdentityProvider returns a single identity provider of the project
+func (s *Sessions) GetIdentityProvider(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+
+	provider, err := s.store.GetIdentityProvider(projectID, c.Param("providerName"))
+	if err != nil {
+		apierror.Respond(c, http.StatusNotFound, "identity provider not found")
+		return
+	}
+	provider.ClientSecret = ""
+
+	c.JSON(http.StatusOK, provider)
+}
+
+// SaveIdentityProvider creates or replaces an identity provider of the project. The endpoints of OpenID Connect
+// providers are discovered from the issuer, the client secret is kept if it is omitted.
+func (s *Sessions) SaveIdentityProvider(c *gin.Context) {
+	var provider models.IdentityProvider
+	projectID := c.MustGet("projectId").(string)
+
+	c.BindJSON(&provider)
+	provider.Name = c.Param("providerName")
+
+	if provider.ClientSecret == "" {
+		if existing, err := s.store.GetIdentityProvider(projectID, provider.Name); err == nil {
+			provider.ClientSecret = existing.ClientSecret
+		}
+	}
+
+	provider.ApplyPreset()
+	if provider.OIDC() && provider.Issuer != "" && (provider.AuthorizationURL == "" || provider.TokenURL == "") {
+		if err := auth.DiscoverOIDC(&provider); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := provider.Validate(); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.store.SaveIdentityProvider(projectID, &provider); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	provider.ClientSecret = ""
+
+	c.JSON(http.StatusOK, provider)
+}
+
+// DeleteIdentityProvider deletes an identity provider of the project, users can no longer log in with it
+func (s *Sessions) DeleteIdentityProvider(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+
+	if err := s.store.DeleteIdentityProvider(projectID, c.Param("providerName")); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
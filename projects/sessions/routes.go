@@ -2,15 +2,16 @@ package sessions
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/middleware"
 )
 
 // SetRoutes sets all of the appropriate routes to handlers for project sessions
-func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, config *config.AppConfig) error {
+func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, cache redis.UniversalClient, config *config.AppConfig) error {
 	// create new Resources handler with datastore
-	handler := New(datastore, config)
+	handler := New(datastore, cache, config)
 
 	// sessions have a mixed authz policy so there is a route here and at /mgmt/sessions
 	sessions := engine.Group("/sessions")
@@ -18,14 +19,33 @@ func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, config *confi
 	sessions.DELETE("/:sessionID", handler.RevokeSession) // delete this user's session TODO: AUTH
 	sessions.POST("/refresh", middleware.ValidateRefreshToken(datastore, config), handler.RefreshSession)
 
+	// login with the project's external identity providers
+	oauth := engine.Group("/sessions/oauth")
+	oauth.Use(middleware.ProjectIDAuthzMiddleware(datastore))
+	oauth.GET("/:provider/authorize", handler.OAuthAuthorize) // redirect to the identity provider
+	oauth.GET("/:provider/callback", handler.OAuthCallback)   // complete the login and create a new session
+	oauth.POST("/:provider/token", handler.OAuthToken)        // exchange the application's login code for the session
+
+	// link an identity to the logged in user
+	oauth.POST("/:provider/link", middleware.ProjectRequesterMiddleware(datastore, config), handler.OAuthLink)
+
+	// public keys of the project's tokens
+	engine.GET("/.well-known/jwks.json", middleware.ProjectIDAuthzMiddleware(datastore), handler.JWKS)
+
 	// App mgmt routes with different authz policy
 	mgmt := engine.Group("/mgmt")
-	mgmt.Use(middleware.AppUserJwtAuthzMiddleware(config))
+	mgmt.Use(middleware.AppUserJwtAuthzMiddleware(datastore, config))
 	mgmt.Use(middleware.AppUserProjectAuthzMiddleware(datastore, config))
 
 	mgmtSessions := mgmt.Group("/sessions")
 	mgmtSessions.GET("/", handler.ListSessions)
 	mgmtSessions.DELETE("/:sessionID", handler.RevokeSession)
 
+	mgmtProviders := mgmt.Group("/providers")
+	mgmtProviders.GET("/", handler.ListIdentityProviders)
+	mgmtProviders.GET("/:providerName", handler.GetIdentityProvider)
+	mgmtProviders.PUT("/:providerName", handler.SaveIdentityProvider)
+	mgmtProviders.DELETE("/:providerName", handler.DeleteIdentityProvider)
+
 	return nil
 }
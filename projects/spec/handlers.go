@@ -1,14 +1,18 @@
 package spec
 
 import (
+	"bytes"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
+	"github.com/machinable/machinable/dsi/errors"
 	"github.com/machinable/machinable/dsi/interfaces"
 )
 
-// New returns a pointer to a new `Users` struct
-func New(db interfaces.ResourcesDatastore) *Spec {
+// New returns a pointer to a new `Spec` struct
+func New(db interfaces.Datastore) *Spec {
 	return &Spec{
 		store: db,
 	}
@@ -16,30 +20,99 @@ func New(db interfaces.ResourcesDatastore) *Spec {
 
 // Spec wraps the datastore and any HTTP handlers for project openapi spec
 type Spec struct {
-	store interfaces.ResourcesDatastore
+	store interfaces.Datastore
 }
 
 // GetSpec retrieves the openapi spec for the project
 func (s *Spec) GetSpec(c *gin.Context) {
+	spec, err := s.projectSpec(c)
+	if err != nil {
+		apierror.RespondError(c, apierror.FromDatastore(err))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"spec": spec})
+}
+
+// GetSDK generates a typed client of the project spec in the requested language, returned as a zip archive
+func (s *Spec) GetSDK(c *gin.Context) {
+	language := c.Param("language")
+	if _, ok := sdkLanguages[language]; !ok {
+		apierror.Respond(c, http.StatusBadRequest, fmt.Sprintf("unsupported SDK language '%s'", language))
+		return
+	}
+
+	spec, dsiErr := s.projectSpec(c)
+	if dsiErr != nil {
+		apierror.RespondError(c, apierror.FromDatastore(dsiErr))
+		return
+	}
+
+	projectPath := c.MustGet("projectPath").(string)
+	files, err := generateSDK(spec, projectPath, language)
+	if pErr, ok := err.(*sdkPropertyError); ok {
+		apierror.Respond(c, http.StatusBadRequest, pErr.Error())
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	root := fmt.Sprintf("%s-%s-sdk", projectPath, language)
+	var archive bytes.Buffer
+	if err := writeSDKArchive(&archive, root, files); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to create SDK archive")
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.zip", root))
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+// projectSpec builds the openapi spec of the project's resources, as seen by the `role` query parameter if set
+func (s *Spec) projectSpec(c *gin.Context) (*ProjectSpec, *errors.DatastoreError) {
 	projectID := c.MustGet("projectId").(string)
 	projectName := c.MustGet("projectName").(string)
 	projectPath := c.MustGet("projectPath").(string)
 	projectIcon := c.MustGet("projectIcon").(string)
 	projectDescription := c.MustGet("projectDescription").(string)
+	userRegistration := c.GetBool("projectUserRegistration")
 
 	resources, err := s.store.ListDefinitions(projectID)
-
 	if err != nil {
-		c.JSON(err.Code(), gin.H{"error": err.Error()})
-		return
+		return nil, err
+	}
+
+	rootKeys, rErr := s.store.ListRootKeys(projectID)
+	if rErr != nil {
+		return nil, errors.New(errors.UnknownError, rErr)
+	}
+
+	providers, pErr := s.store.ListIdentityProviders(projectID)
+	if pErr != nil {
+		return nil, errors.New(errors.UnknownError, pErr)
+	}
+
+	// `?role=` documents the properties the role can read and write
+	if role := c.Query("role"); role != "" {
+		for i, resource := range resources {
+			resources[i] = resource.RoleView(role)
+		}
 	}
 
 	spec := baseSpec(projectPath)
 
 	injectProjectSchema(spec, resources)
+	injectJSONTrees(spec, rootKeys)
+	injectUserFlows(spec)
+	injectOAuthLogin(spec, providers)
+	if userRegistration {
+		injectUserRegistration(spec)
+	}
 	spec.Info.Title = projectName
 	spec.Info.Description = projectDescription
 	spec.Info.XLogo.URL = projectIcon
 
-	c.IndentedJSON(http.StatusOK, gin.H{"spec": spec})
+	return spec, nil
 }
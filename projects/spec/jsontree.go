@@ -0,0 +1,240 @@
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/machinable/machinable/dsi/models"
+)
+
+const jsonTreesTag = "JSON Trees"
+
+// childQueryParameters list, order and filter the children of a JSON tree node
+var childQueryParameters = []Parameter{
+	{
+		Name:        "orderBy",
+		In:          "query",
+		Description: "Lists the children of the node, ordered by `$key`, `$value` or the slash separated path of a child key.",
+		Schema:      map[string]interface{}{"type": "string"},
+	},
+	{
+		Name:        "startAt",
+		In:          "query",
+		Description: "Only return children with an order value greater than or equal to this JSON value.",
+		Schema:      map[string]interface{}{"type": "string"},
+	},
+	{
+		Name:        "endAt",
+		In:          "query",
+		Description: "Only return children with an order value less than or equal to this JSON value.",
+		Schema:      map[string]interface{}{"type": "string"},
+	},
+	{
+		Name:        "limitToFirst",
+		In:          "query",
+		Description: "Only return the first children.",
+		Schema:      map[string]interface{}{"type": "integer", "minimum": 1},
+	},
+	{
+		Name:        "limitToLast",
+		In:          "query",
+		Description: "Only return the last children.",
+		Schema:      map[string]interface{}{"type": "integer", "minimum": 1},
+	},
+	{
+		Name:        "shallow",
+		In:          "query",
+		Description: "Only return the keys of the children.",
+		Schema:      map[string]interface{}{"type": "boolean"},
+	},
+}
+
+// keysParameter is the key path below the root key
+var keysParameter = Parameter{
+	Name:        "keys",
+	In:          "path",
+	Description: "Slash separated key path of the node, array elements are addressed by index, i.e. `posts/0/title`.",
+	Required:    true,
+	Schema:      map[string]interface{}{"type": "string"},
+}
+
+// injectJSONTrees documents the JSON tree routes of each root key of the project
+func injectJSONTrees(spec *ProjectSpec, rootKeys []*models.RootKey) {
+	if len(rootKeys) == 0 {
+		return
+	}
+
+	spec.Tags = append(spec.Tags, Tag{
+		Name:        jsonTreesTag,
+		Description: "JSON documents which are read and written at any key path",
+	})
+	spec.XTagGroups[1].Tags = append(spec.XTagGroups[1].Tags, jsonTreesTag)
+
+	spec.Components.Schemas["JSONChildren"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"items": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"key": map[string]interface{}{
+							"description": "Key of the child, the index of array elements",
+						},
+						"value": map[string]interface{}{
+							"description": "Value of the child, left out of shallow queries",
+						},
+					},
+				},
+			},
+		},
+	}
+	spec.Components.Schemas["JSONOperation"] = map[string]interface{}{
+		"type":        "object",
+		"description": "Atomic operation on the value at the key path. The \"before\" hooks of `edit` receive the value at the key path after the operation, the value they return is written.",
+		"required":    []string{"op", "value"},
+		"properties": map[string]interface{}{
+			"op": map[string]interface{}{
+				"type": "string",
+				"enum": []string{
+					models.JSONOpIncrement,
+					models.JSONOpDecrement,
+					models.JSONOpAppend,
+					models.JSONOpRemove,
+					models.JSONOpCompareAndSet,
+					models.JSONOpSetIfAbsent,
+				},
+			},
+			"value": map[string]interface{}{
+				"description": "Operand of the operation",
+			},
+			"expected": map[string]interface{}{
+				"description": "Current value required by `compare_and_set`",
+			},
+		},
+	}
+
+	for _, rootKey := range rootKeys {
+		injectJSONTree(spec, rootKey)
+	}
+}
+
+func injectJSONTree(spec *ProjectSpec, rootKey *models.RootKey) {
+	name := fmt.Sprintf("JSONTree-%s", rootKey.Key)
+
+	tree := map[string]interface{}{}
+	if len(rootKey.Schema) > 0 {
+		json.Unmarshal(rootKey.Schema, &tree)
+	}
+	spec.Components.Schemas[name] = tree
+
+	// path scoped rules replace the access flags of the tree
+	security := func(requiresAuthn bool) []map[string][]interface{} {
+		return accessSecurity(requiresAuthn && len(rootKey.Rules) == 0)
+	}
+	description := ""
+	if len(rootKey.Rules) > 0 {
+		description = "Access is determined by the path scoped rules of the tree."
+	}
+
+	value := map[string]interface{}{"description": "JSON value at the key path"}
+	read := map[string]interface{}{
+		"anyOf": []interface{}{value, schemaRef("JSONChildren")},
+	}
+
+	spec.Paths[fmt.Sprintf("/json/%s/", rootKey.Key)] = map[string]Verb{
+		"get": {
+			Tags:        []string{jsonTreesTag},
+			Summary:     fmt.Sprintf("Read the %s tree", rootKey.Key),
+			Description: description,
+			OperationID: fmt.Sprintf("Read%s", name),
+			Security:    security(rootKey.Read),
+			Parameters:  childQueryParameters,
+			Responses: withErrorResponses(map[string]interface{}{
+				"200": jsonResponse("Tree retrieved successfully", map[string]interface{}{
+					"anyOf": []interface{}{schemaRef(name), schemaRef("JSONChildren")},
+				}),
+			}, 400, 401, 403, 404, 429, 500),
+		},
+		"put": {
+			Tags:        []string{jsonTreesTag},
+			Summary:     fmt.Sprintf("Replace the %s tree", rootKey.Key),
+			Description: description,
+			OperationID: fmt.Sprintf("Replace%s", name),
+			Security:    security(rootKey.Update),
+			Parameters:  []Parameter{triggerHooksParameter},
+			RequestBody: jsonRequestBody(schemaRef(name)),
+			Responses: withErrorResponses(map[string]interface{}{
+				"201": jsonResponse("Tree replaced successfully", schemaRef(name)),
+			}, 400, 401, 403, 404, 429, 500),
+		},
+	}
+
+	spec.Paths[fmt.Sprintf("/json/%s/{keys}", rootKey.Key)] = map[string]Verb{
+		"get": {
+			Tags:        []string{jsonTreesTag},
+			Summary:     fmt.Sprintf("Read a key of the %s tree", rootKey.Key),
+			Description: description,
+			OperationID: fmt.Sprintf("Read%sKey", name),
+			Security:    security(rootKey.Read),
+			Parameters:  append([]Parameter{keysParameter}, childQueryParameters...),
+			Responses: withErrorResponses(map[string]interface{}{
+				"200": jsonResponse("Key retrieved successfully", read),
+			}, 400, 401, 403, 404, 429, 500),
+		},
+		"post": {
+			Tags:        []string{jsonTreesTag},
+			Summary:     fmt.Sprintf("Create a key of the %s tree", rootKey.Key),
+			Description: joinDescription(description, "The key must not exist."),
+			OperationID: fmt.Sprintf("Create%sKey", name),
+			Security:    security(rootKey.Create),
+			Parameters:  []Parameter{keysParameter, triggerHooksParameter},
+			RequestBody: jsonRequestBody(value),
+			Responses: withErrorResponses(map[string]interface{}{
+				"201": jsonResponse("Key created successfully", value),
+			}, 400, 401, 403, 404, 409, 429, 500),
+		},
+		"put": {
+			Tags:        []string{jsonTreesTag},
+			Summary:     fmt.Sprintf("Update a key of the %s tree", rootKey.Key),
+			Description: joinDescription(description, "The key is created if it does not exist."),
+			OperationID: fmt.Sprintf("Update%sKey", name),
+			Security:    security(rootKey.Update),
+			Parameters:  []Parameter{keysParameter, triggerHooksParameter},
+			RequestBody: jsonRequestBody(value),
+			Responses: withErrorResponses(map[string]interface{}{
+				"201": jsonResponse("Key updated successfully", value),
+			}, 400, 401, 403, 404, 429, 500),
+		},
+		"patch": {
+			Tags:        []string{jsonTreesTag},
+			Summary:     fmt.Sprintf("Apply an atomic operation to a key of the %s tree", rootKey.Key),
+			Description: description,
+			OperationID: fmt.Sprintf("Apply%sOperation", name),
+			Security:    security(rootKey.Update),
+			Parameters:  []Parameter{keysParameter, triggerHooksParameter},
+			RequestBody: jsonRequestBody(schemaRef("JSONOperation")),
+			Responses: withErrorResponses(map[string]interface{}{
+				"200": jsonResponse("Operation applied successfully, returns the new value", value),
+			}, 400, 401, 403, 404, 409, 429, 500),
+		},
+		"delete": {
+			Tags:        []string{jsonTreesTag},
+			Summary:     fmt.Sprintf("Delete a key of the %s tree", rootKey.Key),
+			Description: description,
+			OperationID: fmt.Sprintf("Delete%sKey", name),
+			Security:    security(rootKey.Delete),
+			Parameters:  []Parameter{keysParameter, triggerHooksParameter},
+			Responses: withErrorResponses(map[string]interface{}{
+				"201": jsonResponse("Key deleted successfully", map[string]interface{}{"type": "object"}),
+			}, 400, 401, 403, 404, 429, 500),
+		},
+	}
+}
+
+func joinDescription(description, extra string) string {
+	if description == "" {
+		return extra
+	}
+	return description + " " + extra
+}
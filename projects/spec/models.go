@@ -21,18 +21,28 @@ func injectProjectSchema(spec *ProjectSpec, resources []*models.ResourceDefiniti
 	}
 }
 
+// componentName returns the name of the resource's component schemas, titles may contain characters which are not
+// allowed in component names
+func componentName(title, pathName string) string {
+	name := invalidComponentChars.ReplaceAllString(title, "")
+	if name == "" {
+		return pathName
+	}
+	return name
+}
+
 func injectComponents(spec *ProjectSpec, resource *models.ResourceDefinition) {
 	schema, _ := resource.GetSchemaMap()
-	// TODO: Title could have spaces?
-	spec.Components.Schemas[resource.Title] = schema
+	name := componentName(resource.Title, resource.PathName)
+	spec.Components.Schemas[name] = schema
 
-	spec.Components.Schemas[fmt.Sprintf("%sRecord", resource.Title)] = map[string][]map[string]interface{}{
+	spec.Components.Schemas[fmt.Sprintf("%sRecord", name)] = map[string][]map[string]interface{}{
 		"allOf": []map[string]interface{}{
 			map[string]interface{}{"$ref": "#/components/schemas/MetaData"},
 			schema,
 		},
 	}
-	spec.Components.Responses[fmt.Sprintf("%sList", resource.Title)] = map[string]interface{}{
+	spec.Components.Schemas[fmt.Sprintf("%sList", name)] = map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
 			"count": map[string]interface{}{
@@ -55,200 +65,171 @@ func injectComponents(spec *ProjectSpec, resource *models.ResourceDefinition) {
 				},
 			},
 			"items": map[string]interface{}{
-				"type": "array",
-				"items": map[string]interface{}{
-					"$ref": fmt.Sprintf("#/components/schemas/%sRecord", resource.Title),
-				},
+				"type":  "array",
+				"items": schemaRef(fmt.Sprintf("%sRecord", name)),
 			},
 		},
 	}
 }
 
 func injectPaths(spec *ProjectSpec, resource *models.ResourceDefinition) {
-	componentLink := fmt.Sprintf("#/components/schemas/%s", resource.Title)
-	componentRecordLink := fmt.Sprintf("#/components/schemas/%sRecord", resource.Title)
-	listLink := fmt.Sprintf("#/components/responses/%sList", resource.Title)
+	name := componentName(resource.Title, resource.PathName)
+	component := schemaRef(name)
+	record := schemaRef(fmt.Sprintf("%sRecord", name))
+	schema, _ := resource.GetSchema()
+	if schema == nil {
+		schema = &models.JSONSchemaObject{}
+	}
+
+	idParameter := Parameter{
+		Name:        "id",
+		In:          "path",
+		Description: fmt.Sprintf("ID of the %s document", resource.Title),
+		Required:    true,
+		Schema:      map[string]interface{}{"type": "string"},
+	}
+	relationParameters := relationParameters(schema)
+
 	paths := map[string]map[string]Verb{
-		fmt.Sprintf("/api/%s/{%sId}", resource.PathName, resource.PathName): {
+		fmt.Sprintf("/api/%s/{id}", resource.PathName): {
 			"get": {
 				Tags:        []string{resource.Title},
 				Summary:     fmt.Sprintf("Get %s", resource.Title),
-				OperationID: fmt.Sprintf("Get%s", resource.Title),
-				Security: []map[string][]interface{}{
-					{
-						"JWT": []interface{}{},
-					},
-				},
-				Responses: map[string]interface{}{
-					"200": map[string]interface{}{
-						"description": "Resource retrieved successfully",
-						"headers":     map[string]interface{}{},
-						"content": map[string]interface{}{
-							"application/json": map[string]interface{}{
-								"schema": map[string]interface{}{
-									"$ref": componentRecordLink,
-								},
-							},
-						},
-					},
-					"400": map[string]interface{}{
-						"$ref": "#/components/responses/BadRequest",
-					},
-					"401": map[string]interface{}{
-						"$ref": "#/components/responses/UnauthorizedError",
-					},
-					"404": map[string]interface{}{
-						"$ref": "#/components/responses/NotFound",
-					},
-				},
+				OperationID: fmt.Sprintf("Get%s", name),
+				Security:    accessSecurity(resource.Read),
+				Parameters:  append([]Parameter{idParameter}, relationParameters...),
+				Responses: withErrorResponses(map[string]interface{}{
+					"200": jsonResponse("Resource retrieved successfully", record),
+				}, 400, 401, 403, 404, 429, 500),
 			},
 			"put": {
 				Tags:        []string{resource.Title},
 				Summary:     fmt.Sprintf("Update %s", resource.Title),
-				OperationID: fmt.Sprintf("Update%s", resource.Title),
-				Security: []map[string][]interface{}{
-					{
-						"JWT": []interface{}{},
-					},
-				},
-				RequestBody: map[string]interface{}{
-					"content": map[string]interface{}{
-						"application/json": map[string]interface{}{
-							"schema": map[string]interface{}{
-								"$ref": componentLink,
-							},
-						},
-					},
-				},
-				Responses: map[string]interface{}{
-					"200": map[string]interface{}{
-						"description": "Resource created successfully",
-						"headers":     map[string]interface{}{},
-						"content": map[string]interface{}{
-							"application/json": map[string]interface{}{
-								"schema": map[string]interface{}{
-									"$ref": componentRecordLink,
-								},
-							},
-						},
-					},
-					"400": map[string]interface{}{
-						"$ref": "#/components/responses/BadRequest",
-					},
-					"401": map[string]interface{}{
-						"$ref": "#/components/responses/UnauthorizedError",
-					},
-					"404": map[string]interface{}{
-						"$ref": "#/components/responses/NotFound",
-					},
-				},
+				OperationID: fmt.Sprintf("Update%s", name),
+				Security:    accessSecurity(resource.Update),
+				Parameters:  []Parameter{idParameter, triggerHooksParameter},
+				RequestBody: jsonRequestBody(component),
+				Responses: withErrorResponses(map[string]interface{}{
+					"200": jsonResponse("Resource updated successfully", record),
+				}, 400, 401, 403, 404, 429, 500),
 			},
 			"delete": {
 				Tags:        []string{resource.Title},
 				Summary:     fmt.Sprintf("Delete %s", resource.Title),
-				OperationID: fmt.Sprintf("Delete%s", resource.Title),
-				Security:    []map[string][]interface{}{},
-				Responses: map[string]interface{}{
-					"204": map[string]interface{}{
-						"description": "Resource was deleted successfully",
-						"headers":     map[string]interface{}{},
-						"content": map[string]interface{}{
-							"application/json": map[string]interface{}{
-								"schema": map[string]interface{}{
-									"type": "object",
-								},
-							},
-						},
-					},
-					"401": map[string]interface{}{
-						"$ref": "#/components/responses/UnauthorizedError",
-					},
-					"404": map[string]interface{}{
-						"$ref": "#/components/responses/NotFound",
-					},
-				},
+				OperationID: fmt.Sprintf("Delete%s", name),
+				Security:    accessSecurity(resource.Delete),
+				Parameters:  []Parameter{idParameter, triggerHooksParameter},
+				Responses: withErrorResponses(map[string]interface{}{
+					"204": emptyResponse("Resource was deleted successfully"),
+				}, 400, 401, 403, 404, 429, 500),
 			},
 		},
 		fmt.Sprintf("/api/%s", resource.PathName): {
 			"get": {
 				Tags:        []string{resource.Title},
 				Summary:     fmt.Sprintf("List %s", resource.Title),
-				OperationID: fmt.Sprintf("List%s", resource.Title),
-				Security: []map[string][]interface{}{
-					{
-						"JWT": []interface{}{},
-					},
-				},
-				Responses: map[string]interface{}{
-					"200": map[string]interface{}{
-						"description": "Resource list retrieved successfully",
-						"headers":     map[string]interface{}{},
-						"content": map[string]interface{}{
-							"application/json": map[string]interface{}{
-								"schema": map[string]interface{}{
-									"$ref": listLink,
-								},
-							},
-						},
-					},
-					"400": map[string]interface{}{
-						"$ref": "#/components/responses/BadRequest",
-					},
-					"401": map[string]interface{}{
-						"$ref": "#/components/responses/UnauthorizedError",
-					},
-					"404": map[string]interface{}{
-						"$ref": "#/components/responses/NotFound",
-					},
-				},
+				OperationID: fmt.Sprintf("List%s", name),
+				Security:    accessSecurity(resource.Read),
+				Parameters:  listParameters(schema),
+				Responses: withErrorResponses(map[string]interface{}{
+					"200": jsonResponse("Resource list retrieved successfully", schemaRef(fmt.Sprintf("%sList", name))),
+				}, 400, 401, 403, 404, 429, 500),
 			},
 			"post": {
 				Tags:        []string{resource.Title},
 				Summary:     fmt.Sprintf("Create %s", resource.Title),
-				OperationID: fmt.Sprintf("Create%s", resource.Title),
-				Security: []map[string][]interface{}{
-					{
-						"JWT": []interface{}{},
-					},
-				},
-				RequestBody: map[string]interface{}{
-					"content": map[string]interface{}{
-						"application/json": map[string]interface{}{
-							"schema": map[string]interface{}{
-								"$ref": componentLink,
-							},
-						},
-					},
-				},
-				Responses: map[string]interface{}{
-					"200": map[string]interface{}{
-						"description": "Resource created successfully",
-						"headers":     map[string]interface{}{},
-						"content": map[string]interface{}{
-							"application/json": map[string]interface{}{
-								"schema": map[string]interface{}{
-									"$ref": componentRecordLink,
-								},
-							},
-						},
-					},
-					"400": map[string]interface{}{
-						"$ref": "#/components/responses/BadRequest",
-					},
-					"401": map[string]interface{}{
-						"$ref": "#/components/responses/UnauthorizedError",
-					},
-					"404": map[string]interface{}{
-						"$ref": "#/components/responses/NotFound",
-					},
-				},
+				OperationID: fmt.Sprintf("Create%s", name),
+				Security:    accessSecurity(resource.Create),
+				Parameters:  []Parameter{triggerHooksParameter},
+				RequestBody: jsonRequestBody(component),
+				Responses: withErrorResponses(map[string]interface{}{
+					"201": jsonResponse("Resource created successfully", record),
+				}, 400, 401, 403, 404, 429, 500),
 			},
 		},
 	}
 
+	// documents are shared by their creator, the requester must be able to read or update the document
+	aclParameters := []Parameter{
+		idParameter,
+		{
+			Name:        "principalType",
+			In:          "path",
+			Description: "Type of the principal the document is shared with",
+			Required:    true,
+			Schema:      map[string]interface{}{"type": "string", "enum": []string{models.PrincipalUser, models.PrincipalGroup}},
+		},
+		{
+			Name:        "principalId",
+			In:          "path",
+			Description: "ID of the user or name of the group",
+			Required:    true,
+			Schema:      map[string]interface{}{"type": "string"},
+		},
+	}
+	paths[fmt.Sprintf("/api/%s/{id}/acl", resource.PathName)] = map[string]Verb{
+		"get": {
+			Tags:        []string{resource.Title},
+			Summary:     fmt.Sprintf("List %s access", resource.Title),
+			OperationID: fmt.Sprintf("List%sAccess", name),
+			Security:    accessSecurity(true),
+			Parameters:  []Parameter{idParameter},
+			Responses: withErrorResponses(map[string]interface{}{
+				"200": jsonResponse("Document access retrieved successfully", map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"items": map[string]interface{}{"type": "array", "items": schemaRef("DocumentACL")}},
+				}),
+			}, 401, 403, 404, 429, 500),
+		},
+	}
+	paths[fmt.Sprintf("/api/%s/{id}/acl/{principalType}/{principalId}", resource.PathName)] = map[string]Verb{
+		"put": {
+			Tags:        []string{resource.Title},
+			Summary:     fmt.Sprintf("Share %s", resource.Title),
+			OperationID: fmt.Sprintf("Grant%sAccess", name),
+			Security:    accessSecurity(true),
+			Parameters:  aclParameters,
+			RequestBody: jsonRequestBody(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"read":  map[string]interface{}{"type": "boolean"},
+					"write": map[string]interface{}{"type": "boolean", "description": "Write access includes read access"},
+				},
+			}),
+			Responses: withErrorResponses(map[string]interface{}{
+				"200": jsonResponse("Document access granted successfully", schemaRef("DocumentACL")),
+			}, 400, 401, 403, 404, 429, 500),
+		},
+		"delete": {
+			Tags:        []string{resource.Title},
+			Summary:     fmt.Sprintf("Stop sharing %s", resource.Title),
+			OperationID: fmt.Sprintf("Revoke%sAccess", name),
+			Security:    accessSecurity(true),
+			Parameters:  aclParameters,
+			Responses: withErrorResponses(map[string]interface{}{
+				"204": emptyResponse("Document access revoked successfully"),
+			}, 401, 403, 404, 429, 500),
+		},
+	}
+
 	for key, val := range paths {
 		spec.Paths[key] = val
 	}
+	spec.Components.Schemas["DocumentACL"] = documentACLSchema
+}
+
+// documentACLSchema is the component schema of the entries documents are shared with
+var documentACLSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"resource_path":  map[string]interface{}{"type": "string"},
+		"document_id":    map[string]interface{}{"type": "string", "format": "uuid"},
+		"principal_type": map[string]interface{}{"type": "string", "enum": []string{models.PrincipalUser, models.PrincipalGroup}},
+		"principal_id":   map[string]interface{}{"type": "string"},
+		"read":           map[string]interface{}{"type": "boolean"},
+		"write":          map[string]interface{}{"type": "boolean"},
+		"created":        map[string]interface{}{"type": "string", "format": "date-time"},
+	},
 }
 
 func baseSpec(projectPath string) *ProjectSpec {
@@ -301,7 +282,7 @@ func baseSpec(projectPath string) *ProjectSpec {
 			},
 		},
 		Paths: map[string]map[string]Verb{
-			"/sessions": {
+			"/sessions/": {
 				"post": Verb{
 					Tags:        []string{"JWT Session"},
 					Summary:     "Create new session",
@@ -321,11 +302,11 @@ func baseSpec(projectPath string) *ProjectSpec {
 										"type": "object",
 										"properties": map[string]interface{}{
 											"access_token": map[string]interface{}{
-												"description": "Access token which is used to authenticate future requests. The `access_token` has an expiration of 5 minutes.",
+												"description": "Access token which is used to authenticate future requests. The `access_token` expires after 15 minutes, unless the project configures a different lifetime.",
 												"type":        "string",
 											},
 											"refresh_token": map[string]interface{}{
-												"description": "Refresh token which is used to retrieve a new `access_token`.",
+												"description": "Refresh token which is used once to retrieve a new `access_token` and `refresh_token`.",
 												"type":        "string",
 											},
 											"session_id": map[string]interface{}{
@@ -356,6 +337,7 @@ func baseSpec(projectPath string) *ProjectSpec {
 				"post": Verb{
 					Tags:        []string{"JWT Session"},
 					Summary:     "Refresh current session",
+					Description: "Exchanges the refresh token for a new access token and refresh token. If a refresh token which has already been used is presented, the session is revoked.",
 					OperationID: "RefreshSession",
 					Security: []map[string][]interface{}{
 						{
@@ -372,7 +354,11 @@ func baseSpec(projectPath string) *ProjectSpec {
 										"type": "object",
 										"properties": map[string]interface{}{
 											"access_token": map[string]interface{}{
-												"description": "The new access token which is used to authenticate future requests. The `access_token` has an expiration of 5 minutes.",
+												"description": "The new access token which is used to authenticate future requests.",
+												"type":        "string",
+											},
+											"refresh_token": map[string]interface{}{
+												"description": "The new refresh token of the session. Refresh tokens are single use, using a refresh token again revokes the session.",
 												"type":        "string",
 											},
 										},
@@ -401,35 +387,62 @@ func baseSpec(projectPath string) *ProjectSpec {
 					Summary:     "Delete a session",
 					OperationID: "DeleteSession",
 					Security:    []map[string][]interface{}{},
-					Responses: map[string]interface{}{
-						"204": map[string]interface{}{
-							"description": "Session was deleted successfully",
-							"headers":     map[string]interface{}{},
-							"content": map[string]interface{}{
-								"application/json": map[string]interface{}{
-									"schema": map[string]interface{}{
-										"type": "object",
-									},
-								},
-							},
-						},
-						"401": map[string]interface{}{
-							"$ref": "#/components/responses/UnauthorizedError",
-						},
-						"404": map[string]interface{}{
-							"$ref": "#/components/responses/NotFound",
+					Parameters: []Parameter{
+						{
+							Name:        "sessionId",
+							In:          "path",
+							Description: "The `ID` of the session.",
+							Required:    true,
+							Schema:      map[string]interface{}{"type": "string"},
 						},
 					},
+					Responses: withErrorResponses(map[string]interface{}{
+						"204": emptyResponse("Session was deleted successfully"),
+					}, 401, 404, 500),
 				},
 			},
 		},
 		Components: Components{
 			Schemas: map[string]interface{}{
 				"Error": map[string]interface{}{
-					"type": "object",
+					"type":        "object",
+					"description": "Errors are served as RFC 7807 problem details instead if the request accepts `application/problem+json`.",
+					"required":    []string{"code", "message"},
 					"properties": map[string]interface{}{
+						"code": map[string]interface{}{
+							"description": "Machine readable error code",
+							"type":        "string",
+							"example":     "NOT_FOUND",
+						},
+						"message": map[string]interface{}{
+							"description": "Description of the error",
+							"type":        "string",
+						},
+						"details": map[string]interface{}{
+							"description": "Field level validation errors, if any",
+							"type":        "array",
+							"items": map[string]interface{}{
+								"type":     "object",
+								"required": []string{"message"},
+								"properties": map[string]interface{}{
+									"field": map[string]interface{}{
+										"description": "Slash separated path of the field",
+										"type":        "string",
+									},
+									"message": map[string]interface{}{
+										"type": "string",
+									},
+								},
+							},
+						},
+						"request_id": map[string]interface{}{
+							"description": "Id of the request, also returned as the `X-Request-Id` header",
+							"type":        "string",
+						},
 						"error": map[string]interface{}{
-							"type": "string",
+							"description": "Description of the error, same as `message`",
+							"type":        "string",
+							"deprecated":  true,
 						},
 					},
 				},
@@ -454,6 +467,7 @@ func baseSpec(projectPath string) *ProjectSpec {
 									"enum": []string{
 										"apikey",
 										"user",
+										"anonymous",
 									},
 								},
 								"created": map[string]interface{}{
@@ -497,6 +511,36 @@ func baseSpec(projectPath string) *ProjectSpec {
 						},
 					},
 				},
+				"Forbidden": map[string]interface{}{
+					"description": "The requester is not allowed to perform the operation",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"$ref": "#/components/schemas/Error",
+							},
+						},
+					},
+				},
+				"Conflict": map[string]interface{}{
+					"description": "The operation conflicts with the current value",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"$ref": "#/components/schemas/Error",
+							},
+						},
+					},
+				},
+				"TooManyRequests": map[string]interface{}{
+					"description": "The request count exceeded the account rate limit",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"$ref": "#/components/schemas/Error",
+							},
+						},
+					},
+				},
 				"ServerError": map[string]interface{}{
 					"description": "Unknown server error occurred",
 					"content": map[string]interface{}{
@@ -515,8 +559,8 @@ func baseSpec(projectPath string) *ProjectSpec {
 					Scheme:       "bearer",
 					BearerFormat: "JWT",
 				},
-				"ApiKey": SecurityScheme{
-					Description: "API Keys can be created from the [project dashboard](https://www.machinable.io/documentation/projects/access/#api-keys).\nUsage format: `apikey <API Key>`\n",
+				"APIKey": SecurityScheme{
+					Description: "API Keys can be created from the [project dashboard](https://www.machinable.io/documentation/projects/access/#api-keys).\nUsage format: `apikey <API Key>`, keys have the form `mch_<key id>_<secret>`\n",
 					Name:        "Authorization",
 					Type:        "apiKey",
 					In:          "header",
@@ -572,12 +616,21 @@ type XTagGroup struct {
 type Verb struct {
 	Tags        []string                   `json:"tags"`
 	Summary     string                     `json:"summary"`
+	Description string                     `json:"description,omitempty"`
 	OperationID string                     `json:"operationId"`
 	Security    []map[string][]interface{} `json:"security"`
-	RequestBody map[string]interface{}     `json:"requestBody"`
+	Parameters  []Parameter                `json:"parameters,omitempty"`
+	RequestBody map[string]interface{}     `json:"requestBody,omitempty"`
 	Responses   map[string]interface{}     `json:"responses,omitempty"`
 	CodeSamples []CodeSample               `json:"x-code-samples,omitempty"`
 }
+type Parameter struct {
+	Name        string                 `json:"name"`
+	In          string                 `json:"in"`
+	Description string                 `json:"description,omitempty"`
+	Required    bool                   `json:"required,omitempty"`
+	Schema      map[string]interface{} `json:"schema"`
+}
 type CodeSample struct {
 	Lang   string `json:"lang"`
 	Source string `json:"source"`
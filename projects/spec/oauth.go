@@ -0,0 +1,114 @@
+package spec
+
+import "github.com/machinable/machinable/dsi/models"
+
+// injectOAuthLogin documents the login with the project's external identity providers, if it has any
+func injectOAuthLogin(spec *ProjectSpec, providers []*models.IdentityProvider) {
+	if len(providers) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		names = append(names, provider.Name)
+	}
+	providerParameter := Parameter{
+		Name:        "provider",
+		In:          "path",
+		Description: "The name of the identity provider.",
+		Required:    true,
+		Schema:      map[string]interface{}{"type": "string", "enum": names},
+	}
+	tokens := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"access_token": map[string]interface{}{
+				"type": "string",
+			},
+			"refresh_token": map[string]interface{}{
+				"type": "string",
+			},
+			"session_id": map[string]interface{}{
+				"type": "string",
+			},
+		},
+	}
+
+	spec.Paths["/sessions/oauth/{provider}/authorize"] = map[string]Verb{
+		"get": {
+			Tags:        []string{"JWT Session"},
+			Summary:     "Log in with an identity provider",
+			Description: "Redirects to the identity provider with the authorization code flow and PKCE. If `redirect_url` is set, the application is redirected to with a short lived `code` after the login, which is exchanged for the session tokens. Otherwise the callback responds with the session tokens.",
+			OperationID: "OAuthAuthorize",
+			Security:    []map[string][]interface{}{},
+			Parameters: []Parameter{
+				providerParameter,
+				{
+					Name:        "redirect_url",
+					In:          "query",
+					Description: "An application url allowed by the identity provider configuration.",
+					Schema:      map[string]interface{}{"type": "string", "format": "uri"},
+				},
+				{
+					Name:        "state",
+					In:          "query",
+					Description: "Passed through to the `redirect_url`.",
+					Schema:      map[string]interface{}{"type": "string"},
+				},
+			},
+			Responses: withErrorResponses(map[string]interface{}{
+				"302": emptyResponse("Redirect to the identity provider"),
+			}, 400, 404, 500),
+		},
+	}
+	spec.Paths["/sessions/oauth/{provider}/callback"] = map[string]Verb{
+		"get": {
+			Tags:        []string{"JWT Session"},
+			Summary:     "Complete an identity provider login",
+			Description: "The redirect uri of the identity provider. The identity is linked to the user with the same verified email, or a new user is created if the provider allows it.",
+			OperationID: "OAuthCallback",
+			Security:    []map[string][]interface{}{},
+			Parameters: []Parameter{
+				providerParameter,
+				{
+					Name:     "code",
+					In:       "query",
+					Required: true,
+					Schema:   map[string]interface{}{"type": "string"},
+				},
+				{
+					Name:     "state",
+					In:       "query",
+					Required: true,
+					Schema:   map[string]interface{}{"type": "string"},
+				},
+			},
+			Responses: withErrorResponses(map[string]interface{}{
+				"201": jsonResponse("User logged in successfully", tokens),
+				"302": emptyResponse("Redirect to the application with a login code"),
+			}, 400, 401, 403, 404, 500),
+		},
+	}
+	spec.Paths["/sessions/oauth/{provider}/token"] = map[string]Verb{
+		"post": {
+			Tags:        []string{"JWT Session"},
+			Summary:     "Exchange a login code",
+			Description: "Exchanges the login code of the application redirect for the session tokens. Codes are single use and expire after a minute.",
+			OperationID: "OAuthToken",
+			Security:    []map[string][]interface{}{},
+			Parameters:  []Parameter{providerParameter},
+			RequestBody: jsonRequestBody(map[string]interface{}{
+				"type":     "object",
+				"required": []string{"code"},
+				"properties": map[string]interface{}{
+					"code": map[string]interface{}{
+						"type": "string",
+					},
+				},
+			}),
+			Responses: withErrorResponses(map[string]interface{}{
+				"201": jsonResponse("User logged in successfully", tokens),
+			}, 400, 500),
+		},
+	}
+}
@@ -0,0 +1,170 @@
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/machinable/machinable/dsi"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/query"
+)
+
+// invalidComponentChars are removed from component names, which must match `^[a-zA-Z0-9\.\-_]+$`
+var invalidComponentChars = regexp.MustCompile(`[^a-zA-Z0-9.\-_]`)
+
+// errorResponses maps the status codes of error responses to their components
+var errorResponses = map[int]string{
+	400: "BadRequest",
+	401: "UnauthorizedError",
+	403: "Forbidden",
+	404: "NotFound",
+	409: "Conflict",
+	429: "TooManyRequests",
+	500: "ServerError",
+}
+
+// filterTypes are the property types documents can be filtered on
+var filterTypes = map[string]bool{
+	"string":  true,
+	"integer": true,
+	"number":  true,
+	"boolean": true,
+}
+
+// triggerHooksParameter disables the web hooks of a write
+var triggerHooksParameter = Parameter{
+	Name:        "X-Trigger-Hooks",
+	In:          "header",
+	Description: "Set to `false` to skip the project's web hooks for this write.",
+	Schema:      map[string]interface{}{"type": "string", "enum": []string{"true", "false"}},
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonResponse(description string, schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": schema,
+			},
+		},
+	}
+}
+
+func emptyResponse(description string) map[string]interface{} {
+	return map[string]interface{}{"description": description}
+}
+
+func jsonRequestBody(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": schema,
+			},
+		},
+	}
+}
+
+// withErrorResponses adds references to the error responses of the status codes
+func withErrorResponses(responses map[string]interface{}, codes ...int) map[string]interface{} {
+	for _, code := range codes {
+		responses[strconv.Itoa(code)] = map[string]interface{}{
+			"$ref": "#/components/responses/" + errorResponses[code],
+		}
+	}
+	return responses
+}
+
+// accessSecurity returns the security requirements of an operation, anonymous requests are allowed unless the access
+// policy requires authentication
+func accessSecurity(requiresAuthn bool) []map[string][]interface{} {
+	security := []map[string][]interface{}{
+		{"JWT": []interface{}{}},
+		{"APIKey": []interface{}{}},
+	}
+	if !requiresAuthn {
+		security = append([]map[string][]interface{}{{}}, security...)
+	}
+	return security
+}
+
+// listParameters returns the pagination, sort, relation and filter query parameters of a resource list
+func listParameters(schema *models.JSONSchemaObject) []Parameter {
+	keys := make([]string, 0, len(schema.Properties))
+	for key := range schema.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sortValues := []string{dsi.MetadataCreated, "-" + dsi.MetadataCreated}
+	filters := []Parameter{}
+	for _, key := range keys {
+		typ, _ := schema.Properties[key]["type"].(string)
+		if !filterTypes[typ] {
+			continue
+		}
+		sortValues = append(sortValues, key, "-"+key)
+		filters = append(filters, Parameter{
+			Name:        key,
+			In:          "query",
+			Description: fmt.Sprintf("Only return documents where `%s` equals the value.", key),
+			Schema:      map[string]interface{}{"type": typ},
+		})
+	}
+
+	parameters := []Parameter{
+		{
+			Name:        dsi.LimitKey,
+			In:          "query",
+			Description: "Maximum number of documents to return.",
+			Schema:      map[string]interface{}{"type": "integer", "minimum": 1, "maximum": query.MaxLimit, "default": 10},
+		},
+		{
+			Name:        dsi.OffsetKey,
+			In:          "query",
+			Description: "Number of documents to skip.",
+			Schema:      map[string]interface{}{"type": "integer", "minimum": 0, "default": 0},
+		},
+		{
+			Name:        dsi.SortKey,
+			In:          "query",
+			Description: "Property to sort by, prefixed with `-` for descending order.",
+			Schema:      map[string]interface{}{"type": "string", "enum": sortValues},
+		},
+	}
+	parameters = append(parameters, relationParameters(schema)...)
+
+	return append(parameters, filters...)
+}
+
+// relationParameters returns the `_relation` query parameter if the resource has relation properties
+func relationParameters(schema *models.JSONSchemaObject) []Parameter {
+	relations := []string{}
+	for key, property := range schema.Properties {
+		if _, ok := property["relation"]; ok {
+			relations = append(relations, key)
+		}
+	}
+	if len(relations) == 0 {
+		return []Parameter{}
+	}
+	sort.Strings(relations)
+
+	return []Parameter{
+		{
+			Name:        dsi.RelationKey,
+			In:          "query",
+			Description: "Relation properties to expand with the related document.",
+			Schema: map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string", "enum": relations},
+			},
+		},
+	}
+}
@@ -2,6 +2,7 @@ package spec
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/middleware"
 )
@@ -9,10 +10,11 @@ import (
 // Handler is an interface to the API Key HTTP handler functions.
 type Handler interface {
 	GetSpec(c *gin.Context)
+	GetSDK(c *gin.Context)
 }
 
 // SetRoutes sets all of the appropriate routes to handlers for project users
-func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore) error {
+func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, config *config.AppConfig) error {
 	// create new Resources handler with datastore
 	handler := New(datastore)
 
@@ -21,16 +23,25 @@ func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore) error {
 		engine,
 		handler,
 		datastore,
+		config,
 		middleware.ProjectIDAuthzMiddleware(datastore),
 	)
 }
 
-func setRoutes(engine *gin.Engine, handler Handler, datastore interfaces.ResourcesDatastore, mw ...gin.HandlerFunc) error {
+func setRoutes(engine *gin.Engine, handler Handler, datastore interfaces.Datastore, config *config.AppConfig, mw ...gin.HandlerFunc) error {
 	// Only app users have access to api key management
 	keys := engine.Group("/spec")
 	keys.Use(mw...)
 
 	keys.GET("/", handler.GetSpec) // get openapi spec
 
+	// App mgmt routes with different authz policy
+	mgmt := engine.Group("/mgmt/spec")
+	mgmt.Use(middleware.AppUserJwtAuthzMiddleware(datastore, config))
+	mgmt.Use(middleware.AppUserProjectAuthzMiddleware(datastore, config))
+	mgmt.Use(mw...)
+
+	mgmt.GET("/sdk/:language", handler.GetSDK) // download a generated client SDK archive
+
 	return nil
 }
@@ -0,0 +1,419 @@
+package spec
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"go/token"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// sdkLanguages are the languages client SDKs can be generated for
+var sdkLanguages = map[string][]sdkTemplate{
+	"typescript": {
+		{name: "package.json", text: tsPackageTemplate},
+		{name: "tsconfig.json", text: tsConfigTemplate},
+		{name: "src/models.ts", text: tsModelsTemplate},
+		{name: "src/client.ts", text: tsClientTemplate},
+		{name: "src/index.ts", text: tsIndexTemplate},
+		{name: "README.md", text: tsReadmeTemplate},
+	},
+	"go": {
+		{name: "go.mod", text: goModTemplate},
+		{name: "client.go", text: goClientTemplate, gofmt: true},
+		{name: "models.go", text: goModelsTemplate, gofmt: true},
+		{name: "README.md", text: goReadmeTemplate},
+	},
+}
+
+// reservedSDKNames are the type and client member names used by the generated clients, resources are renamed to avoid
+// them
+var reservedSDKNames = []string{
+	"Client", "ClientOptions", "Session", "Error", "MachinableError", "Metadata", "ResourceRecord",
+	"ListResponse", "ListOptions", "Links", "Resource", "NewClient", "DefaultBaseURL",
+	"BaseUrl", "BaseURL", "HTTPClient", "ApiKey", "APIKey", "AccessToken", "RefreshToken", "SessionId", "SessionID",
+	"Login", "Refresh", "Logout", "Request", "Authorization", "Send", "Do",
+}
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// sdkTemplate renders a file of the SDK, Go files are formatted with gofmt
+type sdkTemplate struct {
+	name  string
+	text  string
+	gofmt bool
+}
+
+// sdkFile is a file of a generated SDK
+type sdkFile struct {
+	Name    string
+	Content []byte
+}
+
+// sdkProject is the data the SDK templates are rendered with
+type sdkProject struct {
+	Title     string
+	Slug      string
+	Package   string
+	Server    string
+	Resources []*sdkResource
+}
+
+// sdkResource is a resource of the project spec, `Name` is the type name of its model
+type sdkResource struct {
+	Title      string
+	PathName   string
+	Name       string
+	Field      string
+	Properties []*sdkProperty
+}
+
+// sdkProperty is a property of a resource model
+type sdkProperty struct {
+	Key      string
+	TSKey    string
+	TSType   string
+	GoName   string
+	GoType   string
+	Required bool
+}
+
+// generateSDK renders the client SDK of the project spec in the language
+func generateSDK(spec *ProjectSpec, projectPath, language string) ([]*sdkFile, error) {
+	templates, ok := sdkLanguages[language]
+	if !ok {
+		return nil, fmt.Errorf("unsupported SDK language '%s'", language)
+	}
+
+	project := sdkProjectFromSpec(spec, projectPath)
+	if language == "go" {
+		if err := goTagKeys(project); err != nil {
+			return nil, err
+		}
+	}
+
+	funcs := template.FuncMap{
+		"jsonTag": func(p *sdkProperty) string {
+			if p.Required {
+				return fmt.Sprintf("`json:\"%s\"`", p.Key)
+			}
+			return fmt.Sprintf("`json:\"%s,omitempty\"`", p.Key)
+		},
+		"tag": func(key string) string {
+			return fmt.Sprintf("`json:\"%s\"`", key)
+		},
+		"quote": func(s string) string {
+			b, _ := json.Marshal(s)
+			return string(b)
+		},
+	}
+
+	files := make([]*sdkFile, 0, len(templates))
+	for _, t := range templates {
+		tmpl, err := template.New(t.name).Funcs(funcs).Parse(t.text)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, project); err != nil {
+			return nil, err
+		}
+
+		content := buf.Bytes()
+		if t.gofmt {
+			if content, err = format.Source(content); err != nil {
+				return nil, fmt.Errorf("failed to format %s: %s", t.name, err)
+			}
+		}
+
+		files = append(files, &sdkFile{Name: t.name, Content: content})
+	}
+
+	return files, nil
+}
+
+// writeSDKArchive writes the files to a zip archive with all files in the `root` directory
+func writeSDKArchive(buf *bytes.Buffer, root string, files []*sdkFile) error {
+	archive := zip.NewWriter(buf)
+	for _, file := range files {
+		w, err := archive.Create(root + "/" + file.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(file.Content); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// sdkProjectFromSpec collects the resource models from the paths and component schemas of the spec
+func sdkProjectFromSpec(spec *ProjectSpec, projectPath string) *sdkProject {
+	project := &sdkProject{
+		Title:   spec.Info.Title,
+		Slug:    projectPath,
+		Package: goPackageName(projectPath),
+	}
+	if len(spec.Servers) > 0 {
+		project.Server = spec.Servers[0].URL
+	}
+
+	paths := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	used := map[string]bool{}
+	for _, name := range reservedSDKNames {
+		used[name] = true
+	}
+
+	for _, path := range paths {
+		// resource collections are served at `/api/{pathName}`
+		pathName := strings.TrimPrefix(path, "/api/")
+		if pathName == path || strings.Contains(pathName, "/") {
+			continue
+		}
+		list, ok := spec.Paths[path]["get"]
+		if !ok || len(list.Tags) == 0 {
+			continue
+		}
+
+		title := list.Tags[0]
+		schema, _ := spec.Components.Schemas[componentName(title, pathName)].(map[string]interface{})
+
+		name := uniqueSDKName(exportedName(title, pathName), used)
+		resource := &sdkResource{
+			Title:      title,
+			PathName:   pathName,
+			Name:       name,
+			Field:      strings.ToLower(name[:1]) + name[1:],
+			Properties: sdkProperties(schema),
+		}
+		project.Resources = append(project.Resources, resource)
+	}
+
+	return project
+}
+
+// uniqueSDKName returns the name, suffixed if it or one of its derived type names is already used
+func uniqueSDKName(name string, used map[string]bool) string {
+	derived := func(n string) []string {
+		return []string{n, n + "Record", n + "List", n + "Client"}
+	}
+
+	candidate := name
+	for i := 2; ; i++ {
+		taken := false
+		for _, n := range derived(candidate) {
+			taken = taken || used[n]
+		}
+		if !taken {
+			break
+		}
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+
+	for _, n := range derived(candidate) {
+		used[n] = true
+	}
+
+	return candidate
+}
+
+// sdkProperties maps the properties of a JSON schema object to model fields, sorted by key
+func sdkProperties(schema map[string]interface{}) []*sdkProperty {
+	properties, _ := schema["properties"].(map[string]interface{})
+	required := requiredKeys(schema)
+
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := map[string]bool{}
+	result := make([]*sdkProperty, 0, len(keys))
+	for _, key := range keys {
+		property, _ := properties[key].(map[string]interface{})
+
+		goName := exportedName(key, "Field")
+		for i := 2; fields[goName]; i++ {
+			goName = fmt.Sprintf("%s%d", exportedName(key, "Field"), i)
+		}
+		fields[goName] = true
+
+		goType := goPropertyType(property)
+		if !required[key] && !strings.HasPrefix(goType, "[]") && !strings.HasPrefix(goType, "map") && goType != "interface{}" {
+			goType = "*" + goType
+		}
+
+		tsKey := key
+		if !tsIdentifier.MatchString(key) {
+			b, _ := json.Marshal(key)
+			tsKey = string(b)
+		}
+
+		result = append(result, &sdkProperty{
+			Key:      key,
+			TSKey:    tsKey,
+			TSType:   tsPropertyType(property),
+			GoName:   goName,
+			GoType:   goType,
+			Required: required[key],
+		})
+	}
+
+	return result
+}
+
+func requiredKeys(schema map[string]interface{}) map[string]bool {
+	required := map[string]bool{}
+	keys, _ := schema["required"].([]interface{})
+	for _, key := range keys {
+		if k, ok := key.(string); ok {
+			required[k] = true
+		}
+	}
+	return required
+}
+
+// tsPropertyType returns the TypeScript type of the JSON schema property
+func tsPropertyType(property map[string]interface{}) string {
+	switch property["type"] {
+	case "string":
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		items, _ := property["items"].(map[string]interface{})
+		return fmt.Sprintf("Array<%s>", tsPropertyType(items))
+	case "object":
+		properties := sdkProperties(property)
+		if len(properties) == 0 {
+			return "{ [key: string]: unknown }"
+		}
+		fields := make([]string, 0, len(properties))
+		for _, p := range properties {
+			optional := "?"
+			if p.Required {
+				optional = ""
+			}
+			fields = append(fields, fmt.Sprintf("%s%s: %s", p.TSKey, optional, p.TSType))
+		}
+		return "{ " + strings.Join(fields, "; ") + " }"
+	}
+
+	return "unknown"
+}
+
+// sdkPropertyError is returned if a property key of a resource can not be used in the generated SDK
+type sdkPropertyError struct {
+	Resource string
+	Key      string
+}
+
+func (e *sdkPropertyError) Error() string {
+	return fmt.Sprintf("property '%s' of resource '%s' can not be used as a Go JSON field name", e.Key, e.Resource)
+}
+
+// goTagKeys returns an error if a property key of a resource can not be the name of a `json` struct tag
+func goTagKeys(project *sdkProject) error {
+	for _, resource := range project.Resources {
+		for _, p := range resource.Properties {
+			if !goTagKey(p.Key) {
+				return &sdkPropertyError{Resource: resource.PathName, Key: p.Key}
+			}
+		}
+	}
+	return nil
+}
+
+// goTagKey reports whether `encoding/json` accepts the key as the name of a struct tag
+func goTagKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// goPropertyType returns the Go type of the JSON schema property
+func goPropertyType(property map[string]interface{}) string {
+	switch property["type"] {
+	case "string":
+		return "string"
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		items, _ := property["items"].(map[string]interface{})
+		return "[]" + goPropertyType(items)
+	case "object":
+		return "map[string]interface{}"
+	}
+
+	return "interface{}"
+}
+
+// exportedName converts the name to an exported identifier, i.e. `blog posts` to `BlogPosts`, using the fallback if
+// the name has no letters or digits
+func exportedName(name, fallback string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool {
+		return !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+
+	exported := b.String()
+	if exported == "" {
+		if fallback == "" {
+			return "Resource"
+		}
+		return exportedName(fallback, "")
+	}
+	if unicode.IsDigit(rune(exported[0])) {
+		exported = "R" + exported
+	}
+
+	return exported
+}
+
+// goPackageName converts the project slug to a Go package name
+func goPackageName(slug string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(slug) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		}
+	}
+
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "project" + name
+	}
+	if token.Lookup(name).IsKeyword() {
+		name += "sdk"
+	}
+
+	return name
+}
@@ -0,0 +1,652 @@
+package spec
+
+// TypeScript SDK, a dependency free client using `fetch`
+
+const tsPackageTemplate = `{
+  "name": {{quote (print .Slug "-sdk")}},
+  "version": "1.0.0",
+  "description": {{quote (print "Client SDK for the " .Title " API")}},
+  "main": "dist/index.js",
+  "types": "dist/index.d.ts",
+  "scripts": {
+    "build": "tsc"
+  },
+  "devDependencies": {
+    "typescript": "^3.8.0"
+  }
+}
+`
+
+const tsConfigTemplate = `{
+  "compilerOptions": {
+    "target": "es2017",
+    "module": "commonjs",
+    "lib": ["es2017", "dom"],
+    "declaration": true,
+    "strict": true,
+    "outDir": "dist"
+  },
+  "include": ["src"]
+}
+`
+
+const tsModelsTemplate = `// Code generated by machinable for the {{.Title}} project. DO NOT EDIT.
+
+export interface Metadata {
+  creator: string;
+  creator_type: string;
+  created: number;
+}
+
+/** ResourceRecord holds the fields every stored document has */
+export interface ResourceRecord {
+  id: string;
+  _metadata: Metadata;
+}
+
+export interface ListResponse<T> {
+  count: number;
+  links: { self?: string; next?: string; prev?: string };
+  items: Array<T & ResourceRecord>;
+}
+
+export interface ListOptions<T> {
+  limit?: number;
+  offset?: number;
+  /** property to sort by, prefixed with "-" for descending order */
+  sort?: string;
+  /** relation properties to expand with the related document */
+  relations?: string[];
+  /** documents are filtered on properties equal to the value */
+  filter?: { [K in keyof T]?: string | number | boolean };
+}
+{{range .Resources}}
+/** {{.Title}}, served at /api/{{.PathName}} */
+export interface {{.Name}} {
+{{- range .Properties}}
+  {{.TSKey}}{{if not .Required}}?{{end}}: {{.TSType}};
+{{- end}}
+}
+{{end}}`
+
+const tsClientTemplate = `// Code generated by machinable for the {{.Title}} project. DO NOT EDIT.
+
+import { ListOptions, ListResponse, ResourceRecord{{range .Resources}}, {{.Name}}{{end}} } from './models';
+
+export const DEFAULT_BASE_URL = {{quote .Server}};
+
+export interface ClientOptions {
+  baseUrl?: string;
+  /** API key of the project, sent as "apikey <key>" */
+  apiKey?: string;
+  accessToken?: string;
+  refreshToken?: string;
+}
+
+export interface Session {
+  access_token: string;
+  refresh_token: string;
+  session_id: string;
+}
+
+export interface ErrorDetail {
+  field?: string;
+  message: string;
+}
+
+export class MachinableError extends Error {
+  constructor(
+    public status: number,
+    message: string,
+    public code?: string,
+    public details: ErrorDetail[] = [],
+    public requestId?: string,
+  ) {
+    super(message);
+    this.name = 'MachinableError';
+  }
+}
+
+/**
+ * Client of the {{.Title}} API. Requests are authenticated with the access token of a project user session if there
+ * is one, otherwise the API key. Expired access tokens are refreshed once with the refresh token.
+ */
+export class Client {
+  baseUrl: string;
+  apiKey?: string;
+  accessToken?: string;
+  refreshToken?: string;
+  sessionId?: string;
+{{range .Resources}}
+  readonly {{.Field}}: Resource<{{.Name}}>;
+{{- end}}
+
+  constructor(options: ClientOptions = {}) {
+    this.baseUrl = (options.baseUrl || DEFAULT_BASE_URL).replace(/\/+$/, '');
+    this.apiKey = options.apiKey;
+    this.accessToken = options.accessToken;
+    this.refreshToken = options.refreshToken;
+{{range .Resources}}
+    this.{{.Field}} = new Resource<{{.Name}}>(this, {{quote .PathName}});
+{{- end}}
+  }
+
+  /** login creates a session for the project user, subsequent requests use its access token */
+  async login(username: string, password: string): Promise<Session> {
+    const session = await this.send<Session>('POST', '/sessions/', undefined, 'Basic ' + btoa(username + ':' + password));
+    this.accessToken = session.access_token;
+    this.refreshToken = session.refresh_token;
+    this.sessionId = session.session_id;
+    return session;
+  }
+
+  /** refresh retrieves a new access token and refresh token, refresh tokens are single use */
+  async refresh(): Promise<string> {
+    if (!this.refreshToken) {
+      throw new MachinableError(401, 'refresh token required');
+    }
+    const res = await this.send<{ access_token: string; refresh_token: string }>('POST', '/sessions/refresh', undefined, 'Bearer ' + this.refreshToken);
+    this.accessToken = res.access_token;
+    this.refreshToken = res.refresh_token;
+    return res.access_token;
+  }
+
+  /** logout deletes the session of the project user */
+  async logout(): Promise<void> {
+    if (this.sessionId) {
+      await this.request<void>('DELETE', '/sessions/' + encodeURIComponent(this.sessionId));
+    }
+    this.accessToken = this.refreshToken = this.sessionId = undefined;
+  }
+
+  /** request sends an authenticated request to the API */
+  async request<T>(method: string, path: string, body?: unknown): Promise<T> {
+    try {
+      return await this.send<T>(method, path, body, this.authorization());
+    } catch (err) {
+      if (err instanceof MachinableError && err.status === 401 && this.accessToken && this.refreshToken) {
+        await this.refresh();
+        return this.send<T>(method, path, body, this.authorization());
+      }
+      throw err;
+    }
+  }
+
+  private authorization(): string | undefined {
+    if (this.accessToken) {
+      return 'Bearer ' + this.accessToken;
+    }
+    if (this.apiKey) {
+      return 'apikey ' + this.apiKey;
+    }
+    return undefined;
+  }
+
+  private async send<T>(method: string, path: string, body?: unknown, authorization?: string): Promise<T> {
+    const headers: { [key: string]: string } = {};
+    if (body !== undefined) {
+      headers['Content-Type'] = 'application/json';
+    }
+    if (authorization) {
+      headers['Authorization'] = authorization;
+    }
+
+    const res = await fetch(this.baseUrl + path, {
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    const text = await res.text();
+    const data = text ? JSON.parse(text) : undefined;
+    if (!res.ok) {
+      throw new MachinableError(
+        res.status,
+        (data && data.message) || res.statusText,
+        data && data.code,
+        (data && data.details) || [],
+        (data && data.request_id) || res.headers.get('X-Request-Id') || undefined,
+      );
+    }
+    return data as T;
+  }
+}
+
+/** Resource lists, retrieves, creates, updates and deletes the documents of a resource */
+export class Resource<T> {
+  constructor(private client: Client, readonly pathName: string) {}
+
+  list(options: ListOptions<T> = {}): Promise<ListResponse<T>> {
+    const params = new URLSearchParams();
+    if (options.limit !== undefined) {
+      params.set('_limit', String(options.limit));
+    }
+    if (options.offset !== undefined) {
+      params.set('_offset', String(options.offset));
+    }
+    if (options.sort) {
+      params.set('_sort', options.sort);
+    }
+    (options.relations || []).forEach(relation => params.append('_relation', relation));
+    Object.entries(options.filter || {}).forEach(([key, value]) => {
+      if (value !== undefined) {
+        params.set(key, String(value));
+      }
+    });
+
+    const query = params.toString();
+    return this.client.request('GET', this.path() + (query ? '?' + query : ''));
+  }
+
+  get(id: string, relations: string[] = []): Promise<T & ResourceRecord> {
+    const params = new URLSearchParams();
+    relations.forEach(relation => params.append('_relation', relation));
+    const query = params.toString();
+    return this.client.request('GET', this.path(id) + (query ? '?' + query : ''));
+  }
+
+  create(document: T): Promise<T & ResourceRecord> {
+    return this.client.request('POST', this.path(), document);
+  }
+
+  update(id: string, document: T): Promise<T & ResourceRecord> {
+    return this.client.request('PUT', this.path(id), document);
+  }
+
+  delete(id: string): Promise<void> {
+    return this.client.request('DELETE', this.path(id));
+  }
+
+  private path(id?: string): string {
+    const path = '/api/' + this.pathName;
+    return id === undefined ? path : path + '/' + encodeURIComponent(id);
+  }
+}
+`
+
+const tsIndexTemplate = `// Code generated by machinable for the {{.Title}} project. DO NOT EDIT.
+
+export * from './models';
+export * from './client';
+`
+
+const tsReadmeTemplate = `# {{.Title}} TypeScript SDK
+
+Generated client for the {{.Title}} API at {{.Server}}.
+
+~~~ts
+import { Client } from '{{.Slug}}-sdk';
+
+// authenticate with an API key
+const client = new Client({ apiKey: 'KEY' });
+
+// or with a project user session, the access token is refreshed when it expires
+await client.login('username', 'password');
+{{if .Resources}}{{with index .Resources 0 -}}
+const page = await client.{{.Field}}.list({ limit: 10, sort: '-_metadata.created' });
+{{- end}}{{end}}
+~~~
+
+Run ` + "`npm install && npm run build`" + ` to compile the SDK.
+`
+
+// Go SDK, depends only on the standard library
+
+const goModTemplate = `module {{.Package}}
+
+go 1.12
+`
+
+const goClientTemplate = `// Code generated by machinable for the {{.Title}} project. DO NOT EDIT.
+
+// Package {{.Package}} is a client for the {{.Title}} API.
+package {{.Package}}
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultBaseURL is the URL of the project API
+const DefaultBaseURL = {{quote .Server}}
+
+// Client is a client of the {{.Title}} API. Requests are authenticated with the access token of a project user
+// session if there is one, otherwise the API key. Expired access tokens are refreshed once with the refresh token.
+type Client struct {
+	BaseURL      string
+	HTTPClient   *http.Client
+	APIKey       string
+	AccessToken  string
+	RefreshToken string
+	SessionID    string
+{{range .Resources}}
+	{{.Name}} *{{.Name}}Client
+{{- end}}
+}
+
+// NewClient returns a client of the project API authenticated with the API key, which may be empty
+func NewClient(apiKey string) *Client {
+	c := &Client{
+		BaseURL:    DefaultBaseURL,
+		HTTPClient: http.DefaultClient,
+		APIKey:     apiKey,
+	}
+{{range .Resources}}
+	c.{{.Name}} = &{{.Name}}Client{client: c}
+{{- end}}
+	return c
+}
+
+// Session is a project user session
+type Session struct {
+	AccessToken  string {{tag "access_token"}}
+	RefreshToken string {{tag "refresh_token"}}
+	SessionID    string {{tag "session_id"}}
+}
+
+// Error is an error response of the API
+type Error struct {
+	StatusCode int
+	Code       string        {{tag "code"}}
+	Message    string        {{tag "message"}}
+	Details    []ErrorDetail {{tag "details"}}
+	RequestID  string        {{tag "request_id"}}
+}
+
+// ErrorDetail is a field level error
+type ErrorDetail struct {
+	Field   string {{tag "field"}}
+	Message string {{tag "message"}}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+}
+
+// Metadata is set on every stored document
+type Metadata struct {
+	Creator     string {{tag "creator"}}
+	CreatorType string {{tag "creator_type"}}
+	Created     int64  {{tag "created"}}
+}
+
+// Links are the pagination links of a list response
+type Links struct {
+	Self string {{tag "self"}}
+	Next string {{tag "next"}}
+	Prev string {{tag "prev"}}
+}
+
+// ListOptions paginates, sorts and filters a list of documents
+type ListOptions struct {
+	Limit  int
+	Offset int
+	// Sort is the property to sort by, prefixed with "-" for descending order
+	Sort string
+	// Relations are the relation properties to expand with the related document
+	Relations []string
+	// Filter matches documents with properties equal to the value
+	Filter map[string]string
+}
+
+func (o *ListOptions) values() url.Values {
+	values := url.Values{}
+	if o == nil {
+		return values
+	}
+	if o.Limit > 0 {
+		values.Set("_limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		values.Set("_offset", strconv.Itoa(o.Offset))
+	}
+	if o.Sort != "" {
+		values.Set("_sort", o.Sort)
+	}
+	for _, relation := range o.Relations {
+		values.Add("_relation", relation)
+	}
+	for key, value := range o.Filter {
+		values.Set(key, value)
+	}
+	return values
+}
+
+// Login creates a session for the project user, subsequent requests use its access token
+func (c *Client) Login(username, password string) (*Session, error) {
+	req, err := c.newRequest(http.MethodPost, "/sessions/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(username, password)
+
+	session := &Session{}
+	if err := c.send(req, session); err != nil {
+		return nil, err
+	}
+
+	c.AccessToken = session.AccessToken
+	c.RefreshToken = session.RefreshToken
+	c.SessionID = session.SessionID
+	return session, nil
+}
+
+// Refresh retrieves a new access token and refresh token, refresh tokens are single use
+func (c *Client) Refresh() error {
+	if c.RefreshToken == "" {
+		return &Error{StatusCode: http.StatusUnauthorized, Message: "refresh token required"}
+	}
+
+	req, err := c.newRequest(http.MethodPost, "/sessions/refresh", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.RefreshToken)
+
+	res := &Session{}
+	if err := c.send(req, res); err != nil {
+		return err
+	}
+
+	c.AccessToken, c.RefreshToken = res.AccessToken, res.RefreshToken
+	return nil
+}
+
+// Logout deletes the session of the project user
+func (c *Client) Logout() error {
+	if c.SessionID != "" {
+		if err := c.Do(http.MethodDelete, "/sessions/"+url.PathEscape(c.SessionID), nil, nil); err != nil {
+			return err
+		}
+	}
+
+	c.AccessToken, c.RefreshToken, c.SessionID = "", "", ""
+	return nil
+}
+
+// Do sends an authenticated request to the API, decoding the JSON response into out unless it is nil
+func (c *Client) Do(method, path string, body, out interface{}) error {
+	err := c.do(method, path, body, out)
+	if apiErr, ok := err.(*Error); ok && apiErr.StatusCode == http.StatusUnauthorized && c.AccessToken != "" && c.RefreshToken != "" {
+		if err := c.Refresh(); err != nil {
+			return err
+		}
+		return c.do(method, path, body, out)
+	}
+	return err
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	req, err := c.newRequest(method, path, body)
+	if err != nil {
+		return err
+	}
+
+	if c.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	} else if c.APIKey != "" {
+		req.Header.Set("Authorization", "apikey "+c.APIKey)
+	}
+
+	return c.send(req, out)
+}
+
+func (c *Client) newRequest(method, path string, body interface{}) (*http.Request, error) {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(c.BaseURL, "/")+path, &buf)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+func (c *Client) send(req *http.Request, out interface{}) error {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		apiErr := &Error{}
+		if json.NewDecoder(res.Body).Decode(apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = res.Status
+		}
+		apiErr.StatusCode = res.StatusCode
+		if apiErr.RequestID == "" {
+			apiErr.RequestID = res.Header.Get("X-Request-Id")
+		}
+		return apiErr
+	}
+
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func resourcePath(pathName, id string, query url.Values) string {
+	path := "/api/" + pathName
+	if id != "" {
+		path += "/" + url.PathEscape(id)
+	}
+	if encoded := query.Encode(); encoded != "" {
+		path += "?" + encoded
+	}
+	return path
+}
+`
+
+const goModelsTemplate = `// Code generated by machinable for the {{.Title}} project. DO NOT EDIT.
+
+package {{.Package}}
+{{if .Resources}}
+import (
+	"net/http"
+	"net/url"
+)
+{{end}}{{range .Resources}}
+// {{.Name}} is a document of {{.Title}}, served at /api/{{.PathName}}
+type {{.Name}} struct {
+{{- range .Properties}}
+	{{.GoName}} {{.GoType}} {{jsonTag .}}
+{{- end}}
+}
+
+// {{.Name}}Record is a stored document of {{.Title}}
+type {{.Name}}Record struct {
+	ID       string   {{tag "id"}}
+	Metadata Metadata {{tag "_metadata"}}
+	{{.Name}}
+}
+
+// {{.Name}}List is a page of {{.Title}} documents
+type {{.Name}}List struct {
+	Count int64                {{tag "count"}}
+	Links Links                {{tag "links"}}
+	Items []*{{.Name}}Record {{tag "items"}}
+}
+
+// {{.Name}}Client lists, retrieves, creates, updates and deletes {{.Title}} documents
+type {{.Name}}Client struct {
+	client *Client
+}
+
+// List retrieves a page of documents
+func (r *{{.Name}}Client) List(opts *ListOptions) (*{{.Name}}List, error) {
+	list := &{{.Name}}List{}
+	if err := r.client.Do(http.MethodGet, resourcePath({{quote .PathName}}, "", opts.values()), nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Get retrieves the document, expanding the relation properties
+func (r *{{.Name}}Client) Get(id string, relations ...string) (*{{.Name}}Record, error) {
+	record := &{{.Name}}Record{}
+	if err := r.client.Do(http.MethodGet, resourcePath({{quote .PathName}}, id, url.Values{"_relation": relations}), nil, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Create creates a new document
+func (r *{{.Name}}Client) Create(document *{{.Name}}) (*{{.Name}}Record, error) {
+	record := &{{.Name}}Record{}
+	if err := r.client.Do(http.MethodPost, resourcePath({{quote .PathName}}, "", nil), document, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Update replaces the fields of the document
+func (r *{{.Name}}Client) Update(id string, document *{{.Name}}) (*{{.Name}}Record, error) {
+	record := &{{.Name}}Record{}
+	if err := r.client.Do(http.MethodPut, resourcePath({{quote .PathName}}, id, nil), document, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Delete deletes the document
+func (r *{{.Name}}Client) Delete(id string) error {
+	return r.client.Do(http.MethodDelete, resourcePath({{quote .PathName}}, id, nil), nil, nil)
+}
+{{end}}`
+
+const goReadmeTemplate = `# {{.Title}} Go SDK
+
+Generated client for the {{.Title}} API at {{.Server}}.
+
+~~~go
+// authenticate with an API key
+client := {{.Package}}.NewClient("KEY")
+
+// or with a project user session, the access token is refreshed when it expires
+if _, err := client.Login("username", "password"); err != nil {
+	return err
+}
+{{if .Resources}}{{with index .Resources 0}}
+page, err := client.{{.Name}}.List(&{{$.Package}}.ListOptions{Limit: 10, Sort: "-_metadata.created"})
+{{- end}}{{end}}
+~~~
+`
@@ -0,0 +1,159 @@
+package spec
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var sdkDefinitions = []*models.ResourceDefinition{
+	{
+		Title:    "Blog Posts",
+		PathName: "blog-posts",
+		Schema:   `{"type":"object","required":["title"],"properties":{"title":{"type":"string"},"views":{"type":"integer"},"tags":{"type":"array","items":{"type":"string"}},"first-name":{"type":"string"},"meta":{"type":"object","properties":{"draft":{"type":"boolean"}}}}}`,
+	},
+	{
+		Title:    "Client",
+		PathName: "clients",
+		Schema:   `{"type":"object","properties":{"rating":{"type":"number"}}}`,
+	},
+}
+
+func sdkFiles(t *testing.T, language string) map[string]string {
+	spec := baseSpec("my-app")
+	injectProjectSchema(spec, sdkDefinitions)
+	spec.Info.Title = "My App"
+
+	files, err := generateSDK(spec, "my-app", language)
+	assert.Nil(t, err)
+
+	contents := map[string]string{}
+	for _, file := range files {
+		contents[file.Name] = string(file.Content)
+	}
+	return contents
+}
+
+func TestSDKProject(t *testing.T) {
+	spec := baseSpec("my-app")
+	injectProjectSchema(spec, sdkDefinitions)
+
+	project := sdkProjectFromSpec(spec, "my-app")
+	assert.Equal(t, "myapp", project.Package)
+	assert.Equal(t, "https://my-app.machinable.io", project.Server)
+	assert.Len(t, project.Resources, 2)
+
+	posts := project.Resources[0]
+	assert.Equal(t, "BlogPosts", posts.Name)
+	assert.Equal(t, "blogPosts", posts.Field)
+	assert.Equal(t, "blog-posts", posts.PathName)
+
+	// "Client" is used by the SDK
+	assert.Equal(t, "Client2", project.Resources[1].Name)
+
+	properties := map[string]*sdkProperty{}
+	for _, p := range posts.Properties {
+		properties[p.Key] = p
+	}
+	assert.Equal(t, &sdkProperty{Key: "title", TSKey: "title", TSType: "string", GoName: "Title", GoType: "string", Required: true}, properties["title"])
+	assert.Equal(t, &sdkProperty{Key: "views", TSKey: "views", TSType: "number", GoName: "Views", GoType: "*int64"}, properties["views"])
+	assert.Equal(t, &sdkProperty{Key: "tags", TSKey: "tags", TSType: "Array<string>", GoName: "Tags", GoType: "[]string"}, properties["tags"])
+	assert.Equal(t, &sdkProperty{Key: "first-name", TSKey: `"first-name"`, TSType: "string", GoName: "FirstName", GoType: "*string"}, properties["first-name"])
+	assert.Equal(t, "{ draft?: boolean }", properties["meta"].TSType)
+}
+
+func TestGenerateTypeScriptSDK(t *testing.T) {
+	files := sdkFiles(t, "typescript")
+
+	assert.Contains(t, files["src/models.ts"], "export interface BlogPosts {\n  \"first-name\"?: string;\n  meta?: { draft?: boolean };\n  tags?: Array<string>;\n  title: string;\n  views?: number;\n}")
+	assert.Contains(t, files["src/client.ts"], "this.blogPosts = new Resource<BlogPosts>(this, \"blog-posts\");")
+	assert.Contains(t, files["src/client.ts"], "export const DEFAULT_BASE_URL = \"https://my-app.machinable.io\";")
+	assert.Contains(t, files["package.json"], `"name": "my-app-sdk"`)
+}
+
+func TestGenerateGoSDK(t *testing.T) {
+	files := sdkFiles(t, "go")
+	assert.Contains(t, files["models.go"], "type BlogPosts struct {\n\tFirstName *string                `json:\"first-name,omitempty\"`")
+	assert.Contains(t, files["client.go"], "package myapp")
+
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain is not available to build the SDK")
+	}
+
+	dir, err := ioutil.TempDir("", "sdk")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, content := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	cmd := exec.Command(goBin, "vet", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+	out, err := cmd.CombinedOutput()
+	assert.Nil(t, err, string(out))
+}
+
+func TestGenerateSDKErrors(t *testing.T) {
+	_, err := generateSDK(baseSpec("my-app"), "my-app", "cobol")
+	assert.EqualError(t, err, "unsupported SDK language 'cobol'")
+
+	// keys which can not be Go struct tags are rejected instead of left out of the Go models
+	spec := baseSpec("my-app")
+	injectProjectSchema(spec, []*models.ResourceDefinition{{
+		Title:    "Notes",
+		PathName: "notes",
+		Schema:   `{"type":"object","properties":{"a,b":{"type":"string"}}}`,
+	}})
+	_, err = generateSDK(spec, "my-app", "go")
+	assert.EqualError(t, err, "property 'a,b' of resource 'notes' can not be used as a Go JSON field name")
+	files, err := generateSDK(spec, "my-app", "typescript")
+	assert.Nil(t, err)
+	for _, file := range files {
+		if file.Name == "src/models.ts" {
+			assert.Contains(t, string(file.Content), `"a,b"?: string`)
+		}
+	}
+
+	// a project without resources still generates a client
+	for language := range sdkLanguages {
+		_, err := generateSDK(baseSpec("my-app"), "my-app", language)
+		assert.Nil(t, err, language)
+	}
+}
+
+func TestWriteSDKArchive(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeSDKArchive(&buf, "my-app-go-sdk", []*sdkFile{{Name: "go.mod", Content: []byte("module myapp\n")}})
+	assert.Nil(t, err)
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+	assert.Len(t, archive.File, 1)
+	assert.True(t, strings.HasPrefix(archive.File[0].Name, "my-app-go-sdk/"))
+}
+
+func TestGoTagKey(t *testing.T) {
+	for key, valid := range map[string]bool{
+		"title":      true,
+		"first-name": true,
+		"a b/c:d":    true,
+		"":           false,
+		"a,b":        false,
+		`a"b`:        false,
+		"a`b":        false,
+		`a\b`:        false,
+	} {
+		assert.Equal(t, valid, goTagKey(key), key)
+	}
+}
@@ -0,0 +1,176 @@
+package spec
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var pathParameter = regexp.MustCompile(`{([^}]+)}`)
+
+func testProjectSpec(t *testing.T) map[string]interface{} {
+	spec := baseSpec("my-app")
+	injectProjectSchema(spec, []*models.ResourceDefinition{
+		{
+			Title:    "Blog Posts",
+			PathName: "blog-posts",
+			Read:     true,
+			Schema:   `{"type":"object","properties":{"title":{"type":"string"},"views":{"type":"integer"},"tags":{"type":"array","items":{"type":"string"}},"author":{"type":"string","relation":"people"}}}`,
+		},
+	})
+	injectJSONTrees(spec, []*models.RootKey{
+		{Key: "settings", Update: true, Schema: json.RawMessage(`{"type":"object"}`)},
+		{Key: "chat", Rules: []*models.JSONRule{{Path: "/", Read: "true"}}},
+	})
+	injectUserRegistration(spec)
+	injectUserFlows(spec)
+	injectOAuthLogin(spec, []*models.IdentityProvider{{Name: "github"}, {Name: "google"}})
+
+	b, err := json.Marshal(spec)
+	assert.Nil(t, err)
+
+	doc := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(b, &doc))
+	return doc
+}
+
+// resolveRefs asserts every `$ref` of the value points to an existing component
+func resolveRefs(t *testing.T, doc map[string]interface{}, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"].(string); ok {
+			var node interface{} = doc
+			for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+				m, _ := node.(map[string]interface{})
+				node = m[part]
+			}
+			assert.NotNil(t, node, "unresolved reference %s", ref)
+		}
+		for _, child := range v {
+			resolveRefs(t, doc, child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			resolveRefs(t, doc, child)
+		}
+	}
+}
+
+func TestProjectSpec(t *testing.T) {
+	doc := testProjectSpec(t)
+	resolveRefs(t, doc, doc)
+
+	schemes := doc["components"].(map[string]interface{})["securitySchemes"].(map[string]interface{})
+	operationIDs := map[string]bool{}
+
+	for path, item := range doc["paths"].(map[string]interface{}) {
+		for method, op := range item.(map[string]interface{}) {
+			operation := op.(map[string]interface{})
+
+			id := operation["operationId"].(string)
+			assert.False(t, operationIDs[id], "duplicate operationId %s", id)
+			operationIDs[id] = true
+
+			// sessions are created and refreshed with the Authorization header, verification codes are in the path
+			_, hasBody := operation["requestBody"]
+			write := method == "post" || method == "put" || method == "patch"
+			bodyless := (strings.HasPrefix(path, "/sessions") && !strings.HasPrefix(path, "/sessions/oauth")) || path == "/users/verify/{verificationCode}"
+			assert.Equal(t, write && !bodyless, hasBody, "%s %s request body", method, path)
+
+			// every security requirement refers to a scheme
+			assert.NotNil(t, operation["security"], "%s %s security", method, path)
+			for _, requirement := range operation["security"].([]interface{}) {
+				for scheme := range requirement.(map[string]interface{}) {
+					assert.Contains(t, schemes, scheme)
+				}
+			}
+
+			// every path template parameter is declared
+			declared := map[string]bool{}
+			parameters, _ := operation["parameters"].([]interface{})
+			for _, p := range parameters {
+				parameter := p.(map[string]interface{})
+				if parameter["in"] == "path" {
+					declared[parameter["name"].(string)] = true
+					assert.Equal(t, true, parameter["required"])
+				}
+			}
+			for _, match := range pathParameter.FindAllStringSubmatch(path, -1) {
+				assert.True(t, declared[match[1]], "%s %s does not declare {%s}", method, path, match[1])
+			}
+		}
+	}
+
+	for _, requirement := range doc["security"].([]interface{}) {
+		for scheme := range requirement.(map[string]interface{}) {
+			assert.Contains(t, schemes, scheme)
+		}
+	}
+}
+
+func TestProjectSpecPaths(t *testing.T) {
+	doc := testProjectSpec(t)
+	paths := doc["paths"].(map[string]interface{})
+
+	for _, path := range []string{
+		"/sessions/", "/sessions/refresh", "/sessions/{sessionId}", "/users/register",
+		"/sessions/oauth/{provider}/authorize", "/sessions/oauth/{provider}/callback", "/sessions/oauth/{provider}/token",
+		"/users/verify", "/users/verify/{verificationCode}", "/users/password/forgot", "/users/password/reset",
+		"/api/blog-posts", "/api/blog-posts/{id}", "/api/blog-posts/{id}/acl", "/api/blog-posts/{id}/acl/{principalType}/{principalId}",
+		"/json/settings/", "/json/settings/{keys}", "/json/chat/", "/json/chat/{keys}",
+	} {
+		assert.Contains(t, paths, path)
+	}
+
+	list := paths["/api/blog-posts"].(map[string]interface{})["get"].(map[string]interface{})
+	parameters := map[string]map[string]interface{}{}
+	for _, p := range list["parameters"].([]interface{}) {
+		parameter := p.(map[string]interface{})
+		parameters[parameter["name"].(string)] = parameter["schema"].(map[string]interface{})
+	}
+	assert.Equal(t, []interface{}{"_metadata.created", "-_metadata.created", "author", "-author", "title", "-title", "views", "-views"}, parameters["_sort"]["enum"])
+	assert.Equal(t, map[string]interface{}{"type": "string", "enum": []interface{}{"author"}}, parameters["_relation"]["items"])
+	assert.Equal(t, map[string]interface{}{"type": "integer"}, parameters["views"])
+	assert.NotContains(t, parameters, "tags")
+
+	// the resource requires authentication to read, the tree allows anonymous reads
+	assert.Equal(t, []interface{}{map[string]interface{}{"JWT": []interface{}{}}, map[string]interface{}{"APIKey": []interface{}{}}}, list["security"])
+	read := paths["/json/settings/"].(map[string]interface{})["get"].(map[string]interface{})
+	assert.Len(t, read["security"], 3)
+	update := paths["/json/settings/{keys}"].(map[string]interface{})["put"].(map[string]interface{})
+	assert.Len(t, update["security"], 2)
+
+	// rules replace the access flags
+	chat := paths["/json/chat/{keys}"].(map[string]interface{})["delete"].(map[string]interface{})
+	assert.Len(t, chat["security"], 3)
+	assert.Equal(t, "Access is determined by the path scoped rules of the tree.", chat["description"])
+}
+
+func TestProjectSpecRoleView(t *testing.T) {
+	resource := &models.ResourceDefinition{
+		Title:    "Orders",
+		PathName: "orders",
+		Schema:   `{"type":"object","required":["item","cost"],"properties":{"item":{"type":"string"},"cost":{"type":"number","read_roles":["billing"]},"status":{"type":"string","write_roles":["billing"]}}}`,
+	}
+
+	spec := baseSpec("my-app")
+	injectProjectSchema(spec, []*models.ResourceDefinition{resource.RoleView("user")})
+	b, err := json.Marshal(spec.Components.Schemas["Orders"])
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"type":"object","required":["item"],"properties":{"item":{"type":"string"},"status":{"type":"string","readOnly":true}}}`, string(b))
+
+	list := spec.Paths["/api/orders"]
+	b, err = json.Marshal(list)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), "cost")
+
+	spec = baseSpec("my-app")
+	injectProjectSchema(spec, []*models.ResourceDefinition{resource.RoleView("billing")})
+	b, err = json.Marshal(spec.Components.Schemas["Orders"])
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"type":"object","required":["item","cost"],"properties":{"item":{"type":"string"},"cost":{"type":"number"},"status":{"type":"string"}}}`, string(b))
+}
@@ -0,0 +1,190 @@
+package spec
+
+const usersTag = "Users"
+
+// injectUserRegistration documents the registration of project users, which is only routed if enabled for the project
+func injectUserRegistration(spec *ProjectSpec) {
+	spec.Tags = append(spec.Tags, Tag{
+		Name:        usersTag,
+		Description: "Project user registration",
+	})
+	spec.XTagGroups[0].Tags = append(spec.XTagGroups[0].Tags, usersTag)
+
+	spec.Components.Schemas["ProjectUser"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id": map[string]interface{}{
+				"type":   "string",
+				"format": "uuid",
+			},
+			"project_id": map[string]interface{}{
+				"type":   "string",
+				"format": "uuid",
+			},
+			"username": map[string]interface{}{
+				"type": "string",
+			},
+			"email": map[string]interface{}{
+				"type": "string",
+			},
+			"created": map[string]interface{}{
+				"type":   "string",
+				"format": "date-time",
+			},
+			"read": map[string]interface{}{
+				"type": "boolean",
+			},
+			"write": map[string]interface{}{
+				"type": "boolean",
+			},
+			"role": map[string]interface{}{
+				"type": "string",
+				"enum": []string{"user"},
+			},
+			"active": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Users of projects which require email verification are inactive until they verify their email.",
+			},
+		},
+	}
+
+	spec.Paths["/users/register"] = map[string]Verb{
+		"post": {
+			Tags:        []string{usersTag},
+			Summary:     "Register a new user",
+			Description: "Creates a project user with the `user` role, which can then create a JWT session.",
+			OperationID: "RegisterUser",
+			Security:    []map[string][]interface{}{},
+			RequestBody: jsonRequestBody(map[string]interface{}{
+				"type":     "object",
+				"required": []string{"username", "password"},
+				"properties": map[string]interface{}{
+					"username": map[string]interface{}{
+						"type": "string",
+					},
+					"password": map[string]interface{}{
+						"type":   "string",
+						"format": "password",
+					},
+					"email": map[string]interface{}{
+						"type":        "string",
+						"format":      "email",
+						"description": "Required if the project verifies the email of registered users.",
+					},
+					"read": map[string]interface{}{
+						"type": "boolean",
+					},
+					"write": map[string]interface{}{
+						"type": "boolean",
+					},
+				},
+			}),
+			Responses: withErrorResponses(map[string]interface{}{
+				"201": jsonResponse("User was registered successfully", schemaRef("ProjectUser")),
+			}, 400, 404, 500),
+		},
+	}
+}
+
+const accountsTag = "User Accounts"
+
+// injectUserFlows documents email verification and password reset, which are routed for every project
+func injectUserFlows(spec *ProjectSpec) {
+	spec.Tags = append(spec.Tags, Tag{
+		Name:        accountsTag,
+		Description: "Email verification and password reset of project users",
+	})
+	spec.XTagGroups[0].Tags = append(spec.XTagGroups[0].Tags, accountsTag)
+
+	emailBody := jsonRequestBody(map[string]interface{}{
+		"type":     "object",
+		"required": []string{"email"},
+		"properties": map[string]interface{}{
+			"email": map[string]interface{}{
+				"type":   "string",
+				"format": "email",
+			},
+		},
+	})
+	message := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"message": map[string]interface{}{
+				"type": "string",
+			},
+		},
+	}
+
+	spec.Paths["/users/verify"] = map[string]Verb{
+		"post": {
+			Tags:        []string{accountsTag},
+			Summary:     "Resend the verification email",
+			Description: "Sends a new verification code if the email belongs to an unverified user. The response does not reveal whether the user exists.",
+			OperationID: "ResendVerification",
+			Security:    []map[string][]interface{}{},
+			RequestBody: emailBody,
+			Responses: withErrorResponses(map[string]interface{}{
+				"202": jsonResponse("Verification email was queued", message),
+			}, 400, 500),
+		},
+	}
+	spec.Paths["/users/verify/{verificationCode}"] = map[string]Verb{
+		"post": {
+			Tags:        []string{accountsTag},
+			Summary:     "Verify an email address",
+			Description: "Activates the user of the verification code, which expires after 24 hours.",
+			OperationID: "VerifyEmail",
+			Security:    []map[string][]interface{}{},
+			Parameters: []Parameter{
+				{
+					Name:        "verificationCode",
+					In:          "path",
+					Description: "The code of the verification email.",
+					Required:    true,
+					Schema:      map[string]interface{}{"type": "string"},
+				},
+			},
+			Responses: withErrorResponses(map[string]interface{}{
+				"200": jsonResponse("User was activated successfully", message),
+			}, 404, 500),
+		},
+	}
+	spec.Paths["/users/password/forgot"] = map[string]Verb{
+		"post": {
+			Tags:        []string{accountsTag},
+			Summary:     "Request a password reset",
+			Description: "Emails a password reset token, which expires after 1 hour. The response does not reveal whether the user exists.",
+			OperationID: "ForgotPassword",
+			Security:    []map[string][]interface{}{},
+			RequestBody: emailBody,
+			Responses: withErrorResponses(map[string]interface{}{
+				"202": jsonResponse("Password reset email was queued", message),
+			}, 400, 500),
+		},
+	}
+	spec.Paths["/users/password/reset"] = map[string]Verb{
+		"post": {
+			Tags:        []string{accountsTag},
+			Summary:     "Reset a password",
+			Description: "Sets the password of the user with a password reset token. All sessions of the user are revoked.",
+			OperationID: "ResetPassword",
+			Security:    []map[string][]interface{}{},
+			RequestBody: jsonRequestBody(map[string]interface{}{
+				"type":     "object",
+				"required": []string{"token", "password"},
+				"properties": map[string]interface{}{
+					"token": map[string]interface{}{
+						"type": "string",
+					},
+					"password": map[string]interface{}{
+						"type":   "string",
+						"format": "password",
+					},
+				},
+			}),
+			Responses: withErrorResponses(map[string]interface{}{
+				"200": jsonResponse("Password was reset successfully", message),
+			}, 400, 404, 500),
+		},
+	}
+}
@@ -0,0 +1,274 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/apierror"
+	"github.com/machinable/machinable/auth"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/events"
+	"github.com/machinable/machinable/middleware"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// verificationTTL is the lifetime of an email verification code
+	verificationTTL = time.Hour * 24
+	// passwordResetTTL is the lifetime of a password reset token
+	passwordResetTTL = time.Hour
+
+	// flowIPLimit is the number of requests a client can make to each flow within flowLimitWindow
+	flowIPLimit = 20
+	// flowEmailLimit is the number of verification or password reset emails requested for an email within
+	// flowLimitWindow, whether or not the email belongs to a user
+	flowEmailLimit  = 5
+	flowLimitWindow = time.Hour
+)
+
+func verificationKey(projectID, code string) string {
+	return fmt.Sprintf("projectVerificationCode:%s:%s", projectID, code)
+}
+
+func passwordResetKey(projectID, token string) string {
+	return fmt.Sprintf("projectPasswordReset:%s:%s", projectID, token)
+}
+
+func flowEmailKey(projectID, flow, email string) string {
+	return fmt.Sprintf("rateLimit:%s:%s:email:%s", flow, projectID, strings.ToLower(strings.TrimSpace(email)))
+}
+
+// emailRateLimited responds with 429 if too many emails of the flow were requested for the email
+func (u *Users) emailRateLimited(c *gin.Context, flow, email string) bool {
+	projectID := c.MustGet("projectId").(string)
+	if middleware.RateLimited(u.cache, flowEmailKey(projectID, flow, email), flowEmailLimit, flowLimitWindow) {
+		apierror.Respond(c, http.StatusTooManyRequests, "too many requests for this email, try again later")
+		return true
+	}
+	return false
+}
+
+// consumeCode deletes the single use code and returns the user it belongs to. Concurrent requests with the same code
+// race to delete it, only the request which deleted it may use it.
+func (u *Users) consumeCode(key string) (string, bool) {
+	userID, err := u.cache.Get(key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Println(err)
+		}
+		return "", false
+	}
+
+	deleted, err := u.cache.Del(key).Result()
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+
+	return userID, deleted == 1
+}
+
+// expiresIn formats the lifetime of a code for email templates, i.e. "24 hours"
+func expiresIn(d time.Duration) string {
+	hours := int(d.Hours())
+	if hours == 1 {
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d hours", hours)
+}
+
+// sendEmail renders the project's email template for the user and queues the notification
+func (u *Users) sendEmail(c *gin.Context, templateName string, user *models.ProjectUser, code string, ttl time.Duration) error {
+	projectID := c.MustGet("projectId").(string)
+	projectName := c.GetString("projectName")
+
+	template, err := u.store.GetEmailTemplate(projectID, templateName)
+	if err != nil {
+		log.Println(err)
+		return errors.New("failed to load email template")
+	}
+
+	subject, body, link, err := template.Render(&models.EmailTemplateData{
+		Project: projectName,
+		Name:    user.Username,
+		Code:    code,
+		Expires: expiresIn(ttl),
+	})
+	if err != nil {
+		log.Println(err)
+		return errors.New("failed to render email template")
+	}
+
+	n := &events.Notification{
+		Template:         "default",
+		Subject:          subject,
+		ReceiverName:     user.Username,
+		ReceiverEmail:    user.Email,
+		PlainTextContent: body,
+		Data: map[string]string{
+			"Name":    user.Username,
+			"Site":    projectName,
+			"URL":     link,
+			"Company": projectName,
+		},
+		Meta: map[string]string{
+			"project_id": projectID,
+			"user_id":    user.ID,
+			"template":   templateName,
+		},
+	}
+
+	b, err := json.Marshal(n)
+	if err != nil {
+		log.Println(err)
+		return errors.New("failed to queue email")
+	}
+	if err := u.bus.Publish(events.QueueEmailNotifications, b); err != nil {
+		log.Println(err)
+		return errors.New("failed to queue email")
+	}
+
+	return nil
+}
+
+// sendVerification creates the verification code of the user, saves it to redis with a timeout, and queues the email
+func (u *Users) sendVerification(c *gin.Context, user *models.ProjectUser) error {
+	projectID := c.MustGet("projectId").(string)
+	code := uuid.NewV4().String()
+
+	if err := u.cache.Set(verificationKey(projectID, code), user.ID, verificationTTL).Err(); err != nil {
+		log.Println(err)
+		return errors.New("failed creating verification code")
+	}
+
+	return u.sendEmail(c, models.EmailTemplateVerification, user, code, verificationTTL)
+}
+
+// ResendVerification sends a new verification code to an inactive user. The response is the same whether or not the
+// email belongs to a user, so the endpoint can't be used to discover users.
+func (u *Users) ResendVerification(c *gin.Context) {
+	var body emailBody
+	projectID := c.MustGet("projectId").(string)
+
+	c.BindJSON(&body)
+	if strings.TrimSpace(body.Email) == "" {
+		apierror.Respond(c, http.StatusBadRequest, "email is required")
+		return
+	}
+	if u.emailRateLimited(c, "verify", body.Email) {
+		return
+	}
+
+	// failures are logged, a different response would reveal the user
+	user, err := u.store.GetUserByEmail(projectID, body.Email)
+	if err == nil && !user.Active {
+		if err := u.sendVerification(c, user); err != nil {
+			log.Println(err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "If the email belongs to an unverified user, a new verification code has been sent",
+	})
+}
+
+// VerifyEmail looks up the verification code in redis, marks the email of the associated user as verified and activates
+// the user
+func (u *Users) VerifyEmail(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+
+	// codes are single use
+	userID, ok := u.consumeCode(verificationKey(projectID, c.Param("verificationCode")))
+	if !ok {
+		apierror.Respond(c, http.StatusNotFound, "invalid or expired verification code")
+		return
+	}
+
+	if _, err := u.store.GetUserByID(projectID, userID); err != nil {
+		apierror.Respond(c, http.StatusNotFound, "user does not exist")
+		return
+	}
+
+	if err := u.store.VerifyProjectUserEmail(projectID, userID); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to activate user")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully verified email"})
+}
+
+// ForgotPassword sends a password reset token to the user with the email. The response is the same whether or not the
+// email belongs to a user.
+func (u *Users) ForgotPassword(c *gin.Context) {
+	var body emailBody
+	projectID := c.MustGet("projectId").(string)
+
+	c.BindJSON(&body)
+	if strings.TrimSpace(body.Email) == "" {
+		apierror.Respond(c, http.StatusBadRequest, "email is required")
+		return
+	}
+	if u.emailRateLimited(c, "password", body.Email) {
+		return
+	}
+
+	// failures are logged, a different response would reveal the user
+	user, err := u.store.GetUserByEmail(projectID, body.Email)
+	if err == nil {
+		token := uuid.NewV4().String()
+		if err := u.cache.Set(passwordResetKey(projectID, token), user.ID, passwordResetTTL).Err(); err != nil {
+			log.Println(err)
+		} else if err := u.sendEmail(c, models.EmailTemplatePasswordReset, user, token, passwordResetTTL); err != nil {
+			log.Println(err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "If the email belongs to a user, a password reset token has been sent",
+	})
+}
+
+// ResetPassword sets the password of the user with a password reset token. All sessions of the user are revoked.
+func (u *Users) ResetPassword(c *gin.Context) {
+	var body resetPasswordBody
+	projectID := c.MustGet("projectId").(string)
+
+	c.BindJSON(&body)
+	if body.Token == "" || body.Password == "" {
+		apierror.Respond(c, http.StatusBadRequest, "token and password are required")
+		return
+	}
+
+	// tokens are single use
+	userID, ok := u.consumeCode(passwordResetKey(projectID, body.Token))
+	if !ok {
+		apierror.Respond(c, http.StatusNotFound, "invalid or expired password reset token")
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(body.Password)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	body.Password = ""
+
+	if err := u.store.UpdateProjectUserPassword(projectID, userID, passwordHash); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to reset password")
+		return
+	}
+
+	// the password may have been compromised, log out everywhere
+	if err := u.store.DeleteUserSessions(projectID, userID); err != nil {
+		log.Println(err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully reset password"})
+}
@@ -0,0 +1,402 @@
+package users
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/auth"
+	"github.com/machinable/machinable/config"
+	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/events"
+	"github.com/machinable/machinable/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.ReleaseMode)
+	code := m.Run()
+	os.Exit(code)
+}
+
+// testCache is an in-memory cache with expiring keys, unused functions panic
+type testCache struct {
+	redis.UniversalClient
+	now      time.Time
+	values   map[string]string
+	expires  map[string]time.Time
+	consumed map[string]bool
+}
+
+func newTestCache() *testCache {
+	return &testCache{now: time.Now(), values: map[string]string{}, expires: map[string]time.Time{}, consumed: map[string]bool{}}
+}
+
+func (c *testCache) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	c.values[key] = value.(string)
+	c.expires[key] = c.now.Add(expiration)
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (c *testCache) Get(key string) *redis.StringCmd {
+	value, ok := c.values[key]
+	if !ok || !c.now.Before(c.expires[key]) {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(value, nil)
+}
+
+// Del returns the number of deleted keys, a key consumed by a concurrent request is not deleted again
+func (c *testCache) Del(keys ...string) *redis.IntCmd {
+	deleted := int64(0)
+	for _, key := range keys {
+		if _, ok := c.values[key]; ok && !c.consumed[key] {
+			deleted++
+		}
+		delete(c.values, key)
+	}
+	return redis.NewIntResult(deleted, nil)
+}
+
+func (c *testCache) Incr(key string) *redis.IntCmd {
+	count, _ := c.Get(key).Int64()
+	count++
+	c.values[key] = strconv.FormatInt(count, 10)
+	if _, ok := c.expires[key]; !ok || !c.now.Before(c.expires[key]) {
+		c.expires[key] = c.now.Add(time.Hour * 24 * 365)
+	}
+	return redis.NewIntResult(count, nil)
+}
+
+func (c *testCache) Expire(key string, expiration time.Duration) *redis.BoolCmd {
+	c.expires[key] = c.now.Add(expiration)
+	return redis.NewBoolResult(true, nil)
+}
+
+// key returns the only key with the prefix, and its lifetime
+func (c *testCache) key(t *testing.T, prefix string) (string, time.Duration) {
+	found := []string{}
+	for key := range c.values {
+		if strings.HasPrefix(key, prefix) {
+			found = append(found, key)
+		}
+	}
+	if !assert.Len(t, found, 1) {
+		t.FailNow()
+	}
+	return strings.TrimPrefix(found[0], prefix), c.expires[found[0]].Sub(c.now)
+}
+
+// testStore keeps the users of a project in memory, unused functions panic
+type testStore struct {
+	interfaces.Datastore
+	users           map[string]*models.ProjectUser
+	templateErr     error
+	deleted         []string
+	sessionsDeleted []string
+}
+
+func (s *testStore) find(match func(user *models.ProjectUser) bool) (*models.ProjectUser, error) {
+	for _, user := range s.users {
+		if match(user) {
+			return user, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (s *testStore) GetUserByUsername(projectID, userName string) (*models.ProjectUser, error) {
+	return s.find(func(user *models.ProjectUser) bool { return user.Username == userName })
+}
+
+func (s *testStore) GetUserByEmail(projectID, email string) (*models.ProjectUser, error) {
+	return s.find(func(user *models.ProjectUser) bool { return user.Email == email })
+}
+
+func (s *testStore) GetUserByID(projectID, userID string) (*models.ProjectUser, error) {
+	return s.find(func(user *models.ProjectUser) bool { return user.ID == userID })
+}
+
+func (s *testStore) CreateUser(projectID string, user *models.ProjectUser) error {
+	user.ID = "user-" + strconv.Itoa(len(s.users)+1)
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *testStore) VerifyProjectUserEmail(projectID, userID string) error {
+	s.users[userID].EmailVerified = true
+	s.users[userID].Active = true
+	return nil
+}
+
+func (s *testStore) UpdateProjectUserPassword(projectID, userID, passwordHash string) error {
+	s.users[userID].PasswordHash = passwordHash
+	return nil
+}
+
+func (s *testStore) DeleteUser(projectID, userID string) error {
+	delete(s.users, userID)
+	s.deleted = append(s.deleted, userID)
+	return nil
+}
+
+func (s *testStore) DeleteUserSessions(projectID, userID string) error {
+	s.sessionsDeleted = append(s.sessionsDeleted, userID)
+	return nil
+}
+
+func (s *testStore) GetEmailTemplate(projectID, name string) (*models.EmailTemplate, error) {
+	if s.templateErr != nil {
+		return nil, s.templateErr
+	}
+	return models.DefaultEmailTemplate(name), nil
+}
+
+func newTestStore(users ...*models.ProjectUser) *testStore {
+	store := &testStore{users: map[string]*models.ProjectUser{}}
+	for _, user := range users {
+		store.users[user.ID] = user
+	}
+	return store
+}
+
+// testRouter serves the registration and flow routes of a project which requires email verification
+func testRouter(store *testStore, cache *testCache, bus events.EventBus) *gin.Engine {
+	handler := New(store, cache, bus)
+	password := middleware.IPRateLimit(cache, &config.AppConfig{}, "password", flowIPLimit, flowLimitWindow)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("projectId", "project")
+		c.Set("projectName", "Blog")
+		c.Set("projectUserVerification", true)
+	})
+	router.POST("/users/register", handler.AddLimitedUser)
+	router.POST("/users/verify", handler.ResendVerification)
+	router.POST("/users/verify/:verificationCode", handler.VerifyEmail)
+	router.POST("/users/password/forgot", password, handler.ForgotPassword)
+	router.POST("/users/password/reset", password, handler.ResetPassword)
+
+	return router
+}
+
+func request(router *gin.Engine, path string, body interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAddLimitedUser(t *testing.T) {
+	store := newTestStore()
+	cache := newTestCache()
+	bus := events.NewMemoryBus()
+	router := testRouter(store, cache, bus)
+
+	w := request(router, "/users/register", gin.H{"username": "ada", "password": "secret", "email": "ada@example.com", "role": "admin"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// the user is inactive until the emailed code is verified
+	user, err := store.GetUserByUsername("project", "ada")
+	assert.Nil(t, err)
+	assert.False(t, user.Active)
+	assert.Equal(t, "user", user.Role)
+
+	code, ttl := cache.key(t, verificationKey("project", ""))
+	assert.Equal(t, verificationTTL, ttl)
+
+	msg, err := bus.Consume(events.QueueEmailNotifications)
+	assert.Nil(t, err)
+	notification := &events.Notification{}
+	assert.Nil(t, json.Unmarshal(msg.Body, notification))
+	assert.Equal(t, "ada@example.com", notification.ReceiverEmail)
+	assert.Contains(t, notification.PlainTextContent, code)
+}
+
+func TestAddLimitedUserEmailFailure(t *testing.T) {
+	store := newTestStore()
+	store.templateErr = errors.New("template store unavailable")
+	router := testRouter(store, newTestCache(), events.NewMemoryBus())
+
+	w := request(router, "/users/register", gin.H{"username": "ada", "password": "secret", "email": "ada@example.com"})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// the user is removed so the username can be registered again
+	assert.Equal(t, []string{"user-1"}, store.deleted)
+	assert.Empty(t, store.users)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	tables := []struct {
+		name       string
+		active     bool
+		age        time.Duration
+		reuse      bool
+		statusCode int
+	}{
+		{"verified", true, time.Hour, false, http.StatusOK},
+		{"expired", false, verificationTTL + time.Minute, false, http.StatusNotFound},
+		{"reused", true, time.Hour, true, http.StatusNotFound},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(&models.ProjectUser{ID: "user-1", Username: "ada", Email: "ada@example.com"})
+			cache := newTestCache()
+			cache.Set(verificationKey("project", "code"), "user-1", verificationTTL)
+			cache.now = cache.now.Add(tt.age)
+			router := testRouter(store, cache, events.NewMemoryBus())
+
+			w := request(router, "/users/verify/code", nil)
+			if tt.reuse {
+				assert.Equal(t, http.StatusOK, w.Code)
+				w = request(router, "/users/verify/code", nil)
+			}
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.active, store.users["user-1"].Active)
+			assert.Equal(t, tt.active, store.users["user-1"].EmailVerified)
+		})
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	store := newTestStore(&models.ProjectUser{ID: "user-1", Username: "ada", Email: "ada@example.com", Active: true})
+	cache := newTestCache()
+	bus := events.NewMemoryBus()
+	router := testRouter(store, cache, bus)
+
+	// the response does not reveal whether the email belongs to a user
+	w := request(router, "/users/password/forgot", gin.H{"email": "nobody@example.com"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	for key := range cache.values {
+		assert.False(t, strings.HasPrefix(key, passwordResetKey("project", "")), key)
+	}
+
+	w = request(router, "/users/password/forgot", gin.H{"email": "ada@example.com"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	token, ttl := cache.key(t, passwordResetKey("project", ""))
+	assert.Equal(t, passwordResetTTL, ttl)
+
+	msg, err := bus.Consume(events.QueueEmailNotifications)
+	assert.Nil(t, err)
+	assert.Contains(t, string(msg.Body), token)
+
+	w = request(router, "/users/password/forgot", gin.H{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestResetPassword(t *testing.T) {
+	tables := []struct {
+		name       string
+		age        time.Duration
+		reuse      bool
+		statusCode int
+		reset      bool
+	}{
+		{"reset", time.Minute, false, http.StatusOK, true},
+		{"expired", passwordResetTTL + time.Minute, false, http.StatusNotFound, false},
+		{"reused", time.Minute, true, http.StatusNotFound, true},
+	}
+
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, _ := auth.HashPassword("old")
+			store := newTestStore(&models.ProjectUser{ID: "user-1", Username: "ada", Email: "ada@example.com", Active: true, PasswordHash: hash})
+			cache := newTestCache()
+			cache.Set(passwordResetKey("project", "token"), "user-1", passwordResetTTL)
+			cache.now = cache.now.Add(tt.age)
+			router := testRouter(store, cache, events.NewMemoryBus())
+
+			w := request(router, "/users/password/reset", gin.H{"token": "token", "password": "new"})
+			if tt.reuse {
+				assert.Equal(t, http.StatusOK, w.Code)
+				w = request(router, "/users/password/reset", gin.H{"token": "token", "password": "other"})
+			}
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.reset, auth.CompareHashAndPassword(store.users["user-1"].PasswordHash, "new"))
+			if tt.reset {
+				// the sessions of the user are revoked
+				assert.Equal(t, []string{"user-1"}, store.sessionsDeleted)
+			}
+		})
+	}
+}
+
+func TestForgotPasswordEmailFailure(t *testing.T) {
+	store := newTestStore(&models.ProjectUser{ID: "user-1", Username: "ada", Email: "ada@example.com", Active: true})
+	store.templateErr = errors.New("template store unavailable")
+	router := testRouter(store, newTestCache(), events.NewMemoryBus())
+
+	// the failure is not revealed, the response is the same as for unknown emails
+	w := request(router, "/users/password/forgot", gin.H{"email": "ada@example.com"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	store.users["user-1"].Active = false
+	w = request(router, "/users/verify", gin.H{"email": "ada@example.com"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestConcurrentCodeUse(t *testing.T) {
+	hash, _ := auth.HashPassword("old")
+	store := newTestStore(&models.ProjectUser{ID: "user-1", Username: "ada", Email: "ada@example.com", PasswordHash: hash})
+	cache := newTestCache()
+	router := testRouter(store, cache, events.NewMemoryBus())
+
+	// the codes were read by concurrent requests which deleted them first
+	for key, path := range map[string]string{
+		verificationKey("project", "code"):   "/users/verify/code",
+		passwordResetKey("project", "token"): "/users/password/reset",
+	} {
+		cache.Set(key, "user-1", time.Hour)
+		cache.consumed[key] = true
+
+		w := request(router, path, gin.H{"token": "token", "password": "new"})
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+
+	assert.False(t, store.users["user-1"].Active)
+	assert.True(t, auth.CompareHashAndPassword(store.users["user-1"].PasswordHash, "old"))
+}
+
+func TestFlowRateLimits(t *testing.T) {
+	store := newTestStore(&models.ProjectUser{ID: "user-1", Username: "ada", Email: "ada@example.com", Active: true})
+	cache := newTestCache()
+	router := testRouter(store, cache, events.NewMemoryBus())
+
+	// emails are limited whether or not they belong to a user
+	for _, email := range []string{"ada@example.com", "nobody@example.com"} {
+		for i := 0; i < flowEmailLimit; i++ {
+			w := request(router, "/users/password/forgot", gin.H{"email": email})
+			assert.Equal(t, http.StatusAccepted, w.Code)
+		}
+		w := request(router, "/users/password/forgot", gin.H{"email": strings.ToUpper(email)})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code, email)
+	}
+
+	// the client is limited across emails and tokens
+	for i := 2*flowEmailLimit + 2; i < flowIPLimit; i++ {
+		w := request(router, "/users/password/reset", gin.H{"token": "guess", "password": "new"})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+	w := request(router, "/users/password/reset", gin.H{"token": "guess", "password": "new"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// the limits are reset after the window
+	cache.now = cache.now.Add(flowLimitWindow + time.Minute)
+	w = request(router, "/users/password/forgot", gin.H{"email": "ada@example.com"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
@@ -1,25 +1,34 @@
 package users
 
 import (
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/machinable/machinable/apierror"
 	"github.com/machinable/machinable/auth"
 	"github.com/machinable/machinable/dsi/interfaces"
 	"github.com/machinable/machinable/dsi/models"
+	"github.com/machinable/machinable/events"
 )
 
 // New returns a pointer to a new `Users` struct
-func New(db interfaces.ProjectUsersDatastore) *Users {
+func New(db interfaces.Datastore, cache redis.UniversalClient, bus events.EventBus) *Users {
 	return &Users{
 		store: db,
+		cache: cache,
+		bus:   bus,
 	}
 }
 
-// Users wraps the datastore and any HTTP handlers for project users
+// Users wraps the datastore and any HTTP handlers for project users. Verification codes and password reset tokens are
+// kept in the cache until they expire, emails are queued on the bus.
 type Users struct {
-	store interfaces.ProjectUsersDatastore
+	store interfaces.Datastore
+	cache redis.UniversalClient
+	bus   events.EventBus
 }
 
 // UpdateUser updates the role and access of a project user
@@ -33,52 +42,80 @@ func (u *Users) UpdateUser(c *gin.Context) {
 	// validate user access and role
 	err := newUser.ValidateAccessRole()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !u.supportedRole(projectID, newUser.Role) {
+		apierror.Respond(c, http.StatusBadRequest, "invalid role")
 		return
 	}
 
-	// update project user object
+	// update project user object, attributes and groups are kept if they are omitted
 	user := &models.ProjectUser{
-		Read:  newUser.Read,
-		Write: newUser.Write,
-		Role:  newUser.Role,
+		Read:       newUser.Read,
+		Write:      newUser.Write,
+		Role:       newUser.Role,
+		Attributes: newUser.Attributes,
+		Groups:     newUser.Groups,
 	}
 
-	u.store.UpdateUser(projectID, userID, user)
-
+	err = u.store.UpdateUser(projectID, userID, user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	// app users can activate and deactivate users
+	if newUser.Active != nil {
+		if err := u.store.ActivateProjectUser(projectID, userID, *newUser.Active); err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{})
 }
 
-// AddLimitedUser creates a new user for this project from the unauthenticated route
+// AddLimitedUser creates a new user for this project from the unauthenticated route. If the project requires email
+// verification, the user is inactive until the emailed verification code is confirmed.
 func (u *Users) AddLimitedUser(c *gin.Context) {
 	var newUser NewProjectUser
 	projectID := c.MustGet("projectId").(string)
+	verification := c.GetBool("projectUserVerification")
 
 	c.BindJSON(&newUser)
-	// override role
+	// override role and access
 	newUser.Role = "user"
+	newUser.Active = nil
+	newUser.Attributes = nil
+	newUser.Groups = nil
 
 	// validate user
 	err := newUser.Validate()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if verification && newUser.Email == "" {
+		apierror.Respond(c, http.StatusBadRequest, "email is required to verify the user")
 		return
 	}
 
 	if _, err := u.store.GetUserByUsername(projectID, newUser.Username); err == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user already exists"})
+		apierror.Respond(c, http.StatusBadRequest, "user already exists")
 		return
 	}
+	if newUser.Email != "" {
+		if _, err := u.store.GetUserByEmail(projectID, newUser.Email); err == nil {
+			apierror.Respond(c, http.StatusBadRequest, "email is already in use")
+			return
+		}
+	}
 
 	// generate hashed password
 	passwordHash, err := auth.HashPassword(newUser.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 	newUser.Password = ""
@@ -88,18 +125,30 @@ func (u *Users) AddLimitedUser(c *gin.Context) {
 		Created:      time.Now(),
 		PasswordHash: passwordHash,
 		Username:     newUser.Username,
+		Email:        newUser.Email,
 		Read:         newUser.Read,
 		Write:        newUser.Write,
 		Role:         newUser.Role,
+		Active:       !verification,
 	}
 
-	u.store.CreateUser(projectID, user)
-
+	err = u.store.CreateUser(projectID, user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	// the user is removed if the verification email can not be sent, so the username and email can be registered again
+	if verification {
+		if err := u.sendVerification(c, user); err != nil {
+			if dErr := u.store.DeleteUser(projectID, user.ID); dErr != nil {
+				log.Println(dErr)
+			}
+			apierror.Respond(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
 	c.JSON(http.StatusCreated, user)
 }
 
@@ -113,19 +162,29 @@ func (u *Users) AddUser(c *gin.Context) {
 	// validate user
 	err := newUser.Validate()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !u.supportedRole(projectID, newUser.Role) {
+		apierror.Respond(c, http.StatusBadRequest, "invalid role")
 		return
 	}
 
 	if _, err := u.store.GetUserByUsername(projectID, newUser.Username); err == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user already exists"})
+		apierror.Respond(c, http.StatusBadRequest, "user already exists")
 		return
 	}
+	if newUser.Email != "" {
+		if _, err := u.store.GetUserByEmail(projectID, newUser.Email); err == nil {
+			apierror.Respond(c, http.StatusBadRequest, "email is already in use")
+			return
+		}
+	}
 
 	// generate hashed password
 	passwordHash, err := auth.HashPassword(newUser.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 	newUser.Password = ""
@@ -135,21 +194,34 @@ func (u *Users) AddUser(c *gin.Context) {
 		Created:      time.Now(),
 		PasswordHash: passwordHash,
 		Username:     newUser.Username,
+		Email:        newUser.Email,
 		Read:         newUser.Read,
 		Write:        newUser.Write,
 		Role:         newUser.Role,
+		Active:       newUser.Active == nil || *newUser.Active,
+		Attributes:   newUser.Attributes,
+		Groups:       newUser.Groups,
 	}
 
-	u.store.CreateUser(projectID, user)
+	err = u.store.CreateUser(projectID, user)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	c.JSON(http.StatusCreated, user)
 }
 
+// supportedRole returns true if the role is a built-in role or a custom role of the project
+func (u *Users) supportedRole(projectID, role string) bool {
+	if auth.BuiltinRole(role) {
+		return true
+	}
+	_, err := u.store.GetRole(projectID, role)
+	return err == nil
+}
+
 // ListUsers lists all users of this project
 func (u *Users) ListUsers(c *gin.Context) {
 	projectID := c.MustGet("projectId").(string)
@@ -157,7 +229,7 @@ func (u *Users) ListUsers(c *gin.Context) {
 	users, err := u.store.ListUsers(projectID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -177,7 +249,7 @@ func (u *Users) DeleteUser(c *gin.Context) {
 	err := u.store.DeleteUser(projectID, userID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
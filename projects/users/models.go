@@ -2,27 +2,50 @@ package users
 
 import (
 	"errors"
+	"fmt"
+	"net/mail"
 
 	"github.com/machinable/machinable/auth"
+	"github.com/machinable/machinable/dsi/models"
 )
 
 // NewProjectUser is the JSON structure of a new user request
 type NewProjectUser struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email"`
 	Read     bool   `json:"read"`
 	Write    bool   `json:"write"`
 	Role     string `json:"role"`
+	Active   *bool  `json:"active"` // Active is only set by app users, registered users are activated by verifying their email
+
+	// Attributes are only set by app users, they are compared with documents by the conditions of custom roles
+	Attributes map[string]interface{} `json:"attributes"`
+	// Groups are only set by app users, documents can be shared with the users of a group
+	Groups []string `json:"groups"`
+}
+
+const (
+	// maxUserAttributes limits the attributes of a project user, which are included in its access tokens
+	maxUserAttributes = 32
+	// maxUserGroups limits the groups of a project user, which are included in its access tokens
+	maxUserGroups = 32
+)
+
+// emailBody is the JSON structure of a verification or password reset request
+type emailBody struct {
+	Email string `json:"email"`
 }
 
-// SupportedRole verifies that the users role is valid and supported
+// resetPasswordBody is the JSON structure of a password reset
+type resetPasswordBody struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// SupportedRole verifies that the role is one of the built-in roles, other roles must be custom roles of the project
 func (u *NewProjectUser) SupportedRole() bool {
-	for _, b := range auth.ValidRoles {
-		if b == u.Role {
-			return true
-		}
-	}
-	return false
+	return auth.BuiltinRole(u.Role)
 }
 
 // Validate checks that the new user has a username and password.
@@ -31,6 +54,12 @@ func (u *NewProjectUser) Validate() error {
 		return errors.New("invalid username or password")
 	}
 
+	if u.Email != "" {
+		if addr, err := mail.ParseAddress(u.Email); err != nil || addr.Address != u.Email {
+			return errors.New("invalid email")
+		}
+	}
+
 	return u.ValidateAccessRole()
 }
 
@@ -41,9 +70,24 @@ func (u *NewProjectUser) ValidateAccessRole() error {
 		u.Role = auth.RoleUser
 	}
 
-	// Validate role
-	if ok := u.SupportedRole(); !ok {
-		return errors.New("invalid role")
+	if len(u.Attributes) > maxUserAttributes {
+		return fmt.Errorf("a user can have at most %d attributes", maxUserAttributes)
+	}
+	for key, value := range u.Attributes {
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			return fmt.Errorf("invalid attribute '%s', must be a string, number or boolean", key)
+		}
+	}
+
+	if len(u.Groups) > maxUserGroups {
+		return fmt.Errorf("a user can have at most %d groups", maxUserGroups)
+	}
+	for _, group := range u.Groups {
+		if !models.ValidGroupName(group) {
+			return fmt.Errorf("invalid group '%s'", group)
+		}
 	}
 
 	return nil
@@ -2,23 +2,35 @@ package users
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
 	"github.com/machinable/machinable/config"
 	"github.com/machinable/machinable/dsi/interfaces"
+	"github.com/machinable/machinable/events"
 	"github.com/machinable/machinable/middleware"
 )
 
 // SetRoutes sets all of the appropriate routes to handlers for project users
-func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, config *config.AppConfig) error {
+func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, cache redis.UniversalClient, bus events.EventBus, config *config.AppConfig) error {
 	// create new Resources handler with datastore
-	handler := New(datastore)
+	handler := New(datastore, cache, bus)
 
 	users := engine.Group("/users")
 	users.Use(middleware.ProjectUserRegistrationMiddleware(datastore))
 	users.POST("/register", handler.AddLimitedUser) // create a new user with the role 'user'
 
+	// email verification and password reset are available to all projects, users can be created by app users
+	flows := engine.Group("/users")
+	flows.Use(middleware.ProjectIDAuthzMiddleware(datastore))
+	verify := middleware.IPRateLimit(cache, config, "verify", flowIPLimit, flowLimitWindow)
+	password := middleware.IPRateLimit(cache, config, "password", flowIPLimit, flowLimitWindow)
+	flows.POST("/verify", verify, handler.ResendVerification)            // send a new verification code
+	flows.POST("/verify/:verificationCode", verify, handler.VerifyEmail) // activate the user of the verification code
+	flows.POST("/password/forgot", password, handler.ForgotPassword)     // send a password reset token
+	flows.POST("/password/reset", password, handler.ResetPassword)       // set a new password with the reset token
+
 	// Only app users have access to user management
 	mgmt := engine.Group("/mgmt/users")
-	mgmt.Use(middleware.AppUserJwtAuthzMiddleware(config))
+	mgmt.Use(middleware.AppUserJwtAuthzMiddleware(datastore, config))
 	mgmt.Use(middleware.AppUserProjectAuthzMiddleware(datastore, config))
 
 	mgmt.GET("/", handler.ListUsers)            // get list of users for this project
@@ -27,5 +39,15 @@ func SetRoutes(engine *gin.Engine, datastore interfaces.Datastore, config *confi
 	mgmt.DELETE("/:userID", handler.DeleteUser) // delete a user of this project
 	mgmt.PUT("/:userID", handler.UpdateUser)    // update a user of this project
 
+	// email templates of the verification and password reset flows
+	templates := engine.Group("/mgmt/templates")
+	templates.Use(middleware.AppUserJwtAuthzMiddleware(datastore, config))
+	templates.Use(middleware.AppUserProjectAuthzMiddleware(datastore, config))
+
+	templates.GET("/", handler.ListEmailTemplates)                 // list the email templates of this project
+	templates.GET("/:templateName", handler.GetEmailTemplate)      // get a single email template
+	templates.PUT("/:templateName", handler.UpdateEmailTemplate)   // customize an email template
+	templates.DELETE("/:templateName", handler.ResetEmailTemplate) // reset an email template to the default
+
 	return nil
 }
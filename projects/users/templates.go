@@ -0,0 +1,96 @@
+package users
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/machinable/machinable/apierror"
+	"github.com/machinable/machinable/dsi/models"
+)
+
+// templateNames are the email templates of the project user flows
+var templateNames = []string{models.EmailTemplateVerification, models.EmailTemplatePasswordReset}
+
+// ListEmailTemplates lists the email templates of the project, defaults are returned for templates that have not been
+// customized
+func (u *Users) ListEmailTemplates(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+
+	templates := make([]*models.EmailTemplate, 0, len(templateNames))
+	for _, name := range templateNames {
+		template, err := u.store.GetEmailTemplate(projectID, name)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		templates = append(templates, template)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": templates})
+}
+
+// GetEmailTemplate returns a single email template of the project
+func (u *Users) GetEmailTemplate(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+	name := c.Param("templateName")
+
+	if models.DefaultEmailTemplate(name) == nil {
+		apierror.Respond(c, http.StatusNotFound, "template not found")
+		return
+	}
+
+	template, err := u.store.GetEmailTemplate(projectID, name)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+// UpdateEmailTemplate customizes an email template of the project
+func (u *Users) UpdateEmailTemplate(c *gin.Context) {
+	var template models.EmailTemplate
+	projectID := c.MustGet("projectId").(string)
+	name := c.Param("templateName")
+
+	if models.DefaultEmailTemplate(name) == nil {
+		apierror.Respond(c, http.StatusNotFound, "template not found")
+		return
+	}
+
+	c.BindJSON(&template)
+	template.Name = name
+	template.ProjectID = projectID
+	template.Default = false
+
+	if err := template.Validate(); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := u.store.SaveEmailTemplate(projectID, &template); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+// ResetEmailTemplate deletes the customized email template, the project uses the default template again
+func (u *Users) ResetEmailTemplate(c *gin.Context) {
+	projectID := c.MustGet("projectId").(string)
+	name := c.Param("templateName")
+
+	if models.DefaultEmailTemplate(name) == nil {
+		apierror.Respond(c, http.StatusNotFound, "template not found")
+		return
+	}
+
+	if err := u.store.DeleteEmailTemplate(projectID, name); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
@@ -0,0 +1,77 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+
+	"github.com/machinable/machinable/dsi/interfaces"
+)
+
+const (
+	// LogRetention is how long project logs are kept
+	LogRetention = 24 * time.Hour
+	// SessionRetention is how long sessions are kept after they were last accessed, sessions which can still be
+	// refreshed are kept until they expire
+	SessionRetention = 36 * time.Hour
+	// HookResultRetention is how long web hook results are kept
+	HookResultRetention = 2 * time.Hour
+	// OutboxRetention is how long published outbox events are kept
+	OutboxRetention = 24 * time.Hour
+
+	// expiryBatchSize is the maximum number of documents deleted per statement
+	expiryBatchSize = 500
+)
+
+// ExpireDocuments returns a job that deletes the expired documents of all resources with a TTL, in batches
+func ExpireDocuments(store interfaces.Datastore) func() error {
+	return func() error {
+		definitions, err := store.ListExpiringDefinitions()
+		if err != nil {
+			return err
+		}
+
+		for _, def := range definitions {
+			for {
+				deleted, err := store.DeleteExpiredDefDocuments(def, expiryBatchSize)
+				if err != nil {
+					log.Printf("failed to expire documents of '%s': %s", def.PathName, err)
+					break
+				}
+				if deleted < expiryBatchSize {
+					break
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// PurgeRetention returns a job that deletes logs, sessions, web hook results and published events older than their
+// retention period, as well as expired JWT signing keys
+func PurgeRetention(store interfaces.Datastore) func() error {
+	return func() error {
+		now := time.Now()
+
+		if _, err := store.PurgeProjectLogs(now.Add(-LogRetention)); err != nil {
+			return err
+		}
+		if _, err := store.PurgeProjectSessions(now.Add(-SessionRetention)); err != nil {
+			return err
+		}
+		if _, err := store.PurgeAppSessions(now.Add(-SessionRetention)); err != nil {
+			return err
+		}
+		if _, err := store.PurgeResults(now.Add(-HookResultRetention)); err != nil {
+			return err
+		}
+		if _, err := store.PurgePublishedOutboxEvents(now.Add(-OutboxRetention)); err != nil {
+			return err
+		}
+		if _, err := store.PurgeSigningKeys(now); err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
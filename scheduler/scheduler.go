@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a function run by the scheduler on an interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+// Scheduler runs jobs in the background on a fixed interval. A job is never run concurrently with itself.
+type Scheduler struct {
+	jobs []*Job
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates and returns a new instance of `Scheduler`
+func New() *Scheduler {
+	return &Scheduler{
+		jobs: make([]*Job, 0),
+		stop: make(chan struct{}),
+	}
+}
+
+// Every adds a job that runs every `interval`
+func (s *Scheduler) Every(interval time.Duration, name string, run func() error) {
+	s.jobs = append(s.jobs, &Job{
+		Name:     name,
+		Interval: interval,
+		Run:      run,
+	})
+}
+
+// Start runs each job in its own goroutine until `Stop` is called
+func (s *Scheduler) Start() {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.run(job)
+	}
+}
+
+// Stop stops all jobs and waits for running jobs to finish
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(job *Job) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := job.Run(); err != nil {
+				log.Printf("scheduled job '%s' failed: %s", job.Name, err)
+			}
+		}
+	}
+}
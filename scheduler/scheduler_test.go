@@ -0,0 +1,37 @@
+package scheduler
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler(t *testing.T) {
+	var runs, failures int32
+
+	s := New()
+	s.Every(10*time.Millisecond, "count", func() error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+	s.Every(10*time.Millisecond, "fail", func() error {
+		atomic.AddInt32(&failures, 1)
+		return errors.New("failed")
+	})
+
+	s.Start()
+	time.Sleep(55 * time.Millisecond)
+	s.Stop()
+
+	stopped := atomic.LoadInt32(&runs)
+	assert.True(t, stopped >= 2)
+	// failing jobs keep running
+	assert.True(t, atomic.LoadInt32(&failures) >= 2)
+
+	// no runs after stop
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt32(&runs))
+}
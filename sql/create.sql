@@ -28,7 +28,29 @@ CREATE TABLE app_users (
     admin BOOLEAN DEFAULT false
 );
 
--- DELETE FROM app_sessions WHERE last_accessed < now()-'36 hours'::interval;
+-- totp_secret is set when enrollment starts, encrypted with the key encryption key, it is only used once totp_enabled
+-- is set. recovery_codes are the sha256 hashes of the unused recovery codes.
+CREATE TABLE app_user_mfa (
+    user_id uuid PRIMARY KEY REFERENCES app_users(id),
+    totp_secret VARCHAR NOT NULL DEFAULT '',
+    totp_enabled BOOLEAN NOT NULL DEFAULT false,
+    totp_last_step BIGINT NOT NULL DEFAULT 0,
+    recovery_codes JSONB NOT NULL DEFAULT '[]',
+    updated TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE app_user_webauthn_credentials (
+    id VARCHAR PRIMARY KEY,
+    user_id uuid NOT NULL REFERENCES app_users(id),
+    name VARCHAR NOT NULL,
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    created TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX app_user_webauthn_credentials_user_idx ON app_user_webauthn_credentials (user_id);
+
+-- expired by the scheduler: DELETE FROM app_sessions WHERE last_accessed < now()-'36 hours'::interval and expires < now();
 CREATE TABLE app_sessions (
     id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
     user_id uuid NOT NULL REFERENCES app_users(id), 
@@ -37,7 +59,10 @@ CREATE TABLE app_sessions (
     ip VARCHAR, 
     last_accessed TIMESTAMP NOT NULL DEFAULT NOW(), 
     browser VARCHAR, 
-    os VARCHAR
+    os VARCHAR,
+    refresh_token_id VARCHAR NOT NULL DEFAULT '',
+    mfa BOOLEAN NOT NULL DEFAULT false,
+    expires TIMESTAMP NOT NULL DEFAULT NOW() + interval '3 days'
 );
 
 CREATE TABLE app_projects (
@@ -48,6 +73,10 @@ CREATE TABLE app_projects (
     description VARCHAR,
     icon VARCHAR,
     user_registration BOOLEAN DEFAULT false,
+    user_verification BOOLEAN DEFAULT false,
+    access_token_lifetime INTEGER NOT NULL DEFAULT 0,
+    refresh_token_lifetime INTEGER NOT NULL DEFAULT 0,
+    mfa_required BOOLEAN NOT NULL DEFAULT false,
     created TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
@@ -68,10 +97,85 @@ CREATE TABLE project_users_real (
     read BOOLEAN DEFAULT false,
     write BOOLEAN DEFAULT false,
     role VARCHAR NOT NULL,
-    created TIMESTAMP NOT NULL DEFAULT NOW()
+    active BOOLEAN DEFAULT true,
+    attributes JSONB NOT NULL DEFAULT '{}',
+    groups JSONB NOT NULL DEFAULT '[]',
+    created TIMESTAMP NOT NULL DEFAULT NOW(),
+    email_verified BOOLEAN NOT NULL DEFAULT false
+);
+
+-- custom roles of project users and api keys, permissions are a JSON array of resource and root key permissions
+CREATE TABLE project_roles(
+  id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+  project_id uuid NOT NULL REFERENCES app_projects(id),
+  name VARCHAR NOT NULL,
+  description VARCHAR NOT NULL DEFAULT '',
+  permissions JSONB NOT NULL DEFAULT '[]',
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  UNIQUE(project_id, name)
+);
+
+-- verification and password reset emails of project users, projects without a template use the default
+CREATE TABLE project_email_templates(
+  id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+  project_id uuid NOT NULL REFERENCES app_projects(id),
+  name VARCHAR NOT NULL,
+  subject VARCHAR NOT NULL,
+  body TEXT NOT NULL,
+  url VARCHAR,
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  UNIQUE(project_id, name)
+);
+
+CREATE TABLE signing_keys(
+  id VARCHAR PRIMARY KEY,
+  project_id uuid REFERENCES app_projects(id),
+  algorithm VARCHAR NOT NULL,
+  private_key TEXT NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  activates TIMESTAMP NOT NULL,
+  rotates TIMESTAMP NOT NULL,
+  expires TIMESTAMP NOT NULL
+);
+
+CREATE INDEX signing_keys_project_idx ON signing_keys (project_id, activates);
+
+CREATE TABLE project_identity_providers(
+  id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+  project_id uuid NOT NULL REFERENCES app_projects(id),
+  name VARCHAR NOT NULL,
+  type VARCHAR NOT NULL,
+  client_id VARCHAR NOT NULL,
+  client_secret VARCHAR NOT NULL,
+  issuer VARCHAR,
+  authorization_url VARCHAR NOT NULL,
+  token_url VARCHAR NOT NULL,
+  userinfo_url VARCHAR,
+  scopes TEXT[],
+  redirect_urls TEXT[],
+  provision BOOLEAN DEFAULT false,
+  read BOOLEAN DEFAULT false,
+  write BOOLEAN DEFAULT false,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  UNIQUE(project_id, name)
 );
 
--- DELETE FROM project_sessions WHERE last_accessed < now()-'36 hours'::interval;
+CREATE TABLE project_user_identities(
+  id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+  project_id uuid NOT NULL REFERENCES app_projects(id),
+  provider VARCHAR NOT NULL,
+  subject VARCHAR NOT NULL,
+  user_id uuid NOT NULL,
+  email VARCHAR,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  UNIQUE(project_id, provider, subject)
+);
+
+-- expired by the scheduler: DELETE FROM project_sessions WHERE last_accessed < now()-'36 hours'::interval and expires < now();
 CREATE TABLE project_sessions_real (
     id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
     user_id uuid NOT NULL REFERENCES project_users_real(id),
@@ -81,10 +185,12 @@ CREATE TABLE project_sessions_real (
     ip VARCHAR, 
     last_accessed TIMESTAMP NOT NULL DEFAULT NOW(), 
     browser VARCHAR, 
-    os VARCHAR
+    os VARCHAR,
+    refresh_token_id VARCHAR NOT NULL DEFAULT '',
+    expires TIMESTAMP NOT NULL DEFAULT NOW() + interval '3 days'
 );
 
--- DELETE FROM project_logs WHERE created < now()-'24 hours'::interval;
+-- expired by the scheduler: DELETE FROM project_logs WHERE created < now()-'24 hours'::interval;
 CREATE TABLE project_logs_real (
     id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
     project_id uuid NOT NULL REFERENCES app_projects(id),
@@ -104,14 +210,22 @@ CREATE TABLE project_logs_real (
 CREATE TABLE project_apikeys_real (
     id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
     project_id uuid NOT NULL REFERENCES app_projects(id), 
+    key_id VARCHAR,
     key_hash VARCHAR NOT NULL,
     description VARCHAR,
     read BOOLEAN DEFAULT false,
     write BOOLEAN DEFAULT false,
     role VARCHAR NOT NULL,
+    scopes JSONB NOT NULL DEFAULT '[]',
+    allowed_ips TEXT[] NOT NULL DEFAULT '{}',
+    expires TIMESTAMP,
+    last_used TIMESTAMP,
     created TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
+-- prefixed keys are looked up by the key id embedded in the key, legacy keys have no key id
+CREATE UNIQUE INDEX project_apikeys_key_id ON project_apikeys_real (project_id, key_id);
+
 CREATE TABLE project_resource_definitions_real (
     id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
     project_id uuid NOT NULL REFERENCES app_projects(id),
@@ -125,6 +239,7 @@ CREATE TABLE project_resource_definitions_real (
     "delete" BOOLEAN DEFAULT false,
     schema JSONB,
     created TIMESTAMP NOT NULL DEFAULT NOW(),
+    ttl JSONB,
 
     UNIQUE(project_id, path_name)
 );
@@ -140,6 +255,22 @@ CREATE TABLE project_resource_objects_real (
 );
 CREATE INDEX project_resource_objects_idx ON project_resource_objects_real (project_id, resource_path);
 CREATE INDEX project_resource_objects_creator_idx ON project_resource_objects_real (project_id, resource_path, creator);
+CREATE INDEX project_resource_objects_created_idx ON project_resource_objects_real (project_id, resource_path, created);
+
+-- documents shared with project users and groups beyond their creator, the principal is a user id or group name
+CREATE TABLE project_document_acls(
+  project_id uuid NOT NULL REFERENCES app_projects(id),
+  resource_path VARCHAR NOT NULL,
+  document_id uuid NOT NULL,
+  principal_type VARCHAR NOT NULL,
+  principal_id VARCHAR NOT NULL,
+  read BOOLEAN NOT NULL DEFAULT false,
+  write BOOLEAN NOT NULL DEFAULT false,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  PRIMARY KEY(project_id, document_id, principal_type, principal_id)
+);
+CREATE INDEX project_document_acls_principal_idx ON project_document_acls (project_id, resource_path, principal_type, principal_id);
 
 CREATE TABLE project_json_real(
   id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
@@ -150,13 +281,33 @@ CREATE TABLE project_json_real(
   "update" BOOLEAN DEFAULT false,
   "delete" BOOLEAN DEFAULT false,
   data JSONB,
+  schema JSONB,
+  rules JSONB,
+  history JSONB,
 
   UNIQUE(project_id, root_key)
 );
 CREATE INDEX project_json_idx ON project_json_real (project_id, root_key);
 
+CREATE TABLE project_json_versions(
+  id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+  project_id uuid NOT NULL REFERENCES app_projects(id),
+  root_key VARCHAR NOT NULL,
+  version BIGINT NOT NULL,
+  action VARCHAR NOT NULL,
+  keys TEXT[],
+  previous JSONB,
+  value JSONB,
+  creator VARCHAR,
+  creator_type VARCHAR,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  UNIQUE(project_id, root_key, version)
+);
+
 CREATE TYPE hook_type AS ENUM ('create', 'edit', 'delete');
 CREATE TYPE entity_type AS ENUM ('resource', 'json');
+CREATE TYPE hook_phase AS ENUM ('before', 'after');
 CREATE TABLE project_webhooks_real(
   id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
   project_id uuid NOT NULL REFERENCES app_projects(id),
@@ -166,10 +317,13 @@ CREATE TABLE project_webhooks_real(
   entity_id uuid NOT NULL,
   hook_event hook_type,
   headers JSONB,
-  hook_url VARCHAR NOT NULL
+  hook_url VARCHAR NOT NULL,
+  phase hook_phase NOT NULL DEFAULT 'after',
+  timeout_ms INT NOT NULL DEFAULT 2000,
+  fail_open BOOLEAN NOT NULL DEFAULT false
 );
 
--- DELETE FROM project_webhook_results WHERE created < now()-'2 hours'::interval;
+-- expired by the scheduler: DELETE FROM project_webhook_results WHERE created < now()-'2 hours'::interval;
 CREATE TABLE project_webhook_results_real(
   id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
   project_id uuid NOT NULL REFERENCES app_projects(id),
@@ -180,6 +334,27 @@ CREATE TABLE project_webhook_results_real(
   created TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
+-- events are written in the same transaction as the document/json change, then relayed to the hook queue
+-- outbox events are claimed until they are published or have been attempted max_attempts times, after which they are
+-- dead lettered and kept for inspection
+CREATE TABLE project_event_outbox(
+  id uuid DEFAULT uuid_generate_v4() PRIMARY KEY,
+  project_id uuid NOT NULL REFERENCES app_projects(id),
+  entity entity_type NOT NULL,
+  entity_key VARCHAR NOT NULL,
+  entity_id uuid NOT NULL,
+  action hook_type NOT NULL,
+  keys VARCHAR[],
+  payload JSONB,
+  attempts INT NOT NULL DEFAULT 0,
+  max_attempts INT NOT NULL DEFAULT 10,
+  locked_until TIMESTAMP,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  published TIMESTAMP,
+  dead_lettered TIMESTAMP
+);
+CREATE INDEX project_event_outbox_pending_idx ON project_event_outbox (created) WHERE published IS NULL AND dead_lettered IS NULL;
+
 /* PARTITIONING */
 
 CREATE OR REPLACE FUNCTION create_partition_and_insert() RETURNS trigger AS
@@ -190,7 +365,8 @@ CREATE OR REPLACE FUNCTION create_partition_and_insert() RETURNS trigger AS
       partition := TG_RELNAME || '_' || MD5(NEW.project_id::VARCHAR);
       IF NOT EXISTS(SELECT relname FROM pg_class WHERE relname=partition) THEN
         RAISE NOTICE 'A partition has been created %',partition;
-        EXECUTE 'CREATE TABLE ' || partition || ' (check (project_id = ''' || NEW.project_id || ''')) INHERITS (' || TG_RELNAME || '_real' || ');';
+        -- indexes are not inherited, they are copied so unique indexes also apply to the rows of the partition
+        EXECUTE 'CREATE TABLE ' || partition || ' (LIKE ' || TG_RELNAME || '_real' || ' INCLUDING INDEXES, check (project_id = ''' || NEW.project_id || ''')) INHERITS (' || TG_RELNAME || '_real' || ');';
       END IF;
       EXECUTE 'INSERT INTO ' || partition || ' SELECT(' || TG_RELNAME || ' ' || quote_literal(NEW) || ').* RETURNING id;';
       RETURN NEW;
@@ -199,6 +375,19 @@ CREATE OR REPLACE FUNCTION create_partition_and_insert() RETURNS trigger AS
 LANGUAGE plpgsql VOLATILE
 COST 100;
 
+/* TTL */
+
+/* safe_timestamptz casts a document value to a timestamp, invalid values are NULL */
+CREATE OR REPLACE FUNCTION safe_timestamptz(value TEXT) RETURNS TIMESTAMPTZ AS
+  $BODY$
+    BEGIN
+      RETURN value::TIMESTAMPTZ;
+    EXCEPTION WHEN others THEN
+      RETURN NULL;
+    END;
+  $BODY$
+LANGUAGE plpgsql STABLE;
+
 /* project_resource_definitions */
 CREATE view project_resource_definitions as select * from project_resource_definitions_real;
 ALTER view project_resource_definitions ALTER column id set DEFAULT uuid_generate_v4();
@@ -227,6 +416,8 @@ FOR EACH ROW EXECUTE PROCEDURE create_partition_and_insert();
 /* project_apikeys */
 CREATE view project_apikeys as select * from project_apikeys_real;
 ALTER view project_apikeys ALTER column id set DEFAULT uuid_generate_v4();
+ALTER view project_apikeys ALTER column scopes set DEFAULT '[]';
+ALTER view project_apikeys ALTER column allowed_ips set DEFAULT '{}';
 CREATE TRIGGER project_apikeys_insert_trigger
 INSTEAD OF INSERT ON project_apikeys
 FOR EACH ROW EXECUTE PROCEDURE create_partition_and_insert();
@@ -241,6 +432,8 @@ FOR EACH ROW EXECUTE PROCEDURE create_partition_and_insert();
 /* project_sessions */
 CREATE view project_sessions as select * from project_sessions_real;
 ALTER view project_sessions ALTER column id set DEFAULT uuid_generate_v4();
+ALTER view project_sessions ALTER column refresh_token_id set DEFAULT '';
+ALTER view project_sessions ALTER column expires set DEFAULT NOW() + interval '3 days';
 CREATE TRIGGER project_sessions_insert_trigger
 INSTEAD OF INSERT ON project_sessions
 FOR EACH ROW EXECUTE PROCEDURE create_partition_and_insert();
@@ -248,6 +441,10 @@ FOR EACH ROW EXECUTE PROCEDURE create_partition_and_insert();
 /* project_users */
 CREATE view project_users as select * from project_users_real;
 ALTER view project_users ALTER column id set DEFAULT uuid_generate_v4();
+ALTER view project_users ALTER column active set DEFAULT true;
+ALTER view project_users ALTER column attributes set DEFAULT '{}';
+ALTER view project_users ALTER column groups set DEFAULT '[]';
+ALTER view project_users ALTER column email_verified set DEFAULT false;
 CREATE TRIGGER project_users_insert_trigger
 INSTEAD OF INSERT ON project_users
 FOR EACH ROW EXECUTE PROCEDURE create_partition_and_insert();